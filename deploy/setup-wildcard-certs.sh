@@ -38,7 +38,7 @@ DRY_RUN="${DRY_RUN:-0}"
 # moons validate at moon.<parent>.latency.space. Regenerate with:
 #   curl -s https://latency.space/api/status-data \
 #     | python3 -c 'import sys,json;o=[x for v in json.load(sys.stdin)["objects"].values() for x in v];print(*sorted({m["parentName"].lower().replace(" ","-") for m in o if m.get("type")=="moon" and m.get("parentName")}))'
-PARENTS=(earth jupiter mars neptune pluto saturn uranus)
+PARENTS=(didymos earth jupiter mars neptune pluto saturn uranus)
 
 if [ -z "${CLOUDFLARE_API_TOKEN:-}" ]; then
   echo "ERROR: CLOUDFLARE_API_TOKEN is not set (needs Zone:DNS:Edit on latency.space)." >&2
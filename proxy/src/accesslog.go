@@ -0,0 +1,412 @@
+// accesslog.go - on-disk access log in extended Common Log Format.
+//
+// -access-log writes one line per HTTP request and per SOCKS or TCP-forward
+// session, for GoAccess, awstats and friends, apart from the debug output on
+// stdout. Each line is CLF followed by four latency.space fields:
+//
+//	host - - [time] "request" status bytes body latency_ms upstream_ms outcome
+//
+// body is the body's host label (mars), latency_ms the one-way light time
+// applied, upstream_ms the time to connect to the destination and outcome
+// one of the /_debug/recent outcomes; "-" where a field doesn't apply. SOCKS
+// sessions log a pseudo request line such as "CONNECT example.com:443
+// SOCKS5", with the status derived from the outcome.
+//
+// Handlers only queue the line. A single goroutine writes it, so a slow
+// disk never stalls a request; when the queue is full the line is dropped
+// and counted in access_log_dropped_total. The file is rotated when it
+// passes -access-log-max-size or -access-log-max-age, and reopened on
+// SIGUSR1 for external logrotate.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// accessLogQueue is the number of lines waiting for the writer before new
+// ones are dropped.
+const accessLogQueue = 4096
+
+// accessLogDropped counts lines dropped because the queue was full or the
+// file could not be written.
+var accessLogDropped atomic.Int64
+
+// clfTime is the Common Log Format timestamp layout.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// AccessEntry is one access log line.
+type AccessEntry struct {
+	Time     time.Time
+	Host     string // client IP
+	Request  string // "GET /path HTTP/1.1" or a SOCKS pseudo request
+	Status   int
+	Bytes    int64  // sent to the client
+	Body     string // celestial body, "" if none
+	Latency  time.Duration
+	Upstream time.Duration
+	Outcome  string
+}
+
+// format renders e as an extended CLF line, newline included.
+func (e AccessEntry) format() []byte {
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	body := "-"
+	if e.Body != "" {
+		body = FormatDomainName(e.Body)
+	}
+	return []byte(fmt.Sprintf("%s - - [%s] \"%s\" %d %s %s %s %s %s\n",
+		orDash(e.Host), e.Time.Format(clfTime), clfEscape(e.Request), e.Status, bytes,
+		body, accessMillis(e.Latency), accessMillis(e.Upstream), orDash(e.Outcome)))
+}
+
+// accessMillis renders d in whole milliseconds, "-" for none.
+func accessMillis(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clfEscape keeps a request line inside its quotes, as Apache does.
+var clfEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace
+
+// transactionStatus maps a session outcome to an HTTP-like status.
+func transactionStatus(outcome string) int {
+	switch outcome {
+	case outcomeOK:
+		return http.StatusOK
+	case outcomeDenied:
+		return http.StatusForbidden
+	case outcomeOccluded, outcomeNoContact, outcomeNoStation:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// httpOutcome maps an HTTP status to an outcome.
+func httpOutcome(status int) string {
+	switch {
+	case status < 400:
+		return outcomeOK
+	case status == http.StatusForbidden || status == http.StatusTooManyRequests:
+		return outcomeDenied
+	default:
+		return outcomeError
+	}
+}
+
+// AccessLog writes access lines to a file. A nil *AccessLog is a valid
+// no-op logger, like a nil *RecentLog.
+type AccessLog struct {
+	path      string
+	maxSize   int64         // bytes; 0 = no size limit
+	maxAge    time.Duration // 0 = no age limit
+	anonymize bool
+
+	lines  chan []byte
+	reopen chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+
+	// Owned by the writer goroutine.
+	f      *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+}
+
+// OpenAccessLog opens (appending to) the log at path and starts its writer.
+// maxSize and maxAge bound the file before it is rotated; zero disables
+// either. anonymize truncates client IPs as -anonymize-ips does.
+func OpenAccessLog(path string, maxSize int64, maxAge time.Duration, anonymize bool) (*AccessLog, error) {
+	a := &AccessLog{
+		path:      path,
+		maxSize:   maxSize,
+		maxAge:    maxAge,
+		anonymize: anonymize,
+		lines:     make(chan []byte, accessLogQueue),
+		reopen:    make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("access log: %v", err)
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	go a.run()
+	return a, nil
+}
+
+// Log queues e without blocking.
+func (a *AccessLog) Log(e AccessEntry) {
+	if a == nil {
+		return
+	}
+	if a.anonymize {
+		e.Host = anonymizeIP(e.Host)
+	}
+	select {
+	case <-a.stop:
+		return
+	default:
+	}
+	select {
+	case a.lines <- e.format():
+	default:
+		accessLogDropped.Add(1)
+	}
+}
+
+// LogTransaction logs a SOCKS or TCP-forward session.
+func (a *AccessLog) LogTransaction(tx RecentTransaction) {
+	if a == nil {
+		return
+	}
+	method, proto := "CONNECT", "SOCKS5"
+	switch tx.Protocol {
+	case "socks-udp":
+		method = "UDP-ASSOCIATE"
+	case "tcpforward":
+		proto = "TCP"
+	}
+	a.Log(AccessEntry{
+		Time:     tx.Time,
+		Host:     tx.ClientIP,
+		Request:  method + " " + orDash(tx.Target) + " " + proto,
+		Status:   transactionStatus(tx.Outcome),
+		Bytes:    tx.BytesOut,
+		Body:     tx.Body,
+		Latency:  tx.Latency,
+		Upstream: tx.Upstream,
+		Outcome:  tx.Outcome,
+	})
+}
+
+// Reopen closes and reopens the file, after logrotate has moved it.
+func (a *AccessLog) Reopen() {
+	if a == nil {
+		return
+	}
+	select {
+	case a.reopen <- struct{}{}:
+	default: // one is already pending
+	}
+}
+
+// Close writes the queued lines and closes the file.
+func (a *AccessLog) Close() {
+	if a == nil {
+		return
+	}
+	a.once.Do(func() { close(a.stop) })
+	<-a.done
+}
+
+func (a *AccessLog) run() {
+	defer close(a.done)
+	for {
+		select {
+		case line := <-a.lines:
+			a.write(line)
+		case <-a.reopen:
+			a.closeFile()
+			if err := a.open(); err != nil {
+				log.Printf("Access log: %v", err)
+			}
+		case <-a.stop:
+			for {
+				select {
+				case line := <-a.lines:
+					a.write(line)
+				default:
+					a.closeFile()
+					return
+				}
+			}
+		}
+	}
+}
+
+// write appends line, rotating first if the file is due, and flushes once
+// the queue is empty.
+func (a *AccessLog) write(line []byte) {
+	if a.f != nil && a.due(len(line)) {
+		if err := a.rotate(); err != nil {
+			log.Printf("Access log: %v", err)
+		}
+	}
+	if a.f == nil {
+		accessLogDropped.Add(1)
+		return
+	}
+	n, err := a.w.Write(line)
+	a.size += int64(n)
+	if err == nil && len(a.lines) == 0 {
+		err = a.w.Flush()
+	}
+	if err != nil {
+		accessLogDropped.Add(1)
+		log.Printf("Access log: %v", err)
+	}
+}
+
+// due reports whether the file must be rotated before writing n bytes. A
+// line longer than maxSize still goes into an empty file.
+func (a *AccessLog) due(n int) bool {
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(n) > a.maxSize {
+		return true
+	}
+	return a.maxAge > 0 && time.Since(a.opened) >= a.maxAge
+}
+
+// rotate moves the file aside with a timestamp suffix and starts a new one.
+func (a *AccessLog) rotate() error {
+	a.closeFile()
+	stamp := time.Now().UTC().Format("20060102T150405")
+	name := a.path + "." + stamp
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s.%s.%d", a.path, stamp, i)
+	}
+	if err := os.Rename(a.path, name); err != nil && !os.IsNotExist(err) {
+		a.open() // keep logging to the old file
+		return fmt.Errorf("rotate %s: %v", a.path, err)
+	}
+	return a.open()
+}
+
+func (a *AccessLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("access log: %v", err)
+	}
+	a.f, a.w, a.size, a.opened = f, bufio.NewWriter(f), 0, time.Now()
+	if fi, err := f.Stat(); err == nil {
+		a.size = fi.Size()
+	}
+	return nil
+}
+
+func (a *AccessLog) closeFile() {
+	if a.f == nil {
+		return
+	}
+	if err := a.w.Flush(); err != nil {
+		log.Printf("Access log: %v", err)
+	}
+	a.f.Close()
+	a.f, a.w = nil, nil
+}
+
+// accessResponseWriter records the status and size of a response. It
+// passes Flush through for the status stream, and Unwrap for
+// http.ResponseController.
+type accessResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withAccessLog logs each request next serves to s.access.
+func (s *Server) withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.access == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := s.now()
+		aw := &accessResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+		s.access.Log(AccessEntry{
+			Time:    start,
+			Host:    s.requestClientIP(r),
+			Request: r.Method + " " + loggedURI(r.URL) + " " + r.Proto,
+			Status:  aw.status,
+			Bytes:   aw.bytes,
+			Body:    s.resolveCelestialHost(getCelestialObjects(), r.Host),
+			Outcome: httpOutcome(aw.status),
+		})
+	})
+}
+
+// loggedURI is u's request URI with any token parameter's value replaced,
+// so an admin token sent in the query by mistake stays out of the log.
+func loggedURI(u *url.URL) string {
+	q := u.Query()
+	if !q.Has("token") {
+		return u.RequestURI()
+	}
+	for i := range q["token"] {
+		q["token"][i] = "REDACTED"
+	}
+	redacted := *u
+	redacted.RawQuery = q.Encode()
+	return redacted.RequestURI()
+}
+
+// accessLogMetrics exports the dropped line count. It is process-wide, so
+// only the registered collector publishes it.
+func accessLogMetrics() prometheus.Collector {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "access_log_dropped_total",
+		Help: "Access log lines dropped because the writer fell behind or failed",
+	}, func() float64 { return float64(accessLogDropped.Load()) })
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// accessLineRE parses an extended CLF line.
+var accessLineRE = regexp.MustCompile(`^(\S+) - - \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) (\d+|-) (\S+) (\d+|-) (\d+|-) (\S+)$`)
+
+type parsedAccessLine struct {
+	host, request, body, latency, upstream, outcome string
+	time                                            time.Time
+	status                                          int
+	bytes                                           string
+}
+
+func parseAccessLine(t *testing.T, line string) parsedAccessLine {
+	t.Helper()
+	m := accessLineRE.FindStringSubmatch(line)
+	if m == nil {
+		t.Fatalf("not an access line: %q", line)
+	}
+	when, err := time.Parse(clfTime, m[2])
+	if err != nil {
+		t.Fatalf("%q: %v", line, err)
+	}
+	status, _ := strconv.Atoi(m[4])
+	return parsedAccessLine{host: m[1], time: when, request: m[3], status: status, bytes: m[5],
+		body: m[6], latency: m[7], upstream: m[8], outcome: m[9]}
+}
+
+// readAccessLines returns the lines of the files matching pattern.
+func readAccessLines(t *testing.T, pattern string) []string {
+	t.Helper()
+	files, _ := filepath.Glob(pattern)
+	var lines []string
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")...)
+	}
+	return lines
+}
+
+func TestAccessEntryFormat(t *testing.T) {
+	when := time.Date(2026, 10, 19, 12, 0, 0, 0, time.FixedZone("", -7*3600))
+	e := AccessEntry{Time: when, Host: "203.0.113.5", Request: `GET /a"b HTTP/1.1`, Status: 200, Bytes: 1234,
+		Body: "Voyager 1", Latency: 81 * time.Second, Upstream: 12 * time.Millisecond, Outcome: outcomeOK}
+	want := `203.0.113.5 - - [19/Oct/2026:12:00:00 -0700] "GET /a\"b HTTP/1.1" 200 1234 voyager-1 81000 12 ok` + "\n"
+	if got := string(e.format()); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	p := parseAccessLine(t, strings.TrimSuffix(want, "\n"))
+	if !p.time.Equal(when) || p.body != "voyager-1" {
+		t.Errorf("parsed %+v", p)
+	}
+
+	got := string(AccessEntry{Time: when, Request: "CONNECT - SOCKS5", Status: 502}.format())
+	if want := `- - - [19/Oct/2026:12:00:00 -0700] "CONNECT - SOCKS5" 502 - - - - -` + "\n"; got != want {
+		t.Errorf("empty fields: got %q, want %q", got, want)
+	}
+}
+
+// TestAccessLogConcurrent logs from many goroutines and checks every line
+// arrives whole.
+func TestAccessLogConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "access.log")
+	a, err := OpenAccessLog(path, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const writers, each = 8, 200
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				a.Log(AccessEntry{Time: time.Now(), Host: "192.0.2.1", Request: fmt.Sprintf("GET /%d/%d HTTP/1.1", i, j),
+					Status: 200, Bytes: int64(j), Body: "Mars", Latency: time.Second, Outcome: outcomeOK})
+			}
+		}(i)
+	}
+	wg.Wait()
+	a.Close()
+	a.Log(AccessEntry{Request: "after close"}) // ignored, not a panic
+
+	lines := readAccessLines(t, path)
+	if len(lines) != writers*each {
+		t.Fatalf("%d lines, want %d", len(lines), writers*each)
+	}
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		p := parseAccessLine(t, line)
+		seen[p.request] = true
+		if p.body != "mars" || p.latency != "1000" || p.outcome != outcomeOK {
+			t.Fatalf("line %q", line)
+		}
+	}
+	if len(seen) != writers*each {
+		t.Errorf("%d distinct requests, want %d", len(seen), writers*each)
+	}
+}
+
+func TestAccessLogOverflow(t *testing.T) {
+	// No writer: the second line finds the queue full.
+	a := &AccessLog{lines: make(chan []byte, 1), stop: make(chan struct{})}
+	before := accessLogDropped.Load()
+	a.Log(AccessEntry{Request: "one"})
+	a.Log(AccessEntry{Request: "two"})
+	if got := accessLogDropped.Load() - before; got != 1 {
+		t.Errorf("dropped %d, want 1", got)
+	}
+	var nilLog *AccessLog
+	nilLog.Log(AccessEntry{})
+	nilLog.LogTransaction(RecentTransaction{})
+	nilLog.Reopen()
+	nilLog.Close()
+}
+
+func TestAccessLogRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	entry := AccessEntry{Time: time.Now(), Host: "192.0.2.1", Request: "GET / HTTP/1.1", Status: 200, Outcome: outcomeOK}
+	lineLen := int64(len(entry.format()))
+
+	t.Run("size", func(t *testing.T) {
+		a, err := OpenAccessLog(path, 3*lineLen, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			a.Log(entry)
+		}
+		a.Close()
+		rotated, _ := filepath.Glob(path + ".*")
+		if len(rotated) != 3 {
+			t.Errorf("rotated files %v, want 3 (10 lines, 3 per file)", rotated)
+		}
+		for _, name := range append(rotated, path) {
+			if fi, err := os.Stat(name); err != nil || fi.Size() > 3*lineLen {
+				t.Errorf("%s: %v, size over the limit", name, err)
+			}
+		}
+		if lines := readAccessLines(t, path+"*"); len(lines) != 10 {
+			t.Errorf("%d lines across files, want 10", len(lines))
+		}
+	})
+
+	t.Run("age", func(t *testing.T) {
+		agePath := filepath.Join(dir, "age.log")
+		a, err := OpenAccessLog(agePath, 0, 20*time.Millisecond, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Log(entry)
+		time.Sleep(40 * time.Millisecond)
+		a.Log(entry)
+		a.Close()
+		if rotated, _ := filepath.Glob(agePath + ".*"); len(rotated) != 1 {
+			t.Errorf("rotated files %v, want 1", rotated)
+		}
+	})
+
+	t.Run("reopen", func(t *testing.T) {
+		reopenPath := filepath.Join(dir, "reopen.log")
+		a, err := OpenAccessLog(reopenPath, 0, 0, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer a.Close()
+		a.Log(entry)
+		// logrotate moves the file, then signals.
+		waitFor(t, func() bool { fi, err := os.Stat(reopenPath); return err == nil && fi.Size() > 0 })
+		if err := os.Rename(reopenPath, reopenPath+".1"); err != nil {
+			t.Fatal(err)
+		}
+		a.Reopen()
+		waitFor(t, func() bool { _, err := os.Stat(reopenPath); return err == nil })
+		a.Log(entry)
+		a.Close()
+		lines := readAccessLines(t, reopenPath)
+		if len(lines) != 1 || parseAccessLine(t, lines[0]).host != "192.0.2.0" {
+			t.Errorf("reopened file %q, want one anonymized line", lines)
+		}
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
+
+// TestAccessLogSessions checks an HTTP request and a SOCKS session each
+// leave a line.
+func TestAccessLogSessions(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+
+	path := filepath.Join(t.TempDir(), "access.log")
+	a, err := OpenAccessLog(path, 0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{security: newTestSecurity(), metrics: NewRecordingMetrics(), limiter: NewRateLimiter(60, 5, 3, 0),
+		fixedCelestialBody: "Mars", access: a, timing: fixedLatency(5 * time.Millisecond)}
+
+	srv := httptest.NewServer(s.withAccessLog(http.HandlerFunc(s.handleHTTP)))
+	defer srv.Close()
+	req, _ := http.NewRequest("GET", srv.URL+"/api/bodies?x=1", nil)
+	req.Host = "mars.latency.space"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	echo := startEchoServer(t)
+	proxy := startTestSOCKS(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, _, err := client.Dial(ctx, proxy, echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("ping"))
+	io.ReadFull(conn, make([]byte, 4))
+	conn.Close()
+
+	// The SOCKS line is written when the session ends.
+	waitFor(t, func() bool {
+		data, _ := os.ReadFile(path)
+		return strings.Count(string(data), "\n") >= 2
+	})
+	a.Close()
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	var lines []parsedAccessLine
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		lines = append(lines, parseAccessLine(t, sc.Text()))
+	}
+	if len(lines) != 2 {
+		t.Fatalf("%d lines, want 2", len(lines))
+	}
+	h := lines[0]
+	if h.host != "127.0.0.1" || h.request != "GET /api/bodies?x=1 HTTP/1.1" || h.status != 200 ||
+		h.bytes != strconv.Itoa(len(body)) || h.body != "mars" || h.outcome != outcomeOK {
+		t.Errorf("HTTP line %+v", h)
+	}
+	c := lines[1]
+	if c.request != "CONNECT "+echo.String()+" SOCKS5" || c.status != 200 || c.body != "mars" ||
+		c.latency != "5" || c.upstream == "" || c.outcome != outcomeOK {
+		t.Errorf("SOCKS line %+v", c)
+	}
+}
+
+// TestLoggedURIRedactsToken checks an admin token sent in the query never
+// reaches the access log, and other queries are logged as sent.
+func TestLoggedURIRedactsToken(t *testing.T) {
+	for raw, want := range map[string]string{
+		"/_debug/recent?token=sekrit&format=text": "/_debug/recent?format=text&token=REDACTED",
+		"/_debug/recent?token=a&token=b":          "/_debug/recent?token=REDACTED&token=REDACTED",
+		"/api/bodies?x=1&b=2":                     "/api/bodies?x=1&b=2",
+		"/api/bodies":                             "/api/bodies",
+	} {
+		u, err := url.ParseRequestURI(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := loggedURI(u); got != want {
+			t.Errorf("%s logged as %s, want %s", raw, got, want)
+		}
+	}
+}
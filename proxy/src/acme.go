@@ -0,0 +1,90 @@
+// acme.go - answering ACME HTTP-01 challenges on port 80.
+//
+// A certificate authority checks an HTTP-01 challenge by fetching
+// http://<host>/.well-known/acme-challenge/<token>. When the proxy owns port
+// 80 that request would otherwise be treated as celestial traffic: delayed by
+// the body's light time or refused outright. handleHTTP hands every challenge
+// path to serveACMEChallenge before any other routing, which answers from,
+// in order:
+//
+//   - the -acme-webroot directory, where certbot --webroot writes its tokens;
+//   - the -acme-responder address, e.g. certbot --standalone moved to
+//     127.0.0.1:8402 with --http-01-port;
+//   - the HTTPS listener's own autocert manager.
+//
+// Anything none of them know is a plain 404.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// isACMEChallenge reports whether r is an HTTP-01 challenge request.
+func isACMEChallenge(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, acmeChallengePrefix)
+}
+
+// validACMEToken reports whether token is a single base64url segment, as
+// RFC 8555 requires; anything else can't name a file in the webroot.
+func validACMEToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, c := range token {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// newACMEResponder returns a reverse proxy to a local challenge responder
+// at addr (host:port).
+func newACMEResponder(addr string) (http.Handler, error) {
+	u, err := url.Parse("http://" + addr)
+	if err != nil || u.Host == "" || u.Port() == "" {
+		return nil, fmt.Errorf("%q: want host:port", addr)
+	}
+	return httputil.NewSingleHostReverseProxy(u), nil
+}
+
+// serveACMEChallenge answers an HTTP-01 challenge without latency, crawler
+// or destination checks.
+func (s *Server) serveACMEChallenge(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, acmeChallengePrefix)
+	if !validACMEToken(token) || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if s.acmeWebroot != "" {
+		path := filepath.Join(s.acmeWebroot, ".well-known", "acme-challenge", token)
+		if body, err := os.ReadFile(path); err == nil {
+			log.Printf("ACME: served challenge %s for %s from %s", token, r.Host, s.acmeWebroot)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write(body)
+			return
+		}
+	}
+	if s.acmeResponder != nil {
+		log.Printf("ACME: passing challenge %s for %s to the responder", token, r.Host)
+		s.acmeResponder.ServeHTTP(w, r)
+		return
+	}
+	if s.acmeAutocert != nil {
+		log.Printf("ACME: answering challenge %s for %s from autocert", token, r.Host)
+		s.acmeAutocert.ServeHTTP(w, r)
+		return
+	}
+	log.Printf("ACME: no answer for challenge %s for %s", token, r.Host)
+	http.NotFound(w, r)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestACMEChallengeFromWebroot checks a token certbot wrote to the webroot is
+// served at once on any host, even to a blocked crawler, while the rest of
+// the body host behaves as before.
+func TestACMEChallengeFromWebroot(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	webroot := t.TempDir()
+	dir := filepath.Join(webroot, ".well-known", "acme-challenge")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	const token, keyAuth = "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0", "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0.9jg46WB3rR_AHD-EBXdN7cBkH1WOu0tA3M9fm21mqTI"
+	if err := os.WriteFile(filepath.Join(dir, token), []byte(keyAuth), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), crawlers: newCrawlerBlocker("letsencrypt"),
+		acmeWebroot: webroot, timing: fixedLatency(time.Second)}
+	get := func(url string) (*httptest.ResponseRecorder, time.Duration) {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Let's Encrypt validation server; +https://www.letsencrypt.org)")
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		s.handleHTTP(rec, req)
+		return rec, time.Since(start)
+	}
+
+	for _, host := range []string{"voyager-1.latency.space", "phobos.mars.latency.space", "latency.space", "nowhere.latency.space"} {
+		rec, elapsed := get("http://" + host + acmeChallengePrefix + token)
+		if rec.Code != http.StatusOK || rec.Body.String() != keyAuth {
+			t.Errorf("%s: %d %q", host, rec.Code, rec.Body.String())
+		}
+		if elapsed > 100*time.Millisecond {
+			t.Errorf("%s: challenge took %v; it must not be delayed", host, elapsed)
+		}
+	}
+	for _, path := range []string{"unknown-token", "..%2F..%2Fetc%2Fpasswd", "a/b", ""} {
+		if rec, _ := get("http://mars.latency.space" + acmeChallengePrefix + path); rec.Code != http.StatusNotFound {
+			t.Errorf("%q: %d, want 404", path, rec.Code)
+		}
+	}
+	if rec, _ := get("http://mars.latency.space/"); rec.Code != http.StatusForbidden {
+		t.Errorf("info page for a blocked agent: %d, want 403", rec.Code)
+	}
+}
+
+// TestACMEChallengeResponder checks challenges missing from the webroot are
+// passed to the configured local responder with their host and path.
+func TestACMEChallengeResponder(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	var gotHost, gotPath string
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost, gotPath = r.Host, r.URL.Path
+		w.Write([]byte("from-standalone"))
+	}))
+	defer responder.Close()
+
+	proxy, err := newACMEResponder(strings.TrimPrefix(responder.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(),
+		acmeWebroot: t.TempDir(), acmeResponder: proxy}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://mars.latency.space"+acmeChallengePrefix+"tok_en-1", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "from-standalone" {
+		t.Errorf("%d %q", rec.Code, rec.Body.String())
+	}
+	if gotPath != acmeChallengePrefix+"tok_en-1" {
+		t.Errorf("responder saw %s %s", gotHost, gotPath)
+	}
+
+	for _, bad := range []string{"", "localhost", "http://x:1/", "[::1"} {
+		if _, err := newACMEResponder(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// admin.go - shared guard for operator-only endpoints.
+//
+// Some debug endpoints expose client detail or change server state, so they
+// require the operator token from the ADMIN_TOKEN environment variable, as
+// "Authorization: Bearer <token>". It isn't taken from the query string,
+// which ends up in access logs and shell history. With ADMIN_TOKEN unset these
+// endpoints are disabled outright rather than left open. The /_debug ones are also limited to
+// -admin-cidrs and hidden behind a 404 (debugmux.go).
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin reports whether r carries the admin token. On failure it has
+// already written a 403 (token unset) or 401 (token missing/wrong) response.
+// The /_debug endpoints check hasAdminToken through debugMux instead, which
+// answers 404.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		http.Error(w, "Admin endpoints are disabled (ADMIN_TOKEN is not set)", http.StatusForbidden)
+		return false
+	}
+	if !s.hasAdminToken(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="latency.space admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// hasAdminToken reports whether r carries the admin token, compared in
+// constant time. It is always false with ADMIN_TOKEN unset.
+func (s *Server) hasAdminToken(r *http.Request) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	given := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.adminToken)) == 1
+}
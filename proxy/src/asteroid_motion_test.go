@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestAsteroidMeanMotion checks the bodies defined without a mean-motion
+// rate now move: over one orbital period the mean longitude advances a full
+// turn and the position returns to where it started, having been on the far
+// side of the Sun at the half period.
+func TestAsteroidMeanMotion(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	for _, name := range []string{"Ceres", "Vesta", "Pallas", "Hygiea", "Bennu", "Apophis"} {
+		obj, ok := findObjectByName(objects, name)
+		if !ok {
+			t.Fatalf("%s missing", name)
+		}
+		if obj.DL == 0 || obj.Period <= 0 {
+			t.Errorf("%s: DL %v, Period %v; both should be populated", name, obj.DL, obj.Period)
+			continue
+		}
+		period := obj.Period / celestial.DAYS_PER_CENTURY // centuries
+		if advance := obj.DL * period; math.Abs(advance-360) > 1e-9 {
+			t.Errorf("%s: mean longitude advances %.6f deg per orbit, want 360", name, advance)
+		}
+
+		const T0 = 0.26 // early 2026
+		start := calculateVSOP87Position(obj, T0)
+		half := calculateVSOP87Position(obj, T0+period/2)
+		full := calculateVSOP87Position(obj, T0+period)
+		if d := full.Subtract(start).Magnitude(); d > 1e-6*start.Magnitude() {
+			t.Errorf("%s: %.3g AU from its start after one period", name, d)
+		}
+		if cos := start.DotProduct(half) / (start.Magnitude() * half.Magnitude()); cos > -0.5 {
+			t.Errorf("%s: only %.0f deg round the Sun at the half period", name, math.Acos(cos)*180/math.Pi)
+		}
+	}
+}
+
+// TestBennuEarthDistanceVaries samples Bennu's distance over a year; a
+// near-Earth asteroid that doesn't move would stay nearly constant.
+func TestBennuEarthDistanceVaries(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+	bennu, _ := findObjectByName(objects, "Bennu")
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC); at.Year() == 2026; at = at.AddDate(0, 0, 7) {
+		d := CalculateDistance(earth, bennu, objects, at) / celestial.AU
+		lo, hi = math.Min(lo, d), math.Max(hi, d)
+	}
+	if hi-lo < 0.5 {
+		t.Errorf("Bennu's Earth distance spans %.3f-%.3f AU over 2026, want at least 0.5 AU of variation", lo, hi)
+	}
+}
+
+func TestLoadObjectsFileDerivesMeanMotion(t *testing.T) {
+	path := writeObjectsFile(t, "objects.json", `[
+		{"Name": "Psyche", "Type": "asteroid", "ParentName": "Sun", "Radius": 113, "Mass": 2.72e19, "A": 2.92, "E": 0.134}
+	]`)
+	merged, err := loadObjectsFile(path, celestial.InitSolarSystemObjects())
+	if err != nil {
+		t.Fatal(err)
+	}
+	psyche, _ := findObjectByName(merged, "Psyche")
+	wantPeriod := 365.25 * math.Pow(2.92, 1.5)
+	if math.Abs(psyche.Period-wantPeriod) > 1e-9 || math.Abs(psyche.DL*psyche.Period/36525-360) > 1e-9 {
+		t.Errorf("Psyche: Period %v DL %v, want Period %v and a full turn per period", psyche.Period, psyche.DL, wantPeriod)
+	}
+}
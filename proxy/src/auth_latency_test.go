@@ -18,13 +18,9 @@ func TestSOCKSAuthentication(t *testing.T) {
 	cleanup, _ := setupExtendedTestEnv()
 	defer cleanup()
 
-	// Enable test mode
-	testModeCleanup := setupTestMode()
-	defer testModeCleanup()
-
 	// Setup security and metrics
-	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector()
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics()
 
 	// Define test cases for authentication
 	tests := []struct {
@@ -59,7 +55,7 @@ func TestSOCKSAuthentication(t *testing.T) {
 					}
 					defer conn.Close()
 
-					handler := NewSOCKSHandler(conn, security, metrics, "")
+					handler := NewTestSOCKSHandler(conn, security, metrics)
 					handler.Handle()
 				}()
 
@@ -91,7 +87,7 @@ func TestSOCKSAuthentication(t *testing.T) {
 					}
 					defer conn.Close()
 
-					handler := NewSOCKSHandler(conn, security, metrics, "")
+					handler := NewTestSOCKSHandler(conn, security, metrics)
 					handler.Handle()
 				}()
 
@@ -123,7 +119,7 @@ func TestSOCKSAuthentication(t *testing.T) {
 					}
 					defer conn.Close()
 
-					handler := NewSOCKSHandler(conn, security, metrics, "")
+					handler := NewTestSOCKSHandler(conn, security, metrics)
 					handler.Handle()
 				}()
 
@@ -245,16 +241,9 @@ func TestSOCKSLatencyValues(t *testing.T) {
 	cleanup, _ := setupExtendedTestEnv()
 	defer cleanup()
 
-	// Need control over test mode latency values
-	originalTestMode := isTestMode.Load()
-	defer func() { isTestMode.Store(originalTestMode) }()
-
-	// We'll override the test mode latency for this test
-	isTestMode.Store(true)
-
 	// Setup security and metrics
-	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector()
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics()
 
 	// Allow localhost for testing
 	security.allowedHosts["127.0.0.1"] = true
@@ -290,10 +279,6 @@ func TestSOCKSLatencyValues(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			// Set up test mode with the specific latency for this test case
-			testCleanup := setupTestModeWithLatency(tc.latencyValue)
-			defer testCleanup()
-
 			// Start a SOCKS server
 			socksListener, err := net.Listen("tcp", "127.0.0.1:0")
 			if err != nil {
@@ -319,7 +304,9 @@ func TestSOCKSLatencyValues(t *testing.T) {
 					bodyName: tc.bodyName,
 				}
 
-				handler := NewSOCKSHandler(wrappedConn, security, metrics, "")
+				// Each case runs at its own latency.
+				handler := NewSOCKSHandler(wrappedConn, security, metrics, "", nil)
+				handler.timing = fixedLatency(tc.latencyValue)
 				handler.Handle()
 			}()
 
@@ -525,13 +512,9 @@ func TestSOCKSConnTimeouts(t *testing.T) {
 	cleanup, _ := setupExtendedTestEnv()
 	defer cleanup()
 
-	// Enable test mode
-	testModeCleanup := setupTestMode()
-	defer testModeCleanup()
-
 	// Setup security and metrics
-	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector()
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics()
 
 	// Allow localhost for testing
 	security.allowedHosts["127.0.0.1"] = true
@@ -558,7 +541,7 @@ func TestSOCKSConnTimeouts(t *testing.T) {
 				return
 			}
 
-			handler := NewSOCKSHandler(conn, security, metrics, "")
+			handler := NewTestSOCKSHandler(conn, security, metrics)
 			go handler.Handle()
 		}
 	}()
@@ -659,7 +642,7 @@ func TestSOCKSOcclusion(t *testing.T) {
 
 	// Setup security and metrics
 	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector()
+	metrics := NewRecordingMetrics()
 
 	// Allow localhost for testing
 	security.allowedHosts["127.0.0.1"] = true
@@ -703,7 +686,7 @@ func TestSOCKSOcclusion(t *testing.T) {
 				return
 			}
 
-			handler := NewSOCKSHandler(wrappedConn, security, metrics, "")
+			handler := NewSOCKSHandler(wrappedConn, security, metrics, "", nil)
 			handler.Handle()
 		}()
 
@@ -774,7 +757,7 @@ func TestSOCKSOcclusion(t *testing.T) {
 				return
 			}
 
-			handler := NewSOCKSHandler(wrappedConn, security, metrics, "")
+			handler := NewSOCKSHandler(wrappedConn, security, metrics, "", nil)
 			handler.Handle()
 		}()
 
@@ -0,0 +1,246 @@
+// bodies.go - educational text and protocol impact for each body.
+//
+//	GET /api/bodies
+//
+// Lists every body with its MOTD, facts, the protocol impact of its
+// current light time (see ProtocolImpact) and how long files take to
+// arrive from it (see transfer.go); transmitting spacecraft also get a
+// link budget (see CalculateLinkBudget) and spacecraft their uplink,
+// downlink and round-trip light times (see LightTimes); near-Earth
+// asteroids get their next close approach (closeapproach.go). The same text appears on the
+// body's info page, which shows one fact at a time, changing every
+// factRotation. MOTD and facts come from InitSolarSystemObjects and can be
+// replaced per body from -objects-file.
+package main
+
+import (
+	"math"
+	"net/http"
+	"time"
+)
+
+// factRotation is how long the info page shows each fact.
+const factRotation = time.Minute
+
+// ProtocolImpactSeconds is ProtocolImpact in JSON.
+type ProtocolImpactSeconds struct {
+	DNS          float64 `json:"dns_seconds"`
+	TCPHandshake float64 `json:"tcp_handshake_seconds"`
+	TLSHandshake float64 `json:"tls13_handshake_seconds"`
+	PageLoad     float64 `json:"page_load_seconds"`
+}
+
+// BodyInfo is one entry in /api/bodies.
+type BodyInfo struct {
+	Name           string                `json:"name"`
+	Type           string                `json:"type"`
+	Category       string                `json:"category"` // its /api/status-data key (categories.go)
+	ParentName     string                `json:"parentName,omitempty"`
+	Domain         string                `json:"domain"`
+	Latency        float64               `json:"latency_seconds"`
+	MOTD           string                `json:"motd,omitempty"`
+	Facts          []string              `json:"facts"`
+	ProtocolImpact ProtocolImpactSeconds `json:"protocol_impact"`
+	DownlinkBps    float64               `json:"downlink_bps"`
+	UplinkBps      float64               `json:"uplink_bps"`
+	TransferTime   TransferSeconds       `json:"transfer_time"`
+	LinkBudget     *LinkBudgetInfo       `json:"link_budget,omitempty"`
+	LightTimes     *LightTimesInfo       `json:"light_times,omitempty"`
+	CloseApproach  *CloseApproachInfo    `json:"next_close_approach,omitempty"`
+}
+
+// LinkBudgetInfo is a spacecraft's LinkBudget in JSON.
+type LinkBudgetInfo struct {
+	PathLossDb  float64 `json:"path_loss_db"`
+	ReceivedDbm float64 `json:"received_dbm"`
+	SNRDb       float64 `json:"snr_db"`
+	MaxRateBps  float64 `json:"max_rate_bps"`
+	Marginal    bool    `json:"link_marginal"`
+}
+
+// LightTimesInfo is a spacecraft's LinkLightTimes in JSON.
+type LightTimesInfo struct {
+	Uplink    float64 `json:"uplink_owlt_seconds"`
+	Downlink  float64 `json:"downlink_owlt_seconds"`
+	RoundTrip float64 `json:"round_trip_seconds"`
+}
+
+// BodiesResponse is the JSON returned by /api/bodies.
+type BodiesResponse struct {
+	Timestamp  time.Time  `json:"timestamp"`
+	ComputedAt time.Time  `json:"computed_at"` // the instant the latencies describe
+	Bodies     []BodyInfo `json:"bodies"`
+}
+
+// impactRow is one line of the info page's protocol impact section.
+type impactRow struct {
+	Label, Value string
+}
+
+// impactRows renders p for the info page in l.
+func impactRows(l Locale, p ProtocolImpact) []impactRow {
+	return []impactRow{
+		{l.T("impact.dns"), l.Duration(p.DNS)},
+		{l.T("impact.tcp"), l.Duration(p.TCPHandshake)},
+		{l.T("impact.tls"), l.Duration(p.TLSHandshake)},
+		{l.T("impact.page"), l.Duration(p.PageLoad)},
+	}
+}
+
+// linkBudgetRows renders b for the info page in l.
+func linkBudgetRows(l Locale, b LinkBudget) []impactRow {
+	return []impactRow{
+		{l.T("link.path_loss"), l.Number(b.PathLossDb, 1) + " dB"},
+		{l.T("link.received"), l.Number(b.ReceivedDbm, 1) + " dBm"},
+		{l.T("link.snr"), l.Number(b.SNRDb, 1) + " dB"},
+		{l.T("link.rate"), formatBitRate(roundSignificant(b.MaxRateBps, 3))},
+	}
+}
+
+// closeApproachRows renders p for the info page in l.
+func closeApproachRows(l Locale, p CloseApproach) []impactRow {
+	return []impactRow{
+		{l.T("approach.date"), p.Time.UTC().Format(l.T("format.datetime"))},
+		{l.T("approach.distance"), l.Number(math.Round(p.Distance), 0) + " km"},
+		{l.T("approach.one_way"), l.Duration(p.Latency)},
+	}
+}
+
+// lightTimeRows renders lt for the info page in l.
+func lightTimeRows(l Locale, lt LinkLightTimes) []impactRow {
+	return []impactRow{
+		{l.T("light.uplink"), l.Duration(lt.Uplink)},
+		{l.T("light.downlink"), l.Duration(lt.Downlink)},
+		{l.T("light.round_trip"), l.Duration(lt.RoundTrip)},
+	}
+}
+
+// spacecraftLightTimes is LightTimes now for a spacecraft; ok is false for
+// anything else.
+func spacecraftLightTimes(obj CelestialObject, objects []CelestialObject) (LinkLightTimes, bool) {
+	if obj.Type != "spacecraft" {
+		return LinkLightTimes{}, false
+	}
+	return LightTimes(obj, objects, simTime(time.Now()))
+}
+
+// roundSignificant rounds f to digits significant figures, so a rate reads
+// "2.39 kbps" rather than "2.3941875 kbps".
+func roundSignificant(f float64, digits int) float64 {
+	if f == 0 {
+		return 0
+	}
+	exp := digits - 1 - int(math.Floor(math.Log10(math.Abs(f))))
+	if exp >= 0 {
+		scale := math.Pow(10, float64(exp))
+		return math.Round(f*scale) / scale
+	}
+	scale := math.Pow(10, float64(-exp))
+	return math.Round(f/scale) * scale
+}
+
+// displayDuration rounds d for reading: to the second once it's a minute
+// or more, to the hundredth of a second below that.
+func displayDuration(d time.Duration) string {
+	if d >= time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return d.Round(10 * time.Millisecond).String()
+}
+
+// rotatingFact returns the fact to show at now, or "" if there are none.
+func rotatingFact(facts []string, now time.Time) string {
+	if len(facts) == 0 {
+		return ""
+	}
+	return facts[int(now.Unix()/int64(factRotation/time.Second))%len(facts)]
+}
+
+// bodyInfo describes obj at its current light time.
+func bodyInfo(obj CelestialObject) BodyInfo {
+	distance, _ := getCurrentDistance(obj.Name) // a miss is logged; listed as 0
+	latency := CalculateLatency(distance)
+	impact := CalculateProtocolImpact(latency)
+	down, up := dataRates(obj)
+	facts := obj.Facts
+	if facts == nil {
+		facts = []string{}
+	}
+	return BodyInfo{
+		Name:       obj.Name,
+		Type:       obj.Type,
+		Category:   categoryKey(obj.Type),
+		ParentName: obj.ParentName,
+		Domain:     FormatBodyDomain(obj),
+		Latency:    float64(int(latency.Seconds()*100)) / 100,
+		MOTD:       obj.MOTD,
+		Facts:      facts,
+		ProtocolImpact: ProtocolImpactSeconds{
+			DNS:          impact.DNS.Seconds(),
+			TCPHandshake: impact.TCPHandshake.Seconds(),
+			TLSHandshake: impact.TLSHandshake.Seconds(),
+			PageLoad:     impact.PageLoad.Seconds(),
+		},
+		DownlinkBps:   down,
+		UplinkBps:     up,
+		TransferTime:  transferSeconds(down, latency),
+		LinkBudget:    linkBudgetInfo(obj, distance),
+		LightTimes:    lightTimesInfo(obj),
+		CloseApproach: closeApproachInfo(obj, simTime(distanceClock())),
+	}
+}
+
+// lightTimesInfo is obj's light times for /api/bodies, or nil if it isn't
+// a spacecraft.
+func lightTimesInfo(obj CelestialObject) *LightTimesInfo {
+	lt, ok := spacecraftLightTimes(obj, getCelestialObjects())
+	if !ok {
+		return nil
+	}
+	return &LightTimesInfo{
+		Uplink:    roundedSeconds(lt.Uplink),
+		Downlink:  roundedSeconds(lt.Downlink),
+		RoundTrip: roundedSeconds(lt.RoundTrip),
+	}
+}
+
+// linkBudgetInfo is obj's link budget at distance for /api/bodies, or nil
+// if it isn't a transmitting spacecraft.
+func linkBudgetInfo(obj CelestialObject, distance float64) *LinkBudgetInfo {
+	b, ok := CalculateLinkBudget(obj, distance)
+	if !ok {
+		return nil
+	}
+	round := func(f float64) float64 { return math.Round(f*100) / 100 }
+	return &LinkBudgetInfo{
+		PathLossDb:  round(b.PathLossDb),
+		ReceivedDbm: round(b.ReceivedDbm),
+		SNRDb:       round(b.SNRDb),
+		MaxRateBps:  round(b.MaxRateBps),
+		Marginal:    b.Marginal(),
+	}
+}
+
+// handleBodies serves /api/bodies. On a group host (see groups.go) it lists
+// only that group's members, nearest first.
+func (s *Server) handleBodies(w http.ResponseWriter, r *http.Request) {
+	// Refresh the cache first, so every body is read from the table dated
+	// computed_at.
+	snap, ok := s.requestSnapshot(w, r)
+	if !ok {
+		return
+	}
+	var bodies []BodyInfo
+	if group, ok := bodyGroupForHost(r.Host); ok {
+		bodies = groupMembers(group, getCelestialObjects())
+	} else {
+		objects := getCelestialObjects()
+		bodies = make([]BodyInfo, 0, len(objects))
+		for _, obj := range objects {
+			if obj.Type != "star" {
+				bodies = append(bodies, bodyInfo(obj))
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, BodiesResponse{Timestamp: s.now().UTC(), ComputedAt: snap.ComputedAt.UTC(), Bodies: bodies})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestBodiesAPI(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/bodies", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp BodiesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]BodyInfo)
+	for _, b := range resp.Bodies {
+		byName[b.Name] = b
+	}
+	if _, ok := byName["Sun"]; ok {
+		t.Error("the Sun should not be listed")
+	}
+	mars, ok := byName["Mars"]
+	if !ok {
+		t.Fatal("Mars missing")
+	}
+	if len(mars.Facts) == 0 || mars.Domain != "mars.latency.space" {
+		t.Errorf("Mars entry: %+v", mars)
+	}
+	// TCP is 1.5 round trips of the listed one-way time.
+	if got, want := mars.ProtocolImpact.TCPHandshake, 3*mars.Latency; got < want-0.05 || got > want+0.05 {
+		t.Errorf("Mars TCP handshake %.2fs, want ~%.2fs", got, want)
+	}
+	// Voyager's 160 bps dominates: a megabyte takes ~14.5 hours beyond the light time.
+	if v := byName["Voyager 1"]; v.DownlinkBps != 160 || v.TransferTime.MB-v.Latency < 52428 || v.TransferTime.MB-v.Latency > 52430 {
+		t.Errorf("Voyager 1 transfer: %v bps, %+v", v.DownlinkBps, v.TransferTime)
+	}
+	if mars.DownlinkBps != defaultDataRate || mars.TransferTime.KB < mars.Latency {
+		t.Errorf("Mars transfer: %v bps, %+v", mars.DownlinkBps, mars.TransferTime)
+	}
+	if byName["Voyager 1"].MOTD == "" {
+		t.Error("Voyager 1 should carry its built-in MOTD")
+	}
+	if b := byName["Hygiea"]; b.Facts == nil {
+		t.Error("facts should be [] rather than null for bodies without any")
+	}
+}
+
+// TestInfoPageProtocolImpact checks the info page's educational sections,
+// with plausible numbers for Mars.
+func TestInfoPageProtocolImpact(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	calculateDistancesFromEarth(getCelestialObjects(), time.Now())
+
+	origTemplate := infoTemplate
+	defer func() { infoTemplate = origTemplate }()
+	infoTemplate = template.Must(template.ParseFiles("templates/info_page.html"))
+
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	render := func(body string) string {
+		rec := httptest.NewRecorder()
+		s.displayCelestialInfo(rec, httptest.NewRequest("GET", "/", nil), currentSnapshot(distanceClock()), body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", body, rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	page := render("Mars")
+	if !strings.Contains(page, "<h2>Protocol Impact</h2>") {
+		t.Fatal("Mars page has no protocol impact section")
+	}
+	// Mars is 3-22 light-minutes away: a TCP handshake takes 9-66 minutes.
+	m := regexp.MustCompile(`TCP three-way handshake: <strong>([^<]+)</strong>`).FindStringSubmatch(page)
+	if m == nil {
+		t.Fatal("no TCP handshake row")
+	}
+	tcp, err := parseEnglishDuration(m[1])
+	if err != nil {
+		t.Fatalf("TCP handshake %q: %v", m[1], err)
+	}
+	if tcp < 9*time.Minute || tcp > 66*time.Minute {
+		t.Errorf("Mars TCP handshake %v, want 9-66 minutes", tcp)
+	}
+	for _, row := range []string{"DNS lookup: <strong>", "TLS 1.3 connection ready: <strong>", "Web page (50 requests, 6 connections): <strong>"} {
+		if !strings.Contains(page, row) {
+			t.Errorf("Mars page missing %q", row)
+		}
+	}
+
+	if !strings.Contains(page, "<h2>Transfer Times</h2>") || !strings.Contains(page, "Mars at 1 Gbps") ||
+		!strings.Contains(page, "RAW photo (25 MB): <strong>") {
+		t.Error("Mars page has no transfer table at the default rate")
+	}
+
+	mars, _ := findObjectByName(getCelestialObjects(), "Mars")
+	shown := false
+	for _, fact := range mars.Facts {
+		if strings.Contains(page, template.HTMLEscapeString(fact)) {
+			shown = true
+		}
+	}
+	if !shown {
+		t.Error("Mars page shows none of its facts")
+	}
+	if strings.Contains(page, `class="motd"`) {
+		t.Error("Mars has no MOTD, but one was rendered")
+	}
+
+	voyager, _ := findObjectByName(getCelestialObjects(), "Voyager 1")
+	if page := render("Voyager 1"); !strings.Contains(page, template.HTMLEscapeString(voyager.MOTD)) {
+		t.Error("Voyager 1 page missing its MOTD")
+	}
+}
+
+func TestRotatingFact(t *testing.T) {
+	facts := []string{"a", "b", "c"}
+	start := time.Unix(0, 0)
+	var seen []string
+	for i := 0; i < 4; i++ {
+		seen = append(seen, rotatingFact(facts, start.Add(time.Duration(i)*factRotation)))
+	}
+	if got := strings.Join(seen, ""); got != "abca" {
+		t.Errorf("rotation gave %q, want \"abca\"", got)
+	}
+	if rotatingFact(facts, start.Add(factRotation/2)) != "a" {
+		t.Error("fact changed within one rotation period")
+	}
+	if rotatingFact(nil, start) != "" {
+		t.Error("no facts should give an empty string")
+	}
+}
+
+func TestObjectsFileFacts(t *testing.T) {
+	base := celestial.InitSolarSystemObjects()
+	mars, _ := findObjectByName(base, "Mars")
+	mars.MOTD = "Dust storm season: expect outages."
+	mars.Facts = []string{"Olympus Mons is about 22 km high."}
+	def, err := json.Marshal([]celestial.CelestialObject{mars})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := loadObjectsFile(writeObjectsFile(t, "facts.json", string(def)), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := findObjectByName(objects, "Mars")
+	if got.MOTD != mars.MOTD || len(got.Facts) != 1 || got.Facts[0] != mars.Facts[0] {
+		t.Errorf("objects file didn't override the text: MOTD %q, facts %q", got.MOTD, got.Facts)
+	}
+}
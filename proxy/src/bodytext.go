@@ -0,0 +1,72 @@
+// bodytext.go - the plain-text info page shared by the finger and Gopher
+// listeners.
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+const (
+	bodyTextVisibleStep  = 10 * time.Minute    // resolution of the next-visibility search
+	bodyTextVisibleLimit = 30 * 24 * time.Hour // how far ahead the search looks
+)
+
+// writeBodyText writes the plain-text info page for obj as "Key: value"
+// lines. finger sends it as is and Gopher as a text item.
+func writeBodyText(w io.Writer, obj celestial.CelestialObject, objects []celestial.CelestialObject, now time.Time) {
+	distance, _ := getCurrentDistance(obj.Name) // a miss is logged; shown as no latency
+	var latency time.Duration
+	if distance > 0 {
+		latency = CalculateLatency(distance)
+	}
+
+	domain := FormatBodyDomain(obj)
+
+	fmt.Fprintf(w, "Body:         %s (%s)\n", obj.Name, obj.Type)
+	fmt.Fprintf(w, "Domain:       %s\n", domain)
+	fmt.Fprintf(w, "Distance:     %s (%.0f km)\n", FormatDistance(distance), distance)
+	fmt.Fprintf(w, "One-way:      %s\n", latency.Round(time.Second))
+	fmt.Fprintf(w, "Round trip:   %s\n", (2 * latency).Round(time.Second))
+
+	earth, ok := findObjectByName(objects, "Earth")
+	if ok && !strings.EqualFold(obj.Name, "Earth") {
+		if v, occluder := IsOccluded(earth, obj, objects, now); v.Blocked() {
+			fmt.Fprintf(w, "Visibility:   Occluded by %s\n", occluder.Name)
+			if at, found := NextVisibilityChange(earth, obj, objects, now, bodyTextVisibleStep, bodyTextVisibleLimit); found {
+				fmt.Fprintf(w, "Next visible: %s (in %s)\n", at.UTC().Format(time.RFC3339), at.Sub(now).Round(time.Minute))
+			} else {
+				fmt.Fprintf(w, "Next visible: not within %d days\n", int(bodyTextVisibleLimit.Hours()/24))
+			}
+		} else if v == VisibilityDegraded {
+			fmt.Fprintf(w, "Visibility:   Degraded near %s\n", occluder.Name)
+		} else {
+			fmt.Fprintln(w, "Visibility:   Visible")
+		}
+	}
+
+	if moons := moonsOf(objects, obj.Name); len(moons) > 0 {
+		names := make([]string, len(moons))
+		for i, m := range moons {
+			names[i] = m.Name
+		}
+		fmt.Fprintf(w, "Moons:        %s\n", strings.Join(names, ", "))
+	}
+}
+
+// crlfWriter turns the "\n" line endings of the shared text renderers into
+// the CRLF that finger and Gopher clients expect.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c crlfWriter) Write(p []byte) (int, error) {
+	if _, err := c.w.Write([]byte(strings.ReplaceAll(string(p), "\n", "\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,52 @@
+// bufpool.go - pooled relay buffers.
+//
+// The SOCKS delay lines used to allocate a fresh 32KB slice for every read,
+// and each UDP association a 64KB one; with thousands of slow links that is
+// a lot of garbage for buffers that spend most of their life waiting out the
+// light delay. Buffers now come from a pool per size (relay.BufferPool) and
+// go back once their data has been written (or copied). Gets, fresh allocations and the
+// number currently out are exported as relay_buffer_* metrics, alongside
+// delay_queue_chunks, the chunks sitting in delay lines right now.
+package main
+
+import (
+	"github.com/latency-space/proxy/relay"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// udpReadBufferSize fits the largest UDP datagram.
+const udpReadBufferSize = 65535
+
+var (
+	// delayBufPool backs the TCP delay lines (relay.Pipe.CopyTCP).
+	delayBufPool = relay.ChunkBuffers
+	// udpBufPool backs the UDP relay's socket reads.
+	udpBufPool = relay.NewBufferPool(udpReadBufferSize)
+)
+
+// relayBufferMetrics exports the pool and delay-line stats. They are
+// process-wide, so only the registered collector publishes them.
+func relayBufferMetrics() []prometheus.Collector {
+	var cs []prometheus.Collector
+	for _, p := range []struct {
+		name string
+		pool *relay.BufferPool
+	}{{"delay", delayBufPool}, {"udp", udpBufPool}} {
+		labels := prometheus.Labels{"pool": p.name}
+		pool := p.pool
+		cs = append(cs,
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "relay_buffer_gets_total", Help: "Relay buffers taken from the pool", ConstLabels: labels,
+			}, func() float64 { return float64(pool.Stats().Gets) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "relay_buffer_allocs_total", Help: "Relay buffers the pool had to allocate", ConstLabels: labels,
+			}, func() float64 { return float64(pool.Stats().Allocs) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "relay_buffers_in_use", Help: "Relay buffers currently taken from the pool", ConstLabels: labels,
+			}, func() float64 { return float64(pool.Stats().InUse) }),
+		)
+	}
+	return append(cs, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "delay_queue_chunks", Help: "Chunks waiting out the light delay in SOCKS delay lines",
+	}, func() float64 { return float64(relay.Queued()) }))
+}
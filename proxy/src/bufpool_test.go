@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRelayBufferMetricsRegister(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for _, c := range relayBufferMetrics() {
+		if err := reg.Register(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 4 {
+		t.Errorf("gathered %d metric families, want 4", len(families))
+	}
+}
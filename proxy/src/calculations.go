@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,7 +18,8 @@ import (
 
 // celestialObjectsPtr holds the solar-system snapshot. Atomic because tests swap
 // it while SOCKS UDP relay goroutines may still be reading it (a data race under
-// -race); production sets it once in init() and never mutates it afterwards.
+// -race); production sets it once in main (through NewServer) and replaces it only
+// on an -objects-file reload.
 // Always access via getCelestialObjects/setCelestialObjects, never the pointer.
 var celestialObjectsPtr atomic.Pointer[[]celestial.CelestialObject]
 var DistanceCacheMutex sync.RWMutex // Exported mutex for cache access
@@ -34,32 +37,127 @@ func setCelestialObjects(objs []celestial.CelestialObject) {
 	celestialObjectsPtr.Store(&objs)
 }
 
-func init() {
-	setCelestialObjects(celestial.InitSolarSystemObjects())
-	log.Printf("Celestial objects initialized. Count: %d", len(getCelestialObjects()))
+func CalculateLatency(distanceKm float64) time.Duration {
+	seconds := distanceKm / celestial.SPEED_OF_LIGHT
+	return time.Duration(seconds * float64(time.Second))
+}
 
-	// Debug: Verify Sun is in the list
-	sunFound := false
-	for _, obj := range getCelestialObjects() {
-		if obj.Name == "Sun" {
-			sunFound = true
-			break
-		}
-	}
-	if !sunFound {
-		log.Printf("WARNING: Sun object not found in celestialObjects!")
-	}
+// Page-load model for ProtocolImpact: a typical page of 50 requests fetched
+// over 6 parallel HTTP/1.1 connections.
+const (
+	pageLoadRequests    = 50
+	pageLoadConnections = 6
+)
+
+// ProtocolImpact is how long everyday exchanges take over a link with a
+// given one-way light time, counted in round trips (RTT, twice the one-way
+// time) and ignoring bandwidth and server time:
+//
+//   - DNS: one query and its answer, 1 RTT.
+//   - TCP: SYN, SYN-ACK, ACK - the server has the final ACK after 1.5 RTT.
+//   - TLS 1.3: one RTT for TCP, then the one-RTT TLS handshake; the client can
+//     send its request after 2 RTT.
+//   - Page load: DNS, all connections opened in parallel with TLS (2 RTT),
+//     the HTML (1 RTT), then the remaining requests one per connection per
+//     RTT: 1 + 2 + 1 + ceil(49/6) = 13 RTT.
+type ProtocolImpact struct {
+	DNS          time.Duration
+	TCPHandshake time.Duration
+	TLSHandshake time.Duration
+	PageLoad     time.Duration
 }
 
-func CalculateLatency(distanceKm float64) time.Duration {
-	// Use test mode with fixed low latency if enabled
-	if isTestMode.Load() {
-		return testModeCalculateLatency(distanceKm)
+// CalculateProtocolImpact applies the ProtocolImpact model to oneWay.
+func CalculateProtocolImpact(oneWay time.Duration) ProtocolImpact {
+	rtt := 2 * oneWay
+	rounds := (pageLoadRequests - 1 + pageLoadConnections - 1) / pageLoadConnections
+	return ProtocolImpact{
+		DNS:          rtt,
+		TCPHandshake: 3 * oneWay,
+		TLSHandshake: 2 * rtt,
+		PageLoad:     time.Duration(1+2+1+rounds) * rtt,
 	}
+}
 
-	// Normal latency calculation
-	seconds := distanceKm / celestial.SPEED_OF_LIGHT
-	return time.Duration(seconds * float64(time.Second))
+// Link budget model for spacecraft downlinks (see CalculateLinkBudget). It
+// is a teaching estimate, not mission design: no pointing, atmospheric or
+// implementation losses, and the DSN dish is assumed to work at any band.
+const (
+	// dsnDishDiameterM and dsnApertureEfficiency describe the receiving
+	// antenna, one of the DSN's 70 m dishes.
+	dsnDishDiameterM      = 70.0
+	dsnApertureEfficiency = 0.6
+	// systemNoiseTempK is the receiver's system noise temperature, typical of
+	// a cryogenic front end looking at cold sky.
+	systemNoiseTempK = 20.0
+	// receiverBandwidthHz is the bandwidth the Shannon capacity is taken
+	// over. Weak links are power limited and barely notice it; it keeps
+	// strong ones to a plausible figure.
+	receiverBandwidthHz = 10e6
+	// boltzmann is Boltzmann's constant in J/K.
+	boltzmann = 1.380649e-23
+	// defaultTxPowerWatts and defaultAntennaGainDbi stand in for a
+	// spacecraft without TxPowerWatts or AntennaGainDbi of its own.
+	defaultTxPowerWatts   = 20.0
+	defaultAntennaGainDbi = 40.0
+	// marginalLinkBps is the achievable rate below which the status API
+	// marks a spacecraft's link marginal.
+	marginalLinkBps = 10.0
+)
+
+// LinkBudget is a spacecraft downlink's estimated strength at a DSN dish.
+type LinkBudget struct {
+	PathLossDb  float64 // free-space path loss
+	ReceivedDbm float64 // signal power at the receiver
+	SNRDb       float64 // signal to noise over receiverBandwidthHz
+	MaxRateBps  float64 // Shannon capacity of the link
+}
+
+// Marginal reports whether the link can carry less than marginalLinkBps.
+func (b LinkBudget) Marginal() bool {
+	return b.MaxRateBps < marginalLinkBps
+}
+
+// FreeSpacePathLoss is the loss in dB of a signal at frequencyMHz spreading
+// over distanceKm: 20 log10(4πd/λ).
+func FreeSpacePathLoss(distanceKm, frequencyMHz float64) float64 {
+	wavelengthKm := celestial.SPEED_OF_LIGHT / (frequencyMHz * 1e6)
+	return 20 * math.Log10(4*math.Pi*distanceKm/wavelengthKm)
+}
+
+// dishGainDbi is the gain of a parabolic dish: efficiency × (πD/λ)².
+func dishGainDbi(diameterM, efficiency, frequencyMHz float64) float64 {
+	wavelengthM := celestial.SPEED_OF_LIGHT * 1000 / (frequencyMHz * 1e6)
+	return 10 * math.Log10(efficiency*math.Pow(math.Pi*diameterM/wavelengthM, 2))
+}
+
+// wattsToDbm converts a power in watts to dBm.
+func wattsToDbm(w float64) float64 {
+	return 10 * math.Log10(w*1000)
+}
+
+// CalculateLinkBudget estimates obj's downlink at distanceKm: its
+// transmitter and antenna (or the defaults) through free space into a 70 m
+// DSN dish, with thermal noise at systemNoiseTempK. ok is false for bodies
+// that aren't transmitting spacecraft.
+func CalculateLinkBudget(obj celestial.CelestialObject, distanceKm float64) (budget LinkBudget, ok bool) {
+	if obj.Type != "spacecraft" || !obj.TransmitterActive || obj.FrequencyMHz <= 0 || distanceKm <= 0 {
+		return LinkBudget{}, false
+	}
+	power, gain := obj.TxPowerWatts, obj.AntennaGainDbi
+	if power <= 0 {
+		power = defaultTxPowerWatts
+	}
+	if gain <= 0 {
+		gain = defaultAntennaGainDbi
+	}
+	budget.PathLossDb = FreeSpacePathLoss(distanceKm, obj.FrequencyMHz)
+	budget.ReceivedDbm = wattsToDbm(power) + gain +
+		dishGainDbi(dsnDishDiameterM, dsnApertureEfficiency, obj.FrequencyMHz) - budget.PathLossDb
+	noiseDbm := wattsToDbm(boltzmann * systemNoiseTempK * receiverBandwidthHz)
+	budget.SNRDb = budget.ReceivedDbm - noiseDbm
+	budget.MaxRateBps = receiverBandwidthHz * math.Log2(1+math.Pow(10, budget.SNRDb/10))
+	return budget, true
 }
 
 // Convert degrees to radians
@@ -67,6 +165,11 @@ func degToRad(deg float64) float64 {
 	return deg * math.Pi / 180.0
 }
 
+// Convert radians to degrees
+func radToDeg(rad float64) float64 {
+	return rad * 180.0 / math.Pi
+}
+
 // Normalize angle to [0, 2π) radians
 func normalizeRadians(angle float64) float64 {
 	angle = math.Mod(angle, 2*math.Pi)
@@ -144,47 +247,133 @@ func centuriesSinceJ2000TDB(t time.Time) float64 {
 	return (tdbJD - celestial.J2000_EPOCH) / celestial.DAYS_PER_CENTURY
 }
 
-// Solve Kepler's equation using a high-precision algorithm
+// Solve Kepler's equation using a high-precision algorithm. Newton's method
+// from a poor start can overshoot at high eccentricity, so the root is kept
+// bracketed and a step leaving the bracket bisects it instead; Halley's
+// comet (e = 0.967) converges across the whole range of M.
 func solveKeplerEquation(M float64, e float64) float64 {
+	E, _, _ := solveKepler(M, e)
+	return normalizeRadians(E)
+}
+
+// solveKepler is solveKeplerEquation returning E in (-π, π] with its sine
+// and cosine, which the position needs too. Each step is Halley's, which
+// converges cubically (about three steps for a planet), with the same
+// bracket as a fallback.
+func solveKepler(M float64, e float64) (E, sinE, cosE float64) {
+	// Work in (-π, π]: E - e sin E - M is then bracketed by [-π, π] and
+	// has the sign of M at E = 0.
+	M = math.Remainder(M, 2*math.Pi)
+
 	// Initial estimate using Danby's starter formula
-	var E float64
+	sinM, cosM := math.Sincos(M)
 	if e < 0.8 {
-		E = M + e*math.Sin(M)*(1.0+e*math.Cos(M))
+		E = M + e*sinM*(1.0+e*cosM)
 	} else {
-		// For high eccentricity, use a better approximation
-		E = M + e*math.Sin(M)/(1.0-math.Sin(M+e)+math.Sin(M))
+		// For high eccentricity start near the apocentre side, as Danby
+		// recommends
+		E = M + 0.85*e*math.Copysign(1, sinM)
 	}
+	lo, hi := -math.Pi, math.Pi
 
-	// Refine using Newton-Raphson iterations with higher precision
-	for iter := 0; iter < 15; iter++ {
-		error := E - e*math.Sin(E) - M
-		if math.Abs(error) < 1e-14 {
-			break
+	for iter := 0; iter < 50; iter++ {
+		sinE, cosE = math.Sincos(E)
+		f := E - e*sinE - M
+		if math.Abs(f) < 1e-14 {
+			return E, sinE, cosE
+		}
+		if f < 0 {
+			lo = E
+		} else {
+			hi = E
 		}
 
-		delta := error / (1.0 - e*math.Cos(E))
-		E -= delta
-
-		// Add damping for highly eccentric orbits
-		if iter > 8 && e > 0.95 {
-			E = E*0.5 + (M+e*math.Sin(E))*0.5
+		f1 := 1.0 - e*cosE // f'
+		f2 := e * sinE     // f''
+		next := E - 2*f*f1/(2*f1*f1-f*f2)
+		if next <= lo || next >= hi {
+			next = (lo + hi) / 2
 		}
+		E = next
 	}
-
-	return normalizeRadians(E)
+	sinE, cosE = math.Sincos(E)
+	return E, sinE, cosE
 }
 
 // calculateVSOP87Position calculates planetary positions using VSOP87 algorithm
 // This is a simplified version with only the main periodic terms
 func calculateVSOP87Position(obj celestial.CelestialObject, T float64) celestial.Vector3 {
-	// Calculate the object's orbital elements at time T (centuries from J2000)
+	return elementsAt(obj, T).position()
+}
+
+// Calculate local position relative to parent body
+func calculateLocalPosition(obj celestial.CelestialObject, T float64) celestial.Vector3 {
+	return elementsAt(obj, T).position()
+}
+
+// orbitalElements are a body's osculating elements at one instant. a is in
+// km where elementsInKm, else AU; the angles are in radians.
+type orbitalElements struct {
+	a, e, i float64
+	w       float64 // argument of periapsis
+	node    float64 // longitude of the ascending node
+	M       float64 // mean anomaly
+
+	// Comets only (comets.go): perihelion distance in AU and days since
+	// perihelion, from which nearParabolic orbits are placed.
+	q, dt float64
+}
+
+// position places the body on its orbit, in the units of a.
+func (el orbitalElements) position() celestial.Vector3 {
+	x, y := el.perifocal()
+	return orbitalToReference(x, y, el.i, el.w, el.node)
+}
+
+// perifocal places the body in its orbital plane, x towards periapsis: by
+// the universal variable for a nearParabolic comet, else by Kepler's
+// equation.
+func (el orbitalElements) perifocal() (x, y float64) {
+	if el.nearParabolic() {
+		return universalPerifocal(el.q, el.e, el.dt)
+	}
+	return keplerPerifocal(el.a, el.e, el.M)
+}
+
+// hasHeliocentricElements reports whether obj is placed directly from
+// heliocentric elements with the VSOP87 perturbation terms, rather than
+// relative to a parent.
+func hasHeliocentricElements(obj celestial.CelestialObject) bool {
+	return obj.Type == "planet" || obj.Type == "dwarf_planet" || obj.Type == "asteroid"
+}
+
+// elementsAt returns obj's elements at time T (centuries from J2000), exactly
+// as the position code evaluates them: planets, dwarf planets and asteroids
+// with their perturbation terms, comets from their cometary elements, moons
+// and spacecraft unperturbed.
+func elementsAt(obj celestial.CelestialObject, T float64) orbitalElements {
+	if obj.Type == "comet" {
+		return cometElementsAt(obj, T)
+	}
 	a := obj.A + T*obj.DA
 	e := obj.E + T*obj.DE
 	i := degToRad(obj.I + T*obj.DI)
 	L := degToRad(obj.L + T*obj.DL)
-	wbar := degToRad(obj.LP + T*obj.DLP)
 	node := degToRad(obj.N + T*obj.DN)
 
+	if !hasHeliocentricElements(obj) {
+		// For objects with defined argument of perigee (moons, spacecraft)
+		if obj.W != 0 {
+			w := degToRad(obj.W + T*obj.DW)
+			return orbitalElements{a: a, e: e, i: i, w: w, node: node, M: normalizeRadians(L - (node + w))}
+		}
+		// For objects with longitude of perihelion
+		lp := degToRad(obj.LP + T*obj.DLP)
+		return orbitalElements{a: a, e: e, i: i, w: normalizeRadians(lp - node), node: node, M: normalizeRadians(L - lp)}
+	}
+
+	wbar := degToRad(obj.LP + T*obj.DLP)
+
 	// Add some important planetary perturbations for higher accuracy
 	// These are simplified forms of the major perturbation terms
 
@@ -211,123 +400,282 @@ func calculateVSOP87Position(obj celestial.CelestialObject, T float64) celestial
 		e += 0.000045 * math.Cos(degToRad(2.0*obj.B-obj.F+106.3))
 	}
 
-	// Calculate the mean anomaly
-	// M = L - wbar
-	M := normalizeRadians(L - wbar)
-
-	// Calculate the argument of perihelion
-	w := normalizeRadians(wbar - node)
-
-	// Solve Kepler's equation for the eccentric anomaly
-	E := solveKeplerEquation(M, e)
-
-	// Calculate the true anomaly
-	v := 2.0 * math.Atan2(
-		math.Sqrt(1.0+e)*math.Sin(E/2.0),
-		math.Sqrt(1.0-e)*math.Cos(E/2.0),
-	)
+	// The mean anomaly is M = L - wbar, the argument of perihelion wbar - node
+	return orbitalElements{a: a, e: e, i: i, w: normalizeRadians(wbar - node), node: node, M: normalizeRadians(L - wbar)}
+}
 
-	// Calculate the heliocentric distance (in AU)
-	r := a * (1.0 - e*math.Cos(E))
+// keplerPerifocal places a body on its elliptical orbit at mean anomaly M,
+// in its orbital plane with x towards periapsis, in the units of a. From
+// the eccentric anomaly directly: x = a(cos E - e), y = b sin E.
+func keplerPerifocal(a, e, M float64) (x, y float64) {
+	_, sinE, cosE := solveKepler(M, e)
+	return a * (cosE - e), a * math.Sqrt(1.0-e*e) * sinE
+}
 
-	// Calculate the heliocentric position in the orbital plane
-	xOrbit := r * math.Cos(v)
-	yOrbit := r * math.Sin(v)
-	zOrbit := 0.0
+// orbitalToReference rotates a point in the orbital plane into the reference
+// frame of the elements (ecliptic for planets, equatorial for moons).
+func orbitalToReference(xOrb, yOrb, i, w, node float64) celestial.Vector3 {
+	sinW, cosW := math.Sincos(w)
+	sinI, cosI := math.Sincos(i)
+	sinN, cosN := math.Sincos(node)
 
-	// Transform to the ecliptic plane
+	// First, rotate around z by argument of perihelion
+	xRef := xOrb*cosW - yOrb*sinW
+	yRef := xOrb*sinW + yOrb*cosW
 
-	// First, rotate around z by w (argument of perihelion)
-	xEclOrbit := xOrbit*math.Cos(w) - yOrbit*math.Sin(w)
-	yEclOrbit := xOrbit*math.Sin(w) + yOrbit*math.Cos(w)
-	zEclOrbit := zOrbit
+	// Next, rotate around x by inclination (the point starts with z = 0)
+	yInc := yRef * cosI
+	zInc := yRef * sinI
 
-	// Next, rotate around x by i (inclination)
-	xEcl := xEclOrbit
-	yEcl := yEclOrbit*math.Cos(i) - zEclOrbit*math.Sin(i)
-	zEcl := yEclOrbit*math.Sin(i) + zEclOrbit*math.Cos(i)
+	// Finally, rotate around z by longitude of ascending node
+	return celestial.Vector3{
+		X: xRef*cosN - yInc*sinN,
+		Y: xRef*sinN + yInc*cosN,
+		Z: zInc,
+	}
+}
 
-	// Finally, rotate around z by node (longitude of ascending node)
-	x := xEcl*math.Cos(node) - yEcl*math.Sin(node)
-	y := xEcl*math.Sin(node) + yEcl*math.Cos(node)
-	z := zEcl
+// Lunar theory.
+//
+// The two-body Kepler orbit puts the Moon up to ~20,000 km from its true
+// place: the Sun perturbs the lunar orbit strongly enough that the evection
+// (period 31.8 days), variation (14.8 days), annual equation and parallactic
+// inequality are each hundreds to thousands of km in distance. For an object
+// only ~384,400 km away that is a latency error of several percent, so the
+// Moon gets its own position model: the truncated ELP-2000/82 series from
+// Jean Meeus, "Astronomical Algorithms" (2nd ed., 1998), chapter 47, Tables
+// 47.A and 47.B. Accuracy is about 10" in longitude, 4" in latitude and well
+// under 100 km in distance.
+
+// lunarTerm is one periodic term: multiples of the Delaunay arguments D, M,
+// M', F, then the longitude (1e-6 deg) and distance (1e-3 km) coefficients.
+type lunarTerm struct {
+	d, m, mp, f int
+	l, r        float64
+}
 
-	return celestial.Vector3{X: x, Y: y, Z: z}
+// lunarLR is Meeus Table 47.A: periodic terms for longitude and distance.
+var lunarLR = []lunarTerm{
+	{0, 0, 1, 0, 6288774, -20905355}, // equation of the centre
+	{2, 0, -1, 0, 1274027, -3699111}, // evection
+	{2, 0, 0, 0, 658314, -2955968},   // variation
+	{0, 0, 2, 0, 213618, -569925},
+	{0, 1, 0, 0, -185116, 48888}, // annual equation
+	{0, 0, 0, 2, -114332, -3149},
+	{2, 0, -2, 0, 58793, 246158},
+	{2, -1, -1, 0, 57066, -152138},
+	{2, 0, 1, 0, 53322, -170733},
+	{2, -1, 0, 0, 45758, -204586},
+	{0, 1, -1, 0, -40923, -129620},
+	{1, 0, 0, 0, -34720, 108743}, // parallactic inequality
+	{0, 1, 1, 0, -30383, 104755},
+	{2, 0, 0, -2, 15327, 10321},
+	{0, 0, 1, 2, -12528, 0},
+	{0, 0, 1, -2, 10980, 79661},
+	{4, 0, -1, 0, 10675, -34782},
+	{0, 0, 3, 0, 10034, -23210},
+	{4, 0, -2, 0, 8548, -21636},
+	{2, 1, -1, 0, -7888, 24208},
+	{2, 1, 0, 0, -6766, 30824},
+	{1, 0, -1, 0, -5163, -8379},
+	{1, 1, 0, 0, 4987, -16675},
+	{2, -1, 1, 0, 4036, -12831},
+	{2, 0, 2, 0, 3994, -10445},
+	{4, 0, 0, 0, 3861, -11650},
+	{2, 0, -3, 0, 3665, 14403},
+	{0, 1, -2, 0, -2689, -7003},
+	{2, 0, -1, 2, -2602, 0},
+	{2, -1, -2, 0, 2390, 10056},
+	{1, 0, 1, 0, -2348, 6322},
+	{2, -2, 0, 0, 2236, -9884},
+	{0, 1, 2, 0, -2120, 5751},
+	{0, 2, 0, 0, -2069, 0},
+	{2, -2, -1, 0, 2048, -4950},
+	{2, 0, 1, -2, -1773, 4130},
+	{2, 0, 0, 2, -1595, 0},
+	{4, -1, -1, 0, 1215, -3958},
+	{0, 0, 2, 2, -1110, 0},
+	{3, 0, -1, 0, -892, 3258},
+	{2, 1, 1, 0, -810, 2616},
+	{4, -1, -2, 0, 759, -1897},
+	{0, 2, -1, 0, -713, -2117},
+	{2, 2, -1, 0, -700, 2354},
+	{2, 1, -2, 0, 691, 0},
+	{2, -1, 0, -2, 596, 0},
+	{4, 0, 1, 0, 549, -1423},
+	{0, 0, 4, 0, 537, -1117},
+	{4, -1, 0, 0, 520, -1571},
+	{1, 0, -2, 0, -487, -1739},
+	{2, 1, 0, -2, -399, 0},
+	{0, 0, 2, -2, -381, -4421},
+	{1, 1, 1, 0, 351, 0},
+	{3, 0, -2, 0, -340, 0},
+	{4, 0, -3, 0, 330, 0},
+	{2, -1, 2, 0, 327, 0},
+	{0, 2, 1, 0, -323, 1165},
+	{1, 1, -1, 0, 299, 0},
+	{2, 0, 3, 0, 294, 0},
+	{2, 0, -1, -2, 0, 8752},
 }
 
-// Calculate local position relative to parent body
-func calculateLocalPosition(obj celestial.CelestialObject, T float64) celestial.Vector3 {
-	// Calculate the object's orbital elements at time T
-	a := obj.A + T*obj.DA
-	e := obj.E + T*obj.DE
-	i := degToRad(obj.I + T*obj.DI)
-	L := degToRad(obj.L + T*obj.DL)
+// lunarB is Meeus Table 47.B: periodic terms for latitude (1e-6 deg, in l).
+var lunarB = []lunarTerm{
+	{0, 0, 0, 1, 5128122, 0},
+	{0, 0, 1, 1, 280602, 0},
+	{0, 0, 1, -1, 277693, 0},
+	{2, 0, 0, -1, 173237, 0},
+	{2, 0, -1, 1, 55413, 0},
+	{2, 0, -1, -1, 46271, 0},
+	{2, 0, 0, 1, 32573, 0},
+	{0, 0, 2, 1, 17198, 0},
+	{2, 0, 1, -1, 9266, 0},
+	{0, 0, 2, -1, 8822, 0},
+	{2, -1, 0, -1, 8216, 0},
+	{2, 0, -2, -1, 4324, 0},
+	{2, 0, 1, 1, 4200, 0},
+	{2, 1, 0, -1, -3359, 0},
+	{2, -1, -1, 1, 2463, 0},
+	{2, -1, 0, 1, 2211, 0},
+	{2, -1, -1, -1, 2065, 0},
+	{0, 1, -1, -1, -1870, 0},
+	{4, 0, -1, -1, 1828, 0},
+	{0, 1, 0, 1, -1794, 0},
+	{0, 0, 0, 3, -1749, 0},
+	{0, 1, -1, 1, -1565, 0},
+	{1, 0, 0, 1, -1491, 0},
+	{0, 1, 1, 1, -1475, 0},
+	{0, 1, 1, -1, -1410, 0},
+	{0, 1, 0, -1, -1344, 0},
+	{1, 0, 0, -1, -1335, 0},
+	{0, 0, 3, 1, 1107, 0},
+	{4, 0, 0, -1, 1021, 0},
+	{4, 0, -1, 1, 833, 0},
+	{0, 0, 1, -3, 777, 0},
+	{4, 0, -2, 1, 671, 0},
+	{2, 0, 0, -3, 607, 0},
+	{2, 0, 2, -1, 596, 0},
+	{2, -1, 1, -1, 491, 0},
+	{2, 0, -2, 1, -451, 0},
+	{0, 0, 3, -1, 439, 0},
+	{2, 0, 2, 1, 422, 0},
+	{2, 0, -3, -1, 421, 0},
+	{2, 1, -1, 1, -366, 0},
+	{2, 1, 0, 1, -351, 0},
+	{4, 0, 0, 1, 331, 0},
+	{2, -1, 1, 1, 315, 0},
+	{2, -2, 0, -1, 302, 0},
+	{0, 0, 1, 3, -283, 0},
+	{2, 1, 1, -1, -229, 0},
+	{1, 1, 0, -1, 223, 0},
+	{1, 1, 0, 1, 223, 0},
+	{0, 1, -2, -1, -220, 0},
+	{2, 1, -1, -1, -220, 0},
+	{1, 0, 1, 1, -185, 0},
+	{2, -1, -2, -1, 181, 0},
+	{0, 1, 2, 1, -177, 0},
+	{4, 0, -2, -1, 176, 0},
+	{4, -1, -1, -1, 166, 0},
+	{1, 0, 1, -1, -164, 0},
+	{4, 0, 1, -1, 132, 0},
+	{1, 0, -1, -1, -119, 0},
+	{4, -1, 0, -1, 115, 0},
+	{2, -2, 0, 1, 107, 0},
+}
 
-	var w, node, M float64
+// isEarthMoon reports whether obj should use the lunar theory instead of the
+// generic Kepler orbit. Other moons are untouched.
+func isEarthMoon(obj celestial.CelestialObject) bool {
+	return obj.Type == "moon" && obj.Name == "Moon" && obj.ParentName == "Earth"
+}
 
-	// For objects with defined argument of perigee (moons, spacecraft)
-	if obj.W != 0 {
-		w = degToRad(obj.W + T*obj.DW)
-		node = degToRad(obj.N + T*obj.DN)
-		// Calculate mean anomaly
-		M = normalizeRadians(L - (node + w))
-	} else {
-		// For objects with longitude of perihelion
-		lp := degToRad(obj.LP + T*obj.DLP)
-		node = degToRad(obj.N + T*obj.DN)
-		// Calculate argument of perihelion and mean anomaly
-		w = normalizeRadians(lp - node)
-		M = normalizeRadians(L - lp)
+// lunarEclipticCoords returns the Moon's geocentric ecliptic longitude and
+// latitude (degrees, mean equinox of date) and distance (km, centre to
+// centre) at T Julian centuries from J2000 (TT), per Meeus chapter 47.
+func lunarEclipticCoords(T float64) (lambda, beta, distKm float64) {
+	T2, T3, T4 := T*T, T*T*T, T*T*T*T
+
+	// Mean longitude, elongation, solar and lunar anomalies, argument of latitude.
+	Lp := 218.3164477 + 481267.88123421*T - 0.0015786*T2 + T3/538841 - T4/65194000
+	D := 297.8501921 + 445267.1114034*T - 0.0018819*T2 + T3/545868 - T4/113065000
+	M := 357.5291092 + 35999.0502909*T - 0.0001536*T2 + T3/24490000
+	Mp := 134.9633964 + 477198.8675055*T + 0.0087414*T2 + T3/69699 - T4/14712000
+	F := 93.2720950 + 483202.0175233*T - 0.0036539*T2 - T3/3526000 + T4/863310000
+
+	// Venus, Jupiter and Earth-flattening arguments.
+	A1 := 119.75 + 131.849*T
+	A2 := 53.09 + 479264.290*T
+	A3 := 313.45 + 481266.484*T
+
+	// The decreasing eccentricity of Earth's orbit scales terms containing M.
+	E := 1 - 0.002516*T - 0.0000074*T2
+	eccFactor := func(m int) float64 {
+		switch m {
+		case 1, -1:
+			return E
+		case 2, -2:
+			return E * E
+		}
+		return 1
 	}
 
-	// Solve Kepler's equation for eccentric anomaly
-	E := solveKeplerEquation(M, e)
-
-	// Calculate true anomaly
-	v := 2.0 * math.Atan2(
-		math.Sqrt(1.0+e)*math.Sin(E/2.0),
-		math.Sqrt(1.0-e)*math.Cos(E/2.0),
-	)
-
-	// Calculate distance from parent
-	r := a * (1.0 - e*math.Cos(E))
-
-	// Position in orbital plane
-	xOrb := r * math.Cos(v)
-	yOrb := r * math.Sin(v)
-	zOrb := 0.0
+	arg := func(t lunarTerm) float64 {
+		return degToRad(float64(t.d)*D + float64(t.m)*M + float64(t.mp)*Mp + float64(t.f)*F)
+	}
 
-	// Transform to reference plane (ecliptic for planets, equatorial for moons)
-	// First, rotate around z by argument of perihelion
-	xRef := xOrb*math.Cos(w) - yOrb*math.Sin(w)
-	yRef := xOrb*math.Sin(w) + yOrb*math.Cos(w)
-	zRef := zOrb
+	var sumL, sumR, sumB float64
+	for _, t := range lunarLR {
+		a, k := arg(t), eccFactor(t.m)
+		sumL += t.l * k * math.Sin(a)
+		sumR += t.r * k * math.Cos(a)
+	}
+	for _, t := range lunarB {
+		sumB += t.l * eccFactor(t.m) * math.Sin(arg(t))
+	}
 
-	// Next, rotate around x by inclination
-	xInc := xRef
-	yInc := yRef*math.Cos(i) - zRef*math.Sin(i)
-	zInc := yRef*math.Sin(i) + zRef*math.Cos(i)
+	sumL += 3958*math.Sin(degToRad(A1)) + 1962*math.Sin(degToRad(Lp-F)) + 318*math.Sin(degToRad(A2))
+	sumB += -2235*math.Sin(degToRad(Lp)) + 382*math.Sin(degToRad(A3)) +
+		175*math.Sin(degToRad(A1-F)) + 175*math.Sin(degToRad(A1+F)) +
+		127*math.Sin(degToRad(Lp-Mp)) - 115*math.Sin(degToRad(Lp+Mp))
 
-	// Finally, rotate around z by longitude of ascending node
-	x := xInc*math.Cos(node) - yInc*math.Sin(node)
-	y := xInc*math.Sin(node) + yInc*math.Cos(node)
-	z := zInc
+	lambda = math.Mod(Lp+sumL/1e6, 360)
+	if lambda < 0 {
+		lambda += 360
+	}
+	return lambda, sumB / 1e6, 385000.56 + sumR/1000
+}
 
-	return celestial.Vector3{X: x, Y: y, Z: z}
+// calculateLunarPosition returns the Moon's geocentric position in km, in the
+// J2000 ecliptic frame the planetary positions use.
+func calculateLunarPosition(T float64) celestial.Vector3 {
+	lambda, beta, dist := lunarEclipticCoords(T)
+	// Refer the longitude back from the equinox of date to J2000 (general
+	// precession, 5029.0966"/century) so directions match the other bodies.
+	lambda -= 1.396971 * T
+	l, b := degToRad(lambda), degToRad(beta)
+	return celestial.Vector3{
+		X: dist * math.Cos(b) * math.Cos(l),
+		Y: dist * math.Cos(b) * math.Sin(l),
+		Z: dist * math.Sin(b),
+	}
 }
 
 // GetObjectPosition calculates the position of an object at a given time
 func GetObjectPosition(obj celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time) celestial.Vector3 {
+	// Calculate centuries since J2000 using TDB
+	return objectPositionAt(obj, objects, centuriesSinceJ2000TDB(t), nil)
+}
+
+// objectPositionAt is GetObjectPosition at T centuries from J2000 (TDB). A
+// moon or spacecraft's parent is placed by parentPosition when it is set
+// (a PositionCache), else solved here.
+func objectPositionAt(obj celestial.CelestialObject, objects []celestial.CelestialObject, T float64, parentPosition func(celestial.CelestialObject) celestial.Vector3) celestial.Vector3 {
 	// For the Sun, return the origin
 	if obj.Name == "Sun" {
 		return celestial.Vector3{X: 0, Y: 0, Z: 0}
 	}
 
-	// Calculate centuries since J2000 using TDB
-	T := centuriesSinceJ2000TDB(t)
-
-	// For planets and dwarf planets (heliocentric orbits)
-	if obj.Type == "planet" || obj.Type == "dwarf_planet" || obj.Type == "asteroid" {
+	// For planets, dwarf planets, asteroids and comets (heliocentric orbits)
+	if hasHeliocentricElements(obj) || obj.Type == "comet" {
 		return calculateVSOP87Position(obj, T)
 	}
 
@@ -358,16 +706,20 @@ func GetObjectPosition(obj celestial.CelestialObject, objects []celestial.Celest
 		}
 
 		// Get parent position
-		parentPos := GetObjectPosition(parent, objects, t)
+		var parentPos celestial.Vector3
+		if parentPosition != nil {
+			parentPos = parentPosition(parent)
+		} else {
+			parentPos = objectPositionAt(parent, objects, T, nil)
+		}
 
 		// Calculate object's position relative to parent
-		localPos := calculateLocalPosition(obj, T)
+		localPos := parentRelativePosition(obj, T)
 
-		// Convert localPos to AU if it was calculated in km.
-		// Moons always have 'A' in km.
-		// Spacecraft have 'A' in km if their parent is not the Sun.
-		// If parent is Sun, 'A' is in AU, so localPos is already in AU.
-		if obj.Type == "moon" || (obj.Type == "spacecraft" && obj.ParentName != "Sun") {
+		// Convert localPos to AU only if it was calculated in km; a
+		// heliocentric spacecraft's elements are already in AU, and dividing
+		// again would crush it toward the Sun.
+		if elementsInKm(obj) {
 			localPos.X /= celestial.AU
 			localPos.Y /= celestial.AU
 			localPos.Z /= celestial.AU
@@ -385,6 +737,18 @@ func GetObjectPosition(obj celestial.CelestialObject, objects []celestial.Celest
 	return celestial.Vector3{X: 0, Y: 0, Z: 0}
 }
 
+// elementsInKm reports whether obj's orbital elements (A) are in km rather
+// than AU: true for moons and for spacecraft orbiting anything but the Sun.
+func elementsInKm(obj celestial.CelestialObject) bool {
+	switch obj.Type {
+	case "moon":
+		return true
+	case "spacecraft":
+		return obj.ParentName != "Sun"
+	}
+	return false
+}
+
 // CalculateDistance calculates the distance between two objects in kilometers
 func CalculateDistance(obj1, obj2 celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time) float64 {
 	// Get positions
@@ -401,40 +765,244 @@ func CalculateDistance(obj1, obj2 celestial.CelestialObject, objects []celestial
 	return distanceKm
 }
 
-// IsOccluded determines if target is occluded from the viewpoint of observer by any other object
-func IsOccluded(observer, target celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time) (bool, celestial.CelestialObject) {
-	// Get positions
-	observerPos := GetObjectPosition(observer, objects, t)
-	targetPos := GetObjectPosition(target, objects, t)
+// Light-time iteration limits for ApparentDistance.
+const (
+	lightTimeToleranceKm   = 1.0
+	lightTimeMaxIterations = 5
+)
+
+// ApparentDistance returns the light-time corrected distance from observer to
+// target at time t: the distance to where the target was when the light now
+// reaching the observer left it. Starting from the geometric distance, the
+// target's position is back-dated by distance/c and the distance recomputed
+// until successive values agree to within 1 km (at most 5 iterations). For
+// a receding body this is shorter than the geometric distance, for an
+// approaching one longer.
+func ApparentDistance(observer, target celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time) float64 {
+	return apparentDistanceFrom(GetObjectPosition(observer, objects, t), GetObjectPosition(target, objects, t), target, objects, t)
+}
+
+// apparentDistanceFrom is ApparentDistance given the observer's and
+// target's positions at t.
+func apparentDistanceFrom(observerPos, targetPos celestial.Vector3, target celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time) float64 {
+	distanceKm := targetPos.Subtract(observerPos).Magnitude() * celestial.AU
+
+	for i := 0; i < lightTimeMaxIterations; i++ {
+		lightTime := time.Duration(distanceKm / celestial.SPEED_OF_LIGHT * float64(time.Second))
+		next := GetObjectPosition(target, objects, t.Add(-lightTime)).Subtract(observerPos).Magnitude() * celestial.AU
+		converged := math.Abs(next-distanceKm) < lightTimeToleranceKm
+		distanceKm = next
+		if converged {
+			break
+		}
+	}
+	return distanceKm
+}
+
+// LinkLightTimes are the one-way and round-trip light times of a
+// closed-loop exchange between Earth and a body.
+type LinkLightTimes struct {
+	Uplink    time.Duration // a command sent from Earth at t reaches the body
+	Downlink  time.Duration // telemetry received on Earth at t left the body
+	RoundTrip time.Duration // a command sent at t is answered at once and the answer arrives
+}
+
+// LightTimes returns target's light times from Earth's centre at t. The
+// legs differ because both ends move while a signal is in flight: the
+// uplink is solved forward to where target will be when the command
+// arrives, the downlink back to where it was when the telemetry left
+// (ApparentDistance), and the round trip is the uplink plus a downlink
+// leaving target at the uplink's arrival. ok is false without Earth.
+func LightTimes(target celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time) (lt LinkLightTimes, ok bool) {
+	earth, ok := findObjectByName(objects, "Earth")
+	if !ok {
+		return LinkLightTimes{}, false
+	}
+	lt.Uplink = lightTimeForward(GetObjectPosition(earth, objects, t), target, objects, t)
+	lt.Downlink = CalculateLatency(ApparentDistance(earth, target, objects, t))
+	turnaround := t.Add(lt.Uplink)
+	lt.RoundTrip = lt.Uplink + lightTimeForward(GetObjectPosition(target, objects, turnaround), earth, objects, turnaround)
+	return lt, true
+}
+
+// lightTimeForward solves for how long a signal leaving from at t takes to
+// reach to, moving to to its position at arrival until successive
+// distances agree as in ApparentDistance.
+func lightTimeForward(from celestial.Vector3, to celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time) time.Duration {
+	distanceKm := GetObjectPosition(to, objects, t).Subtract(from).Magnitude() * celestial.AU
+	for i := 0; i < lightTimeMaxIterations; i++ {
+		next := GetObjectPosition(to, objects, t.Add(CalculateLatency(distanceKm))).Subtract(from).Magnitude() * celestial.AU
+		converged := math.Abs(next-distanceKm) < lightTimeToleranceKm
+		distanceKm = next
+		if converged {
+			break
+		}
+	}
+	return CalculateLatency(distanceKm)
+}
+
+// Visibility is how clear the line of sight to a body is.
+type Visibility int
+
+const (
+	VisibilityClear    Visibility = iota
+	VisibilityDegraded            // passes close to an occluder's limb: usable, but lossy
+	VisibilityBlocked             // an occluder is in the way
+)
+
+// String is the name used in the status API.
+func (v Visibility) String() string {
+	switch v {
+	case VisibilityDegraded:
+		return "degraded"
+	case VisibilityBlocked:
+		return "blocked"
+	}
+	return "clear"
+}
+
+// Blocked reports whether the link is down.
+func (v Visibility) Blocked() bool { return v == VisibilityBlocked }
+
+// degradedLimbRadii is, per occluder type, how many of the occluder's radii
+// beyond its limb a line of sight still counts as degraded. Around the Sun
+// the corona scintillates the signal for a few solar radii either side of
+// superior conjunction; other occluders have no such zone. Set at startup
+// (-degraded-limb-radii).
+var degradedLimbRadii = map[string]float64{
+	"star": 4,
+}
+
+// minOccluderAngularRadius is the smallest angular radius (radians), as seen
+// from the observer, a body needs to be considered as an occluder. A 260 m
+// asteroid an AU away subtends under 2 nanoradians and can never matter, but
+// checking it costs a Kepler solve. Set at startup
+// (-min-occluder-angular-radius); 0 checks every body.
+var minOccluderAngularRadius = 1e-6
+
+// heliocentricMargin shrinks the perihelion/aphelion distance bound to allow
+// for perturbations and element rates the bound ignores.
+const heliocentricMargin = 0.9
+
+// PositionCache memoizes body positions at one instant, keyed by name, so a
+// caller checking many lines of sight at the same epoch solves each body
+// once. It also keeps, per observer, the bodies big enough to occlude from
+// there. It is not safe for concurrent use.
+type PositionCache struct {
+	objects   []celestial.CelestialObject
+	T         float64 // the instant, in centuries from J2000 (TDB)
+	pos       map[string]celestial.Vector3
+	occluders map[string][]occluder // by observer name
+}
+
+// occluder is a body that subtends at least minOccluderAngularRadius from
+// an observer, as seen from it.
+type occluder struct {
+	obj    celestial.CelestialObject
+	offset celestial.Vector3 // from the observer, AU
+	dist   float64           // km
+	reach  float64           // radius with the degraded limb zone, km
+}
+
+// NewPositionCache returns an empty cache of positions in objects at t.
+func NewPositionCache(objects []celestial.CelestialObject, t time.Time) *PositionCache {
+	return &PositionCache{objects: objects, T: centuriesSinceJ2000TDB(t), pos: make(map[string]celestial.Vector3, len(objects))}
+}
+
+// Position returns obj's heliocentric position (AU), solving it on first use.
+// A moon's parent comes from the cache too.
+func (c *PositionCache) Position(obj celestial.CelestialObject) celestial.Vector3 {
+	if p, ok := c.pos[obj.Name]; ok {
+		return p
+	}
+	p := objectPositionAt(obj, c.objects, c.T, c.Position)
+	c.pos[obj.Name] = p
+	return p
+}
+
+// occludersFrom returns the bodies other than observer that are big enough
+// to see from it (minOccluderAngularRadius), in objects order. Most of the
+// rest are ruled out from their orbits before their position is solved.
+func (c *PositionCache) occludersFrom(observer celestial.CelestialObject) []occluder {
+	if list, ok := c.occluders[observer.Name]; ok {
+		return list
+	}
+	observerPos := c.Position(observer)
+	observerSunDist := observerPos.Magnitude() // the Sun is the origin
+	var list []occluder
+	for _, obj := range c.objects {
+		if obj.Name == observer.Name {
+			continue
+		}
+		// The widest the body could count for, limb zone included.
+		reach := obj.Radius * (1 + degradedLimbRadii[obj.Type])
+		if minDist := minHeliocentricSeparation(obj, observerSunDist) * celestial.AU; reach < minOccluderAngularRadius*minDist {
+			continue // too small from anywhere on its orbit
+		}
+		offset := c.Position(obj).Subtract(observerPos)
+		dist := offset.Magnitude() * celestial.AU
+		if reach < minOccluderAngularRadius*dist {
+			continue
+		}
+		list = append(list, occluder{obj: obj, offset: offset, dist: dist, reach: reach})
+	}
+	if c.occluders == nil {
+		c.occluders = make(map[string][]occluder)
+	}
+	c.occluders[observer.Name] = list
+	return list
+}
+
+// IsOccluded determines how clear the line of sight from observer to target
+// is. For a degraded or blocked line it also returns the occluder, the
+// blocker if there is one.
+func IsOccluded(observer, target celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time) (Visibility, celestial.CelestialObject) {
+	return IsOccludedCached(observer, target, objects, t, nil)
+}
+
+// IsOccludedCached is IsOccluded taking positions from cache, which must be
+// for objects at t. A nil cache memoizes for this call only.
+func IsOccludedCached(observer, target celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time, cache *PositionCache) (Visibility, celestial.CelestialObject) {
+	if cache == nil {
+		cache = NewPositionCache(objects, t)
+	}
+	return occlusionWith(observer, target, objects, cache)
+}
+
+// occlusionWith is IsOccluded taking positions, and the bodies big enough
+// to matter from the observer, from cache.
+func occlusionWith(observer, target celestial.CelestialObject, objects []celestial.CelestialObject, cache *PositionCache) (Visibility, celestial.CelestialObject) {
+	// A body close to its parent (surface assets, low orbiters) is checked
+	// against the parent in precise parent-relative km instead.
+	parent, nearParent := closeParent(target, observer, objects)
+	if nearParent && isOccludedByParent(observer, target, parent, cache) {
+		return VisibilityBlocked, parent
+	}
 
 	// Calculate the direction vector from observer to target
-	dirVector := targetPos.Subtract(observerPos)
+	dirVector := cache.Position(target).Subtract(cache.Position(observer))
 	distToTarget := dirVector.Magnitude() * celestial.AU // Distance in km
 
 	// Normalize the direction vector
 	dirNorm := dirVector.Normalize()
 
-	// Check each object to see if it occludes the target
-	for _, obj := range objects {
-		// Skip the observer and target
-		if obj.Name == observer.Name || obj.Name == target.Name {
+	// Check each object to see if it occludes the target; a grazed limb is
+	// remembered in case nothing blocks outright.
+	visibility, grazed := VisibilityClear, celestial.CelestialObject{}
+	for _, o := range cache.occludersFrom(observer) {
+		obj := o.obj
+		// Skip the target (and a parent already checked above)
+		if obj.Name == target.Name || (nearParent && obj.Name == parent.Name) {
 			continue
 		}
 
-		// Get the position of the potential occluding body
-		objPos := GetObjectPosition(obj, objects, t)
-
-		// Vector from observer to the object
-		objVector := objPos.Subtract(observerPos)
-		distToObj := objVector.Magnitude() * celestial.AU // Distance in km
-
 		// If the object is further away than the target, it can't occlude
-		if distToObj >= distToTarget {
+		if o.dist >= distToTarget {
 			continue
 		}
 
 		// Project the object vector onto the direction vector
-		projection := objVector.DotProduct(dirNorm)
+		projection := o.offset.DotProduct(dirNorm)
 
 		// If the projection is negative, the object is behind the observer
 		if projection <= 0 {
@@ -443,7 +1011,7 @@ func IsOccluded(observer, target celestial.CelestialObject, objects []celestial.
 
 		// Calculate the perpendicular distance from the object to the line of sight
 		projectionVector := dirNorm.Scale(projection)
-		perpendicularVector := objVector.Subtract(projectionVector)
+		perpendicularVector := o.offset.Subtract(projectionVector)
 		perpendicularDist := perpendicularVector.Magnitude() * celestial.AU // in km
 
 		// Check if the perpendicular distance is less than the radius of the object
@@ -458,12 +1026,122 @@ func IsOccluded(observer, target celestial.CelestialObject, objects []celestial.
 		}
 
 		if perpendicularDist < occlusionRadius {
-			return true, obj
+			return VisibilityBlocked, obj
+		}
+		if visibility == VisibilityClear && perpendicularDist < o.reach {
+			visibility, grazed = VisibilityDegraded, obj
+		}
+	}
+
+	return visibility, grazed
+}
+
+// minHeliocentricSeparation returns a lower bound (AU) on the distance from
+// obj to any point r AU from the Sun, from its perihelion and aphelion: 0 if
+// its orbit can cross that sphere, or for bodies that don't orbit the Sun
+// on fixed elements.
+func minHeliocentricSeparation(obj celestial.CelestialObject, r float64) float64 {
+	switch obj.Type {
+	case "planet", "dwarf_planet", "asteroid", "comet":
+	default:
+		return 0
+	}
+	if obj.A <= 0 || obj.E < 0 || obj.E >= 1 {
+		return 0
+	}
+	perihelion, aphelion := obj.A*(1-obj.E), obj.A*(1+obj.E)
+	return math.Max(0, math.Max(perihelion-r, r-aphelion)) * heliocentricMargin
+}
+
+// parseDegradedLimbRadii parses -degraded-limb-radii, e.g. "star=4,planet=0.1",
+// into a per-occluder-type map.
+func parseDegradedLimbRadii(spec string) (map[string]float64, error) {
+	radii := make(map[string]float64)
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		typ, val, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want type=radii", entry)
 		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%q: radii must be a number >= 0", entry)
+		}
+		radii[strings.TrimSpace(typ)] = n
+	}
+	return radii, nil
+}
+
+// parentRelativePosition returns obj's position relative to its parent, in
+// km (AU for spacecraft orbiting the Sun). Earth's Moon uses the perturbed
+// lunar theory; everything else a Kepler orbit.
+func parentRelativePosition(obj celestial.CelestialObject, T float64) celestial.Vector3 {
+	if isEarthMoon(obj) {
+		return calculateLunarPosition(T)
+	}
+	return calculateLocalPosition(obj, T)
+}
+
+// nearParentRadii is how close (in parent radii) a body must orbit its parent
+// for the parent occlusion to be solved in parent-relative km.
+const nearParentRadii = 10.0
+
+// parentGrazingMarginDeg lets a line of sight dip this far (degrees) below a
+// parent's geometric horizon and still get through, for atmospheric
+// refraction. Keyed by parent name; set once at startup.
+var parentGrazingMarginDeg = map[string]float64{
+	"Mars": 0.1,
+}
+
+// closeParent returns target's parent if it is a planet, moon or asteroid
+// that target sits within nearParentRadii of, and the parent is not the
+// observer itself.
+func closeParent(target, observer celestial.CelestialObject, objects []celestial.CelestialObject) (celestial.CelestialObject, bool) {
+	if target.ParentName == "" || target.ParentName == "Sun" || target.ParentName == observer.Name {
+		return celestial.CelestialObject{}, false
+	}
+	parent, ok := findObjectByName(objects, target.ParentName)
+	if !ok || parent.Radius <= 0 {
+		return celestial.CelestialObject{}, false
 	}
+	switch parent.Type {
+	case "planet", "dwarf_planet", "moon", "asteroid":
+	default:
+		return celestial.CelestialObject{}, false
+	}
+	if target.A > nearParentRadii*parent.Radius {
+		return celestial.CelestialObject{}, false
+	}
+	return parent, true
+}
+
+// isOccludedByParent reports whether parent blocks the line of sight from
+// observer to target. The target's offset from the parent (a few km for a
+// surface rover) is taken straight from its parent-relative orbit in km,
+// before any AU conversion can round it away.
+func isOccludedByParent(observer, target, parent celestial.CelestialObject, cache *PositionCache) bool {
+	targetRel := parentRelativePosition(target, cache.T)
+	observerRel := cache.Position(observer).Subtract(cache.Position(parent)).Scale(celestial.AU)
+	return parentBlocksLine(targetRel, observerRel, parent.Radius, parentGrazingMarginDeg[parent.Name])
+}
 
-	// No occlusion found
-	return false, celestial.CelestialObject{}
+// parentBlocksLine reports whether a sphere of the given radius at the origin
+// blocks the line from target to observer (both in km relative to the sphere
+// centre). A line grazing within marginDeg below the horizon still passes.
+func parentBlocksLine(target, observer celestial.Vector3, radius, marginDeg float64) bool {
+	line := observer.Subtract(target)
+	dir := line.Normalize()
+	// Closest approach of the line of sight to the centre, measured from the
+	// target toward the observer. Behind the target means the target faces
+	// the observer; beyond the observer means the parent is behind it.
+	s := -target.DotProduct(dir)
+	if s <= 0 || s >= line.Magnitude() {
+		return false
+	}
+	closest := target.Add(dir.Scale(s)).Magnitude()
+	return closest < radius*math.Cos(degToRad(marginDeg))
 }
 
 // Helper function to find an object by name
@@ -483,6 +1161,20 @@ func findObjectByName(objects []celestial.CelestialObject, name string) (celesti
 	return celestial.CelestialObject{}, false
 }
 
+// moonsOf returns the moons in objects whose parent is bodyName, innermost
+// first. Unlike celestial.GetMoons it reads the given (possibly reloaded)
+// list rather than the built-in data.
+func moonsOf(objects []celestial.CelestialObject, bodyName string) []celestial.CelestialObject {
+	moons := make([]celestial.CelestialObject, 0)
+	for _, obj := range objects {
+		if obj.Type == "moon" && strings.EqualFold(obj.ParentName, bodyName) {
+			moons = append(moons, obj)
+		}
+	}
+	celestial.SortMoons(moons)
+	return moons
+}
+
 // ParseDate parses a date string in format YYYY-MM-DD
 func ParseDate(dateStr string) (time.Time, error) {
 	return time.Parse("2006-01-02", dateStr)
@@ -502,101 +1194,227 @@ func FormatDistance(dist float64) string {
 // Create a slice to store results
 type DistanceEntry struct {
 	Object     celestial.CelestialObject
-	Distance   float64
-	Occluded   bool
-	OccludedBy celestial.CelestialObject
+	Distance   float64                   // light-time corrected (apparent) distance; used for latency
+	Geometric  float64                   // geometric distance at the same instant
+	Occluded   bool                      // Visibility is blocked
+	Visibility Visibility                // clear, degraded or blocked
+	OccludedBy celestial.CelestialObject // the blocker, or the limb grazed when degraded
+	Elongation float64                   // angle between the Sun and the object seen from Earth, degrees
+	Direction  celestial.Vector3         // unit vector from Earth's centre, for the ground-station model
 }
 
-var lastDistanceUpdate time.Time
+var distanceUpdated clockReading    // when the cache was last filled (clockjump.go)
 var distanceEntries []DistanceEntry // store the current distances
-
-// Calculate distances from Earth to all objects, using double-check locking
-func calculateDistancesFromEarth(objects []celestial.CelestialObject, t time.Time) {
-	// First check (read lock) - cheap check if update is needed
-	DistanceCacheMutex.RLock()
-	needsUpdate := len(distanceEntries) == 0 || time.Since(lastDistanceUpdate) >= time.Hour
-	DistanceCacheMutex.RUnlock()
-
-	if !needsUpdate {
-		//log.Printf("No distances update required (quick check)")
-		return
+var distanceEpoch time.Time         // the instant the cached entries describe
+var distanceGeneration uint64       // refreshes so far, for /_debug/runtime
+
+// distanceClock ages the distance cache; tests replace it.
+var distanceClock = time.Now
+
+// freshOcclusionElongationDeg: within this angle of the Sun, occlusion can
+// flip within minutes of a conjunction, so it is re-solved per request
+// rather than served from the hourly cache.
+const freshOcclusionElongationDeg = 10.0
+
+// distanceJumpWarned is the cache generation whose clock jump has been
+// logged, plus one, so concurrent readers warn once.
+var distanceJumpWarned atomic.Uint64
+
+// distanceCacheStale reports whether the cache must be refilled for t: it is
+// empty or an hour old by the monotonic clock, the wall clock has jumped
+// since it was filled (clockjump.go), or, with the epoch pinned (epoch.go),
+// it describes another instant. Callers hold DistanceCacheMutex.
+func distanceCacheStale(t time.Time) bool {
+	if len(distanceEntries) == 0 {
+		return true
+	}
+	if _, pinned := pinnedEpoch(); pinned {
+		return !distanceEpoch.Equal(t)
 	}
+	now := readClock(distanceClock)
+	if jump := distanceUpdated.jumpTo(now); clockJumped(jump) {
+		if distanceJumpWarned.Swap(distanceGeneration+1) != distanceGeneration+1 {
+			log.Printf("Warning: the wall clock jumped %v since the distances were computed for %s; refreshing them",
+				jump.Round(time.Second), distanceEpoch.Format(time.RFC3339))
+		}
+		return true
+	}
+	return now.Mono-distanceUpdated.Mono >= time.Hour
+}
 
-	// Acquire write lock to perform the update
-	DistanceCacheMutex.Lock()
-	defer DistanceCacheMutex.Unlock() // Ensure lock is released
+// calculateDistancesFromEarth returns the cached distances from Earth to all
+// objects, refreshing them first if they are stale. It returns the entries
+// and the instant they describe as they stood under the lock, so a
+// concurrent invalidation can't empty them between the refresh and the read
+// (see currentSnapshot).
+func calculateDistancesFromEarth(objects []celestial.CelestialObject, t time.Time) ([]DistanceEntry, time.Time) {
+	if entries, epoch, ok := freshDistances(t); ok {
+		return entries, epoch
+	}
+	return refreshDistances(objects, t)
+}
 
-	// Second check (write lock) - re-check condition after acquiring lock
-	if len(distanceEntries) > 0 && time.Since(lastDistanceUpdate) < time.Hour {
-		//log.Printf("No distances update required (double check)")
-		return // Another goroutine updated the cache while we waited for the lock
+// freshDistances returns the cache if it needn't be refilled for t.
+func freshDistances(t time.Time) ([]DistanceEntry, time.Time, bool) {
+	DistanceCacheMutex.RLock()
+	defer DistanceCacheMutex.RUnlock()
+	if distanceCacheStale(t) {
+		return nil, time.Time{}, false
 	}
+	return distanceEntries, distanceEpoch, true
+}
 
+// computeDistances solves every object's distance and visibility from Earth
+// at t. ok is false when there is no Earth to measure from.
+func computeDistances(objects []celestial.CelestialObject, t time.Time) (entries []DistanceEntry, ok bool) {
 	log.Printf("Updating distances cache...")
 
 	// Find Earth
 	earth, found := findObjectByName(objects, "Earth")
 	if !found {
 		fmt.Println("Error: Earth data not found")
-		return
+		return nil, false
 	}
 
 	fmt.Printf("\nDistances from Earth on %s:\n\n", t.Format("2006-01-02"))
-	distanceEntries = make([]DistanceEntry, 0, 20)
+	entries = make([]DistanceEntry, 0, len(objects))
+	// Every line of sight below is at t: solve each body once.
+	positions := NewPositionCache(objects, t)
+	earthPos := positions.Position(earth)
+	var sunPos celestial.Vector3
+	if sun, ok := findObjectByName(objects, "Sun"); ok {
+		sunPos = positions.Position(sun)
+	}
 	// Calculate distances to all objects except Earth
 	for _, obj := range objects {
 		if obj.Name != "Earth" && obj.Name != "" {
-			// Calculate distance
-			distance := CalculateDistance(earth, obj, objects, t)
+			// Calculate distance: latency uses the light-time corrected value
+			objPos := positions.Position(obj)
+			distance := apparentDistanceFrom(earthPos, objPos, obj, objects, t)
+			toObj := objPos.Subtract(earthPos)
+			geometric := toObj.Magnitude() * celestial.AU
 
 			// Check for occlusion
-			occluded, occluderObj := IsOccluded(earth, obj, objects, t)
+			visibility, occluderObj := IsOccludedCached(earth, obj, objects, t, positions)
+			direction := toObj.Normalize()
 
-			distanceEntries = append(distanceEntries, DistanceEntry{
+			entries = append(entries, DistanceEntry{
 				Object:     obj,
 				Distance:   distance,
-				Occluded:   occluded,
+				Geometric:  geometric,
+				Occluded:   visibility.Blocked(),
+				Visibility: visibility,
 				OccludedBy: occluderObj,
+				Elongation: solarElongationAt(sunPos, earthPos, objPos),
+				Direction:  direction,
 			})
 		}
 	}
+	return entries, true
+}
+
+// SolarElongation returns the angle in degrees between the Sun and target as
+// seen from observer (0 at conjunction, 180 at opposition).
+func SolarElongation(observer, target celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time) float64 {
+	var sunPos celestial.Vector3 // heliocentric origin when there's no Sun object
+	if sun, ok := findObjectByName(objects, "Sun"); ok {
+		sunPos = GetObjectPosition(sun, objects, t)
+	}
+	return solarElongationAt(sunPos, GetObjectPosition(observer, objects, t), GetObjectPosition(target, objects, t))
+}
 
-	lastDistanceUpdate = time.Now()
+// solarElongationAt is SolarElongation from the three positions.
+func solarElongationAt(sunPos, observerPos, targetPos celestial.Vector3) float64 {
+	toSun := sunPos.Subtract(observerPos)
+	toTarget := targetPos.Subtract(observerPos)
+	if toSun.Magnitude() == 0 || toTarget.Magnitude() == 0 {
+		return 0
+	}
+	cos := toSun.DotProduct(toTarget) / (toSun.Magnitude() * toTarget.Magnitude())
+	return math.Acos(math.Max(-1, math.Min(1, cos))) * 180 / math.Pi
 }
 
-func getCurrentDistance(bodyName string) float64 {
+// errDistanceUnavailable means there is no distance for a body: it isn't
+// defined, or the cache couldn't be filled. Callers must not treat it as zero
+// latency, which the latency floor would misreport as "too close to proxy".
+var errDistanceUnavailable = errors.New("distance unavailable")
+
+// distanceCacheMisses counts lookups the distance cache couldn't answer.
+var distanceCacheMisses atomic.Int64
+
+// getCurrentDistance returns the light-time distance from Earth to bodyName
+// in km, from the ground station if -ground-station is on. A body missing
+// from the cache (objects reloaded, or Earth missing when it was filled)
+// makes it refresh the cache once, synchronously, rather than wait for the
+// hourly update.
+func getCurrentDistance(bodyName string) (float64, error) {
 	// Special case: Earth is the reference point, distance is 0
 	if strings.EqualFold(bodyName, "Earth") {
-		return 0
+		return 0, nil
+	}
+
+	calculateDistancesFromEarth(getCelestialObjects(), simTime(time.Now())) // Ensure cache is potentially updated (handles its own locking)
+	if d, ok := cachedDistance(bodyName); ok {
+		return d, nil
+	}
+
+	distanceCacheMisses.Add(1)
+	// Only a defined body is worth a refresh; anything else would let a
+	// client force a recalculation per request.
+	if _, known := findObjectByName(getCelestialObjects(), bodyName); known {
+		invalidateDistanceCache()
+		calculateDistancesFromEarth(getCelestialObjects(), simTime(time.Now()))
+		if d, ok := cachedDistance(bodyName); ok {
+			return d, nil
+		}
+	}
+	log.Printf("getCurrentDistance: no distance for body %s", bodyName)
+	return 0, fmt.Errorf("%w for body %s", errDistanceUnavailable, bodyName)
+}
+
+// cachedDistance looks bodyName up in the distance cache and applies the
+// ground station, if any, that has it above the horizon.
+func cachedDistance(bodyName string) (float64, bool) {
+	e, ok := cachedEntry(bodyName)
+	if !ok {
+		return 0, false
 	}
+	return stationDistance(e), true
+}
 
-	//log.Printf("Size of celestialObjects: %d", len(celestialObjects))
-	calculateDistancesFromEarth(getCelestialObjects(), time.Now()) // Ensure cache is potentially updated (handles its own locking)
+// stationDistance is e's distance from the ground station that has it above
+// the horizon, or from Earth's centre when there is none.
+func stationDistance(e DistanceEntry) float64 {
+	if fix := stationFor(e, simTime(distanceClock())); fix != nil && fix.Visible {
+		return e.Distance + fix.OffsetKm
+	}
+	return e.Distance
+}
 
+// cachedEntry looks bodyName up in the distance cache.
+func cachedEntry(bodyName string) (DistanceEntry, bool) {
 	DistanceCacheMutex.RLock()         // Acquire read lock to access the cache
 	defer DistanceCacheMutex.RUnlock() // Ensure lock is released
-
-	//log.Printf("Size of distanceObjects: %d", len(distanceEntries))
 	for _, body := range distanceEntries {
-		//log.Printf("body: %s, type: %s", body.Object.Name, body.Object.Type)
 		if strings.EqualFold(body.Object.Name, bodyName) {
-			return body.Distance
+			return body, true
 		}
 	}
-	log.Printf("getCurrentDistance: invalid body %s", bodyName)
-	return 0
+	return DistanceEntry{}, false
 }
 
-// Display objects of a specific type
-func printObjectsByType(w io.Writer, objectType string) {
-	calculateDistancesFromEarth(getCelestialObjects(), time.Now()) // Ensure cache is potentially updated
-
-	DistanceCacheMutex.RLock()         // Acquire read lock
-	defer DistanceCacheMutex.RUnlock() // Ensure lock is released
+// currentDistanceEntries returns a copy of the cached Earth distances,
+// refreshing the cache first if it is stale.
+func currentDistanceEntries() []DistanceEntry {
+	entries, _ := calculateDistancesFromEarth(getCelestialObjects(), simTime(time.Now()))
+	return append([]DistanceEntry(nil), entries...)
+}
 
-	// Filter entries by type within the lock
+// Display objects of a specific type
+func printObjectsByType(w io.Writer, snap *SystemSnapshot, objectType string) {
+	// Filter entries by type
 	filteredEntries := make([]DistanceEntry, 0, 10)
-	for _, entry := range distanceEntries { // Access shared data under lock
+	for _, entry := range snap.Entries {
 		if entry.Object.Type == objectType {
 			filteredEntries = append(filteredEntries, entry)
 		}
@@ -622,20 +1440,29 @@ func printObjectsByType(w io.Writer, objectType string) {
 		typeName = "Moons"
 	case "asteroid":
 		typeName = "Asteroids"
+	case "comet":
+		typeName = "Comets"
 	case "spacecraft":
 		typeName = "Spacecraft"
 	}
 
-	fmt.Fprintf(w, "\n--- %s ---\n", typeName)
+	printDistanceTable(w, typeName, filteredEntries)
+}
+
+// printDistanceTable prints entries, in the order given, under a heading.
+func printDistanceTable(w io.Writer, heading string, entries []DistanceEntry) {
+	fmt.Fprintf(w, "\n--- %s ---\n", heading)
 	fmt.Fprintf(w, "%-15s | %-10s | %-18s | %-15s | %-15s | %s\n",
 		"Name", "Type", "Distance (km)", "Distance", "RTT", "Visibility")
 	fmt.Fprintln(w, "--------------------------------------------------------------------------------------")
 
-	// Iterate over the filtered (local) copy
-	for _, entry := range filteredEntries {
+	for _, entry := range entries {
 		visibility := "Visible"
-		if entry.Occluded {
+		switch entry.Visibility {
+		case VisibilityBlocked:
 			visibility = fmt.Sprintf("Occluded by %s", entry.OccludedBy.Name)
+		case VisibilityDegraded:
+			visibility = fmt.Sprintf("Degraded near %s", entry.OccludedBy.Name)
 		}
 
 		fmt.Fprintf(w, "%-15s | %-10s | %18.0f | %-15s | %-15s | %s\n",
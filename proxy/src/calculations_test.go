@@ -55,7 +55,7 @@ func TestDistinctSpacecraftDistances(t *testing.T) {
 	// This function updates the global distanceEntries slice
 	// Reset global state before test
 	distanceEntries = []DistanceEntry{}
-	lastDistanceUpdate = time.Time{}
+	distanceUpdated = clockReading{}
 	// Set the global celestialObjects for the test context IF NEEDED by dependencies
 	// Since calculateDistancesFromEarth takes objects as arg, we don't strictly need this
 	// But GetObjectPosition relies on the global slice if ParentName lookups occur
@@ -0,0 +1,90 @@
+// categories.go - the JSON keys bodies are grouped under.
+//
+// /api/status-data groups bodies under these keys and lists the ones present
+// in "categories"; /api/bodies gives each body its "category". The keys are
+// camelCase plurals: planets, dwarfPlanets, moons, asteroids, comets,
+// spacecraft, stars. The old keys, the type with an "s" appended ("dwarf_planets",
+// "spacecrafts"), are still served with ?legacyKeys=true for one release.
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// bodyCategories maps each object type to its key, in display order.
+var bodyCategories = []struct{ objType, key string }{
+	{"planet", "planets"},
+	{"dwarf_planet", "dwarfPlanets"},
+	{"moon", "moons"},
+	{"asteroid", "asteroids"},
+	{"comet", "comets"},
+	{"spacecraft", "spacecraft"},
+	{"star", "stars"},
+}
+
+// legacyKeysDeprecated is when ?legacyKeys=true was deprecated, sent in its
+// Deprecation header.
+var legacyKeysDeprecated = time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+// categoryKey returns the key bodies of objType are grouped under. The type
+// is matched case-insensitively; an unknown one is camelCased and given an
+// "s".
+func categoryKey(objType string) string {
+	for _, c := range bodyCategories {
+		if strings.EqualFold(c.objType, objType) {
+			return c.key
+		}
+	}
+	words := strings.Split(strings.ToLower(objType), "_")
+	for i := 1; i < len(words); i++ {
+		if words[i] != "" {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+	return strings.Join(words, "") + "s"
+}
+
+// legacyCategoryKey is the pre-categories key for objType.
+func legacyCategoryKey(objType string) string {
+	return objType + "s"
+}
+
+// categoriesOf lists the keys of objects, in display order, unknown types
+// last by name.
+func categoriesOf(objects map[string][]StatusEntry) []string {
+	rank := func(key string) int {
+		for i, c := range bodyCategories {
+			if key == c.key || key == legacyCategoryKey(c.objType) {
+				return i
+			}
+		}
+		return len(bodyCategories)
+	}
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ri, rj := rank(keys[i]), rank(keys[j]); ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// withLegacyKeys returns resp with its bodies grouped under the legacy keys.
+func withLegacyKeys(resp ApiResponse) ApiResponse {
+	objects := make(map[string][]StatusEntry, len(resp.Objects))
+	for _, key := range resp.Categories {
+		for _, entry := range resp.Objects[key] {
+			legacy := legacyCategoryKey(entry.Type)
+			objects[legacy] = append(objects[legacy], entry)
+		}
+	}
+	resp.Objects = objects
+	resp.Categories = categoriesOf(objects)
+	return resp
+}
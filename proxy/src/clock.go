@@ -0,0 +1,97 @@
+// clock.go - the time and latency seams of the proxy paths.
+//
+// Handlers read the time, wait, and choose a body's one-way latency through
+// a Clock and a LatencyProvider instead of the time package and
+// CalculateLatency. Tests can then inject a clock they advance by hand and a
+// fixed latency, and run a twelve-minute Mars delay in no time. Production
+// uses the system clock and the light time of the body's distance.
+//
+// Components embed timing, whose zero value is the production pair, so a
+// Server or handler built without one behaves as before. The Server hands
+// its timing to every handler and listener it starts.
+//
+// The ephemeris clocks (distanceClock, linkClock) are separate: they move
+// the geometry, not the waits, and give way to a pinned simulation epoch
+// (epoch.go). The DTN store schedules its deliveries with AfterFunc on the
+// Server's clock, and re-arms them when the wall clock jumps (clockjump.go).
+package main
+
+import "time"
+
+// Clock is a source of time and waits.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending AfterFunc call. Stop cancels it, reporting false if f
+// has already been started.
+type Timer interface {
+	Stop() bool
+}
+
+// systemClock is the real clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// LatencyProvider chooses the one-way latency applied to traffic for body,
+// which is distanceKm from Earth.
+type LatencyProvider interface {
+	Latency(body string, distanceKm float64) time.Duration
+}
+
+// lightTime is the physical latency: the distance at the speed of light.
+type lightTime struct{}
+
+func (lightTime) Latency(_ string, distanceKm float64) time.Duration {
+	return CalculateLatency(distanceKm)
+}
+
+// timing is the Clock and LatencyProvider of a component. Nil fields mean
+// the system clock and light time.
+type timing struct {
+	clock     Clock
+	latencies LatencyProvider
+	drain     time.Duration // relay idle limit after a half-close; zero means relayDrainTimeout
+}
+
+// clk returns the component's clock.
+func (t timing) clk() Clock {
+	if t.clock == nil {
+		return systemClock{}
+	}
+	return t.clock
+}
+
+func (t timing) now() time.Time                         { return t.clk().Now() }
+func (t timing) since(start time.Time) time.Duration    { return t.clk().Now().Sub(start) }
+func (t timing) sleep(d time.Duration)                  { t.clk().Sleep(d) }
+func (t timing) after(d time.Duration) <-chan time.Time { return t.clk().After(d) }
+func (t timing) afterFunc(d time.Duration, f func()) Timer {
+	return t.clk().AfterFunc(d, f)
+}
+
+// drainTimeout returns how long a relay direction left running after a
+// half-close may go without data.
+func (t timing) drainTimeout() time.Duration {
+	if t.drain == 0 {
+		return relayDrainTimeout
+	}
+	return t.drain
+}
+
+// oneWay returns the latency to apply for body at distanceKm.
+func (t timing) oneWay(body string, distanceKm float64) time.Duration {
+	if t.latencies == nil {
+		return lightTime{}.Latency(body, distanceKm)
+	}
+	return t.latencies.Latency(body, distanceKm)
+}
@@ -0,0 +1,73 @@
+// clockjump.go - noticing when the wall clock leaves the monotonic one.
+//
+// Cache ages and waits run on the monotonic clock, which NTP steps don't
+// move. But Linux's monotonic clock also stops while a VM or laptop is
+// suspended, so after two hours asleep the wall clock is two hours ahead
+// of it: the distance cache looks two hours younger than the ephemeris it
+// describes, and a DTN fetch timer fires two hours after the wall-clock
+// arrival its job reports. A clockReading pairs the two clocks so the gap
+// can be measured. A jump of clockJumpThreshold or more either way
+// refreshes the distance cache (calculations.go) and re-arms the DTN
+// timers against the wall clock (dtn.go), with a warning in the log.
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	clockJumpThreshold = time.Minute      // smaller gaps are drift, not a jump
+	clockJumpInterval  = 30 * time.Second // how often DTNStore compares the clocks
+)
+
+// processStart anchors uptime.
+var processStart = time.Now()
+
+// uptime is the monotonic time since the process started; tests replace
+// it to stop it while the wall clock moves.
+var uptime = func() time.Duration { return time.Since(processStart) }
+
+// clockReading is the wall and monotonic clocks read together.
+type clockReading struct {
+	Wall time.Time     // without its monotonic reading, so Sub compares wall times
+	Mono time.Duration // uptime
+}
+
+// readClock reads the clocks, taking the wall time from wall.
+func readClock(wall func() time.Time) clockReading {
+	return clockReading{Wall: wall().Round(0), Mono: uptime()}
+}
+
+// jumpTo returns how far the wall clock moved beyond the monotonic one
+// between r and now: positive after a suspend or a forward step, negative
+// after a backward step.
+func (r clockReading) jumpTo(now clockReading) time.Duration {
+	return now.Wall.Sub(r.Wall) - (now.Mono - r.Mono)
+}
+
+// clockJumped reports whether jump is beyond clockJumpThreshold.
+func clockJumped(jump time.Duration) bool {
+	return jump >= clockJumpThreshold || jump <= -clockJumpThreshold
+}
+
+// watchClockJumps compares the clocks every interval until stop closes and
+// calls onJump with each jump it finds.
+func watchClockJumps(stop <-chan struct{}, interval time.Duration, onJump func(time.Duration)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	last := readClock(time.Now)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			now := readClock(time.Now)
+			if jump := last.jumpTo(now); clockJumped(jump) {
+				log.Printf("Warning: the wall clock jumped %v against the monotonic clock (suspend or clock step)", jump.Round(time.Second))
+				onJump(jump)
+			}
+			last = now
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestDistanceCacheClockJump checks the cache is refilled for the new wall
+// time after a two-hour suspend and after a backward step, though the
+// monotonic clock says it is minutes old.
+func TestDistanceCacheClockJump(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	withObjects(t, objects)
+	t0 := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	setClock := fakeDistanceClock(t, t0)
+	var mono time.Duration
+	uptime = func() time.Duration { return mono } // fakeDistanceClock restores it
+	earth, _ := findObjectByName(objects, "Earth")
+	mars, _ := findObjectByName(objects, "Mars")
+
+	check := func(wall time.Time, up time.Duration, wantComputed time.Time) {
+		t.Helper()
+		setClock(wall)
+		mono = up
+		snap := currentSnapshot(distanceClock())
+		if !snap.ComputedAt.Equal(wantComputed) {
+			t.Fatalf("at %v, up %v: computedAt %v, want %v", wall, up, snap.ComputedAt, wantComputed)
+		}
+		e, _ := snap.Entry("Mars")
+		if want := ApparentDistance(earth, mars, objects, wantComputed); e.Distance != want {
+			t.Errorf("at %v: Mars at %v km, want %v for %v", wall, e.Distance, want, wantComputed)
+		}
+	}
+	check(t0, 0, t0)
+	check(t0.Add(30*time.Minute), 30*time.Minute, t0)
+
+	// Two hours asleep: the wall clock moves, uptime doesn't.
+	resumed := t0.Add(150 * time.Minute)
+	check(resumed, 31*time.Minute, resumed)
+	check(resumed.Add(10*time.Minute), 41*time.Minute, resumed)
+
+	// An NTP step back an hour, a minute later by the monotonic clock.
+	stepped := resumed.Add(-time.Hour)
+	check(stepped, 42*time.Minute, stepped)
+
+	// Drift under the threshold leaves the cache alone, and an hour of
+	// uptime still ages it.
+	check(stepped.Add(90*time.Second), 43*time.Minute, stepped)
+	check(stepped.Add(time.Hour), 102*time.Minute, stepped.Add(time.Hour))
+}
+
+func TestClockReadingJump(t *testing.T) {
+	t0 := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	last := clockReading{Wall: t0, Mono: time.Hour}
+	for _, tc := range []struct {
+		wall, mono time.Duration // since last
+		jumped     bool
+	}{
+		{time.Minute, time.Minute, false},
+		{time.Minute + 59*time.Second, time.Minute, false},
+		{2*time.Hour + time.Minute, time.Minute, true}, // suspended for two hours
+		{-time.Hour + time.Minute, time.Minute, true},  // stepped back an hour
+		{time.Minute, 2 * time.Minute, true},           // stepped back a minute
+	} {
+		jump := last.jumpTo(clockReading{Wall: t0.Add(tc.wall), Mono: time.Hour + tc.mono})
+		if clockJumped(jump) != tc.jumped {
+			t.Errorf("wall +%v, monotonic +%v: jump %v, jumped %v", tc.wall, tc.mono, jump, !tc.jumped)
+		}
+	}
+}
+
+// TestDTNRearmAfterSuspend stands a job's submission two hours back, as a
+// suspend leaves it against its monotonic timer, and checks rearm fetches
+// it at once while a job still in transit keeps waiting.
+func TestDTNRearmAfterSuspend(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello from space")
+	}))
+	defer dest.Close()
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(time.Hour)
+
+	send := func() string {
+		code, out := dtnSend(t, s, "mars.latency.space", fmt.Sprintf(`{"url":%q}`, dest.URL))
+		id, _ := out["id"].(string)
+		if code != http.StatusAccepted || id == "" {
+			t.Fatalf("send: %d %v", code, out)
+		}
+		return id
+	}
+	asleep, waiting := send(), send()
+
+	s.dtn.mu.Lock()
+	s.dtn.jobs[asleep].SubmittedAt = s.dtn.jobs[asleep].SubmittedAt.Add(-2 * time.Hour)
+	s.dtn.mu.Unlock()
+	if _, out := dtnStatus(t, s, asleep); out["state"] != "arriving" {
+		t.Fatalf("after the suspend: state %v, want arriving", out["state"])
+	}
+
+	s.dtn.rearm()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if j, _ := s.dtn.Get(asleep); j.Fetched {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the arrived job wasn't fetched after rearm")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if j, _ := s.dtn.Get(waiting); j.Fetched {
+		t.Error("rearm fetched a job still in transit")
+	}
+}
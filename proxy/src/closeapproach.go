@@ -0,0 +1,288 @@
+// closeapproach.go - near-Earth asteroid flybys.
+//
+// A near-Earth asteroid's distance swings by orders of magnitude: Apophis
+// passes within 32,000 km of Earth in April 2029, a tenth of a second of
+// light time, well under the latency floor that keeps this from being an
+// open proxy. For every near-Earth asteroid (perihelion inside
+// neaPerihelionAU) the Earth distance is sampled every closeApproachStep
+// over -close-approach-horizon, and each local minimum is refined to
+// forecastResolution. The next one is shown on the body's info page and
+// in /api/bodies.
+//
+// While such a body is under the floor the proxy paths refuse it with
+// BodyTooCloseError (code BODY_TOO_CLOSE in JSON) rather than the generic
+// insufficient-latency refusal: it says the body is passing close to
+// Earth, how close, and when its light time is back over the floor.
+// /api/ping and the demo endpoints don't proxy anything, so they keep
+// answering and show the short light time.
+//
+// The prediction is the model's: Keplerian elements without planetary
+// perturbations, so a flyby's date and distance are only as good as the
+// body's elements. Tracks are cached per body and UTC day, like forecasts.
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+const (
+	neaPerihelionAU             = 1.3           // perihelion of a near-Earth object
+	closeApproachStep           = 6 * time.Hour // Earth distance sampling interval
+	defaultCloseApproachHorizon = 2 * 365 * 24 * time.Hour
+	maxCloseApproachHorizon     = 20 * 365 * 24 * time.Hour
+	closeApproachCacheSize      = 64 // tracks kept
+)
+
+// bodyTooCloseCode is the structured error code for a body under the
+// latency floor on a close approach.
+const bodyTooCloseCode = "BODY_TOO_CLOSE"
+
+// closeApproachHorizon is how far ahead close approaches are looked for
+// (-close-approach-horizon).
+var closeApproachHorizon = defaultCloseApproachHorizon
+
+// CloseApproach is a near-Earth asteroid's least distance from Earth on
+// one pass.
+type CloseApproach struct {
+	Time     time.Time
+	Distance float64       // km, light-time corrected
+	Latency  time.Duration // one-way light time at Time
+}
+
+// CloseApproachInfo is a CloseApproach in JSON.
+type CloseApproachInfo struct {
+	Time     time.Time `json:"time"`
+	Distance float64   `json:"distance_km"`
+	OneWay   float64   `json:"one_way_seconds"`
+}
+
+// isNearEarthAsteroid reports whether obj is an asteroid whose orbit comes
+// within neaPerihelionAU of the Sun.
+func isNearEarthAsteroid(obj celestial.CelestialObject) bool {
+	return obj.Type == "asteroid" && obj.ParentName == "Sun" && obj.A > 0 && obj.A*(1-obj.E) < neaPerihelionAU
+}
+
+// closeApproachTrack is a body's Earth distance sampled over a horizon,
+// and the close approaches in it.
+type closeApproachTrack struct {
+	start    time.Time
+	distance []float64 // km at start + i*closeApproachStep
+	passes   []CloseApproach
+}
+
+type closeApproachKey struct {
+	body    string
+	start   int64 // Unix seconds of the first midnight
+	horizon time.Duration
+}
+
+type closeApproachEntry struct {
+	objects *[]celestial.CelestialObject // the object list it was solved from
+	track   *closeApproachTrack
+}
+
+var (
+	closeApproachMu    sync.Mutex
+	closeApproachCache = make(map[closeApproachKey]closeApproachEntry)
+)
+
+// cachedCloseApproaches returns obj's track from the UTC day containing
+// now, or nil if obj isn't a near-Earth asteroid.
+func cachedCloseApproaches(obj celestial.CelestialObject, now time.Time) *closeApproachTrack {
+	if !isNearEarthAsteroid(obj) {
+		return nil
+	}
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	objects := celestialObjectsPtr.Load()
+	key := closeApproachKey{obj.Name, start.Unix(), closeApproachHorizon}
+
+	closeApproachMu.Lock()
+	defer closeApproachMu.Unlock()
+	if e, ok := closeApproachCache[key]; ok && e.objects == objects {
+		return e.track
+	}
+	track := computeCloseApproaches(*objects, obj, start, closeApproachHorizon)
+	if len(closeApproachCache) >= closeApproachCacheSize {
+		for k := range closeApproachCache { // evict an arbitrary entry
+			delete(closeApproachCache, k)
+			break
+		}
+	}
+	closeApproachCache[key] = closeApproachEntry{objects, track}
+	return track
+}
+
+// computeCloseApproaches samples obj's Earth distance from start over
+// horizon and finds its minima.
+func computeCloseApproaches(objects []celestial.CelestialObject, obj celestial.CelestialObject, start time.Time, horizon time.Duration) *closeApproachTrack {
+	earth, ok := findObjectByName(objects, "Earth")
+	if !ok {
+		return &closeApproachTrack{start: start}
+	}
+	distance := func(t time.Time) float64 { return ApparentDistance(earth, obj, objects, t) }
+	track := &closeApproachTrack{start: start}
+	for t := start; !t.After(start.Add(horizon)); t = t.Add(closeApproachStep) {
+		track.distance = append(track.distance, distance(t))
+	}
+	d := track.distance
+	for i := 1; i+1 < len(d); i++ {
+		if d[i] < d[i-1] && d[i] <= d[i+1] {
+			track.passes = append(track.passes, closestBetween(track.at(i-1), track.at(i+1), distance))
+		}
+	}
+	return track
+}
+
+// closestBetween returns the least distance between lo and hi, to
+// forecastResolution, given the distance falls then rises between them.
+func closestBetween(lo, hi time.Time, distance func(time.Time) float64) CloseApproach {
+	for hi.Sub(lo) > forecastResolution {
+		third := (hi.Sub(lo) / 3).Truncate(forecastResolution)
+		if third == 0 {
+			third = forecastResolution
+		}
+		a, b := lo.Add(third), hi.Add(-third)
+		if distance(a) < distance(b) {
+			hi = b
+		} else {
+			lo = a
+		}
+	}
+	best, km := lo, distance(lo)
+	if d := distance(hi); d < km {
+		best, km = hi, d
+	}
+	return CloseApproach{Time: best, Distance: km, Latency: CalculateLatency(km)}
+}
+
+func (t *closeApproachTrack) at(i int) time.Time {
+	return t.start.Add(time.Duration(i) * closeApproachStep)
+}
+
+// next returns the first close approach at or after now.
+func (t *closeApproachTrack) next(now time.Time) (CloseApproach, bool) {
+	for _, p := range t.passes {
+		if !p.Time.Before(now) {
+			return p, true
+		}
+	}
+	return CloseApproach{}, false
+}
+
+// nextCloseApproach returns obj's next close approach after now within
+// the horizon; ok is false for anything but a near-Earth asteroid.
+func nextCloseApproach(obj celestial.CelestialObject, now time.Time) (CloseApproach, bool) {
+	track := cachedCloseApproaches(obj, now)
+	if track == nil {
+		return CloseApproach{}, false
+	}
+	return track.next(now)
+}
+
+// closeApproachInfo is obj's next close approach for /api/bodies, or nil.
+func closeApproachInfo(obj celestial.CelestialObject, now time.Time) *CloseApproachInfo {
+	p, ok := nextCloseApproach(obj, now)
+	if !ok {
+		return nil
+	}
+	return &CloseApproachInfo{
+		Time:     p.Time,
+		Distance: float64(int64(p.Distance)),
+		OneWay:   roundedSeconds(p.Latency),
+	}
+}
+
+// BodyTooCloseError is why a near-Earth asteroid under the latency floor
+// is refused.
+type BodyTooCloseError struct {
+	Body    string
+	Latency time.Duration // one-way light time now
+	Floor   time.Duration
+	Pass    *CloseApproach // the approach under way, if on the track
+	Resumes time.Time      // when the light time is back over Floor; zero if not within the horizon
+}
+
+func (e *BodyTooCloseError) Error() string {
+	msg := fmt.Sprintf("%s is passing close to Earth: its light time is %s, under the %s a body needs to be proxied (any less and this would be an open proxy)",
+		e.Body, displayDuration(e.Latency), displayDuration(e.Floor))
+	if e.Pass != nil {
+		msg += fmt.Sprintf(". Closest approach %s, %.0f km away", e.Pass.Time.UTC().Format("2006-01-02 15:04 UTC"), e.Pass.Distance)
+	}
+	if e.Resumes.IsZero() {
+		return msg + ". It won't be far enough away again within " + closeApproachHorizon.String()
+	}
+	return msg + ". Service resumes " + e.Resumes.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// fields is e for a JSON error body.
+func (e *BodyTooCloseError) fields() map[string]any {
+	out := map[string]any{"error": e.Error(), "code": bodyTooCloseCode, "oneWaySeconds": roundedSeconds(e.Latency)}
+	if e.Pass != nil {
+		out["closeApproach"] = CloseApproachInfo{e.Pass.Time, float64(int64(e.Pass.Distance)), roundedSeconds(e.Pass.Latency)}
+	}
+	if !e.Resumes.IsZero() {
+		out["resumes"] = e.Resumes.UTC()
+	}
+	return out
+}
+
+// checkLatencyFloor refuses body when latency is under floor: a near-Earth
+// asteroid with a BodyTooCloseError, anything else with the generic
+// refusal.
+func checkLatencyFloor(body string, latency, floor time.Duration) error {
+	if latency >= floor {
+		return nil
+	}
+	obj, ok := findObjectByName(getCelestialObjects(), body)
+	if !ok || !isNearEarthAsteroid(obj) {
+		return fmt.Errorf("%s has insufficient latency to proxy (it would be an open proxy)", body)
+	}
+	now := simTime(distanceClock())
+	err := &BodyTooCloseError{Body: obj.Name, Latency: latency, Floor: floor}
+	track := cachedCloseApproaches(obj, now)
+	err.Resumes = track.resumes(obj, now, floor)
+	for i, p := range track.passes {
+		if p.Latency < floor && (err.Pass == nil || absDuration(p.Time.Sub(now)) < absDuration(err.Pass.Time.Sub(now))) {
+			err.Pass = &track.passes[i] // the pass under the floor nearest now
+		}
+	}
+	return err
+}
+
+// resumes returns the first instant after now, to forecastResolution, at
+// which obj's light time is at least floor, or zero if that isn't within
+// the track.
+func (t *closeApproachTrack) resumes(obj celestial.CelestialObject, now time.Time, floor time.Duration) time.Time {
+	objects := getCelestialObjects()
+	earth, ok := findObjectByName(objects, "Earth")
+	if !ok {
+		return time.Time{}
+	}
+	far := func(at time.Time) bool { return CalculateLatency(ApparentDistance(earth, obj, objects, at)) >= floor }
+	for i, km := range t.distance {
+		at := t.at(i)
+		if at.After(now) && CalculateLatency(km) >= floor {
+			return firstChange(maxTime(now, at.Add(-closeApproachStep)), at, far)
+		}
+	}
+	return time.Time{}
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// apophis2029 returns the model's 2029 Apophis close approach. The model's
+// elements put it at 72 million km in June, not the real 32,000 km in
+// April, so tests raise the latency floor above its light time instead.
+func apophis2029(t *testing.T) CloseApproach {
+	t.Helper()
+	apophis, _ := findObjectByName(getCelestialObjects(), "Apophis")
+	pass, ok := nextCloseApproach(apophis, time.Date(2029, 6, 1, 0, 0, 0, 0, time.UTC))
+	if !ok {
+		t.Fatal("no Apophis close approach after 2029-06-01")
+	}
+	if pass.Time.Format("2006-01-02") != "2029-06-21" || pass.Distance < 70e6 || pass.Distance > 75e6 {
+		t.Fatalf("Apophis closest %v at %.0f km; want 2029-06-21, about 72 million km", pass.Time, pass.Distance)
+	}
+	return pass
+}
+
+// TestCloseApproaches checks only near-Earth asteroids get close
+// approaches, and that each is the least distance around it.
+func TestCloseApproaches(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	withObjects(t, objects)
+	for _, tc := range []struct {
+		name string
+		nea  bool
+	}{{"Apophis", true}, {"Bennu", true}, {"Didymos", true}, {"Vesta", false}, {"Mars", false}, {"Moon", false}} {
+		obj, _ := findObjectByName(objects, tc.name)
+		if got := isNearEarthAsteroid(obj); got != tc.nea {
+			t.Errorf("%s: near-Earth asteroid %v, want %v", tc.name, got, tc.nea)
+		}
+	}
+
+	pass := apophis2029(t)
+	apophis, _ := findObjectByName(objects, "Apophis")
+	earth, _ := findObjectByName(objects, "Earth")
+	for _, d := range []time.Duration{-time.Hour, -forecastResolution, forecastResolution, time.Hour} {
+		if km := ApparentDistance(earth, apophis, objects, pass.Time.Add(d)); km < pass.Distance {
+			t.Errorf("%v from the approach: %.0f km, closer than %.0f", d, km, pass.Distance)
+		}
+	}
+	if pass.Latency != CalculateLatency(pass.Distance) {
+		t.Errorf("latency %v for %.0f km", pass.Latency, pass.Distance)
+	}
+}
+
+// TestBodyTooClose pins the epoch at the model's 2029 Apophis approach with
+// a floor over its light time: the proxy paths refuse it with
+// BODY_TOO_CLOSE and when service resumes, its info page and /api/bodies
+// show the approach, and /api/ping still answers.
+func TestBodyTooClose(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	pass := apophis2029(t)
+	withPinnedEpoch(t, pass.Time)
+	withPageTemplates(t)
+	const floor = 5 * time.Minute
+
+	s := newDTNTestServer(t)
+	s.security.minLatency = floor
+	code, out := dtnSend(t, s, "apophis.latency.space", `{"url":"https://example.com/"}`)
+	if code != http.StatusBadRequest || out["code"] != bodyTooCloseCode {
+		t.Fatalf("DTN via Apophis: %d %v", code, out)
+	}
+	if msg, _ := out["error"].(string); !strings.Contains(msg, "passing close to Earth") || !strings.Contains(msg, "Service resumes") {
+		t.Errorf("error %q", msg)
+	}
+	approach, _ := out["closeApproach"].(map[string]interface{})
+	if approach["time"] != pass.Time.Format(time.RFC3339) {
+		t.Errorf("closeApproach %v, want the one at %v", approach, pass.Time)
+	}
+	resumes, err := time.Parse(time.RFC3339, out["resumes"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apophis, _ := findObjectByName(getCelestialObjects(), "Apophis")
+	earth, _ := findObjectByName(getCelestialObjects(), "Earth")
+	latencyAt := func(at time.Time) time.Duration {
+		return CalculateLatency(ApparentDistance(earth, apophis, getCelestialObjects(), at))
+	}
+	if !resumes.After(pass.Time) || latencyAt(resumes) < floor || latencyAt(resumes.Add(-forecastResolution)) >= floor {
+		t.Errorf("resumes %v: light time %v there, %v a minute before", resumes, latencyAt(resumes), latencyAt(resumes.Add(-forecastResolution)))
+	}
+
+	// A body that is always close gets the generic refusal.
+	if code, out := dtnSend(t, s, "moon.latency.space", `{"url":"https://example.com/"}`); code != http.StatusBadRequest || out["code"] != nil {
+		t.Errorf("DTN via the Moon: %d %v", code, out)
+	}
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+	page := get("http://apophis.latency.space/")
+	if page.Code != http.StatusOK {
+		t.Fatalf("info page: %d", page.Code)
+	}
+	for _, want := range []string{
+		"Next Close Approach",
+		"Closest on: <strong>" + pass.Time.Format("Mon 2006-01-02 15:04 UTC"),
+		"Apophis is passing close to Earth",
+		"Proxying resumes " + resumes.Format("Mon 2006-01-02 15:04 UTC"),
+	} {
+		if !strings.Contains(page.Body.String(), want) {
+			t.Errorf("info page lacks %q", want)
+		}
+	}
+
+	var bodies BodiesResponse
+	if err := json.Unmarshal(get("http://latency.space/api/bodies").Body.Bytes(), &bodies); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range bodies.Bodies {
+		switch b.Name {
+		case "Apophis":
+			if b.CloseApproach == nil || !b.CloseApproach.Time.Equal(pass.Time) {
+				t.Errorf("Apophis next_close_approach %+v", b.CloseApproach)
+			}
+		case "Mars":
+			if b.CloseApproach != nil {
+				t.Errorf("Mars has a close approach: %+v", b.CloseApproach)
+			}
+		}
+	}
+
+	var ping PingResponse
+	rec := get("http://latency.space/api/ping?body=apophis")
+	if err := json.Unmarshal(rec.Body.Bytes(), &ping); rec.Code != http.StatusOK || err != nil || ping.OneWaySeconds <= 0 || ping.OneWaySeconds >= floor.Seconds() {
+		t.Errorf("/api/ping for Apophis: %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,141 @@
+// coalesce.go - one upstream fetch for identical DTN GETs in flight together.
+//
+// A classroom produces thundering herds: thirty students send the same URL
+// via mars.latency.space within a second, and thirty identical fetches hit
+// the target as the jobs arrive. With -coalesce-gets, a job whose fetch
+// would start while an identical one is still running waits for it and
+// takes a copy of its response instead. Each job keeps its own timeline:
+// it arrives one light time after it was sent, and its copy of the
+// response is delivered one light time after the shared fetch finished.
+//
+// Jobs are identical when they go via the same body with the same URL and
+// the same headers. Only GETs are shared, and never with Authorization,
+// Cookie or Range headers: those responses belong to one client. A
+// response over coalesceMaxBytes isn't kept for sharing; the waiters fetch
+// for themselves.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// coalesceMaxBytes is the largest response body a shared fetch hands to
+// its waiters.
+const coalesceMaxBytes = 1 << 20
+
+// coalescedHeader marks a /dtn/status response whose body came from an
+// identical job's fetch.
+const coalescedHeader = "X-Latency-Space-Coalesced"
+
+// privateRequestHeaders make a request's response the client's own, so it
+// is never shared.
+var privateRequestHeaders = []string{"Authorization", "Cookie", "Range"}
+
+// fetchResult is the outcome of fetchWithRetry.
+type fetchResult struct {
+	status     int
+	headers    map[string]string
+	body       string
+	err, cause string
+	attempts   int
+}
+
+// fetchCall is a fetch in flight, and what it returned once done is
+// closed.
+type fetchCall struct {
+	done chan struct{}
+	res  fetchResult
+}
+
+// fetchGroup runs one fetch per key at a time and hands its result to
+// every caller that asked for the key meanwhile.
+type fetchGroup struct {
+	mu    sync.Mutex
+	calls map[string]*fetchCall
+}
+
+func newFetchGroup() *fetchGroup {
+	return &fetchGroup{calls: make(map[string]*fetchCall)}
+}
+
+// do runs fn for key, or waits for the run already under way; shared
+// reports the latter. Fetches are bounded by the upstream timeouts, so a
+// waiter doesn't wait forever.
+func (g *fetchGroup) do(key string, fn func() fetchResult) (res fetchResult, shared bool) {
+	g.mu.Lock()
+	if c, running := g.calls[key]; running {
+		g.mu.Unlock()
+		<-c.done
+		return c.res, true
+	}
+	c := &fetchCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.res = fn()
+	return c.res, false
+}
+
+// coalesceKey identifies a job's fetch for sharing; ok is false when the
+// fetch must not be shared.
+func coalesceKey(bodyName, method, rawURL string, headers map[string]string) (key string, ok bool) {
+	if method != http.MethodGet {
+		return "", false
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		for _, private := range privateRequestHeaders {
+			if strings.EqualFold(k, private) {
+				return "", false
+			}
+		}
+		if !strings.EqualFold(k, "host") { // fetch doesn't send it
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, k := range names {
+		h.Write([]byte(http.CanonicalHeaderKey(k) + ":" + headers[k] + "\n"))
+	}
+	return bodyName + "\x00" + rawURL + "\x00" + hex.EncodeToString(h.Sum(nil)), true
+}
+
+// coalescedFetch is fetchWithRetry, shared with identical jobs in flight
+// when coalescing is on. It also reports whether the result was another
+// job's. A GET with a body is never shared.
+func (s *DTNStore) coalescedFetch(ctx context.Context, bodyName string, oneWay time.Duration, method, rawURL string, headers map[string]string, body string) (fetchResult, bool) {
+	fetch := func() fetchResult {
+		status, rh, rb, fetchErr, cause, attempts := s.fetchWithRetry(ctx, bodyName, oneWay, method, rawURL, headers, body)
+		return fetchResult{status, rh, rb, fetchErr, cause, attempts}
+	}
+	key, ok := coalesceKey(bodyName, method, rawURL, headers)
+	if s.coalesce == nil || !ok || body != "" {
+		return fetch(), false
+	}
+	res, shared := s.coalesce.do(key, fetch)
+	if !shared {
+		return res, false
+	}
+	if len(res.body) > coalesceMaxBytes {
+		return fetch(), false
+	}
+	res.headers = maps.Clone(res.headers) // each job's copy is its own
+	res.attempts = 0                      // this job made no upstream requests
+	s.metrics.RecordCoalesced(bodyName, int64(len(res.body)))
+	return res, true
+}
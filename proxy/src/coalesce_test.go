@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestCoalesceGets fires a class's worth of identical DTN jobs at a slow,
+// counting target: with -coalesce-gets they cost one fetch between them,
+// and every job still gets the whole response on its own timeline. The
+// requests that belong to one client, and everything with coalescing off,
+// each get their own fetch.
+func TestCoalesceGets(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	var hits atomic.Int64
+	big := strings.Repeat("x", coalesceMaxBytes+1)
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(200 * time.Millisecond) // long enough for every job to arrive meanwhile
+		if r.URL.Path == "/big" {
+			fmt.Fprint(w, big)
+			return
+		}
+		fmt.Fprintf(w, "lesson %s", r.URL.Path)
+	}))
+	defer dest.Close()
+
+	const class = 8
+	for _, tc := range []struct {
+		name    string
+		off     bool
+		path    string
+		request string // the rest of the job's JSON
+		fetches int64
+	}{
+		{name: "get", path: "/orbit", fetches: 1},
+		{name: "off", off: true, path: "/orbit", fetches: class},
+		{name: "post", path: "/orbit", request: `,"method":"POST","body":"answer"`, fetches: class},
+		{name: "authorization", path: "/orbit", request: `,"headers":{"Authorization":"Bearer t"}`, fetches: class},
+		{name: "cookie", path: "/orbit", request: `,"headers":{"Cookie":"s=1"}`, fetches: class},
+		{name: "range", path: "/orbit", request: `,"headers":{"Range":"bytes=0-3"}`, fetches: class},
+		{name: "over the cap", path: "/big", fetches: class},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			hits.Store(0)
+			s := newDTNTestServer(t)
+			s.timing = fixedLatency(50 * time.Millisecond)
+			s.dtn.SetCoalesceGets(!tc.off)
+
+			ids := make([]string, class)
+			for i := range ids {
+				code, out := dtnSend(t, s, "mars.latency.space", fmt.Sprintf(`{"url":%q%s}`, dest.URL+tc.path, tc.request))
+				if code != http.StatusAccepted {
+					t.Fatalf("send: %d %v", code, out)
+				}
+				ids[i] = out["id"].(string)
+			}
+			var wg sync.WaitGroup
+			coalesced := make([]bool, class)
+			for i, id := range ids {
+				wg.Add(1)
+				go func(i int, id string) {
+					defer wg.Done()
+					rec := waitDTN(t, s, id)
+					var st struct {
+						Response struct{ Body string } `json:"response"`
+					}
+					if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+						t.Error(err)
+						return
+					}
+					want := "lesson " + tc.path
+					if tc.path == "/big" {
+						want = big
+					}
+					if st.Response.Body != want {
+						t.Errorf("job %d got %d bytes, want %d", i, len(st.Response.Body), len(want))
+					}
+					coalesced[i] = rec.Header().Get(coalescedHeader) == "true"
+				}(i, id)
+			}
+			wg.Wait()
+
+			if n := hits.Load(); n != tc.fetches {
+				t.Errorf("%d upstream fetches, want %d", n, tc.fetches)
+			}
+			shared := 0
+			for _, c := range coalesced {
+				if c {
+					shared++
+				}
+			}
+			m := recorded(t, s.metrics)
+			if want := class - int(tc.fetches); shared != want || m.Count(metricCoalesced, "Mars") != float64(want) {
+				t.Errorf("%d jobs marked coalesced, %v counted; want %d", shared, m.Count(metricCoalesced, "Mars"), want)
+			}
+			if tc.fetches == 1 && m.Count(metricCoalescedBytes, "Mars") != float64((class-1)*len("lesson /orbit")) {
+				t.Errorf("%v bytes saved, want %d", m.Count(metricCoalescedBytes, "Mars"), (class-1)*len("lesson /orbit"))
+			}
+			if n := m.Count(metricRequests, "Mars", "dtn"); n != class {
+				t.Errorf("%v requests recorded, want one per job", n)
+			}
+		})
+	}
+}
+
+// TestCoalesceKey checks which jobs count as identical.
+func TestCoalesceKey(t *testing.T) {
+	key := func(body, method, url string, headers map[string]string) string {
+		k, _ := coalesceKey(body, method, url, headers)
+		return k
+	}
+	base := key("Mars", http.MethodGet, "https://example.com/", map[string]string{"Accept": "text/html"})
+	if base == "" {
+		t.Fatal("a plain GET isn't coalesced")
+	}
+	if k := key("Mars", http.MethodGet, "https://example.com/", map[string]string{"accept": "text/html", "Host": "x"}); k != base {
+		t.Error("header name case or Host changed the key")
+	}
+	for name, k := range map[string]string{
+		"another body":    key("Venus", http.MethodGet, "https://example.com/", map[string]string{"Accept": "text/html"}),
+		"another URL":     key("Mars", http.MethodGet, "https://example.com/a", map[string]string{"Accept": "text/html"}),
+		"another header":  key("Mars", http.MethodGet, "https://example.com/", map[string]string{"Accept": "text/plain"}),
+		"no headers":      key("Mars", http.MethodGet, "https://example.com/", nil),
+		"an extra header": key("Mars", http.MethodGet, "https://example.com/", map[string]string{"Accept": "text/html", "Accept-Language": "de"}),
+	} {
+		if k == base {
+			t.Errorf("%s gave the same key", name)
+		}
+	}
+	for _, tc := range []struct {
+		method  string
+		headers map[string]string
+	}{
+		{http.MethodHead, nil},
+		{http.MethodPost, nil},
+		{http.MethodGet, map[string]string{"authorization": "Basic x"}},
+		{http.MethodGet, map[string]string{"Cookie": "a=b"}},
+		{http.MethodGet, map[string]string{"Range": "bytes=0-"}},
+	} {
+		if _, ok := coalesceKey("Mars", tc.method, "https://example.com/", tc.headers); ok {
+			t.Errorf("%s %v is coalesced", tc.method, tc.headers)
+		}
+	}
+}
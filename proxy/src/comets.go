@@ -0,0 +1,158 @@
+// comets.go - positions of comets from their cometary elements.
+//
+// A comet is catalogued by its perihelion distance q and time of perihelion
+// passage Tp rather than by a semi-major axis and mean longitude (see
+// celestial.DeriveCometElements). Comets on ordinary ellipses, such as 67P,
+// are then placed by Kepler's equation like any asteroid. Near e = 1 that
+// breaks down: Kepler's equation converges slowly above e ≈ 0.95, and a
+// parabolic or hyperbolic orbit has no ellipse at all. Comets with
+// e > nearParabolicE - Halley at 0.967, or C/2023 A3 just past 1 - are
+// placed instead by the universal-variable form of Kepler's equation, which
+// is the same function of time since perihelion on either side of e = 1.
+package main
+
+import (
+	"math"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+const (
+	// nearParabolicE is the eccentricity above which a comet is placed by
+	// the universal variable rather than Kepler's equation.
+	nearParabolicE = 0.95
+	// gmSun is the Sun's gravitational parameter in AU³/day², the one
+	// implied by the period rule used for derived elements (P = A^1.5 Julian
+	// years), so both paths agree on where a comet is.
+	gmSun = (2 * math.Pi / 365.25) * (2 * math.Pi / 365.25)
+	// universalMaxIterations bounds the safeguarded Newton solve.
+	universalMaxIterations = 100
+)
+
+// cometElementsAt returns comet obj's elements at time T (centuries from
+// J2000). Besides a, e and the angles they carry q and the days since
+// perihelion, which nearParabolic orbits are placed from. a is negative for
+// a hyperbolic orbit and 0 for an exact parabola; M is the hyperbolic mean
+// anomaly past e = 1 and 0 at it.
+func cometElementsAt(obj celestial.CelestialObject, T float64) orbitalElements {
+	el := orbitalElements{
+		e:    obj.E,
+		i:    degToRad(obj.I),
+		w:    degToRad(obj.W),
+		node: degToRad(obj.N),
+		q:    obj.Q,
+		dt:   T*celestial.DAYS_PER_CENTURY + celestial.J2000_EPOCH - obj.TP,
+	}
+	if el.e == 1 {
+		return el
+	}
+	el.a = el.q / (1 - el.e)
+	n := math.Sqrt(gmSun / math.Abs(el.a*el.a*el.a))
+	if el.e < 1 {
+		el.M = normalizeRadians(n * el.dt)
+	} else {
+		el.M = n * el.dt
+	}
+	return el
+}
+
+// nearParabolic reports whether el is placed by the universal variable.
+func (el orbitalElements) nearParabolic() bool {
+	return el.q > 0 && el.e > nearParabolicE
+}
+
+// atMeanAnomaly returns el moved along its orbit to mean anomaly M, keeping
+// the time since perihelion in step for a near-parabolic ellipse.
+func (el orbitalElements) atMeanAnomaly(M float64) orbitalElements {
+	el.M = M
+	if el.nearParabolic() && el.e < 1 {
+		el.dt = M / math.Sqrt(gmSun/(el.a*el.a*el.a))
+	}
+	return el
+}
+
+// stumpff returns the Stumpff functions C(z) and S(z), by their series near
+// z = 0 where the closed forms lose their precision.
+func stumpff(z float64) (c, s float64) {
+	switch {
+	case math.Abs(z) < 1e-3:
+		c = 1.0/2 - z/24 + z*z/720 - z*z*z/40320
+		s = 1.0/6 - z/120 + z*z/5040 - z*z*z/362880
+	case z > 0:
+		sz := math.Sqrt(z)
+		c = (1 - math.Cos(sz)) / z
+		s = (sz - math.Sin(sz)) / (sz * z)
+	default:
+		sz := math.Sqrt(-z)
+		c = (math.Cosh(sz) - 1) / -z
+		s = (math.Sinh(sz) - sz) / (sz * -z)
+	}
+	return c, s
+}
+
+// universalAnomaly solves the universal Kepler equation from perihelion,
+//
+//	√μ·dt = e·χ³·S(αχ²) + q·χ,  α = (1-e)/q,
+//
+// for the universal anomaly χ, dt days after perihelion. The left side grows
+// with χ at the rate r, the heliocentric distance, so it is bracketed and
+// solved by Newton's method falling back to bisection, which converges at any
+// eccentricity. An ellipse's dt is first reduced to within half a period of
+// perihelion.
+func universalAnomaly(q, e, dt float64) float64 {
+	alpha := (1 - e) / q
+	if e < 1 {
+		period := 2 * math.Pi / math.Sqrt(gmSun*alpha*alpha*alpha)
+		dt -= period * math.Round(dt/period)
+	}
+	sign := 1.0
+	if dt < 0 {
+		sign, dt = -1, -dt
+	}
+	target := math.Sqrt(gmSun) * dt
+	kepler := func(chi float64) (f, r float64) {
+		z := alpha * chi * chi
+		c, s := stumpff(z)
+		return e*chi*chi*chi*s + q*chi - target, e*chi*chi*c + q
+	}
+
+	lo, hi := 0.0, math.Max(target/q, 1e-9)
+	if e < 1 {
+		// Half a period is half a turn of the eccentric anomaly: χ = E/√α.
+		hi = math.Min(hi, math.Pi/math.Sqrt(alpha))
+	}
+	for f, _ := kepler(hi); f < 0; f, _ = kepler(hi) {
+		lo, hi = hi, 2*hi
+	}
+	chi := (lo + hi) / 2
+	for iter := 0; iter < universalMaxIterations; iter++ {
+		f, r := kepler(chi)
+		if math.Abs(f) <= 1e-14*(target+q) {
+			break
+		}
+		if f < 0 {
+			lo = chi
+		} else {
+			hi = chi
+		}
+		next := chi - f/r
+		if next <= lo || next >= hi {
+			next = (lo + hi) / 2
+		}
+		if next == chi {
+			break
+		}
+		chi = next
+	}
+	return sign * chi
+}
+
+// universalPerifocal places a body dt days after perihelion on the orbit
+// with perihelion distance q and eccentricity e, in the orbital plane with x
+// towards perihelion, in AU.
+func universalPerifocal(q, e, dt float64) (x, y float64) {
+	chi := universalAnomaly(q, e, dt)
+	z := (1 - e) / q * chi * chi
+	c, s := stumpff(z)
+	return q - chi*chi*c, math.Sqrt(q*(1+e)) * chi * (1 - z*s)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestKeplerHalleyEccentricity solves Kepler's equation at Halley's e =
+// 0.967 across the whole mean anomaly range, and checks the universal
+// variable places the comet where the ellipse does.
+func TestKeplerHalleyEccentricity(t *testing.T) {
+	const (
+		e = 0.967143
+		q = 0.587104
+	)
+	a := q / (1 - e)
+	n := math.Sqrt(gmSun / (a * a * a))
+	for k := 0; k < 3600; k++ {
+		M := 2 * math.Pi * float64(k) / 3600
+		E := solveKeplerEquation(M, e)
+		if res := math.Remainder(E-e*math.Sin(E)-M, 2*math.Pi); math.Abs(res) > 1e-12 {
+			t.Fatalf("M = %.4f: Kepler residual %g", M, res)
+		}
+		kx, ky := keplerPerifocal(a, e, M)
+		ux, uy := universalPerifocal(q, e, M/n)
+		if d := math.Hypot(kx-ux, ky-uy); d > 1e-9*a {
+			t.Fatalf("M = %.4f: universal variable %.3g AU from the ellipse", M, d)
+		}
+	}
+}
+
+// TestUniversalAcrossParabola checks the universal variable has no seam at
+// e = 1 and keeps a hyperbola on its conic: r = q(1+e)/(1+e cos ν).
+func TestUniversalAcrossParabola(t *testing.T) {
+	const q = 0.4
+	for _, dt := range []float64{-3000, -200, -1, 0, 5, 90, 4000} {
+		px, py := universalPerifocal(q, 1, dt)
+		for _, e := range []float64{1 - 1e-9, 1 + 1e-9} {
+			x, y := universalPerifocal(q, e, dt)
+			if d := math.Hypot(x-px, y-py); d > 1e-6*math.Hypot(px, py) {
+				t.Errorf("dt %v: e = %v is %.3g AU from the parabola", dt, e, d)
+			}
+		}
+		for _, e := range []float64{0.96, 1.04} {
+			x, y := universalPerifocal(q, e, dt)
+			r, nu := math.Hypot(x, y), math.Atan2(y, x)
+			if want := q * (1 + e) / (1 + e*math.Cos(nu)); math.Abs(r-want) > 1e-9*want {
+				t.Errorf("dt %v, e %v: r %.12f AU, conic says %.12f", dt, e, r, want)
+			}
+			if dt > 0 && y <= 0 || dt < 0 && y >= 0 {
+				t.Errorf("dt %v, e %v: y %v on the wrong side of perihelion", dt, e, y)
+			}
+		}
+	}
+}
+
+// TestCometDistances checks the seeded comets against their known
+// geometry: Halley at perihelion in February 1986 and near its 35 AU
+// aphelion now, and C/2023 A3's closest approach to Earth in October 2024.
+func TestCometDistances(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	objects := getCelestialObjects()
+	body := func(name string) celestial.CelestialObject {
+		obj, ok := findObjectByName(objects, name)
+		if !ok {
+			t.Fatalf("%s not found", name)
+		}
+		return obj
+	}
+	sun, earth, halley, atlas := body("Sun"), body("Earth"), body("Halley"), body("tsuchinshan-atlas")
+
+	au := func(a, b celestial.CelestialObject, at time.Time) float64 {
+		return CalculateDistance(a, b, objects, at) / celestial.AU
+	}
+	if r := au(sun, halley, time.Date(1986, 2, 9, 11, 0, 0, 0, time.UTC)); math.Abs(r-halley.Q) > 1e-4 {
+		t.Errorf("Halley %.5f AU from the Sun at perihelion, want %.5f", r, halley.Q)
+	}
+	if d := au(earth, halley, time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)); d < 33.5 || d > 36.5 {
+		t.Errorf("Halley %.2f AU from Earth in 2026, want about 35", d)
+	}
+	if d := au(earth, atlas, time.Date(2024, 10, 12, 14, 0, 0, 0, time.UTC)); d < 0.45 || d > 0.49 {
+		t.Errorf("C/2023 A3 %.3f AU from Earth at closest approach, want about 0.47", d)
+	}
+	if r := au(sun, atlas, time.Date(2035, 1, 1, 0, 0, 0, 0, time.UTC)); r < 20 {
+		t.Errorf("C/2023 A3 only %.1f AU from the Sun in 2035 on a hyperbolic orbit", r)
+	}
+
+	// 67P's Kepler path and its derived elements agree with the time since
+	// perihelion: it is back at perihelion one period after 2021-11-02.
+	p67 := body("67P")
+	next := time.Date(2021, 11, 2, 1, 0, 0, 0, time.UTC).Add(time.Duration(p67.Period * float64(24*time.Hour)))
+	if r := au(sun, p67, next); math.Abs(r-p67.Q) > 1e-3 {
+		t.Errorf("67P %.4f AU from the Sun one period after perihelion, want %.4f", r, p67.Q)
+	}
+}
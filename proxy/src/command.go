@@ -0,0 +1,182 @@
+// command.go - a simulated spacecraft command and its acknowledgement.
+//
+//	POST /api/command?body=voyager-1   transmit a command; returns its id
+//	GET  /api/command/{id}             in_transit, received, then acknowledged
+//
+// Deep-space operations time a command by the uplink light time to reach
+// the spacecraft and the downlink light time for its acknowledgement to
+// come back (see LightTimes). Nothing is sent anywhere: the server works
+// out when the command arrives and when the acknowledgement does, and the
+// status changes as its clock passes them. The body can also come from
+// the host (voyager-1.latency.space/api/command). Only spacecraft take
+// commands.
+//
+// Commands are kept in memory, at most commandMaxPending, until
+// commandRetention after they are acknowledged.
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	commandMaxPending = 1024
+	commandRetention  = time.Hour
+)
+
+// Command states.
+const (
+	commandInTransit    = "in_transit"   // travelling to the spacecraft
+	commandReceived     = "received"     // executed; the acknowledgement is on its way
+	commandAcknowledged = "acknowledged" // the acknowledgement has reached Earth
+)
+
+// errCommandStoreFull is returned by Add when every slot holds a command
+// that hasn't expired.
+var errCommandStoreFull = errors.New("command store is full")
+
+// Command is one simulated command.
+type Command struct {
+	ID          string
+	Body        string
+	Transmitted time.Time
+	LightTimes  LinkLightTimes
+}
+
+func (c *Command) arrivalAt() time.Time { return c.Transmitted.Add(c.LightTimes.Uplink) }
+func (c *Command) ackAt() time.Time     { return c.Transmitted.Add(c.LightTimes.RoundTrip) }
+
+// state is the command's stage at now.
+func (c *Command) state(now time.Time) string {
+	switch {
+	case now.Before(c.arrivalAt()):
+		return commandInTransit
+	case now.Before(c.ackAt()):
+		return commandReceived
+	}
+	return commandAcknowledged
+}
+
+// commandStore holds the commands in flight. The zero value is ready.
+type commandStore struct {
+	mu       sync.Mutex
+	commands map[string]*Command
+}
+
+// Add stores c, first dropping commands acknowledged more than
+// commandRetention before now.
+func (s *commandStore) Add(c *Command, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.commands == nil {
+		s.commands = make(map[string]*Command)
+	}
+	for id, old := range s.commands {
+		if now.Sub(old.ackAt()) > commandRetention {
+			delete(s.commands, id)
+		}
+	}
+	if len(s.commands) >= commandMaxPending {
+		return errCommandStoreFull
+	}
+	s.commands[c.ID] = c
+	return nil
+}
+
+// Get returns the command with id, unless it has expired by now.
+func (s *commandStore) Get(id string, now time.Time) (*Command, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.commands[id]
+	if !ok || now.Sub(c.ackAt()) > commandRetention {
+		return nil, false
+	}
+	return c, true
+}
+
+// CommandStatus is the JSON returned by /api/command.
+type CommandStatus struct {
+	ID               string    `json:"id"`
+	Body             string    `json:"body"`
+	State            string    `json:"state"`
+	Transmitted      time.Time `json:"command_transmitted"`
+	ExpectedArrival  time.Time `json:"expected_arrival"`
+	ExpectedAck      time.Time `json:"expected_ack_receipt"`
+	UplinkSeconds    float64   `json:"uplink_owlt_seconds"`
+	DownlinkSeconds  float64   `json:"downlink_owlt_seconds"`
+	RoundTripSeconds float64   `json:"round_trip_seconds"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+func commandStatus(c *Command, now time.Time) CommandStatus {
+	return CommandStatus{
+		ID:               c.ID,
+		Body:             c.Body,
+		State:            c.state(now),
+		Transmitted:      c.Transmitted.UTC(),
+		ExpectedArrival:  c.arrivalAt().UTC(),
+		ExpectedAck:      c.ackAt().UTC(),
+		UplinkSeconds:    roundedSeconds(c.LightTimes.Uplink),
+		DownlinkSeconds:  roundedSeconds(c.LightTimes.RoundTrip - c.LightTimes.Uplink),
+		RoundTripSeconds: roundedSeconds(c.LightTimes.RoundTrip),
+		Timestamp:        now.UTC(),
+	}
+}
+
+// handleCommand serves /api/command and /api/command/{id}.
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/command":
+		s.handleCommandSend(w, r)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/command/"):
+		now := s.now()
+		c, ok := s.commands.Get(strings.TrimPrefix(r.URL.Path, "/api/command/"), now)
+		if !ok {
+			writeJSONError(w, r, http.StatusNotFound, "error.command_unknown")
+			return
+		}
+		writeJSON(w, http.StatusOK, commandStatus(c, now))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, r, http.StatusMethodNotAllowed, "error.command_method")
+	}
+}
+
+func (s *Server) handleCommandSend(w http.ResponseWriter, r *http.Request) {
+	objects := getCelestialObjects()
+	name := strings.TrimSpace(r.URL.Query().Get("body"))
+	if name == "" {
+		name = s.resolveCelestialHost(objects, r.Host)
+	}
+	if name == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.command_body")
+		return
+	}
+	obj, ok := findObjectByName(objects, name)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotFound, "error.unknown_body_named", name)
+		return
+	}
+	if obj.Type != "spacecraft" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.command_not_spacecraft", obj.Name)
+		return
+	}
+
+	now := s.now()
+	lt, ok := LightTimes(obj, objects, simTime(now))
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": errDistanceUnavailable.Error()})
+		return
+	}
+	c := &Command{ID: newDTNID(), Body: obj.Name, Transmitted: now, LightTimes: lt}
+	if err := s.commands.Add(c, now); err != nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, "error.command_store_full")
+		return
+	}
+	w.Header().Set("Location", "/api/command/"+c.ID)
+	writeJSON(w, http.StatusAccepted, commandStatus(c, now))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestCommandLifecycle(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	clock := newFakeClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), timing: timing{clock: clock}}
+
+	do := func(method, url string) (*httptest.ResponseRecorder, CommandStatus) {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(method, url, nil))
+		var out CommandStatus
+		json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec, out
+	}
+
+	rec, sent := do(http.MethodPost, "http://voyager-1.latency.space/api/command")
+	if rec.Code != http.StatusAccepted || sent.State != commandInTransit || sent.Body != "Voyager 1" {
+		t.Fatalf("send: %d %s", rec.Code, rec.Body.String())
+	}
+	if loc := rec.Header().Get("Location"); loc != "/api/command/"+sent.ID {
+		t.Errorf("Location %q", loc)
+	}
+	if rtt := sent.ExpectedAck.Sub(sent.Transmitted).Seconds(); math.Abs(rtt-sent.UplinkSeconds-sent.DownlinkSeconds) > 0.01 || rtt < 86000 {
+		t.Errorf("round trip %v s, uplink %v s, downlink %v s", rtt, sent.UplinkSeconds, sent.DownlinkSeconds)
+	}
+
+	status := "http://latency.space/api/command/" + sent.ID
+	for _, step := range []struct {
+		at   time.Time
+		want string
+	}{
+		{sent.ExpectedArrival.Add(-time.Second), commandInTransit},
+		{sent.ExpectedArrival, commandReceived},
+		{sent.ExpectedAck.Add(-time.Second), commandReceived},
+		{sent.ExpectedAck, commandAcknowledged},
+	} {
+		clock.Advance(step.at.Sub(clock.Now()))
+		if rec, got := do(http.MethodGet, status); rec.Code != http.StatusOK || got.State != step.want {
+			t.Errorf("at %v: %d %q, want %q", step.at, rec.Code, got.State, step.want)
+		}
+	}
+	clock.Advance(commandRetention + time.Second)
+	if rec, _ := do(http.MethodGet, status); rec.Code != http.StatusNotFound {
+		t.Errorf("after retention: %d, want 404", rec.Code)
+	}
+
+	for url, want := range map[string]int{
+		"http://latency.space/api/command?body=mars":   http.StatusBadRequest,
+		"http://latency.space/api/command?body=vulcan": http.StatusNotFound,
+		"http://latency.space/api/command":             http.StatusBadRequest,
+	} {
+		if rec, _ := do(http.MethodPost, url); rec.Code != want {
+			t.Errorf("POST %s: %d, want %d", url, rec.Code, want)
+		}
+	}
+	if rec, _ := do(http.MethodGet, "http://latency.space/api/command"); rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("GET /api/command: %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestCommandStoreFull(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	lt := LinkLightTimes{Uplink: time.Minute, Downlink: time.Minute, RoundTrip: 2 * time.Minute}
+	var store commandStore
+	for i := 0; i < commandMaxPending; i++ {
+		if err := store.Add(&Command{ID: newDTNID(), Transmitted: now, LightTimes: lt}, now); err != nil {
+			t.Fatalf("command %d: %v", i, err)
+		}
+	}
+	if err := store.Add(&Command{ID: "one-too-many", Transmitted: now, LightTimes: lt}, now); err != errCommandStoreFull {
+		t.Fatalf("full store: %v", err)
+	}
+	// Once the others have been acknowledged and retained long enough,
+	// there is room again.
+	later := now.Add(lt.RoundTrip + commandRetention + time.Second)
+	if err := store.Add(&Command{ID: "room", Transmitted: later, LightTimes: lt}, later); err != nil {
+		t.Errorf("after expiry: %v", err)
+	}
+}
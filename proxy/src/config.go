@@ -0,0 +1,397 @@
+// config.go - the -config file: every command-line flag in one place.
+//
+// The file is a flat YAML mapping from flag names to values, e.g.
+//
+//	# /etc/latency-space/config.yaml
+//	socks-addr: ":1080"
+//	udp-loss-pct: "1,voyager-1=10"
+//	upstream-retries: 2
+//
+// Only that subset of YAML is read - one "name: value" per line, with
+// comments and single- or double-quoted values - so no third-party parser is
+// needed. A flag given on the command line beats the file, which beats the
+// flag's default. An unknown name is an error, so a misspelled setting
+// doesn't silently fall back to its default.
+//
+// SIGHUP re-reads the file and applies the settings in hotConfigKeys; the
+// rest (listen addresses, files, forwards...) need a restart, and a change
+// to one is logged and ignored.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hotConfigKeys are the settings a SIGHUP re-applies from the -config file.
+var hotConfigKeys = map[string]bool{
+	"debug":                 true,
+	"udp-max-pps":           true,
+	"udp-max-bytes-per-sec": true,
+	"udp-max-targets":       true,
+	"udp-max-queued":        true,
+	"udp-loss-pct":          true,
+	"udp-reorder-pct":       true,
+	"udp-dup-pct":           true,
+	"udp-degraded-loss-pct": true,
+	"block-crawlers":        true,
+	"crawler-agents":        true,
+	"status-stream-max":     true,
+}
+
+// secretConfigKeys are redacted by -print-config.
+var secretConfigKeys = map[string]bool{
+	"admin-token": true,
+}
+
+// notConfigKeys are flags about the config file itself, which it can't set.
+var notConfigKeys = map[string]bool{
+	"config":       true,
+	"print-config": true,
+}
+
+// configKeyPattern is what a setting name looks like.
+var configKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// configSource resolves a setting: the command line, else the -config file,
+// else the flag's default.
+type configSource struct {
+	path     string
+	fs       *flag.FlagSet
+	explicit map[string]bool   // set on the command line
+	file     map[string]string // read from path
+}
+
+// loadConfig reads the config file at path (none when empty) and sets each
+// of its flags in fs that wasn't given on the command line. Call it after
+// fs.Parse.
+func loadConfig(fs *flag.FlagSet, path string) (*configSource, error) {
+	c := &configSource{path: path, fs: fs, explicit: make(map[string]bool)}
+	fs.Visit(func(f *flag.Flag) { c.explicit[f.Name] = true })
+	if path == "" {
+		return c, nil
+	}
+	var err error
+	if c.file, err = readConfigFile(path, fs); err != nil {
+		return nil, err
+	}
+	for _, name := range sortedKeys(c.file) {
+		if c.explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, c.file[name]); err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+	}
+	return c, nil
+}
+
+// reread reads the file again, keeping the command line.
+func (c *configSource) reread() (*configSource, error) {
+	file, err := readConfigFile(c.path, c.fs)
+	if err != nil {
+		return nil, err
+	}
+	return &configSource{path: c.path, fs: c.fs, explicit: c.explicit, file: file}, nil
+}
+
+// value is the setting for the flag name, as text.
+func (c *configSource) value(name string) string {
+	f := c.fs.Lookup(name)
+	if f == nil {
+		return ""
+	}
+	if c.explicit[name] {
+		return f.Value.String()
+	}
+	if v, ok := c.file[name]; ok {
+		return v
+	}
+	return f.DefValue
+}
+
+// readConfigFile parses the file at path, checking every name is a flag in
+// fs.
+func readConfigFile(path string, fs *flag.FlagSet) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, name := range sortedKeys(values) {
+		if fs.Lookup(name) == nil || notConfigKeys[name] {
+			return nil, fmt.Errorf("%s: unknown setting %q (valid: %s)", path, name, strings.Join(configKeys(fs), ", "))
+		}
+	}
+	return values, nil
+}
+
+// parseConfig parses a flat YAML mapping of names to scalar values.
+func parseConfig(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: nested values are not supported", n)
+		}
+		name, rest, ok := strings.Cut(line, ":")
+		if !ok || !configKeyPattern.MatchString(name) {
+			return nil, fmt.Errorf("line %d: want \"name: value\"", n)
+		}
+		if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+			return nil, fmt.Errorf("line %d: want a space after %q", n, name+":")
+		}
+		v, err := parseConfigValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", n, name, err)
+		}
+		if _, dup := values[name]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", n, name)
+		}
+		values[name] = v
+	}
+	return values, sc.Err()
+}
+
+// parseConfigValue unquotes a scalar and strips a trailing comment.
+func parseConfigValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := closingQuote(s)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if err := configTrailer(s[end+1:]); err != nil {
+			return "", err
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' { // '' is a quote
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), configTrailer(s[i+1:])
+		}
+		return "", fmt.Errorf("unterminated string")
+	case strings.HasPrefix(s, "#"):
+		return "", nil
+	case strings.HasPrefix(s, "- "):
+		return "", fmt.Errorf("lists are not supported")
+	case s != "" && strings.ContainsRune("[{|>&*!%@`", rune(s[0])):
+		return "", fmt.Errorf("only plain, single- and double-quoted values are supported")
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// closingQuote is the index of the quote ending the double-quoted string at
+// the start of s, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// configTrailer checks nothing but a comment follows a quoted value.
+func configTrailer(s string) error {
+	if s = strings.TrimSpace(s); s != "" && !strings.HasPrefix(s, "#") {
+		return fmt.Errorf("unexpected %q after the closing quote", s)
+	}
+	return nil
+}
+
+// configKeys lists the settings a config file may hold.
+func configKeys(fs *flag.FlagSet) []string {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !notConfigKeys[f.Name] {
+			names = append(names, f.Name)
+		}
+	})
+	return names
+}
+
+// writeConfig writes fs's current settings in the -config format, with
+// secrets redacted, for -print-config.
+func writeConfig(w io.Writer, fs *flag.FlagSet) {
+	for _, name := range configKeys(fs) {
+		v := fs.Lookup(name).Value.String()
+		if secretConfigKeys[name] && v != "" {
+			v = "<redacted>"
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, configScalar(v))
+	}
+}
+
+// plainConfigScalar matches values written without quotes.
+var plainConfigScalar = regexp.MustCompile(`^[A-Za-z0-9_.][A-Za-z0-9_./+-]*$`)
+
+// configScalar is v as a config file value.
+func configScalar(v string) string {
+	if plainConfigScalar.MatchString(v) {
+		return v
+	}
+	return strconv.Quote(v)
+}
+
+// sortedKeys returns m's keys in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// defineHotFlags defines the flags in hotConfigKeys on fs.
+func defineHotFlags(fs *flag.FlagSet) {
+	fs.Bool("debug", false, "Log debug detail, such as where each request's host and client address came from")
+	fs.Float64("udp-max-pps", defaultUDPLimits.PacketsPerSec, "Max UDP packets/second per association (0 = unlimited)")
+	fs.Float64("udp-max-bytes-per-sec", defaultUDPLimits.BytesPerSec, "Max UDP payload bytes/second per association (0 = unlimited)")
+	fs.Int("udp-max-targets", defaultUDPLimits.MaxTargets, "Max distinct UDP destinations per association (0 = unlimited)")
+	fs.Int("udp-max-queued", defaultUDPLimits.MaxQueued, "Max UDP packets in flight per association, both ways; a full queue drops its oldest (0 = unlimited)")
+	fs.String("udp-loss-pct", "0", "UDP relay packet loss percentage per direction, with optional per-body overrides, e.g. 1,mars=2,voyager-1=10")
+	fs.String("udp-reorder-pct", "0", "UDP relay percentage of packets delivered after their successor (same syntax as -udp-loss-pct)")
+	fs.String("udp-dup-pct", "0", "UDP relay percentage of packets delivered twice (same syntax as -udp-loss-pct)")
+	fs.Float64("udp-degraded-loss-pct", defaultDegradedLossPct, "Extra UDP relay packet loss percentage while a body's line of sight grazes the Sun's limb")
+	fs.Bool("block-crawlers", false, "Reject requests from known crawler User-Agents with 403")
+	fs.String("crawler-agents", defaultCrawlerAgents, "Comma-separated User-Agent substrings blocked by -block-crawlers")
+	fs.Int("status-stream-max", defaultStatusStreamClients, "Max concurrent /api/status-stream clients (0 = unlimited)")
+}
+
+// hotSettings are the values of the flags in hotConfigKeys.
+type hotSettings struct {
+	debug           bool
+	udpLimits       UDPLimits
+	udpImpair       UDPImpairment
+	crawlers        *crawlerBlocker // nil unless -block-crawlers
+	statusStreamMax int
+}
+
+// hotSettingsFrom parses the hot settings, looking each up by flag name.
+func hotSettingsFrom(value func(name string) string, objects []CelestialObject) (hotSettings, error) {
+	var h hotSettings
+	var err error
+	fail := func(name string, err error) (hotSettings, error) {
+		return hotSettings{}, fmt.Errorf("-%s: %v", name, err)
+	}
+	if h.debug, err = strconv.ParseBool(value("debug")); err != nil {
+		return fail("debug", err)
+	}
+	if h.udpLimits.PacketsPerSec, err = strconv.ParseFloat(value("udp-max-pps"), 64); err != nil {
+		return fail("udp-max-pps", err)
+	}
+	if h.udpLimits.BytesPerSec, err = strconv.ParseFloat(value("udp-max-bytes-per-sec"), 64); err != nil {
+		return fail("udp-max-bytes-per-sec", err)
+	}
+	if h.udpLimits.MaxTargets, err = strconv.Atoi(value("udp-max-targets")); err != nil {
+		return fail("udp-max-targets", err)
+	}
+	if h.udpLimits.MaxQueued, err = strconv.Atoi(value("udp-max-queued")); err != nil {
+		return fail("udp-max-queued", err)
+	}
+	for name, dst := range map[string]*bodyPercent{
+		"udp-loss-pct":    &h.udpImpair.Loss,
+		"udp-reorder-pct": &h.udpImpair.Reorder,
+		"udp-dup-pct":     &h.udpImpair.Dup,
+	} {
+		if *dst, err = parseBodyPercent(value(name), objects); err != nil {
+			return fail(name, err)
+		}
+	}
+	if h.udpImpair.DegradedLoss, err = strconv.ParseFloat(value("udp-degraded-loss-pct"), 64); err != nil {
+		return fail("udp-degraded-loss-pct", err)
+	}
+	block, err := strconv.ParseBool(value("block-crawlers"))
+	if err != nil {
+		return fail("block-crawlers", err)
+	}
+	if block {
+		h.crawlers = newCrawlerBlocker(value("crawler-agents"))
+	}
+	if h.statusStreamMax, err = strconv.Atoi(value("status-stream-max")); err != nil {
+		return fail("status-stream-max", err)
+	}
+	return h, nil
+}
+
+// applyHotSettings puts h into effect. Connections already open keep the
+// UDP settings they started with.
+func (s *Server) applyHotSettings(h hotSettings) {
+	debugLogging.Store(h.debug)
+	s.settingsMu.Lock()
+	s.udpLimits, s.udpImpair, s.crawlers = h.udpLimits, h.udpImpair, h.crawlers
+	s.settingsMu.Unlock()
+	if s.statusStream != nil {
+		s.statusStream.SetMax(h.statusStreamMax)
+	}
+}
+
+// crawlerBlocker returns the blocker in effect (nil allows all).
+func (s *Server) crawlerBlocker() *crawlerBlocker {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.crawlers
+}
+
+// reloadConfig re-reads the -config file and applies its hot settings. It
+// returns the other settings whose change was ignored. A file that fails to
+// parse changes nothing.
+func (s *Server) reloadConfig() ([]string, error) {
+	next, err := s.config.reread()
+	if err != nil {
+		return nil, err
+	}
+	h, err := hotSettingsFrom(next.value, getCelestialObjects())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", next.path, err)
+	}
+	var ignored []string
+	for _, name := range configKeys(next.fs) {
+		if !hotConfigKeys[name] && next.value(name) != s.config.value(name) {
+			ignored = append(ignored, name)
+		}
+	}
+	s.applyHotSettings(h)
+	log.Printf("Reloaded settings from %s", next.path)
+	if len(ignored) > 0 {
+		log.Printf("Changes to %s need a restart; ignored", strings.Join(ignored, ", "))
+	}
+	return ignored, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// testFlags is a flag set like the proxy's: the hot flags plus a few that
+// need a restart.
+func testFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	fs := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	fs.String("socks-addr", ":1080", "")
+	fs.Int("upstream-retries", 2, "")
+	fs.String("admin-token", "", "")
+	fs.String("config", "", "")
+	fs.Bool("print-config", false, "")
+	defineHotFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// writeConfigFile writes body to a config file and returns its path.
+func writeConfigFile(t *testing.T, path, body string) string {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "config.yaml")
+	}
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestConfigPrecedence checks a command-line flag beats the file, which
+// beats the default.
+func TestConfigPrecedence(t *testing.T) {
+	fs := testFlags(t, "-upstream-retries", "5")
+	path := writeConfigFile(t, "", `---
+# deployed settings
+upstream-retries: 0
+socks-addr: ":2080"   # behind the balancer
+udp-loss-pct: '1,mars=2'
+`)
+	c, err := loadConfig(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"upstream-retries": "5",        // command line
+		"socks-addr":       ":2080",    // file
+		"udp-loss-pct":     "1,mars=2", // file
+		"udp-max-targets":  "32",       // default
+	} {
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+		if got := c.value(name); got != want {
+			t.Errorf("value(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestConfigRejectsBadFiles checks unknown names and unsupported YAML are
+// errors, and that an unknown name's error lists the valid ones.
+func TestConfigRejectsBadFiles(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{"upstream-retry: 3\n", `unknown setting "upstream-retry" (valid: admin-token, block-crawlers,`},
+		{"config: other.yaml\n", `unknown setting "config"`},
+		{"udp:\n  max-pps: 10\n", "line 2: nested values are not supported"},
+		{"crawler-agents:\n- FooBot\n", "line 2: want \"name: value\""},
+		{"crawler-agents: [FooBot]\n", "only plain, single- and double-quoted values"},
+		{"socks-addr: \":2080\n", "unterminated string"},
+		{"socks-addr: \":2080\" x\n", "after the closing quote"},
+		{"debug: true\ndebug: false\n", "line 2: debug is set twice"},
+		{"upstream-retries: lots\n", "upstream-retries: parse error"},
+	} {
+		_, err := loadConfig(testFlags(t), writeConfigFile(t, "", tc.body))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got %v, want an error containing %q", tc.body, err, tc.want)
+		}
+	}
+}
+
+// TestPrintConfigRedactsSecrets checks -print-config shows the merged
+// settings in a form the file reads back, without the admin token.
+func TestPrintConfigRedactsSecrets(t *testing.T) {
+	fs := testFlags(t, "-admin-token", "s3cret", "-config", "x.yaml")
+	if _, err := loadConfig(fs, writeConfigFile(t, "", "socks-addr: \":2080\"\nudp-dup-pct: 1,mars=2\n")); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	writeConfig(&out, fs)
+	text := out.String()
+	if strings.Contains(text, "s3cret") || !strings.Contains(text, `admin-token: "<redacted>"`) {
+		t.Errorf("admin token not redacted:\n%s", text)
+	}
+	if strings.Contains(text, "config:") {
+		t.Errorf("printed the -config flags themselves:\n%s", text)
+	}
+
+	printed, err := parseConfig(out.Bytes())
+	if err != nil {
+		t.Fatalf("printed config doesn't parse: %v\n%s", err, text)
+	}
+	for name, want := range map[string]string{"socks-addr": ":2080", "udp-dup-pct": "1,mars=2", "upstream-retries": "2", "crawler-agents": defaultCrawlerAgents} {
+		if printed[name] != want {
+			t.Errorf("printed %s = %q, want %q", name, printed[name], want)
+		}
+	}
+}
+
+// TestConfigReloadAppliesHotSettings rewrites the file and reloads: hot
+// settings change, settings given on the command line and ones that need a
+// restart don't, and a bad file changes nothing.
+func TestConfigReloadAppliesHotSettings(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	defer debugLogging.Store(false)
+	fs := testFlags(t, "-udp-max-pps", "7")
+	path := writeConfigFile(t, "", "udp-max-targets: 4\nsocks-addr: \":2080\"\nudp-max-pps: 50\n")
+	c, err := loadConfig(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{statusStream: NewStatusStream(defaultStatusStreamClients), config: c}
+	h, err := hotSettingsFrom(c.value, getCelestialObjects())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.applyHotSettings(h)
+	if s.udpLimits.MaxTargets != 4 || s.udpLimits.PacketsPerSec != 7 || s.crawlerBlocker() != nil {
+		t.Fatalf("initial settings %+v, crawlers %v", s.udpLimits, s.crawlerBlocker())
+	}
+
+	writeConfigFile(t, path, `udp-max-targets: 9
+socks-addr: ":3080"
+upstream-retries: 4
+udp-max-pps: 50
+udp-loss-pct: 1,mars=5
+block-crawlers: true
+crawler-agents: FooBot
+status-stream-max: 3
+debug: true
+`)
+	ignored, err := s.reloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"socks-addr", "upstream-retries"}; !reflect.DeepEqual(ignored, want) {
+		t.Errorf("ignored %v, want %v", ignored, want)
+	}
+	if s.udpLimits.MaxTargets != 9 || s.udpLimits.PacketsPerSec != 7 {
+		t.Errorf("UDP limits %+v: want 9 targets from the file and 7 pps from the command line", s.udpLimits)
+	}
+	if got := s.udpImpair.Loss.forBody("Mars"); got != 5 {
+		t.Errorf("Mars loss %v%%, want 5", got)
+	}
+	if !s.crawlerBlocker().matches("FooBot/1.0") || s.crawlerBlocker().matches("Googlebot") {
+		t.Error("crawler list not replaced")
+	}
+	if s.statusStream.max != 3 || !debugLogging.Load() {
+		t.Errorf("status stream max %d, debug %v", s.statusStream.max, debugLogging.Load())
+	}
+	if got := fs.Lookup("socks-addr").Value.String(); got != ":2080" {
+		t.Errorf("socks-addr changed to %q without a restart", got)
+	}
+
+	writeConfigFile(t, path, "udp-max-targets: 1\nudp-dup-pct: nonsense\n")
+	if _, err := s.reloadConfig(); err == nil || !strings.Contains(err.Error(), "-udp-dup-pct") {
+		t.Errorf("bad file: %v", err)
+	}
+	if s.udpLimits.MaxTargets != 9 {
+		t.Errorf("a rejected file changed the settings: %+v", s.udpLimits)
+	}
+}
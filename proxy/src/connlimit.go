@@ -0,0 +1,162 @@
+// connlimit.go - a cap on open connections per client IP, counted at accept.
+//
+// The rate limiter (ratelimit.go) admits proxied sessions once a client has
+// said what it wants. This counts sockets as they are accepted, before a byte
+// is read, so it also bounds clients that never finish a request: slowloris
+// dribbling headers, or idle keep-alives. The HTTP, HTTPS and SOCKS
+// listeners share one ConnLimiter, so the cap is per IP across all three. A
+// connection over the cap is closed as soon as it is accepted.
+//
+// Behind a PROXY protocol balancer every peer is the balancer, so listeners
+// expecting PROXY headers are not capped here; the balancer should limit
+// connections per client instead. For the same reason HTTP connections from
+// -trusted-proxies (a reverse proxy sending X-Forwarded-For) are not
+// counted: they carry every client's requests.
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultConnsPerIP is the default -conns-per-ip: room for a browser's six
+// connections to each of a dozen hosts plus a few SOCKS tunnels.
+const defaultConnsPerIP = 100
+
+// ConnLimiter caps open connections per client IP. A nil *ConnLimiter
+// admits everything.
+type ConnLimiter struct {
+	max      int
+	rejected atomic.Int64
+
+	mu   sync.Mutex
+	open map[string]int
+}
+
+// NewConnLimiter allows max open connections per IP; max <= 0 disables the
+// cap and returns nil.
+func NewConnLimiter(max int) *ConnLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &ConnLimiter{max: max, open: make(map[string]int)}
+}
+
+// Listener wraps ln so its connections count against the cap, except those
+// from peers in exempt. A nil limiter returns ln unchanged.
+func (l *ConnLimiter) Listener(ln net.Listener, exempt []*net.IPNet) net.Listener {
+	if l == nil {
+		return ln
+	}
+	return &limitedListener{Listener: ln, limits: l, exempt: exempt}
+}
+
+// Max is the per-IP cap, 0 when there is none.
+func (l *ConnLimiter) Max() int {
+	if l == nil {
+		return 0
+	}
+	return l.max
+}
+
+// Rejected is how many connections have been closed for being over the cap.
+func (l *ConnLimiter) Rejected() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.rejected.Load()
+}
+
+// Open is how many connections ip has open.
+func (l *ConnLimiter) Open(ip string) int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.open[ip]
+}
+
+// acquire counts a new connection from ip, reporting false if ip is at the
+// cap.
+func (l *ConnLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[ip] >= l.max {
+		return false
+	}
+	l.open[ip]++
+	return true
+}
+
+func (l *ConnLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[ip] <= 1 {
+		delete(l.open, ip)
+		return
+	}
+	l.open[ip]--
+}
+
+// limitedListener closes connections from IPs over the cap as it accepts
+// them.
+type limitedListener struct {
+	net.Listener
+	limits *ConnLimiter
+	exempt []*net.IPNet // peers not counted: trusted reverse proxies
+}
+
+func (ln *limitedListener) Accept() (net.Conn, error) {
+	for {
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := clientIP(c.RemoteAddr().String())
+		if isTrustedProxy(ln.exempt, ip) {
+			return c, nil
+		}
+		if ln.limits.acquire(ip) {
+			return &limitedConn{Conn: c, limits: ln.limits, ip: ip}, nil
+		}
+		ln.limits.rejected.Add(1)
+		c.Close()
+	}
+}
+
+// limitedConn gives its slot back when closed.
+type limitedConn struct {
+	net.Conn
+	limits *ConnLimiter
+	ip     string
+	once   sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(func() { c.limits.release(c.ip) })
+	return c.Conn.Close()
+}
+
+// CloseWrite half-closes the underlying connection, if it can be.
+func (c *limitedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+// NetConn returns the accepted connection, for its TCP options.
+func (c *limitedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// tcpConnOf returns the TCP connection under c, looking through the cap.
+func tcpConnOf(c net.Conn) (*net.TCPConn, bool) {
+	if lc, ok := c.(*limitedConn); ok {
+		c = lc.Conn
+	}
+	tc, ok := c.(*net.TCPConn)
+	return tc, ok
+}
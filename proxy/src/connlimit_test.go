@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// TestConnLimitPerIP boots the Server with a cap of two connections per IP
+// and checks it is shared by the HTTP and SOCKS listeners: with a SOCKS
+// tunnel and an HTTP keep-alive open, a third connection to either is
+// closed at once, the tunnel keeps relaying, and closing it frees a slot.
+func TestConnLimitPerIP(t *testing.T) {
+	t.Setenv("METRICS_ADDR", "-")
+	withObjects(t, celestial.InitSolarSystemObjects())
+	s := &Server{
+		httpAddr:           "127.0.0.1:0",
+		socksAddr:          "127.0.0.1:0",
+		metrics:            NewRecordingMetrics(),
+		security:           newTestSecurity(),
+		limiter:            NewRateLimiter(6000, 100, 100, 100),
+		connLimit:          NewConnLimiter(2),
+		recent:             NewRecentLog(16, false),
+		udpLimits:          defaultUDPLimits,
+		sizeLimits:         defaultSizeLimits,
+		httpEnabled:        true,
+		socksEnabled:       true,
+		fixedCelestialBody: "Mars",
+		timing:             fixedLatency(20 * time.Millisecond),
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go s.Serve()
+	defer s.Stop()
+	httpAddr, socksAddr := s.httpListener.Addr().String(), s.socksListener.Addr().String()
+
+	echo := startEchoServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tunnel, _, err := client.Dial(ctx, socksAddr, echo.String())
+	if err != nil {
+		t.Fatalf("SOCKS dial: %v", err)
+	}
+	defer tunnel.Close()
+	tunnel.SetDeadline(time.Now().Add(5 * time.Second))
+	ping := func() {
+		t.Helper()
+		tunnel.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(tunnel, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("tunnel echo: %q, %v", buf, err)
+		}
+	}
+	ping()
+
+	keepAlive, err := net.Dial("tcp", httpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keepAlive.Close()
+	keepAlive.SetDeadline(time.Now().Add(5 * time.Second))
+	keepAlive.Write([]byte("GET /healthz HTTP/1.1\r\nHost: latency.space\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(keepAlive), nil)
+	if err != nil {
+		t.Fatalf("keep-alive request: %v", err)
+	}
+	resp.Body.Close()
+
+	for _, addr := range []string{httpAddr, socksAddr} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: latency.space\r\n\r\n"))
+		if n, err := conn.Read(make([]byte, 64)); err == nil || isTimeout(err) {
+			t.Errorf("third connection to %s: read %d bytes, %v; want it closed", addr, n, err)
+		}
+		conn.Close()
+	}
+	if got := s.connLimit.Rejected(); got != 2 {
+		t.Errorf("%d connections rejected, want 2", got)
+	}
+	ping()
+
+	tunnel.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.connLimit.Open("127.0.0.1") > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections still counted after the tunnel closed", s.connLimit.Open("127.0.0.1"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, err = http.Get("http://" + httpAddr + "/healthz")
+	if err != nil {
+		t.Fatalf("after a slot was freed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+// TestConnLimitTrustedProxy checks a trusted reverse proxy, which carries
+// every client's requests, may open more than the cap.
+func TestConnLimitTrustedProxy(t *testing.T) {
+	trusted, err := parseTrustedProxies("127.0.0.1/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{connLimit: NewConnLimiter(2), trustedProxies: trusted}
+	ln, err := s.listenHTTP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() { defer c.Close(); io.Copy(c, c) }()
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte("ping"))
+		if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+			t.Fatalf("connection %d from the trusted proxy: %v", i+1, err)
+		}
+	}
+	if got := s.connLimit.Rejected(); got != 0 {
+		t.Errorf("%d connections from the trusted proxy rejected, want none", got)
+	}
+}
@@ -0,0 +1,286 @@
+// contact.go - Deep Space Network contact windows.
+//
+// Real missions only get a few DSN passes a day; outside a pass nothing flows,
+// however good the geometry. -contact-schedule (or -contact-schedule-file)
+// gives bodies a weekly schedule of windows in UTC:
+//
+//	voyager-1=04:00-08:00UTC,16:00-18:00UTC;mars=Mon-Fri 09:00-17:00UTC
+//
+// A window may carry a day-of-week prefix (a day, a range like Mon-Fri, or
+// days joined with '+', e.g. Sat+Sun) and may run past midnight (22:00-02:00,
+// which belongs to the day it starts on). Bodies without a schedule, or with
+// "always", are always in contact.
+//
+// linkOutage is the single check used wherever occlusion is enforced, so every
+// path that drops an occluded link (including mid-session rechecks) also drops
+// it outside a contact window.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// noContactWindowCode is the structured error code for requests outside a pass.
+const noContactWindowCode = "NO_CONTACT_WINDOW"
+
+// linkClock is the time used for link checks; tests replace it.
+var linkClock = time.Now
+
+// linkTime is the instant link checks describe: linkClock, or the pinned
+// simulation epoch (epoch.go).
+func linkTime() time.Time {
+	return simTime(linkClock())
+}
+
+// checkLink is the link check used by the proxy paths; tests replace it to
+// flip a link mid-request.
+var checkLink = linkOutage
+
+// contactWindow is one daily pass, as offsets from midnight UTC. end may
+// exceed 24h for a pass that runs past midnight.
+type contactWindow struct {
+	days       uint8 // bit per time.Weekday the pass starts on
+	start, end time.Duration
+}
+
+// ContactSchedule is a body's set of contact windows. A nil schedule is
+// always in contact.
+type ContactSchedule struct {
+	windows []contactWindow
+}
+
+// Pass returns the window containing t, or else the next one to open. ok is
+// false for a nil schedule (always in contact).
+func (c *ContactSchedule) Pass(t time.Time) (start, end time.Time, ok bool) {
+	if c == nil || len(c.windows) == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	// Start a day back to catch a pass running past midnight; a week and a
+	// day ahead covers any weekly rule.
+	for d := -1; d <= 8; d++ {
+		day := midnight.AddDate(0, 0, d)
+		for _, w := range c.windows {
+			if w.days&(1<<uint(day.Weekday())) == 0 {
+				continue
+			}
+			ws, we := day.Add(w.start), day.Add(w.end)
+			if !t.Before(we) {
+				continue
+			}
+			if !ok || ws.Before(start) {
+				start, end, ok = ws, we, true
+			}
+		}
+		// Windows on later days can't open earlier than one already found.
+		if ok && !start.After(day.AddDate(0, 0, 1)) {
+			break
+		}
+	}
+	return start, end, ok
+}
+
+// InContact reports whether t falls inside a window.
+func (c *ContactSchedule) InContact(t time.Time) bool {
+	start, _, ok := c.Pass(t)
+	return !ok || !start.After(t)
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseDays parses "Mon", "Mon-Fri", "Fri-Mon" or "Sat+Sun" into a bitmask.
+func parseDays(spec string) (uint8, error) {
+	var mask uint8
+	for _, part := range strings.Split(spec, "+") {
+		from, to, isRange := strings.Cut(strings.ToLower(part), "-")
+		a, ok := weekdays[from]
+		if !ok {
+			return 0, fmt.Errorf("unknown day %q", from)
+		}
+		b := a
+		if isRange {
+			if b, ok = weekdays[to]; !ok {
+				return 0, fmt.Errorf("unknown day %q", to)
+			}
+		}
+		for d := a; ; d = (d + 1) % 7 {
+			mask |= 1 << uint(d)
+			if d == b {
+				break
+			}
+		}
+	}
+	return mask, nil
+}
+
+// parseClock parses "HH:MM" (24:00 allowed) as an offset from midnight.
+func parseClock(s string) (time.Duration, error) {
+	var h, m int
+	if n, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || n != 2 || len(s) != 5 {
+		return 0, fmt.Errorf("bad time %q (want HH:MM)", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parseContactWindow parses "[days ]HH:MM-HH:MM[UTC]".
+func parseContactWindow(spec string) (contactWindow, error) {
+	w := contactWindow{days: 0x7F}
+	spec = strings.TrimSpace(spec)
+	if days, rest, ok := strings.Cut(spec, " "); ok {
+		mask, err := parseDays(days)
+		if err != nil {
+			return w, err
+		}
+		w.days, spec = mask, strings.TrimSpace(rest)
+	}
+	spec = strings.TrimSuffix(strings.TrimSuffix(spec, "UTC"), "Z")
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return w, fmt.Errorf("bad window %q (want HH:MM-HH:MM)", spec)
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("empty window %q", spec)
+	}
+	if w.end < w.start {
+		w.end += 24 * time.Hour // runs past midnight
+	}
+	return w, nil
+}
+
+// parseContactSchedules parses "body=window,window;body=always". Body names
+// are resolved against objects and keyed by their canonical name.
+func parseContactSchedules(spec string, objects []CelestialObject) (map[string]*ContactSchedule, error) {
+	schedules := make(map[string]*ContactSchedule)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, windows, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want body=windows", entry)
+		}
+		obj, found := findObjectByName(objects, strings.TrimSpace(name))
+		if !found {
+			return nil, fmt.Errorf("%q: unknown body %q", entry, name)
+		}
+		if strings.EqualFold(strings.TrimSpace(windows), "always") {
+			schedules[obj.Name] = nil
+			continue
+		}
+		sched := &ContactSchedule{}
+		for _, ws := range strings.Split(windows, ",") {
+			w, err := parseContactWindow(ws)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", obj.Name, err)
+			}
+			sched.windows = append(sched.windows, w)
+		}
+		schedules[obj.Name] = sched
+	}
+	return schedules, nil
+}
+
+// loadContactScheduleFile reads one "body=windows" entry per line; blank
+// lines and '#' comments are ignored.
+func loadContactScheduleFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var entries []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return strings.Join(entries, ";"), sc.Err()
+}
+
+var contactSchedulesPtr atomic.Pointer[map[string]*ContactSchedule]
+
+// contactSchedule returns the schedule for a body (nil = always in contact).
+func contactSchedule(name string) *ContactSchedule {
+	if p := contactSchedulesPtr.Load(); p != nil {
+		return (*p)[name]
+	}
+	return nil
+}
+
+// setContactSchedules replaces all schedules; nil clears them.
+func setContactSchedules(m map[string]*ContactSchedule) {
+	contactSchedulesPtr.Store(&m)
+}
+
+// LinkOutage says why the Earth link to a body is down.
+type LinkOutage struct {
+	Occluder     string    // body in the line of sight, if occluded
+	BelowHorizon string    // ground station(s) that can't see the target (groundstation.go)
+	NextContact  time.Time // start of the next pass, if outside a contact window
+}
+
+func (o LinkOutage) String() string {
+	if o.Occluder != "" {
+		return "occluded by " + o.Occluder
+	}
+	if o.BelowHorizon != "" {
+		return "below the horizon of " + o.BelowHorizon
+	}
+	return "no DSN contact window until " + o.NextContact.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// outcome is the recent-log outcome for a request refused by this outage.
+func (o LinkOutage) outcome() string {
+	if o.Occluder != "" {
+		return outcomeOccluded
+	}
+	if o.BelowHorizon != "" {
+		return outcomeNoStation
+	}
+	return outcomeNoContact
+}
+
+// linkOutage reports whether the link from earth to target is down at t:
+// by occlusion, by no ground station seeing the target, or by being outside
+// the target's contact windows.
+func linkOutage(earth, target CelestialObject, objects []CelestialObject, t time.Time) (LinkOutage, bool) {
+	if v, occluder := IsOccluded(earth, target, objects, t); v.Blocked() {
+		return LinkOutage{Occluder: occluder.Name}, true
+	}
+	if outage, down := stationOutage(earth, target, objects, t); down {
+		return outage, true
+	}
+	return contactOutage(target.Name, t)
+}
+
+// contactOutage is the contact-window half of linkOutage.
+func contactOutage(name string, t time.Time) (LinkOutage, bool) {
+	sched := contactSchedule(name)
+	if sched.InContact(t) {
+		return LinkOutage{}, false
+	}
+	start, _, _ := sched.Pass(t)
+	return LinkOutage{NextContact: start}, true
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// fakeLinkClock points linkClock at a settable time for the test's duration.
+func fakeLinkClock(t *testing.T, start time.Time) func(time.Time) {
+	t.Helper()
+	var now atomic.Int64
+	now.Store(start.UnixNano())
+	orig := linkClock
+	linkClock = func() time.Time { return time.Unix(0, now.Load()).UTC() }
+	t.Cleanup(func() { linkClock = orig })
+	return func(t time.Time) { now.Store(t.UnixNano()) }
+}
+
+// withContactSchedules installs schedules parsed from spec for the test.
+func withContactSchedules(t *testing.T, spec string) {
+	t.Helper()
+	schedules, err := parseContactSchedules(spec, getCelestialObjects())
+	if err != nil {
+		t.Fatalf("parse %q: %v", spec, err)
+	}
+	setContactSchedules(schedules)
+	t.Cleanup(func() { setContactSchedules(nil) })
+}
+
+func TestContactSchedulePass(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	schedules, err := parseContactSchedules(
+		"voyager-1=04:00-08:00UTC,16:00-18:00UTC;mars=Mon-Fri 09:00-17:00UTC;jupiter=Sat 22:00-02:00;saturn=always",
+		getCelestialObjects())
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	// 2026-10-19 is a Monday.
+	cases := []struct {
+		body, now, start, end string
+		inContact             bool
+	}{
+		{"Voyager 1", "2026-10-19 03:00", "2026-10-19 04:00", "2026-10-19 08:00", false},
+		{"Voyager 1", "2026-10-19 05:30", "2026-10-19 04:00", "2026-10-19 08:00", true},
+		{"Voyager 1", "2026-10-19 08:00", "2026-10-19 16:00", "2026-10-19 18:00", false},
+		{"Voyager 1", "2026-10-19 19:00", "2026-10-20 04:00", "2026-10-20 08:00", false},
+		{"Mars", "2026-10-24 10:00", "2026-10-26 09:00", "2026-10-26 17:00", false},   // Saturday
+		{"Mars", "2026-10-23 16:59", "2026-10-23 09:00", "2026-10-23 17:00", true},    // Friday
+		{"Jupiter", "2026-10-25 01:00", "2026-10-24 22:00", "2026-10-25 02:00", true}, // Sunday, past midnight
+		{"Jupiter", "2026-10-25 02:00", "2026-10-31 22:00", "2026-11-01 02:00", false},
+	}
+	for _, tc := range cases {
+		sched := schedules[tc.body]
+		now := at(tc.now)
+		start, end, ok := sched.Pass(now)
+		if !ok {
+			t.Errorf("%s at %s: no pass found", tc.body, tc.now)
+			continue
+		}
+		if !start.Equal(at(tc.start)) || !end.Equal(at(tc.end)) {
+			t.Errorf("%s at %s: pass %s-%s, want %s-%s", tc.body, tc.now,
+				start.Format("01-02 15:04"), end.Format("01-02 15:04"), tc.start, tc.end)
+		}
+		if got := sched.InContact(now); got != tc.inContact {
+			t.Errorf("%s at %s: InContact = %v, want %v", tc.body, tc.now, got, tc.inContact)
+		}
+	}
+
+	// "always" and unscheduled bodies are always in contact.
+	for _, name := range []string{"Saturn", "Neptune"} {
+		if _, _, ok := schedules[name].Pass(at("2026-10-19 12:00")); ok {
+			t.Errorf("%s should have no passes", name)
+		}
+		if !schedules[name].InContact(at("2026-10-19 12:00")) {
+			t.Errorf("%s should always be in contact", name)
+		}
+	}
+}
+
+func TestParseContactSchedulesErrors(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	for _, spec := range []string{
+		"vulcan=04:00-08:00",
+		"mars",
+		"mars=4:00-08:00",
+		"mars=04:00-25:00",
+		"mars=04:00-04:00",
+		"mars=Funday 04:00-08:00",
+		"mars=04:00",
+	} {
+		if _, err := parseContactSchedules(spec, getCelestialObjects()); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+// TestContactWindowTCPForward crosses a window boundary with a fake clock:
+// an established forward is torn down when the pass ends, new connections
+// are refused, and service resumes with the next pass.
+func TestContactWindowTCPForward(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	withContactSchedules(t, "mars=10:00-11:00UTC")
+	setClock := fakeLinkClock(t, time.Date(2026, 10, 19, 10, 30, 0, 0, time.UTC))
+
+	echo := startEchoServer(t)
+	f := newTestForwarder(t, "mars", echo.String())
+	f.recheck = 10 * time.Millisecond
+	if err := f.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go f.Serve()
+	defer f.Stop()
+
+	echoes := func(conn net.Conn) bool {
+		buf := make([]byte, 4)
+		conn.Write([]byte("ping"))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err := io.ReadFull(conn, buf)
+		return err == nil
+	}
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", f.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	live := dial()
+	if !echoes(live) {
+		t.Fatal("forward should relay during the pass")
+	}
+
+	setClock(time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC))
+	live.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := live.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the live forward to end with a FIN when the pass ended, got %v", err)
+	}
+	live.Close() // as a client does on EOF; the relay stops draining
+	if echoes(dial()) {
+		t.Error("a new connection outside the pass should be refused")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		snap := f.recent.Snapshot(RecentFilter{})
+		if len(snap) >= 2 && snap[0].Outcome == outcomeNoContact && snap[1].Outcome == outcomeNoContact {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected two no_contact outcomes, got %+v", snap)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	setClock(time.Date(2026, 10, 20, 10, 0, 0, 0, time.UTC))
+	if !echoes(dial()) {
+		t.Error("forward should relay again in the next pass")
+	}
+}
+
+// TestDTNNoContactWindow checks the HTTP response outside a pass.
+func TestDTNNoContactWindow(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	withContactSchedules(t, "mars=10:00-11:00UTC")
+	setClock := fakeLinkClock(t, time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC))
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(time.Millisecond)
+
+	send := func() (int, http.Header, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "http://x/dtn/send",
+			strings.NewReader(`{"url":"http://127.0.0.1:9/"}`))
+		req.Host = "mars.latency.space"
+		rec := httptest.NewRecorder()
+		s.handleDTN(rec, req)
+		var out map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, rec.Header(), out
+	}
+	code, hdr, out := send()
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("outside the pass: got %d (%v), want 503", code, out)
+	}
+	if out["code"] != noContactWindowCode {
+		t.Errorf("code = %v, want %s", out["code"], noContactWindowCode)
+	}
+	if ra := hdr.Get("Retry-After"); ra != "3600" {
+		t.Errorf("Retry-After = %q, want 3600", ra)
+	}
+
+	setClock(time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC))
+	if code, _, out := send(); code != http.StatusAccepted {
+		t.Errorf("inside the pass: got %d (%v), want 202", code, out)
+	}
+}
+
+// TestSOCKSLinkLostDuringSetup flips the link mid-sleep: the CONNECT was
+// accepted, but by the time the latency sleep ends the body is occluded, so
+// the client gets HOST_UNREACHABLE instead of SUCCESS and the target is
+// never dialed.
+func TestSOCKSLinkLostDuringSetup(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	setClock := fakeLinkClock(t, start)
+	occludedFrom := start.Add(time.Hour)
+	orig := checkLink
+	checkLink = func(earth, target CelestialObject, objects []CelestialObject, at time.Time) (LinkOutage, bool) {
+		if !at.Before(occludedFrom) {
+			return LinkOutage{Occluder: "Sun"}, true
+		}
+		return LinkOutage{}, false
+	}
+	t.Cleanup(func() { checkLink = orig })
+
+	var dials atomic.Int32
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			conn.Close()
+		}
+	}()
+
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics()
+	recent := NewRecentLog(4, false)
+	connect := func() byte {
+		client, server := net.Pipe()
+		defer client.Close()
+		h := NewSOCKSHandler(server, security, metrics, "Mars", nil)
+		h.timing = fixedLatency(100 * time.Millisecond)
+		h.recent = recent
+		go h.Handle()
+		client.SetDeadline(time.Now().Add(3 * time.Second))
+		client.Write([]byte{SOCKS5_VERSION, 1, SOCKS5_NO_AUTH})
+		if _, err := io.ReadFull(client, make([]byte, 2)); err != nil {
+			t.Fatalf("greeting: %v", err)
+		}
+		addr := target.Addr().(*net.TCPAddr)
+		req := append([]byte{SOCKS5_VERSION, SOCKS5_CMD_CONNECT, 0, SOCKS5_ADDR_IPV4}, addr.IP.To4()...)
+		req = append(req, byte(addr.Port>>8), byte(addr.Port))
+		client.Write(req)
+		reply := make([]byte, 10)
+		if _, err := io.ReadFull(client, reply); err != nil {
+			t.Fatalf("reply: %v", err)
+		}
+		return reply[1]
+	}
+
+	// Link up for the whole sleep: connected.
+	if rep := connect(); rep != SOCKS5_REP_SUCCESS {
+		t.Fatalf("steady link: reply %#x, want success", rep)
+	}
+	for deadline := time.Now().Add(2 * time.Second); dials.Load() != 1; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the target never saw the first connection")
+		}
+	}
+
+	// Occluded while the request is in flight.
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		setClock(occludedFrom)
+	}()
+	if rep := connect(); rep != SOCKS5_REP_HOST_UNREACHABLE {
+		t.Fatalf("occluded mid-sleep: reply %#x, want host unreachable", rep)
+	}
+	if v := metrics.Count(metricSOCKSLinkLost, "Mars", outcomeOccluded); v != 1 {
+		t.Errorf("socks_occluded_during_setup_total = %v, want 1", v)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		snap := recent.Snapshot(RecentFilter{})
+		if len(snap) == 2 && snap[0].Outcome == outcomeOccluded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected an occluded outcome, got %+v", snap)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dials.Load() != 1 {
+		t.Error("the target was dialed although the link was lost")
+	}
+}
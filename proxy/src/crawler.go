@@ -0,0 +1,48 @@
+// crawler.go - keep search engine crawlers off the body hosts.
+//
+// Every body host serves a synthetic robots.txt (see handleHTTP) that only
+// allows the info page and /api/. Crawlers that ignore it can be turned away
+// outright with -block-crawlers: any request whose User-Agent contains one of
+// the -crawler-agents substrings (case-insensitive) gets an immediate 403,
+// before any latency is applied. robots.txt itself is always served.
+package main
+
+import "strings"
+
+// robotsTxt allows only the root info page and the API.
+const robotsTxt = "User-agent: *\nAllow: /$\nAllow: /api/\nDisallow: /\n"
+
+// defaultCrawlerAgents is the default -crawler-agents list.
+const defaultCrawlerAgents = "Googlebot,bingbot,Slurp,DuckDuckBot,Baiduspider,YandexBot,Applebot," +
+	"AhrefsBot,SemrushBot,MJ12bot,DotBot,PetalBot,Bytespider,GPTBot,CCBot"
+
+// crawlerBlocker matches User-Agents against a bot list. A nil blocker
+// matches nothing.
+type crawlerBlocker struct {
+	agents []string // lower-cased substrings
+}
+
+// newCrawlerBlocker parses a comma-separated list of User-Agent substrings.
+func newCrawlerBlocker(list string) *crawlerBlocker {
+	c := &crawlerBlocker{}
+	for _, a := range strings.Split(list, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			c.agents = append(c.agents, strings.ToLower(a))
+		}
+	}
+	return c
+}
+
+// matches reports whether userAgent belongs to a listed crawler.
+func (c *crawlerBlocker) matches(userAgent string) bool {
+	if c == nil || userAgent == "" {
+		return false
+	}
+	ua := strings.ToLower(userAgent)
+	for _, a := range c.agents {
+		if strings.Contains(ua, a) {
+			return true
+		}
+	}
+	return false
+}
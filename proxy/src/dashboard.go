@@ -0,0 +1,67 @@
+// dashboard.go - the front door at latency.space and www.latency.space.
+//
+// dashboard.html is embedded and self-contained: it subscribes to
+// /api/status-stream (polling /api/status-data where the stream is
+// unavailable) and renders a sortable table of every body with links to its
+// page and /setup, followed by the /_debug/help text. It is served at once,
+// with no latency. A latency.space subdomain that names no body is
+// redirected here with ?unknown=<host>&reason=<why> so the page can say so.
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// isApexHost reports whether host (port optional) is latency.space or
+// www.latency.space.
+func isApexHost(host string) bool {
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+	return strings.EqualFold(host, "latency.space") || strings.EqualFold(host, "www.latency.space")
+}
+
+// isLatencySpaceHost reports whether host (port optional) is a subdomain of
+// latency.space.
+func isLatencySpaceHost(host string) bool {
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	const suffix = ".latency.space"
+	return len(host) > len(suffix) && strings.EqualFold(host[len(host)-len(suffix):], suffix)
+}
+
+// handleDashboard serves the dashboard at / on the apex hosts.
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(dashboardHTML)
+}
+
+// redirectUnknownHost sends a request for a latency.space subdomain that
+// names no body to the dashboard, saying which host it was and why it names
+// none (a hostError reason).
+func (s *Server) redirectUnknownHost(w http.ResponseWriter, r *http.Request, reason string) {
+	host := r.Host
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	w.Header().Set("X-Robots-Tag", "noindex")
+	http.Redirect(w, r, s.webOrigin("latency.space")+"/?unknown="+url.QueryEscape(strings.ToLower(host))+"&reason="+url.QueryEscape(reason), http.StatusFound)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestDashboard(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	origTemplate := infoTemplate
+	defer func() { infoTemplate = origTemplate }()
+	infoTemplate = template.Must(template.ParseFiles("templates/info_page.html"))
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), timing: fixedLatency(time.Second)}
+	do := func(method, url string) (*httptest.ResponseRecorder, time.Duration) {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		s.handleHTTP(rec, httptest.NewRequest(method, url, nil))
+		return rec, time.Since(start)
+	}
+
+	for _, url := range []string{"http://latency.space/", "http://www.latency.space/", "http://Latency.Space:8080/"} {
+		rec, elapsed := do(http.MethodGet, url)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Fatalf("%s: %d %q", url, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if elapsed > 500*time.Millisecond {
+			t.Errorf("%s took %v; the dashboard must not be delayed", url, elapsed)
+		}
+		page := rec.Body.String()
+		for _, want := range []string{"<table", "/api/status-stream", "/api/status-data", "/_debug/help", "/setup?body="} {
+			if !strings.Contains(page, want) {
+				t.Errorf("%s: dashboard lacks %s", url, want)
+			}
+		}
+	}
+	if rec, _ := do(http.MethodHead, "http://latency.space/"); rec.Code != http.StatusOK {
+		t.Errorf("HEAD /: %d", rec.Code)
+	}
+	if rec, _ := do(http.MethodPost, "http://latency.space/"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /: %d", rec.Code)
+	}
+	if rec, _ := do(http.MethodGet, "http://latency.space/nope"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown apex path: %d", rec.Code)
+	}
+	// The API still answers on the apex.
+	if rec, _ := do(http.MethodGet, "http://latency.space/api/status-data"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Mars"`) {
+		t.Errorf("apex /api/status-data: %d", rec.Code)
+	}
+
+	// Subdomains that name no body are sent to the dashboard.
+	for host, want := range map[string]string{
+		"nibiru.latency.space":              "https://latency.space/?unknown=nibiru.latency.space&reason=unknown_label",
+		"phobos.jupiter.latency.space:8080": "https://latency.space/?unknown=phobos.jupiter.latency.space&reason=parent_mismatch",
+		"example.com.mars.latency.space":    "https://latency.space/?unknown=example.com.mars.latency.space&reason=too_many_labels",
+	} {
+		rec, _ := do(http.MethodGet, "http://"+host+"/")
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != want {
+			t.Errorf("%s: %d to %q, want a redirect to %s", host, rec.Code, rec.Header().Get("Location"), want)
+		}
+	}
+	// Other hosts are not ours to redirect.
+	if rec, _ := do(http.MethodGet, "http://example.com/"); rec.Code != http.StatusBadRequest ||
+		!strings.Contains(rec.Body.String(), `"code": "UNKNOWN_BODY"`) || !strings.Contains(rec.Body.String(), `"reason": "not_latency_space"`) {
+		t.Errorf("foreign host: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Body hosts are unaffected.
+	for _, url := range []string{"http://mars.latency.space/", "http://phobos.mars.latency.space/", "http://planets.latency.space/"} {
+		rec, _ := do(http.MethodGet, url)
+		if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "/api/status-stream") {
+			t.Errorf("%s: %d, or it served the dashboard", url, rec.Code)
+		}
+	}
+}
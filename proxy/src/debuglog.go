@@ -0,0 +1,21 @@
+// debuglog.go - opt-in debug logging (-debug).
+//
+// Decisions that are normally silent, such as where a request's host or
+// client address came from, are logged with debugf. They are too chatty for
+// production, so they only appear with -debug.
+package main
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// debugLogging enables debugf; set from -debug at startup.
+var debugLogging atomic.Bool
+
+// debugf logs like log.Printf when -debug is set.
+func debugf(format string, args ...any) {
+	if debugLogging.Load() {
+		log.Printf("DEBUG: "+format, args...)
+	}
+}
@@ -0,0 +1,177 @@
+// debugmux.go - the /_debug endpoints and who may call them.
+//
+// Every endpoint is registered on debugMux with a scope. Public endpoints
+// (help, distances, allowed-hosts) answer anyone, with CORS headers so a
+// page elsewhere can read them. Admin endpoints expose client detail or
+// change server state: they need the admin token (admin.go) and, with
+// -admin-cidrs set, a client address inside one of those networks. A
+// request failing either check gets the same 404 as an unknown command, so
+// the admin surface isn't advertised, and is counted in
+// proxy_debug_denied_total by endpoint and reason.
+//
+// Handle panics on a route without a scope, as http.ServeMux does on a bad
+// pattern, so a new endpoint can't ship open by omission: debugMux is built
+// at init, so the first test run fails.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// debugScope is who may call a /_debug endpoint. The zero value is no
+// scope, which Handle refuses.
+type debugScope int
+
+const (
+	debugPublic debugScope = iota + 1 // anyone, with CORS
+	debugAdmin                        // the admin token, from inside -admin-cidrs
+)
+
+// Reasons an admin request is refused, the reason label of
+// proxy_debug_denied_total.
+const (
+	debugDeniedToken   = "token"   // missing or wrong, or ADMIN_TOKEN unset
+	debugDeniedAddress = "address" // client outside -admin-cidrs
+)
+
+// debugHandler serves one /_debug endpoint.
+type debugHandler func(s *Server, w http.ResponseWriter, r *http.Request, snap *SystemSnapshot)
+
+type debugRoute struct {
+	pattern string
+	scope   debugScope
+	handler debugHandler
+}
+
+// DebugMux routes /_debug/<name> to the endpoint registered for name.
+type DebugMux struct {
+	routes  map[string]debugRoute
+	subtree map[string]debugRoute // patterns ending in "/", matched by prefix
+}
+
+// NewDebugMux returns an empty DebugMux.
+func NewDebugMux() *DebugMux {
+	return &DebugMux{routes: make(map[string]debugRoute), subtree: make(map[string]debugRoute)}
+}
+
+// Handle registers h for /_debug/<pattern>. A pattern ending in "/" also
+// serves everything under it and the name without the slash, like
+// http.ServeMux. It panics if scope isn't debugPublic or debugAdmin, or the
+// pattern is taken.
+func (m *DebugMux) Handle(pattern string, scope debugScope, h debugHandler) {
+	if scope != debugPublic && scope != debugAdmin {
+		panic(fmt.Sprintf("debugmux: /_debug/%s registered without a scope", pattern))
+	}
+	if pattern == "" || h == nil {
+		panic("debugmux: empty pattern or nil handler")
+	}
+	route := debugRoute{pattern: pattern, scope: scope, handler: h}
+	name, dir := strings.CutSuffix(pattern, "/")
+	if _, taken := m.routes[name]; taken {
+		panic(fmt.Sprintf("debugmux: /_debug/%s registered twice", name))
+	}
+	if _, taken := m.subtree[name]; taken {
+		panic(fmt.Sprintf("debugmux: /_debug/%s registered twice", name))
+	}
+	if dir {
+		m.subtree[name] = route
+	} else {
+		m.routes[name] = route
+	}
+}
+
+// lookup finds the route for the path below /_debug/.
+func (m *DebugMux) lookup(path string) (debugRoute, bool) {
+	if route, ok := m.routes[path]; ok {
+		return route, true
+	}
+	name, _, _ := strings.Cut(path, "/")
+	route, ok := m.subtree[name]
+	return route, ok
+}
+
+// serve answers a /_debug request for s.
+func (m *DebugMux) serve(s *Server, w http.ResponseWriter, r *http.Request, snap *SystemSnapshot) {
+	path := strings.TrimPrefix(r.URL.Path, "/_debug/")
+	route, ok := m.lookup(path)
+	if !ok {
+		debugNotFound(w, path)
+		return
+	}
+	switch route.scope {
+	case debugPublic:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	case debugAdmin:
+		if reason := s.debugAdminDenied(r); reason != "" {
+			debugf("/_debug/%s refused to %s: %s", path, s.requestClientIP(r), reason)
+			orNop(s.metrics).RecordDebugDenied(route.pattern, reason)
+			debugNotFound(w, path)
+			return
+		}
+	}
+	route.handler(s, w, r, snap)
+}
+
+// debugNotFound is the answer to an unknown command and a refused one alike.
+func debugNotFound(w http.ResponseWriter, path string) {
+	http.Error(w, "Unknown debug command: "+path, http.StatusNotFound)
+}
+
+// debugAdminDenied returns why r may not use an admin endpoint, or "".
+func (s *Server) debugAdminDenied(r *http.Request) string {
+	if len(s.adminCIDRs) > 0 && !isTrustedProxy(s.adminCIDRs, s.requestClientIP(r)) {
+		return debugDeniedAddress
+	}
+	if !s.hasAdminToken(r) {
+		return debugDeniedToken
+	}
+	return ""
+}
+
+// debugMux holds the /_debug endpoints.
+var debugMux = newServerDebugMux()
+
+func newServerDebugMux() *DebugMux {
+	m := NewDebugMux()
+	m.Handle("help", debugPublic, func(s *Server, w http.ResponseWriter, r *http.Request, _ *SystemSnapshot) {
+		s.printHelp(w, localeFor(r))
+	})
+	m.Handle("distances", debugPublic, func(s *Server, w http.ResponseWriter, _ *http.Request, snap *SystemSnapshot) {
+		s.printCelestialDistances(w, snap)
+	})
+	m.Handle("allowed-hosts", debugPublic, func(s *Server, w http.ResponseWriter, _ *http.Request, _ *SystemSnapshot) {
+		s.printAllowedHosts(w)
+	})
+
+	m.Handle("metrics", debugAdmin, func(s *Server, w http.ResponseWriter, r *http.Request, _ *SystemSnapshot) {
+		s.serveMetrics(w, r)
+	})
+	m.Handle("limits", debugAdmin, func(s *Server, w http.ResponseWriter, _ *http.Request, _ *SystemSnapshot) {
+		s.printLimits(w)
+	})
+	m.Handle("status", debugAdmin, func(s *Server, w http.ResponseWriter, _ *http.Request, _ *SystemSnapshot) {
+		s.printStatus(w)
+	})
+	m.Handle("recent", debugAdmin, func(s *Server, w http.ResponseWriter, r *http.Request, _ *SystemSnapshot) {
+		s.printRecent(w, r)
+	})
+	m.Handle("reload-objects", debugAdmin, func(s *Server, w http.ResponseWriter, r *http.Request, _ *SystemSnapshot) {
+		s.handleReloadObjects(w, r)
+	})
+	m.Handle("security", debugAdmin, func(s *Server, w http.ResponseWriter, r *http.Request, _ *SystemSnapshot) {
+		s.handleSecurityConfig(w, r)
+	})
+	m.Handle("epoch", debugAdmin, func(s *Server, w http.ResponseWriter, r *http.Request, _ *SystemSnapshot) {
+		s.handleEpoch(w, r)
+	})
+	m.Handle("runtime", debugAdmin, func(_ *Server, w http.ResponseWriter, r *http.Request, _ *SystemSnapshot) {
+		handleRuntime(w, r)
+	})
+	m.Handle("pprof/", debugAdmin, func(_ *Server, w http.ResponseWriter, r *http.Request, _ *SystemSnapshot) {
+		handlePprof(w, r)
+	})
+	return m
+}
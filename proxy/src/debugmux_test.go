@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// newDebugTestServer returns a server with admin token "ops" whose denials
+// land in the returned metrics.
+func newDebugTestServer(t *testing.T) (*Server, *RecordingMetrics) {
+	t.Helper()
+	withObjects(t, celestial.InitSolarSystemObjects())
+	metrics := NewRecordingMetrics()
+	s := &Server{security: NewSecurityValidator(), metrics: metrics,
+		sizeLimits: defaultSizeLimits, udpLimits: defaultUDPLimits, adminToken: "ops"}
+	return s, metrics
+}
+
+func debugGet(s *Server, path, token, remote string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "http://latency.space"+path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if remote != "" {
+		req.RemoteAddr = remote
+	}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, req)
+	return rec
+}
+
+// TestDebugMuxScopes checks public endpoints answer anyone with CORS, and
+// admin ones answer the token and nothing else, refusing with the 404 an
+// unknown command gets.
+func TestDebugMuxScopes(t *testing.T) {
+	s, metrics := newDebugTestServer(t)
+
+	for _, path := range []string{"/_debug/help", "/_debug/distances", "/_debug/allowed-hosts"} {
+		rec := debugGet(s, path, "", "")
+		if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Errorf("%s without a token: %d, CORS %q", path, rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	}
+
+	unknown := debugGet(s, "/_debug/limitz", "", "")
+	if unknown.Code != http.StatusNotFound {
+		t.Fatalf("unknown command: %d", unknown.Code)
+	}
+	for _, token := range []string{"", "wrong", "ops2", "op"} {
+		rec := debugGet(s, "/_debug/limits", token, "")
+		if rec.Code != http.StatusNotFound || rec.Body.String() != strings.Replace(unknown.Body.String(), "limitz", "limits", 1) {
+			t.Errorf("limits with token %q: %d %q, want the unknown command's 404", token, rec.Code, rec.Body)
+		}
+		if rec.Header().Get("WWW-Authenticate") != "" || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("limits with token %q: headers %v", token, rec.Header())
+		}
+	}
+	if n := metrics.Count(metricDebugDenied, "limits", debugDeniedToken); n != 4 {
+		t.Errorf("%s{limits, token} = %v, want 4", metricDebugDenied, n)
+	}
+
+	rec := debugGet(s, "/_debug/limits", "ops", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"size"`) {
+		t.Errorf("limits with the token: %d %s", rec.Code, rec.Body)
+	}
+	if rec := debugGet(s, "/_debug/pprof/cmdline", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("pprof subtree without a token: %d", rec.Code)
+	}
+	if n := metrics.Count(metricDebugDenied, "pprof/", debugDeniedToken); n != 1 {
+		t.Errorf("%s{pprof/, token} = %v, want 1", metricDebugDenied, n)
+	}
+
+	// With ADMIN_TOKEN unset no token opens them.
+	s.adminToken = ""
+	if rec := debugGet(s, "/_debug/limits", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("limits with ADMIN_TOKEN unset: %d", rec.Code)
+	}
+}
+
+// TestDebugMuxAdminCIDRs checks -admin-cidrs refuses the right token from
+// outside the networks, reading the client through a trusted proxy.
+func TestDebugMuxAdminCIDRs(t *testing.T) {
+	s, metrics := newDebugTestServer(t)
+	var err error
+	if s.adminCIDRs, err = parseTrustedProxies("10.0.0.0/8, 2001:db8::1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		remote string
+		code   int
+	}{
+		{"192.0.2.1:4000", http.StatusNotFound}, // httptest's default
+		{"10.1.2.3:4000", http.StatusOK},
+		{"[2001:db8::1]:4000", http.StatusOK},
+		{"[2001:db8::2]:4000", http.StatusNotFound},
+	} {
+		if rec := debugGet(s, "/_debug/limits", "ops", tc.remote); rec.Code != tc.code {
+			t.Errorf("limits from %s: %d, want %d", tc.remote, rec.Code, tc.code)
+		}
+	}
+	if n := metrics.Count(metricDebugDenied, "limits", debugDeniedAddress); n != 2 {
+		t.Errorf("%s{limits, address} = %v, want 2", metricDebugDenied, n)
+	}
+	if rec := debugGet(s, "/_debug/help", "", "192.0.2.1:4000"); rec.Code != http.StatusOK {
+		t.Errorf("help from outside -admin-cidrs: %d", rec.Code)
+	}
+
+	s.trustedProxies, _ = parseTrustedProxies("192.0.2.0/24")
+	req := httptest.NewRequest(http.MethodGet, "http://latency.space/_debug/limits", nil)
+	req.Header.Set("Authorization", "Bearer ops")
+	req.Header.Set("X-Forwarded-For", "10.9.9.9")
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("limits forwarded for 10.9.9.9: %d", rec.Code)
+	}
+}
+
+// TestDebugMuxRequiresScope checks an endpoint registered without a scope,
+// or twice, panics at registration, and every endpoint has one.
+func TestDebugMuxRequiresScope(t *testing.T) {
+	h := func(*Server, http.ResponseWriter, *http.Request, *SystemSnapshot) {}
+	mustPanic := func(name string, register func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: no panic", name)
+			}
+		}()
+		register()
+	}
+	mustPanic("no scope", func() { NewDebugMux().Handle("new", 0, h) })
+	mustPanic("unknown scope", func() { NewDebugMux().Handle("new", debugAdmin+1, h) })
+	mustPanic("twice", func() {
+		m := NewDebugMux()
+		m.Handle("new", debugPublic, h)
+		m.Handle("new/", debugAdmin, h)
+	})
+
+	for name, route := range debugMux.routes {
+		if route.scope != debugPublic && route.scope != debugAdmin {
+			t.Errorf("/_debug/%s has scope %d", name, route.scope)
+		}
+	}
+	for _, public := range []string{"help", "distances"} {
+		if debugMux.routes[public].scope != debugPublic {
+			t.Errorf("/_debug/%s isn't public", public)
+		}
+	}
+}
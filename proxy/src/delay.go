@@ -1,89 +1,187 @@
 // proxy/src/delay.go
 //
-// Latency simulation primitives. Light-speed delay shifts every byte in time
-// by a constant; it does not reduce throughput. The old SOCKS relay slept one
-// full one-way latency per 32KB chunk, so a Mars link (~12 min one-way)
-// carried roughly 45 bytes/s and a TLS handshake could take over an hour.
-// delayCopy instead timestamps chunks as they arrive and releases each one
-// exactly `latency` later: throughput is preserved while every byte still
-// arrives late by the light-travel time.
+// Latency simulation for the proxy paths. The delay line itself lives in the
+// relay package (relay.Pipe); this file wires it to connections, bodies and
+// metrics.
 package main
 
 import (
 	"context"
-	"io"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/latency-space/proxy/relay"
 )
 
+// sleepCtx sleeps for d on clock but aborts early if ctx is cancelled.
+func sleepCtx(ctx context.Context, clock Clock, d time.Duration) error {
+	return relay.New(relay.WithContext(ctx), relay.WithClock(clock)).Sleep(d)
+}
+
+// bandwidthSink reports a pipe's bytes against body and protocol in metrics
+// (if non-nil).
+func bandwidthSink(metrics Metrics, body, protocol string) relay.MetricsFunc {
+	metrics = orNop(metrics)
+	return func(dir relay.Direction, n int) {
+		metrics.TrackBandwidthDir(body, protocol, string(dir), int64(n))
+	}
+}
+
+// relayEnd is how a relayed connection ended: the outcome label of
+// socks_session_outcomes_total.
+type relayEnd string
+
 const (
-	delayChunkSize = 32 * 1024
-	// delayQueueLen bounds buffered in-flight data per direction
-	// (delayQueueLen * delayChunkSize = 512KB). When the queue is full the
-	// reader stalls, which acts as crude bandwidth backpressure.
-	delayQueueLen = 16
+	relayEndOK     relayEnd = "ok"           // both sides finished with a FIN
+	relayEndClosed relayEnd = "closed"       // the proxy closed it: link lost or session cap
+	relayEndIdle   relayEnd = "idle_timeout" // after a half-close, the other side went quiet
+	relayEndError  relayEnd = "error"        // any other failure
+	relayEndReset  relayEnd = "reset"        // a side reset the connection
 )
 
-type timedChunk struct {
-	data      []byte
-	deliverAt time.Time
+// relayEndOf classifies the error a direction of the relay ended with.
+func relayEndOf(err error) relayEnd {
+	switch {
+	case err == nil:
+		return relayEndOK
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return relayEndReset
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return relayEndIdle
+	case isNetClosingErr(err):
+		return relayEndClosed
+	}
+	return relayEndError
 }
 
-// delayCopy copies src to dst, delaying each chunk by latency. onBytes, if
-// non-nil, is called with the size of each chunk written (for metrics).
-// Returns the first error from either side; io.EOF is reported as nil.
-func delayCopy(ctx context.Context, dst io.Writer, src io.Reader, latency time.Duration, onBytes func(int)) error {
-	queue := make(chan timedChunk, delayQueueLen)
-	readErr := make(chan error, 1)
-
-	go func() {
-		defer close(queue)
-		for {
-			buf := make([]byte, delayChunkSize)
-			n, err := src.Read(buf)
-			if n > 0 {
-				select {
-				case queue <- timedChunk{data: buf[:n], deliverAt: time.Now().Add(latency)}:
-				case <-ctx.Done():
-					readErr <- ctx.Err()
-					return
-				}
-			}
-			if err != nil {
-				if err == io.EOF {
-					readErr <- nil
-				} else {
-					readErr <- err
-				}
-				return
-			}
-		}
-	}()
+// worse returns whichever of e and other says more about what went wrong;
+// they are declared in increasing order.
+func (e relayEnd) worse(other relayEnd) relayEnd {
+	rank := map[relayEnd]int{relayEndOK: 0, relayEndClosed: 1, relayEndIdle: 2, relayEndError: 3, relayEndReset: 4}
+	if rank[other] > rank[e] {
+		return other
+	}
+	return e
+}
 
-	for chunk := range queue {
-		if err := sleepCtx(ctx, time.Until(chunk.deliverAt)); err != nil {
-			return err
-		}
-		if _, err := dst.Write(chunk.data); err != nil {
-			return err
-		}
-		if onBytes != nil {
-			onBytes(len(chunk.data))
-		}
+// outcome is the recent-log outcome of a session that ended so: a reset or
+// failure is an error; a clean or idle end, or one the proxy chose, is ok.
+func (e relayEnd) outcome() string {
+	if e == relayEndReset || e == relayEndError {
+		return outcomeError
 	}
-	return <-readErr
+	return outcomeOK
+}
+
+// drainReader renews its connection's read deadline before every read once
+// armed, so the direction left running after a half-close carries on for as
+// long as data keeps coming and ends after timeout of silence.
+type drainReader struct {
+	net.Conn
+	timeout time.Duration
+	armed   atomic.Bool
 }
 
-// sleepCtx sleeps for d but aborts early if ctx is cancelled.
-func sleepCtx(ctx context.Context, d time.Duration) error {
-	if d <= 0 {
-		return ctx.Err()
+func (r *drainReader) Read(p []byte) (int, error) {
+	if r.armed.Load() {
+		r.Conn.SetReadDeadline(time.Now().Add(r.timeout))
 	}
-	timer := time.NewTimer(d)
-	defer timer.Stop()
-	select {
-	case <-timer.C:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
+	return r.Conn.Read(p)
+}
+
+// arm starts the idle deadline, which also ends a read already waiting.
+func (r *drainReader) arm() {
+	r.armed.Store(true)
+	r.Conn.SetReadDeadline(time.Now().Add(r.timeout))
+}
+
+// defaultTCPWindow is the default -tcp-window: 64KB, the largest window TCP
+// offers without window scaling.
+const defaultTCPWindow = 64 << 10
+
+// windowThroughput is the most a TCP connection with window bytes in flight
+// carries over rtt, in bits per second to three significant figures.
+func windowThroughput(window int, rtt time.Duration) float64 {
+	bps := float64(window) * 8 / rtt.Seconds()
+	scale := math.Pow(10, math.Floor(math.Log10(bps))-2)
+	return math.Round(bps/scale) * scale
+}
+
+// relayWithLatency relays data between clientConn and targetConn using a delay
+// line per direction, so every byte arrives `latency` late without throttling
+// throughput. When one direction reaches EOF it half-closes its destination,
+// so the peer reads EOF after the last byte instead of a reset, and the other
+// direction keeps relaying until it reaches EOF too or stays quiet for t's
+// drain timeout (relayDrainTimeout, linkloss.go, unless t sets one): an
+// HTTP/1.0 client or git fetch that half-closes after its request still gets
+// the whole response, however far away the body is. Both legs carry Go's default TCP keepalive, so the silence
+// of a long round trip doesn't get them dropped by NATs on the way.
+//
+// Bytes are tracked each way against body and protocol in metrics (if
+// non-nil). A positive maxBytes closes both connections as soon as more than
+// that has been carried both ways together. A positive window caps each
+// direction's unacknowledged bytes as TCP would over the real distance
+// (-simulate-tcp-windows), so throughput tops out at window/RTT. It blocks
+// until both directions are done, closes both connections and returns the
+// bytes carried each way and how the session ended. The delay lines run on
+// t's clock.
+func relayWithLatency(t timing, clientConn, targetConn net.Conn, body, protocol string, latency time.Duration, metrics Metrics, maxBytes int64, window int) (bytesIn, bytesOut int64, end relayEnd) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	sink := bandwidthSink(metrics, body, protocol)
+	var in, out atomic.Int64
+	var capped sync.Once
+	drain := t.drainTimeout()
+	client, target := &drainReader{Conn: clientConn, timeout: drain}, &drainReader{Conn: targetConn, timeout: drain}
+	ends := make(chan relayEnd, 2)
+	copyDir := func(dst, src *drainReader, label string, dir relay.Direction, counter *atomic.Int64) {
+		defer wg.Done()
+		// Each direction gets its own context so returning here unblocks
+		// only this direction's internal reader, not the other side.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		pipe := relay.New(
+			relay.WithContext(ctx),
+			relay.WithClock(t.clk()),
+			relay.WithLatency(latency),
+			relay.WithDirection(dir),
+			relay.WithWindow(window),
+			relay.WithMetrics(relay.MetricsFunc(func(dir relay.Direction, n int) {
+				counter.Add(int64(n))
+				sink(dir, n)
+				if maxBytes > 0 && in.Load()+out.Load() > maxBytes {
+					capped.Do(func() {
+						clientConn.Close()
+						targetConn.Close()
+					})
+				}
+			})),
+		)
+		err := pipe.CopyTCP(dst.Conn, src)
+		ends <- relayEndOf(err)
+		if err != nil && !isNetClosingErr(err) && !errors.Is(err, os.ErrDeadlineExceeded) {
+			log.Printf("Relay %s (%s) error: %v", label, body, err)
+		}
+		closeWrite(dst.Conn)
+		// The opposite direction reads from dst: from now on it ends once
+		// dst goes quiet.
+		dst.arm()
 	}
+
+	go copyDir(target, client, "client->target", relay.ToTarget, &in)
+	go copyDir(client, target, "target->client", relay.ToClient, &out)
+	wg.Wait()
+	clientConn.Close()
+	targetConn.Close()
+	end = (<-ends).worse(<-ends)
+	metrics.RecordRelayEnd(body, protocol, string(end))
+	return in.Load(), out.Load(), end
 }
@@ -4,81 +4,244 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"html/template"
 	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
-)
-
-// TestDelayCopyShiftsButDoesNotThrottle is the core throughput regression:
-// the delay line must shift the whole stream by one latency, NOT sleep once
-// per chunk (which the old relay did, throttling throughput to chunk/latency).
-func TestDelayCopyShiftsButDoesNotThrottle(t *testing.T) {
-	latency := 100 * time.Millisecond
-	payload := bytes.Repeat([]byte("x"), 256*1024) // 8 chunks of 32KB
 
-	var out bytes.Buffer
-	start := time.Now()
-	err := delayCopy(context.Background(), &out, bytes.NewReader(payload), latency, nil)
-	elapsed := time.Since(start)
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
 
-	if err != nil {
-		t.Fatalf("delayCopy: %v", err)
+// streamEcho writes size random bytes to conn and reads the echo back,
+// returning how long the round trip took.
+func streamEcho(t *testing.T, conn net.Conn, size int) time.Duration {
+	t.Helper()
+	payload := make([]byte, size)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
 	}
-	if out.Len() != len(payload) {
-		t.Fatalf("copied %d bytes, want %d", out.Len(), len(payload))
-	}
-	if elapsed < latency {
-		t.Errorf("finished in %v, expected at least the %v delay", elapsed, latency)
+	conn.SetDeadline(time.Now().Add(20 * time.Second))
+	start := time.Now()
+	go conn.Write(payload)
+	got := make([]byte, size)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read echo: %v", err)
 	}
-	// Old behavior: 8 chunks * 100ms = 800ms minimum. Delay-line behavior:
-	// one 100ms shift for the whole stream.
-	if elapsed > latency+200*time.Millisecond {
-		t.Errorf("took %v: per-chunk sleeping detected, expected about %v total", elapsed, latency)
+	elapsed := time.Since(start)
+	if !bytes.Equal(got, payload) {
+		t.Fatal("echoed data does not match what was sent")
 	}
+	return elapsed
 }
 
-// TestDelayCopyCancellation ensures a cancelled context tears the copy down
-// promptly instead of holding the full (possibly interplanetary) delay.
-func TestDelayCopyCancellation(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
+// TestRelayStreamsAtFullRate streams 5MB (160 chunks) through each TCP relay
+// and checks the stream is shifted by one round trip in total. Delaying each
+// chunk in turn, as the old relay did, would take 160 round trips.
+func TestRelayStreamsAtFullRate(t *testing.T) {
+	const (
+		latency = 100 * time.Millisecond
+		size    = 5 << 20
+	)
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	echo := startEchoServer(t)
 
-	pr, pw := io.Pipe()
-	defer pw.Close()
+	check := func(t *testing.T, elapsed time.Duration) {
+		if elapsed < 2*latency {
+			t.Errorf("5MB echoed in %v, faster than one round trip", elapsed)
+		}
+		if elapsed > 2*latency+2*time.Second {
+			t.Errorf("5MB echoed in %v, want about one round trip (%v): is the relay delaying per chunk?", elapsed, 2*latency)
+		}
+	}
+
+	t.Run("socks connect", func(t *testing.T) {
+		proxy := startTestSOCKS(t, &Server{security: newTestSecurity(), metrics: NewRecordingMetrics(),
+			limiter: NewRateLimiter(600, 100, 0, 0), fixedCelestialBody: "Mars", timing: fixedLatency(latency)})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, _, err := client.Dial(ctx, proxy, echo.String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		check(t, streamEcho(t, conn, size))
+	})
+
+	t.Run("tcp forward", func(t *testing.T) {
+		f := newTestForwarder(t, "mars", echo.String())
+		f.timing = fixedLatency(latency)
+		if err := f.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		go f.Serve()
+		defer f.Stop()
+		conn, err := net.Dial("tcp", f.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		// Wait out the connection setup delay before timing the stream.
+		conn.Write([]byte{0})
+		if _, err := io.ReadFull(conn, make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+		check(t, streamEcho(t, conn, size))
+	})
+}
 
-	done := make(chan error, 1)
+// TestRelayHalfClose sends a request through the SOCKS relay and half-closes,
+// as an HTTP/1.0 client or git fetch does. The upstream answers only after
+// reading EOF, slowly, trickling the response for longer than the drain
+// timeout: the client must still get all of it, a round trip late, and the
+// session end "ok". An upstream that resets instead ends it as "reset".
+func TestRelayHalfClose(t *testing.T) {
+	const latency = 100 * time.Millisecond
+	withObjects(t, celestial.InitSolarSystemObjects())
+
+	request := []byte("GET / HTTP/1.0\r\n\r\n")
+	response := make([]byte, 256<<10)
+	if _, err := rand.Read(response); err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { upstream.Close() })
+	reset := make(chan bool, 1)
 	go func() {
-		var out bytes.Buffer
-		done <- delayCopy(ctx, &out, pr, time.Hour, nil)
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			got, _ := io.ReadAll(conn) // the request, up to the half-close
+			if !bytes.Equal(got, request) {
+				t.Errorf("upstream read %q", got)
+			}
+			time.Sleep(50 * time.Millisecond)
+			if <-reset {
+				conn.Write(response[:1000])
+				conn.(*net.TCPConn).SetLinger(0)
+				conn.Close()
+				continue
+			}
+			// Ten pieces 50ms apart: 450ms in all, three drain timeouts.
+			piece := len(response) / 10
+			for i := 0; i < 10; i++ {
+				if i > 0 {
+					time.Sleep(50 * time.Millisecond)
+				}
+				conn.Write(response[i*piece : (i+1)*piece])
+			}
+			conn.Close()
+		}
 	}()
 
-	if _, err := pw.Write([]byte("stranded in transit")); err != nil {
-		t.Fatalf("write: %v", err)
+	recent := NewRecentLog(4, false)
+	metrics := NewRecordingMetrics()
+	timing := fixedLatency(latency)
+	timing.drain = 150 * time.Millisecond
+	proxy := startTestSOCKS(t, &Server{security: newTestSecurity(), metrics: metrics, recent: recent,
+		fixedCelestialBody: "Mars", timing: timing})
+	exchange := func() ([]byte, time.Duration) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, _, err := client.Dial(ctx, proxy, upstream.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		start := time.Now()
+		conn.Write(request)
+		if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(conn)
+		return got, time.Since(start)
 	}
-	cancel()
-
-	select {
-	case err := <-done:
-		if err == nil {
-			t.Error("expected context error, got nil")
+	waitOutcome := func(n int) RecentTransaction {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if snap := recent.Snapshot(RecentFilter{}); len(snap) == n {
+				return snap[0]
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("the session was never recorded")
+			}
+			time.Sleep(10 * time.Millisecond)
 		}
-	case <-time.After(5 * time.Second):
-		t.Fatal("delayCopy did not return after cancellation")
+	}
+
+	reset <- false
+	got, elapsed := exchange()
+	if !bytes.Equal(got, response[:len(response)/10*10]) {
+		t.Fatalf("got %d bytes of the %d byte response, or they differ", len(got), len(response)/10*10)
+	}
+	if elapsed < 2*latency+450*time.Millisecond {
+		t.Errorf("response complete after %v, before a round trip plus the upstream's trickle", elapsed)
+	}
+	if tx := waitOutcome(1); tx.Outcome != outcomeOK {
+		t.Errorf("half-closed session recorded as %s, want %s", tx.Outcome, outcomeOK)
+	}
+	if v := metrics.Count(metricRelayEnds, "Mars", protoSOCKSTCP, string(relayEndOK)); v != 1 {
+		t.Errorf("ok relay ends: %v, want 1", v)
+	}
+
+	reset <- true
+	exchange()
+	if tx := waitOutcome(2); tx.Outcome != outcomeError {
+		t.Errorf("reset session recorded as %s, want %s", tx.Outcome, outcomeError)
+	}
+	if v := metrics.Count(metricRelayEnds, "Mars", protoSOCKSTCP, string(relayEndReset)); v != 1 {
+		t.Errorf("reset relay ends: %v, want 1", v)
 	}
 }
 
-// TestDelayCopyMetricsCallback checks onBytes is invoked with the full byte
-// count (the relay uses it for bandwidth metrics).
-func TestDelayCopyMetricsCallback(t *testing.T) {
-	payload := bytes.Repeat([]byte("y"), 100*1024)
-	var counted int
-	var out bytes.Buffer
-	err := delayCopy(context.Background(), &out, bytes.NewReader(payload), time.Millisecond, func(n int) {
-		counted += n
-	})
+// TestRelayTCPWindow streams through a SOCKS relay in Interplanetary Internet
+// mode: with a 16KB window, 256KB needs 16 round trips where the unwindowed
+// relay takes one. The info page states the cap.
+func TestRelayTCPWindow(t *testing.T) {
+	const (
+		latency = 50 * time.Millisecond
+		window  = 16 << 10
+		size    = 256 << 10
+	)
+	withObjects(t, celestial.InitSolarSystemObjects())
+	echo := startEchoServer(t)
+	s := &Server{security: newTestSecurity(), metrics: NewRecordingMetrics(),
+		limiter: NewRateLimiter(600, 100, 0, 0), fixedCelestialBody: "Mars", timing: fixedLatency(latency), tcpWindow: window}
+	proxy := startTestSOCKS(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := client.Dial(ctx, proxy, echo.String())
 	if err != nil {
-		t.Fatalf("delayCopy: %v", err)
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	rtts := time.Duration(size / window)
+	if elapsed := streamEcho(t, conn, size); elapsed < rtts*2*latency*9/10 || elapsed > 2*rtts*2*latency+time.Second {
+		t.Errorf("256KB echoed in %v, want about %d round trips (%v)", elapsed, rtts, rtts*2*latency)
+	}
+
+	if got := formatBitRate(windowThroughput(64<<10, 10*time.Second)); got != "52.4 kbps" {
+		t.Errorf("64KB window over a 10s round trip: %s, want 52.4 kbps", got)
 	}
-	if counted != len(payload) {
-		t.Errorf("onBytes counted %d, want %d", counted, len(payload))
+	origTemplate := infoTemplate
+	defer func() { infoTemplate = origTemplate }()
+	infoTemplate = template.Must(template.ParseFiles("templates/info_page.html"))
+	rec := httptest.NewRecorder()
+	s.displayCelestialInfo(rec, httptest.NewRequest("GET", "/", nil), currentSnapshot(distanceClock()), "Mars")
+	if !strings.Contains(rec.Body.String(), "Interplanetary Internet mode: SOCKS connections keep at most a 16 KB TCP window") {
+		t.Error("info page does not mention the simulated TCP window")
 	}
 }
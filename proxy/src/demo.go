@@ -0,0 +1,210 @@
+// demo.go - latency a browser can watch, without proxying anyone's content.
+//
+//	GET  /api/demo/drip?bytes=100000&chunk=1000   stream a known payload
+//	POST /api/demo/echo                           get the request body back
+//
+// drip sends bytes of demoPattern, repeated, in chunks of chunk bytes. The
+// first byte leaves after the body's one-way light time and the rest are
+// paced at the body's downlink rate (dataRates, less any space weather
+// loss; spaceweather.go), or at bps if given,
+// clamped to demoMinBps-demoMaxBps, so a progress bar fills the way a
+// download from the body would. Each chunk is flushed as it is due, and
+// X-Accel-Buffering keeps nginx from holding them back.
+//
+// echo returns the request body after the round trip, twice the one-way
+// light time, counted from when the body has been read.
+//
+// The body comes from the host (mars.latency.space) or ?body=. Nothing
+// leaves the server, so unlike the proxy paths the minimum latency doesn't
+// apply and Earth answers at once. Both are capped at demoMaxBytes and
+// rate limited per client IP, and refused with 503 while the body's link
+// is down (occlusion_policy.go).
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	demoMaxBytes     = 10 << 20 // largest drip or echo payload
+	demoDefaultBytes = 100000
+	demoDefaultChunk = 1000
+	demoMaxChunk     = 64 << 10
+	demoMinBps       = 800 // 100 bytes a second
+	demoMaxBps       = 1e9
+)
+
+// demoPattern is the drip payload's repeating unit, so a client can check
+// what it received.
+const demoPattern = "latency.space demo payload 0123456789abcdefghijklmnopqrstuvwxyz\n"
+
+// newDemoLimiter builds the per-IP limiter for /api/demo: a rate cap, and
+// at most two drips or echoes in flight per client.
+func newDemoLimiter() *RateLimiter {
+	return NewRateLimiter(
+		envFloat("DEMO_RATE_PER_MIN", 20),
+		envInt("DEMO_BURST", 5),
+		envInt("DEMO_PER_IP", 2),
+		0,
+	)
+}
+
+// handleDemo serves /api/demo/drip and /api/demo/echo.
+func (s *Server) handleDemo(w http.ResponseWriter, r *http.Request) {
+	var allow string
+	switch r.URL.Path {
+	case "/api/demo/drip":
+		allow = http.MethodGet
+	case "/api/demo/echo":
+		allow = http.MethodPost
+	default:
+		writeJSONError(w, r, http.StatusNotFound, "error.demo_method")
+		return
+	}
+	if r.Method != allow {
+		w.Header().Set("Allow", allow)
+		writeJSONError(w, r, http.StatusMethodNotAllowed, "error.demo_method")
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("body"))
+	if name == "" {
+		name = s.resolveCelestialHost(getCelestialObjects(), r.Host)
+	}
+	if name == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.demo_body")
+		return
+	}
+	obj, ok := findObjectByName(getCelestialObjects(), name)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotFound, "error.unknown_body_named", name)
+		return
+	}
+	distance, err := getCurrentDistance(obj.Name)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	// The demo goes over the body's link, so it is refused while that is
+	// down, with what to wait for (occlusion_policy.go).
+	if earth, ok := findObjectByName(getCelestialObjects(), "Earth"); ok {
+		now := linkTime()
+		if outage, down := checkLink(earth, obj, getCelestialObjects(), now); down {
+			occlusionPolicy.refuseHTTP(w, s.metrics, obj, outage, now)
+			return
+		}
+	}
+
+	release, err := s.demoLimiter.Acquire(s.requestClientIP(r))
+	if err != nil {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	setStationHeader(w, obj.Name)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-store")
+	oneWay := s.oneWay(obj.Name, distance)
+	if allow == http.MethodGet {
+		down, _ := dataRates(obj)
+		down = bodyWeather(obj.Name, getCelestialObjects(), linkTime()).downlink(down)
+		s.demoDrip(w, r, oneWay, down)
+	} else {
+		s.demoEcho(w, r, oneWay)
+	}
+}
+
+// demoDrip streams the drip payload; oneWay and bps are the body's.
+func (s *Server) demoDrip(w http.ResponseWriter, r *http.Request, oneWay time.Duration, bps float64) {
+	q := r.URL.Query()
+	size, chunk := int64(demoDefaultBytes), demoDefaultChunk
+	var err error
+	if v := q.Get("bytes"); v != "" {
+		if size, err = strconv.ParseInt(v, 10, 64); err != nil || size < 1 || size > demoMaxBytes {
+			writeJSONError(w, r, http.StatusBadRequest, "error.demo_bytes", demoMaxBytes)
+			return
+		}
+	}
+	if v := q.Get("chunk"); v != "" {
+		if chunk, err = strconv.Atoi(v); err != nil || chunk < 1 || chunk > demoMaxChunk {
+			writeJSONError(w, r, http.StatusBadRequest, "error.demo_chunk", demoMaxChunk)
+			return
+		}
+	}
+	if v := q.Get("bps"); v != "" {
+		if bps, err = strconv.ParseFloat(v, 64); err != nil || !(bps > 0) {
+			writeJSONError(w, r, http.StatusBadRequest, "error.demo_bps")
+			return
+		}
+	}
+	bps = min(max(bps, demoMinBps), demoMaxBps)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// A slow link outlasts the ordinary request timeout.
+	holdOpen(w)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("X-Latency-Space-Drip-Bps", strconv.FormatFloat(bps, 'f', -1, 64))
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Each chunk is due when the one before it has been serialized,
+	// reckoned from the start so the pace doesn't drift.
+	start := s.now()
+	payload := demoPayload(min(int64(chunk)+int64(len(demoPattern)), size+int64(len(demoPattern))))
+	for sent := int64(0); sent < size; {
+		due := start.Add(oneWay + time.Duration(float64(sent)*8/bps*float64(time.Second)))
+		if err := sleepCtx(r.Context(), s.clk(), due.Sub(s.now())); err != nil {
+			return
+		}
+		n := min(int64(chunk), size-sent)
+		off := sent % int64(len(demoPattern))
+		if _, err := w.Write(payload[off : off+n]); err != nil {
+			return
+		}
+		flusher.Flush()
+		sent += n
+	}
+}
+
+// demoPayload returns n bytes of demoPattern, repeated.
+func demoPayload(n int64) []byte {
+	return bytes.Repeat([]byte(demoPattern), int(n)/len(demoPattern)+1)[:n]
+}
+
+// demoEcho returns the request body after a round trip of 2*oneWay.
+func (s *Server) demoEcho(w http.ResponseWriter, r *http.Request, oneWay time.Duration) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, demoMaxBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSONError(w, r, http.StatusRequestEntityTooLarge, "error.demo_too_large", demoMaxBytes)
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	// The round trip to Mars outlasts the ordinary request timeout.
+	holdOpen(w)
+	if err := sleepCtx(r.Context(), s.clk(), 2*oneWay); err != nil {
+		return
+	}
+	// Never the client's own type: the echo isn't a way to serve pages.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
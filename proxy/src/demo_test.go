@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestDemoDripPacing(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	const latency = 150 * time.Millisecond
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), timing: fixedLatency(latency)}
+	srv := httptest.NewServer(http.HandlerFunc(s.handleHTTP))
+	defer srv.Close()
+
+	// 80 kbit/s is 10 kB/s: a 1000-byte chunk every 100 ms.
+	const size, chunk, gap = 5000, 1000, 100 * time.Millisecond
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/demo/drip?bytes=5000&chunk=1000&bps=80000", nil)
+	req.Host = "mars.latency.space"
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Accel-Buffering") != "no" || resp.ContentLength != size {
+		t.Fatalf("%d, X-Accel-Buffering %q, Content-Length %d", resp.StatusCode, resp.Header.Get("X-Accel-Buffering"), resp.ContentLength)
+	}
+
+	var got []byte
+	var arrived []time.Duration // when each chunk was complete
+	buf := make([]byte, chunk)
+	for {
+		n, err := resp.Body.Read(buf)
+		got = append(got, buf[:n]...)
+		if n > 0 && len(got)%chunk == 0 {
+			arrived = append(arrived, time.Since(start))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(got, demoPayload(size)) {
+		t.Fatalf("payload: %d bytes, %q...", len(got), got[:min(len(got), 40)])
+	}
+	if len(arrived) != size/chunk {
+		t.Fatalf("chunks arrived at %v", arrived)
+	}
+	if arrived[0] < latency || arrived[0] > latency+gap/2 {
+		t.Errorf("first chunk after %v, want about %v", arrived[0], latency)
+	}
+	for i := 1; i < len(arrived); i++ {
+		if d := arrived[i] - arrived[i-1]; d < gap/2 || d > gap*3/2 {
+			t.Errorf("chunk %d came %v after the one before, want about %v", i, d, gap)
+		}
+	}
+}
+
+func TestDemoEcho(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	const latency = 100 * time.Millisecond
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), timing: fixedLatency(latency)}
+
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodPost, "http://mars.latency.space/api/demo/echo", strings.NewReader("hello, Mars")))
+	if elapsed := time.Since(start); elapsed < 2*latency {
+		t.Errorf("echo after %v, want at least the round trip %v", elapsed, 2*latency)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello, Mars" {
+		t.Errorf("echo: %d %q", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/octet-stream" {
+		t.Errorf("Content-Type %q", ct)
+	}
+}
+
+func TestDemoLimits(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), timing: fixedLatency(0)}
+	do := func(method, url string, body io.Reader) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(method, url, body))
+		return rec
+	}
+
+	for url, want := range map[string]int{
+		"http://mars.latency.space/api/demo/drip?bytes=100":                             http.StatusOK,
+		"http://latency.space/api/demo/drip?body=earth&bytes=100":                       http.StatusOK, // no minimum latency
+		"http://mars.latency.space/api/demo/drip?bytes=" + strconv.Itoa(demoMaxBytes+1): http.StatusBadRequest,
+		"http://mars.latency.space/api/demo/drip?bytes=0":                               http.StatusBadRequest,
+		"http://mars.latency.space/api/demo/drip?chunk=0":                               http.StatusBadRequest,
+		"http://mars.latency.space/api/demo/drip?chunk=" + strconv.Itoa(demoMaxChunk+1): http.StatusBadRequest,
+		"http://mars.latency.space/api/demo/drip?bps=-5":                                http.StatusBadRequest,
+		"http://latency.space/api/demo/drip":                                            http.StatusBadRequest,
+		"http://latency.space/api/demo/drip?body=vulcan":                                http.StatusNotFound,
+		"http://mars.latency.space/api/demo/flood":                                      http.StatusNotFound,
+	} {
+		if rec := do(http.MethodGet, url, nil); rec.Code != want {
+			t.Errorf("GET %s: %d, want %d (%s)", url, rec.Code, want, rec.Body.String())
+		}
+	}
+	if rec := do(http.MethodGet, "http://mars.latency.space/api/demo/drip?bytes=10&bps=1e15", nil); rec.Header().Get("X-Latency-Space-Drip-Bps") != "1000000000" {
+		t.Errorf("bps not capped: %q", rec.Header().Get("X-Latency-Space-Drip-Bps"))
+	}
+	if rec := do(http.MethodGet, "http://mars.latency.space/api/demo/echo", nil); rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "POST" {
+		t.Errorf("GET echo: %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+	if rec := do(http.MethodPost, "http://mars.latency.space/api/demo/echo", bytes.NewReader(make([]byte, demoMaxBytes+1))); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized echo: %d", rec.Code)
+	}
+
+	// One request a minute, no burst: the second is turned away.
+	s.demoLimiter = NewRateLimiter(1, 1, 0, 0)
+	if rec := do(http.MethodGet, "http://mars.latency.space/api/demo/drip?bytes=10", nil); rec.Code != http.StatusOK {
+		t.Fatalf("first: %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "http://mars.latency.space/api/demo/echo", strings.NewReader("x")); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second: %d, want 429", rec.Code)
+	}
+}
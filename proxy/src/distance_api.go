@@ -0,0 +1,136 @@
+// distance_api.go - on-demand distance between any two bodies.
+//
+//	GET /api/distance?from=europa&to=enceladus[&t=RFC3339]
+//
+// ?at= is accepted for t. Either wins over a pinned epoch (epoch.go).
+//
+// The status API and /_debug/distances are Earth-centric and served from the
+// hourly cache. This endpoint instead solves both positions (and an occlusion
+// scan over every object) fresh for the requested pair and instant, so it is
+// rate limited per client IP. An occluded pair also gets the time it clears,
+// searched as the finger and Gopher info page does (bodytext.go).
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// positionAU is a heliocentric ecliptic position in astronomical units.
+type positionAU struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// DistanceResponse is the JSON returned by /api/distance.
+type DistanceResponse struct {
+	From         string     `json:"from"`
+	To           string     `json:"to"`
+	Timestamp    time.Time  `json:"timestamp"`
+	FromPosition positionAU `json:"from_position_au"`
+	ToPosition   positionAU `json:"to_position_au"`
+	Distance     float64    `json:"distance_km"` // light-time corrected
+	Geometric    float64    `json:"geometric_distance_km"`
+	OneWay       float64    `json:"one_way_seconds"`
+	RoundTrip    float64    `json:"round_trip_seconds"`
+	Occluded     bool       `json:"occluded"`
+	OccludedBy   string     `json:"occludedBy,omitempty"`
+	NextVisible  *time.Time `json:"nextVisible,omitempty"` // when an occluded body clears, if within bodyTextVisibleLimit
+}
+
+// newDistanceLimiter builds the per-IP limiter for /api/distance. Only the
+// request rate is capped; the handler is synchronous so concurrency is bounded
+// by the rate anyway.
+func newDistanceLimiter() *RateLimiter {
+	return NewRateLimiter(
+		envFloat("DISTANCE_API_RATE_PER_MIN", 30),
+		envInt("DISTANCE_API_BURST", 10),
+		0, 0,
+	)
+}
+
+// handleDistance serves /api/distance.
+func (s *Server) handleDistance(w http.ResponseWriter, r *http.Request) {
+	release, err := s.distanceLimiter.Acquire(s.requestClientIP(r))
+	if err != nil {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	q := r.URL.Query()
+	fromName, toName := strings.TrimSpace(q.Get("from")), strings.TrimSpace(q.Get("to"))
+	if fromName == "" || toName == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.distance_params")
+		return
+	}
+
+	at := simTime(time.Now())
+	ts := q.Get("t")
+	if ts == "" {
+		ts = q.Get("at")
+	}
+	if ts != "" {
+		parsed, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid 't' (want RFC3339): " + err.Error()})
+			return
+		}
+		at = parsed
+	}
+
+	resp, status, err := computeDistance(fromName, toName, at)
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// computeDistance solves the separation and line of sight between two named
+// bodies at time at. Names accept the same forms as host parsing (case-
+// insensitive, hyphenated slugs). On failure it returns the HTTP status the
+// caller should use alongside the error.
+func computeDistance(fromName, toName string, at time.Time) (*DistanceResponse, int, error) {
+	objects := getCelestialObjects()
+	from, ok := findObjectByName(objects, fromName)
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("unknown celestial body: %q", fromName)
+	}
+	to, ok := findObjectByName(objects, toName)
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("unknown celestial body: %q", toName)
+	}
+	if from.Name == to.Name {
+		return nil, http.StatusBadRequest, fmt.Errorf("'from' and 'to' both name %s", from.Name)
+	}
+
+	fromPos := GetObjectPosition(from, objects, at)
+	toPos := GetObjectPosition(to, objects, at)
+	distance := ApparentDistance(from, to, objects, at)
+	oneWay := distance / SPEED_OF_LIGHT
+
+	resp := &DistanceResponse{
+		From:         from.Name,
+		To:           to.Name,
+		Timestamp:    at.UTC(),
+		FromPosition: positionAU{X: fromPos.X, Y: fromPos.Y, Z: fromPos.Z},
+		ToPosition:   positionAU{X: toPos.X, Y: toPos.Y, Z: toPos.Z},
+		Distance:     distance,
+		Geometric:    toPos.Subtract(fromPos).Magnitude() * AU,
+		OneWay:       oneWay,
+		RoundTrip:    2 * oneWay,
+	}
+	if v, occluder := IsOccluded(from, to, objects, at); v.Blocked() {
+		resp.Occluded = true
+		resp.OccludedBy = occluder.Name
+		if clearAt, found := NextVisibilityChange(from, to, objects, at, bodyTextVisibleStep, bodyTextVisibleLimit); found {
+			clearAt = clearAt.UTC()
+			resp.NextVisible = &clearAt
+		}
+	}
+	return resp, http.StatusOK, nil
+}
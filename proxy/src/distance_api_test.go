@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func distanceRequest(t *testing.T, s *Server, query string) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://latency.space/api/distance?"+query, nil)
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, req)
+	var out map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("response not JSON (%d): %q", rec.Code, rec.Body.String())
+	}
+	return rec.Code, out
+}
+
+// TestDistanceSymmetric checks distance(a,b) == distance(b,a) for a few pairs,
+// including slug and case variants of the names.
+func TestDistanceSymmetric(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	const at = "2030-06-01T00:00:00Z"
+
+	pairs := [][2]string{
+		{"europa", "enceladus"},
+		{"Mars", "voyager-1"},
+		{"MOON", "phobos"},
+		{"earth", "JWST"},
+	}
+	for _, p := range pairs {
+		t.Run(p[0]+"-"+p[1], func(t *testing.T) {
+			code, ab := distanceRequest(t, s, "from="+p[0]+"&to="+p[1]+"&t="+at)
+			if code != http.StatusOK {
+				t.Fatalf("a->b: expected 200, got %d (%v)", code, ab)
+			}
+			code, ba := distanceRequest(t, s, "from="+p[1]+"&to="+p[0]+"&t="+at)
+			if code != http.StatusOK {
+				t.Fatalf("b->a: expected 200, got %d (%v)", code, ba)
+			}
+			gab, gba := ab["geometric_distance_km"].(float64), ba["geometric_distance_km"].(float64)
+			if gab <= 0 || math.Abs(gab-gba) > 1e-6*gab {
+				t.Errorf("asymmetric geometric distance: %v vs %v", gab, gba)
+			}
+			// The light-time corrected distance depends on which end is
+			// observing, but only by about v/c.
+			dab, dba := ab["distance_km"].(float64), ba["distance_km"].(float64)
+			if math.Abs(dab-dba) > 1e-3*dab {
+				t.Errorf("apparent distances differ by more than v/c: %v vs %v", dab, dba)
+			}
+			if rt, ow := ab["round_trip_seconds"].(float64), ab["one_way_seconds"].(float64); math.Abs(rt-2*ow) > 1e-9 {
+				t.Errorf("round trip %v is not twice one-way %v", rt, ow)
+			}
+			if ab["timestamp"] != at {
+				t.Errorf("expected timestamp %s, got %v", at, ab["timestamp"])
+			}
+		})
+	}
+}
+
+func TestDistanceErrors(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+
+	cases := []struct {
+		name  string
+		query string
+		code  int
+	}{
+		{"unknown from", "from=vulcan&to=mars", http.StatusNotFound},
+		{"unknown to", "from=mars&to=krypton", http.StatusNotFound},
+		{"identical", "from=mars&to=Mars", http.StatusBadRequest},
+		{"identical via slug", "from=Voyager%201&to=voyager-1", http.StatusBadRequest},
+		{"missing to", "from=mars", http.StatusBadRequest},
+		{"bad time", "from=mars&to=venus&t=yesterday", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			code, out := distanceRequest(t, s, tc.query)
+			if code != tc.code {
+				t.Errorf("expected %d, got %d (%v)", tc.code, code, out)
+			}
+			if _, ok := out["error"]; !ok {
+				t.Errorf("expected an error message, got %v", out)
+			}
+		})
+	}
+}
+
+func TestDistanceRateLimited(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	s := &Server{
+		security:        NewSecurityValidator(),
+		metrics:         NewRecordingMetrics(),
+		distanceLimiter: NewRateLimiter(1 /* per min */, 2 /* burst */, 0, 0),
+	}
+	for i := 0; i < 2; i++ {
+		if code, out := distanceRequest(t, s, "from=mars&to=venus"); code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d (%v)", i, code, out)
+		}
+	}
+	if code, _ := distanceRequest(t, s, "from=mars&to=venus"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the burst is spent, got %d", code)
+	}
+}
+
+// TestDistanceNextVisible checks an occluded pair reports when it clears:
+// Mars is behind the Moon at 01:00 on 30 June 2025 and clear by 03:00.
+func TestDistanceNextVisible(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+
+	code, out := distanceRequest(t, s, "from=earth&to=mars&t=2025-06-30T01:00:00Z")
+	if code != http.StatusOK || out["occluded"] != true {
+		t.Fatalf("expected an occluded 200, got %d (%v)", code, out)
+	}
+	raw, _ := out["nextVisible"].(string)
+	at, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		t.Fatalf("nextVisible %q: %v", raw, err)
+	}
+	if from, to := time.Date(2025, 6, 30, 1, 0, 0, 0, time.UTC), time.Date(2025, 6, 30, 3, 0, 0, 0, time.UTC); !at.After(from) || at.After(to) {
+		t.Errorf("nextVisible %s, want between %s and %s", at, from, to)
+	}
+
+	if _, out := distanceRequest(t, s, "from=earth&to=mars&t=2025-06-30T03:00:00Z"); out["nextVisible"] != nil {
+		t.Errorf("clear pair has nextVisible %v", out["nextVisible"])
+	}
+}
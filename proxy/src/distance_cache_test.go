@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// mustDistance is getCurrentDistance for tests that expect a distance.
+func mustDistance(t *testing.T, body string) float64 {
+	t.Helper()
+	d, err := getCurrentDistance(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// withObjects swaps in objects with a cold distance cache for one test.
+func withObjects(t *testing.T, objects []celestial.CelestialObject) {
+	t.Helper()
+	original := getCelestialObjects()
+	t.Cleanup(func() {
+		setCelestialObjects(original)
+		invalidateDistanceCache()
+	})
+	setCelestialObjects(objects)
+	invalidateDistanceCache()
+}
+
+// withoutBody returns the solar system minus name.
+func withoutBody(name string) []celestial.CelestialObject {
+	var out []celestial.CelestialObject
+	for _, obj := range celestial.InitSolarSystemObjects() {
+		if obj.Name != name {
+			out = append(out, obj)
+		}
+	}
+	return out
+}
+
+// TestDistanceCacheFillsOnMiss checks a body added after the cache was
+// filled is answered at once, not after the hourly refresh.
+func TestDistanceCacheFillsOnMiss(t *testing.T) {
+	withObjects(t, withoutBody("Mars"))
+	if _, err := getCurrentDistance("Jupiter"); err != nil {
+		t.Fatal(err)
+	}
+	// Mars arrives without the cache being invalidated.
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	misses := distanceCacheMisses.Load()
+	d, err := getCurrentDistance("Mars")
+	if err != nil || d <= 0 {
+		t.Fatalf("Mars after a miss: %v, %v", d, err)
+	}
+	if got := distanceCacheMisses.Load() - misses; got != 1 {
+		t.Errorf("counted %d misses, want 1", got)
+	}
+	if _, err := getCurrentDistance("Mars"); err != nil || distanceCacheMisses.Load()-misses != 1 {
+		t.Errorf("second lookup should hit the refreshed cache (err %v)", err)
+	}
+
+	if _, err := getCurrentDistance("Vulcan"); !errors.Is(err, errDistanceUnavailable) {
+		t.Errorf("unknown body: got %v, want errDistanceUnavailable", err)
+	}
+}
+
+// TestDistanceUnavailable hits bodies while the cache can't be filled (no
+// Earth to measure from) and checks callers report that rather than
+// rejecting for insufficient latency.
+func TestDistanceUnavailable(t *testing.T) {
+	withObjects(t, withoutBody("Earth"))
+
+	_, err := getCurrentDistance("Mars")
+	if !errors.Is(err, errDistanceUnavailable) || !strings.Contains(err.Error(), "Mars") {
+		t.Fatalf("got %v, want distance unavailable for Mars", err)
+	}
+
+	t.Run("info page", func(t *testing.T) {
+		s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+		rec := httptest.NewRecorder()
+		s.displayCelestialInfo(rec, httptest.NewRequest("GET", "/", nil), currentSnapshot(distanceClock()), "Mars")
+		if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "distance unavailable for body Mars") {
+			t.Errorf("got %d %q", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("api time", func(t *testing.T) {
+		s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+		rec := httptest.NewRecorder()
+		s.handleTime(rec, httptest.NewRequest("GET", "/api/time?body=mars", nil))
+		if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "distance unavailable") {
+			t.Errorf("got %d %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("socks udp associate", func(t *testing.T) {
+		recent := NewRecentLog(4, false)
+		code, _ := udpAssociateWith(t, NewSecurityValidator(), NewRecordingMetrics(),
+			func(h *SOCKSHandler) { h.recent = recent })
+		if code != SOCKS5_REP_GENERAL_FAILURE {
+			t.Fatalf("reply 0x%02x, want general failure", code)
+		}
+		var got []RecentTransaction
+		for deadline := time.Now().Add(2 * time.Second); len(got) == 0 && time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			got = recent.Snapshot(RecentFilter{})
+		}
+		if len(got) != 1 || got[0].Outcome != outcomeError {
+			t.Errorf("recorded %+v, want one error (not a latency denial)", got)
+		}
+	})
+}
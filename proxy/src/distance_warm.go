@@ -0,0 +1,237 @@
+// distance_warm.go - filling the distance cache before traffic needs it.
+//
+// A cold cache costs the request that finds it a full solve of every body's
+// position and visibility. Start fills it before the listeners accept, and
+// with -cache-file the last table is written out on shutdown and read back
+// at boot: it is served at once, marked stale in /readyz, while a fresh one
+// is computed in the background. However a refresh is triggered, callers
+// that arrive while one is running wait for it rather than start another.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// distanceCacheFileMaxAge is how old a -cache-file table may be and still
+// be served while the fresh one is computed.
+const distanceCacheFileMaxAge = 24 * time.Hour
+
+var (
+	// distanceFromDisk is set while the cache holds a table read from
+	// -cache-file rather than computed here. Guarded by DistanceCacheMutex.
+	distanceFromDisk bool
+
+	// distanceInvalidations counts invalidateDistanceCache calls, so a
+	// refresh that started against replaced objects or another epoch
+	// neither stores its result nor is joined.
+	distanceInvalidations atomic.Uint64
+)
+
+// distanceCall is one refresh, shared by every caller that arrives while it
+// runs.
+type distanceCall struct {
+	done    chan struct{}
+	gen     uint64 // distanceInvalidations when it started
+	entries []DistanceEntry
+	epoch   time.Time
+}
+
+var distanceFlight struct {
+	sync.Mutex
+	call *distanceCall
+}
+
+// refreshDistances computes the table for t and stores it, or waits for
+// the refresh already running and returns its result.
+func refreshDistances(objects []celestial.CelestialObject, t time.Time) ([]DistanceEntry, time.Time) {
+	distanceFlight.Lock()
+	gen := distanceInvalidations.Load()
+	if c := distanceFlight.call; c != nil && c.gen == gen {
+		distanceFlight.Unlock()
+		<-c.done
+		return c.entries, c.epoch
+	}
+	c := &distanceCall{done: make(chan struct{}), gen: gen}
+	distanceFlight.call = c
+	distanceFlight.Unlock()
+
+	defer func() {
+		distanceFlight.Lock()
+		if distanceFlight.call == c {
+			distanceFlight.call = nil
+		}
+		distanceFlight.Unlock()
+		close(c.done)
+	}()
+
+	entries, ok := computeDistances(objects, t)
+
+	DistanceCacheMutex.Lock()
+	defer DistanceCacheMutex.Unlock()
+	if ok && distanceInvalidations.Load() == gen {
+		distanceEntries = entries
+		distanceEpoch = t
+		distanceUpdated = readClock(distanceClock)
+		distanceFromDisk = false
+		distanceGeneration++
+	}
+	if !ok {
+		// No Earth: callers get whatever the cache held.
+		entries, t = distanceEntries, distanceEpoch
+	}
+	c.entries, c.epoch = entries, t
+	return entries, t
+}
+
+// distanceCacheFile is the -cache-file format. Bodies are stored by name
+// and matched to the current objects on load.
+type distanceCacheFile struct {
+	Epoch   time.Time            `json:"epoch"` // the instant the distances describe
+	Saved   time.Time            `json:"saved"`
+	Entries []savedDistanceEntry `json:"entries"`
+}
+
+type savedDistanceEntry struct {
+	Body       string            `json:"body"`
+	Distance   float64           `json:"distance_km"`
+	Geometric  float64           `json:"geometric_km"`
+	Visibility Visibility        `json:"visibility"`
+	OccludedBy string            `json:"occluded_by,omitempty"`
+	Elongation float64           `json:"elongation_deg"`
+	Direction  celestial.Vector3 `json:"direction"`
+}
+
+// saveDistanceCache writes the cached table to path via a temp file and
+// rename. An empty cache writes nothing.
+func saveDistanceCache(path string) error {
+	DistanceCacheMutex.RLock()
+	file := distanceCacheFile{Epoch: distanceEpoch, Saved: distanceClock().UTC()}
+	for _, e := range distanceEntries {
+		file.Entries = append(file.Entries, savedDistanceEntry{
+			Body:       e.Object.Name,
+			Distance:   e.Distance,
+			Geometric:  e.Geometric,
+			Visibility: e.Visibility,
+			OccludedBy: e.OccludedBy.Name,
+			Elongation: e.Elongation,
+			Direction:  e.Direction,
+		})
+	}
+	DistanceCacheMutex.RUnlock()
+	if len(file.Entries) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("saving %s: %v", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("saving %s: %v", path, err)
+	}
+	return nil
+}
+
+// loadDistanceCache fills the cache from a table saved by
+// saveDistanceCache, marked as loaded from disk. A table for another pinned
+// epoch, or saved more than distanceCacheFileMaxAge ago, is refused. Bodies
+// no longer defined are dropped.
+func loadDistanceCache(path string, objects []celestial.CelestialObject) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var file distanceCacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+	if epoch, pinned := pinnedEpoch(); pinned && !file.Epoch.Equal(epoch) {
+		return 0, fmt.Errorf("%s describes %s, not the pinned epoch %s", path, file.Epoch.Format(time.RFC3339), epoch.Format(time.RFC3339))
+	}
+	if age := distanceClock().Sub(file.Saved); age > distanceCacheFileMaxAge {
+		return 0, fmt.Errorf("%s was saved %v ago", path, age.Round(time.Minute))
+	}
+
+	entries := make([]DistanceEntry, 0, len(file.Entries))
+	for _, saved := range file.Entries {
+		obj, ok := findObjectByName(objects, saved.Body)
+		if !ok {
+			continue
+		}
+		occluder, _ := findObjectByName(objects, saved.OccludedBy)
+		entries = append(entries, DistanceEntry{
+			Object:     obj,
+			Distance:   saved.Distance,
+			Geometric:  saved.Geometric,
+			Occluded:   saved.Visibility.Blocked(),
+			Visibility: saved.Visibility,
+			OccludedBy: occluder,
+			Elongation: saved.Elongation,
+			Direction:  saved.Direction,
+		})
+	}
+	if len(entries) == 0 {
+		return 0, fmt.Errorf("%s has no known bodies", path)
+	}
+
+	DistanceCacheMutex.Lock()
+	defer DistanceCacheMutex.Unlock()
+	distanceEntries = entries
+	distanceEpoch = file.Epoch
+	distanceUpdated = readClock(distanceClock)
+	distanceFromDisk = true
+	distanceGeneration++
+	return len(entries), nil
+}
+
+// distanceCacheState describes the cache for /readyz: "fresh", or "stale"
+// while a table loaded from -cache-file is being replaced.
+func distanceCacheState() string {
+	DistanceCacheMutex.RLock()
+	defer DistanceCacheMutex.RUnlock()
+	if distanceFromDisk {
+		return "stale: loaded from -cache-file, refreshing"
+	}
+	return "fresh"
+}
+
+// warmDistanceCache fills the distance cache before the listeners start:
+// from -cache-file if it holds a usable table, refreshed in the background,
+// otherwise by computing it now.
+func (s *Server) warmDistanceCache() {
+	objects := getCelestialObjects()
+	if s.cacheFile != "" {
+		n, err := loadDistanceCache(s.cacheFile, objects)
+		switch {
+		case err == nil:
+			log.Printf("Loaded %d distances from %s; refreshing in the background", n, s.cacheFile)
+			go func() {
+				start := time.Now()
+				refreshDistances(objects, simTime(time.Now()))
+				log.Printf("Distance table refreshed in %v", time.Since(start).Round(time.Millisecond))
+			}()
+			return
+		case errors.Is(err, fs.ErrNotExist):
+		default:
+			log.Printf("Not using -cache-file: %v", err)
+		}
+	}
+	start := time.Now()
+	calculateDistancesFromEarth(objects, simTime(time.Now()))
+	log.Printf("Distance table computed in %v", time.Since(start).Round(time.Millisecond))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestRefreshDistancesShared starts many lookups against a cold cache at
+// once and checks they share a single computation.
+func TestRefreshDistancesShared(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	before := distanceGeneration
+
+	const callers = 16
+	start := make(chan struct{})
+	results := make([][]DistanceEntry, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			results[i], _ = calculateDistancesFromEarth(getCelestialObjects(), distanceClock())
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if got := distanceGeneration - before; got != 1 {
+		t.Errorf("%d computations for %d concurrent callers, want 1", got, callers)
+	}
+	for i, r := range results {
+		if len(r) == 0 || &r[0] != &results[0][0] {
+			t.Fatalf("caller %d got a different table", i)
+		}
+	}
+}
+
+// TestDistanceCacheFile saves a table, loads it back as if after a restart
+// three hours later, and checks it is served as stale until refreshed.
+func TestDistanceCacheFile(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	orig := distanceClock
+	distanceClock = func() time.Time { return now }
+	defer func() { distanceClock = orig }()
+
+	saved, epoch := calculateDistancesFromEarth(getCelestialObjects(), now)
+	path := filepath.Join(t.TempDir(), "distances.json")
+	if err := saveDistanceCache(path); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(3 * time.Hour)
+	invalidateDistanceCache()
+	n, err := loadDistanceCache(path, getCelestialObjects())
+	if err != nil || n != len(saved) {
+		t.Fatalf("loaded %d entries (%v), want %d", n, err, len(saved))
+	}
+	entries, at := calculateDistancesFromEarth(getCelestialObjects(), now)
+	if !at.Equal(epoch) || len(entries) != len(saved) || entries[3].Distance != saved[3].Distance || entries[3].Object.Name != saved[3].Object.Name {
+		t.Fatalf("served %d entries for %v, want the saved %d for %v", len(entries), at, len(saved), epoch)
+	}
+	s := &Server{security: NewSecurityValidator()}
+	if c := readinessCheck(t, s, "distance_cache"); !c.OK || !strings.HasPrefix(c.Detail, "stale") {
+		t.Errorf("after load: %+v, want ok and stale", c)
+	}
+
+	refreshDistances(getCelestialObjects(), now)
+	if _, at := calculateDistancesFromEarth(getCelestialObjects(), now); !at.Equal(now) {
+		t.Errorf("after the refresh the table describes %v, want %v", at, now)
+	}
+	if c := readinessCheck(t, s, "distance_cache"); c.Detail != "fresh" {
+		t.Errorf("after the refresh: %+v, want fresh", c)
+	}
+
+	// A table saved more than a day ago is not served.
+	now = now.Add(distanceCacheFileMaxAge)
+	if _, err := loadDistanceCache(path, getCelestialObjects()); err == nil {
+		t.Error("loaded a table saved over a day ago")
+	}
+
+	// Nor is one that doesn't parse.
+	data, _ := json.Marshal(map[string]string{"epoch": "yesterday"})
+	os.WriteFile(path, data, 0o644)
+	if _, err := loadDistanceCache(path, getCelestialObjects()); err == nil {
+		t.Error("loaded a malformed table")
+	}
+}
+
+// readinessCheck returns the named /readyz check.
+func readinessCheck(t *testing.T, s *Server, name string) healthCheck {
+	t.Helper()
+	for _, c := range s.readinessChecks() {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("no %s check", name)
+	return healthCheck{}
+}
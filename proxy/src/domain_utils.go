@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"strings"
+
+	"github.com/latency-space/shared/celestial"
 )
 
 // FormatDomainName formats the name of a celestial body or spacecraft into a valid domain name
@@ -21,6 +23,15 @@ func FormatMoonDomain(moonName, planetName string) string {
 	return fmt.Sprintf("%s.%s.latency.space", FormatDomainName(moonName), FormatDomainName(planetName))
 }
 
+// FormatBodyDomain returns the info page domain for obj: moons sit under
+// their parent planet, everything else directly under latency.space.
+func FormatBodyDomain(obj celestial.CelestialObject) string {
+	if obj.Type == "moon" && obj.ParentName != "" {
+		return FormatMoonDomain(obj.Name, obj.ParentName)
+	}
+	return FormatFullDomain(obj.Name)
+}
+
 // FormatTargetDomain formats a target domain with a celestial body
 // Example: example.com.mars.latency.space
 func FormatTargetDomain(targetDomain, celestialName string) string {
@@ -11,6 +11,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
@@ -19,18 +20,18 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptrace"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	dtnMaxBodyBytes = 1 << 20            // cap stored request/response bodies at 1 MiB
-	dtnRetention    = 7 * 24 * time.Hour // keep delivered jobs this long after delivery
-	dtnFetchTimeout = 60 * time.Second   // real network timeout for the actual fetch
-	dtnMaxJobs      = 512                // hard cap on live jobs, bounding memory + store size
+	dtnRetention = 7 * 24 * time.Hour // keep delivered jobs this long after delivery
+	dtnMaxJobs   = 512                // hard cap on live jobs, bounding memory + store size
 )
 
 // errDTNStoreFull is returned by Add when the store is at capacity.
@@ -48,12 +49,18 @@ type DTNJob struct {
 	ReqBody     string            `json:"reqBody,omitempty"`
 
 	// Filled in once the outbound request "arrives" and the fetch runs.
-	Fetched     bool              `json:"fetched"`
-	FetchedAt   time.Time         `json:"fetchedAt,omitempty"`
-	RespStatus  int               `json:"respStatus,omitempty"`
-	RespHeaders map[string]string `json:"respHeaders,omitempty"`
-	RespBody    string            `json:"respBody,omitempty"`
-	FetchErr    string            `json:"fetchErr,omitempty"`
+	Fetched       bool              `json:"fetched"`
+	FetchedAt     time.Time         `json:"fetchedAt,omitempty"`
+	RespStatus    int               `json:"respStatus,omitempty"`
+	RespHeaders   map[string]string `json:"respHeaders,omitempty"`
+	RespBody      string            `json:"respBody,omitempty"`
+	RespTruncated bool              `json:"respTruncated,omitempty"` // RespBody was cut at the response cap
+	FetchErr      string            `json:"fetchErr,omitempty"`
+	FetchCause    string            `json:"fetchCause,omitempty"` // refused, timeout, dns, ... (see classifyUpstreamError)
+	Attempts      int               `json:"attempts,omitempty"`   // upstream requests made, retries included
+	Coalesced     bool              `json:"coalesced,omitempty"`  // response copied from an identical job's fetch (coalesce.go)
+
+	trace context.Context // submitting request's span, parent of dtn.fetch; not persisted
 }
 
 func (j *DTNJob) arrivalAt() time.Time  { return j.SubmittedAt.Add(j.OneWay) }
@@ -80,29 +87,97 @@ func (j *DTNJob) state(now time.Time) string {
 type DTNStore struct {
 	path     string
 	security *SecurityValidator
-	metrics  *MetricsCollector
+	metrics  Metrics
+	client   *http.Client // shared upstream client; see upstream.go
+
+	retries     int           // extra attempts for transient failures (-upstream-retries)
+	retryBase   time.Duration // first retry backoff
+	maxResponse int64         // response body cap (-response-max-size); the rest is dropped
+	coalesce    *fetchGroup   // shares identical GETs in flight (-coalesce-gets); nil = off
+
+	overhead *overheadSampler // fetches whose time is recorded as overhead; nil = none
+	timing                    // clock for submissions, deliveries and retention (clock.go)
 
 	mu     sync.Mutex
 	jobs   map[string]*DTNJob
-	timers map[string]*time.Timer
+	timers map[string]Timer
 }
 
 // NewDTNStore builds a store backed by the given file and loads any saved jobs.
-func NewDTNStore(path string, security *SecurityValidator, metrics *MetricsCollector) *DTNStore {
+func NewDTNStore(path string, security *SecurityValidator, metrics Metrics) *DTNStore {
 	if dir := filepath.Dir(path); dir != "" && dir != "." {
 		_ = os.MkdirAll(dir, 0o700) // best effort; save() logs if writes still fail
 	}
 	s := &DTNStore{
 		path:     path,
 		security: security,
-		metrics:  metrics,
+		metrics:  orNop(metrics),
 		jobs:     make(map[string]*DTNJob),
-		timers:   make(map[string]*time.Timer),
+		timers:   make(map[string]Timer),
+
+		retries:     defaultUpstreamRetries,
+		retryBase:   upstreamRetryBase,
+		maxResponse: defaultSizeLimits.ResponseBytes,
 	}
+	s.SetUpstreamTimeouts(defaultUpstreamTimeouts)
 	s.load()
 	return s
 }
 
+// SetUpstreamTimeouts replaces the upstream client with one using t. Call it
+// before Start.
+func (s *DTNStore) SetUpstreamTimeouts(t UpstreamTimeouts) {
+	s.client = &http.Client{
+		Transport: newUpstreamTransport(t),
+		// Re-validate every redirect hop against the allowlist. Without this an
+		// open redirect on an allowlisted host could bounce the fetch to
+		// 169.254.169.254 / 127.0.0.1 / internal services and return the body
+		// via /dtn/status - an SSRF that defeats the initial-URL allowlist.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			if _, err := s.security.ValidateHTTPTarget(req.URL.String()); err != nil {
+				recordDenial(s.metrics, protoHTTP, err)
+				return fmt.Errorf("%w: %v", errRedirectBlocked, err)
+			}
+			return nil
+		},
+	}
+}
+
+// SetUpstreamRetries sets how many times a transient upstream failure is
+// retried; 0 disables retries. Call it before Start.
+func (s *DTNStore) SetUpstreamRetries(n int) {
+	s.retries = max(n, 0)
+}
+
+// SetCoalesceGets turns sharing of identical GETs in flight on or off
+// (coalesce.go). Call it before Start.
+func (s *DTNStore) SetCoalesceGets(on bool) {
+	s.coalesce = nil
+	if on {
+		s.coalesce = newFetchGroup()
+	}
+}
+
+// SetMaxResponseBytes sets the response body cap, which must be positive.
+// Call it before Start.
+func (s *DTNStore) SetMaxResponseBytes(n int64) {
+	s.maxResponse = n
+}
+
+// SetOverheadSampler picks the fetches recorded in
+// latency_space_overhead_seconds (overhead.go). Call it before Start.
+func (s *DTNStore) SetOverheadSampler(o *overheadSampler) {
+	s.overhead = o
+}
+
+// CloseIdleConnections drops the pooled upstream connections (on shutdown).
+func (s *DTNStore) CloseIdleConnections() {
+	s.client.CloseIdleConnections()
+}
+
 // load reads persisted jobs (best effort - a missing or corrupt file is ignored).
 func (s *DTNStore) load() {
 	data, err := os.ReadFile(s.path)
@@ -145,7 +220,8 @@ func (s *DTNStore) save() {
 }
 
 // Start reschedules the fetch leg for any pending jobs (surviving a restart) and
-// launches the retention janitor. stop closes to shut the janitor down.
+// launches the retention janitor and the clock-jump watch. stop closes to shut
+// them down.
 func (s *DTNStore) Start(stop <-chan struct{}) {
 	s.mu.Lock()
 	for _, j := range s.jobs {
@@ -155,6 +231,8 @@ func (s *DTNStore) Start(stop <-chan struct{}) {
 	}
 	s.mu.Unlock()
 
+	go watchClockJumps(stop, clockJumpInterval, func(time.Duration) { s.rearm() })
+
 	go func() {
 		t := time.NewTicker(time.Hour)
 		defer t.Stop()
@@ -173,12 +251,27 @@ func (s *DTNStore) Start(stop <-chan struct{}) {
 // request "arrives". If arrival is already in the past (e.g. after a restart),
 // the fetch runs immediately. Caller must hold s.mu.
 func (s *DTNStore) scheduleFetchLocked(j *DTNJob) {
-	delay := time.Until(j.arrivalAt())
+	delay := j.arrivalAt().Sub(s.now())
 	if delay < 0 {
 		delay = 0
 	}
 	id := j.ID
-	s.timers[id] = time.AfterFunc(delay, func() { s.runFetch(id) })
+	s.timers[id] = s.afterFunc(delay, func() { s.runFetch(id) })
+}
+
+// rearm re-arms the timers of jobs still in transit from their arrivals.
+// The timers run on the monotonic clock and arrivals are wall-clock
+// instants, so after a suspend or a clock step (clockjump.go) a timer would
+// fire long after, or before, the arrival the job's state reports. A timer
+// that has already fired is left to its fetch.
+func (s *DTNStore) rearm() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.timers {
+		if j, ok := s.jobs[id]; ok && t.Stop() {
+			s.scheduleFetchLocked(j)
+		}
+	}
 }
 
 // runFetch performs the real HTTP request for a job and records the result.
@@ -192,78 +285,160 @@ func (s *DTNStore) runFetch(id string) {
 	}
 	// Snapshot the immutable request fields; release the lock during network I/O.
 	method, rawURL, reqHeaders, reqBody := j.Method, j.URL, j.ReqHeaders, j.ReqBody
+	oneWay, submitted, parent, bodyName := j.OneWay, j.SubmittedAt, j.trace, j.Body
 	s.mu.Unlock()
 
-	status, respHeaders, respBody, fetchErr := s.fetch(method, rawURL, reqHeaders, reqBody)
+	if parent == nil {
+		parent = context.Background() // restored from disk: a new trace
+	}
+	ctx, span := startSpan(parent, "dtn.fetch", attr("dtn.job_id", id), attr("http.url", rawURL))
+	defer span.End()
+	span.backdate(submitted)
+	// The outbound leg is a timer rather than a sleep; record it the same way.
+	_, transit := startSpan(ctx, "latency.sleep", attr("latency.intended_ms", durationMs(oneWay)))
+	transit.backdate(submitted)
+	transit.End()
+
+	fetchStart := s.now()
+	res, coalesced := s.coalescedFetch(ctx, bodyName, oneWay, method, rawURL, reqHeaders, reqBody)
+	status, respHeaders, respBody, fetchErr, cause, attempts := res.status, res.headers, res.body, res.err, res.cause, res.attempts
+	upstream := s.since(fetchStart)
+	// fetch reads one byte past the cap, so a response that stops exactly at
+	// it isn't mistaken for a truncated one.
+	truncated := int64(len(respBody)) > s.maxResponse
+	if truncated {
+		respBody = respBody[:s.maxResponse]
+		log.Printf("DTN: job %s response from %s truncated at the %d byte limit", id, rawURL, s.maxResponse)
+		s.metrics.RecordSizeLimit(bodyName, sizeLimitResponse)
+	}
+	span.SetAttr("http.status_code", status)
+	span.SetAttr("upstream.attempts", attempts)
+	span.SetAttr("upstream.coalesced", coalesced)
+	if fetchErr != "" {
+		span.SetError(errors.New(fetchErr))
+	}
 
 	s.mu.Lock()
 	j, ok = s.jobs[id]
-	var bodyName string
 	if ok {
 		j.Fetched = true
-		j.FetchedAt = time.Now()
+		j.FetchedAt = s.now()
 		j.RespStatus = status
 		j.RespHeaders = respHeaders
 		j.RespBody = respBody
+		j.RespTruncated = truncated
 		j.FetchErr = fetchErr
-		bodyName = j.Body
+		j.FetchCause = cause
+		j.Attempts = attempts
+		j.Coalesced = coalesced
 		s.save()
 	}
 	s.mu.Unlock()
 
 	if s.metrics != nil && ok {
-		s.metrics.RecordRequest(bodyName, "dtn", 0)
+		// The simulated part is the round trip the job spends in transit; the
+		// upstream part is the real fetch.
+		s.metrics.RecordRequest(bodyName, "dtn", upstream)
+		s.metrics.RecordLatencySplit(bodyName, "dtn", 2*oneWay, upstream)
+		s.overhead.record(s.metrics, bodyName, overheadDTN, upstream)
+		s.metrics.TrackBandwidthDir(bodyName, protoHTTP, dirToTarget, int64(len(reqBody)))
+		s.metrics.TrackBandwidthDir(bodyName, protoHTTP, dirToClient, int64(len(respBody)))
 	}
 }
 
-// fetch does the actual outbound request. Returns status, headers, body, error.
-func (s *DTNStore) fetch(method, rawURL string, headers map[string]string, body string) (int, map[string]string, string, string) {
+// fetchWithRetry runs fetch, retrying transient failures of idempotent
+// requests up to s.retries times (see upstream.go). It also returns the
+// number of attempts made.
+func (s *DTNStore) fetchWithRetry(ctx context.Context, bodyName string, oneWay time.Duration, method, rawURL string, headers map[string]string, body string) (int, map[string]string, string, string, string, int) {
+	for attempt := 1; ; attempt++ {
+		status, rh, rb, fetchErr, cause := s.fetch(ctx, method, rawURL, headers, body)
+		failed := fetchErr != "" || retryableUpstream(status, cause)
+		retry := attempt <= s.retries && isIdempotent(method) && retryableUpstream(status, cause)
+		if !retry {
+			s.metrics.RecordUpstreamOutcome(bodyName, attempt, failed)
+			return status, rh, rb, fetchErr, cause, attempt
+		}
+		s.metrics.RecordUpstreamRetry(bodyName)
+		select {
+		case <-s.after(upstreamRetryBackoff(attempt, s.retryBase, oneWay)):
+		case <-ctx.Done():
+			s.metrics.RecordUpstreamOutcome(bodyName, attempt, failed)
+			return status, rh, rb, fetchErr, cause, attempt
+		}
+	}
+}
+
+// fetch does the actual outbound request. Returns status, headers, body, and
+// on failure an error message and its classified cause.
+func (s *DTNStore) fetch(parent context.Context, method, rawURL string, headers map[string]string, body string) (int, map[string]string, string, string, string) {
 	var reqBody io.Reader
 	if body != "" {
 		reqBody = strings.NewReader(body)
 	}
-	req, err := http.NewRequest(method, rawURL, reqBody)
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	// The hooks run on the transport's goroutines, hence the atomics.
+	var dial, ttfb atomic.Pointer[Span]
+	defer func() { dial.Load().End(); ttfb.Load().End() }() // no-ops unless a phase was cut short
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			_, sp := startSpan(parent, "upstream.dial", attr("net.peer", hostPort))
+			dial.Store(sp)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			s.metrics.RecordUpstreamConn(info.Reused)
+			sp := dial.Load()
+			sp.SetAttr("net.conn_reused", info.Reused)
+			sp.End()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			_, sp := startSpan(parent, "upstream.ttfb")
+			ttfb.Store(sp)
+		},
+		GotFirstResponseByte: func() { ttfb.Load().End() },
+	})
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, reqBody)
 	if err != nil {
-		return 0, nil, "", fmt.Sprintf("build request: %v", err)
+		return 0, nil, "", fmt.Sprintf("build request: %v", err), "request"
 	}
 	for k, v := range headers {
 		if !strings.EqualFold(k, "host") {
 			req.Header.Set(k, v)
 		}
 	}
-	client := &http.Client{
-		Timeout: dtnFetchTimeout,
-		// Re-validate every redirect hop against the allowlist. Without this an
-		// open redirect on an allowlisted host could bounce the fetch to
-		// 169.254.169.254 / 127.0.0.1 / internal services and return the body
-		// via /dtn/status - an SSRF that defeats the initial-URL allowlist.
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= 10 {
-				return fmt.Errorf("stopped after 10 redirects")
-			}
-			if _, err := s.security.ValidateHTTPTarget(req.URL.String()); err != nil {
-				return fmt.Errorf("redirect to disallowed target: %w", err)
-			}
-			return nil
-		},
-	}
-	resp, err := client.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
-		return 0, nil, "", fmt.Sprintf("fetch: %v", err)
+		return 0, nil, "", fmt.Sprintf("fetch: %v", err), classifyUpstreamError(err)
 	}
 	defer resp.Body.Close()
 
-	rb, _ := io.ReadAll(io.LimitReader(resp.Body, dtnMaxBodyBytes))
 	rh := make(map[string]string, len(resp.Header))
 	for k := range resp.Header {
 		rh[k] = resp.Header.Get(k)
 	}
-	return resp.StatusCode, rh, string(rb), ""
+	if bodyless(method, resp.StatusCode) {
+		return resp.StatusCode, rh, "", "", ""
+	}
+
+	// The connect and header phases are bounded by the transport; the body
+	// gets a deadline sized to what is left to transfer.
+	deadline := time.AfterFunc(bodyTransferDeadline(resp.ContentLength, s.maxResponse), cancel)
+	_, copySpan := startSpan(parent, "body.copy")
+	rb, err := io.ReadAll(io.LimitReader(resp.Body, s.maxResponse+1))
+	copySpan.SetAttr("bytes", len(rb))
+	copySpan.End()
+	if !deadline.Stop() {
+		return 0, nil, "", "fetch: response body transfer deadline exceeded", "timeout"
+	}
+	if err != nil {
+		return 0, nil, "", fmt.Sprintf("fetch: read body: %v", err), classifyUpstreamError(err)
+	}
+	return resp.StatusCode, rh, string(rb), "", ""
 }
 
 // sweep drops jobs whose retention window has passed.
 func (s *DTNStore) sweep() {
-	now := time.Now()
+	now := s.now()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	changed := false
@@ -279,27 +454,26 @@ func (s *DTNStore) sweep() {
 }
 
 // Add validates and stores a new job, then schedules its fetch.
-func (s *DTNStore) Add(bodyName, method, rawURL string, headers map[string]string, body string, oneWay time.Duration) (*DTNJob, error) {
+func (s *DTNStore) Add(ctx context.Context, bodyName, method, rawURL string, headers map[string]string, body string, oneWay time.Duration) (*DTNJob, error) {
 	validatedURL, err := s.security.ValidateHTTPTarget(rawURL)
 	if err != nil {
+		recordDenial(s.metrics, protoHTTP, err)
 		return nil, err
 	}
 	if method == "" {
 		method = http.MethodGet
 	}
-	if len(body) > dtnMaxBodyBytes {
-		return nil, fmt.Errorf("request body exceeds %d bytes", dtnMaxBodyBytes)
-	}
 
 	j := &DTNJob{
 		ID:          newDTNID(),
 		Body:        bodyName,
 		OneWay:      oneWay,
-		SubmittedAt: time.Now(),
+		SubmittedAt: s.now(),
 		Method:      strings.ToUpper(method),
 		URL:         validatedURL,
 		ReqHeaders:  headers,
 		ReqBody:     body,
+		trace:       context.WithoutCancel(ctx), // outlives the submitting request
 	}
 
 	s.mu.Lock()
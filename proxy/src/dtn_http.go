@@ -10,8 +10,9 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -32,54 +33,161 @@ func (s *Server) handleDTN(w http.ResponseWriter, r *http.Request) {
 	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/dtn/status/"):
 		s.handleDTNStatus(w, r)
 	default:
-		writeJSON(w, http.StatusNotFound, map[string]string{
-			"error": "unknown DTN endpoint; use POST /dtn/send or GET /dtn/status/{id}",
-		})
+		writeJSONError(w, r, http.StatusNotFound, "error.dtn_endpoint")
 	}
 }
 
 func (s *Server) handleDTNSend(w http.ResponseWriter, r *http.Request) {
 	// Abuse control, same as the other proxy paths.
-	release, err := s.limiter.Acquire(clientIP(r.RemoteAddr))
+	release, err := s.limiter.Acquire(s.requestClientIP(r))
 	if err != nil {
 		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
 		return
 	}
 	defer release()
 
+	// Resolve the celestial body: prefer the host subdomain, fall back to "via".
+	bodyName, _ := s.tracedResolveHost(r.Context(), getCelestialObjects(), r.Host)
+
+	// Honour Expect: 100-continue before touching the body. When the body is
+	// known from the host the interim response is held back by the uplink
+	// light time; a "via" in the JSON can't be known until the body is read.
+	var uplink time.Duration
+	if bodyName != "" {
+		if distance, err := getCurrentDistance(bodyName); err == nil {
+			uplink = s.oneWay(bodyName, distance)
+		}
+	}
+	limit := s.sizeLimits.requestLimit(bodyName)
+	if uplink > 0 {
+		// The wait for 100 Continue can outlast the request timeout.
+		s.holdFor(w, uplink)
+	}
+	if !expectContinue(w, r, s.clk(), limit, uplink) {
+		return
+	}
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
 	var req dtnSendRequest
-	dec := json.NewDecoder(io.LimitReader(r.Body, dtnMaxBodyBytes))
+	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.writeRequestTooLarge(w, bodyName, limit)
+			return
+		}
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body: " + err.Error()})
 		return
 	}
 
-	// Resolve the celestial body: prefer the host subdomain, fall back to "via".
-	bodyName := s.resolveCelestialHost(r.Host)
 	if bodyName == "" && req.Via != "" {
 		if obj, ok := findObjectByName(getCelestialObjects(), req.Via); ok {
 			bodyName = obj.Name
 		}
 	}
 	if bodyName == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "no celestial body: POST to a body host (e.g. voyager-1.latency.space) or set \"via\"",
-		})
+		writeJSONError(w, r, http.StatusBadRequest, "error.dtn_body")
+		return
+	}
+	// A "via" body's class, and so its cap, is only known now.
+	if limit = s.sizeLimits.requestLimit(bodyName); limit > 0 {
+		if dec.InputOffset() > limit {
+			s.writeRequestTooLarge(w, bodyName, limit)
+			return
+		}
+		w.Header().Set(requestAllowanceHeader, strconv.FormatInt(limit-dec.InputOffset(), 10))
+	}
+	if err := checkProxyable(bodyName); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error(), "code": notProxyableCode})
 		return
 	}
 
-	oneWay := CalculateLatency(getCurrentDistance(bodyName))
+	distance, err := getCurrentDistance(bodyName)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	oneWay := s.oneWay(bodyName, distance)
 	// Refuse bodies with negligible latency (Earth is 0). Without the light-travel
 	// friction DTN would be a plain open proxy, which the SOCKS path also guards
-	// against; keep Earth non-proxyable. Skipped in test mode, like the SOCKS guard.
-	if !isTestMode.Load() && oneWay < time.Second {
-		writeJSON(w, http.StatusBadRequest, map[string]string{
-			"error": bodyName + " has insufficient latency to proxy (it would be an open proxy)",
+	// against; keep Earth non-proxyable. Tests lower the floor, like the SOCKS guard.
+	// A near-Earth asteroid on a flyby gets BODY_TOO_CLOSE and when it clears.
+	if err := checkLatencyFloor(bodyName, oneWay, s.security.minLatency); err != nil {
+		var tooClose *BodyTooCloseError
+		if errors.As(err, &tooClose) {
+			writeJSON(w, http.StatusBadRequest, tooClose.fields())
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Outside a DSN pass nothing can be uplinked. (Occlusion isn't checked:
+	// the delay-tolerant path is meant to ride out geometry.)
+	now := linkTime()
+	_, linkSpan := startSpan(r.Context(), "link.check", attr("celestial.body", bodyName))
+	outage, down := contactOutage(bodyName, now)
+	linkSpan.SetAttr("link.up", !down)
+	linkSpan.End()
+	if down {
+		retry := math.Ceil(outage.NextContact.Sub(now).Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry)))
+		s.recent.Record(RecentTransaction{
+			Time:     s.now(),
+			ClientIP: s.requestClientIP(r),
+			Protocol: "dtn",
+			Body:     bodyName,
+			Target:   req.URL,
+			Outcome:  outage.outcome(),
+		})
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"error":       bodyName + ": " + outage.String(),
+			"code":        noContactWindowCode,
+			"nextContact": outage.NextContact,
+		})
+		return
+	}
+
+	// A target known to be down is reported now, not a round trip later
+	// (preflight.go).
+	if addr, res, up := s.preflightURL(r, req.URL); !up {
+		s.recent.Record(RecentTransaction{
+			Time:     s.now(),
+			ClientIP: s.requestClientIP(r),
+			Protocol: "dtn",
+			Body:     bodyName,
+			Target:   req.URL,
+			Outcome:  outcomeError,
+		})
+		writeJSON(w, http.StatusBadGateway, map[string]interface{}{
+			"error":           addr + " is unreachable (" + res.Cause + ")",
+			"code":            preflightUnreachableCode,
+			"cause":           res.Cause,
+			"probeAgeSeconds": s.preflight.Age(res).Seconds(),
 		})
 		return
 	}
 
-	job, err := s.dtn.Add(bodyName, req.Method, req.URL, req.Headers, req.Payload, oneWay)
+	job, err := s.dtn.Add(r.Context(), bodyName, req.Method, req.URL, req.Headers, req.Payload, oneWay)
+	tx := RecentTransaction{
+		Time:     s.now(),
+		ClientIP: s.requestClientIP(r),
+		Protocol: "dtn",
+		Body:     bodyName,
+		Target:   req.URL,
+		Latency:  oneWay,
+		BytesIn:  int64(len(req.Payload)),
+		Outcome:  outcomeOK,
+	}
+	if err != nil {
+		tx.Outcome = outcomeDenied
+		if errors.Is(err, errDTNStoreFull) {
+			tx.Outcome = outcomeError
+		}
+	}
+	s.recent.Record(tx)
 	if err != nil {
 		if errors.Is(err, errDTNStoreFull) {
 			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
@@ -92,7 +200,7 @@ func (s *Server) handleDTNSend(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusAccepted, map[string]interface{}{
 		"id":                   job.ID,
 		"body":                 job.Body,
-		"state":                job.state(time.Now()),
+		"state":                job.state(s.now()),
 		"oneWayLatencySeconds": job.OneWay.Seconds(),
 		"submittedAt":          job.SubmittedAt,
 		"arrivesAt":            job.arrivalAt(),
@@ -104,16 +212,16 @@ func (s *Server) handleDTNSend(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleDTNStatus(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/dtn/status/")
 	if id == "" {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing job id"})
+		writeJSONError(w, r, http.StatusBadRequest, "error.dtn_job_id")
 		return
 	}
 	job, ok := s.dtn.Get(id)
 	if !ok {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such job (it may have expired)"})
+		writeJSONError(w, r, http.StatusNotFound, "error.dtn_no_job")
 		return
 	}
 
-	now := time.Now()
+	now := s.now()
 	state := job.state(now)
 	out := map[string]interface{}{
 		"id":                   job.ID,
@@ -126,15 +234,41 @@ func (s *Server) handleDTNStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// The response is only revealed once it has finished travelling back to Earth.
+	if (state == "delivered" || state == "failed") && job.Attempts > 0 {
+		w.Header().Set("X-Latency-Space-Upstream-Attempts", strconv.Itoa(job.Attempts))
+	}
+	if (state == "delivered" || state == "failed") && job.Coalesced {
+		w.Header().Set(coalescedHeader, "true")
+	}
 	switch state {
 	case "delivered":
-		out["response"] = map[string]interface{}{
+		if bodyless(job.Method, job.RespStatus) {
+			w.Header().Set(bodySkippedHeader, "true")
+		}
+		response := map[string]interface{}{
 			"status":  job.RespStatus,
 			"headers": job.RespHeaders,
 			"body":    job.RespBody,
 		}
+		out["response"] = response
+		w.Header().Set(responseAllowanceHeader, strconv.FormatInt(max(s.dtn.maxResponse-int64(len(job.RespBody)), 0), 10))
+		if job.RespTruncated {
+			// The mark follows the body it qualifies, as it would on a
+			// response streamed until the cap cut it off.
+			response["truncated"] = true
+			w.Header().Set("Trailer", truncatedTrailer)
+			writeJSON(w, http.StatusOK, out)
+			w.Header().Set(truncatedTrailer, "true")
+			return
+		}
 	case "failed":
+		// The target itself failed (refused, timed out, ...), not the
+		// simulation: report it as a gateway error with the cause.
 		out["error"] = job.FetchErr
+		out["code"] = upstreamErrorCode
+		out["cause"] = job.FetchCause
+		writeJSON(w, http.StatusBadGateway, out)
+		return
 	}
 
 	writeJSON(w, http.StatusOK, out)
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -18,8 +22,8 @@ import (
 // no-op, which is what we want here.
 func newDTNTestServer(t *testing.T) *Server {
 	t.Helper()
-	sec := NewSecurityValidator()
-	s := &Server{security: sec, metrics: NewTestMetricsCollector(), httpEnabled: true}
+	sec := newTestSecurity()
+	s := &Server{security: sec, metrics: NewRecordingMetrics(), httpEnabled: true, sizeLimits: defaultSizeLimits}
 	s.dtn = NewDTNStore(t.TempDir()+"/dtn.json", sec, s.metrics)
 	return s
 }
@@ -50,7 +54,6 @@ func dtnStatus(t *testing.T, s *Server, id string) (int, map[string]interface{})
 func TestDTNLifecycle(t *testing.T) {
 	// One-way latency of 40ms keeps the test fast but leaves a window where the
 	// job is observably in transit before it is delivered (~80ms round trip).
-	defer setupTestModeWithLatency(40 * time.Millisecond)()
 	setCelestialObjects(celestial.InitSolarSystemObjects())
 
 	// Destination echo server.
@@ -61,6 +64,7 @@ func TestDTNLifecycle(t *testing.T) {
 	defer dest.Close()
 
 	s := newDTNTestServer(t)
+	s.timing = fixedLatency(40 * time.Millisecond)
 
 	code, out := dtnSend(t, s, "mars.latency.space",
 		fmt.Sprintf(`{"url":%q,"method":"GET"}`, dest.URL))
@@ -107,13 +111,74 @@ func TestDTNLifecycle(t *testing.T) {
 	if body, _ := resp["body"].(string); body != "hello from space" {
 		t.Errorf("expected echoed body, got %q", body)
 	}
+
+	// The simulated round trip and the real fetch are recorded separately.
+	if n := len(recorded(t, s.metrics).Observations(metricSimulatedLatency, "Mars", "dtn")); n != 1 {
+		t.Errorf("expected one simulated latency observation, got %d", n)
+	}
+	if n := len(recorded(t, s.metrics).Observations(metricUpstreamDuration, "Mars", "dtn")); n != 1 {
+		t.Errorf("expected one upstream duration observation, got %d", n)
+	}
+	m := recorded(t, s.metrics)
+	if n := m.Count(metricRequests, "Mars", "dtn"); n != 1 {
+		t.Errorf("requests_total{Mars,dtn} = %v, want 1", n)
+	}
+	if n := m.Count(metricBandwidth, "Mars", dirToClient, protoHTTP); n != float64(len("hello from space")) {
+		t.Errorf("bytes to client = %v, want %d", n, len("hello from space"))
+	}
+	if n := m.Count(metricUpstreamOutcomes, "Mars", "ok"); n != 1 {
+		t.Errorf("upstream ok outcomes = %v, want 1", n)
+	}
+}
+
+// TestDTNFakeClock runs a twelve-minute Mars round trip on a fake clock:
+// submission, the fetch at arrival and delivery all follow the store's
+// clock, not the time package.
+func TestDTNFakeClock(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello from space")
+	}))
+	defer dest.Close()
+
+	const oneWay = 12 * time.Minute
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	s := newDTNTestServer(t)
+	s.timing = timing{clock: clock, latencies: &testLatencies{fixed: oneWay}}
+	s.dtn.timing = s.timing
+
+	_, out := dtnSend(t, s, "mars.latency.space", fmt.Sprintf(`{"url":%q}`, dest.URL))
+	id, _ := out["id"].(string)
+	if out["submittedAt"] != start.Format(time.RFC3339) || out["state"] != "in_transit" {
+		t.Fatalf("send: %v, want in transit from %v", out, start)
+	}
+	state := func() any {
+		_, st := dtnStatus(t, s, id)
+		return st["state"]
+	}
+
+	clock.BlockUntil(t, 1) // the fetch timer
+	clock.Advance(oneWay - time.Second)
+	if got := state(); got != "in_transit" {
+		t.Errorf("a second before arrival: %v, want in_transit", got)
+	}
+	clock.Advance(time.Second)
+	waitFor(t, func() bool { return state() == "returning" })
+	if job, _ := s.dtn.Get(id); !job.FetchedAt.Equal(start.Add(oneWay)) {
+		t.Errorf("fetched at %v, want %v", job.FetchedAt, start.Add(oneWay))
+	}
+	clock.Advance(oneWay)
+	if got := state(); got != "delivered" {
+		t.Errorf("after the round trip: %v, want delivered", got)
+	}
 }
 
 // TestDTNRejectsNonAllowlistedHost verifies the allowlist is enforced on submit.
 func TestDTNRejectsNonAllowlistedHost(t *testing.T) {
-	defer setupTestMode()()
 	setCelestialObjects(celestial.InitSolarSystemObjects())
 	s := newDTNTestServer(t)
+	s.timing = fixedLatency(testLatency)
 
 	code, out := dtnSend(t, s, "mars.latency.space",
 		`{"url":"https://evil.not-listed-anywhere.example/"}`)
@@ -124,9 +189,9 @@ func TestDTNRejectsNonAllowlistedHost(t *testing.T) {
 
 // TestDTNRequiresBody verifies a body must be identified (host or "via").
 func TestDTNRequiresBody(t *testing.T) {
-	defer setupTestMode()()
 	setCelestialObjects(celestial.InitSolarSystemObjects())
 	s := newDTNTestServer(t)
+	s.timing = fixedLatency(testLatency)
 
 	// Apex host, no "via".
 	code, _ := dtnSend(t, s, "latency.space", `{"url":"https://example.com/"}`)
@@ -147,10 +212,9 @@ func TestDTNRequiresBody(t *testing.T) {
 // TestDTNRedirectSSRFBlocked verifies a redirect to a non-allowlisted host is
 // refused (not followed), so the response is never delivered.
 func TestDTNRedirectSSRFBlocked(t *testing.T) {
-	defer setupTestModeWithLatency(40 * time.Millisecond)()
 	setCelestialObjects(celestial.InitSolarSystemObjects())
 
-	// Allowlisted-in-test-mode loopback origin that redirects to a host that is
+	// Loopback origin (allowed by the test validator) that redirects to a host that is
 	// NOT on the allowlist (simulating an open redirect -> internal/SSRF target).
 	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "https://evil.not-listed-anywhere.example/secret", http.StatusFound)
@@ -158,6 +222,7 @@ func TestDTNRedirectSSRFBlocked(t *testing.T) {
 	defer dest.Close()
 
 	s := newDTNTestServer(t)
+	s.timing = fixedLatency(40 * time.Millisecond)
 	code, out := dtnSend(t, s, "mars.latency.space", fmt.Sprintf(`{"url":%q}`, dest.URL))
 	if code != http.StatusAccepted {
 		t.Fatalf("send: expected 202, got %d (%v)", code, out)
@@ -181,17 +246,18 @@ func TestDTNRedirectSSRFBlocked(t *testing.T) {
 	if _, leaked := final["response"]; leaked {
 		t.Error("SSRF: a blocked redirect must not deliver a response body")
 	}
+	if final["cause"] != "redirect" {
+		t.Errorf("expected cause redirect, got %v", final["cause"])
+	}
 }
 
 // TestDTNRejectsEarth verifies zero/negligible-latency bodies are refused
-// outside test mode, keeping DTN from being an open proxy.
+// under the production latency floor, keeping DTN from being an open proxy.
 func TestDTNRejectsEarth(t *testing.T) {
-	orig := isTestMode.Load()
-	isTestMode.Store(false) // exercise the production guard
-	defer isTestMode.Store(orig)
 	setCelestialObjects(celestial.InitSolarSystemObjects())
 
 	s := newDTNTestServer(t)
+	s.security.minLatency = minProxyLatency // exercise the production guard
 	code, out := dtnSend(t, s, "earth.latency.space", `{"url":"https://example.com/"}`)
 	if code != http.StatusBadRequest {
 		t.Fatalf("expected 400 for Earth (zero latency), got %d (%v)", code, out)
@@ -200,13 +266,12 @@ func TestDTNRejectsEarth(t *testing.T) {
 
 // TestDTNStoreCapacity verifies Add refuses new jobs once the store is full.
 func TestDTNStoreCapacity(t *testing.T) {
-	defer setupTestMode()()
-	store := NewDTNStore(t.TempDir()+"/dtn.json", NewSecurityValidator(), NewTestMetricsCollector())
+	store := NewDTNStore(t.TempDir()+"/dtn.json", NewSecurityValidator(), NewRecordingMetrics())
 	// Pre-fill the map to the cap without scheduling real fetches.
 	for i := 0; i < dtnMaxJobs; i++ {
 		store.jobs[fmt.Sprintf("job-%d", i)] = &DTNJob{ID: fmt.Sprintf("job-%d", i)}
 	}
-	_, err := store.Add("Mars", "GET", "https://example.com/", nil, "", time.Second)
+	_, err := store.Add(context.Background(), "Mars", "GET", "https://example.com/", nil, "", time.Second)
 	if !errors.Is(err, errDTNStoreFull) {
 		t.Fatalf("expected errDTNStoreFull at capacity, got %v", err)
 	}
@@ -223,23 +288,189 @@ func TestDTNStatusUnknownJob(t *testing.T) {
 
 // TestDTNPersistenceReload verifies jobs survive a store reload (process restart).
 func TestDTNPersistenceReload(t *testing.T) {
-	defer setupTestModeWithLatency(50 * time.Millisecond)()
 	setCelestialObjects(celestial.InitSolarSystemObjects())
 
 	dir := t.TempDir()
 	path := dir + "/dtn.json"
-	sec := NewSecurityValidator()
+	sec := newTestSecurity()
 
-	store1 := NewDTNStore(path, sec, NewTestMetricsCollector())
-	// Loopback (allowed in test mode) so the scheduled fetch stays local.
-	job, err := store1.Add("Mars", "GET", "http://127.0.0.1:80/", nil, "", 50*time.Millisecond)
+	store1 := NewDTNStore(path, sec, NewRecordingMetrics())
+	// Loopback (allowed by the test validator) so the scheduled fetch stays local.
+	job, err := store1.Add(context.Background(), "Mars", "GET", "http://127.0.0.1:80/", nil, "", 50*time.Millisecond)
 	if err != nil {
 		t.Fatalf("add: %v", err)
 	}
 
 	// A fresh store loads the persisted job.
-	store2 := NewDTNStore(path, sec, NewTestMetricsCollector())
+	store2 := NewDTNStore(path, sec, NewRecordingMetrics())
 	if got, ok := store2.Get(job.ID); !ok || got.Body != "Mars" {
 		t.Fatalf("reloaded store missing job %s", job.ID)
 	}
 }
+
+// TestDTNRangeRequests fetches a file in ranged pieces through DTN jobs: the
+// Range and If-Range headers reach the destination, and the 206 status and
+// Content-Range come back, so the pieces reassemble byte for byte.
+func TestDTNRangeRequests(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	content := strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz\n", 100)
+	modTime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "file.txt", modTime, strings.NewReader(content))
+	}))
+	defer dest.Close()
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(5 * time.Millisecond)
+
+	type response struct {
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+		Body    string            `json:"body"`
+	}
+	fetch := func(headers map[string]string) response {
+		t.Helper()
+		hdrs, _ := json.Marshal(headers)
+		code, out := dtnSend(t, s, "mars.latency.space", fmt.Sprintf(`{"url":%q,"headers":%s}`, dest.URL, hdrs))
+		if code != http.StatusAccepted {
+			t.Fatalf("send: expected 202, got %d (%v)", code, out)
+		}
+		var st struct {
+			Response response `json:"response"`
+		}
+		if err := json.Unmarshal(waitDTN(t, s, out["id"].(string)).Body.Bytes(), &st); err != nil {
+			t.Fatal(err)
+		}
+		return st.Response
+	}
+
+	var got strings.Builder
+	for from := 0; from < len(content); from += 1000 {
+		to := min(from+999, len(content)-1)
+		resp := fetch(map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", from, to), "If-Range": `"v1"`})
+		if resp.Status != http.StatusPartialContent {
+			t.Fatalf("bytes=%d-%d: status %d, want 206", from, to, resp.Status)
+		}
+		if want := fmt.Sprintf("bytes %d-%d/%d", from, to, len(content)); resp.Headers["Content-Range"] != want {
+			t.Errorf("Content-Range %q, want %q", resp.Headers["Content-Range"], want)
+		}
+		got.WriteString(resp.Body)
+	}
+	if got.String() != content {
+		t.Error("ranged pieces don't reassemble to the original file")
+	}
+
+	// A stale validator gets the whole file instead of a range.
+	resp := fetch(map[string]string{"Range": "bytes=100-", "If-Range": `"v0"`})
+	if resp.Status != http.StatusOK || resp.Body != content {
+		t.Errorf("stale If-Range: status %d and %d bytes, want 200 and the full file", resp.Status, len(resp.Body))
+	}
+}
+
+// pollDTNDelivered polls job id until it is delivered and returns the
+// status response with its headers.
+func pollDTNDelivered(t *testing.T, s *Server, id string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		s.handleDTN(rec, httptest.NewRequest(http.MethodGet, "http://x/dtn/status/"+id, nil))
+		var out map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		if out["state"] == "delivered" {
+			resp, _ := out["response"].(map[string]interface{})
+			return rec, resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job never delivered; last state %v", out["state"])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestDTNHeadSkipsBody sends a HEAD to an upstream that wrongly answers with
+// a body: nothing of it is stored, and the delivery says the body was skipped.
+func TestDTNHeadSkipsBody(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 18\r\nContent-Type: text/plain\r\n\r\nbody a HEAD lacks\n")
+			}()
+		}
+	}()
+
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(5 * time.Millisecond)
+	code, out := dtnSend(t, s, "mars.latency.space", fmt.Sprintf(`{"url":"http://%s/","method":"HEAD"}`, ln.Addr()))
+	if code != http.StatusAccepted {
+		t.Fatalf("send: expected 202, got %d (%v)", code, out)
+	}
+	rec, resp := pollDTNDelivered(t, s, out["id"].(string))
+	if resp["status"].(float64) != http.StatusOK {
+		t.Errorf("status %v, want 200", resp["status"])
+	}
+	if body, _ := resp["body"].(string); body != "" {
+		t.Errorf("HEAD stored a body: %q", body)
+	}
+	if headers, _ := resp["headers"].(map[string]interface{}); headers["Content-Length"] != "18" {
+		t.Errorf("HEAD headers %v should keep the upstream Content-Length", headers)
+	}
+	if rec.Header().Get(bodySkippedHeader) != "true" {
+		t.Errorf("missing %s on a HEAD delivery", bodySkippedHeader)
+	}
+}
+
+// TestDTNNotModified checks conditional headers reach the upstream as sent
+// and its 304 comes back without a body.
+func TestDTNNotModified(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` && r.Header.Get("If-Modified-Since") == "Wed, 05 Nov 2025 00:00:00 GMT" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "full body")
+	}))
+	defer dest.Close()
+
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(5 * time.Millisecond)
+	code, out := dtnSend(t, s, "mars.latency.space", fmt.Sprintf(
+		`{"url":%q,"headers":{"If-None-Match":"\"v1\"","If-Modified-Since":"Wed, 05 Nov 2025 00:00:00 GMT"}}`, dest.URL))
+	if code != http.StatusAccepted {
+		t.Fatalf("send: expected 202, got %d (%v)", code, out)
+	}
+	rec, resp := pollDTNDelivered(t, s, out["id"].(string))
+	if resp["status"].(float64) != http.StatusNotModified {
+		t.Fatalf("status %v, want 304 (conditional headers not forwarded?)", resp["status"])
+	}
+	if body, _ := resp["body"].(string); body != "" {
+		t.Errorf("304 carried a body: %q", body)
+	}
+	if rec.Header().Get(bodySkippedHeader) != "true" {
+		t.Errorf("missing %s on a 304 delivery", bodySkippedHeader)
+	}
+
+	// An unconditional GET is a normal delivery.
+	_, out = dtnSend(t, s, "mars.latency.space", fmt.Sprintf(`{"url":%q}`, dest.URL))
+	rec, resp = pollDTNDelivered(t, s, out["id"].(string))
+	if resp["body"] != "full body" || rec.Header().Get(bodySkippedHeader) != "" {
+		t.Errorf("plain GET: body %v, %s %q", resp["body"], bodySkippedHeader, rec.Header().Get(bodySkippedHeader))
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/latency-space/shared/celestial"
 )
@@ -14,10 +15,11 @@ import (
 // previously 404'd.
 func TestDebugStatusEndpoint(t *testing.T) {
 	setCelestialObjects(celestial.InitSolarSystemObjects())
-	s := &Server{security: NewSecurityValidator(), metrics: NewTestMetricsCollector(), httpEnabled: true}
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), httpEnabled: true, adminToken: "t0k"}
 	s.dtn = NewDTNStore(t.TempDir()+"/dtn.json", s.security, s.metrics)
 
 	req := httptest.NewRequest(http.MethodGet, "http://latency.space/_debug/status", nil)
+	req.Header.Set("Authorization", "Bearer t0k")
 	rec := httptest.NewRecorder()
 	s.handleHTTP(rec, req)
 
@@ -35,20 +37,94 @@ func TestDebugStatusEndpoint(t *testing.T) {
 	}
 }
 
-// TestRobotsTxt verifies body hosts serve a Disallow-all robots.txt.
+// TestRobotsTxt verifies body hosts serve a robots.txt that only allows the
+// info page and the API, instantly and even to blocked crawlers.
 func TestRobotsTxt(t *testing.T) {
-	s := &Server{security: NewSecurityValidator(), metrics: NewTestMetricsCollector()}
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), crawlers: newCrawlerBlocker(defaultCrawlerAgents),
+		timing: fixedLatency(time.Second)}
 	req := httptest.NewRequest(http.MethodGet, "http://mars.latency.space/robots.txt", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)")
 	rec := httptest.NewRecorder()
+	start := time.Now()
 	s.handleHTTP(rec, req)
 
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("robots.txt took %v; it must not be delayed", elapsed)
+	}
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", rec.Code)
 	}
 	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
 		t.Errorf("expected text/plain, got %q", ct)
 	}
-	if !strings.Contains(rec.Body.String(), "Disallow: /") {
-		t.Errorf("robots.txt should disallow all, got %q", rec.Body.String())
+	for _, line := range []string{"Allow: /$", "Allow: /api/", "Disallow: /"} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("robots.txt missing %q, got %q", line, rec.Body.String())
+		}
+	}
+}
+
+// TestBlockCrawlers verifies listed crawlers get an immediate 403 in blocking
+// mode while ordinary clients are unaffected.
+func TestBlockCrawlers(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	get := func(s *Server, ua string) (int, time.Duration) {
+		req := httptest.NewRequest(http.MethodGet, "http://mars.latency.space/api/distance?from=earth&to=mars", nil)
+		req.Header.Set("User-Agent", ua)
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		s.handleHTTP(rec, req)
+		return rec.Code, time.Since(start)
+	}
+	const googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	const browser = "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0"
+
+	blocking := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), crawlers: newCrawlerBlocker(defaultCrawlerAgents),
+		timing: fixedLatency(time.Second)}
+	if code, elapsed := get(blocking, googlebot); code != http.StatusForbidden || elapsed > 100*time.Millisecond {
+		t.Errorf("Googlebot: expected an immediate 403, got %d after %v", code, elapsed)
+	}
+	if code, _ := get(blocking, browser); code != http.StatusOK {
+		t.Errorf("browser: expected 200 in blocking mode, got %d", code)
+	}
+
+	open := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), timing: fixedLatency(time.Second)}
+	if code, _ := get(open, googlebot); code != http.StatusOK {
+		t.Errorf("Googlebot without -block-crawlers: expected 200, got %d", code)
+	}
+
+	custom := newCrawlerBlocker(" ExampleBot , ,otherbot")
+	if !custom.matches("examplebot/1.0") || custom.matches(googlebot) || custom.matches("") {
+		t.Error("custom crawler list not matched as configured")
+	}
+}
+
+// TestBodyHostMethods checks a body host answers OPTIONS on any path with
+// what it serves, and HEAD for its info page with headers alone.
+func TestBodyHostMethods(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	do := func(method, url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(method, url, nil))
+		return rec
+	}
+
+	rec := do(http.MethodOptions, "http://mars.latency.space/some/arbitrary/path")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") != "GET, HEAD, OPTIONS" || rec.Body.Len() != 0 {
+		t.Errorf("OPTIONS: %d, Allow %q, body %q", rec.Code, rec.Header().Get("Allow"), rec.Body)
+	}
+	// A preflight for the API keeps its CORS answer.
+	if rec := do(http.MethodOptions, "http://mars.latency.space/api/bodies"); rec.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Error("API preflight lost its CORS headers")
+	}
+
+	rec = do(http.MethodHead, "http://mars.latency.space/")
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get(bodySkippedHeader) != "true" {
+		t.Errorf("HEAD: %d, body %d bytes, %s %q", rec.Code, rec.Body.Len(), bodySkippedHeader, rec.Header().Get(bodySkippedHeader))
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("HEAD Content-Type %q, want the page's", ct)
 	}
 }
@@ -0,0 +1,115 @@
+// epoch.go - pinning the simulation epoch for classroom scenarios.
+//
+// A class working through an exercise wants every student to see the same
+// numbers whenever they connect. -fixed-epoch 2025-11-05T00:00:00Z, or an
+// admin POST to /_debug/epoch, freezes the instant used for positions,
+// distances, occlusion, ground-station geometry and contact windows.
+// Timestamps in logs, metrics and responses, and the latency waits
+// themselves, stay on the wall clock.
+//
+// While an epoch is pinned the distance cache is filled once for it and
+// never aged, and searches for the next visibility or contact window start
+// from it. An explicit ?t= (or ?at=) on /api/distance or /api/state still wins.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var pinnedEpochPtr atomic.Pointer[time.Time]
+
+// pinnedEpoch returns the pinned simulation epoch, if there is one.
+func pinnedEpoch() (time.Time, bool) {
+	if p := pinnedEpochPtr.Load(); p != nil {
+		return *p, true
+	}
+	return time.Time{}, false
+}
+
+// setPinnedEpoch pins the simulation epoch to t; the zero time unpins it.
+// The distance cache is dropped so the next lookup describes the new epoch.
+func setPinnedEpoch(t time.Time) {
+	if t.IsZero() {
+		pinnedEpochPtr.Store(nil)
+	} else {
+		t = t.UTC()
+		pinnedEpochPtr.Store(&t)
+	}
+	invalidateDistanceCache()
+}
+
+// simTime returns the instant the simulation describes at wall-clock time
+// now: the pinned epoch if there is one, else now.
+func simTime(now time.Time) time.Time {
+	if epoch, ok := pinnedEpoch(); ok {
+		return epoch
+	}
+	return now
+}
+
+// parseEpoch parses an RFC 3339 instant for -fixed-epoch and /_debug/epoch.
+func parseEpoch(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid epoch %q (want RFC3339, e.g. 2025-11-05T00:00:00Z)", s)
+	}
+	return t, nil
+}
+
+// EpochState is the JSON exchanged with /_debug/epoch. A null or empty
+// epoch means the simulation follows the wall clock.
+type EpochState struct {
+	Epoch *time.Time `json:"epoch"`
+}
+
+// epochState reports the current pin.
+func epochState() EpochState {
+	if epoch, ok := pinnedEpoch(); ok {
+		return EpochState{Epoch: &epoch}
+	}
+	return EpochState{}
+}
+
+// handleEpoch serves the admin /_debug/epoch. GET shows the pin; POST
+// {"epoch":"2025-11-05T00:00:00Z"} sets it and {"epoch":""} clears it.
+func (s *Server) handleEpoch(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, epochState())
+	case http.MethodPost:
+		var edit struct {
+			Epoch string `json:"epoch"`
+		}
+		dec := json.NewDecoder(io.LimitReader(r.Body, 1<<10))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&edit); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid edit: " + err.Error()})
+			return
+		}
+		var epoch time.Time
+		if edit.Epoch != "" {
+			var err error
+			if epoch, err = parseEpoch(edit.Epoch); err != nil {
+				writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		setPinnedEpoch(epoch)
+		if epoch.IsZero() {
+			log.Printf("Simulation epoch unpinned; following the wall clock")
+		} else {
+			log.Printf("Simulation epoch pinned to %s", epoch.UTC().Format(time.RFC3339))
+		}
+		writeJSON(w, http.StatusOK, epochState())
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET or POST"})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// classEpoch is a Wednesday.
+var classEpoch = time.Date(2025, 11, 5, 0, 0, 0, 0, time.UTC)
+
+// withPinnedEpoch pins the simulation epoch for the test.
+func withPinnedEpoch(t *testing.T, epoch time.Time) {
+	t.Helper()
+	setPinnedEpoch(epoch)
+	t.Cleanup(func() { setPinnedEpoch(time.Time{}) })
+}
+
+// TestPinnedEpochFreezesDistances checks distances describe the pinned
+// epoch, stay identical hours of fake time apart, and are computed once.
+func TestPinnedEpochFreezesDistances(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	withObjects(t, objects)
+	advance := fakeDistanceClock(t, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	withPinnedEpoch(t, classEpoch)
+
+	earth, _ := findObjectByName(objects, "Earth")
+	mars, _ := findObjectByName(objects, "Mars")
+	want := ApparentDistance(earth, mars, objects, classEpoch)
+	if got := mustDistance(t, "Mars"); got != want {
+		t.Fatalf("Mars at the pinned epoch: %v km, want %v", got, want)
+	}
+	DistanceCacheMutex.RLock()
+	filled := distanceUpdated
+	DistanceCacheMutex.RUnlock()
+
+	for _, hours := range []int{2, 30, 24 * 9} {
+		advance(time.Date(2026, 3, 1, 12+hours, 0, 0, 0, time.UTC))
+		if got := mustDistance(t, "Mars"); got != want {
+			t.Errorf("after %dh: Mars at %v km, want %v", hours, got, want)
+		}
+		status := buildStatusResponse(currentSnapshot(distanceClock()), distanceClock())
+		if !status.ComputedAt.Equal(classEpoch) || status.Pinned == nil || !status.Pinned.Equal(classEpoch) {
+			t.Errorf("after %dh: computedAt %v, pinnedEpoch %v", hours, status.ComputedAt, status.Pinned)
+		}
+		if status.Timestamp.Equal(classEpoch) {
+			t.Errorf("after %dh: timestamp should stay on the wall clock", hours)
+		}
+	}
+	DistanceCacheMutex.RLock()
+	refilled := distanceUpdated != filled
+	DistanceCacheMutex.RUnlock()
+	if refilled {
+		t.Error("the distance cache was refreshed while the epoch was pinned")
+	}
+
+	// Moving the pin recomputes.
+	setPinnedEpoch(classEpoch.AddDate(0, 6, 0))
+	if got := mustDistance(t, "Mars"); got == want {
+		t.Error("re-pinning six months later left Mars where it was")
+	}
+}
+
+// TestPinnedEpochOverride checks an explicit ?t= or ?at= on /api/distance
+// wins over the pin, and that without one the pin is used.
+func TestPinnedEpochOverride(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	withPinnedEpoch(t, classEpoch)
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+
+	timestamp := func(query string) string {
+		t.Helper()
+		code, out := distanceRequest(t, s, "from=earth&to=mars"+query)
+		if code != http.StatusOK {
+			t.Fatalf("%q: expected 200, got %d (%v)", query, code, out)
+		}
+		return out["timestamp"].(string)
+	}
+	if got := timestamp(""); got != "2025-11-05T00:00:00Z" {
+		t.Errorf("no override: solved for %s, want the pinned epoch", got)
+	}
+	for _, q := range []string{"&t=2030-01-01T00:00:00Z", "&at=2030-01-01T00:00:00Z"} {
+		if got := timestamp(q); got != "2030-01-01T00:00:00Z" {
+			t.Errorf("%s: solved for %s, want the override", q, got)
+		}
+	}
+}
+
+// TestPinnedEpochContactSearch checks contact windows are searched from the
+// pinned epoch rather than the link clock.
+func TestPinnedEpochContactSearch(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	withContactSchedules(t, "mars=Mon 09:00-10:00UTC")
+	fakeLinkClock(t, time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)) // inside a Monday pass
+	withPinnedEpoch(t, classEpoch)
+
+	if got := linkTime(); !got.Equal(classEpoch) {
+		t.Fatalf("linkTime %v, want the pinned epoch", got)
+	}
+	outage, down := contactOutage("Mars", linkTime())
+	if want := time.Date(2025, 11, 10, 9, 0, 0, 0, time.UTC); !down || !outage.NextContact.Equal(want) {
+		t.Errorf("contact from the pinned Wednesday: down=%v next %v, want %v", down, outage.NextContact, want)
+	}
+}
+
+func TestEpochEndpoint(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	t.Cleanup(func() { setPinnedEpoch(time.Time{}) })
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), adminToken: "t0k"}
+	call := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://latency.space/_debug/epoch", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer t0k")
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, req)
+		return rec
+	}
+
+	if rec := call(http.MethodPost, `{"epoch":"2025-11-05T00:00:00Z"}`); rec.Code != http.StatusOK {
+		t.Fatalf("pin: %d %s", rec.Code, rec.Body)
+	}
+	if epoch, ok := pinnedEpoch(); !ok || !epoch.Equal(classEpoch) {
+		t.Fatalf("pinned %v, %v", epoch, ok)
+	}
+	var got EpochState
+	if rec := call(http.MethodGet, ""); json.Unmarshal(rec.Body.Bytes(), &got) != nil || got.Epoch == nil || !got.Epoch.Equal(classEpoch) {
+		t.Errorf("GET: %s", rec.Body)
+	}
+
+	origTemplate := infoTemplate
+	defer func() { infoTemplate = origTemplate }()
+	infoTemplate = template.Must(template.ParseFiles("templates/info_page.html"))
+	rec := httptest.NewRecorder()
+	s.displayCelestialInfo(rec, httptest.NewRequest("GET", "/", nil), currentSnapshot(distanceClock()), "Mars")
+	if !strings.Contains(rec.Body.String(), "Simulation epoch pinned to Wed 2025-11-05 00:00 UTC") {
+		t.Error("info page does not say the epoch is pinned")
+	}
+
+	if rec := call(http.MethodPost, `{"epoch":"next tuesday"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("bad epoch: %d, want 422", rec.Code)
+	}
+	if rec := call(http.MethodPost, `{"epoch":""}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"epoch": null`) {
+		t.Errorf("unpin: %d %s", rec.Code, rec.Body)
+	}
+	if _, ok := pinnedEpoch(); ok {
+		t.Error("epoch still pinned after clearing")
+	}
+	if rec := call(http.MethodPut, ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,49 @@
+// expect.go - latency-correct handling of "Expect: 100-continue".
+//
+// net/http answers 100-continue automatically the moment a handler first reads
+// the request body, so an uploading client would start transmitting with zero
+// simulated uplink delay. Endpoints that accept bodies call expectContinue
+// first: it rejects oversized uploads with a final status before a single body
+// byte is read, and otherwise withholds the interim 100 Continue until the
+// one-way light time has elapsed - the client hears "go ahead" only once its
+// request headers could have reached the body.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// expectContinue handles an "Expect: 100-continue" request before its body is
+// read. maxBody caps the declared Content-Length; latency is the one-way delay
+// applied before the interim response. It returns false if a final response
+// has already been written (or the client went away) and the caller must stop.
+// Requests without the expectation pass straight through. Other expectations
+// never get here: net/http answers them with 417 itself.
+func expectContinue(w http.ResponseWriter, r *http.Request, clock Clock, maxBody int64, latency time.Duration) bool {
+	if !strings.EqualFold(r.Header.Get("Expect"), "100-continue") {
+		return true
+	}
+
+	// Early rejection: the client is waiting on us, so it never sends the body.
+	if maxBody > 0 && r.ContentLength > maxBody {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{
+			"error": fmt.Sprintf("request body of %d bytes exceeds the %d byte limit", r.ContentLength, maxBody),
+			"code":  requestTooLargeCode,
+		})
+		return false
+	}
+
+	if err := sleepCtx(r.Context(), clock, latency); err != nil {
+		return false
+	}
+
+	// An explicit 1xx is written and flushed immediately, and disarms the
+	// automatic 100 net/http would otherwise send on the first body read. Do
+	// not Flush the writer here: that would commit an implicit 200 as the
+	// final status.
+	w.WriteHeader(http.StatusContinue)
+	return true
+}
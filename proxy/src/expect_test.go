@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// rawExpectRequest writes a POST /dtn/send with the given Expect header and
+// Content-Length to a raw connection, without a body, and returns the
+// connection plus a reader positioned at the first response status line.
+func rawExpectRequest(t *testing.T, addr, expect string, contentLength int) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	fmt.Fprintf(conn, "POST /dtn/send HTTP/1.1\r\nHost: mars.latency.space\r\nContent-Type: application/json\r\nContent-Length: %d\r\nExpect: %s\r\n\r\n", contentLength, expect)
+	return conn, bufio.NewReader(conn)
+}
+
+// readStatus reads one status line plus its headers and returns the code.
+func readStatus(t *testing.T, br *bufio.Reader) int {
+	t.Helper()
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read status line: %v", err)
+	}
+	var proto string
+	var code int
+	if _, err := fmt.Sscanf(line, "%s %d", &proto, &code); err != nil {
+		t.Fatalf("bad status line %q: %v", line, err)
+	}
+	for {
+		h, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read headers: %v", err)
+		}
+		if h == "\r\n" {
+			return code
+		}
+	}
+}
+
+func TestExpectContinueDelayedByLatency(t *testing.T) {
+	const latency = 80 * time.Millisecond
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer dest.Close()
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(latency)
+	srv := httptest.NewServer(http.HandlerFunc(s.handleHTTP))
+	defer srv.Close()
+
+	body := fmt.Sprintf(`{"url":%q}`, dest.URL)
+	start := time.Now()
+	conn, br := rawExpectRequest(t, srv.Listener.Addr().String(), "100-continue", len(body))
+	defer conn.Close()
+
+	// A well-behaved client sends nothing until it hears 100 Continue.
+	if code := readStatus(t, br); code != http.StatusContinue {
+		t.Fatalf("expected interim 100, got %d", code)
+	}
+	if waited := time.Since(start); waited < latency {
+		t.Errorf("100 Continue arrived after %v, expected at least the %v uplink latency", waited, latency)
+	}
+
+	if _, err := conn.Write([]byte(body)); err != nil {
+		t.Fatalf("send body: %v", err)
+	}
+	if code := readStatus(t, br); code != http.StatusAccepted {
+		t.Fatalf("expected final 202 after the body, got %d", code)
+	}
+}
+
+func TestExpectContinueEarlyRejection(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(time.Second)
+	srv := httptest.NewServer(http.HandlerFunc(s.handleHTTP))
+	defer srv.Close()
+
+	cases := []struct {
+		name   string
+		expect string
+		length int
+		code   int
+	}{
+		// Oversized upload: rejected immediately, not after the 1s latency.
+		{"too large", "100-continue", int(defaultSizeLimits.RequestBytes) + 1, http.StatusRequestEntityTooLarge},
+		// Unknown expectation: net/http's own 417.
+		{"unsupported expectation", "something-else", 10, http.StatusExpectationFailed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			conn, br := rawExpectRequest(t, srv.Listener.Addr().String(), tc.expect, tc.length)
+			defer conn.Close()
+			if code := readStatus(t, br); code != tc.code {
+				t.Fatalf("expected %d without sending the body, got %d", tc.code, code)
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("rejection took %v; it should not wait for the simulated latency", elapsed)
+			}
+		})
+	}
+}
+
+func TestExpectContinuePassThrough(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://mars.latency.space/dtn/send", strings.NewReader("{}"))
+	rec := httptest.NewRecorder()
+	if !expectContinue(rec, req, systemClock{}, 10, time.Hour) {
+		t.Fatal("requests without Expect must pass straight through")
+	}
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("nothing should be written without Expect, got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -114,13 +114,9 @@ func TestSocksTCPConnect(t *testing.T) {
 	cleanup, _ := setupExtendedTestEnv()
 	defer cleanup()
 
-	// Enable test mode for controllable latency
-	testModeCleanup := setupTestMode()
-	defer testModeCleanup()
-
 	// Setup security validator and metrics
-	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector()
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics()
 
 	// Allow localhost and common ports for testing
 	security.allowedHosts["127.0.0.1"] = true
@@ -198,7 +194,7 @@ func TestSocksTCPConnect(t *testing.T) {
 			return
 		}
 
-		handler := NewSOCKSHandler(conn, security, metrics, "")
+		handler := NewTestSOCKSHandler(conn, security, metrics)
 		handler.Handle()
 	}()
 
@@ -324,6 +320,51 @@ func TestSocksTCPConnect(t *testing.T) {
 	}
 
 	t.Logf("Client received echo response: %q", respMsg)
+
+	// Tear down the tunnel and wait for the handler so the session is observed.
+	clientConn.Close()
+	done := make(chan struct{})
+	go func() { serverWg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for SOCKS handler to finish")
+	}
+
+	if n := len(metrics.Observations(metricSOCKSHandshake, "success")); n != 1 {
+		t.Errorf("Expected 1 successful handshake observation, got %d", n)
+	}
+	if n := len(metrics.Observations(metricSOCKSDial)); n != 1 {
+		t.Errorf("Expected one dial duration observation, got %d", n)
+	}
+	if n := len(metrics.Observations(metricSOCKSSession)); n != 1 {
+		t.Errorf("Expected one session duration observation, got %d", n)
+	}
+	if n := metrics.Count(metricRequests); n != 1 {
+		t.Errorf("Expected the established tunnel in requests_total, got %v", n)
+	}
+	if n := metrics.Count(metricSOCKSFailures); n != 0 {
+		t.Errorf("Expected no failures for a successful CONNECT, got %v", n)
+	}
+	// The message went out and came back: payload bytes only, both ways.
+	if n := metrics.Count(metricBandwidth); n != float64(2*len(testMessage)) {
+		t.Errorf("Expected %d relayed bytes, got %v", 2*len(testMessage), n)
+	}
+	if n := metrics.Count(metricRelayEnds); n != 1 {
+		t.Errorf("Expected the relay's end to be counted once, got %v", n)
+	}
+}
+
+// assertSOCKSFailure checks that the failure counter for a reply code moved.
+func assertSOCKSFailure(t *testing.T, metrics *RecordingMetrics, rep byte) {
+	t.Helper()
+	label := socksFailureLabel(rep)
+	if v := metrics.Count(metricSOCKSFailures, label); v < 1 {
+		t.Errorf("Expected socks_failures_total{code=%q} to be incremented, got %v", label, v)
+	}
+	if n := len(metrics.Observations(metricSOCKSHandshake, "failure")); n < 1 {
+		t.Errorf("Expected a failed handshake observation, got %d", n)
+	}
 }
 
 // TestSocksTCPDomainName tests connecting using domain name resolution instead of IP
@@ -331,13 +372,9 @@ func TestSocksTCPDomainName(t *testing.T) {
 	cleanup, _ := setupExtendedTestEnv()
 	defer cleanup()
 
-	// Enable test mode for controllable latency
-	testModeCleanup := setupTestMode()
-	defer testModeCleanup()
-
 	// Setup security validator and metrics
-	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector()
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics()
 
 	// Allow test domains
 	security.allowedHosts["localhost"] = true
@@ -409,7 +446,7 @@ func TestSocksTCPDomainName(t *testing.T) {
 			return
 		}
 
-		handler := NewSOCKSHandler(conn, security, metrics, "")
+		handler := NewTestSOCKSHandler(conn, security, metrics)
 		handler.Handle()
 	}()
 
@@ -554,13 +591,9 @@ func TestSocksTCPErrorHandling(t *testing.T) {
 	cleanup, _ := setupExtendedTestEnv()
 	defer cleanup()
 
-	// Enable test mode for controllable latency
-	testModeCleanup := setupTestMode()
-	defer testModeCleanup()
-
 	// Setup security validator and metrics
-	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector()
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics()
 
 	// Allow localhost for testing
 	security.allowedHosts["127.0.0.1"] = true
@@ -594,7 +627,7 @@ func TestSocksTCPErrorHandling(t *testing.T) {
 						return
 					}
 
-					handler := NewSOCKSHandler(conn, security, metrics, "")
+					handler := NewTestSOCKSHandler(conn, security, metrics)
 					handler.Handle()
 				}()
 
@@ -659,6 +692,7 @@ func TestSocksTCPErrorHandling(t *testing.T) {
 					t.Fatalf("Expected CONN_NOT_ALLOWED (0x02), got: 0x%02x", resp[1])
 				}
 
+				assertSOCKSFailure(t, metrics, SOCKS5_REP_CONN_NOT_ALLOWED)
 				t.Logf("Correctly received CONN_NOT_ALLOWED for disallowed host")
 			},
 		},
@@ -684,7 +718,7 @@ func TestSocksTCPErrorHandling(t *testing.T) {
 						return
 					}
 
-					handler := NewSOCKSHandler(conn, security, metrics, "")
+					handler := NewTestSOCKSHandler(conn, security, metrics)
 					handler.Handle()
 				}()
 
@@ -752,6 +786,10 @@ func TestSocksTCPErrorHandling(t *testing.T) {
 					t.Fatalf("Expected connection error, got: 0x%02x", resp[1])
 				}
 
+				assertSOCKSFailure(t, metrics, resp[1])
+				if n := len(metrics.Observations(metricSOCKSDial)); n < 1 {
+					t.Errorf("Expected the failed dial to be timed, got %d observations", n)
+				}
 				t.Logf("Correctly received error (0x%02x) for non-existent port", resp[1])
 			},
 		},
@@ -777,7 +815,7 @@ func TestSocksTCPErrorHandling(t *testing.T) {
 						return
 					}
 
-					handler := NewSOCKSHandler(conn, security, metrics, "")
+					handler := NewTestSOCKSHandler(conn, security, metrics)
 					handler.Handle()
 				}()
 
@@ -841,6 +879,7 @@ func TestSocksTCPErrorHandling(t *testing.T) {
 					t.Fatalf("Expected CMD_NOT_SUPPORTED (0x07), got: 0x%02x", resp[1])
 				}
 
+				assertSOCKSFailure(t, metrics, SOCKS5_REP_CMD_NOT_SUPPORTED)
 				t.Logf("Correctly received CMD_NOT_SUPPORTED for invalid command")
 			},
 		},
@@ -854,18 +893,69 @@ func TestSocksTCPErrorHandling(t *testing.T) {
 	}
 }
 
+// TestSocksFailureMetricsBeforeRequest covers failures that happen before a
+// destination is known: the greeting's auth rejection and a bad address type.
+func TestSocksFailureMetricsBeforeRequest(t *testing.T) {
+	testCases := []struct {
+		name     string
+		messages [][]byte
+		code     byte
+	}{
+		{
+			name:     "No acceptable auth method",
+			messages: [][]byte{{SOCKS5_VERSION, 1, SOCKS5_AUTH_USERNAME_PASSWORD}},
+			code:     SOCKS5_AUTH_NO_ACCEPTABLE,
+		},
+		{
+			name: "Unsupported address type",
+			messages: [][]byte{
+				{SOCKS5_VERSION, 1, SOCKS5_NO_AUTH},
+				{SOCKS5_VERSION, SOCKS5_CMD_CONNECT, 0x00, 0x09},
+			},
+			code: SOCKS5_REP_ADDR_NOT_SUPPORTED,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics := NewRecordingMetrics()
+			client, server := net.Pipe()
+			defer client.Close()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				NewSOCKSHandler(server, NewSecurityValidator(), metrics, "Mars", nil).Handle()
+			}()
+
+			for _, msg := range tc.messages {
+				if _, err := client.Write(msg); err != nil {
+					t.Fatalf("Failed to write: %v", err)
+				}
+				reply := make([]byte, 2)
+				if _, err := io.ReadFull(client, reply); err != nil {
+					t.Fatalf("Failed to read reply: %v", err)
+				}
+			}
+			client.Close()
+			<-done
+
+			assertSOCKSFailure(t, metrics, tc.code)
+			if n := len(metrics.Observations(metricSOCKSHandshake, "success")); n != 0 {
+				t.Errorf("Expected no successful handshakes, got %d", n)
+			}
+		})
+	}
+}
+
 // TestSocksUDPReliability tests UDP reliability with packet loss and reordering
 func TestSocksUDPReliability(t *testing.T) {
 	cleanup, _ := setupExtendedTestEnv()
 	defer cleanup()
 
-	// Enable test mode for low latency
-	testModeCleanup := setupTestMode()
-	defer testModeCleanup()
-
 	// Setup test-specific validator and metrics
-	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector()
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics()
 
 	// Allow loopback host for testing
 	security.allowedHosts["127.0.0.1"] = true
@@ -902,7 +992,7 @@ func TestSocksUDPReliability(t *testing.T) {
 		}
 		defer conn.Close()
 
-		handler := NewSOCKSHandler(conn, security, metrics, "")
+		handler := NewTestSOCKSHandler(conn, security, metrics)
 		handler.Handle()
 	}()
 
@@ -0,0 +1,132 @@
+// finger.go - a finger (RFC 1288) listener for poking the proxy with netcat.
+//
+// -finger :79 enables it. `finger mars@latency.space` (or `echo mars | nc
+// latency.space 79`) returns the body's info page as plain text: distance,
+// one-way latency, round trip, occlusion and, if occluded, when it is next
+// visible. An empty query lists every body sorted by current latency. For
+// flavour the reply to a body query is held back by that body's one-way
+// latency; Earth and the listing are answered immediately.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	fingerBanner      = "latency.space finger service - send a body name, or an empty line for all bodies"
+	fingerReadTimeout = 30 * time.Second
+	fingerMaxQueryLen = 512
+)
+
+// FingerServer serves the finger listener.
+type FingerServer struct {
+	addr    string
+	limiter *RateLimiter // nil = no per-IP limits
+	metrics Metrics
+	timing  // clock and latency source (clock.go)
+
+	*trackedListener // listener, live connections and Stop
+}
+
+// NewFingerServer creates a finger server for addr. Call Listen, then Serve.
+func NewFingerServer(addr string, metrics Metrics) *FingerServer {
+	return &FingerServer{
+		addr:            addr,
+		metrics:         orNop(metrics),
+		trackedListener: newTrackedListener(),
+	}
+}
+
+// Listen binds the listener.
+func (f *FingerServer) Listen() error {
+	l, err := net.Listen("tcp", f.addr)
+	if err != nil {
+		return fmt.Errorf("finger %s: %v", f.addr, err)
+	}
+	f.listener = l
+	return nil
+}
+
+// Serve accepts connections until Stop is called.
+func (f *FingerServer) Serve() error {
+	log.Printf("Finger server listening on %s", f.listener.Addr())
+	return f.serve("Finger", f.limiter, f.handle)
+}
+
+// handle answers one finger query.
+func (f *FingerServer) handle(conn net.Conn) {
+	start := f.now()
+	w := crlfWriter{conn}
+	fmt.Fprintln(w, fingerBanner)
+
+	conn.SetReadDeadline(time.Now().Add(fingerReadTimeout))
+	line, err := bufio.NewReader(io.LimitReader(conn, fingerMaxQueryLen)).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	query, ok := parseFingerQuery(line)
+	if !ok {
+		fmt.Fprintln(w, "Finger forwarding is not supported.")
+		return
+	}
+	if query == "" {
+		printDistanceTable(w, "All bodies by current latency", bodiesByLatency())
+		return
+	}
+
+	objects := getCelestialObjects()
+	obj, found := findObjectByName(objects, query)
+	if !found {
+		fmt.Fprintf(w, "No such body: %s\n", query)
+		return
+	}
+
+	// Earth answers at once; everything else is held back by its light time.
+	if !strings.EqualFold(obj.Name, "Earth") {
+		distance, err := getCurrentDistance(obj.Name)
+		if err != nil {
+			fmt.Fprintf(w, "%s\n", err)
+			return
+		}
+		if err := sleepCtx(f.ctx, f.clk(), f.oneWay(obj.Name, distance)); err != nil {
+			return
+		}
+	}
+	writeBodyText(w, obj, objects, simTime(f.now()))
+	f.metrics.RecordRequest(obj.Name, "finger", f.since(start))
+}
+
+// parseFingerQuery extracts the body name from a finger query line. The
+// verbose "/W" switch is accepted and ignored; user@host forwarding is
+// refused unless the host is latency.space itself.
+func parseFingerQuery(line string) (string, bool) {
+	q := strings.TrimSpace(line)
+	if strings.HasPrefix(q, "/W") || strings.HasPrefix(q, "/w") {
+		q = strings.TrimSpace(q[2:])
+	}
+	if user, host, ok := strings.Cut(q, "@"); ok {
+		if !strings.EqualFold(host, "latency.space") {
+			return "", false
+		}
+		q = user
+	}
+	return q, true
+}
+
+// bodiesByLatency returns every body's current distance entry, nearest first.
+func bodiesByLatency() []DistanceEntry {
+	entries := currentDistanceEntries()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Distance < entries[j].Distance
+	})
+	return entries
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// startFingerServer runs a finger server on a loopback port where every body
+// is latency away.
+func startFingerServer(t *testing.T, latency time.Duration) string {
+	t.Helper()
+	f := NewFingerServer("127.0.0.1:0", NewRecordingMetrics())
+	f.timing = fixedLatency(latency)
+	if err := f.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go f.Serve()
+	t.Cleanup(f.Stop)
+	return f.Addr().String()
+}
+
+// fingerQuery sends one finger query and returns the response lines (banner
+// included) and how long the full response took.
+func fingerQuery(t *testing.T, addr, query string) ([]string, time.Duration) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	if _, err := conn.Write([]byte(query + "\r\n")); err != nil {
+		t.Fatalf("write query: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	text := string(raw)
+	if strings.Contains(strings.ReplaceAll(text, "\r\n", ""), "\n") {
+		t.Errorf("response has bare LF line endings: %q", text)
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\r\n"), "\r\n")
+	if lines[0] != fingerBanner {
+		t.Errorf("expected banner first, got %q", lines[0])
+	}
+	return lines[1:], elapsed
+}
+
+// fingerFields parses "Key: value" lines.
+func fingerFields(lines []string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range lines {
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = strings.TrimSpace(v)
+		}
+	}
+	return fields
+}
+
+func TestFingerBodyQuery(t *testing.T) {
+	const latency = 100 * time.Millisecond
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	addr := startFingerServer(t, latency)
+
+	lines, elapsed := fingerQuery(t, addr, "mars")
+	fields := fingerFields(lines)
+	if fields["Body"] != "Mars (planet)" {
+		t.Errorf("Body: got %q", fields["Body"])
+	}
+	if fields["Domain"] != "mars.latency.space" {
+		t.Errorf("Domain: got %q", fields["Domain"])
+	}
+	if !strings.HasSuffix(fields["Distance"], " km)") || !strings.Contains(fields["Distance"], "million km") {
+		t.Errorf("Distance: got %q", fields["Distance"])
+	}
+	for _, key := range []string{"One-way", "Round trip", "Visibility", "Moons"} {
+		if fields[key] == "" {
+			t.Errorf("missing %s in response %q", key, lines)
+		}
+	}
+	if fields["Moons"] != "Phobos, Deimos" {
+		t.Errorf("Moons: got %q", fields["Moons"])
+	}
+	if elapsed < latency {
+		t.Errorf("reply took %v, expected it to be delayed by the %v one-way latency", elapsed, latency)
+	}
+
+	// finger user@host form and moon domains.
+	lines, _ = fingerQuery(t, addr, "/W phobos@latency.space")
+	if got := fingerFields(lines)["Domain"]; got != "phobos.mars.latency.space" {
+		t.Errorf("moon Domain: got %q", got)
+	}
+}
+
+func TestFingerEarthAnsweredImmediately(t *testing.T) {
+	const latency = 500 * time.Millisecond
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	addr := startFingerServer(t, latency)
+
+	lines, elapsed := fingerQuery(t, addr, "earth")
+	if elapsed >= latency {
+		t.Errorf("Earth took %v; it should not be delayed", elapsed)
+	}
+	fields := fingerFields(lines)
+	if fields["Body"] != "Earth (planet)" || fields["One-way"] != "0s" {
+		t.Errorf("unexpected Earth response %q", lines)
+	}
+
+	if _, elapsed := fingerQuery(t, addr, ""); elapsed >= latency {
+		t.Errorf("listing took %v; it should not be delayed", elapsed)
+	}
+}
+
+func TestFingerListingSortedByLatency(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	objects := celestial.InitSolarSystemObjects()
+	setCelestialObjects(objects)
+	invalidateDistanceCache()
+	addr := startFingerServer(t, time.Millisecond)
+
+	lines, _ := fingerQuery(t, addr, "")
+	var names []string
+	last := -1.0
+	for _, line := range lines {
+		cols := strings.Split(line, "|")
+		if len(cols) != 6 || strings.TrimSpace(cols[0]) == "Name" {
+			continue
+		}
+		km, err := strconv.ParseFloat(strings.TrimSpace(cols[2]), 64)
+		if err != nil {
+			t.Fatalf("bad distance column in %q: %v", line, err)
+		}
+		if km < last {
+			t.Errorf("listing not sorted by latency: %q after %.0f km", line, last)
+		}
+		last = km
+		names = append(names, strings.TrimSpace(cols[0]))
+	}
+	if len(names) != len(objects)-1 { // every body except Earth
+		t.Errorf("expected %d bodies, got %d: %v", len(objects)-1, len(names), names)
+	}
+	if len(names) > 0 && names[0] != "Moon" {
+		t.Errorf("expected the Moon first, got %s", names[0])
+	}
+}
+
+func TestFingerErrors(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	addr := startFingerServer(t, time.Millisecond)
+
+	if lines, _ := fingerQuery(t, addr, "vulcan"); len(lines) != 1 || lines[0] != "No such body: vulcan" {
+		t.Errorf("unknown body: got %q", lines)
+	}
+	if lines, _ := fingerQuery(t, addr, "mars@example.com"); len(lines) != 1 || !strings.Contains(lines[0], "forwarding") {
+		t.Errorf("forwarding: got %q", lines)
+	}
+}
+
+func TestParseFingerQuery(t *testing.T) {
+	cases := []struct {
+		line, want string
+		ok         bool
+	}{
+		{"\r\n", "", true},
+		{"mars\r\n", "mars", true},
+		{"/W mars\r\n", "mars", true},
+		{"/W\r\n", "", true},
+		{"mars@latency.space\r\n", "mars", true},
+		{"mars@LATENCY.SPACE", "mars", true},
+		{"mars@example.com\r\n", "", false},
+		{"mars@latency.space@example.com\r\n", "", false},
+	}
+	for _, tc := range cases {
+		got, ok := parseFingerQuery(tc.line)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseFingerQuery(%q) = %q, %v; want %q, %v", tc.line, got, ok, tc.want, tc.ok)
+		}
+	}
+}
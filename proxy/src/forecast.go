@@ -0,0 +1,425 @@
+// forecast.go - the days ahead on a body's link, for planning around them.
+//
+//	GET /api/forecast?body=mars[&days=30][&format=json|ical|csv]
+//
+// Groups running a "Mars colony" event want to schedule around the real
+// communication windows. The forecast covers days whole UTC days from
+// today (or the pinned epoch): each day's least and greatest one-way light
+// time, the windows when the line of sight is blocked or degraded (a solar
+// conjunction, a moon behind its planet), and the body's DSN passes when
+// -contact-schedule gives it some.
+//
+// The link is sampled every forecastStep and the edges of each window are
+// found to the minute between samples, so a window shorter than the step
+// can be missed. A window already open on the first day, or still open on
+// the last, is followed out of the range to its real edges. Samples fall
+// on whole UTC hours whatever the range, so the same window gets the same
+// edges, and the same UID, in every forecast that contains it, and a
+// calendar client that re-subscribes updates its events rather than adding
+// copies.
+//
+// ical is an RFC 5545 calendar with the days as all-day events; csv has one
+// row per day and per window. Forecasts are cached per body and range until
+// the distance cache is next refreshed. The endpoint shares the
+// /api/distance rate limit.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+const (
+	forecastDefaultDays = 30
+	forecastMaxDays     = 365
+	forecastStep        = time.Hour           // link sampling interval
+	forecastResolution  = time.Minute         // window edges are found to this
+	forecastEdgeLimit   = 60 * 24 * time.Hour // how far out of the range a window's edges are followed
+	forecastCacheSize   = 64                  // forecasts kept
+	forecastUIDDomain   = "forecast.latency.space"
+)
+
+// ForecastDay is one UTC day's range of one-way light time.
+type ForecastDay struct {
+	Date      string  `json:"date"` // YYYY-MM-DD
+	MinOneWay float64 `json:"min_one_way_seconds"`
+	MaxOneWay float64 `json:"max_one_way_seconds"`
+}
+
+// ForecastEvent is a window on the link.
+type ForecastEvent struct {
+	UID        string    `json:"uid"`  // stable across forecasts
+	Kind       string    `json:"kind"` // "blocked", "degraded" or "contact"
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Summary    string    `json:"summary"`
+	OccludedBy string    `json:"occluded_by,omitempty"`
+}
+
+// ForecastResponse is the JSON returned by /api/forecast.
+type ForecastResponse struct {
+	Body        string          `json:"body"`
+	Start       time.Time       `json:"start"` // midnight UTC of the first day
+	End         time.Time       `json:"end"`   // midnight UTC after the last
+	GeneratedAt time.Time       `json:"generated_at"`
+	Days        []ForecastDay   `json:"days"`
+	Events      []ForecastEvent `json:"events"` // by start
+}
+
+type forecastKey struct {
+	body  string
+	start int64 // Unix seconds of the first midnight
+	days  int
+}
+
+type forecastEntry struct {
+	objects    *[]celestial.CelestialObject // the object list it was solved from
+	generation uint64                       // distanceGeneration it was solved in
+	resp       *ForecastResponse
+}
+
+var (
+	forecastCacheMu sync.Mutex
+	forecastCache   = make(map[forecastKey]forecastEntry)
+)
+
+// handleForecast serves /api/forecast.
+func (s *Server) handleForecast(w http.ResponseWriter, r *http.Request) {
+	release, err := s.distanceLimiter.Acquire(s.requestClientIP(r))
+	if err != nil {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	q := r.URL.Query()
+	format := strings.ToLower(q.Get("format"))
+	switch format {
+	case "":
+		format = "json"
+	case "json", "ical", "csv":
+	default:
+		writeJSONError(w, r, http.StatusBadRequest, "error.forecast_format")
+		return
+	}
+	days := forecastDefaultDays
+	if v := q.Get("days"); v != "" {
+		if days, err = strconv.Atoi(v); err != nil || days < 1 || days > forecastMaxDays {
+			writeJSONError(w, r, http.StatusBadRequest, "error.forecast_days", forecastMaxDays)
+			return
+		}
+	}
+	snap, ok := s.requestSnapshot(w, r)
+	if !ok {
+		return
+	}
+	name := strings.TrimSpace(q.Get("body"))
+	if name == "" {
+		name = s.resolveCelestialHost(snap.Objects, r.Host)
+	}
+	if name == "" || strings.EqualFold(name, "Earth") {
+		writeJSONError(w, r, http.StatusBadRequest, "error.forecast_body")
+		return
+	}
+	obj, ok := snap.Find(name)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotFound, "error.unknown_body_named", name)
+		return
+	}
+
+	resp := cachedForecast(obj, simTime(distanceClock()), days)
+	filename := FormatDomainName(obj.Name) + "-forecast"
+	switch format {
+	case "ical":
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.ics"`)
+		w.Write(forecastICal(resp))
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		w.Write(forecastCSV(resp))
+	default:
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// cachedForecast returns obj's forecast for days days from the UTC day
+// containing now, computing it if it isn't cached for the current objects
+// and distance cache generation.
+func cachedForecast(obj celestial.CelestialObject, now time.Time, days int) *ForecastResponse {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	objects := celestialObjectsPtr.Load()
+	DistanceCacheMutex.RLock()
+	generation := distanceGeneration
+	DistanceCacheMutex.RUnlock()
+	key := forecastKey{obj.Name, start.Unix(), days}
+
+	forecastCacheMu.Lock()
+	defer forecastCacheMu.Unlock()
+	if e, ok := forecastCache[key]; ok && e.objects == objects && e.generation == generation {
+		return e.resp
+	}
+	resp := computeForecast(*objects, obj, start, days, now)
+	if len(forecastCache) >= forecastCacheSize {
+		for k := range forecastCache { // evict an arbitrary entry
+			delete(forecastCache, k)
+			break
+		}
+	}
+	forecastCache[key] = forecastEntry{objects, generation, resp}
+	return resp
+}
+
+// linkState is the line of sight from Earth at one instant.
+type linkState struct {
+	visibility Visibility
+	by         string // the occluder, unless clear
+}
+
+// computeForecast solves obj's forecast for days days from midnight start,
+// stamped as generated at now.
+func computeForecast(objects []celestial.CelestialObject, obj celestial.CelestialObject, start time.Time, days int, now time.Time) *ForecastResponse {
+	end := start.AddDate(0, 0, days)
+	resp := &ForecastResponse{
+		Body:        obj.Name,
+		Start:       start,
+		End:         end,
+		GeneratedAt: now.Truncate(time.Second),
+		Days:        make([]ForecastDay, 0, days),
+		Events:      []ForecastEvent{},
+	}
+	earth, ok := findObjectByName(objects, "Earth")
+	if !ok {
+		return resp
+	}
+	state := func(t time.Time) linkState {
+		v, by := IsOccluded(earth, obj, objects, t)
+		if v == VisibilityClear {
+			return linkState{}
+		}
+		return linkState{v, by.Name}
+	}
+
+	// Sample on the hour, keeping each day's light-time range and the
+	// windows where the state isn't clear.
+	var open *ForecastEvent
+	prev := state(start)
+	if prev != (linkState{}) {
+		open = forecastWindow(obj.Name, prev, forecastEdgeBack(start, prev, state))
+	}
+	perDay := int(24 * time.Hour / forecastStep)
+	for d := 0; d < days; d++ {
+		day := start.AddDate(0, 0, d)
+		var lo, hi time.Duration
+		for i := 0; i <= perDay; i++ {
+			t := day.Add(time.Duration(i) * forecastStep)
+			oneWay := CalculateLatency(ApparentDistance(earth, obj, objects, t))
+			if i == 0 || oneWay < lo {
+				lo = oneWay
+			}
+			if i == 0 || oneWay > hi {
+				hi = oneWay
+			}
+			if i == 0 {
+				continue // the previous day's last sample
+			}
+			cur := state(t)
+			if cur == prev {
+				continue
+			}
+			from := prev
+			edge := firstChange(t.Add(-forecastStep), t, func(t time.Time) bool { return state(t) != from })
+			if open != nil {
+				open.End = edge
+				resp.Events = append(resp.Events, *open)
+				open = nil
+			}
+			if cur != (linkState{}) {
+				open = forecastWindow(obj.Name, cur, edge)
+			}
+			prev = cur
+		}
+		resp.Days = append(resp.Days, ForecastDay{
+			Date:      day.Format("2006-01-02"),
+			MinOneWay: roundedSeconds(lo),
+			MaxOneWay: roundedSeconds(hi),
+		})
+	}
+	if open != nil {
+		open.End = forecastEdgeAhead(end, prev, state)
+		resp.Events = append(resp.Events, *open)
+	}
+
+	if sched := contactSchedule(obj.Name); sched != nil {
+		for t := start; ; {
+			ps, pe, ok := sched.Pass(t)
+			if !ok || !ps.Before(end) {
+				break
+			}
+			resp.Events = append(resp.Events, ForecastEvent{
+				UID:     forecastUID("contact", obj.Name, ps),
+				Kind:    "contact",
+				Start:   ps,
+				End:     pe,
+				Summary: obj.Name + " DSN pass",
+			})
+			t = pe
+		}
+	}
+	sort.SliceStable(resp.Events, func(i, j int) bool { return resp.Events[i].Start.Before(resp.Events[j].Start) })
+	return resp
+}
+
+// forecastWindow opens a window of state st on body's link at start.
+func forecastWindow(body string, st linkState, start time.Time) *ForecastEvent {
+	kind, summary := "blocked", fmt.Sprintf("%s behind %s — no comms", body, st.by)
+	switch {
+	case st.visibility == VisibilityDegraded && st.by == "Sun":
+		kind, summary = "degraded", body+" near the Sun — degraded comms"
+	case st.visibility == VisibilityDegraded:
+		kind, summary = "degraded", fmt.Sprintf("%s at the limb of %s — degraded comms", body, st.by)
+	case st.by == "Sun":
+		summary = body + " solar conjunction — no comms"
+	}
+	return &ForecastEvent{UID: forecastUID(kind, body, start), Kind: kind, Start: start, Summary: summary, OccludedBy: st.by}
+}
+
+// forecastUID names an event by what it is and when it starts, so every
+// forecast containing it gives it the same UID.
+func forecastUID(kind, body string, start time.Time) string {
+	return kind + "-" + FormatDomainName(body) + "-" + start.UTC().Format("20060102T1504Z") + "@" + forecastUIDDomain
+}
+
+// firstChange returns the first instant after lo, to forecastResolution,
+// at which changed holds, given it doesn't at lo and does at hi.
+func firstChange(lo, hi time.Time, changed func(time.Time) bool) time.Time {
+	for hi.Sub(lo) > forecastResolution {
+		mid := lo.Add((hi.Sub(lo) / 2).Truncate(forecastResolution))
+		if changed(mid) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi
+}
+
+// forecastEdgeBack returns when the window of state st open at start
+// began, looking back at most forecastEdgeLimit.
+func forecastEdgeBack(start time.Time, st linkState, state func(time.Time) linkState) time.Time {
+	for t := start.Add(-forecastStep); start.Sub(t) <= forecastEdgeLimit; t = t.Add(-forecastStep) {
+		if state(t) != st {
+			return firstChange(t, t.Add(forecastStep), func(t time.Time) bool { return state(t) == st })
+		}
+	}
+	return start.Add(-forecastEdgeLimit)
+}
+
+// forecastEdgeAhead returns when the window of state st open at end
+// closes, looking ahead at most forecastEdgeLimit.
+func forecastEdgeAhead(end time.Time, st linkState, state func(time.Time) linkState) time.Time {
+	for t := end.Add(forecastStep); t.Sub(end) <= forecastEdgeLimit; t = t.Add(forecastStep) {
+		if state(t) != st {
+			return firstChange(t.Add(-forecastStep), t, func(t time.Time) bool { return state(t) != st })
+		}
+	}
+	return end.Add(forecastEdgeLimit)
+}
+
+// forecastICal renders resp as an RFC 5545 calendar.
+func forecastICal(resp *ForecastResponse) []byte {
+	var b bytes.Buffer
+	stamp := resp.GeneratedAt.UTC().Format("20060102T150405Z")
+	line := func(name, value string) { b.WriteString(icalFold(name + ":" + value)) }
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//latency.space//Forecast//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	line("X-WR-CALNAME", icalText(resp.Body+" communications forecast"))
+	for _, d := range resp.Days {
+		day, _ := time.Parse("2006-01-02", d.Date)
+		line("BEGIN", "VEVENT")
+		line("UID", forecastUID("latency", resp.Body, day))
+		line("DTSTAMP", stamp)
+		line("DTSTART;VALUE=DATE", day.Format("20060102"))
+		line("DTEND;VALUE=DATE", day.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY", icalText(fmt.Sprintf("%s one-way light time %s–%s", resp.Body,
+			displayDuration(time.Duration(d.MinOneWay*float64(time.Second))),
+			displayDuration(time.Duration(d.MaxOneWay*float64(time.Second))))))
+		line("TRANSP", "TRANSPARENT")
+		line("END", "VEVENT")
+	}
+	for _, e := range resp.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.UID)
+		line("DTSTAMP", stamp)
+		line("DTSTART", e.Start.UTC().Format("20060102T150405Z"))
+		line("DTEND", e.End.UTC().Format("20060102T150405Z"))
+		line("SUMMARY", icalText(e.Summary))
+		line("CATEGORIES", strings.ToUpper(e.Kind))
+		if e.Kind == "contact" {
+			line("TRANSP", "TRANSPARENT")
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return b.Bytes()
+}
+
+// icalText escapes s as an RFC 5545 TEXT value.
+func icalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icalFold folds a content line at 75 octets, without splitting a UTF-8
+// sequence, and ends it with CRLF.
+func icalFold(s string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		size := utf8.RuneLen(r)
+		if n+size > 75 {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// forecastCSVHeader is the csv format's header row.
+var forecastCSVHeader = []string{"kind", "start", "end", "body", "summary", "min_one_way_seconds", "max_one_way_seconds", "occluded_by", "uid"}
+
+// forecastCSV renders resp as CSV: a "latency" row per day, then a row per
+// window.
+func forecastCSV(resp *ForecastResponse) []byte {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(forecastCSVHeader)
+	seconds := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	for _, d := range resp.Days {
+		day, _ := time.Parse("2006-01-02", d.Date)
+		w.Write([]string{"latency", day.Format(time.RFC3339), day.AddDate(0, 0, 1).Format(time.RFC3339), resp.Body,
+			resp.Body + " one-way light time", seconds(d.MinOneWay), seconds(d.MaxOneWay), "", forecastUID("latency", resp.Body, day)})
+	}
+	for _, e := range resp.Events {
+		w.Write([]string{e.Kind, e.Start.UTC().Format(time.RFC3339), e.End.UTC().Format(time.RFC3339), resp.Body,
+			e.Summary, "", "", e.OccludedBy, e.UID})
+	}
+	w.Flush()
+	return b.Bytes()
+}
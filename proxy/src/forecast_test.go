@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// icalComponent is a parsed iCalendar component: its properties by name,
+// with parameters dropped and TEXT values unescaped, and its children.
+type icalComponent struct {
+	Name     string
+	Props    map[string][]string
+	Children []*icalComponent
+}
+
+// parseICal parses an RFC 5545 stream strictly enough to catch what a
+// calendar client would reject: lines not ending in CRLF, lines over 75
+// octets, UTF-8 split by folding, unbalanced BEGIN/END, properties without
+// a value, and bad escapes.
+func parseICal(t *testing.T, data []byte) *icalComponent {
+	t.Helper()
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		t.Fatal("the calendar doesn't end with CRLF")
+	}
+	var lines []string
+	for i, raw := range strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n") {
+		if strings.ContainsAny(raw, "\r\n") {
+			t.Fatalf("line %d: a bare CR or LF", i+1)
+		}
+		if len(raw) > 75 {
+			t.Fatalf("line %d is %d octets: %q", i+1, len(raw), raw)
+		}
+		if !utf8.ValidString(raw) {
+			t.Fatalf("line %d splits a UTF-8 sequence: %q", i+1, raw)
+		}
+		if strings.HasPrefix(raw, " ") || strings.HasPrefix(raw, "\t") {
+			if len(lines) == 0 {
+				t.Fatal("the calendar starts with a continuation line")
+			}
+			lines[len(lines)-1] += raw[1:]
+			continue
+		}
+		lines = append(lines, raw)
+	}
+
+	var stack []*icalComponent
+	var root *icalComponent
+	for _, l := range lines {
+		name, value, ok := strings.Cut(l, ":")
+		if !ok || name == "" {
+			t.Fatalf("no name and value in %q", l)
+		}
+		name, _, _ = strings.Cut(name, ";")
+		switch name {
+		case "BEGIN":
+			c := &icalComponent{Name: value, Props: make(map[string][]string)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, c)
+			} else if root != nil {
+				t.Fatal("a second top-level component")
+			} else {
+				root = c
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != value {
+				t.Fatalf("END:%s doesn't close the open component", value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				t.Fatalf("%s outside any component", name)
+			}
+			c := stack[len(stack)-1]
+			c.Props[name] = append(c.Props[name], unescapeICal(t, value))
+		}
+	}
+	if len(stack) != 0 || root == nil || root.Name != "VCALENDAR" {
+		t.Fatalf("unbalanced calendar (open: %d)", len(stack))
+	}
+	return root
+}
+
+func unescapeICal(t *testing.T, v string) string {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b.WriteByte(v[i])
+			continue
+		}
+		if i++; i == len(v) {
+			t.Fatalf("trailing backslash in %q", v)
+		}
+		switch v[i] {
+		case '\\', ';', ',':
+			b.WriteByte(v[i])
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			t.Fatalf("bad escape \\%c in %q", v[i], v)
+		}
+	}
+	return b.String()
+}
+
+func TestICalFoldAndEscape(t *testing.T) {
+	long := strings.Repeat("Mars solar conjunction — no comms; relay, wait\n", 4)
+	cal := parseICal(t, []byte("BEGIN:VCALENDAR\r\n"+icalFold("SUMMARY:"+icalText(long))+"END:VCALENDAR\r\n"))
+	if got := cal.Props["SUMMARY"]; len(got) != 1 || got[0] != long {
+		t.Errorf("round trip: %q, want %q", got, long)
+	}
+}
+
+// forecastFixture fixes the clock at start with the solar system loaded
+// and Voyager 1 given weekday DSN passes.
+func forecastFixture(t *testing.T, start time.Time) *Server {
+	t.Helper()
+	objects := celestial.InitSolarSystemObjects()
+	withObjects(t, objects)
+	fakeDistanceClock(t, start)
+	schedules, err := parseContactSchedules("voyager-1=Mon-Fri 09:00-17:00UTC", objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setContactSchedules(schedules)
+	t.Cleanup(func() { setContactSchedules(nil) })
+	return &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+}
+
+func getForecast(t *testing.T, s *Server, url string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: %d %s", url, rec.Code, rec.Body.String())
+	}
+	return rec
+}
+
+// TestForecastICal checks the calendar around Voyager 1's March 2026 solar
+// conjunction parses, and has a day event per day, the conjunction and the
+// DSN passes.
+func TestForecastICal(t *testing.T) {
+	s := forecastFixture(t, time.Date(2026, 3, 16, 15, 0, 0, 0, time.UTC))
+	rec := getForecast(t, s, "http://voyager-1.latency.space/api/forecast?days=10&format=ical")
+	if ct := rec.Header().Get("Content-Type"); ct != "text/calendar; charset=utf-8" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	cal := parseICal(t, rec.Body.Bytes())
+	if cal.Props["VERSION"][0] != "2.0" || len(cal.Props["PRODID"]) != 1 {
+		t.Errorf("calendar properties %v", cal.Props)
+	}
+
+	uids := make(map[string]bool)
+	var days, passes int
+	var conjunction bool
+	for _, ev := range cal.Children {
+		if ev.Name != "VEVENT" {
+			t.Fatalf("unexpected %s", ev.Name)
+		}
+		for _, p := range []string{"UID", "DTSTAMP", "DTSTART", "DTEND", "SUMMARY"} {
+			if len(ev.Props[p]) != 1 {
+				t.Fatalf("event %v has %d %s", ev.Props, len(ev.Props[p]), p)
+			}
+		}
+		uid := ev.Props["UID"][0]
+		if uids[uid] {
+			t.Errorf("UID %s repeated", uid)
+		}
+		uids[uid] = true
+		summary := ev.Props["SUMMARY"][0]
+		switch {
+		case strings.HasPrefix(uid, "latency-"):
+			days++
+			if !strings.HasPrefix(summary, "Voyager 1 one-way light time ") {
+				t.Errorf("day summary %q", summary)
+			}
+		case strings.HasPrefix(uid, "contact-"):
+			passes++
+		case summary == "Voyager 1 solar conjunction — no comms":
+			conjunction = true
+			start, err := time.Parse("20060102T150405Z", ev.Props["DTSTART"][0])
+			if err != nil || start.Before(time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)) || start.After(time.Date(2026, 3, 21, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("conjunction starts %q (%v)", ev.Props["DTSTART"][0], err)
+			}
+		}
+	}
+	// Mon 16 to Wed 25 March: eight weekdays.
+	if days != 10 || passes != 8 || !conjunction {
+		t.Errorf("%d days, %d passes, conjunction %v; want 10, 8, true", days, passes, conjunction)
+	}
+}
+
+// TestForecastStableUIDs checks a forecast solved again after the distance
+// cache moves on, and one starting a day later, give the events they share
+// the same UIDs and times.
+func TestForecastStableUIDs(t *testing.T) {
+	start := time.Date(2026, 1, 1, 6, 0, 0, 0, time.UTC)
+	forecastFixture(t, start)
+	europa, _ := findObjectByName(getCelestialObjects(), "Europa")
+
+	first := cachedForecast(europa, start, 5)
+	if len(first.Events) == 0 {
+		t.Fatal("no Europa occultations in five days")
+	}
+	if again := cachedForecast(europa, start, 5); again != first {
+		t.Error("the forecast wasn't cached within a generation")
+	}
+	DistanceCacheMutex.Lock()
+	distanceGeneration++
+	DistanceCacheMutex.Unlock()
+	second := cachedForecast(europa, start.Add(time.Hour), 5)
+	if second == first {
+		t.Fatal("the forecast outlived the distance cache generation")
+	}
+	later := cachedForecast(europa, start.AddDate(0, 0, 1), 5)
+
+	events := func(r *ForecastResponse) map[string]ForecastEvent {
+		m := make(map[string]ForecastEvent)
+		for _, e := range r.Events {
+			m[e.UID] = e
+		}
+		return m
+	}
+	a, b, c := events(first), events(second), events(later)
+	if len(a) != len(b) {
+		t.Errorf("%d events, then %d", len(a), len(b))
+	}
+	shared := 0
+	for uid, e := range a {
+		if other, ok := b[uid]; !ok || other != e {
+			t.Errorf("%s: %+v, then %+v", uid, e, other)
+		}
+		if other, ok := c[uid]; ok {
+			shared++
+			if other != e {
+				t.Errorf("%s: %+v, a day later %+v", uid, e, other)
+			}
+		} else if !e.End.Before(later.Start) {
+			t.Errorf("%s ends %v, after the later forecast starts, but isn't in it", uid, e.End)
+		}
+	}
+	if shared == 0 {
+		t.Error("the forecasts a day apart share no events")
+	}
+}
+
+func TestForecastCSV(t *testing.T) {
+	s := forecastFixture(t, time.Date(2026, 1, 1, 6, 0, 0, 0, time.UTC))
+	rec := getForecast(t, s, "http://latency.space/api/forecast?body=europa&days=7&format=csv")
+	if ct := rec.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rows[0], ",") != "kind,start,end,body,summary,min_one_way_seconds,max_one_way_seconds,occluded_by,uid" {
+		t.Fatalf("header %q", rows[0])
+	}
+	col := make(map[string]int)
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+
+	var days, blocked int
+	for _, row := range rows[1:] {
+		start, err1 := time.Parse(time.RFC3339, row[col["start"]])
+		end, err2 := time.Parse(time.RFC3339, row[col["end"]])
+		if err1 != nil || err2 != nil || !start.Before(end) || row[col["body"]] != "Europa" || row[col["uid"]] == "" {
+			t.Fatalf("row %q", row)
+		}
+		switch row[col["kind"]] {
+		case "latency":
+			lo, err1 := strconv.ParseFloat(row[col["min_one_way_seconds"]], 64)
+			hi, err2 := strconv.ParseFloat(row[col["max_one_way_seconds"]], 64)
+			if err1 != nil || err2 != nil || lo <= 0 || lo > hi || end.Sub(start) != 24*time.Hour {
+				t.Errorf("day row %q", row)
+			}
+			if want := time.Date(2026, 1, 1+days, 0, 0, 0, 0, time.UTC); !start.Equal(want) {
+				t.Errorf("day %d starts %v, want %v", days, start, want)
+			}
+			days++
+		case "blocked":
+			blocked++
+			if row[col["occluded_by"]] != "Jupiter" || row[col["summary"]] != "Europa behind Jupiter — no comms" || row[col["min_one_way_seconds"]] != "" {
+				t.Errorf("blocked row %q", row)
+			}
+		}
+	}
+	// Europa goes behind Jupiter every orbit, 3.55 days.
+	if days != 7 || blocked < 1 {
+		t.Errorf("%d day rows and %d blocked rows", days, blocked)
+	}
+}
+
+func TestForecastJSONAndLimits(t *testing.T) {
+	s := forecastFixture(t, time.Date(2026, 1, 1, 6, 0, 0, 0, time.UTC))
+	var out ForecastResponse
+	if err := json.Unmarshal(getForecast(t, s, "http://mars.latency.space/api/forecast?days=20").Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Body != "Mars" || len(out.Days) != 20 || !out.Start.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("%s, %d days from %v", out.Body, len(out.Days), out.Start)
+	}
+	var degraded bool
+	for _, e := range out.Events {
+		degraded = degraded || e.Kind == "degraded" && e.OccludedBy == "Sun"
+	}
+	if !degraded {
+		t.Errorf("no degraded window for the January 2026 conjunction in %+v", out.Events)
+	}
+
+	for url, want := range map[string]int{
+		"http://latency.space/api/forecast?body=mars&days=365": http.StatusOK,
+		"http://latency.space/api/forecast?body=mars&days=366": http.StatusBadRequest,
+		"http://latency.space/api/forecast?body=mars&days=0":   http.StatusBadRequest,
+		"http://latency.space/api/forecast?body=mars&format=x": http.StatusBadRequest,
+		"http://latency.space/api/forecast?body=earth":         http.StatusBadRequest,
+		"http://latency.space/api/forecast":                    http.StatusBadRequest,
+		"http://latency.space/api/forecast?body=vulcan":        http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != want {
+			t.Errorf("%s: %d, want %d (%s)", url, rec.Code, want, rec.Body.String())
+		}
+	}
+}
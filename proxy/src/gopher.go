@@ -0,0 +1,279 @@
+// gopher.go - a Gopher (RFC 1436) listener, finger's menu-driven sibling.
+//
+// -gopher-port 70 enables it. The selectors:
+//
+//	(empty)                          every body, nearest first
+//	/mars                            Mars's menu: its info text and moons
+//	/mars/info                       the text finger sends for mars
+//	/mars/proxy/<target>             a resource fetched via Mars
+//	/proxy/<target>                  the same via the default body
+//
+// A target is a Gopher server and selector, host[:port]/selector (port 70
+// by default; the selector keeps its slash and a bare / is the root), or an
+// http:// or https:// URL, which is fetched with the store-and-forward
+// path's client. Either way it must pass the destination allowlist. Like finger, everything about a body is held back by its
+// one-way latency; the root menu and Earth are answered at once.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+const (
+	gopherReadTimeout    = 30 * time.Second
+	gopherMaxSelectorLen = 1024
+	gopherDefaultPort    = 70
+)
+
+// GopherServer serves the Gopher listener.
+type GopherServer struct {
+	addr     string
+	host     string // advertised in menu items
+	port     int    // advertised in menu items; the bound port
+	body     string // answers /proxy/ selectors that name no body
+	security *SecurityValidator
+	upstream *DTNStore    // fetches http(s) targets: its client, redirect checks and response cap
+	limiter  *RateLimiter // nil = no per-IP limits
+	metrics  Metrics
+	timing   // clock and latency source (clock.go)
+
+	*trackedListener // listener, live connections and Stop
+}
+
+// NewGopherServer creates a Gopher server for addr whose menus point at
+// host. Call Listen, then Serve.
+func NewGopherServer(addr, host string, security *SecurityValidator, upstream *DTNStore, metrics Metrics) *GopherServer {
+	return &GopherServer{
+		addr:            addr,
+		host:            host,
+		body:            "Mars",
+		security:        security,
+		upstream:        upstream,
+		metrics:         orNop(metrics),
+		trackedListener: newTrackedListener(),
+	}
+}
+
+// Listen binds the listener.
+func (g *GopherServer) Listen() error {
+	l, err := net.Listen("tcp", g.addr)
+	if err != nil {
+		return fmt.Errorf("gopher %s: %v", g.addr, err)
+	}
+	g.listener = l
+	g.port = l.Addr().(*net.TCPAddr).Port
+	return nil
+}
+
+// Serve accepts connections until Stop is called.
+func (g *GopherServer) Serve() error {
+	log.Printf("Gopher server listening on %s", g.listener.Addr())
+	return g.serve("Gopher", g.limiter, g.handle)
+}
+
+// handle answers one selector.
+func (g *GopherServer) handle(conn net.Conn) {
+	start := g.now()
+	w := crlfWriter{conn}
+
+	conn.SetReadDeadline(time.Now().Add(gopherReadTimeout))
+	line, err := bufio.NewReader(io.LimitReader(conn, gopherMaxSelectorLen)).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	// A search string follows a tab; nothing here takes one.
+	selector, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), "\t")
+
+	path := strings.TrimPrefix(selector, "/")
+	if path == "" {
+		g.writeRootMenu(w)
+		return
+	}
+	name, rest, _ := strings.Cut(path, "/")
+	if name == "proxy" {
+		name, rest = g.body, path
+	}
+	objects := getCelestialObjects()
+	obj, found := findObjectByName(objects, name)
+	if !found {
+		g.writeError(w, "No such body: "+name)
+		return
+	}
+
+	var target gopherTarget
+	switch {
+	case rest == "", rest == "info":
+	case strings.HasPrefix(rest, "proxy/"):
+		if target, err = g.proxyTarget(obj.Name, strings.TrimPrefix(rest, "proxy/")); err != nil {
+			g.writeError(w, err.Error())
+			return
+		}
+	default:
+		g.writeError(w, "No such selector: "+selector)
+		return
+	}
+
+	// Earth answers at once; everything else is held back by its light time.
+	if !strings.EqualFold(obj.Name, "Earth") {
+		distance, err := getCurrentDistance(obj.Name)
+		if err != nil {
+			g.writeError(w, err.Error())
+			return
+		}
+		if err := sleepCtx(g.ctx, g.clk(), g.oneWay(obj.Name, distance)); err != nil {
+			return
+		}
+	}
+	switch rest {
+	case "":
+		g.writeBodyMenu(w, obj, objects)
+	case "info":
+		writeBodyText(w, obj, objects, simTime(g.now()))
+		fmt.Fprintln(w, ".")
+	default:
+		if n, err := g.fetch(conn, target); err != nil {
+			log.Printf("Gopher fetch of %s via %s failed: %v", target, obj.Name, err)
+			if n == 0 {
+				g.writeError(w, err.Error())
+			}
+		}
+	}
+	g.metrics.RecordRequest(obj.Name, "gopher", g.since(start))
+}
+
+// item writes one menu line.
+func (g *GopherServer) item(w io.Writer, kind byte, display, selector string) {
+	fmt.Fprintf(w, "%c%s\t%s\t%s\t%d\n", kind, display, selector, g.host, g.port)
+}
+
+// writeRootMenu lists every body, nearest first.
+func (g *GopherServer) writeRootMenu(w io.Writer) {
+	g.item(w, 'i', "latency.space - every body by its one-way light time from Earth", "")
+	g.item(w, 'i', "", "")
+	for _, entry := range bodiesByLatency() {
+		latency := CalculateLatency(entry.Distance).Round(time.Second)
+		g.item(w, '1', fmt.Sprintf("%s (%s)", entry.Object.Name, latency), "/"+FormatDomainName(entry.Object.Name))
+	}
+	fmt.Fprintln(w, ".")
+}
+
+// writeBodyMenu links obj's info text and moons.
+func (g *GopherServer) writeBodyMenu(w io.Writer, obj celestial.CelestialObject, objects []celestial.CelestialObject) {
+	slug := "/" + FormatDomainName(obj.Name)
+	g.item(w, 'i', fmt.Sprintf("%s (%s)", obj.Name, obj.Type), "")
+	g.item(w, '0', "Distance, latency and visibility", slug+"/info")
+	for _, moon := range moonsOf(objects, obj.Name) {
+		g.item(w, '1', moon.Name, "/"+FormatDomainName(moon.Name))
+	}
+	g.item(w, 'i', "", "")
+	g.item(w, 'i', fmt.Sprintf("Fetch through %s: %s/proxy/host/selector or %s/proxy/https://host/path", obj.Name, slug, slug), "")
+	g.item(w, '1', "All bodies", "")
+	fmt.Fprintln(w, ".")
+}
+
+// writeError writes a Gopher error item.
+func (g *GopherServer) writeError(w io.Writer, msg string) {
+	fmt.Fprintf(w, "3%s\t\terror.host\t1\n.\n", msg)
+}
+
+// gopherTarget is what a proxy selector fetches: an http(s) URL, or a
+// Gopher server address and selector.
+type gopherTarget struct {
+	url      string
+	addr     string
+	selector string
+}
+
+func (t gopherTarget) String() string {
+	if t.url != "" {
+		return t.url
+	}
+	return "gopher://" + t.addr + t.selector
+}
+
+// proxyTarget parses and checks the target of a proxy selector via body.
+func (g *GopherServer) proxyTarget(body, raw string) (gopherTarget, error) {
+	if err := checkProxyable(body); err != nil {
+		return gopherTarget{}, err
+	}
+	if distance, err := getCurrentDistance(body); err != nil {
+		return gopherTarget{}, err
+	} else if err := checkLatencyFloor(body, g.oneWay(body, distance), g.security.minLatency); err != nil {
+		return gopherTarget{}, err
+	}
+
+	if strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://") {
+		u, err := g.security.ValidateHTTPTarget(raw)
+		if err != nil {
+			recordDenial(g.metrics, protoGopher, err)
+			return gopherTarget{}, err
+		}
+		return gopherTarget{url: u}, nil
+	}
+
+	// The selector keeps its leading slash; a bare "/" is the server's root.
+	hostPort, selector := raw, ""
+	if i := strings.IndexByte(raw, '/'); i >= 0 {
+		hostPort, selector = raw[:i], raw[i:]
+	}
+	if selector == "/" {
+		selector = ""
+	}
+	host, port := hostPort, strconv.Itoa(gopherDefaultPort)
+	if strings.Contains(hostPort, ":") {
+		var err error
+		if host, port, err = net.SplitHostPort(hostPort); err != nil {
+			return gopherTarget{}, fmt.Errorf("invalid target %q: %v", hostPort, err)
+		}
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || n == 0 {
+		return gopherTarget{}, fmt.Errorf("invalid port %q", port)
+	}
+	if err := g.security.ValidateSocksDestination(host, uint16(n)); err != nil {
+		recordDenial(g.metrics, protoGopher, err)
+		return gopherTarget{}, err
+	}
+	return gopherTarget{addr: net.JoinHostPort(host, port), selector: selector}, nil
+}
+
+// fetch sends target's response to conn as is, up to the response cap,
+// and returns how many bytes it sent.
+func (g *GopherServer) fetch(conn net.Conn, target gopherTarget) (int64, error) {
+	limit := g.upstream.maxResponse
+	if target.url != "" {
+		status, _, body, fetchErr, _ := g.upstream.fetch(g.ctx, http.MethodGet, target.url, nil, "")
+		if fetchErr != "" {
+			return 0, errors.New(fetchErr)
+		}
+		if status >= 400 {
+			return 0, fmt.Errorf("%s answered %d", target.url, status)
+		}
+		n, err := io.WriteString(conn, body[:min(int64(len(body)), limit)])
+		return int64(n), err
+	}
+
+	d := net.Dialer{Timeout: defaultUpstreamTimeouts.Connect}
+	remote, err := d.DialContext(g.ctx, "tcp", target.addr)
+	if err != nil {
+		return 0, err
+	}
+	defer remote.Close()
+	remote.SetDeadline(time.Now().Add(bodyTransferDeadline(-1, limit)))
+	if _, err := io.WriteString(remote, target.selector+"\r\n"); err != nil {
+		return 0, err
+	}
+	return io.Copy(conn, io.LimitReader(remote, limit))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// startGopherServer runs a Gopher server on a loopback port where every body
+// is latency away.
+func startGopherServer(t *testing.T, latency time.Duration, security *SecurityValidator) string {
+	t.Helper()
+	metrics := NewRecordingMetrics()
+	g := NewGopherServer("127.0.0.1:0", "gopher.test", security, NewDTNStore(t.TempDir()+"/dtn.json", security, metrics), metrics)
+	g.timing = fixedLatency(latency)
+	if err := g.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go g.Serve()
+	t.Cleanup(g.Stop)
+	return g.Addr().String()
+}
+
+// gopherGet sends one selector and returns the raw response and how long it
+// took.
+func gopherGet(t *testing.T, addr, selector string) (string, time.Duration) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	if _, err := conn.Write([]byte(selector + "\r\n")); err != nil {
+		t.Fatalf("write selector: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	raw, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	return string(raw), time.Since(start)
+}
+
+// gopherMenu splits a menu into its items, checking each is well formed and
+// the menu is terminated.
+func gopherMenu(t *testing.T, raw string) [][]string {
+	t.Helper()
+	if !strings.HasSuffix(raw, "\r\n.\r\n") {
+		t.Fatalf("menu not terminated by a lone dot: %q", raw)
+	}
+	var items [][]string
+	for _, line := range strings.Split(strings.TrimSuffix(raw, "\r\n.\r\n"), "\r\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 || line == "" {
+			t.Fatalf("malformed menu line %q", line)
+		}
+		items = append(items, fields)
+	}
+	return items
+}
+
+func TestGopherMenus(t *testing.T) {
+	const latency = 200 * time.Millisecond
+	withObjects(t, celestial.InitSolarSystemObjects())
+	addr := startGopherServer(t, latency, newTestSecurity())
+
+	// The root menu is instant and lists every body as a submenu.
+	raw, elapsed := gopherGet(t, addr, "")
+	if elapsed >= latency {
+		t.Errorf("root menu took %v; it should not be delayed", elapsed)
+	}
+	bodies := map[string]string{}
+	for _, item := range gopherMenu(t, raw) {
+		if item[0][0] == '1' {
+			bodies[item[1]] = item[0][1:]
+			if item[2] != "gopher.test" || item[3] != strings.TrimPrefix(addr[strings.LastIndex(addr, ":"):], ":") {
+				t.Errorf("item %q points at %s:%s", item[0], item[2], item[3])
+			}
+		}
+	}
+	if !strings.HasPrefix(bodies["/mars"], "Mars (") || !strings.HasPrefix(bodies["/voyager-1"], "Voyager 1 (") {
+		t.Errorf("root menu bodies: %v", bodies)
+	}
+
+	// A body's menu is held back by its latency and links its text.
+	raw, elapsed = gopherGet(t, addr, "/mars")
+	if elapsed < latency {
+		t.Errorf("Mars's menu took %v, want at least %v", elapsed, latency)
+	}
+	var selectors []string
+	for _, item := range gopherMenu(t, raw) {
+		if item[0][0] != 'i' {
+			selectors = append(selectors, item[0][:1]+item[1])
+		}
+	}
+	if got := strings.Join(selectors, " "); got != "0/mars/info 1/phobos 1/deimos 1" {
+		t.Errorf("Mars menu items %q", got)
+	}
+
+	raw, elapsed = gopherGet(t, addr, "/mars/info")
+	if elapsed < latency || !strings.Contains(raw, "Body:         Mars (planet)\r\n") || !strings.HasSuffix(raw, "\r\n.\r\n") {
+		t.Errorf("Mars text after %v: %q", elapsed, raw)
+	}
+	raw, elapsed = gopherGet(t, addr, "/earth/info")
+	if elapsed >= latency || !strings.Contains(raw, "Earth (planet)") {
+		t.Errorf("Earth text after %v: %q", elapsed, raw)
+	}
+
+	raw, _ = gopherGet(t, addr, "/nibiru")
+	if !strings.HasPrefix(raw, "3No such body: nibiru\t") {
+		t.Errorf("unknown body: %q", raw)
+	}
+}
+
+// startGopherHole serves "hello from <selector>" to every Gopher request.
+func startGopherHole(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				selector, _ := bufio.NewReader(c).ReadString('\n')
+				fmt.Fprintf(c, "hello from %s\r\n.\r\n", strings.TrimSpace(selector))
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestGopherProxy(t *testing.T) {
+	const latency = 100 * time.Millisecond
+	withObjects(t, celestial.InitSolarSystemObjects())
+	hole := startGopherHole(t)
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "web page "+r.URL.Path)
+	}))
+	defer web.Close()
+	sec := newTestSecurity()
+	_, port, _ := net.SplitHostPort(hole)
+	sec.allowedPorts[port] = true
+	addr := startGopherServer(t, latency, sec)
+
+	for _, tc := range []struct{ selector, want string }{
+		{"/proxy/" + hole + "/docs/readme", "hello from /docs/readme\r\n.\r\n"},
+		{"/jupiter/proxy/" + hole + "/", "hello from \r\n.\r\n"},
+		{"/mars/proxy/" + web.URL + "/page", "web page /page"},
+	} {
+		raw, elapsed := gopherGet(t, addr, tc.selector)
+		if raw != tc.want {
+			t.Errorf("%s: %q, want %q", tc.selector, raw, tc.want)
+		}
+		if elapsed < latency {
+			t.Errorf("%s took %v, want at least %v", tc.selector, elapsed, latency)
+		}
+	}
+
+	// Destinations off the allow-list are refused at once.
+	for _, selector := range []string{
+		"/proxy/gopher.example.invalid/",
+		"/mars/proxy/github.com:6379/",
+		"/mars/proxy/https://example.invalid/",
+		"/sun/proxy/" + hole + "/",
+	} {
+		raw, elapsed := gopherGet(t, addr, selector)
+		if !strings.HasPrefix(raw, "3") || !strings.HasSuffix(raw, "\r\n.\r\n") {
+			t.Errorf("%s: %q, want an error item", selector, raw)
+		}
+		if elapsed >= latency {
+			t.Errorf("%s: refusal took %v", selector, elapsed)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// groundstation.go - latency from a DSN antenna rather than Earth's centre.
+//
+// Distances are computed from Earth's centre, but the signal leaves from a
+// dish on the surface. Earth's rotation carries the dish up to an Earth
+// radius towards or away from the target over a day: about 42 ms peak to
+// peak, noise for Mars but over 3% of the Moon's light time. A dish can
+// also only work a target above its horizon.
+//
+// -ground-station picks the model:
+//
+//	off        distances from Earth's centre (the default)
+//	auto       the station with the target highest above its horizon
+//	goldstone  always that station (or madrid, canberra)
+//
+// With a station model on, a target below the horizon of every eligible
+// station is unreachable, as if occluded. The station used and the distance
+// it adds (negative: closer) are reported in /api/status-data and in the
+// X-Latency-Space-Ground-Station header.
+//
+// The offset is applied to the hourly cached distance along the cached
+// direction, so it follows Earth's rotation between refreshes. Station
+// positions ignore Earth's flattening and precession since J2000, both far
+// below the model's other approximations.
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// GroundStation is an antenna site on Earth's surface.
+type GroundStation struct {
+	Name     string
+	Lat, Lon float64 // degrees, east positive
+}
+
+// groundStations are the three Deep Space Network complexes.
+var groundStations = []GroundStation{
+	{Name: "Goldstone", Lat: 35.4267, Lon: -116.8900},
+	{Name: "Madrid", Lat: 40.4314, Lon: -4.2481},
+	{Name: "Canberra", Lat: -35.4014, Lon: 148.9817},
+}
+
+// stationMinElevationDeg is the lowest elevation a station works a target
+// at; DSN antennas don't track much closer to the horizon.
+const stationMinElevationDeg = 6.0
+
+// obliquityJ2000 is the tilt of Earth's equator to the ecliptic, radians.
+var obliquityJ2000 = degToRad(23.4392911)
+
+// groundStationMode is a -ground-station setting: the stations a target may
+// be worked from.
+type groundStationMode struct {
+	stations []GroundStation
+}
+
+// groundStationPtr holds the mode; nil means distances from Earth's centre.
+var groundStationPtr atomic.Pointer[groundStationMode]
+
+func activeGroundStations() *groundStationMode {
+	return groundStationPtr.Load()
+}
+
+// setGroundStationMode replaces the mode; nil turns the model off.
+func setGroundStationMode(m *groundStationMode) {
+	groundStationPtr.Store(m)
+}
+
+// parseGroundStationMode reads -ground-station: off, auto or a station name.
+func parseGroundStationMode(spec string) (*groundStationMode, error) {
+	switch spec = strings.TrimSpace(spec); strings.ToLower(spec) {
+	case "", "off":
+		return nil, nil
+	case "auto":
+		return &groundStationMode{stations: groundStations}, nil
+	}
+	for _, st := range groundStations {
+		if strings.EqualFold(st.Name, spec) {
+			return &groundStationMode{stations: []GroundStation{st}}, nil
+		}
+	}
+	names := make([]string, len(groundStations))
+	for i, st := range groundStations {
+		names[i] = strings.ToLower(st.Name)
+	}
+	return nil, fmt.Errorf("unknown ground station %q (want off, auto, %s)", spec, strings.Join(names, ", "))
+}
+
+// unreachable describes the stations a target is below the horizon of.
+func (m *groundStationMode) unreachable() string {
+	if len(m.stations) == 1 {
+		return m.stations[0].Name
+	}
+	return "every ground station"
+}
+
+// greenwichSiderealTime returns the Earth's rotation angle at t, radians.
+func greenwichSiderealTime(t time.Time) float64 {
+	d := timeToJulianDate(t) - celestial.J2000_EPOCH
+	return normalizeRadians(degToRad(280.46061837 + 360.98564736629*d))
+}
+
+// stationOffset returns st's position relative to Earth's centre at t, in
+// km, in the ecliptic frame of the body positions.
+func stationOffset(st GroundStation, t time.Time) celestial.Vector3 {
+	lat := degToRad(st.Lat)
+	lst := greenwichSiderealTime(t) + degToRad(st.Lon)
+	x := celestial.EARTH_RADIUS * math.Cos(lat) * math.Cos(lst)
+	y := celestial.EARTH_RADIUS * math.Cos(lat) * math.Sin(lst)
+	z := celestial.EARTH_RADIUS * math.Sin(lat)
+	// Equatorial to ecliptic: rotate about the equinox by the obliquity.
+	ce, se := math.Cos(obliquityJ2000), math.Sin(obliquityJ2000)
+	return celestial.Vector3{X: x, Y: y*ce + z*se, Z: -y*se + z*ce}
+}
+
+// StationFix is the ground station a target is worked from.
+type StationFix struct {
+	Station   string  // the best placed eligible station
+	Elevation float64 // of the target above its horizon, degrees
+	OffsetKm  float64 // station distance minus distance from Earth's centre
+	Visible   bool    // Elevation is at least stationMinElevationDeg
+}
+
+// fix picks the eligible station with the target, distanceKm from Earth's
+// centre in direction dir (a unit vector), highest above its horizon.
+func (m *groundStationMode) fix(distanceKm float64, dir celestial.Vector3, t time.Time) StationFix {
+	best := StationFix{Elevation: -90}
+	for _, st := range m.stations {
+		offset := stationOffset(st, t)
+		topo := dir.Scale(distanceKm).Subtract(offset)
+		sin := offset.Normalize().DotProduct(topo.Normalize())
+		elevation := math.Asin(math.Max(-1, math.Min(1, sin))) * 180 / math.Pi
+		if elevation > best.Elevation {
+			best = StationFix{Station: st.Name, Elevation: elevation, OffsetKm: topo.Magnitude() - distanceKm}
+		}
+	}
+	best.Visible = best.Elevation >= stationMinElevationDeg
+	return best
+}
+
+// stationFor applies the ground-station model to a cached entry at t. It
+// returns nil when the model is off.
+func stationFor(e DistanceEntry, t time.Time) *StationFix {
+	m := activeGroundStations()
+	if m == nil || e.Direction == (celestial.Vector3{}) {
+		return nil
+	}
+	fix := m.fix(e.Distance, e.Direction, t)
+	return &fix
+}
+
+// stationOutage is the ground-station part of linkOutage: the link is down
+// when no eligible station has target above its horizon.
+func stationOutage(earth, target CelestialObject, objects []CelestialObject, t time.Time) (LinkOutage, bool) {
+	m := activeGroundStations()
+	if m == nil {
+		return LinkOutage{}, false
+	}
+	rel := GetObjectPosition(target, objects, t).Subtract(GetObjectPosition(earth, objects, t))
+	if m.fix(rel.Magnitude()*celestial.AU, rel.Normalize(), t).Visible {
+		return LinkOutage{}, false
+	}
+	return LinkOutage{BelowHorizon: m.unreachable()}, true
+}
+
+// setStationHeader reports the station body is worked from, e.g.
+// "Goldstone; elevation=41.2; offset-km=-4803.6", or "none" when every
+// station has it below the horizon. Nothing is set when the model is off.
+func setStationHeader(w http.ResponseWriter, body string) {
+	e, ok := cachedEntry(body)
+	if !ok {
+		return
+	}
+	fix := stationFor(e, simTime(distanceClock()))
+	if fix == nil {
+		return
+	}
+	value := "none"
+	if fix.Visible {
+		value = fmt.Sprintf("%s; elevation=%.1f; offset-km=%.1f", fix.Station, fix.Elevation, fix.OffsetKm)
+	}
+	w.Header().Set("X-Latency-Space-Ground-Station", value)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// withGroundStation sets -ground-station to spec for one test.
+func withGroundStation(t *testing.T, spec string) *groundStationMode {
+	t.Helper()
+	m, err := parseGroundStationMode(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := activeGroundStations()
+	setGroundStationMode(m)
+	t.Cleanup(func() { setGroundStationMode(orig) })
+	return m
+}
+
+// fromEarth returns the geometric distance and direction of body from
+// Earth's centre at when.
+func fromEarth(t *testing.T, objects []celestial.CelestialObject, body string, when time.Time) (float64, celestial.Vector3) {
+	t.Helper()
+	earth, _ := findObjectByName(objects, "Earth")
+	target, ok := findObjectByName(objects, body)
+	if !ok {
+		t.Fatalf("no %s", body)
+	}
+	rel := GetObjectPosition(target, objects, when).Subtract(GetObjectPosition(earth, objects, when))
+	return rel.Magnitude() * celestial.AU, rel.Normalize()
+}
+
+func TestParseGroundStationMode(t *testing.T) {
+	for _, spec := range []string{"", "off", "OFF"} {
+		if m, err := parseGroundStationMode(spec); m != nil || err != nil {
+			t.Errorf("%q: %v, %v, want geocentric", spec, m, err)
+		}
+	}
+	if m, err := parseGroundStationMode("auto"); err != nil || len(m.stations) != 3 {
+		t.Errorf("auto: %v, %v", m, err)
+	}
+	if m, err := parseGroundStationMode("madrid"); err != nil || len(m.stations) != 1 || m.stations[0].Name != "Madrid" {
+		t.Errorf("madrid: %v, %v", m, err)
+	}
+	if _, err := parseGroundStationMode("arecibo"); err == nil || !strings.Contains(err.Error(), "goldstone") {
+		t.Errorf("unknown station: %v, want the choices listed", err)
+	}
+}
+
+// TestGroundStationMoonDiurnal follows the Moon from Goldstone for a day:
+// the station's distance swings by about an Earth radius either side of the
+// geocentric one, scaled by cos(latitude) and the Moon's cos(declination).
+func TestGroundStationMoonDiurnal(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	m := withGroundStation(t, "goldstone")
+	goldstone := m.stations[0]
+	t0 := time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)
+
+	minOff, maxOff := math.Inf(1), math.Inf(-1)
+	var cosDec float64
+	var n int
+	for when := t0; when.Before(t0.Add(24 * time.Hour)); when = when.Add(10 * time.Minute) {
+		d, dir := fromEarth(t, objects, "Moon", when)
+		fix := m.fix(d, dir, when)
+
+		// The offset is the exact distance from the station's position.
+		earth, _ := findObjectByName(objects, "Earth")
+		moon, _ := findObjectByName(objects, "Moon")
+		station := GetObjectPosition(earth, objects, when).Add(stationOffset(goldstone, when).Scale(1 / celestial.AU))
+		direct := GetObjectPosition(moon, objects, when).Subtract(station).Magnitude()*celestial.AU - d
+		if math.Abs(direct-fix.OffsetKm) > 1 {
+			t.Fatalf("%v: offset %.1f km, station position gives %.1f", when, fix.OffsetKm, direct)
+		}
+		// Closer when the Moon is up, further when it is down (parallax
+		// blurs the horizon itself by a degree).
+		if math.Abs(fix.Elevation) > 2 && (fix.Elevation > 0) != (fix.OffsetKm < 0) {
+			t.Errorf("%v: elevation %.1f with offset %.1f", when, fix.Elevation, fix.OffsetKm)
+		}
+
+		minOff, maxOff = math.Min(minOff, fix.OffsetKm), math.Max(maxOff, fix.OffsetKm)
+		// Declination: the equatorial z of the ecliptic direction.
+		zEq := dir.Y*math.Sin(obliquityJ2000) + dir.Z*math.Cos(obliquityJ2000)
+		cosDec += math.Sqrt(1 - zEq*zEq)
+		n++
+	}
+	cosDec /= float64(n)
+
+	amplitude := (maxOff - minOff) / 2
+	want := celestial.EARTH_RADIUS * math.Cos(degToRad(goldstone.Lat)) * cosDec
+	if math.Abs(amplitude-want) > 0.05*want {
+		t.Errorf("diurnal amplitude %.0f km, want about %.0f km (R cos(lat) cos(dec))", amplitude, want)
+	}
+	// Only the Moon's nearness (R²/2D, under 1%) lets it exceed R cos(lat).
+	if amplitude > 1.01*celestial.EARTH_RADIUS*math.Cos(degToRad(goldstone.Lat)) {
+		t.Errorf("amplitude %.0f km exceeds R cos(lat)", amplitude)
+	}
+	if ms := 2 * amplitude / celestial.SPEED_OF_LIGHT * 1000; ms < 20 || ms > 42 {
+		t.Errorf("peak to peak %.1f ms of latency", ms)
+	}
+}
+
+// TestGroundStationHandover checks auto mode hands the Moon from station to
+// station over a day, always to the best placed one, while a single station
+// loses it below the horizon.
+func TestGroundStationHandover(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	auto := withGroundStation(t, "auto")
+	goldstone, _ := parseGroundStationMode("goldstone")
+	t0 := time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC)
+
+	var sequence []string
+	seen := make(map[string]bool)
+	var goldstoneUp, goldstoneDown int
+	for when := t0; when.Before(t0.Add(24 * time.Hour)); when = when.Add(15 * time.Minute) {
+		d, dir := fromEarth(t, objects, "Moon", when)
+		fix := auto.fix(d, dir, when)
+		for _, st := range groundStations {
+			if other := (&groundStationMode{stations: []GroundStation{st}}).fix(d, dir, when); other.Elevation > fix.Elevation {
+				t.Fatalf("%v: chose %s at %.1f deg over %s at %.1f", when, fix.Station, fix.Elevation, st.Name, other.Elevation)
+			}
+		}
+		if fix.Visible && (len(sequence) == 0 || sequence[len(sequence)-1] != fix.Station) {
+			sequence = append(sequence, fix.Station)
+		}
+		seen[fix.Station] = true
+		if goldstone.fix(d, dir, when).Visible {
+			goldstoneUp++
+		} else {
+			goldstoneDown++
+		}
+	}
+	if len(seen) < 2 || len(sequence) < 3 {
+		t.Errorf("stations over a day: %v, want handovers between several", sequence)
+	}
+	if goldstoneUp == 0 || goldstoneDown == 0 {
+		t.Errorf("Goldstone up %d, down %d samples; want both over a day", goldstoneUp, goldstoneDown)
+	}
+}
+
+// TestGroundStationLink checks a body below the fixed station's horizon is
+// refused like an occluded one, and that the distance and status API carry
+// the station when it is up.
+func TestGroundStationLink(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	objects := getCelestialObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+	moon, _ := findObjectByName(objects, "Moon")
+	withGroundStation(t, "goldstone")
+	goldstone, _ := parseGroundStationMode("goldstone")
+
+	// Find an hour with the Moon up at Goldstone and one with it down.
+	var up, down time.Time
+	t0 := time.Now().UTC().Truncate(time.Hour)
+	for when := t0; when.Before(t0.Add(48*time.Hour)) && (up.IsZero() || down.IsZero()); when = when.Add(30 * time.Minute) {
+		d, dir := fromEarth(t, objects, "Moon", when)
+		if fix := goldstone.fix(d, dir, when); fix.Elevation > 20 && up.IsZero() {
+			up = when
+		} else if fix.Elevation < -20 && down.IsZero() {
+			down = when
+		}
+	}
+	if up.IsZero() || down.IsZero() {
+		t.Fatal("the Moon neither rose nor set at Goldstone in two days")
+	}
+
+	outage, isDown := linkOutage(earth, moon, objects, down)
+	if !isDown || outage.BelowHorizon != "Goldstone" || outage.outcome() != outcomeNoStation {
+		t.Errorf("Moon set: %+v %v, want below Goldstone's horizon", outage, isDown)
+	}
+	if !strings.Contains(outage.String(), "below the horizon of Goldstone") {
+		t.Errorf("outage %q", outage)
+	}
+	if outage, isDown := linkOutage(earth, moon, objects, up); isDown {
+		t.Errorf("Moon up: %s", outage)
+	}
+
+	// The distance is the station's while the Moon is up; with it down the
+	// geocentric one is kept (the link check refuses the request).
+	for _, when := range []time.Time{up, down} {
+		fakeDistanceClock(t, when)
+		invalidateDistanceCache()
+		calculateDistancesFromEarth(objects, when) // the cache describes when
+		d := mustDistance(t, "Moon")
+		e, _ := cachedEntry("Moon")
+		fix := stationFor(e, when)
+		want := e.Distance
+		if fix.Visible {
+			want += fix.OffsetKm
+		}
+		if d != want || (when == up) != fix.Visible {
+			t.Errorf("%v: distance %.1f, want %.1f (%+v)", when, d, want, *fix)
+		}
+
+		rec := httptest.NewRecorder()
+		s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+		s.handleTime(rec, httptest.NewRequest("GET", "/api/time?body=moon", nil))
+		header := rec.Header().Get("X-Latency-Space-Ground-Station")
+		if fix.Visible && !strings.HasPrefix(header, "Goldstone; elevation=") || !fix.Visible && header != "none" {
+			t.Errorf("%v: header %q", when, header)
+		}
+
+		status := buildStatusResponse(currentSnapshot(when), when)
+		data, _ := json.Marshal(status)
+		checkSchema(t, loadOpenAPI(t), "StatusResponse", string(data))
+		for _, entry := range status.Objects["moons"] {
+			if entry.Name != "Moon" {
+				continue
+			}
+			if fix.Visible && (entry.GroundStation != "Goldstone" || entry.StationOffset >= 0 || entry.NoStation) {
+				t.Errorf("up: status %+v", entry)
+			}
+			if !fix.Visible && (entry.GroundStation != "" || !entry.NoStation) {
+				t.Errorf("down: status %+v", entry)
+			}
+		}
+	}
+
+	// Off: nothing reported.
+	setGroundStationMode(nil)
+	if e, _ := cachedEntry("Moon"); stationFor(e, up) != nil {
+		t.Error("station reported with the model off")
+	}
+	if _, isDown := linkOutage(earth, moon, objects, down); isDown {
+		t.Error("Moon refused with the model off")
+	}
+}
@@ -0,0 +1,111 @@
+// groups.go - index pages for the group subdomains.
+//
+//	planets.latency.space, moons., spacecraft., asteroids., comets., all.
+//
+// Each lists its members (celestial.BodyGroups) nearest first, linking to
+// their info pages; /api/bodies on the same host returns the list as JSON.
+// Like the info pages, these are served straight away with no latency.
+package main
+
+import (
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// bodyGroupForHost returns the group named by a group.latency.space host.
+func bodyGroupForHost(host string) (celestial.BodyGroup, bool) {
+	if idx := strings.Index(host, ":"); idx > 0 {
+		host = host[:idx]
+	}
+	label, rest, ok := strings.Cut(host, ".")
+	if !ok || !strings.EqualFold(rest, "latency.space") {
+		return celestial.BodyGroup{}, false
+	}
+	return celestial.FindBodyGroup(label)
+}
+
+// groupMembers returns the bodies in group, nearest to Earth first (ties by
+// name).
+func groupMembers(group celestial.BodyGroup, objects []CelestialObject) []BodyInfo {
+	var members []CelestialObject
+	distances := make(map[string]float64)
+	for _, obj := range objects {
+		if group.Includes(obj) {
+			members = append(members, obj)
+			distances[obj.Name], _ = getCurrentDistance(obj.Name)
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		di, dj := distances[members[i].Name], distances[members[j].Name]
+		if di != dj {
+			return di < dj
+		}
+		return members[i].Name < members[j].Name
+	})
+	bodies := make([]BodyInfo, 0, len(members))
+	for _, obj := range members {
+		bodies = append(bodies, bodyInfo(obj))
+	}
+	return bodies
+}
+
+// groupRow is one body on a group index page.
+type groupRow struct {
+	Name, Type, Link, OneWay, RoundTrip string
+}
+
+// groupPage is the data for groupTemplate.
+type groupPage struct {
+	Title  string
+	Groups []celestial.BodyGroup
+	Rows   []groupRow
+	Origin func(domain string) string
+}
+
+var groupTemplate = htmltemplate.Must(htmltemplate.New("group").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} - latency.space</title>
+<style>body{font-family:sans-serif;max-width:48em;margin:2em auto;padding:0 1em}table{border-collapse:collapse;width:100%}th,td{text-align:left;padding:.3em .6em;border-bottom:1px solid #ddd}td.n{text-align:right}</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{range $i, $g := .Groups}}{{if $i}} &middot; {{end}}<a href="{{call $.Origin (printf "%s.latency.space" $g.Name)}}/">{{$g.Title}}</a>{{end}}</p>
+<p>Sorted by current one-way light time from Earth. Each body's page explains how to proxy through it.</p>
+<table>
+<tr><th>Body</th><th>Type</th><th>One way</th><th>Round trip</th></tr>
+{{range .Rows}}<tr><td><a href="{{.Link}}">{{.Name}}</a></td><td>{{.Type}}</td><td class="n">{{.OneWay}}</td><td class="n">{{.RoundTrip}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// handleGroupIndex serves the HTML index for group.
+func (s *Server) handleGroupIndex(w http.ResponseWriter, r *http.Request, group celestial.BodyGroup) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	page := groupPage{Title: group.Title, Groups: celestial.BodyGroups, Origin: s.webOrigin}
+	for _, b := range groupMembers(group, getCelestialObjects()) {
+		latency := time.Duration(b.Latency * float64(time.Second))
+		page.Rows = append(page.Rows, groupRow{
+			Name:      b.Name,
+			Type:      strings.ReplaceAll(b.Type, "_", " "),
+			Link:      s.webOrigin(b.Domain) + "/",
+			OneWay:    displayDuration(latency),
+			RoundTrip: displayDuration(2 * latency),
+		})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := groupTemplate.Execute(w, page); err != nil {
+		log.Printf("Error rendering %s index: %v", group.Name, err)
+	}
+}
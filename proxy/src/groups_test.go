@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestBodyGroupHostClassification(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	s := &Server{}
+
+	for host, want := range map[string]string{
+		"planets.latency.space":        "group planets",
+		"ALL.latency.space:8080":       "group all",
+		"spacecraft.latency.space":     "group spacecraft",
+		"mars.latency.space":           "body Mars",
+		"voyager-1.latency.space":      "body Voyager 1",
+		"moon.earth.latency.space":     "body Moon",
+		"moons.latency.space":          "group moons",
+		"phobos.planets.latency.space": "unknown",
+		"planet.latency.space":         "unknown",
+		"planets.example.com":          "unknown",
+	} {
+		got := "unknown"
+		if g, ok := bodyGroupForHost(host); ok {
+			got = "group " + g.Name
+		} else if body := s.resolveCelestialHost(getCelestialObjects(), host); body != "" {
+			got = "body " + body
+		}
+		if got != want {
+			t.Errorf("%s: got %s, want %s", host, got, want)
+		}
+	}
+}
+
+// TestGroupIndex checks the index pages and their JSON list nearest first,
+// each with only its group's members.
+func TestGroupIndex(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", url, rec.Code)
+		}
+		return rec
+	}
+
+	for _, group := range celestial.BodyGroups {
+		var resp BodiesResponse
+		if err := json.Unmarshal(get("http://"+group.Name+".latency.space/api/bodies").Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Bodies) == 0 {
+			t.Errorf("%s: no bodies", group.Name)
+		}
+		for i, b := range resp.Bodies {
+			obj, _ := findObjectByName(getCelestialObjects(), b.Name)
+			if !group.Includes(obj) || obj.Type == "star" {
+				t.Errorf("%s: lists %s (%s)", group.Name, b.Name, b.Type)
+			}
+			if i > 0 && mustDistance(t, resp.Bodies[i-1].Name) > mustDistance(t, b.Name) {
+				t.Errorf("%s: %s listed before nearer %s", group.Name, resp.Bodies[i-1].Name, b.Name)
+			}
+		}
+	}
+
+	page := get("http://planets.latency.space/").Body.String()
+	var names []string
+	for _, m := range regexp.MustCompile(`<td><a href="[^"]*">([^<]+)</a></td>`).FindAllStringSubmatch(page, -1) {
+		names = append(names, m[1])
+	}
+	var want []string
+	for _, b := range groupMembers(celestial.BodyGroups[1], getCelestialObjects()) {
+		want = append(want, b.Name)
+	}
+	if strings.Join(names, ",") != strings.Join(want, ",") || len(names) != 13 {
+		t.Errorf("planets page lists %v, want %v", names, want)
+	}
+	if !strings.Contains(get("http://moons.latency.space/").Body.String(), `href="https://phobos.mars.latency.space/"`) {
+		t.Error("moons page should link Phobos under Mars")
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://planets.latency.space/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown path on a group host: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestGroupNamesReserved(t *testing.T) {
+	if err := validateObjects(celestial.InitSolarSystemObjects()); err != nil {
+		t.Fatalf("built-in objects: %v", err)
+	}
+	base := celestial.InitSolarSystemObjects()
+	_, err := loadObjectsFile(writeObjectsFile(t, "reserved.json",
+		`[{"Name": "Asteroids", "Type": "spacecraft", "ParentName": "Sun", "Radius": 0.01, "A": 2.9}]`), base)
+	if err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Errorf("expected a reserved-name error, got %v", err)
+	}
+}
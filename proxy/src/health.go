@@ -0,0 +1,163 @@
+// health.go - liveness (/healthz) and readiness (/readyz) probes.
+//
+// Both are routed ahead of host parsing, crawler blocking and latency, and
+// are cheap enough for a 5-second probe interval: the only I/O is a SOCKS
+// greeting round trip over loopback.
+//
+//	/healthz  the process is up: template parsed, objects loaded
+//	/readyz   the pipeline works: distance cache populated, Earth present,
+//	          a body far enough to proxy, and the SOCKS listener answering a
+//	          greeting within socksSelfTestTimeout. The distance_cache check's
+//	          detail says whether the table is fresh or a stale one from
+//	          -cache-file (distance_warm.go).
+//
+// A failing probe returns 503 and the failing checks in "failing".
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// minReadyDistanceEntries is the distance-cache size below which the
+	// object set is considered broken (the built-in list has ~50 bodies).
+	minReadyDistanceEntries = 20
+
+	// socksSelfTestTimeout bounds the loopback SOCKS greeting in /readyz.
+	socksSelfTestTimeout = time.Second
+)
+
+// healthCheck is one named probe result.
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func newHealthCheck(name string, err error) healthCheck {
+	c := healthCheck{Name: name, OK: err == nil}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// livenessChecks reports whether the process is fit to serve at all.
+func (s *Server) livenessChecks() []healthCheck {
+	var tmplErr, objErr error
+	if s.httpEnabled && infoTemplate == nil {
+		tmplErr = errors.New("info page template not parsed")
+	}
+	if len(getCelestialObjects()) == 0 {
+		objErr = errors.New("celestial object list is empty")
+	}
+	return []healthCheck{
+		newHealthCheck("template", tmplErr),
+		newHealthCheck("objects", objErr),
+	}
+}
+
+// readinessChecks exercises the pieces a proxied request depends on.
+func (s *Server) readinessChecks() []healthCheck {
+	checks := s.livenessChecks()
+
+	var earthErr error
+	if _, ok := findObjectByName(getCelestialObjects(), "Earth"); !ok {
+		earthErr = errors.New("Earth object missing")
+	}
+	checks = append(checks, newHealthCheck("earth", earthErr))
+
+	entries := currentDistanceEntries()
+	var cacheErr, latencyErr error
+	if len(entries) <= minReadyDistanceEntries {
+		cacheErr = fmt.Errorf("distance cache has %d entries, want more than %d", len(entries), minReadyDistanceEntries)
+	}
+	// The same floor the SOCKS path enforces (1s in production).
+	latencyErr = fmt.Errorf("no body has at least %v of latency", s.security.minLatency)
+	for _, e := range entries {
+		if CalculateLatency(e.Distance) >= s.security.minLatency {
+			latencyErr = nil
+			break
+		}
+	}
+	cacheCheck := newHealthCheck("distance_cache", cacheErr)
+	cacheCheck.Detail = distanceCacheState()
+	checks = append(checks, cacheCheck, newHealthCheck("latency", latencyErr))
+
+	if s.socksEnabled {
+		checks = append(checks, newHealthCheck("socks", s.socksSelfTest()))
+	}
+	return checks
+}
+
+// socksSelfTest dials the SOCKS listener over loopback and completes a
+// greeting, proving the accept loop, admission checks and handler all run.
+func (s *Server) socksSelfTest() error {
+	if s.socksListener == nil {
+		return errors.New("SOCKS listener not started")
+	}
+	addr, ok := s.socksListener.Addr().(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("unexpected SOCKS listener address %v", s.socksListener.Addr())
+	}
+	host := "127.0.0.1"
+	if !addr.IP.IsUnspecified() && addr.IP != nil {
+		host = addr.IP.String()
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, fmt.Sprint(addr.Port)), socksSelfTestTimeout)
+	if err != nil {
+		return fmt.Errorf("SOCKS listener not accepting: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socksSelfTestTimeout))
+
+	greeting := []byte{SOCKS5_VERSION, 1, SOCKS5_NO_AUTH}
+	if s.proxyProtocol {
+		// The listener requires a PROXY header; UNKNOWN keeps the real peer.
+		greeting = append([]byte("PROXY UNKNOWN\r\n"), greeting...)
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return fmt.Errorf("SOCKS greeting: %v", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("SOCKS greeting: %v", err)
+	}
+	if reply[0] != SOCKS5_VERSION || reply[1] != SOCKS5_NO_AUTH {
+		return fmt.Errorf("SOCKS greeting: unexpected reply %x", reply)
+	}
+	return nil
+}
+
+// handleHealth serves /healthz and /readyz.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	checks := s.livenessChecks()
+	if r.URL.Path == "/readyz" {
+		checks = s.readinessChecks()
+	}
+	failing := []healthCheck{}
+	for _, c := range checks {
+		if !c.OK {
+			failing = append(failing, c)
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if len(failing) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status":  "unavailable",
+			"failing": failing,
+			"checks":  checks,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"checks": checks,
+	})
+}
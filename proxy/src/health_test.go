@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// newHealthTestServer returns a server with a parsed template, the built-in
+// objects and a live SOCKS accept loop, i.e. one that should be ready.
+func newHealthTestServer(t *testing.T) *Server {
+	t.Helper()
+	original := getCelestialObjects()
+	t.Cleanup(func() {
+		setCelestialObjects(original)
+		invalidateDistanceCache()
+	})
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+
+	origTemplate := infoTemplate
+	infoTemplate = template.Must(template.New("info").Parse("{{.}}"))
+	t.Cleanup(func() { infoTemplate = origTemplate })
+
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(),
+		httpEnabled: true, socksEnabled: true}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s.socksListener = ln
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveSOCKSConn(conn)
+		}
+	}()
+	return s
+}
+
+// probe requests path and returns the status and the names of failing checks.
+func probe(t *testing.T, s *Server, path string) (int, map[string]bool) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://mars.latency.space"+path, nil))
+	loadOpenAPI(t).checkResponse(t, "GET", path, rec)
+	var out struct {
+		Status  string
+		Failing []healthCheck
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("%s: %v (%s)", path, err, rec.Body.String())
+	}
+	failing := make(map[string]bool)
+	for _, c := range out.Failing {
+		failing[c.Name] = true
+	}
+	return rec.Code, failing
+}
+
+func TestHealthAndReadiness(t *testing.T) {
+	s := newHealthTestServer(t)
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if code, failing := probe(t, s, path); code != http.StatusOK {
+			t.Errorf("%s: %d, failing %v", path, code, failing)
+		}
+	}
+}
+
+// TestReadinessFailureModes breaks one dependency at a time and checks the
+// matching check flips.
+func TestReadinessFailureModes(t *testing.T) {
+	cases := []struct {
+		name     string
+		breakIt  func(s *Server)
+		failing  []string
+		liveness bool // /healthz fails too
+	}{
+		{"empty objects", func(s *Server) {
+			setCelestialObjects(nil)
+			invalidateDistanceCache()
+		}, []string{"objects", "earth", "distance_cache", "latency"}, true},
+		{"missing Earth", func(s *Server) {
+			var objs []CelestialObject
+			for _, o := range getCelestialObjects() {
+				if o.Name != "Earth" {
+					objs = append(objs, o)
+				}
+			}
+			setCelestialObjects(objs)
+			invalidateDistanceCache()
+		}, []string{"earth", "distance_cache", "latency"}, false},
+		{"closed SOCKS listener", func(s *Server) {
+			s.socksListener.Close()
+		}, []string{"socks"}, false},
+		{"template not parsed", func(s *Server) {
+			infoTemplate = nil
+		}, []string{"template"}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newHealthTestServer(t)
+			tc.breakIt(s)
+
+			code, failing := probe(t, s, "/readyz")
+			if code != http.StatusServiceUnavailable {
+				t.Errorf("/readyz: %d, want 503", code)
+			}
+			for _, name := range tc.failing {
+				if !failing[name] {
+					t.Errorf("/readyz: check %q should fail (failing: %v)", name, failing)
+				}
+			}
+			if len(failing) != len(tc.failing) {
+				t.Errorf("/readyz: failing %v, want exactly %v", failing, tc.failing)
+			}
+
+			want := http.StatusOK
+			if tc.liveness {
+				want = http.StatusServiceUnavailable
+			}
+			if code, failing := probe(t, s, "/healthz"); code != want {
+				t.Errorf("/healthz: %d, want %d (failing %v)", code, want, failing)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// httptimeouts.go - deadlines on the HTTP and HTTPS listeners.
+//
+// A client must send its request headers within the header timeout
+// (-http-header-timeout, 10s by default) and in at most httpMaxHeaderBytes,
+// so a slowloris client dribbling a byte a second is dropped after a few
+// seconds instead of holding its socket indefinitely. Reading the rest of the
+// request and writing the response then get httpRequestTimeout, plenty for
+// the pages and the API, most of which answer without simulated latency.
+//
+// A request that really must stay open longer says so once routing has
+// decided it: /api/status-stream and the demo drips call holdOpen, which
+// lifts both deadlines for the life of the response, and /dtn/send calls
+// holdFor to push them out by the uplink light time its 100 Continue waits.
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultHTTPHeaderTimeout is the default -http-header-timeout.
+	defaultHTTPHeaderTimeout = 10 * time.Second
+	// httpRequestTimeout bounds reading a request and writing its response.
+	httpRequestTimeout = 30 * time.Second
+	// httpIdleTimeout is how long a keep-alive connection may sit between
+	// requests.
+	httpIdleTimeout = 2 * time.Minute
+	// httpMaxHeaderBytes caps the request line and headers.
+	httpMaxHeaderBytes = 64 << 10
+)
+
+// newHTTPServer returns an HTTP server for h with the listener deadlines.
+// Its request timeout is s.httpRequestTimeout where set (tests shorten it),
+// else httpRequestTimeout.
+func (s *Server) newHTTPServer(h http.Handler) *http.Server {
+	requestTimeout := s.requestTimeout()
+	headerTimeout := s.httpHeaderTimeout
+	if headerTimeout == 0 || headerTimeout > requestTimeout {
+		headerTimeout = requestTimeout
+	}
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: headerTimeout,
+		ReadTimeout:       requestTimeout,
+		WriteTimeout:      requestTimeout,
+		IdleTimeout:       httpIdleTimeout,
+		MaxHeaderBytes:    httpMaxHeaderBytes,
+	}
+}
+
+// requestTimeout is s.httpRequestTimeout, or httpRequestTimeout when unset.
+func (s *Server) requestTimeout() time.Duration {
+	if s.httpRequestTimeout == 0 {
+		return httpRequestTimeout
+	}
+	return s.httpRequestTimeout
+}
+
+// holdFor gives the request served by w a fresh request timeout plus d to
+// finish, for a handler about to spend d of simulated latency.
+func (s *Server) holdFor(w http.ResponseWriter, d time.Duration) {
+	deadline := time.Now().Add(s.requestTimeout() + d)
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		debugf("holdFor: read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		debugf("holdFor: write deadline: %v", err)
+	}
+}
+
+// holdOpen lifts the read and write deadlines of the connection serving w,
+// for a request routing has decided is long-lived. The connection still
+// closes when the client goes away.
+func holdOpen(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		debugf("holdOpen: read deadline: %v", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		debugf("holdOpen: write deadline: %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// startTimeoutHTTP serves s.handleHTTP on a loopback listener with the
+// listener deadlines, returning its address.
+func startTimeoutHTTP(t *testing.T, s *Server) string {
+	t.Helper()
+	ln, err := s.listenHTTP("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := s.newHTTPServer(http.HandlerFunc(s.handleHTTP))
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// TestSlowlorisReaped opens connections that send their headers a byte at a
+// time and checks each is dropped at the header timeout, while a status
+// stream, which routing holds open, outlives the request timeout.
+func TestSlowlorisReaped(t *testing.T) {
+	const (
+		headerTimeout  = 300 * time.Millisecond
+		requestTimeout = 600 * time.Millisecond
+	)
+	withObjects(t, celestial.InitSolarSystemObjects())
+	shortenStatusStream(t)
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(),
+		statusStream: NewStatusStream(4), httpHeaderTimeout: headerTimeout, httpRequestTimeout: requestTimeout}
+	addr := startTimeoutHTTP(t, s)
+	defer s.statusStream.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			start := time.Now()
+			// A byte every 100ms until the server hangs up.
+			header := "GET /api/bodies HTTP/1.1\r\nHost: latency.space\r\nX-Padding: " + strings.Repeat("a", 100)
+			for _, b := range []byte(header) {
+				if _, err := conn.Write([]byte{b}); err != nil {
+					break
+				}
+				conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+				if _, err := conn.Read(make([]byte, 1)); !isTimeout(err) {
+					break
+				}
+			}
+			if elapsed := time.Since(start); elapsed > headerTimeout+time.Second {
+				t.Errorf("slow client held its connection for %v, header timeout %v", elapsed, headerTimeout)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Ordinary requests are unaffected.
+	resp, err := http.Get("http://" + addr + "/api/state?body=mars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/api/state: %d", resp.StatusCode)
+	}
+
+	// The stream is still open three request timeouts later.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /api/status-stream HTTP/1.1\r\nHost: latency.space\r\n\r\n"))
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	stream, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil || stream.StatusCode != http.StatusOK {
+		t.Fatalf("status stream: %v, %v", stream, err)
+	}
+	// Heartbeats keep arriving after the request timeout has passed.
+	body := bufio.NewReader(stream.Body)
+	late := time.Now().Add(requestTimeout + 100*time.Millisecond)
+	conn.SetReadDeadline(time.Now().Add(3 * requestTimeout))
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			t.Fatalf("status stream ended after the request timeout: %v", err)
+		}
+		if strings.HasPrefix(line, ": heartbeat") && time.Now().After(late) {
+			break
+		}
+	}
+}
+
+// TestHTTPHeaderCap checks oversized request headers are refused.
+func TestHTTPHeaderCap(t *testing.T) {
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	addr := startTimeoutHTTP(t, s)
+	req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/healthz", nil)
+	req.Header.Set("X-Padding", strings.Repeat("a", 2*httpMaxHeaderBytes))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("oversized headers: %d, want 431", resp.StatusCode)
+	}
+}
+
+// TestExpectContinueOutlastsRequestTimeout sends /dtn/send with Expect:
+// 100-continue through the real listener, with an uplink longer than the
+// request timeout, and checks the interim 100 and the final 202 still arrive.
+func TestExpectContinueOutlastsRequestTimeout(t *testing.T) {
+	const (
+		requestTimeout = 300 * time.Millisecond
+		uplink         = 2 * requestTimeout
+	)
+	withObjects(t, celestial.InitSolarSystemObjects())
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer dest.Close()
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(uplink)
+	s.httpRequestTimeout = requestTimeout
+	addr := startTimeoutHTTP(t, s)
+
+	body := fmt.Sprintf(`{"url":%q}`, dest.URL)
+	conn, br := rawExpectRequest(t, addr, "100-continue", len(body))
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if code := readStatus(t, br); code != http.StatusContinue {
+		t.Fatalf("expected interim 100, got %d", code)
+	}
+	if _, err := conn.Write([]byte(body)); err != nil {
+		t.Fatalf("send body: %v", err)
+	}
+	if code := readStatus(t, br); code != http.StatusAccepted {
+		t.Fatalf("expected final 202 after the body, got %d", code)
+	}
+}
@@ -0,0 +1,226 @@
+// i18n.go - message catalogs for the info page, help text and errors.
+//
+// Each locale is a flat JSON map from key to a fmt format string, embedded
+// from locales/<tag>.json. Lookups fall back to English, then to the key
+// itself, so a catalog can be partial. The locale for a request is ?lang=
+// if it names a catalog, otherwise the best Accept-Language match, otherwise
+// English. Only the primary subtag is matched: es-MX is served es.
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultLang = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogs maps a language tag to its messages.
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]string {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	out := make(map[string]map[string]string, len(entries))
+	for _, e := range entries {
+		data, err := localeFiles.ReadFile("locales/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		var msgs map[string]string
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			panic(fmt.Sprintf("locales/%s: %v", e.Name(), err))
+		}
+		out[strings.TrimSuffix(e.Name(), path.Ext(e.Name()))] = msgs
+	}
+	return out
+}
+
+// Locale formats messages, numbers and durations in one language. The zero
+// value is English.
+type Locale struct {
+	tag string
+}
+
+// lookupLocale returns the catalog for tag, if there is one.
+func lookupLocale(tag string) (Locale, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if primary, _, ok := strings.Cut(tag, "-"); ok {
+		tag = primary
+	}
+	if _, ok := catalogs[tag]; !ok {
+		return Locale{}, false
+	}
+	return Locale{tag: tag}, true
+}
+
+// localeFor picks the locale for r: ?lang=, then Accept-Language.
+func localeFor(r *http.Request) Locale {
+	if l, ok := lookupLocale(r.URL.Query().Get("lang")); ok {
+		return l
+	}
+	for _, tag := range parseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		if l, ok := lookupLocale(tag); ok {
+			return l
+		}
+	}
+	return Locale{}
+}
+
+// parseAcceptLanguage returns the language ranges in header, most preferred
+// first. Ranges with q=0 and the "*" wildcard are dropped.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var ranges []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	var tags []string
+	for _, r := range ranges {
+		tags = append(tags, r.tag)
+	}
+	return tags
+}
+
+// Tag returns the language tag, for lang= and Content-Language.
+func (l Locale) Tag() string {
+	if l.tag == "" {
+		return defaultLang
+	}
+	return l.tag
+}
+
+// message returns the format string for key.
+func (l Locale) message(key string) string {
+	if msg, ok := catalogs[l.Tag()][key]; ok {
+		return msg
+	}
+	if msg, ok := catalogs[defaultLang][key]; ok {
+		return msg
+	}
+	return key
+}
+
+// T returns the message for key formatted with args.
+func (l Locale) T(key string, args ...any) string {
+	if len(args) == 0 {
+		return l.message(key)
+	}
+	return fmt.Sprintf(l.message(key), args...)
+}
+
+// HTML is T for messages that wrap markup: the catalog text is trusted,
+// args are escaped unless they are already template.HTML (see Code).
+func (l Locale) HTML(key string, args ...any) template.HTML {
+	escaped := make([]any, len(args))
+	for i, a := range args {
+		switch a := a.(type) {
+		case template.HTML:
+			escaped[i] = a
+		case string:
+			escaped[i] = template.HTMLEscapeString(a)
+		default:
+			escaped[i] = template.HTMLEscapeString(fmt.Sprint(a))
+		}
+	}
+	return template.HTML(fmt.Sprintf(l.message(key), escaped...))
+}
+
+// Code wraps v in <code> for use as an HTML argument.
+func (l Locale) Code(v any) template.HTML {
+	return template.HTML("<code>" + template.HTMLEscapeString(fmt.Sprint(v)) + "</code>")
+}
+
+// Number formats f with the given number of decimals and the locale's
+// decimal separator.
+func (l Locale) Number(f float64, decimals int) string {
+	s := strconv.FormatFloat(f, 'f', decimals, 64)
+	if sep := l.message("num.decimal"); sep != "." {
+		s = strings.Replace(s, ".", sep, 1)
+	}
+	return s
+}
+
+// unit returns the singular or plural name of unit for n.
+func (l Locale) unit(unit string, n int64) string {
+	if n == 1 {
+		return l.message("unit." + unit + ".one")
+	}
+	return l.message("unit." + unit + ".other")
+}
+
+// Duration reads d in words: hundredths of a second below a minute, else
+// its two largest units ("12 minutes 5 seconds", "1 day 3 hours").
+func (l Locale) Duration(d time.Duration) string {
+	if d < time.Minute {
+		return l.Number(d.Round(10*time.Millisecond).Seconds(), 2) + " " + l.message("unit.second.other")
+	}
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+		{"second", time.Second},
+	}
+	d = d.Round(time.Second)
+	for i, u := range units[:len(units)-1] {
+		n := int64(d / u.size)
+		if n == 0 {
+			continue
+		}
+		out := fmt.Sprintf("%d %s", n, l.unit(u.name, n))
+		next := units[i+1]
+		if m := int64((d - time.Duration(n)*u.size) / next.size); m > 0 {
+			out += fmt.Sprintf(" %d %s", m, l.unit(next.name, m))
+		}
+		return out
+	}
+	return ""
+}
+
+// setContentLanguage labels the response with l and marks it as varying by
+// Accept-Language.
+func setContentLanguage(w http.ResponseWriter, l Locale) {
+	w.Header().Set("Content-Language", l.Tag())
+	w.Header().Add("Vary", "Accept-Language")
+}
+
+// writeJSONError writes {"error": <message>} in r's locale.
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, key string, args ...any) {
+	l := localeFor(r)
+	setContentLanguage(w, l)
+	writeJSON(w, status, map[string]string{"error": l.T(key, args...)})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// parseEnglishDuration reads Locale{}.Duration output back, e.g.
+// "1 hour 6 minutes" or "4.20 seconds".
+func parseEnglishDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"day": 24 * time.Hour, "hour": time.Hour, "minute": time.Minute, "second": time.Second,
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return 0, fmt.Errorf("malformed duration %q", s)
+	}
+	var d time.Duration
+	for i := 0; i < len(fields); i += 2 {
+		n, err := strconv.ParseFloat(fields[i], 64)
+		unit, ok := units[strings.TrimSuffix(fields[i+1], "s")]
+		if err != nil || !ok {
+			return 0, fmt.Errorf("malformed duration %q", s)
+		}
+		d += time.Duration(n * float64(unit))
+	}
+	return d, nil
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", nil},
+		{"fr", []string{"fr"}},
+		{"de-CH, fr;q=0.8, en;q=0.5", []string{"de-CH", "fr", "en"}},
+		{"en;q=0.3, es-MX;q=0.9, *;q=0.1", []string{"es-MX", "en"}},
+		{"ja, de;q=0", []string{"ja"}},
+	}
+	for _, tt := range tests {
+		if got := parseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAcceptLanguage(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestLocaleFor(t *testing.T) {
+	tests := []struct {
+		url, accept, want string
+	}{
+		{"/", "", "en"},
+		{"/", "es-MX,es;q=0.9", "es"},
+		{"/", "ja, de;q=0.7, fr;q=0.8", "fr"},
+		{"/", "ja", "en"},
+		{"/?lang=de", "fr", "de"},
+		{"/?lang=xx", "fr", "fr"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		r.Header.Set("Accept-Language", tt.accept)
+		if got := localeFor(r).Tag(); got != tt.want {
+			t.Errorf("localeFor(%s, %q) = %s, want %s", tt.url, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestLocaleFormatting(t *testing.T) {
+	en, _ := lookupLocale("en")
+	de, _ := lookupLocale("de")
+	tests := []struct {
+		l    Locale
+		d    time.Duration
+		want string
+	}{
+		{en, 1280 * time.Millisecond, "1.28 seconds"},
+		{en, time.Second, "1.00 seconds"},
+		{en, 12*time.Minute + 5*time.Second, "12 minutes 5 seconds"},
+		{en, time.Hour + 30*time.Second, "1 hour"},
+		{en, 49*time.Hour + 3*time.Minute, "2 days 1 hour"},
+		{de, 1280 * time.Millisecond, "1,28 Sekunden"},
+		{de, 61 * time.Minute, "1 Stunde 1 Minute"},
+	}
+	for _, tt := range tests {
+		if got := tt.l.Duration(tt.d); got != tt.want {
+			t.Errorf("%s Duration(%v) = %q, want %q", tt.l.Tag(), tt.d, got, tt.want)
+		}
+	}
+	if got := de.T("no.such.key"); got != "no.such.key" {
+		t.Errorf("missing key = %q", got)
+	}
+	if got := de.T("help.debug_recent"); got != en.T("help.debug_recent") {
+		t.Errorf("key missing from de should fall back to English, got %q", got)
+	}
+}
+
+// TestCatalogsComplete checks every catalog only has keys English has and
+// formats them with the same verbs.
+func TestCatalogsComplete(t *testing.T) {
+	verbs := regexp.MustCompile(`%[a-z]`)
+	for tag, msgs := range catalogs {
+		for key, msg := range msgs {
+			en, ok := catalogs[defaultLang][key]
+			if !ok {
+				t.Errorf("%s: key %q is not in the English catalog", tag, key)
+				continue
+			}
+			if got, want := verbs.FindAllString(msg, -1), verbs.FindAllString(en, -1); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %q uses %v, English uses %v", tag, key, got, want)
+			}
+		}
+	}
+}
+
+// TestInfoPageLocales renders the Mars page in each shipped language.
+func TestInfoPageLocales(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	calculateDistancesFromEarth(getCelestialObjects(), time.Now())
+
+	origTemplate := infoTemplate
+	defer func() { infoTemplate = origTemplate }()
+	infoTemplate = template.Must(template.ParseFiles("templates/info_page.html"))
+
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	latency := CalculateLatency(mustDistance(t, "Mars"))
+	tests := []struct {
+		accept, lang string
+		want         []string
+	}{
+		{"es-ES,es;q=0.9", "es", []string{"<h1>Proxy de Mars</h1>", "Distancia desde la Tierra:", "Negociación TCP en tres pasos: <strong>", "minutos"}},
+		{"fr-FR", "fr", []string{"<h1>Proxy Mars</h1>", "Distance depuis la Terre :", "Poignée de main TCP en trois temps: <strong>", "Lunes"}},
+		{"de", "de", []string{"<h1>Mars-Proxy</h1>", "Entfernung von der Erde:", "TCP-Drei-Wege-Handshake: <strong>", "Monde"}},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", tt.accept)
+		rec := httptest.NewRecorder()
+		s.displayCelestialInfo(rec, r, currentSnapshot(distanceClock()), "Mars")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", tt.lang, rec.Code)
+		}
+		if got := rec.Header().Get("Content-Language"); got != tt.lang {
+			t.Errorf("%s: Content-Language %q", tt.lang, got)
+		}
+		page := rec.Body.String()
+		want := append(tt.want,
+			fmt.Sprintf(`<html lang="%s">`, tt.lang),
+			"<code>mars.latency.space</code>",
+			// The numbers are the same as in English, with a decimal comma.
+			strings.Replace(strconv.FormatFloat(float64(int(latency.Seconds()*100))/100, 'f', 2, 64), ".", ",", 1),
+			"curl --socks5-hostname mars.latency.space:1080 https://example.com",
+		)
+		for _, w := range want {
+			if !strings.Contains(page, w) {
+				t.Errorf("%s: page missing %q", tt.lang, w)
+			}
+		}
+		if strings.Contains(page, "Distance from Earth") || strings.Contains(page, "Protocol Impact") {
+			t.Errorf("%s: page still has English labels", tt.lang)
+		}
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	r := httptest.NewRequest("GET", "/api/distance?from=mars&lang=fr", nil)
+	rec := httptest.NewRecorder()
+	s.handleDistance(rec, r)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "obligatoires") {
+		t.Errorf("got %d %s, want a French 400", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/_debug/help", nil)
+	r.Header.Set("Accept-Language", "es")
+	s.printHelp(rec, localeFor(r))
+	if help := rec.Body.String(); !strings.Contains(help, "Uso del proxy (SOCKS5):\n-----------------------\n") {
+		t.Errorf("Spanish help:\n%s", help)
+	}
+}
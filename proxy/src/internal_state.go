@@ -0,0 +1,158 @@
+// internal_state.go - impossible states reported, not papered over.
+//
+// The objects are installed once by NewServer, which refuses an empty list
+// or one without Earth, and only ever replaced whole by an -objects-file
+// reload. So a request should never find them empty, Earth missing or the
+// distance table empty. If one does, something is broken that retrying the
+// initialization per request would only hide. Handlers take their
+// SystemSnapshot from a SnapshotProvider instead of the globals, and a
+// provider that can't give a sound one fails the request:
+//
+//   - HTTP answers 503 with code INTERNAL_STATE and the reason;
+//   - SOCKS replies general failure;
+//   - proxy_internal_state_errors_total counts it, by reason;
+//   - it is logged at most once a minute per reason.
+//
+// Like timing (clock.go), a nil provider means the production one, so a
+// Server or SOCKSHandler built without one reads the live objects and
+// distance cache.
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// internalStateCode is the structured error code for an impossible state.
+const internalStateCode = "INTERNAL_STATE"
+
+// Reasons for an InternalStateError. They are sent to clients and used as
+// metric labels, so keep them stable.
+const (
+	stateNoObjects   = "no_objects"   // the object list is empty
+	stateNoEarth     = "no_earth"     // Earth is not in it
+	stateNoDistances = "no_distances" // the distance table came back empty
+)
+
+// internalStateLogInterval is the least time between log lines for one
+// reason.
+const internalStateLogInterval = time.Minute
+
+// InternalStateError is a state the server can't get into by design.
+type InternalStateError struct {
+	Reason string
+}
+
+func (e *InternalStateError) Error() string {
+	switch e.Reason {
+	case stateNoObjects:
+		return "internal state: the celestial object list is empty"
+	case stateNoEarth:
+		return "internal state: Earth is missing from the celestial objects"
+	case stateNoDistances:
+		return "internal state: the distance table is empty"
+	}
+	return "internal state: " + e.Reason
+}
+
+// SnapshotProvider gives handlers their view of the solar system.
+type SnapshotProvider interface {
+	// Snapshot returns the objects and distances for now, or an
+	// *InternalStateError if they aren't sound.
+	Snapshot(now time.Time) (*SystemSnapshot, error)
+}
+
+// liveSnapshots is the production provider: the installed objects and the
+// shared distance cache.
+type liveSnapshots struct{}
+
+func (liveSnapshots) Snapshot(now time.Time) (*SystemSnapshot, error) {
+	if err := checkObjects(getCelestialObjects()); err != nil {
+		return nil, err
+	}
+	snap := currentSnapshot(now)
+	if len(snap.Entries) == 0 {
+		return nil, &InternalStateError{stateNoDistances}
+	}
+	return snap, nil
+}
+
+// checkObjects reports whether objects can be served at all: not empty, and
+// with Earth, which every distance is measured from.
+func checkObjects(objects []celestial.CelestialObject) error {
+	if len(objects) == 0 {
+		return &InternalStateError{stateNoObjects}
+	}
+	if _, ok := findObjectByName(objects, "Earth"); !ok {
+		return &InternalStateError{stateNoEarth}
+	}
+	return nil
+}
+
+// takeSnapshot asks p (nil for the live provider) for a snapshot, and
+// counts and logs a failure.
+func takeSnapshot(p SnapshotProvider, metrics Metrics) (*SystemSnapshot, error) {
+	if p == nil {
+		p = liveSnapshots{}
+	}
+	snap, err := p.Snapshot(distanceClock())
+	if err != nil {
+		reason := "unknown"
+		var ise *InternalStateError
+		if errors.As(err, &ise) {
+			reason = ise.Reason
+		}
+		metrics.RecordInternalState(reason)
+		internalStateLog.log(reason, err)
+	}
+	return snap, err
+}
+
+// internalStateLog keeps one impossible state from flooding the log.
+var internalStateLog = &throttledLog{every: internalStateLogInterval, last: make(map[string]time.Time)}
+
+// throttledLog logs at most one line per key per interval.
+type throttledLog struct {
+	every time.Duration
+	mu    sync.Mutex
+	last  map[string]time.Time
+}
+
+func (l *throttledLog) log(key string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if t, ok := l.last[key]; ok && now.Sub(t) < l.every {
+		return
+	}
+	l.last[key] = now
+	log.Printf("Error: %v (logged at most once per %v)", err, l.every)
+}
+
+// requestSnapshot is takeSnapshot for an HTTP handler: on failure it
+// answers 503 and returns false.
+func (s *Server) requestSnapshot(w http.ResponseWriter, r *http.Request) (*SystemSnapshot, bool) {
+	snap, err := takeSnapshot(s.snapshots, orNop(s.metrics))
+	if err != nil {
+		writeInternalState(w, r, err)
+		return nil, false
+	}
+	return snap, true
+}
+
+// writeInternalState answers a request that ran into err with a 503.
+func writeInternalState(w http.ResponseWriter, r *http.Request, err error) {
+	l := localeFor(r)
+	setContentLanguage(w, l)
+	out := map[string]string{"error": l.T("error.internal_state"), "code": internalStateCode, "detail": err.Error()}
+	var ise *InternalStateError
+	if errors.As(err, &ise) {
+		out["reason"] = ise.Reason
+	}
+	writeJSON(w, http.StatusServiceUnavailable, out)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// brokenSnapshots is a provider stuck in one impossible state.
+type brokenSnapshots struct{ reason string }
+
+func (b brokenSnapshots) Snapshot(time.Time) (*SystemSnapshot, error) {
+	return nil, &InternalStateError{b.reason}
+}
+
+// TestInternalStateHTTP checks a broken provider fails requests with a 503
+// naming the state, rather than the handler rebuilding the objects.
+func TestInternalStateHTTP(t *testing.T) {
+	for _, tc := range []struct{ url, reason string }{
+		{"http://latency.space/api/status-data", stateNoDistances},
+		{"http://mars.latency.space/", stateNoEarth},
+		{"http://latency.space/api/bodies", stateNoObjects},
+	} {
+		s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), snapshots: brokenSnapshots{tc.reason}}
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, tc.url, nil))
+		var out map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil || rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: %d %s", tc.url, rec.Code, rec.Body)
+			continue
+		}
+		if out["code"] != internalStateCode || out["reason"] != tc.reason || out["detail"] == "" {
+			t.Errorf("%s: %v", tc.url, out)
+		}
+		if n := recorded(t, s.metrics).Count(metricInternalState, tc.reason); n != 1 {
+			t.Errorf("%s: %v internal state errors counted, want 1", tc.url, n)
+		}
+	}
+}
+
+// TestInternalStateSOCKS checks a CONNECT with a broken provider gets a
+// general failure before anything is dialled.
+func TestInternalStateSOCKS(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	metrics := NewRecordingMetrics()
+	h := NewSOCKSHandler(server, newTestSecurity(), metrics, "Mars", brokenSnapshots{stateNoEarth})
+	done := make(chan struct{})
+	go func() {
+		h.Handle()
+		close(done)
+	}()
+
+	if _, err := client.Write([]byte{SOCKS5_VERSION, 1, SOCKS5_NO_AUTH}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte{SOCKS5_VERSION, SOCKS5_CMD_CONNECT, 0x00, SOCKS5_ADDR_IPV4, 127, 0, 0, 1, 0x00, 0x50}); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[1] != SOCKS5_REP_GENERAL_FAILURE {
+		t.Errorf("reply %#x, want general failure", reply[1])
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not finish")
+	}
+	if n := recorded(t, metrics).Count(metricInternalState, stateNoEarth); n != 1 {
+		t.Errorf("%v internal state errors counted, want 1", n)
+	}
+}
+
+// TestNewServerObjects checks NewServer refuses objects it couldn't serve,
+// before installing them. (A successful NewServer registers the Prometheus
+// collectors, so TestDisplayCelestialInfoTemplate covers that case.)
+func TestNewServerObjects(t *testing.T) {
+	for _, tc := range []struct {
+		objects []CelestialObject
+		reason  string
+	}{
+		{nil, stateNoObjects},
+		{[]CelestialObject{{Name: "Mars", Type: "planet"}}, stateNoEarth},
+	} {
+		_, err := NewServer(80, false, true, true, "", tc.objects)
+		var ise *InternalStateError
+		if !errors.As(err, &ise) || ise.Reason != tc.reason {
+			t.Errorf("%v: %v, want %s", tc.objects, err, tc.reason)
+		}
+	}
+	if err := checkObjects(getCelestialObjects()); err != nil {
+		t.Errorf("a refused NewServer replaced the objects: %v", err)
+	}
+}
+
+// TestThrottledLog checks a repeated state is logged once per interval.
+func TestThrottledLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	l := &throttledLog{every: time.Hour, last: make(map[string]time.Time)}
+	for i := 0; i < 3; i++ {
+		l.log(stateNoEarth, &InternalStateError{stateNoEarth})
+		l.log(stateNoObjects, &InternalStateError{stateNoObjects})
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Errorf("%d lines logged, want one per reason:\n%s", n, &buf)
+	}
+}
+
+// TestNoLazyObjectInit checks the objects are installed only where that is
+// the design (main, NewServer and an -objects-file reload): nothing at
+// package init, and no handler filling them in when it finds them missing.
+func TestNoLazyObjectInit(t *testing.T) {
+	allowed := map[string]bool{"main": true, "NewServer": true, "reloadObjects": true}
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi fs.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.go") }, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Body == nil {
+					continue
+				}
+				ast.Inspect(fn.Body, func(n ast.Node) bool {
+					call, ok := n.(*ast.CallExpr)
+					if !ok {
+						return true
+					}
+					name := ""
+					switch f := call.Fun.(type) {
+					case *ast.Ident:
+						name = f.Name
+					case *ast.SelectorExpr:
+						if x, ok := f.X.(*ast.Ident); ok && x.Name == "celestial" {
+							name = "celestial." + f.Sel.Name
+						}
+					}
+					if (name == "setCelestialObjects" || name == "celestial.InitSolarSystemObjects") && !allowed[fn.Name.Name] {
+						t.Errorf("%s: %s calls %s", fset.Position(call.Pos()), fn.Name.Name, name)
+					}
+					return true
+				})
+			}
+		}
+	}
+}
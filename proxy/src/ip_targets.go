@@ -0,0 +1,92 @@
+// ip_targets.go - IP literals as target labels.
+//
+// A DNS label can't hold the dots of an IPv4 address or the colons of an
+// IPv6 one, so an IP target is written with dashes in their place:
+//
+//	10-0-0-5-p8080.mars.latency.space     10.0.0.5 port 8080
+//	fd00-0-0-0-0-0-0-2.mars.latency.space fd00::2
+//
+// IPv4 takes four decimal octets without leading zeros; IPv6 takes all
+// eight hex groups, since "::" would leave a label starting or ending with a
+// dash. The label must be the whole target. Decoding only turns the label
+// into an address: the destination checks are the same as for any IP
+// literal, so public addresses are refused and private ones are reached
+// only with -allow-private.
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// FormatIPTarget returns the target label for ip, with a -p<port> suffix
+// when port isn't 0.
+// Example: 10-0-0-5-p8080
+func FormatIPTarget(ip net.IP, port uint16) string {
+	var label string
+	if v4 := ip.To4(); v4 != nil {
+		label = fmt.Sprintf("%d-%d-%d-%d", v4[0], v4[1], v4[2], v4[3])
+	} else {
+		v6 := ip.To16()
+		groups := make([]string, 8)
+		for i := range groups {
+			groups[i] = strconv.FormatUint(uint64(v6[2*i])<<8|uint64(v6[2*i+1]), 16)
+		}
+		label = strings.Join(groups, "-")
+	}
+	if port != 0 {
+		label += "-p" + strconv.Itoa(int(port))
+	}
+	return label
+}
+
+// parseIPTarget decodes a label written by FormatIPTarget, without its
+// port suffix. ok is false if label isn't an IP literal.
+func parseIPTarget(label string) (ip net.IP, ok bool) {
+	parts := strings.Split(strings.ToLower(label), "-")
+	switch len(parts) {
+	case 4:
+		v4 := make(net.IP, 4)
+		for i, p := range parts {
+			if p == "" || len(p) > 1 && p[0] == '0' {
+				return nil, false
+			}
+			n, err := strconv.ParseUint(p, 10, 8)
+			if err != nil {
+				return nil, false
+			}
+			v4[i] = byte(n)
+		}
+		return net.IPv4(v4[0], v4[1], v4[2], v4[3]), true
+	case 8:
+		v6 := make(net.IP, 16)
+		for i, p := range parts {
+			if p == "" || len(p) > 4 {
+				return nil, false
+			}
+			n, err := strconv.ParseUint(p, 16, 16)
+			if err != nil {
+				return nil, false
+			}
+			v6[2*i], v6[2*i+1] = byte(n>>8), byte(n)
+		}
+		return v6, true
+	}
+	return nil, false
+}
+
+// ipTargetHost returns the address a single-label target names, if it is
+// an IP literal. A label that also names a body is refused rather than
+// guessed at.
+func ipTargetHost(label string) (host string, ok bool, err error) {
+	ip, ok := parseIPTarget(label)
+	if !ok {
+		return "", false, nil
+	}
+	if obj, clash := findObjectByName(getCelestialObjects(), label); clash {
+		return "", false, fmt.Errorf("ambiguous target %s: it is both an IP address and the body %s", label, obj.Name)
+	}
+	return ip.String(), true, nil
+}
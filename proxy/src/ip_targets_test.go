@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+func TestIPTargetRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		ip    string
+		port  uint16
+		label string
+	}{
+		{"10.0.0.5", 0, "10-0-0-5"},
+		{"192.168.4.20", 8080, "192-168-4-20-p8080"},
+		{"::ffff:10.1.2.3", 0, "10-1-2-3"},
+		{"fd00::2", 0, "fd00-0-0-0-0-0-0-2"},
+		{"2001:db8::1:0:0:1", 443, "2001-db8-0-0-1-0-0-1-p443"},
+	} {
+		label := FormatIPTarget(net.ParseIP(tc.ip), tc.port)
+		if label != tc.label {
+			t.Errorf("FormatIPTarget(%s, %d) = %q, want %q", tc.ip, tc.port, label, tc.label)
+			continue
+		}
+		bare, port, _ := splitPortSuffix(label)
+		ip, ok := parseIPTarget(bare)
+		if !ok || !ip.Equal(net.ParseIP(tc.ip)) || port != tc.port {
+			t.Errorf("%q decoded to %v port %d, %v; want %s port %d", label, ip, port, ok, tc.ip, tc.port)
+		}
+	}
+
+	for _, label := range []string{
+		"example", "my-proxy", "10-0-0", "10-0-0-5-6", "10-0-0-256", "010-0-0-5",
+		"10--0-5", "fd00-0-0-0-0-0-2", "fd00-0-0-0-0-0-0-0-2", "fd00-0-0-0-0-0-0-g", "fd00-0-0-0-0-0-0-12345",
+	} {
+		if ip, ok := parseIPTarget(label); ok {
+			t.Errorf("parseIPTarget(%q) = %v, want not an IP", label, ip)
+		}
+	}
+}
+
+func TestProcessDomainNameIPTarget(t *testing.T) {
+	withObjects(t, append(celestial.InitSolarSystemObjects(),
+		celestial.CelestialObject{Name: "10-0-0-7", Type: "asteroid"}))
+
+	h := &SOCKSHandler{security: NewSecurityValidator()}
+	for _, tt := range []struct {
+		domain, host string
+		port         uint16
+		wantErr      bool
+	}{
+		{"10-0-0-5.mars.latency.space", "10.0.0.5", 0, false},
+		{"10-0-0-5-p8080.mars.latency.space", "10.0.0.5", 8080, false},
+		{"fd00-0-0-0-0-0-0-2-p443.mars.latency.space", "fd00::2", 443, false},
+		// Only a whole target is an IP literal.
+		{"host.10-0-0-5.mars.latency.space", "host.10-0-0-5", 0, false},
+		// A label that is also a body is refused, with or without a port.
+		{"10-0-0-7.mars.latency.space", "", 0, true},
+		{"10-0-0-7-p8080.mars.latency.space", "", 0, true},
+	} {
+		host, port, err := h.processDomainName(tt.domain)
+		if (err != nil) != tt.wantErr || host != tt.host || port != tt.port {
+			t.Errorf("processDomainName(%q) = %q, %d, %v; want %q, %d, error %v",
+				tt.domain, host, port, err, tt.host, tt.port, tt.wantErr)
+		}
+	}
+}
+
+// TestPrivateIPTargets checks that private addresses are refused unless
+// -allow-private is set, and that it opens nothing else.
+func TestPrivateIPTargets(t *testing.T) {
+	h := &SOCKSHandler{security: NewSecurityValidator()}
+	for _, addr := range []string{"10.0.0.5", "192.168.4.20", "fd00::2"} {
+		if allowedHost(h, addr) {
+			t.Errorf("private %s allowed by default", addr)
+		}
+	}
+
+	h.security.allowPrivate = true
+	for _, addr := range []string{"10.0.0.5", "172.16.1.1", "192.168.4.20", "fd00::2"} {
+		if !allowedHost(h, addr) {
+			t.Errorf("private %s refused with -allow-private", addr)
+		}
+	}
+	for _, addr := range []string{"127.0.0.1", "169.254.169.254", "8.8.8.8", "::1", "fe80::1"} {
+		if allowedHost(h, addr) {
+			t.Errorf("%s allowed by -allow-private", addr)
+		}
+	}
+	if err := h.security.ValidateSocksDestination("10.0.0.5", 22); err == nil {
+		t.Error("-allow-private bypassed the port allowlist")
+	}
+	if err := h.security.ValidateSocksDestination("10.0.0.5", 8080); err != nil {
+		t.Errorf("10.0.0.5:8080 with -allow-private: %v", err)
+	}
+}
+
+// privateInterfaceIP returns a private address of this host, or skips the
+// test when there is none.
+func privateInterfaceIP(t *testing.T) net.IP {
+	t.Helper()
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.IsPrivate() {
+			return n.IP
+		}
+	}
+	t.Skip("no private interface address")
+	return nil
+}
+
+// TestSOCKSConnectIPTarget proxies to an echo server on a private address
+// named by a dash-encoded label.
+func TestSOCKSConnectIPTarget(t *testing.T) {
+	ip := privateInterfaceIP(t)
+	l, err := net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	echo := l.Addr().(*net.TCPAddr)
+
+	withObjects(t, celestial.InitSolarSystemObjects())
+	security := newTestSecurity()
+	security.allowedPorts[strconv.Itoa(echo.Port)] = true
+	proxy := startTestSOCKS(t, &Server{security: security, metrics: NewRecordingMetrics(),
+		limiter: NewRateLimiter(60, 5, 3, 0), fixedCelestialBody: "Mars", timing: fixedLatency(5 * time.Millisecond)})
+	target := fmt.Sprintf("%s.mars.latency.space:9", FormatIPTarget(ip, uint16(echo.Port)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, _, err := client.Dial(ctx, proxy, target); err == nil {
+		t.Fatal("private target reached without -allow-private")
+	}
+
+	security.allowPrivate = true
+	conn, _, err := client.Dial(ctx, proxy, target)
+	if err != nil {
+		t.Fatalf("dial %s: %v", target, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo: %q, %v", buf, err)
+	}
+}
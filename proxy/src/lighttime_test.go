@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestApparentDistanceConverges checks the light-time solution is
+// self-consistent: the returned distance is the distance to where the target
+// was one light time earlier.
+func TestApparentDistanceConverges(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+	at := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, name := range []string{"Mercury", "Mars", "Jupiter", "Voyager 1", "Moon"} {
+		target, ok := findObjectByName(objects, name)
+		if !ok {
+			t.Fatalf("%s missing", name)
+		}
+		d := ApparentDistance(earth, target, objects, at)
+		lightTime := time.Duration(d / celestial.SPEED_OF_LIGHT * float64(time.Second))
+		check := GetObjectPosition(target, objects, at.Add(-lightTime)).
+			Subtract(GetObjectPosition(earth, objects, at)).Magnitude() * celestial.AU
+		if math.Abs(check-d) > lightTimeToleranceKm {
+			t.Errorf("%s: light-time solution not converged: %.3f vs %.3f km", name, d, check)
+		}
+	}
+}
+
+// TestApparentDistanceDirection checks the correction's sign and size. Only
+// the target's motion during the light time matters, so "receding" means
+// moving away from the observer's position at the instant of reception: the
+// light left a receding target when it was closer, so the apparent distance
+// is shorter than the geometric one, and longer for an approaching target.
+func TestApparentDistanceDirection(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+
+	cases := []struct {
+		name     string
+		target   string
+		at       time.Time
+		receding bool
+	}{
+		{"Voyager 1 receding", "Voyager 1", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), true},
+		// Around opposition Earth overtakes Mars: Mars closes on Earth's
+		// position in the weeks after and falls away from it before.
+		{"Mars approaching", "Mars", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), false},
+		{"Mars receding", "Mars", time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC), true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			target, _ := findObjectByName(objects, tc.target)
+			observer := GetObjectPosition(earth, objects, tc.at)
+			rangeAt := func(at time.Time) float64 {
+				return GetObjectPosition(target, objects, at).Subtract(observer).Magnitude() * celestial.AU
+			}
+			rate := (rangeAt(tc.at.Add(time.Minute)) - rangeAt(tc.at.Add(-time.Minute))) / 120 // km/s
+			if (rate > 0) != tc.receding {
+				t.Fatalf("test premise wrong: target range rate %.2f km/s", rate)
+			}
+
+			geometric := CalculateDistance(earth, target, objects, tc.at)
+			apparent := ApparentDistance(earth, target, objects, tc.at)
+			if tc.receding && apparent >= geometric {
+				t.Errorf("receding: apparent %.0f km should be less than geometric %.0f km", apparent, geometric)
+			}
+			if !tc.receding && apparent <= geometric {
+				t.Errorf("approaching: apparent %.0f km should exceed geometric %.0f km", apparent, geometric)
+			}
+
+			// To first order the shift is the range rate times the light time.
+			shift := geometric - apparent
+			expected := rate * geometric / celestial.SPEED_OF_LIGHT
+			if math.Abs(shift-expected) > 0.05*math.Abs(expected) {
+				t.Errorf("correction %.0f km, expected about %.0f km (%.2f km/s over the light time)", shift, expected, rate)
+			}
+		})
+	}
+}
+
+// TestStatusDataExposesBothDistances checks /api/status-data reports the
+// apparent distance (used for latency) alongside the geometric one.
+func TestStatusDataExposesBothDistances(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/status-data", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var out struct {
+		Objects map[string][]map[string]interface{} `json:"objects"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	// Heliocentric spacecraft have elements in AU; a stray km->AU conversion
+	// would put them next to the Sun (and Earth). Only spacecraft with
+	// parent-relative (km) elements may legitimately be that close.
+	const minHeliocentricKm = 0.1 * celestial.AU
+	for _, e := range out.Objects["spacecraft"] {
+		name, _ := e["name"].(string)
+		obj, ok := findObjectByName(getCelestialObjects(), name)
+		if !ok || elementsInKm(obj) {
+			continue
+		}
+		if d, _ := e["distance_km"].(float64); d < minHeliocentricKm {
+			t.Errorf("%s: %.0f km from Earth, below 0.1 AU for a heliocentric spacecraft", name, d)
+		}
+	}
+
+	for _, e := range out.Objects["planets"] {
+		if e["name"] != "Mars" {
+			continue
+		}
+		apparent, _ := e["distance_km"].(float64)
+		geometric, _ := e["geometric_distance_km"].(float64)
+		if apparent <= 0 || geometric <= 0 {
+			t.Fatalf("missing distances in %v", e)
+		}
+		if apparent == geometric || math.Abs(apparent-geometric) > 1e-3*geometric {
+			t.Errorf("expected a small light-time correction, got apparent %.0f vs geometric %.0f", apparent, geometric)
+		}
+		return
+	}
+	t.Fatal("Mars missing from status data")
+}
+
+func TestCalculateProtocolImpact(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		oneWay time.Duration
+		want   ProtocolImpact
+	}{
+		{"zero", 0, ProtocolImpact{}},
+		{"one second", time.Second, ProtocolImpact{
+			DNS: 2 * time.Second, TCPHandshake: 3 * time.Second, TLSHandshake: 4 * time.Second, PageLoad: 26 * time.Second,
+		}},
+		// The Moon: 1.28 s one way.
+		{"moon", 1280 * time.Millisecond, ProtocolImpact{
+			DNS: 2560 * time.Millisecond, TCPHandshake: 3840 * time.Millisecond, TLSHandshake: 5120 * time.Millisecond, PageLoad: 33280 * time.Millisecond,
+		}},
+		// Mars at its farthest: ~22 minutes one way, so a page takes ~9.5 hours.
+		{"mars far", 22 * time.Minute, ProtocolImpact{
+			DNS: 44 * time.Minute, TCPHandshake: 66 * time.Minute, TLSHandshake: 88 * time.Minute, PageLoad: 572 * time.Minute,
+		}},
+	} {
+		if got := CalculateProtocolImpact(tc.oneWay); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestLightTimes checks the three legs against each other: the answer to a
+// command sent at t is telemetry received at t+RoundTrip, which must have
+// left the body as the command arrived.
+func TestLightTimes(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	at := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"Moon", "Mars", "Voyager 1"} {
+		target, _ := findObjectByName(objects, name)
+		lt, ok := LightTimes(target, objects, at)
+		if !ok {
+			t.Fatal("no Earth")
+		}
+		answer, _ := LightTimes(target, objects, at.Add(lt.RoundTrip))
+		if diff := answer.Downlink - (lt.RoundTrip - lt.Uplink); diff.Abs() > time.Millisecond {
+			t.Errorf("%s: the answer left %v after the command arrived", name, diff)
+		}
+		if d := lt.Uplink - lt.Downlink; d.Abs() > lt.Downlink/1000 {
+			t.Errorf("%s: uplink %v and downlink %v differ by more than 0.1%%", name, lt.Uplink, lt.Downlink)
+		}
+	}
+
+	// Voyager 1 recedes, so a command chases it: the uplink is the longer
+	// leg, by seconds over a light day.
+	voyager, _ := findObjectByName(objects, "Voyager 1")
+	lt, _ := LightTimes(voyager, objects, at)
+	if d := lt.Uplink - lt.Downlink; d < time.Second {
+		t.Errorf("Voyager 1 uplink %v, downlink %v: want the uplink longer by over a second", lt.Uplink, lt.Downlink)
+	}
+
+	if _, ok := LightTimes(voyager, withoutBody("Earth"), at); ok {
+		t.Error("light times without Earth")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestFreeSpacePathLossVoyager compares Voyager 1's path loss at 24 billion
+// km and 8.4 GHz with a hand calculation:
+//
+//	λ = c/f = 299792.458 km/s / 8415 MHz = 3.5626e-5 km
+//	FSPL = 20 log10(4π × 2.4e10 km / 3.5626e-5 km) = 20 × 15.928 = 318.6 dB
+//
+// (A ~308 dB figure is the loss at about 7.6 billion km, a third of the
+// distance.)
+func TestFreeSpacePathLossVoyager(t *testing.T) {
+	if got := FreeSpacePathLoss(24e9, 8415); math.Abs(got-318.6) > 1 {
+		t.Errorf("path loss %.2f dB, want 318.6 dB within 1 dB", got)
+	}
+	if got := FreeSpacePathLoss(7.6e9, 8415); math.Abs(got-308.6) > 1 {
+		t.Errorf("path loss at 7.6e9 km %.2f dB, want 308.6 dB within 1 dB", got)
+	}
+}
+
+func TestCalculateLinkBudget(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	voyager, _ := findObjectByName(objects, "Voyager 1")
+
+	// By hand: 23 W = 43.6 dBm, + 48 dBi, + 73.6 dBi for the 70 m dish at
+	// 8.4 GHz, - 318.6 dB = -153.4 dBm. Noise kTB at 20 K over 10 MHz is
+	// -115.6 dBm, so SNR -37.8 dB and C = B log2(1+SNR) ≈ 2.4 kbps.
+	b, ok := CalculateLinkBudget(voyager, 24e9)
+	if !ok {
+		t.Fatal("no link budget for Voyager 1")
+	}
+	if math.Abs(b.ReceivedDbm - -153.4) > 1 || math.Abs(b.SNRDb - -37.8) > 1 {
+		t.Errorf("received %.1f dBm at SNR %.1f dB, want -153.4 dBm and -37.8 dB", b.ReceivedDbm, b.SNRDb)
+	}
+	if b.MaxRateBps < 2000 || b.MaxRateBps > 3000 || b.Marginal() {
+		t.Errorf("rate %.0f bps (marginal %v), want about 2.4 kbps", b.MaxRateBps, b.Marginal())
+	}
+	// A thousand times further costs 60 dB: well under 10 bps.
+	if far, _ := CalculateLinkBudget(voyager, 24e12); !far.Marginal() {
+		t.Errorf("rate %.3g bps at 24e12 km should be marginal", far.MaxRateBps)
+	}
+
+	// Spacecraft without transmitter figures get the defaults.
+	rover, _ := findObjectByName(objects, "Mars Perseverance")
+	if b, ok := CalculateLinkBudget(rover, 2e8); !ok || b.MaxRateBps <= 0 {
+		t.Errorf("Perseverance: %+v, %v", b, ok)
+	}
+	mars, _ := findObjectByName(objects, "Mars")
+	if _, ok := CalculateLinkBudget(mars, 2e8); ok {
+		t.Error("Mars has no transmitter")
+	}
+	voyager.TransmitterActive = false
+	if _, ok := CalculateLinkBudget(voyager, 24e9); ok {
+		t.Error("a silent spacecraft has no link budget")
+	}
+}
+
+// withFaintProbe adds a Voyager 1 twin with a 1 mW transmitter, whose link
+// is marginal.
+func withFaintProbe(t *testing.T) {
+	t.Helper()
+	objects := celestial.InitSolarSystemObjects()
+	probe, _ := findObjectByName(objects, "Voyager 1")
+	probe.Name = "Faint Probe"
+	probe.TxPowerWatts = 0.001
+	withObjects(t, append(objects, probe))
+}
+
+func TestLinkMarginalStatus(t *testing.T) {
+	withFaintProbe(t)
+	marginal := map[string]bool{}
+	for _, e := range buildStatusResponse(currentSnapshot(distanceClock()), distanceClock()).Objects["spacecraft"] {
+		marginal[e.Name] = e.LinkMarginal
+	}
+	if !marginal["Faint Probe"] {
+		t.Error("the 1 mW probe is not marked link marginal")
+	}
+	if v, ok := marginal["Voyager 1"]; !ok || v {
+		t.Errorf("Voyager 1: listed %v, marginal %v", ok, v)
+	}
+}
+
+func TestLinkBudgetSurfaces(t *testing.T) {
+	withFaintProbe(t)
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+
+	rec := httptest.NewRecorder()
+	s.handleBodies(rec, httptest.NewRequest(http.MethodGet, "/api/bodies", nil))
+	var out BodiesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	budgets := map[string]*LinkBudgetInfo{}
+	for _, b := range out.Bodies {
+		budgets[b.Name] = b.LinkBudget
+	}
+	if b := budgets["Voyager 1"]; b == nil || b.PathLossDb < 300 || b.Marginal {
+		t.Errorf("Voyager 1 link budget: %+v", b)
+	}
+	if b := budgets["Faint Probe"]; b == nil || !b.Marginal {
+		t.Errorf("Faint Probe link budget: %+v", b)
+	}
+	if b := budgets["Mars"]; b != nil {
+		t.Errorf("Mars has a link budget: %+v", b)
+	}
+
+	origTemplate := infoTemplate
+	defer func() { infoTemplate = origTemplate }()
+	infoTemplate = template.Must(template.ParseFiles("templates/info_page.html"))
+	page := func(name string) string {
+		rec := httptest.NewRecorder()
+		s.displayCelestialInfo(rec, httptest.NewRequest(http.MethodGet, "/", nil), currentSnapshot(distanceClock()), name)
+		return rec.Body.String()
+	}
+	if body := page("Voyager 1"); !strings.Contains(body, "Link Budget") || !strings.Contains(body, " dBm") || strings.Contains(body, "Link marginal") {
+		t.Error("Voyager 1's page should show a healthy link budget")
+	}
+	if body := page("Faint Probe"); !strings.Contains(body, "Link marginal") {
+		t.Error("the faint probe's page should say the link is marginal")
+	}
+	if body := page("Mars"); strings.Contains(body, "Link Budget") {
+		t.Error("Mars's page shows a link budget")
+	}
+}
@@ -0,0 +1,87 @@
+// linkloss.go - ending established sessions when the link goes down.
+//
+// A body can slip behind an occluder, or out of its DSN pass, in the middle
+// of a transfer. Established SOCKS tunnels and TCP forwards re-check the
+// link every linkRecheckInterval and end the session when it is down.
+//
+// The session ends with a FIN, not a reset. Closing a socket that still has
+// unread data makes the kernel send an RST, and a reset can make the client
+// drop bytes it has already received but not yet read. The relay instead
+// half-closes the client's side once the data in flight has been written,
+// so the client reads everything it was sent and then EOF. It then drains
+// what the client still sends until it goes quiet for relayDrainTimeout
+// before the connection is closed (see relayWithLatency).
+//
+// HTTP is not covered: the body hosts serve information pages and the DTN
+// API returns whole stored responses, so no HTTP response is streamed over
+// the simulated link.
+package main
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// linkRecheckInterval is how often an established session re-checks
+// whether its body has slipped behind an occluder or out of its contact
+// window.
+var linkRecheckInterval = 30 * time.Second
+
+// relayDrainTimeout is how long the direction still running after the other
+// has finished and half-closed its destination may go without data before
+// the tunnel is torn down. It is renewed by every read, so a long response
+// to a half-closed request keeps flowing. A component's timing can set its
+// own (timing.drain).
+const relayDrainTimeout = 30 * time.Second
+
+// NextVisibilityChange searches forward from now, in steps of step up to
+// limit, for the first time target is blocked from observer when it isn't
+// at now, or the reverse. It returns that time and whether there is one.
+func NextVisibilityChange(observer, target celestial.CelestialObject, objects []celestial.CelestialObject, now time.Time, step, limit time.Duration) (time.Time, bool) {
+	vNow, _ := IsOccluded(observer, target, objects, now)
+	for t := now.Add(step); t.Sub(now) <= limit; t = t.Add(step) {
+		if v, _ := IsOccluded(observer, target, objects, t); v.Blocked() != vNow.Blocked() {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// watchLink calls linkDown every interval until stop is closed. The first
+// time the link is down it calls lost with the outage and returns.
+func watchLink(interval time.Duration, linkDown func() (LinkOutage, bool), lost func(LinkOutage), stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if outage, down := linkDown(); down {
+				lost(outage)
+				return
+			}
+		}
+	}
+}
+
+// closeWriter is a connection that can be half-closed.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// closeWrite half-closes c, so its peer reads EOF after the data already
+// sent. A connection that can't be half-closed is closed.
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(closeWriter); ok {
+		if err := cw.CloseWrite(); err == nil {
+			return
+		} else if !isNetClosingErr(err) {
+			log.Printf("Half-close of %s failed: %v", c.RemoteAddr(), err)
+		}
+	}
+	c.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// TestNextVisibilityChange follows Phobos, which passes behind Mars every
+// orbit: the change found is the first step whose occlusion differs.
+func TestNextVisibilityChange(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+	phobos, _ := findObjectByName(objects, "Phobos")
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	const step = time.Minute
+
+	at, ok := NextVisibilityChange(earth, phobos, objects, now, step, 48*time.Hour)
+	if !ok {
+		t.Fatal("Phobos never changed visibility in two days")
+	}
+	before, _ := IsOccluded(earth, phobos, objects, now)
+	after, _ := IsOccluded(earth, phobos, objects, at)
+	last, _ := IsOccluded(earth, phobos, objects, at.Add(-step))
+	if after == before || last != before {
+		t.Errorf("change at %v: occluded %v -> %v (a step earlier %v)", at, before, after, last)
+	}
+	if _, ok := NextVisibilityChange(earth, phobos, objects, now, step, 0); ok {
+		t.Error("found a change with no lookahead")
+	}
+}
+
+// TestSOCKSLinkLostMidTransfer occludes the body during a download: the
+// client reads every byte sent before the link went down, then a FIN, and
+// the session is recorded as occluded with the bytes delivered.
+func TestSOCKSLinkLostMidTransfer(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	origInterval := linkRecheckInterval
+	linkRecheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { linkRecheckInterval = origInterval })
+
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	setClock := fakeLinkClock(t, start)
+	occludedFrom := start.Add(time.Hour)
+	orig := checkLink
+	checkLink = func(earth, target CelestialObject, objects []CelestialObject, at time.Time) (LinkOutage, bool) {
+		if !at.Before(occludedFrom) {
+			return LinkOutage{Occluder: "Sun"}, true
+		}
+		return LinkOutage{}, false
+	}
+	t.Cleanup(func() { checkLink = orig })
+
+	// The upstream sends a payload and then holds the connection open.
+	payload := make([]byte, 64<<10)
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { upstream.Close() })
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(payload)
+		io.Copy(io.Discard, conn)
+	}()
+
+	recent := NewRecentLog(4, false)
+	proxy := startTestSOCKS(t, &Server{security: newTestSecurity(), metrics: NewRecordingMetrics(), recent: recent,
+		fixedCelestialBody: "Mars", timing: fixedLatency(5 * time.Millisecond)})
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, _, err := client.Dial(ctx, proxy, upstream.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("payload: %v", err)
+	}
+
+	setClock(occludedFrom)
+	if n, err := conn.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("after the link dropped: %d bytes, %v; want EOF (a FIN, not a reset)", n, err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		snap := recent.Snapshot(RecentFilter{})
+		if len(snap) == 1 {
+			if tx := snap[0]; tx.Outcome != outcomeOccluded || tx.BytesOut != int64(len(payload)) {
+				t.Errorf("recorded %s with %d bytes delivered, want %s with %d", tx.Outcome, tx.BytesOut, outcomeOccluded, len(payload))
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the session was never recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
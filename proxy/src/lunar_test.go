@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestLunarTheoryMeeusExample reproduces Meeus, "Astronomical Algorithms",
+// example 47.a (1992 April 12, 0h TD).
+func TestLunarTheoryMeeusExample(t *testing.T) {
+	T := (2448724.5 - celestial.J2000_EPOCH) / celestial.DAYS_PER_CENTURY
+	lambda, beta, dist := lunarEclipticCoords(T)
+	if math.Abs(lambda-133.162655) > 1e-5 {
+		t.Errorf("longitude: expected 133.162655, got %.6f", lambda)
+	}
+	if math.Abs(beta+3.229126) > 1e-5 {
+		t.Errorf("latitude: expected -3.229126, got %.6f", beta)
+	}
+	if math.Abs(dist-368409.7) > 0.1 {
+		t.Errorf("distance: expected 368409.7 km, got %.1f", dist)
+	}
+}
+
+// TestEarthMoonDistanceAgainstEphemeris compares Earth-Moon distances with
+// published ephemeris values: the lunar perigees of recent "supermoons"
+// (geocentric, centre to centre) as tabulated by F. Espenak from the JPL
+// DE ephemerides. The old two-body orbit missed these by thousands of km.
+func TestEarthMoonDistanceAgainstEphemeris(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+	moon, _ := findObjectByName(objects, "Moon")
+
+	cases := []struct {
+		at   string
+		want float64 // km
+	}{
+		{"2011-03-19T19:10:00Z", 356577},
+		{"2012-05-06T03:34:00Z", 356953},
+		{"2016-11-14T11:23:00Z", 356509},
+		{"2022-07-13T09:06:00Z", 357264},
+	}
+	for _, tc := range cases {
+		at, err := time.Parse(time.RFC3339, tc.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := CalculateDistance(earth, moon, objects, at)
+		if math.Abs(got-tc.want) > 500 {
+			t.Errorf("%s: expected %.0f km (±500), got %.0f km", tc.at, tc.want, got)
+		}
+	}
+}
+
+// TestLunarTheoryOnlyForEarthsMoon checks other moons keep the Kepler orbit.
+func TestLunarTheoryOnlyForEarthsMoon(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	for _, name := range []string{"Phobos", "Europa", "Titan"} {
+		obj, ok := findObjectByName(objects, name)
+		if !ok {
+			t.Fatalf("%s missing from built-in objects", name)
+		}
+		if isEarthMoon(obj) {
+			t.Errorf("%s must not use the lunar theory", name)
+		}
+	}
+	moon, _ := findObjectByName(objects, "Moon")
+	if !isEarthMoon(moon) {
+		t.Error("the Moon should use the lunar theory")
+	}
+}
+
+// TestMoonOneWayLatency checks the Moon's reported one-way latency. 2024-01-20
+// falls near the mean distance (~384,400 km), so the answer is ~1.28 s; over
+// a month it ranges from ~1.19 s at perigee to ~1.36 s at apogee.
+func TestMoonOneWayLatency(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	req := httptest.NewRequest(http.MethodGet, "http://latency.space/api/distance?from=earth&to=moon&t=2024-01-20T12:00:00Z", nil)
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var out DistanceResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(out.OneWay-1.28) > 0.02 {
+		t.Errorf("expected ~1.28 s one-way to the Moon, got %.3f s", out.OneWay)
+	}
+
+	invalidateDistanceCache() // other tests may have cached a different object set
+	if latency := CalculateLatency(mustDistance(t, "Moon")).Seconds(); latency < 1.18 || latency > 1.37 {
+		t.Errorf("current Moon latency %.3f s is outside the perigee-apogee range", latency)
+	}
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
@@ -12,14 +13,17 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"encoding/json"
 	"github.com/latency-space/shared/celestial"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // infoTemplate holds the parsed HTML template for the celestial body information page.
@@ -29,16 +33,42 @@ var infoTemplate *template.Template
 type StatusEntry struct {
 	Name       string  `json:"name"`
 	Type       string  `json:"type"`
-	ParentName string  `json:"parentName,omitempty"` // Omit if empty
-	Distance   float64 `json:"distance_km"`
-	Latency    float64 `json:"latency_seconds"` // Changed type to float64
-	Occluded   bool    `json:"occluded"`
+	ParentName string  `json:"parentName,omitempty"`  // Omit if empty
+	Distance   float64 `json:"distance_km"`           // Light-time corrected (apparent) distance
+	Geometric  float64 `json:"geometric_distance_km"` // Geometric distance at the same instant
+	Latency    float64 `json:"latency_seconds"`       // One-way light time, to 0.01 s
+	Occluded   bool    `json:"occluded"`              // Visibility is "blocked"
+	Visibility string  `json:"visibility"`            // "clear", "degraded" (close to the Sun's limb; lossy) or "blocked"
+	// Current or next DSN pass; omitted for bodies without a contact schedule.
+	PassStart *time.Time `json:"next_dsn_pass,omitempty"`
+	PassEnd   *time.Time `json:"dsn_pass_end,omitempty"`
+	NoContact bool       `json:"no_contact,omitempty"` // outside every contact window now
+	// Occluded was solved for this request rather than read from the cache
+	// (bodies within freshOcclusionElongationDeg of the Sun).
+	OcclusionFresh bool `json:"occlusion_fresh,omitempty"`
+	// With -ground-station on: the station the body is worked from and the
+	// distance it adds to distance_km, or no_station_visibility when every
+	// eligible station has the body below its horizon.
+	GroundStation    string  `json:"ground_station,omitempty"`
+	StationElevation float64 `json:"ground_station_elevation_deg,omitempty"`
+	StationOffset    float64 `json:"ground_station_offset_km,omitempty"`
+	NoStation        bool    `json:"no_station_visibility,omitempty"`
+	// A transmitting spacecraft whose link budget allows under
+	// marginalLinkBps (see CalculateLinkBudget).
+	LinkMarginal bool `json:"link_marginal,omitempty"`
+	// With -space-weather on, the conditions degrading the link now, if any
+	// (spaceweather.go).
+	SpaceWeather *WeatherReport `json:"spaceWeather,omitempty"`
 }
 
 // ApiResponse defines the structure of the JSON response for the `/api/status-data` endpoint.
 type ApiResponse struct {
-	Timestamp time.Time                `json:"timestamp"`
-	Objects   map[string][]StatusEntry `json:"objects"` // Keyed by object type (e.g., "planets", "moons")
+	Timestamp  time.Time                `json:"timestamp"`             // when the request was served
+	ComputedAt time.Time                `json:"computedAt"`            // the instant the cached distances describe
+	Pinned     *time.Time               `json:"pinnedEpoch,omitempty"` // set while the simulation epoch is pinned (epoch.go)
+	Objects    map[string][]StatusEntry `json:"objects"`               // Keyed by category (categories.go)
+	Categories []string                 `json:"categories"`            // The keys of Objects, in display order
+	Sun        *StatusEntry             `json:"sun,omitempty"`         // The Sun, which isn't proxied
 }
 
 // InfoPageData holds the data required to render the `info_page.html` template.
@@ -50,34 +80,117 @@ type InfoPageData struct {
 	RoundTripFriendly string        // Human-readable round-trip time
 	OccludedClass     string        // CSS class for occlusion status ("status-visible" or "status-occluded")
 	OccludedStatus    string        // Textual description of occlusion status
+	ContactStatus     string        // DSN pass description; empty for bodies without a schedule
 	MoonsHTML         template.HTML // Pre-rendered HTML for the moons list (if any)
 	Domain            string        // The domain name for this body (e.g., "mars.latency.space")
+	WebOrigin         string        // Scheme, domain and (non-default) port of this body's pages
+	SOCKSPort         int           // Port to use the domain as a SOCKS5 proxy on
+	MOTD              string        // Banner text for the body, if any
+	Fact              string        // The fact currently shown (rotates; see bodies.go)
+	Impact            []impactRow   // Protocol timings at the current latency
+	DownlinkRate      string        // Body-to-Earth data rate, e.g. "160 bps"
+	Transfer          []impactRow   // File transfer times at the downlink rate (see transfer.go)
+	Scenarios         []whatIfLink  // Pre-filled /api/whatif queries (see scenarios.go)
+	LinkBudget        []impactRow   // Downlink budget for transmitting spacecraft (see CalculateLinkBudget)
+	LightTimes        []impactRow   // Uplink, downlink and round-trip light times for spacecraft (see LightTimes)
+	CloseApproach     []impactRow   // Next close approach of a near-Earth asteroid (see closeapproach.go)
+	SpaceWeather      []impactRow   // Space weather on the link now, with -space-weather on (see spaceweather.go)
+	TooClose          string        // Why a near-Earth asteroid on a flyby can't be proxied now, if it can't
+	LinkMarginal      bool          // The link budget allows under marginalLinkBps
+	PinnedEpoch       string        // The pinned simulation epoch, if any (see epoch.go)
+	TCPWindow         string        // Interplanetary Internet mode note, if -simulate-tcp-windows is on
+	L                 Locale        // Language of the page (see i18n.go)
 }
 
 // Server represents the main latency proxy application.
 type Server struct {
-	port               int  // Port for the HTTP server (HTTPS uses 443)
-	https              bool // Flag indicating whether to enable HTTPS
-	metrics            *MetricsCollector
+	httpAddr           string // HTTP listen address (-http-addr); empty disables it
+	httpsAddr          string // HTTPS listen address (-https-addr); empty disables it
+	socksAddr          string // SOCKS5 listen address (-socks-addr); empty disables it
+	metrics            Metrics
+	gatherer           prometheus.Gatherer // Source of /metrics; nil = the default registry
+	requestHistory     *requestHistory     // Per-minute request totals for /api/metrics-summary
 	security           *SecurityValidator
-	limiter            *RateLimiter // Per-IP rate/concurrency abuse controls
-	dtn                *DTNStore    // Store-and-forward delivery for distant bodies
+	limiter            *RateLimiter    // Per-IP rate/concurrency abuse controls
+	distanceLimiter    *RateLimiter    // Per-IP rate cap for the on-demand /api/distance solver
+	refreshLimiter     *RateLimiter    // Per-IP rate cap on /api/status-data?refresh=true
+	demoLimiter        *RateLimiter    // Per-IP rate and concurrency caps on /api/demo
+	dtn                *DTNStore       // Store-and-forward delivery for distant bodies
+	recent             *RecentLog      // Ring of recent proxy transactions for /_debug/recent
+	statusStream       *StatusStream   // Subscribers of /api/status-stream
+	access             *AccessLog      // On-disk access log (-access-log; nil = disabled)
+	adminToken         string          // Operator token for admin-only endpoints (empty disables them)
+	adminCIDRs         []*net.IPNet    // Networks the admin /_debug endpoints answer (-admin-cidrs; empty = any)
+	objectsFile        string          // Optional JSON file merged over the built-in objects (-objects-file)
+	cacheFile          string          // Distance table saved on shutdown and loaded at boot (-cache-file)
+	commands           commandStore    // Simulated spacecraft commands for /api/command
+	tcpForwards        []tcpForward    // Static port forwards (-tcp-forward)
+	settingsMu         sync.RWMutex    // Guards the settings a SIGHUP reloads: udpLimits, udpImpair, crawlers
+	udpLimits          UDPLimits       // Per-association UDP ASSOCIATE caps
+	udpImpair          UDPImpairment   // UDP relay loss/reorder/duplicate rates
+	sizeLimits         SizeLimits      // Request, response and SOCKS session byte caps
+	forwarders         []*TCPForwarder // Running forward listeners, stopped individually on shutdown
+	fingerAddr         string          // Finger listener address (-finger); empty disables it
+	gopherPort         int             // Gopher listener port (-gopher-port); 0 disables it
+	gopherHost         string          // Host named in Gopher menu items (-gopher-host)
+	timeAddr           string          // RFC 868 time listener address (-time-udp); empty disables it
+	timeBody           string          // Body the time listener answers for by default (-time-udp-body)
+	crawlers           *crawlerBlocker // Blocked crawler User-Agents (-block-crawlers); nil allows all
+	config             *configSource   // The -config file re-read on SIGHUP; nil without one
+	proxyProtocol      bool            // SOCKS connections start with a PROXY header (-proxy-protocol)
+	proxyProtocolHTTP  bool            // HTTP(S) connections start with a PROXY header (-proxy-protocol-http)
+	trustedProxies     []*net.IPNet    // Peers whose X-Forwarded-For/Forwarded is believed (-trusted-proxies)
+	acmeWebroot        string          // Directory ACME challenge tokens are served from (-acme-webroot)
+	acmeResponder      http.Handler    // Proxy to a local ACME challenge responder (-acme-responder); nil = none
+	acmeAutocert       http.Handler    // Challenge handler of the HTTPS listener's autocert manager; nil without HTTPS
+	finger             *FingerServer
+	gopher             *GopherServer
+	timeServer         *TimeServer
 	httpServer         *http.Server
 	httpsServer        *http.Server
-	socksListener      net.Listener // Listener for the SOCKS5 server
-	httpEnabled        bool         // Whether HTTP/HTTPS should run
-	socksEnabled       bool         // Whether SOCKS5 should run
-	fixedCelestialBody string       // Fixed celestial body for this instance (empty = dynamic)
+	httpListener       net.Listener     // Bound by Listen; nil when HTTP is off
+	httpsListener      net.Listener     // Bound by Listen; nil when HTTPS is off
+	socksListener      net.Listener     // Listener for the SOCKS5 server
+	connLimit          *ConnLimiter     // Open connections per IP across the listeners (-conns-per-ip); nil = unlimited
+	httpHeaderTimeout  time.Duration    // Time allowed to send request headers (-http-header-timeout); 0 = the request timeout
+	httpRequestTimeout time.Duration    // Read and write deadline of an ordinary HTTP request; 0 = httpRequestTimeout
+	tcpWindow          int              // Simulated TCP window for SOCKS and forward relays (-simulate-tcp-windows); 0 = off
+	preflight          *Preflight       // Reachability probes before paying the latency (-preflight-ttl); nil = off
+	remoteDNS          *RemoteDNS       // SOCKS target names resolved over the link (-remote-dns-latency); nil = off
+	overhead           *overheadSampler // Proxied requests whose real overhead is recorded (-overhead-sampling-pct); nil = none
+	stop               chan struct{}    // Closed by Stop to end Serve
+	stopOnce           sync.Once
+	httpEnabled        bool             // Whether HTTP/HTTPS should run
+	socksEnabled       bool             // Whether SOCKS5 should run
+	fixedCelestialBody string           // Fixed celestial body for this instance (empty = dynamic)
+	timing                              // Clock and latency source, handed to every handler (clock.go)
+	snapshots          SnapshotProvider // Objects and distances for each request; nil = the live ones (internal_state.go)
 }
 
-// NewServer creates and returns a new Server instance.
-func NewServer(port int, useHTTPS bool, httpEn bool, socksEn bool, fixedBody string) *Server {
+// NewServer creates a Server serving objects, which must hold Earth;
+// main validates them first (-skip-object-validation aside).
+func NewServer(port int, useHTTPS bool, httpEn bool, socksEn bool, fixedBody string, objects []CelestialObject) (*Server, error) {
+	if err := checkObjects(objects); err != nil {
+		return nil, fmt.Errorf("celestial objects: %w", err)
+	}
+	setCelestialObjects(objects)
 	s := &Server{
-		port:               port,
-		https:              useHTTPS,
+		httpAddr:           fmt.Sprintf(":%d", port),
+		socksAddr:          ":1080",
 		metrics:            NewMetricsCollector(),
 		security:           NewSecurityValidator(),
 		limiter:            newRateLimiterFromEnv(),
+		distanceLimiter:    newDistanceLimiter(),
+		connLimit:          NewConnLimiter(defaultConnsPerIP),
+		httpHeaderTimeout:  defaultHTTPHeaderTimeout,
+		refreshLimiter:     newStatusRefreshLimiter(),
+		demoLimiter:        newDemoLimiter(),
+		recent:             NewRecentLog(defaultRecentSize, false),
+		requestHistory:     &requestHistory{},
+		statusStream:       NewStatusStream(defaultStatusStreamClients),
+		udpLimits:          defaultUDPLimits,
+		sizeLimits:         defaultSizeLimits,
+		adminToken:         os.Getenv("ADMIN_TOKEN"),
 		httpEnabled:        httpEn,
 		socksEnabled:       socksEn,
 		fixedCelestialBody: fixedBody,
@@ -89,9 +202,18 @@ func NewServer(port int, useHTTPS bool, httpEn bool, socksEn bool, fixedBody str
 		storePath = "/data/dtn-jobs.json"
 	}
 	s.dtn = NewDTNStore(storePath, s.security, s.metrics)
-	return s
+	s.preflight = NewPreflight(defaultPreflightTTL, s.metrics)
+	s.overhead, _ = newOverheadSampler(defaultOverheadSamplingPct)
+	s.dtn.SetOverheadSampler(s.overhead)
+	if useHTTPS {
+		s.httpsAddr = ":443"
+	}
+	return s, nil
 }
 
+// defaultRecentSize is the default capacity of the /_debug/recent ring.
+const defaultRecentSize = 1000
+
 // clientIP extracts the bare IP (no port) from a net.Addr string.
 func clientIP(remoteAddr string) string {
 	if idx := strings.LastIndex(remoteAddr, ":"); idx > 0 {
@@ -103,17 +225,109 @@ func clientIP(remoteAddr string) string {
 // Start initializes and runs the HTTP, HTTPS (if enabled), and SOCKS5 servers.
 // It listens for shutdown signals (SIGINT, SIGTERM) for graceful termination.
 func (s *Server) Start() error {
+	s.warmDistanceCache()
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Listen binds the HTTP, HTTPS and SOCKS5 listeners that are enabled and
+// configured. Binding up front means a bad address fails Start at once, and
+// tests can read the ephemeral ports before calling Serve.
+func (s *Server) Listen() error {
+	s.stop = make(chan struct{})
+	fail := func(err error) error {
+		for _, ln := range []net.Listener{s.httpListener, s.httpsListener, s.socksListener} {
+			if ln != nil {
+				ln.Close()
+			}
+		}
+		return err
+	}
+	var err error
+	if s.httpEnabled && s.httpAddr != "" {
+		if s.httpListener, err = s.listenHTTP(s.httpAddr); err != nil {
+			return fail(fmt.Errorf("HTTP listen on %s: %v", s.httpAddr, err))
+		}
+		s.httpServer = s.newHTTPServer(s.withForwardedHost(s.withAccessLog(http.HandlerFunc(s.handleHTTP))))
+	}
+	if s.httpEnabled && s.httpsAddr != "" {
+		if s.httpsListener, err = s.listenHTTP(s.httpsAddr); err != nil {
+			return fail(fmt.Errorf("HTTPS listen on %s: %v", s.httpsAddr, err))
+		}
+		tlsConfig, manager := setupTLS()
+		s.acmeAutocert = manager.HTTPHandler(nil)
+		s.httpsServer = s.newHTTPServer(s.withForwardedHost(s.withAccessLog(http.HandlerFunc(s.handleHTTP))))
+		s.httpsServer.TLSConfig = tlsConfig
+		s.httpsServer.ErrorLog = log.New(io.Discard, "", 0) // don't really need these errors right now
+	}
+	if s.socksEnabled && s.socksAddr != "" {
+		if s.socksListener, err = net.Listen("tcp", s.socksAddr); err != nil {
+			return fail(fmt.Errorf("failed to listen on SOCKS address %s: %v", s.socksAddr, err))
+		}
+		if !s.proxyProtocol {
+			s.socksListener = s.connLimit.Listener(s.socksListener, nil)
+		}
+	}
+	return nil
+}
+
+// Serve runs the listeners bound by Listen, plus the forwards, finger and
+// background jobs, until a shutdown signal, a server error or Stop.
+func (s *Server) Serve() error {
 	// Channel to listen for OS shutdown signals
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
+	// SIGHUP re-reads the -config file and the -objects-file without a restart.
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	defer signal.Stop(hups)
+
+	// SIGUSR1 reopens the access log after logrotate moved it.
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	defer signal.Stop(usr1)
+
 	// Background janitor to prune idle rate-limiter buckets
 	stopCleanup := make(chan struct{})
 	defer close(stopCleanup)
 	go s.limiter.StartCleanup(stopCleanup)
+	go s.refreshLimiter.StartCleanup(stopCleanup)
+	go s.demoLimiter.StartCleanup(stopCleanup)
+	go s.distanceLimiter.StartCleanup(stopCleanup)
+
+	go func() {
+		for {
+			select {
+			case <-stopCleanup:
+				return
+			case <-hups:
+				if s.config != nil {
+					if _, err := s.reloadConfig(); err != nil {
+						log.Printf("SIGHUP: config reload rejected, keeping current settings: %v", err)
+					}
+				}
+				if s.objectsFile != "" {
+					if _, err := s.reloadObjects(); err != nil {
+						log.Printf("SIGHUP: object reload rejected, keeping current objects: %v", err)
+					}
+				}
+			case <-usr1:
+				s.access.Reopen()
+			}
+		}
+	}()
+
+	// A NOAA space weather source is re-read as SWPC publishes.
+	if noaa, ok := weatherSource().(*NOAAWeather); ok {
+		go noaa.refresh(noaaRefreshInterval, stopCleanup)
+	}
 
 	// Recover any in-flight store-and-forward jobs and start their retention sweep.
 	if s.dtn != nil {
+		s.dtn.timing = s.timing
 		s.dtn.Start(stopCleanup)
 	}
 
@@ -126,7 +340,7 @@ func (s *Server) Start() error {
 		metricsAddr = ":9090"
 	}
 	if metricsAddr != "-" {
-		go s.metrics.ServeMetrics(metricsAddr)
+		go ServeMetrics(metricsAddr)
 	}
 
 	// Publish current per-body latency as a gauge for the "Solar System Latency"
@@ -136,7 +350,7 @@ func (s *Server) Start() error {
 		go func() {
 			publish := func() {
 				for _, obj := range getCelestialObjects() {
-					if d := getCurrentDistance(obj.Name); d > 0 {
+					if d, err := getCurrentDistance(obj.Name); err == nil && d > 0 {
 						s.metrics.SetBodyLatency(obj.Name, CalculateLatency(d).Seconds())
 					}
 				}
@@ -153,6 +367,20 @@ func (s *Server) Start() error {
 				}
 			}
 		}()
+
+		// Sample request totals for /api/metrics-summary's hourly count.
+		go func() {
+			t := time.NewTicker(requestHistoryInterval)
+			defer t.Stop()
+			for {
+				s.requestHistory.record(s.now(), s.collector().requestTotals())
+				select {
+				case <-stopCleanup:
+					return
+				case <-t.C:
+				}
+			}
+		}()
 	}
 
 	// Use a WaitGroup to wait for server goroutines to finish
@@ -161,7 +389,7 @@ func (s *Server) Start() error {
 	errCh := make(chan error, 3) // Buffered channel for HTTP, HTTPS, SOCKS errors
 
 	// Start HTTP server in a goroutine (only if HTTP enabled)
-	if s.httpEnabled {
+	if s.httpListener != nil {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -170,13 +398,13 @@ func (s *Server) Start() error {
 				errCh <- fmt.Errorf("HTTP server error: %v", err)
 			}
 		}()
-		log.Printf("HTTP server starting on port %d", s.port)
+		log.Printf("HTTP server starting on %s", s.httpListener.Addr())
 	} else {
 		log.Printf("HTTP server disabled")
 	}
 
 	// Start HTTPS server in a goroutine (only if HTTP and HTTPS both enabled)
-	if s.httpEnabled && s.https {
+	if s.httpsListener != nil {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -185,13 +413,13 @@ func (s *Server) Start() error {
 				errCh <- fmt.Errorf("HTTPS server error: %v", err)
 			}
 		}()
-		log.Printf("HTTPS server starting on port 443")
+		log.Printf("HTTPS server starting on %s", s.httpsListener.Addr())
 	} else if s.httpEnabled {
 		log.Printf("HTTPS server disabled")
 	}
 
 	// Start SOCKS5 server in a goroutine (only if SOCKS enabled)
-	if s.socksEnabled {
+	if s.socksListener != nil {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -200,17 +428,96 @@ func (s *Server) Start() error {
 				errCh <- fmt.Errorf("SOCKS5 server error: %v", err)
 			}
 		}()
-		log.Printf("SOCKS5 server starting on port 1080")
+		log.Printf("SOCKS5 server starting on %s", s.socksListener.Addr())
 	} else {
 		log.Printf("SOCKS5 server disabled")
 	}
 
+	// Start the static TCP forwards. A listener that can't bind or whose
+	// destination is not allowed is a configuration error.
+	for _, fwd := range s.tcpForwards {
+		f := NewTCPForwarder(fwd, s.security, s.metrics)
+		f.timing = s.timing
+		f.limiter = s.limiter
+		f.recent = s.recent
+		f.access = s.access
+		f.window = s.tcpWindow
+		if err := f.Listen(); err != nil {
+			s.Stop()
+			wg.Wait()
+			return err
+		}
+		s.forwarders = append(s.forwarders, f)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.Serve()
+		}()
+	}
+
+	// Start the finger listener if enabled.
+	if s.fingerAddr != "" {
+		f := NewFingerServer(s.fingerAddr, s.metrics)
+		f.timing = s.timing
+		f.limiter = s.limiter
+		if err := f.Listen(); err != nil {
+			s.Stop()
+			wg.Wait()
+			return err
+		}
+		s.finger = f
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.Serve()
+		}()
+	}
+
+	// Start the Gopher listener if enabled.
+	if s.gopherPort != 0 {
+		g := NewGopherServer(fmt.Sprintf(":%d", s.gopherPort), s.gopherHost, s.security, s.dtn, s.metrics)
+		g.timing = s.timing
+		g.limiter = s.limiter
+		if s.fixedCelestialBody != "" {
+			g.body = s.fixedCelestialBody
+		}
+		if err := g.Listen(); err != nil {
+			s.Stop()
+			wg.Wait()
+			return err
+		}
+		s.gopher = g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Serve()
+		}()
+	}
+
+	// Start the UDP time listener if enabled.
+	if s.timeAddr != "" {
+		ts := NewTimeServer(s.timeAddr, s.timeBody, s.metrics)
+		ts.timing = s.timing
+		if err := ts.Listen(); err != nil {
+			s.Stop()
+			wg.Wait()
+			return err
+		}
+		s.timeServer = ts
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ts.Serve()
+		}()
+	}
+
 	// Wait for signals or errors
 	select {
 	case <-sigs:
 		log.Println("Received shutdown signal")
 	case err := <-errCh:
 		log.Printf("Server error: %v", err)
+	case <-s.stop:
 	}
 
 	// Graceful shutdown
@@ -219,11 +526,24 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop gracefully shuts down the server
+// Stop gracefully shuts down the server. It may be called more than once,
+// and from another goroutine to end Start.
 func (s *Server) Stop() {
+	s.stopOnce.Do(s.shutdown)
+}
+
+func (s *Server) shutdown() {
+	if s.stop != nil {
+		close(s.stop)
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// Status streams never go idle; end them so the HTTP servers can.
+	if s.statusStream != nil {
+		s.statusStream.Close()
+	}
+
 	if s.httpServer != nil {
 		log.Println("Shutting down HTTP server...")
 		if err := s.httpServer.Shutdown(ctx); err != nil {
@@ -242,30 +562,213 @@ func (s *Server) Stop() {
 		log.Println("Shutting down SOCKS5 server...")
 		s.socksListener.Close()
 	}
+
+	for _, f := range s.forwarders {
+		log.Printf("Shutting down TCP forward %s...", f.fwd.Listen)
+		f.Stop()
+	}
+
+	if s.finger != nil {
+		log.Println("Shutting down finger server...")
+		s.finger.Stop()
+	}
+
+	if s.gopher != nil {
+		log.Println("Shutting down Gopher server...")
+		s.gopher.Stop()
+	}
+
+	if s.timeServer != nil {
+		log.Println("Shutting down time server...")
+		s.timeServer.Stop()
+	}
+
+	if s.dtn != nil {
+		s.dtn.CloseIdleConnections()
+	}
+
+	if s.cacheFile != "" {
+		if err := saveDistanceCache(s.cacheFile); err != nil {
+			log.Printf("Distance cache not saved: %v", err)
+		}
+	}
+
+	// Flush the spans of the requests that just finished.
+	activeTracer.Load().Shutdown()
+
+	// Write out the lines of the sessions that just ended.
+	s.access.Close()
 }
 
 // handleHTTP processes HTTP requests with celestial body latency
 func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	//log.Printf("Host %s, Path being accessed: %s", r.Host, r.URL.Path)
+	received := s.now()
+	defer activeHTTP.enter()()
+
+	// ACME HTTP-01 challenges, on any host, come before everything else so
+	// certificates can be issued with the proxy on port 80 (acme.go).
+	if isACMEChallenge(r) {
+		s.serveACMEChallenge(w, r)
+		return
+	}
+
+	// Health probes: no host parsing, latency or tracing.
+	if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+		s.handleHealth(w, r)
+		return
+	}
+
+	// Calibration pings, answered before anything that would add to the
+	// server's share of their round trip (ping.go).
+	if r.URL.Path == "/api/ping" && r.Method != "OPTIONS" {
+		s.handlePing(w, r, received)
+		return
+	}
 
-	// Special case for metrics endpoint
+	ctx, span := startSpan(r.Context(), "http.request",
+		attr("http.method", r.Method), attr("http.host", r.Host), attr("http.target", r.URL.Path))
+	defer span.End()
+	if ctx != r.Context() {
+		r = r.WithContext(ctx)
+	}
+
+	// Metrics: a body's own series on its host, everything for the operator,
+	// and aggregates for anyone
 	if r.URL.Path == "/metrics" {
-		promhttp.Handler().ServeHTTP(w, r)
+		s.serveMetrics(w, r)
+		return
+	}
+	if r.URL.Path == "/metrics/public" {
+		s.servePublicMetrics(w, r)
 		return
 	}
 
 	// robots.txt: the per-body hosts are proxy/info endpoints, not content to
-	// index - tell crawlers to stay out. (The apex latency.space serves its own
-	// robots.txt from the status frontend.)
+	// index - only the info page (or the apex dashboard) and the API may be
+	// crawled. Served before any latency or crawler blocking.
 	if r.URL.Path == "/robots.txt" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		fmt.Fprint(w, "User-agent: *\nDisallow: /\n")
+		fmt.Fprint(w, robotsTxt)
+		return
+	}
+
+	// Crawlers that ignore robots.txt are turned away without any delay.
+	if s.crawlerBlocker().matches(r.UserAgent()) {
+		w.Header().Set("X-Robots-Tag", "noindex")
+		http.Error(w, "Crawlers are not permitted on latency.space body hosts", http.StatusForbidden)
+		return
+	}
+
+	// One view of the objects and distances for the rest of the request.
+	snap, ok := s.requestSnapshot(w, r)
+	if !ok {
 		return
 	}
 
 	// API endpoint for status data
 	if r.URL.Path == "/api/status-data" {
-		s.handleStatusData(w, r)
+		s.handleStatusData(w, r, snap)
+		return
+	}
+
+	// The same data pushed as Server-Sent Events
+	if r.URL.Path == "/api/status-stream" && r.Method != "OPTIONS" {
+		s.handleStatusStream(w, r)
+		return
+	}
+
+	// Machine-readable description of the JSON API
+	if r.URL.Path == "/api/openapi.json" && r.Method != "OPTIONS" {
+		s.handleOpenAPI(w, r)
+		return
+	}
+
+	// On-demand distance between any two bodies
+	if r.URL.Path == "/api/distance" && r.Method != "OPTIONS" {
+		s.handleDistance(w, r)
+		return
+	}
+
+	// Pairwise distances and light times between every body
+	if r.URL.Path == "/api/matrix" && r.Method != "OPTIONS" {
+		s.handleMatrix(w, r)
+		return
+	}
+
+	// Per-body facts and protocol impact
+	if r.URL.Path == "/api/bodies" && r.Method != "OPTIONS" {
+		s.handleBodies(w, r)
+		return
+	}
+
+	// Requests, bytes, latency and rejections for one body
+	if r.URL.Path == "/api/metrics-summary" && r.Method != "OPTIONS" {
+		s.handleMetricsSummary(w, r)
+		return
+	}
+
+	// Naively synced spacecraft clock for a body
+	if r.URL.Path == "/api/time" && r.Method != "OPTIONS" {
+		s.handleTime(w, r)
+		return
+	}
+
+	// Simulated hop-by-hop path of a proxied request
+	if r.URL.Path == "/api/traceroute" && r.Method != "OPTIONS" {
+		s.handleTraceroute(w, r)
+		return
+	}
+
+	// Everyday tasks timed over a body's link
+	if r.URL.Path == "/api/whatif" && r.Method != "OPTIONS" {
+		s.handleWhatIf(w, r)
+		return
+	}
+
+	// Daily light times and link windows ahead, as JSON, iCal or CSV
+	if r.URL.Path == "/api/forecast" && r.Method != "OPTIONS" {
+		s.handleForecast(w, r)
+		return
+	}
+
+	// Simulated spacecraft command and acknowledgement
+	if (r.URL.Path == "/api/command" || strings.HasPrefix(r.URL.Path, "/api/command/")) && r.Method != "OPTIONS" {
+		s.handleCommand(w, r)
+		return
+	}
+
+	// Known payloads dripped and echoed at a body's latency
+	if strings.HasPrefix(r.URL.Path, "/api/demo/") && r.Method != "OPTIONS" {
+		s.handleDemo(w, r)
+		return
+	}
+
+	// Orbit paths for drawing in the status UI
+	if r.URL.Path == "/api/orbit" && r.Method != "OPTIONS" {
+		s.handleOrbit(w, r)
+		return
+	}
+
+	// The whole system at an instant as a 3D scene
+	if (r.URL.Path == "/api/scene.gltf" || r.URL.Path == "/api/scene.json") && r.Method != "OPTIONS" {
+		s.handleScene(w, r)
+		return
+	}
+
+	// Orbital elements, position and velocity at an instant
+	if r.URL.Path == "/api/state" && r.Method != "OPTIONS" {
+		s.handleState(w, r)
+		return
+	}
+
+	// Browser proxy configuration: PAC file and setup instructions
+	if r.URL.Path == "/proxy.pac" {
+		s.handlePAC(w, r)
+		return
+	}
+	if r.URL.Path == "/setup" {
+		s.handleSetup(w, r)
 		return
 	}
 
@@ -277,12 +780,12 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Special case for debug endpoints
 	if strings.HasPrefix(r.URL.Path, "/_debug/") {
-		s.handleDebugEndpoint(w, r)
+		s.handleDebugEndpoint(w, r, snap)
 		return
 	}
 
 	// Handle CORS preflight for API and debug endpoints
-	if r.Method == "OPTIONS" && (strings.HasPrefix(r.URL.Path, "/_debug/") || r.URL.Path == "/api/status-data") {
+	if r.Method == "OPTIONS" && (strings.HasPrefix(r.URL.Path, "/_debug/") || strings.HasPrefix(r.URL.Path, "/api/")) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
@@ -290,10 +793,55 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The dashboard: the front door at latency.space and www.latency.space.
+	if isApexHost(r.Host) {
+		s.handleDashboard(w, r)
+		return
+	}
+
+	// Group index pages: planets.latency.space and friends.
+	if group, ok := bodyGroupForHost(r.Host); ok {
+		s.handleGroupIndex(w, r, group)
+		return
+	}
+
 	// Resolve which celestial body (or moon) this hostname names.
-	bodyName := s.resolveCelestialHost(r.Host)
-	if bodyName == "" {
-		http.Error(w, "Unknown celestial body", http.StatusBadRequest)
+	bodyName, err := s.tracedResolveHost(r.Context(), snap.Objects, r.Host)
+	if err != nil {
+		debugf("HTTP host %q names no body: %v", r.Host, err)
+		// Includes the retired target-prefixed hosts; keep them out of indexes.
+		// A latency.space subdomain is sent to the dashboard to pick a body.
+		reason := hostNotLatencySpace
+		var he *hostError
+		if errors.As(err, &he) {
+			reason = he.Reason
+		}
+		if isLatencySpaceHost(r.Host) {
+			s.redirectUnknownHost(w, r, reason)
+			return
+		}
+		w.Header().Set("X-Robots-Tag", "noindex")
+		l := localeFor(r)
+		setContentLanguage(w, l)
+		out := map[string]string{"error": l.T("error.unknown_body"), "code": unknownBodyCode, "reason": reason}
+		if he != nil {
+			out["detail"] = he.message(l)
+		}
+		writeJSON(w, http.StatusBadRequest, out)
+		return
+	}
+
+	// OPTIONS anywhere else on a body host is an ordinary request, not a
+	// preflight for the API: say what the host serves.
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// The Sun gets its own page: it is the centre, not a destination (sun.go).
+	if obj, ok := snap.Find(bodyName); ok && obj.Type == "star" {
+		s.displaySunInfo(w, r, snap, obj)
 		return
 	}
 
@@ -303,36 +851,43 @@ func (s *Server) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	// (target.body.latency.space) was removed: a dotted target sitting under a
 	// body can be covered by neither a DNS wildcard nor a TLS wildcard (both
 	// match a single label), so those hostnames never resolved in practice.
-	s.displayCelestialInfo(w, bodyName)
+	s.displayCelestialInfo(w, r, snap, bodyName)
 }
 
-// displayCelestialInfo renders the information page for a celestial body using the template
-func (s *Server) displayCelestialInfo(w http.ResponseWriter, name string) {
+// displayCelestialInfo renders the information page for a celestial body using the template,
+// in the language r asks for, from snap.
+func (s *Server) displayCelestialInfo(w http.ResponseWriter, r *http.Request, snap *SystemSnapshot, name string) {
+	l := localeFor(r)
+
 	// 5. Set Content-Type Header
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	setContentLanguage(w, l)
 
 	// 2. Calculate Data
-	distance := getCurrentDistance(name) // km
-	latency := CalculateLatency(distance)
+	distance, err := snap.Distance(name) // km
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setStationHeader(w, name)
+	latency := s.oneWay(name, distance)
 
-	var occluded bool
+	var visibility Visibility
 	var occluderName string
 	var occluder CelestialObject // Use struct type to match IsOccluded return type
-	targetObject, targetFound := findObjectByName(getCelestialObjects(), name)
-	earthObject, earthFound := findObjectByName(getCelestialObjects(), "Earth")
+	targetObject, targetFound := snap.Find(name)
+	earthObject, earthFound := snap.Find("Earth")
 
 	if targetFound && earthFound {
-		occluded, occluder = IsOccluded(earthObject, targetObject, getCelestialObjects(), time.Now())
-		// Check if an actual occluding object was returned (Name will be non-empty)
-		if occluded && occluder.Name != "" {
-			occluderName = occluder.Name
-		}
+		visibility, occluder = IsOccluded(earthObject, targetObject, snap.Objects, simTime(s.now()))
+		// The blocker, or the limb a degraded link passes close to
+		occluderName = occluder.Name
 	} else {
 		log.Printf("Warning: Could not perform occlusion check for %s (targetFound: %v, earthFound: %v)", name, targetFound, earthFound)
 		// Proceed without occlusion data if objects aren't found
 	}
 
-	moons := celestial.GetMoons(name)
+	moons := moonsOf(snap.Objects, name)
 
 	// 3. Populate InfoPageData
 	var moonsHTML template.HTML
@@ -342,7 +897,8 @@ func (s *Server) displayCelestialInfo(w http.ResponseWriter, name string) {
 			// Construct the moon's domain (e.g., phobos.mars.latency.space)
 			moonDomain := FormatMoonDomain(moon.Name, name)
 			// Create the list item HTML, linking to the root of the moon's proxy domain
-			htmlBuilder.WriteString(fmt.Sprintf(`<li><a href="http://%s/">%s</a></li>`, moonDomain, moon.Name))
+			htmlBuilder.WriteString(fmt.Sprintf(`<li><a href="http://%s/">%s</a></li>`,
+				hostWithPort(moonDomain, s.httpListener, 80), moon.Name))
 		}
 		moonsHTML = template.HTML(htmlBuilder.String()) // Convert final string to template.HTML
 	}
@@ -351,146 +907,304 @@ func (s *Server) displayCelestialInfo(w http.ResponseWriter, name string) {
 		Name:              name,                                      // Use the original case name for display
 		DistanceMkm:       float64(int((distance/1e6)*100)) / 100,    // Convert km to million km with 2 decimal places
 		LatencySec:        float64(int(latency.Seconds()*100)) / 100, // One-way latency in seconds with 2 decimal places
-		LatencyFriendly:   latency.Round(time.Second).String(),       // Friendly one-way latency
-		RoundTripFriendly: (2 * latency).Round(time.Second).String(), // Friendly round-trip latency
+		LatencyFriendly:   l.Duration(latency),                       // Friendly one-way latency
+		RoundTripFriendly: l.Duration(2 * latency),                   // Friendly round-trip latency
 		Domain:            FormatFullDomain(name),                    // Formatted domain using utility function
+		WebOrigin:         s.webOrigin(FormatFullDomain(name)),       // Links back to this instance
+		SOCKSPort:         s.socksPort(),                             // Bound SOCKS port, 1080 by default
 		MoonsHTML:         moonsHTML,                                 // Assign generated HTML
+		Impact:            impactRows(l, CalculateProtocolImpact(latency)),
+		L:                 l,
+	}
+	down, _ := dataRates(targetObject) // defaults when the body wasn't found
+	data.DownlinkRate = formatBitRate(down)
+	data.Transfer = transferRows(l, down, latency)
+	data.Scenarios = whatIfLinks(l, name)
+	if epoch, pinned := pinnedEpoch(); pinned {
+		data.PinnedEpoch = epoch.Format(l.T("format.datetime"))
+	}
+	if s.tcpWindow > 0 && latency > 0 {
+		data.TCPWindow = l.T("info.tcp_window", l.Number(float64(s.tcpWindow)/1024, 0), formatBitRate(windowThroughput(s.tcpWindow, 2*latency)))
+	}
+	if budget, ok := CalculateLinkBudget(targetObject, distance); ok {
+		data.LinkBudget = linkBudgetRows(l, budget)
+		data.LinkMarginal = budget.Marginal()
+	}
+	if lt, ok := spacecraftLightTimes(targetObject, snap.Objects); ok {
+		data.LightTimes = lightTimeRows(l, lt)
+	}
+	if pass, ok := nextCloseApproach(targetObject, simTime(distanceClock())); ok {
+		data.CloseApproach = closeApproachRows(l, pass)
+	}
+	if weather := bodyWeather(name, snap.Objects, linkTime()); !weather.clear() {
+		data.SpaceWeather = spaceWeatherRows(l, weather, down)
+	}
+	var tooClose *BodyTooCloseError
+	if errors.As(checkLatencyFloor(name, latency, s.security.minLatency), &tooClose) {
+		if tooClose.Resumes.IsZero() {
+			data.TooClose = l.T("info.too_close_later", name, l.Duration(tooClose.Floor))
+		} else {
+			data.TooClose = l.T("info.too_close", name, l.Duration(tooClose.Floor), tooClose.Resumes.UTC().Format(l.T("format.datetime")))
+		}
+	}
+	if targetFound {
+		data.MOTD = targetObject.MOTD
+		data.Fact = rotatingFact(targetObject.Facts, s.now())
 	}
 
 	// Set occlusion status and class based on calculated data
-	if occluded {
+	switch {
+	case visibility.Blocked():
 		data.OccludedClass = "status-occluded"
 		if occluderName != "" {
-			data.OccludedStatus = fmt.Sprintf("Occluded by %s", occluderName)
+			data.OccludedStatus = l.T("status.occluded_by", occluderName)
 		} else {
-			data.OccludedStatus = "Occluded (Unknown Occluder)" // Fallback if occluder name is missing
+			data.OccludedStatus = l.T("status.occluded") // Fallback if occluder name is missing
 			log.Printf("Warning: Occlusion detected for %s but occluder name is empty.", name)
 		}
-	} else {
+	case visibility == VisibilityDegraded:
+		data.OccludedClass = "status-degraded"
+		data.OccludedStatus = l.T("status.degraded_near", occluderName)
+	default:
 		data.OccludedClass = "status-visible"
-		data.OccludedStatus = "Visible"
+		data.OccludedStatus = l.T("status.visible")
+	}
+
+	now := linkTime()
+	if start, end, scheduled := contactSchedule(name).Pass(now); scheduled {
+		if start.After(now) {
+			data.ContactStatus = l.T("dsn.next", start.Format(l.T("format.datetime")))
+		} else {
+			data.ContactStatus = l.T("dsn.until", end.Format(l.T("format.datetime")))
+		}
+	}
+
+	// A HEAD gets the headers alone; rendering a page only to discard it
+	// would also leave Content-Length to disagree with the missing body.
+	if r.Method == http.MethodHead {
+		w.Header().Set(bodySkippedHeader, "true")
+		w.WriteHeader(http.StatusOK)
+		return
 	}
 
 	// 4. Execute Template
 	// Use the globally parsed infoTemplate
-	err := infoTemplate.Execute(w, data)
+	err = infoTemplate.Execute(w, data)
 	if err != nil {
 		// Log the error
 		log.Printf("Error executing info page template for %s: %v", name, err)
 		// Attempt to send an error to the client, but only if headers haven't been written.
 		// The template engine might have already started writing, so this might fail silently
 		// or cause a "superfluous response.WriteHeader call" log, which is acceptable here.
-		http.Error(w, "Failed to render information page", http.StatusInternalServerError)
+		http.Error(w, l.T("error.render"), http.StatusInternalServerError)
 		return // Stop further processing
 	}
 	// Note: No need to call w.WriteHeader(http.StatusOK) as Execute does this implicitly on success.
 }
 
-// resolveCelestialHost resolves a latency.space hostname to the name of the
+// tracedResolveHost is parseCelestialHost inside a host.parse span.
+func (s *Server) tracedResolveHost(ctx context.Context, objects []CelestialObject, host string) (string, error) {
+	_, span := startSpan(ctx, "host.parse", attr("http.host", host))
+	bodyName, err := parseCelestialHost(objects, host)
+	span.SetAttr("celestial.body", bodyName)
+	span.End()
+	return bodyName, err
+}
+
+// resolveCelestialHost is parseCelestialHost for callers that only need the
+// body: it returns "" if the host names none.
+func (s *Server) resolveCelestialHost(objects []CelestialObject, host string) string {
+	bodyName, _ := parseCelestialHost(objects, host)
+	return bodyName
+}
+
+// Reasons a hostname names no body, for hostError.Reason. They are sent to
+// clients, so keep them stable.
+const (
+	hostNotLatencySpace = "not_latency_space"   // not under latency.space
+	hostTooManyLabels   = "too_many_labels"     // e.g. a target in front of a body
+	hostUnknownLabel    = "unknown_label"       // a label names no body
+	hostMoonNoParent    = "moon_without_parent" // phobos.latency.space
+	hostNotAMoon        = "not_a_moon"          // mars.jupiter.latency.space
+	hostParentMismatch  = "parent_mismatch"     // phobos.jupiter.latency.space
+)
+
+// unknownBodyCode is the error code for a host that names no body.
+const unknownBodyCode = "UNKNOWN_BODY"
+
+// hostError says which rule a hostname failed in parseCelestialHost.
+type hostError struct {
+	Host   string
+	Reason string
+	Label  string // the label at fault, if any
+	Moon   string // for the moon reasons, the moon named
+	Parent string // for the moon reasons, the body the moon orbits
+}
+
+func (e *hostError) Error() string {
+	switch e.Reason {
+	case hostTooManyLabels:
+		return fmt.Sprintf("%s: too many labels; a body is body.latency.space or moon.parent.latency.space", e.Host)
+	case hostUnknownLabel:
+		return fmt.Sprintf("%s: %q names no body", e.Host, e.Label)
+	case hostMoonNoParent:
+		return fmt.Sprintf("%s: %s is a moon, at %s", e.Host, e.Moon, FormatMoonDomain(e.Moon, e.Parent))
+	case hostNotAMoon:
+		return fmt.Sprintf("%s: %q is not a moon", e.Host, e.Label)
+	case hostParentMismatch:
+		return fmt.Sprintf("%s: %s orbits %s, not %q", e.Host, e.Moon, e.Parent, e.Label)
+	}
+	return fmt.Sprintf("%s is not under latency.space", e.Host)
+}
+
+// message is the error in l's language.
+func (e *hostError) message(l Locale) string {
+	switch e.Reason {
+	case hostTooManyLabels:
+		return l.T("host.too_many_labels")
+	case hostUnknownLabel:
+		return l.T("host.unknown_label", e.Label)
+	case hostMoonNoParent:
+		return l.T("host.moon_without_parent", e.Moon, FormatMoonDomain(e.Moon, e.Parent))
+	case hostNotAMoon:
+		return l.T("host.not_a_moon", e.Label)
+	case hostParentMismatch:
+		return l.T("host.parent_mismatch", e.Moon, e.Parent, e.Label)
+	}
+	return l.T("host.not_latency_space")
+}
+
+// parseCelestialHost resolves a latency.space hostname to the name of the
 // celestial body (or moon) it identifies, for that body's information page.
 // Only two hostname shapes are recognised:
 //
 //	body.latency.space           - any non-moon body (planet, dwarf planet, spacecraft, ...)
-//	moon.planet.latency.space    - a moon, validated against its parent planet
+//	moon.parent.latency.space    - a moon, under the body it orbits
 //
-// The former target-embedding shapes (target.body.latency.space) are gone on
-// purpose: a dotted target under a body can be covered by neither a DNS nor a
-// TLS wildcard, so those hostnames never resolved. Actual proxying is done over
-// SOCKS, not by embedding a target in the hostname. Returns "" if the host does
-// not name a known body.
-func (s *Server) resolveCelestialHost(host string) string {
+// The moon's parent may be any type of body (Dimorphos orbits the asteroid
+// Didymos); only the name must match. The former target-embedding shapes
+// (target.body.latency.space) are gone on purpose: a dotted target under a
+// body can be covered by neither a DNS nor a TLS wildcard, so those
+// hostnames never resolved. Actual proxying is done over SOCKS, not by
+// embedding a target in the hostname. A host that names no body gets a
+// *hostError saying which rule it failed.
+func parseCelestialHost(objects []CelestialObject, host string) (string, error) {
 	// Remove port from host if present
 	if idx := strings.Index(host, ":"); idx > 0 {
 		host = host[:idx]
 	}
-
-	// Ensure celestial objects are initialized
-	if getCelestialObjects() == nil {
-		setCelestialObjects(celestial.InitSolarSystemObjects())
+	fail := func(reason, label string) (string, error) {
+		return "", &hostError{Host: strings.ToLower(host), Reason: reason, Label: label}
 	}
 
 	// Must end with ".latency.space" (case-insensitive)
 	suffix := ".latency.space"
-	if len(host) < len(suffix) || !strings.EqualFold(host[len(host)-len(suffix):], suffix) {
-		return ""
-	}
-
-	parts := strings.Split(host, ".")
-	numParts := len(parts)
-	if numParts < 3 || !strings.EqualFold(parts[numParts-1], "space") || !strings.EqualFold(parts[numParts-2], "latency") {
-		return ""
+	if len(host) <= len(suffix) || !strings.EqualFold(host[len(host)-len(suffix):], suffix) {
+		return fail(hostNotLatencySpace, "")
 	}
 
-	switch numParts {
+	parts := strings.Split(strings.ToLower(host), ".")
+	switch len(parts) {
 	case 3:
 		// body.latency.space - any non-moon body.
-		if body, found := findObjectByName(getCelestialObjects(), parts[0]); found && !strings.EqualFold(body.Type, "moon") {
-			return body.Name
+		body, found := findObjectByName(objects, parts[0])
+		if !found {
+			return fail(hostUnknownLabel, parts[0])
+		}
+		if body.Type == "moon" {
+			return "", &hostError{Host: strings.ToLower(host), Reason: hostMoonNoParent, Label: parts[0], Moon: body.Name, Parent: body.ParentName}
 		}
+		return body.Name, nil
 	case 4:
-		// moon.planet.latency.space - moon validated against its parent planet.
-		moon, moonFound := findObjectByName(getCelestialObjects(), parts[0])
-		planet, planetFound := findObjectByName(getCelestialObjects(), parts[1])
-		if moonFound && planetFound &&
-			moon.Type == "moon" &&
-			(planet.Type == "planet" || planet.Type == "dwarf_planet") &&
-			strings.EqualFold(moon.ParentName, planet.Name) {
-			return moon.Name
+		// moon.parent.latency.space - moon validated against its parent.
+		moon, found := findObjectByName(objects, parts[0])
+		if !found {
+			return fail(hostUnknownLabel, parts[0])
 		}
+		parent, found := findObjectByName(objects, parts[1])
+		if !found {
+			return fail(hostUnknownLabel, parts[1])
+		}
+		if moon.Type != "moon" {
+			return fail(hostNotAMoon, parts[0])
+		}
+		if !strings.EqualFold(moon.ParentName, parent.Name) {
+			return "", &hostError{Host: strings.ToLower(host), Reason: hostParentMismatch, Label: parts[1], Moon: moon.Name, Parent: moon.ParentName}
+		}
+		return moon.Name, nil
 	}
-
-	return ""
+	return fail(hostTooManyLabels, "")
 }
 
 func (s *Server) startHTTPServer() error {
-	addr := fmt.Sprintf(":%d", s.port)
-	s.httpServer = &http.Server{
-		Addr:         addr,
-		Handler:      http.HandlerFunc(s.handleHTTP),
-		ReadTimeout:  60 * time.Minute,  // Increased for distant celestial bodies
-		WriteTimeout: 60 * time.Minute,  // Increased for distant celestial bodies
-		IdleTimeout:  120 * time.Minute, // Allow long-lived connections
-	}
-
-	log.Printf("Starting HTTP server on %s", addr)
-	err := s.httpServer.ListenAndServe()
+	log.Printf("Starting HTTP server on %s", s.httpListener.Addr())
+	err := s.httpServer.Serve(s.httpListener)
 	log.Printf("HTTP server stopped: %v", err) // This will tell you if the server stops
 	return err
 }
 
 func (s *Server) startHTTPSServer() error {
-	nullLogger := log.New(io.Discard, "", 0)
-	s.httpsServer = &http.Server{
-		Addr:         ":443",
-		Handler:      http.HandlerFunc(s.handleHTTP),
-		TLSConfig:    setupTLS(),
-		ErrorLog:     nullLogger,        // don't really need these errors right now
-		ReadTimeout:  60 * time.Minute,  // Increased for distant celestial bodies
-		WriteTimeout: 60 * time.Minute,  // Increased for distant celestial bodies
-		IdleTimeout:  120 * time.Minute, // Allow long-lived connections
+	log.Printf("Starting HTTPS server on %s", s.httpsListener.Addr())
+	return s.httpsServer.ServeTLS(s.httpsListener, "", "") // Certificates handled by autocert
+}
+
+// hostWithPort appends ln's port to host unless it is defaultPort (or ln is
+// nil), so the default deployment keeps clean URLs.
+func hostWithPort(host string, ln net.Listener, defaultPort int) string {
+	if ln == nil {
+		return host
+	}
+	if a, ok := ln.Addr().(*net.TCPAddr); ok && a.Port != defaultPort {
+		return net.JoinHostPort(host, strconv.Itoa(a.Port))
 	}
+	return host
+}
 
-	log.Printf("Starting HTTPS server on :443")
-	return s.httpsServer.ListenAndServeTLS("", "") // Certificates handled by autocert
+// webOrigin is the origin for links to domain on this instance: HTTPS when
+// it is served, plain HTTP when only that is.
+func (s *Server) webOrigin(domain string) string {
+	if s.httpsListener == nil && s.httpListener != nil {
+		return "http://" + hostWithPort(domain, s.httpListener, 80)
+	}
+	return "https://" + hostWithPort(domain, s.httpsListener, 443)
 }
 
-func (s *Server) startSOCKSServer() error {
-	// Start SOCKS5 server on port 1080
-	// Create a custom TCP listener with extended keepalive settings
-	tcpAddr, err := net.ResolveTCPAddr("tcp", ":1080")
-	if err != nil {
-		return fmt.Errorf("failed to resolve TCP address: %v", err)
+// socksPort is the SOCKS5 port advertised on the info page and in the PAC:
+// the bound one, else the port in -socks-addr, else the standard 1080 when
+// this instance doesn't run SOCKS itself.
+func (s *Server) socksPort() int {
+	if s.socksListener != nil {
+		if a, ok := s.socksListener.Addr().(*net.TCPAddr); ok {
+			return a.Port
+		}
 	}
+	if _, p, err := net.SplitHostPort(s.socksAddr); err == nil {
+		if port, err := strconv.Atoi(p); err == nil && port > 0 {
+			return port
+		}
+	}
+	return 1080
+}
 
-	listener, err := net.ListenTCP("tcp", tcpAddr)
+// listenHTTP opens an HTTP(S) listener, expecting PROXY protocol headers
+// when -proxy-protocol-http is set and capping connections per IP otherwise.
+// A trusted proxy isn't capped: it is every client it forwards.
+func (s *Server) listenHTTP(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		return fmt.Errorf("failed to listen on SOCKS port: %v", err)
+		return nil, err
 	}
+	if s.proxyProtocolHTTP {
+		log.Printf("HTTP on %s: expecting PROXY protocol headers", addr)
+		return proxyListener{ln}, nil
+	}
+	return s.connLimit.Listener(ln, s.trustedProxies), nil
+}
 
+func (s *Server) startSOCKSServer() error {
+	listener := s.socksListener
 	log.Printf("SOCKS server using extended timeouts for interplanetary latency")
-	s.socksListener = listener
-
-	log.Printf("Starting SOCKS5 server on :1080")
+	log.Printf("Starting SOCKS5 server on %s", listener.Addr())
 
 	for {
 		conn, err := listener.Accept()
@@ -504,7 +1218,7 @@ func (s *Server) startSOCKSServer() error {
 		}
 
 		// Configure extended timeouts for TCP connections
-		if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if tcpConn, ok := tcpConnOf(conn); ok {
 			// Set keep-alive with a long period suitable for celestial distances
 			if err := tcpConn.SetKeepAlive(true); err != nil {
 				log.Printf("Warning: Failed to set TCP keepalive: %v", err)
@@ -521,55 +1235,60 @@ func (s *Server) startSOCKSServer() error {
 			log.Printf("SOCKS: Configured extended timeouts for connection from %s", conn.RemoteAddr().String())
 		}
 
-		// Get client IP for rate limiting
-		ip := clientIP(conn.RemoteAddr().String())
-
-		if !s.security.IsAllowedIP(ip) {
-			conn.Close()
-			continue
-		}
-
-		// Abuse control: per-IP rate and concurrency limits. SOCKS bypasses
-		// the front-end nginx, so this is the only such control on this path.
-		release, err := s.limiter.Acquire(ip)
-		if err != nil {
-			log.Printf("SOCKS connection from %s rejected: %v", ip, err)
-			conn.Close()
-			continue
+		// The PROXY header (and so the real client address) is read in the
+		// connection's goroutine, so a slow client can't stall Accept.
+		if s.proxyProtocol {
+			conn = newProxyConn(conn)
 		}
-
-		// Handle the connection in a goroutine
-		// Pass the fixed celestial body if configured
-		go func() {
-			defer release()
-			NewSOCKSHandler(conn, s.security, s.metrics, s.fixedCelestialBody).Handle()
-		}()
+		go s.serveSOCKSConn(conn)
 	}
 }
 
-// handleDebugEndpoint handles debug and info endpoints
-func (s *Server) handleDebugEndpoint(w http.ResponseWriter, r *http.Request) {
-	// Enable CORS for debug endpoints
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+// serveSOCKSConn applies the per-IP admission checks and runs the handler.
+func (s *Server) serveSOCKSConn(conn net.Conn) {
+	// Get client IP for rate limiting
+	ip := clientIP(conn.RemoteAddr().String())
 
-	// Extract the debug command
-	path := strings.TrimPrefix(r.URL.Path, "/_debug/")
+	if !s.security.IsAllowedIP(ip) {
+		conn.Close()
+		return
+	}
 
-	switch path {
-	case "metrics":
-		promhttp.Handler().ServeHTTP(w, r)
-	case "distances":
-		s.printCelestialDistances(w)
-	case "allowed-hosts":
-		s.printAllowedHosts(w)
-	case "help":
-		s.printHelp(w)
-	case "status":
-		s.printStatus(w)
-	default:
-		http.Error(w, "Unknown debug command: "+path, http.StatusNotFound)
+	// Abuse control: per-IP rate and concurrency limits. SOCKS bypasses
+	// the front-end nginx, so this is the only such control on this path.
+	release, err := s.limiter.Acquire(ip)
+	if err != nil {
+		log.Printf("SOCKS connection from %s rejected: %v", ip, err)
+		conn.Close()
+		return
+	}
+	defer release()
+
+	// Pass the fixed celestial body if configured
+	h := NewSOCKSHandler(conn, s.security, s.metrics, s.fixedCelestialBody, s.snapshots)
+	h.timing = s.timing
+	h.recent = s.recent
+	h.access = s.access
+	s.settingsMu.RLock()
+	h.udpLimits = s.udpLimits
+	h.udpImpair = s.udpImpair
+	s.settingsMu.RUnlock()
+	h.limiter = s.limiter
+	h.sessionLimit = s.sizeLimits.SOCKSSessionBytes
+	h.tcpWindow = s.tcpWindow
+	h.preflight = s.preflight
+	h.remoteDNS = s.remoteDNS
+	h.overhead = s.overhead
+	if !s.proxyProtocol {
+		// Without a PROXY header a balancer's connection hides the client.
+		h.trustedProxies = s.trustedProxies
 	}
+	h.Handle()
+}
+
+// handleDebugEndpoint handles debug and info endpoints (debugmux.go)
+func (s *Server) handleDebugEndpoint(w http.ResponseWriter, r *http.Request, snap *SystemSnapshot) {
+	debugMux.serve(s, w, r, snap)
 }
 
 // printStatus returns a small JSON summary of the running instance. This backs
@@ -589,18 +1308,47 @@ func (s *Server) printStatus(w http.ResponseWriter) {
 		"allowedPorts":     s.security.AllowedPorts(),
 		"dtnJobs":          s.dtn.Count(),
 	}
+	if epoch, pinned := pinnedEpoch(); pinned {
+		status["pinnedEpoch"] = epoch
+	}
 	if err := json.NewEncoder(w).Encode(status); err != nil {
 		log.Printf("printStatus: encode error: %v", err)
 	}
 }
 
+// printRecent renders the recent-transactions ring as JSON (default) or, with
+// ?format=text, a plain-text table. Filters: ?body=mars&outcome=denied&limit=100.
+func (s *Server) printRecent(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := RecentFilter{Body: q.Get("body"), Outcome: q.Get("outcome")}
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+	entries := s.recent.Snapshot(filter)
+
+	if q.Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain")
+		writeRecentText(w, entries)
+		return
+	}
+	if entries == nil {
+		entries = []RecentTransaction{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
 // printAllowedHosts lists the destination allowlist (hosts and ports) as JSON.
 // The proxy only relays to these hosts; operators can extend the list via the
 // ALLOWED_HOSTS environment variable.
 func (s *Server) printAllowedHosts(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 	payload := map[string]interface{}{
-		"note":  "The proxy only relays to these hosts (and their subdomains) on these ports. Extend via the ALLOWED_HOSTS env var or a PR to security.go.",
+		"note":  "The proxy only relays to these hosts (and their subdomains) on these ports. Extend via the ALLOWED_HOSTS env var, /_debug/security (with -security-config), or a PR to security.go.",
 		"hosts": s.security.AllowedHosts(),
 		"ports": s.security.AllowedPorts(),
 	}
@@ -612,60 +1360,123 @@ func (s *Server) printAllowedHosts(w http.ResponseWriter) {
 	_, _ = w.Write(jsonData)
 }
 
-// printCelestialDistances shows the current distances of all celestial bodies
-func (s *Server) printCelestialDistances(w http.ResponseWriter) {
+// printCelestialDistances shows the distances of all celestial bodies in snap
+func (s *Server) printCelestialDistances(w http.ResponseWriter, snap *SystemSnapshot) {
 	w.Header().Set("Content-Type", "text/plain")
 
 	fmt.Fprintln(w, "Latency Space - Current Celestial Distances")
 	fmt.Fprintln(w, "============================================")
-	fmt.Fprintf(w, "Current Time: %s\n\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(w, "Current Time: %s\n", s.now().Format(time.RFC3339))
+	if epoch, pinned := pinnedEpoch(); pinned {
+		fmt.Fprintf(w, "Simulation epoch pinned to %s\n", epoch.Format(time.RFC3339))
+	}
+	fmt.Fprintln(w)
 
-	// Call printObjectsByType without distanceEntries argument, as it now uses the global cache
-	printObjectsByType(w, "planet")
-	printObjectsByType(w, "moon")
-	printObjectsByType(w, "asteroid")
-	printObjectsByType(w, "dwarf_planet")
-	printObjectsByType(w, "spacecraft")
+	printObjectsByType(w, snap, "planet")
+	printObjectsByType(w, snap, "moon")
+	printObjectsByType(w, snap, "asteroid")
+	printObjectsByType(w, snap, "comet")
+	printObjectsByType(w, snap, "dwarf_planet")
+	printObjectsByType(w, snap, "spacecraft")
 
 }
 
-// handleStatusData provides celestial body status data as JSON
-func (s *Server) handleStatusData(w http.ResponseWriter, r *http.Request) {
+// newStatusRefreshLimiter builds the per-IP limiter for forced status
+// recomputes (?refresh=true).
+func newStatusRefreshLimiter() *RateLimiter {
+	return NewRateLimiter(
+		envFloat("STATUS_REFRESH_RATE_PER_MIN", 6),
+		envInt("STATUS_REFRESH_BURST", 2),
+		0, 0,
+	)
+}
+
+// handleStatusData provides the status data of snap as JSON
+func (s *Server) handleStatusData(w http.ResponseWriter, r *http.Request, snap *SystemSnapshot) {
 	// Set CORS and Content-Type headers
 	w.Header().Set("Access-Control-Allow-Origin", "*") // Allow requests from any origin
 	w.Header().Set("Content-Type", "application/json")
 
-	// Ensure distance data is up-to-date
-	now := time.Now()
-	calculateDistancesFromEarth(getCelestialObjects(), now) // Refresh cache
-	log.Printf("DEBUG: distanceEntries after calculation: %+v\n", distanceEntries)
+	// ?refresh=true forces a recompute of the hourly cache; it costs a full
+	// solve of every body, so it is rate limited per client.
+	if r.URL.Query().Get("refresh") == "true" {
+		release, err := s.refreshLimiter.Acquire(s.requestClientIP(r))
+		if err != nil {
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+			return
+		}
+		release()
+		invalidateDistanceCache()
+		var ok bool
+		if snap, ok = s.requestSnapshot(w, r); !ok {
+			return
+		}
+	}
+
+	response := buildStatusResponse(snap, distanceClock())
+	// The pre-categories keys, for one release (categories.go).
+	if r.URL.Query().Get("legacyKeys") == "true" {
+		response = withLegacyKeys(response)
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(legacyKeysDeprecated.Unix(), 10))
+	}
+
+	// Add debug log before marshaling
+	log.Printf("DEBUG: API Response data before marshaling: %+v\n", response)
+
+	// Marshal the response to JSON
+	jsonData, err := json.MarshalIndent(response, "", "  ") // Use Indent for readability
+	if err != nil {
+		log.Printf("Error marshaling status data to JSON: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Write the JSON response
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(jsonData)
+	if err != nil {
+		log.Printf("Error writing JSON response for status data: %v", err)
+	}
+}
+
+// buildStatusResponse assembles the status of every body in snap at now (or
+// at the pinned epoch).
+func buildStatusResponse(snap *SystemSnapshot, now time.Time) ApiResponse {
+	at := simTime(now)
+	objects, entries := snap.Objects, snap.Entries
+	earth, earthFound := snap.Find("Earth")
 
 	// Prepare the response structure
 	response := ApiResponse{
-		Timestamp: now,
-		Objects:   make(map[string][]StatusEntry),
+		Timestamp:  now,
+		ComputedAt: snap.ComputedAt,
+		Objects:    make(map[string][]StatusEntry),
+	}
+	if epoch, pinned := pinnedEpoch(); pinned {
+		response.Pinned = &epoch
 	}
-
-	// Acquire read lock to safely access distanceEntries
-	DistanceCacheMutex.RLock()
-	defer DistanceCacheMutex.RUnlock() // Ensure lock is released
 
 	// Populate the response data
-	for _, obj := range getCelestialObjects() {
-		if obj.Type == "star" { // Skip the Sun for this endpoint
-			continue
-		}
-
+	for _, obj := range objects {
 		// Find the corresponding distance entry by iterating through the slice (under read lock)
-		var distance float64
-		var occluded bool
+		var distance, geometric float64
+		var visibility Visibility
+		var fresh bool
 		var found bool // Flag to track if the entry was found
+		var fix *StationFix
 
-		for _, entry := range distanceEntries { // Accessing shared data
+		for _, entry := range entries {
 			// Compare names case-insensitively
 			if strings.EqualFold(entry.Object.Name, obj.Name) {
+				fix = stationFor(entry, at)
 				distance = entry.Distance
-				occluded = entry.Occluded
+				geometric = entry.Geometric
+				visibility = entry.Visibility
+				// Near the Sun visibility changes faster than the cache.
+				if earthFound && entry.Elongation < freshOcclusionElongationDeg {
+					visibility, _ = IsOccluded(earth, obj, objects, at)
+					fresh = true
+				}
 				found = true
 				break // Found the matching entry, exit the inner loop
 			}
@@ -682,79 +1493,170 @@ func (s *Server) handleStatusData(w http.ResponseWriter, r *http.Request) {
 
 		// Create the status entry using the found data
 		entry := StatusEntry{
-			Name:       obj.Name,
-			Type:       obj.Type,
-			ParentName: obj.ParentName,
-			Distance:   float64(int(distance*100)) / 100,              // Limit distance to 2 decimal places
-			Latency:    float64(int((latency/time.Second)*100)) / 100, // Limit latency to 2 decimal places
-			Occluded:   occluded,
+			Name:           obj.Name,
+			Type:           obj.Type,
+			ParentName:     obj.ParentName,
+			Distance:       float64(int(distance*100)) / 100, // Limit distance to 2 decimal places
+			Geometric:      float64(int(geometric*100)) / 100,
+			Latency:        float64(int(latency.Seconds()*100)) / 100, // Limit latency to 2 decimal places
+			Occluded:       visibility.Blocked(),
+			Visibility:     visibility.String(),
+			OcclusionFresh: fresh,
+		}
+		if fix != nil && fix.Visible {
+			entry.GroundStation = fix.Station
+			entry.StationElevation = float64(int(fix.Elevation*100)) / 100
+			entry.StationOffset = float64(int(fix.OffsetKm*100)) / 100
+		} else if fix != nil {
+			entry.NoStation = true
+		}
+		if budget, ok := CalculateLinkBudget(obj, distance); ok {
+			entry.LinkMarginal = budget.Marginal()
+		}
+		if src := weatherSource(); src != nil {
+			entry.SpaceWeather = src.Weather(obj.Name, objects, at).report(src.Name())
+		}
+		if start, end, scheduled := contactSchedule(obj.Name).Pass(at); scheduled {
+			entry.PassStart, entry.PassEnd = &start, &end
+			entry.NoContact = start.After(at)
 		}
 
-		// Group objects by type
-		objectTypeKey := obj.Type + "s" // e.g., "planets", "moons"
-		response.Objects[objectTypeKey] = append(response.Objects[objectTypeKey], entry)
-	}
-
-	// Add debug log before marshaling
-	log.Printf("DEBUG: API Response data before marshaling: %+v\n", response)
-
-	// Marshal the response to JSON
-	jsonData, err := json.MarshalIndent(response, "", "  ") // Use Indent for readability
-	if err != nil {
-		log.Printf("Error marshaling status data to JSON: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
-	}
+		// The Sun isn't a destination; it gets its own section.
+		if obj.Type == "star" {
+			response.Sun = &entry
+			continue
+		}
 
-	// Write the JSON response
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(jsonData)
-	if err != nil {
-		log.Printf("Error writing JSON response for status data: %v", err)
+		// Group objects by category
+		key := categoryKey(obj.Type)
+		response.Objects[key] = append(response.Objects[key], entry)
 	}
+	response.Categories = categoriesOf(response.Objects)
+	return response
 }
 
-// printHelp displays usage information
-func (s *Server) printHelp(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "text/plain")
+// printHelp displays usage information in l. Commands and URLs are the
+// same in every language; the debug endpoint notes are for operators and
+// are only translated where a catalog has them.
+func (s *Server) printHelp(w http.ResponseWriter, l Locale) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	setContentLanguage(w, l)
+
+	heading := func(key string, underline string) {
+		title := l.T(key)
+		fmt.Fprintln(w, title)
+		fmt.Fprintln(w, strings.Repeat(underline, utf8.RuneCountInString(title)))
+	}
 
-	fmt.Fprintln(w, "Latency Space - Interplanetary Internet Simulator")
-	fmt.Fprintln(w, "===============================================")
+	heading("help.title", "=")
 	fmt.Fprintln(w, "")
-	fmt.Fprintln(w, "This service simulates the latency of Internet access from different")
-	fmt.Fprintln(w, "celestial bodies in our solar system.")
+	fmt.Fprintln(w, l.T("help.intro"))
 	fmt.Fprintln(w, "")
-	fmt.Fprintln(w, "Proxy Usage (SOCKS5):")
-	fmt.Fprintln(w, "---------------------")
-	fmt.Fprintln(w, "Proxying is done over SOCKS5, one port per body (Mars 1080, Moon 1081, ...).")
+	heading("help.socks", "-")
+	fmt.Fprintln(w, l.T("help.socks_ports"))
 	fmt.Fprintln(w, "  curl --socks5-hostname mars.latency.space:1080 https://example.com")
-	fmt.Fprintln(w, "TCP via CONNECT, UDP via UDP ASSOCIATE. Near bodies only - distant bodies")
-	fmt.Fprintln(w, "have latencies that exceed normal client timeouts.")
+	fmt.Fprintln(w, l.T("help.socks_note"))
 	fmt.Fprintln(w, "")
-	fmt.Fprintln(w, "Body Pages (HTTP, informational):")
-	fmt.Fprintln(w, "---------------------------------")
-	fmt.Fprintln(w, "https://mars.latency.space/           - a body's info page")
-	fmt.Fprintln(w, "https://phobos.mars.latency.space/    - a moon (under its planet)")
+	heading("help.pages", "-")
+	fmt.Fprintln(w, "https://mars.latency.space/           - "+l.T("help.page_body"))
+	fmt.Fprintln(w, "https://phobos.mars.latency.space/    - "+l.T("help.page_moon"))
 	fmt.Fprintln(w, "")
-	fmt.Fprintln(w, "Store-and-Forward (DTN) - for distant bodies:")
-	fmt.Fprintln(w, "---------------------------------------------")
-	fmt.Fprintln(w, "Distant bodies (hours/days away) exceed live-proxy timeouts, so requests")
-	fmt.Fprintln(w, "are delivered asynchronously - submit now, poll for the response later:")
+	heading("help.dtn", "-")
+	fmt.Fprintln(w, l.T("help.dtn_intro"))
 	fmt.Fprintln(w, "  POST https://voyager-1.latency.space/dtn/send   {\"url\":\"https://example.com/\"}")
 	fmt.Fprintln(w, "  GET  https://voyager-1.latency.space/dtn/status/{id}")
 	fmt.Fprintln(w, "")
-	fmt.Fprintln(w, "Debug Endpoints:")
-	fmt.Fprintln(w, "---------------")
-	fmt.Fprintln(w, "/_debug/distances - Current distances and latencies")
-	fmt.Fprintln(w, "/_debug/allowed-hosts - Destination allowlist (hosts and ports)")
-	fmt.Fprintln(w, "/_debug/help - This help information")
+	heading("help.distance", "-")
+	fmt.Fprintln(w, "  GET /api/distance?from=europa&to=enceladus[&t=2030-01-01T00:00:00Z]")
+	fmt.Fprintln(w, "  GET /api/matrix?types=planet,moon[&occlusion=true][&t=2030-01-01T00:00:00Z]")
+	fmt.Fprintln(w, "  GET /api/forecast?body=mars&days=30&format=ical")
+	fmt.Fprintln(w, "")
+	heading("help.schema", "-")
+	fmt.Fprintln(w, "  GET /api/openapi.json - "+l.T("help.schema_line"))
+	fmt.Fprintln(w, "")
+	heading("help.health", "-")
+	fmt.Fprintln(w, "  GET /healthz - "+l.T("help.healthz"))
+	fmt.Fprintln(w, "  GET /readyz  - "+l.T("help.readyz"))
+	fmt.Fprintln(w, "")
+	heading("help.debug", "-")
+	fmt.Fprintln(w, "/_debug/distances - "+l.T("help.debug_distances"))
+	fmt.Fprintln(w, "/_debug/allowed-hosts - "+l.T("help.debug_allowed"))
+	fmt.Fprintln(w, "/_debug/help - "+l.T("help.debug_help"))
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, l.T("help.language"))
 }
 
 func main() {
 	// Parse command-line arguments
-	port := flag.Int("port", 80, "HTTP port to listen on")
+	port := flag.Int("port", 80, "HTTP port to listen on (superseded by -http-addr)")
 	https := flag.Bool("https", true, "Enable HTTPS")
+	httpAddr := flag.String("http-addr", ":80", "HTTP listen address; empty disables HTTP (default derived from -port)")
+	httpsAddr := flag.String("https-addr", ":443", "HTTPS listen address; empty disables HTTPS")
+	socksAddr := flag.String("socks-addr", ":1080", "SOCKS5 listen address; empty disables SOCKS5")
+	recentSize := flag.Int("recent-size", defaultRecentSize, "Number of recent transactions kept for /_debug/recent")
+	anonymizeIPs := flag.Bool("anonymize-ips", false, "Truncate client IPs recorded in /_debug/recent and the access log")
+	accessLogPath := flag.String("access-log", "", "File for access lines in extended Common Log Format, e.g. /var/log/latency-space/access.log (empty = disabled)")
+	accessLogMaxSize := flag.Int64("access-log-max-size", 100, "Rotate the access log past this many MB (0 = never)")
+	accessLogMaxAge := flag.Duration("access-log-max-age", 24*time.Hour, "Rotate the access log once it is this old (0 = never)")
+	cacheFile := flag.String("cache-file", "", "File the distance table is saved to on shutdown and served from at boot while a fresh one is computed (empty = disabled)")
+	objectsFile := flag.String("objects-file", "", "JSON file of celestial objects merged by name over the built-in list")
+	skipObjectValidation := flag.Bool("skip-object-validation", false, "Start even if the built-in celestial objects fail validation (the errors are logged)")
+	allowPrivate := flag.Bool("allow-private", false, "Let SOCKS clients reach private-network IP addresses (10/8, 172.16/12, 192.168/16, fc00::/7), e.g. 10-0-0-5.mars.latency.space for lab demos")
+	securityConfig := flag.String("security-config", "", "JSON file of allowed destination hosts and ports, replacing the built-in lists; /_debug/security edits are saved to it")
+	simSeed := flag.Int64("sim-seed", 0, "Seed for simulated link randomness such as UDP loss (0 = seed from the clock)")
+	tcpForwardSpec := flag.String("tcp-forward", "", "Static TCP forwards, e.g. mars:2222=github.com:22,europa:5432=db.example.com:5432")
+	upstreamConnect := flag.Duration("upstream-connect-timeout", defaultUpstreamTimeouts.Connect, "Upstream dial + TLS handshake timeout, independent of simulated latency")
+	upstreamHeader := flag.Duration("upstream-header-timeout", defaultUpstreamTimeouts.Header, "Upstream response header timeout")
+	requestMaxSize := flag.Int64("request-max-size", defaultSizeLimits.RequestBytes>>20, "Max request body in MB via a planet, moon or other natural body (0 = unlimited)")
+	spacecraftRequestMaxSize := flag.Int64("request-max-size-spacecraft", defaultSizeLimits.SpacecraftRequestBytes>>20, "Max request body in MB via a spacecraft (0 = unlimited)")
+	responseMaxSize := flag.Int64("response-max-size", defaultSizeLimits.ResponseBytes>>20, "Max response body in MB kept per request; the rest is dropped and the response marked truncated")
+	socksSessionMaxSize := flag.Int64("socks-session-max-size", defaultSizeLimits.SOCKSSessionBytes>>20, "Max MB carried both ways by one SOCKS CONNECT session before it is closed (0 = unlimited)")
+	upstreamRetries := flag.Int("upstream-retries", defaultUpstreamRetries, "Retries of transient upstream failures (reset/refused connections, 502/503/504) for GET/HEAD/OPTIONS; 0 disables")
+	degradedRadii := flag.String("degraded-limb-radii", "star=4", "Per occluder type, how many of its radii past the limb a line of sight counts as degraded (lossy but usable), e.g. star=4,planet=0.1")
+	minOccluder := flag.Float64("min-occluder-angular-radius", minOccluderAngularRadius, "Smallest angular radius (radians) a body needs, seen from the observer, to be checked as an occluder; 0 checks every body")
+	approachHorizon := flag.Duration("close-approach-horizon", defaultCloseApproachHorizon, "How far ahead near-Earth asteroid close approaches are predicted, for info pages, /api/bodies and BODY_TOO_CLOSE refusals")
+	marsGrazing := flag.Float64("mars-grazing-margin", parentGrazingMarginDeg["Mars"], "Degrees below the Martian horizon a link to a surface asset still gets through (refraction)")
+	httpHeaderTimeout := flag.Duration("http-header-timeout", defaultHTTPHeaderTimeout, "Time an HTTP(S) client has to send its request headers; ordinary requests then get 30s")
+	simulateTCPWindows := flag.Bool("simulate-tcp-windows", false, "Interplanetary Internet mode: cap unacknowledged bytes in flight on SOCKS and forwarded TCP relays as a real TCP window would, so throughput drops to window/RTT")
+	tcpWindow := flag.Int("tcp-window", defaultTCPWindow, "Window in bytes for -simulate-tcp-windows")
+	remoteDNSLatency := flag.Bool("remote-dns-latency", false, "Resolve SOCKS target names in the proxy after a round trip of the body's latency, as a client there would, caching answers per body for their TTL")
+	coalesceGets := flag.Bool("coalesce-gets", false, "Let identical DTN GETs via the same body share one upstream fetch when they are in flight together; each job keeps its own light time")
+	noPreflight := flag.Bool("no-preflight", false, "Don't probe SOCKS and DTN targets directly before the latency sleep; a down target then fails only after it")
+	preflightTTL := flag.Duration("preflight-ttl", defaultPreflightTTL, "How long a pre-flight probe result, or a real dial, vouches for a target")
+	overheadPct := flag.Float64("overhead-sampling-pct", defaultOverheadSamplingPct, "Percentage of proxied SOCKS and DTN requests whose real time outside the simulated delays is recorded in latency_space_overhead_seconds (0-100)")
+	connsPerIP := flag.Int("conns-per-ip", defaultConnsPerIP, "Open connections allowed per client IP across the HTTP, HTTPS and SOCKS listeners, counted at accept (0 = unlimited; not applied behind PROXY protocol)")
+	proxyProtocol := flag.Bool("proxy-protocol", false, "Require a PROXY protocol v1/v2 header on SOCKS connections (behind HAProxy/nginx stream)")
+	proxyProtocolHTTP := flag.Bool("proxy-protocol-http", false, "Require a PROXY protocol v1/v2 header on HTTP/HTTPS connections")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated CIDRs whose X-Forwarded-For/Forwarded/X-Forwarded-Host headers are trusted; SOCKS connections from them need -proxy-protocol")
+	contactSpec := flag.String("contact-schedule", "", "DSN contact windows per body, e.g. voyager-1=04:00-08:00UTC,16:00-18:00UTC;mars=Mon-Fri 09:00-17:00UTC")
+	contactFile := flag.String("contact-schedule-file", "", "File of DSN contact windows, one body=windows entry per line")
+	fingerAddr := flag.String("finger", "", "Finger (RFC 1288) listen address, e.g. :79 (empty = disabled)")
+	gopherPort := flag.Int("gopher-port", 0, "Gopher (RFC 1436) listen port, e.g. 70 (0 = disabled)")
+	gopherHost := flag.String("gopher-host", "latency.space", "Host named in Gopher menu items, so clients can follow them")
+	timeAddr := flag.String("time-udp", "", "RFC 868 time listen address (UDP), e.g. :37; replies arrive one light time late (empty = disabled)")
+	timeBody := flag.String("time-udp-body", "", "Body answered for when a -time-udp request names none (default CELESTIAL_BODY, else mars)")
+	tracing := flag.Bool("tracing", false, "Export per-request timing spans over OTLP/HTTP (configured by the OTEL_EXPORTER_OTLP_* variables)")
+	fixedEpoch := flag.String("fixed-epoch", "", "Freeze positions, distances, occlusion and contact windows at this RFC3339 instant, e.g. 2025-11-05T00:00:00Z (empty = follow the clock)")
+	spaceWeather := flag.String("space-weather", "off", "Link degradation from space weather: off, synthetic (Martian dust storms and the solar cycle, seeded by -sim-seed) or a file or URL of NOAA planetary K-index JSON")
+	groundStation := flag.String("ground-station", "off", "Measure latency from a DSN ground station: off (Earth's centre), auto (best placed), goldstone, madrid or canberra")
+	traceSampleRatio := flag.Float64("trace-sample-ratio", 1.0, "Fraction of requests and SOCKS connections traced with -tracing (0-1)")
+	adminCIDRs := flag.String("admin-cidrs", "", "Comma-separated CIDRs the admin-only /_debug endpoints answer, e.g. 127.0.0.0/8,10.0.0.0/8; others get a 404 even with the token (empty = any address)")
+	adminToken := flag.String("admin-token", "", "Operator token for the admin-only /_debug endpoints (default $ADMIN_TOKEN); better set in -config than on the command line")
+	configPath := flag.String("config", "", "YAML file of flag settings, e.g. /etc/latency-space/config.yaml; flags given on the command line win")
+	acmeWebroot := flag.String("acme-webroot", "", "Serve ACME HTTP-01 challenges from this directory, as written by certbot --webroot -w DIR")
+	acmeResponder := flag.String("acme-responder", "", "Pass ACME HTTP-01 challenges to this local responder, e.g. 127.0.0.1:8402 for certbot --standalone --http-01-port 8402")
+	printConfig := flag.Bool("print-config", false, "Print the effective settings, with secrets redacted, and exit")
+	defineHotFlags(flag.CommandLine)
 	flag.Parse()
+	config, err := loadConfig(flag.CommandLine, *configPath)
+	if err != nil {
+		log.Fatalf("Invalid -config: %v", err)
+	}
+	if *printConfig {
+		writeConfig(os.Stdout, flag.CommandLine)
+		return
+	}
+	debugLogging.Store(flag.Lookup("debug").Value.String() == "true")
 
 	// Read environment variables for configuration
 	fixedCelestialBody := os.Getenv("CELESTIAL_BODY")
@@ -777,7 +1679,6 @@ func main() {
 	log.Printf("==============================================")
 
 	// Parse the info page template at startup (only if HTTP enabled)
-	var err error
 	if httpEnabled {
 		// Try different paths for the template (container paths first, then local development paths)
 		templatePaths := []string{
@@ -792,6 +1693,8 @@ func main() {
 			infoTemplate, templateErr = template.ParseFiles(path)
 			if templateErr == nil {
 				log.Printf("Successfully loaded template from: %s", path)
+				// The Sun's page sits beside it.
+				sunTemplate, templateErr = template.ParseFiles(filepath.Join(filepath.Dir(path), "sun_page.html"))
 				break
 			}
 		}
@@ -799,12 +1702,74 @@ func main() {
 		if infoTemplate == nil {
 			log.Fatalf("Failed to parse info page template: %v", templateErr)
 		}
+		if sunTemplate == nil {
+			log.Fatalf("Failed to parse Sun page template: %v", templateErr)
+		}
 	} else {
 		log.Printf("HTTP disabled, skipping template loading")
 	}
 
+	parentGrazingMarginDeg["Mars"] = *marsGrazing
+	if degradedLimbRadii, err = parseDegradedLimbRadii(*degradedRadii); err != nil {
+		log.Fatalf("Invalid -degraded-limb-radii: %v", err)
+	}
+	if *minOccluder < 0 {
+		log.Fatalf("Invalid -min-occluder-angular-radius %v: must not be negative", *minOccluder)
+	}
+	minOccluderAngularRadius = *minOccluder
+	if *approachHorizon < 24*time.Hour || *approachHorizon > maxCloseApproachHorizon {
+		log.Fatalf("Invalid -close-approach-horizon %v: must be between a day and %v", *approachHorizon, maxCloseApproachHorizon)
+	}
+	closeApproachHorizon = *approachHorizon
+
 	// Initialize celestial objects for calculation
 	setCelestialObjects(celestial.InitSolarSystemObjects())
+	if err := validateObjects(getCelestialObjects()); err != nil {
+		if !*skipObjectValidation {
+			log.Fatalf("Invalid built-in celestial objects (fix them in shared/celestial, or start with -skip-object-validation):\n%v", err)
+		}
+		log.Printf("Warning: built-in celestial objects failed validation:\n%v", err)
+	}
+	if *objectsFile != "" {
+		objects, err := loadObjectsFile(*objectsFile, getCelestialObjects())
+		if err != nil {
+			log.Fatalf("Invalid -objects-file: %v", err)
+		}
+		setCelestialObjects(objects)
+		log.Printf("Loaded celestial objects from %s (%d total)", *objectsFile, len(objects))
+	}
+
+	// DSN contact windows; bodies without one are always in contact.
+	if *contactFile != "" {
+		entries, err := loadContactScheduleFile(*contactFile)
+		if err != nil {
+			log.Fatalf("Invalid -contact-schedule-file: %v", err)
+		}
+		*contactSpec = entries + ";" + *contactSpec
+	}
+	schedules, err := parseContactSchedules(*contactSpec, getCelestialObjects())
+	if err != nil {
+		log.Fatalf("Invalid contact schedule: %v", err)
+	}
+	setContactSchedules(schedules)
+
+	if *fixedEpoch != "" {
+		epoch, err := parseEpoch(*fixedEpoch)
+		if err != nil {
+			log.Fatalf("Invalid -fixed-epoch: %v", err)
+		}
+		setPinnedEpoch(epoch)
+		log.Printf("Simulation epoch pinned to %s", epoch.UTC().Format(time.RFC3339))
+	}
+
+	if *tracing {
+		if *traceSampleRatio < 0 || *traceSampleRatio > 1 {
+			log.Fatalf("Invalid -trace-sample-ratio %v: want 0-1", *traceSampleRatio)
+		}
+		exp := newOTLPExporterFromEnv()
+		setTracer(newTracer(exp, *traceSampleRatio))
+		log.Printf("Tracing enabled: exporting to %s (sample ratio %.2f)", exp.endpoint, *traceSampleRatio)
+	}
 
 	// Validate fixed celestial body if set
 	if fixedCelestialBody != "" {
@@ -816,7 +1781,156 @@ func main() {
 	}
 
 	// Create and start the server
-	server := NewServer(*port, *https, httpEnabled, socksEnabled, fixedCelestialBody)
+	server, err := NewServer(*port, *https, httpEnabled, socksEnabled, fixedCelestialBody, getCelestialObjects())
+	if err != nil {
+		log.Fatalf("Cannot start: %v", err)
+	}
+	log.Printf("Celestial objects initialized. Count: %d", len(getCelestialObjects()))
+	server.recent = NewRecentLog(*recentSize, *anonymizeIPs)
+	// NewServer derives the HTTP address from -port; the -*-addr flags win
+	// when given explicitly.
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "http-addr":
+			server.httpAddr = *httpAddr
+		case "https-addr":
+			if *https {
+				server.httpsAddr = *httpsAddr
+			}
+		case "socks-addr":
+			server.socksAddr = *socksAddr
+		}
+	})
+	server.objectsFile = *objectsFile
+	server.cacheFile = *cacheFile
+	if *securityConfig != "" {
+		if err := server.security.UseConfigFile(*securityConfig); err != nil {
+			log.Fatalf("Invalid -security-config: %v", err)
+		}
+		log.Printf("Destination allow-lists from %s", *securityConfig)
+	}
+	if *allowPrivate {
+		server.security.allowPrivate = true
+		log.Printf("Private-network destinations allowed (-allow-private)")
+	}
+	if *adminToken != "" {
+		server.adminToken = *adminToken
+	}
+	server.adminCIDRs, err = parseTrustedProxies(*adminCIDRs)
+	if err != nil {
+		log.Fatalf("Invalid -admin-cidrs: %v", err)
+	}
+	// The settings a SIGHUP can change again from the -config file.
+	settings, err := hotSettingsFrom(config.value, getCelestialObjects())
+	if err != nil {
+		log.Fatalf("Invalid %v", err)
+	}
+	server.applyHotSettings(settings)
+	if *configPath != "" {
+		server.config = config
+	}
+	seedSimRand(*simSeed)
+	server.fingerAddr = *fingerAddr
+	server.gopherPort = *gopherPort
+	server.gopherHost = *gopherHost
+	stationMode, err := parseGroundStationMode(*groundStation)
+	if err != nil {
+		log.Fatalf("Invalid -ground-station: %v", err)
+	}
+	setGroundStationMode(stationMode)
+	weather, err := parseWeatherSource(*spaceWeather, *simSeed)
+	if err != nil {
+		log.Fatalf("Invalid -space-weather: %v", err)
+	}
+	if noaa, ok := weather.(*NOAAWeather); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := noaa.load(ctx)
+		cancel()
+		switch {
+		case err != nil && !noaa.isURL():
+			log.Fatalf("Invalid -space-weather: %v", err)
+		case err != nil:
+			// NOAA may be down; the refresh tries again.
+			log.Printf("Space weather: no readings yet: %v", err)
+		}
+	}
+	setWeatherSource(weather)
+	if *accessLogPath != "" {
+		server.access, err = OpenAccessLog(*accessLogPath, *accessLogMaxSize<<20, *accessLogMaxAge, *anonymizeIPs)
+		if err != nil {
+			log.Fatalf("Invalid -access-log: %v", err)
+		}
+	}
+	server.timeAddr = *timeAddr
+	server.timeBody = *timeBody
+	if server.timeBody == "" {
+		server.timeBody = fixedCelestialBody
+	}
+	if server.timeBody == "" {
+		server.timeBody = "mars"
+	}
+	if _, ok := findObjectByName(getCelestialObjects(), server.timeBody); server.timeAddr != "" && !ok {
+		log.Fatalf("Invalid -time-udp-body: unknown celestial body %q", server.timeBody)
+	}
+	server.acmeWebroot = *acmeWebroot
+	if *acmeResponder != "" {
+		if server.acmeResponder, err = newACMEResponder(*acmeResponder); err != nil {
+			log.Fatalf("Invalid -acme-responder: %v", err)
+		}
+	}
+	if *httpHeaderTimeout <= 0 {
+		log.Fatalf("Invalid -http-header-timeout %v: must be positive", *httpHeaderTimeout)
+	}
+	server.httpHeaderTimeout = *httpHeaderTimeout
+	server.connLimit = NewConnLimiter(*connsPerIP)
+	if *simulateTCPWindows {
+		if *tcpWindow <= 0 {
+			log.Fatalf("Invalid -tcp-window %d: must be positive", *tcpWindow)
+		}
+		server.tcpWindow = *tcpWindow
+	}
+	if *noPreflight {
+		server.preflight = nil
+	} else {
+		if *preflightTTL <= 0 {
+			log.Fatalf("Invalid -preflight-ttl %v: must be positive", *preflightTTL)
+		}
+		server.preflight = NewPreflight(*preflightTTL, server.metrics)
+	}
+	if *remoteDNSLatency {
+		server.remoteDNS = NewRemoteDNS(server.metrics)
+		log.Printf("SOCKS target names resolved over the link (-remote-dns-latency)")
+	}
+	server.proxyProtocol = *proxyProtocol
+	server.proxyProtocolHTTP = *proxyProtocolHTTP
+	server.trustedProxies, err = parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid -trusted-proxies: %v", err)
+	}
+	if len(server.trustedProxies) > 0 && !server.proxyProtocol {
+		log.Printf("Warning: SOCKS connections from -trusted-proxies will be refused without -proxy-protocol")
+	}
+	server.dtn.SetUpstreamTimeouts(UpstreamTimeouts{Connect: *upstreamConnect, Header: *upstreamHeader})
+	server.dtn.SetUpstreamRetries(*upstreamRetries)
+	server.dtn.SetCoalesceGets(*coalesceGets)
+	if *responseMaxSize <= 0 {
+		log.Fatalf("Invalid -response-max-size %d: responses are buffered, so it must be positive", *responseMaxSize)
+	}
+	server.sizeLimits = SizeLimits{
+		RequestBytes:           *requestMaxSize << 20,
+		SpacecraftRequestBytes: *spacecraftRequestMaxSize << 20,
+		ResponseBytes:          *responseMaxSize << 20,
+		SOCKSSessionBytes:      *socksSessionMaxSize << 20,
+	}
+	server.dtn.SetMaxResponseBytes(server.sizeLimits.ResponseBytes)
+	if server.overhead, err = newOverheadSampler(*overheadPct); err != nil {
+		log.Fatalf("Invalid -overhead-sampling-pct %v", err)
+	}
+	server.dtn.SetOverheadSampler(server.overhead)
+	server.tcpForwards, err = parseTCPForwards(*tcpForwardSpec)
+	if err != nil {
+		log.Fatalf("Invalid -tcp-forward: %v", err)
+	}
 	err = server.Start() // Use = instead of := as err is already declared
 	if err != nil {
 		log.Fatalf("Server error: %v", err)
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
 	"strings" // Import strings for case-insensitive comparison later
 	"testing"
 	"time"
@@ -12,7 +15,14 @@ import (
 	"github.com/latency-space/shared/celestial"
 )
 
-// testCelestialObjects provides a simplified list of objects for testing parseHostForCelestialBody.
+// TestMain installs the built-in objects, as main does through NewServer;
+// nothing sets them at package init.
+func TestMain(m *testing.M) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	os.Exit(m.Run())
+}
+
+// testCelestialObjects provides a simplified list of objects for testing parseCelestialHost.
 var testCelestialObjects = []CelestialObject{
 	{Name: "Earth", Type: "planet"},
 	{Name: "Mars", Type: "planet"},
@@ -21,6 +31,8 @@ var testCelestialObjects = []CelestialObject{
 	{Name: "Phobos", Type: "moon", ParentName: "Mars"},
 	{Name: "Deimos", Type: "moon", ParentName: "Mars"},
 	{Name: "Europa", Type: "moon", ParentName: "Jupiter"},
+	{Name: "Didymos", Type: "asteroid"},
+	{Name: "Dimorphos", Type: "moon", ParentName: "Didymos"},
 	// Multi-word name: advertised subdomain is the hyphenated slug "voyager-1".
 	{Name: "Voyager 1", Type: "spacecraft"},
 }
@@ -35,28 +47,33 @@ func TestResolveCelestialHost(t *testing.T) {
 		name             string
 		host             string
 		expectedBodyName string // "" means the host names no known body
+		expectedReason   string // the hostError reason when it names none
 	}{
 		// Valid info-page hosts.
-		{"Planet", "mars.latency.space", "Mars"},
-		{"Moon with parent", "phobos.mars.latency.space", "Phobos"},
-		{"Multi-word body via hyphenated slug", "voyager-1.latency.space", "Voyager 1"},
-		{"Case insensitivity - planet", "MARS.latency.space", "Mars"},
-		{"Case insensitivity - moon", "PHOBOS.MARS.LATENCY.SPACE", "Phobos"},
-		{"Host with port", "mars.latency.space:8080", "Mars"},
+		{"Planet", "mars.latency.space", "Mars", ""},
+		{"Moon with parent", "phobos.mars.latency.space", "Phobos", ""},
+		{"Moon of an asteroid", "dimorphos.didymos.latency.space", "Dimorphos", ""},
+		{"Multi-word body via hyphenated slug", "voyager-1.latency.space", "Voyager 1", ""},
+		{"Case insensitivity - planet", "MARS.latency.space", "Mars", ""},
+		{"Case insensitivity - moon", "PHOBOS.MARS.LATENCY.SPACE", "Phobos", ""},
+		{"Host with port", "mars.latency.space:8080", "Mars", ""},
 
 		// Target-embedding forms are no longer resolved (they never resolved in
 		// public DNS; proxying is done over SOCKS).
-		{"Embedded target on planet rejected", "www.example.com.mars.latency.space", ""},
-		{"Embedded target on moon rejected", "www.example.com.phobos.mars.latency.space", ""},
-		{"Embedded target slug rejected", "www.example.com.voyager-1.latency.space", ""},
+		{"Embedded target on planet rejected", "www.example.com.mars.latency.space", "", hostTooManyLabels},
+		{"Embedded target on moon rejected", "www.example.com.phobos.mars.latency.space", "", hostTooManyLabels},
+		{"Embedded target slug rejected", "www.example.com.voyager-1.latency.space", "", hostTooManyLabels},
 
 		// Invalid / malformed hosts.
-		{"Invalid moon parent", "phobos.jupiter.latency.space", ""}, // Phobos orbits Mars
-		{"Moon under non-parent planet", "moon.mars.latency.space", ""},
-		{"Non-existent body", "unknown.latency.space", ""},
-		{"Bare apex", "latency.space", ""},
-		{"Wrong TLD", "mars.latency.com", ""},
-		{"Unrelated domain", "example.com", ""},
+		{"Invalid moon parent", "phobos.jupiter.latency.space", "", hostParentMismatch}, // Phobos orbits Mars
+		{"Moon under non-parent planet", "moon.mars.latency.space", "", hostParentMismatch},
+		{"Moon without its parent", "phobos.latency.space", "", hostMoonNoParent},
+		{"Planet under a planet", "mars.jupiter.latency.space", "", hostNotAMoon},
+		{"Unknown parent", "phobos.vulcan.latency.space", "", hostUnknownLabel},
+		{"Non-existent body", "unknown.latency.space", "", hostUnknownLabel},
+		{"Bare apex", "latency.space", "", hostNotLatencySpace},
+		{"Wrong TLD", "mars.latency.com", "", hostNotLatencySpace},
+		{"Unrelated domain", "example.com", "", hostNotLatencySpace},
 	}
 
 	// Instantiate a Server to call the method under test.
@@ -64,14 +81,63 @@ func TestResolveCelestialHost(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			got := s.resolveCelestialHost(tc.host)
+			got := s.resolveCelestialHost(getCelestialObjects(), tc.host)
 			if !strings.EqualFold(got, tc.expectedBodyName) {
 				t.Errorf("host '%s': expected body name '%s', got '%s'", tc.host, tc.expectedBodyName, got)
 			}
+			_, err := parseCelestialHost(getCelestialObjects(), tc.host)
+			var he *hostError
+			if tc.expectedReason == "" && err != nil || tc.expectedReason != "" && (!errors.As(err, &he) || he.Reason != tc.expectedReason) {
+				t.Errorf("host '%s': expected reason %q, got %v", tc.host, tc.expectedReason, err)
+			}
 		})
 	}
 }
 
+// TestParseCelestialHostMoons puts every moon in the dataset under each
+// candidate parent label: only its own parent, in any case, resolves.
+func TestParseCelestialHostMoons(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	var moons int
+	for _, moon := range objects {
+		if moon.Type != "moon" {
+			continue
+		}
+		moons++
+		for _, parent := range objects {
+			if parent.Type == "moon" || parent.Type == "star" {
+				continue
+			}
+			label := FormatDomainName(parent.Name)
+			if parent.Name == moon.ParentName {
+				label = strings.ToUpper(label)
+			}
+			host := FormatDomainName(moon.Name) + "." + label + ".latency.space"
+			got, err := parseCelestialHost(objects, host)
+			if parent.Name == moon.ParentName {
+				if got != moon.Name || err != nil {
+					t.Errorf("%s: got %q, %v; want %s", host, got, err, moon.Name)
+				}
+				continue
+			}
+			var he *hostError
+			if got != "" || !errors.As(err, &he) || he.Reason != hostParentMismatch || he.Parent != moon.ParentName {
+				t.Errorf("%s: got %q, %v; want a parent mismatch naming %s", host, got, err, moon.ParentName)
+			}
+		}
+
+		host := FormatDomainName(moon.Name) + ".latency.space"
+		var he *hostError
+		if _, err := parseCelestialHost(objects, host); !errors.As(err, &he) || he.Reason != hostMoonNoParent ||
+			!strings.Contains(err.Error(), FormatMoonDomain(moon.Name, moon.ParentName)) {
+			t.Errorf("%s: %v, want it sent under %s", host, err, moon.ParentName)
+		}
+	}
+	if moons == 0 {
+		t.Fatal("no moons in the dataset")
+	}
+}
+
 // TestFindObjectByName tests the helper function directly.
 func TestFindObjectByName(t *testing.T) {
 	// Use the same test object data.
@@ -113,7 +179,7 @@ func TestFindObjectByName(t *testing.T) {
 				t.Errorf("searchName '%s': expected object name '%s', got '%s'", tc.searchName, tc.expectedName, foundBody.Name)
 			}
 
-			if !found && foundBody != (CelestialObject{}) {
+			if !found && !reflect.DeepEqual(foundBody, CelestialObject{}) {
 				t.Errorf("searchName '%s': expected empty object when not found, got %+v", tc.searchName, foundBody)
 			}
 		})
@@ -143,11 +209,14 @@ func TestDisplayCelestialInfoTemplate(t *testing.T) {
 	recorder := httptest.NewRecorder()
 
 	// Create a Server instance.
-	s := NewServer(80, false, true, true, "") // Port/HTTPS don't matter for this test
+	s, err := NewServer(80, false, true, true, "", getCelestialObjects()) // Port/HTTPS don't matter for this test
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// Call the function being tested.
 	testBodyName := "Mars"
-	s.displayCelestialInfo(recorder, testBodyName)
+	s.displayCelestialInfo(recorder, httptest.NewRequest("GET", "/", nil), currentSnapshot(distanceClock()), testBodyName)
 
 	// Assert the HTTP status code is OK.
 	if recorder.Code != http.StatusOK {
@@ -181,6 +250,13 @@ func TestDisplayCelestialInfoTemplate(t *testing.T) {
 		t.Errorf("Response body does not contain expected domain code block: %s", expectedDomain)
 	}
 
+	// Default listeners: no ports in the web links, the standard SOCKS port.
+	for _, want := range []string{"mars.latency.space:1080 ", "https://mars.latency.space/dtn/send"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Response body does not contain %q", want)
+		}
+	}
+
 	// Check for moon links if applicable (Mars has moons).
 	if testBodyName == "Mars" {
 		phobosLink := fmt.Sprintf(`<li><a href="http://%s/">Phobos</a></li>`, FormatMoonDomain("Phobos", "Mars"))
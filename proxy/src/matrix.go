@@ -0,0 +1,184 @@
+// matrix.go - pairwise latency between every body at one instant.
+//
+//	GET /api/matrix[?t=RFC3339][&types=planet,moon,spacecraft][&occlusion=true]
+//
+// Calling /api/distance for every pair solves each body's position once per
+// call. This endpoint solves every position once for the instant, rounded
+// down to the minute, and derives all N×N geometric distances and one-way
+// light times from them. They are returned as flat row-major arrays beside
+// an ordered bodies list: the entry for bodies[i] to bodies[j] is at
+// i*len(bodies)+j.
+//
+// ?occlusion=true adds whether each row's line of sight to each column is
+// blocked. That scans every object for every pair, so it is refused beyond
+// matrixMaxOcclusionBodies bodies. Results are cached per minute, type
+// filter and occlusion flag. The endpoint shares the /api/distance rate
+// limit.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+const (
+	// matrixMaxOcclusionBodies bounds ?occlusion=true, which is O(N³).
+	matrixMaxOcclusionBodies = 40
+	// matrixCacheSize is how many matrices are kept.
+	matrixCacheSize = 64
+)
+
+// MatrixResponse is the JSON returned by /api/matrix.
+type MatrixResponse struct {
+	Timestamp      time.Time `json:"timestamp"` // the instant solved, rounded down to the minute
+	Bodies         []string  `json:"bodies"`
+	DistanceKm     []float64 `json:"distanceKm"`         // row-major, geometric
+	LatencySeconds []float64 `json:"latencySeconds"`     // row-major, one way
+	Occluded       []bool    `json:"occluded,omitempty"` // row-major, with ?occlusion=true
+}
+
+type matrixKey struct {
+	minute    int64 // Unix minutes
+	types     string
+	occlusion bool
+}
+
+type matrixEntry struct {
+	objects *[]celestial.CelestialObject // the object list it was solved from
+	resp    *MatrixResponse
+}
+
+var (
+	matrixCacheMu sync.Mutex
+	matrixCache   = make(map[matrixKey]matrixEntry)
+)
+
+// handleMatrix serves /api/matrix.
+func (s *Server) handleMatrix(w http.ResponseWriter, r *http.Request) {
+	release, err := s.distanceLimiter.Acquire(s.requestClientIP(r))
+	if err != nil {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	q := r.URL.Query()
+	at := simTime(time.Now())
+	if ts := q.Get("t"); ts != "" {
+		parsed, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid 't' (want RFC3339): " + err.Error()})
+			return
+		}
+		at = parsed
+	}
+	types, err := parseMatrixTypes(q.Get("types"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	resp, err := cachedMatrix(at, types, q.Get("occlusion") == "true")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseMatrixTypes parses ?types into a sorted, de-duplicated list; empty
+// means every type.
+func parseMatrixTypes(spec string) ([]string, error) {
+	seen := make(map[string]bool)
+	var types []string
+	for _, typ := range strings.Split(spec, ",") {
+		typ = strings.ToLower(strings.TrimSpace(typ))
+		if typ == "" || seen[typ] {
+			continue
+		}
+		if !knownObjectTypes[typ] {
+			return nil, fmt.Errorf("unknown type %q in 'types'", typ)
+		}
+		seen[typ] = true
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types, nil
+}
+
+// cachedMatrix returns the matrix for the minute containing at, computing
+// it if it isn't cached for the current object list.
+func cachedMatrix(at time.Time, types []string, occlusion bool) (*MatrixResponse, error) {
+	at = at.UTC().Truncate(time.Minute)
+	objects := celestialObjectsPtr.Load()
+	key := matrixKey{at.Unix() / 60, strings.Join(types, ","), occlusion}
+
+	matrixCacheMu.Lock()
+	defer matrixCacheMu.Unlock()
+	if e, ok := matrixCache[key]; ok && e.objects == objects {
+		return e.resp, nil
+	}
+	resp, err := computeMatrix(*objects, at, types, occlusion)
+	if err != nil {
+		return nil, err
+	}
+	if len(matrixCache) >= matrixCacheSize {
+		for k := range matrixCache { // evict an arbitrary entry
+			delete(matrixCache, k)
+			break
+		}
+	}
+	matrixCache[key] = matrixEntry{objects, resp}
+	return resp, nil
+}
+
+// computeMatrix solves the matrix for the bodies of the given types (all
+// when empty) at at. Every object still counts as a possible occluder.
+func computeMatrix(objects []celestial.CelestialObject, at time.Time, types []string, occlusion bool) (*MatrixResponse, error) {
+	var bodies []celestial.CelestialObject
+	for _, obj := range objects {
+		if len(types) == 0 || slices.Contains(types, obj.Type) {
+			bodies = append(bodies, obj)
+		}
+	}
+	n := len(bodies)
+	if occlusion && n > matrixMaxOcclusionBodies {
+		return nil, fmt.Errorf("occlusion is limited to %d bodies and the selection has %d; narrow it with 'types'", matrixMaxOcclusionBodies, n)
+	}
+
+	positions := NewPositionCache(objects, at)
+
+	resp := &MatrixResponse{
+		Timestamp:      at,
+		Bodies:         make([]string, n),
+		DistanceKm:     make([]float64, n*n),
+		LatencySeconds: make([]float64, n*n),
+	}
+	for i, a := range bodies {
+		resp.Bodies[i] = a.Name
+		for j := i + 1; j < n; j++ {
+			d := positions.Position(bodies[j]).Subtract(positions.Position(a)).Magnitude() * AU
+			resp.DistanceKm[i*n+j], resp.DistanceKm[j*n+i] = d, d
+			resp.LatencySeconds[i*n+j], resp.LatencySeconds[j*n+i] = d/SPEED_OF_LIGHT, d/SPEED_OF_LIGHT
+		}
+	}
+	if occlusion {
+		resp.Occluded = make([]bool, n*n)
+		for i, a := range bodies {
+			for j, b := range bodies {
+				if i != j {
+					v, _ := occlusionWith(a, b, objects, positions)
+					resp.Occluded[i*n+j] = v.Blocked()
+				}
+			}
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func matrixRequest(t *testing.T, s *Server, query string) (int, *MatrixResponse, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/matrix?"+query, nil))
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil, rec.Body.String()
+	}
+	var out MatrixResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("response not JSON: %q", rec.Body.String())
+	}
+	return rec.Code, &out, ""
+}
+
+func newMatrixServer() *Server {
+	return &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(),
+		distanceLimiter: NewRateLimiter(1e6, 1000, 0, 0)}
+}
+
+// TestMatrixSymmetricWithZeroDiagonal checks the shape of a filtered
+// matrix, its symmetry and its zero diagonal.
+func TestMatrixSymmetricWithZeroDiagonal(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	code, m, body := matrixRequest(t, newMatrixServer(), "types=planet,spacecraft&t=2030-06-01T00:00:30Z")
+	if code != http.StatusOK {
+		t.Fatalf("%d %s", code, body)
+	}
+	if got := m.Timestamp.Format("15:04:05"); got != "00:00:00" {
+		t.Errorf("timestamp %v not rounded down to the minute", m.Timestamp)
+	}
+	n := len(m.Bodies)
+	if n < 10 || len(m.DistanceKm) != n*n || len(m.LatencySeconds) != n*n || m.Occluded != nil {
+		t.Fatalf("%d bodies, %d distances, %d latencies, occluded %v", n, len(m.DistanceKm), len(m.LatencySeconds), m.Occluded != nil)
+	}
+	objects := getCelestialObjects()
+	for i := 0; i < n; i++ {
+		if obj, _ := findObjectByName(objects, m.Bodies[i]); obj.Type != "planet" && obj.Type != "spacecraft" {
+			t.Errorf("%s (%s) doesn't match the filter", obj.Name, obj.Type)
+		}
+		if m.DistanceKm[i*n+i] != 0 || m.LatencySeconds[i*n+i] != 0 {
+			t.Errorf("%s to itself: %v km, %v s", m.Bodies[i], m.DistanceKm[i*n+i], m.LatencySeconds[i*n+i])
+		}
+		for j := 0; j < n; j++ {
+			if m.LatencySeconds[i*n+j] != m.LatencySeconds[j*n+i] {
+				t.Fatalf("%s/%s asymmetric", m.Bodies[i], m.Bodies[j])
+			}
+			if i != j && m.DistanceKm[i*n+j] <= 0 {
+				t.Errorf("%s to %s: %v km", m.Bodies[i], m.Bodies[j], m.DistanceKm[i*n+j])
+			}
+		}
+	}
+}
+
+// TestMatrixAgreesWithDistance samples a pair against /api/distance.
+func TestMatrixAgreesWithDistance(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	s := newMatrixServer()
+	const at = "2030-06-01T00:00:00Z"
+	code, m, body := matrixRequest(t, s, "types=planet,moon&occlusion=true&t="+at)
+	if code != http.StatusOK {
+		t.Fatalf("%d %s", code, body)
+	}
+	index := func(name string) int {
+		for i, b := range m.Bodies {
+			if b == name {
+				return i
+			}
+		}
+		t.Fatalf("%s missing from %v", name, m.Bodies)
+		return -1
+	}
+	n := len(m.Bodies)
+	from, to := index("Europa"), index("Mars")
+
+	code, single := distanceRequest(t, s, "from=europa&to=mars&t="+at)
+	if code != http.StatusOK {
+		t.Fatalf("distance: %d %v", code, single)
+	}
+	if got, want := m.DistanceKm[from*n+to], single["geometric_distance_km"].(float64); math.Abs(got-want) > 1e-6*want {
+		t.Errorf("matrix %v km, /api/distance %v km", got, want)
+	}
+	// /api/distance times the light-time corrected distance; they differ by
+	// about v/c.
+	if got, want := m.LatencySeconds[from*n+to], single["one_way_seconds"].(float64); math.Abs(got-want) > 1e-3*want {
+		t.Errorf("matrix %v s, /api/distance %v s", got, want)
+	}
+	if got, want := m.Occluded[from*n+to], single["occluded"].(bool); got != want {
+		t.Errorf("matrix occluded %v, /api/distance %v", got, want)
+	}
+
+	// The second request is served from the cache.
+	if _, again, _ := matrixRequest(t, s, "types=moon,planet&occlusion=true&t=2030-06-01T00:00:59Z"); again == nil || again.DistanceKm[from*n+to] != m.DistanceKm[from*n+to] {
+		t.Error("the same minute and filter gave a different matrix")
+	}
+}
+
+// TestMatrixOcclusionBound checks ?occlusion=true is refused over
+// matrixMaxOcclusionBodies bodies but the plain matrix isn't, and that
+// unknown types are rejected.
+func TestMatrixOcclusionBound(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	s := newMatrixServer()
+	if n := len(getCelestialObjects()); n <= matrixMaxOcclusionBodies {
+		t.Fatalf("only %d objects; the bound can't be reached", n)
+	}
+	if code, _, body := matrixRequest(t, s, "occlusion=true"); code != http.StatusBadRequest || !strings.Contains(body, "limited to 40 bodies") {
+		t.Errorf("all bodies with occlusion: %d %s", code, body)
+	}
+	if code, m, body := matrixRequest(t, s, ""); code != http.StatusOK || len(m.Bodies) != len(getCelestialObjects()) {
+		t.Errorf("all bodies without occlusion: %d %s", code, body)
+	}
+	if code, _, body := matrixRequest(t, s, "types=planet,nebula"); code != http.StatusBadRequest || !strings.Contains(body, "unknown type") {
+		t.Errorf("unknown type: %d %s", code, body)
+	}
+}
@@ -2,6 +2,8 @@
 package main
 
 import (
+	"fmt"
+	"github.com/latency-space/proxy/relay"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"log"
@@ -9,99 +11,563 @@ import (
 	"time"
 )
 
+// Metrics is what the proxy records as it works: requests and their timings,
+// relayed bytes, SOCKS phases, upstream fetches and the caps that cut them
+// short. MetricsCollector exports it to Prometheus; RecordingMetrics
+// (metrics_recorder.go) keeps it in memory, for tests to read back.
+type Metrics interface {
+	SetBodyLatency(body string, seconds float64)
+	RecordRequest(body, reqType string, duration time.Duration)
+	TrackBandwidthDir(body, protocol, direction string, bytes int64)
+	TrackBandwidth(body string, bytes int64)
+	RecordUDPPacket(body string)
+	RecordUDPDrop(body, reason string)
+	RecordUDPImpairment(body, effect string)
+	RecordSOCKSHandshake(success bool, d time.Duration)
+	RecordSOCKSDial(body string, d time.Duration)
+	RecordSOCKSSession(body string, d time.Duration)
+	RecordSOCKSFailure(rep byte)
+	RecordSOCKSLinkLostDuringSetup(body, reason string)
+	RecordRelayEnd(body, protocol, outcome string)
+	RecordLatencySplit(body, reqType string, simulated, upstream time.Duration)
+	RecordUpstreamConn(reused bool)
+	RecordUpstreamRetry(body string)
+	RecordUpstreamOutcome(body string, attempts int, failed bool)
+	RecordSizeLimit(body, limit string)
+	RecordPreflightProbe(result string)
+	RecordPreflightLookup(result string)
+	RecordOverhead(body, path string, d time.Duration)
+	RecordDestinationDenied(protocol, reason string)
+	RecordDebugDenied(endpoint, reason string)
+	RecordRemoteDNSLookup(body, result string)
+	RecordCoalesced(body string, bytes int64)
+	RecordInternalState(reason string)
+	RecordOcclusionRejection(body, protocol string)
+}
+
+var (
+	_ Metrics = (*MetricsCollector)(nil)
+	_ Metrics = (*RecordingMetrics)(nil)
+)
+
+// orNop returns m, or NopMetrics for a nil m, so a component built without
+// metrics records nothing rather than panicking.
+func orNop(m Metrics) Metrics {
+	if m == nil {
+		return NopMetrics
+	}
+	return m
+}
+
+// Metric names, shared by both implementations so a test reads back the
+// series Prometheus would export.
+const (
+	metricRequestDuration      = "request_duration_seconds"
+	metricRequests             = "requests_total"
+	metricBandwidth            = "bandwidth_bytes_total"
+	metricUDPPackets           = "udp_packets_total"
+	metricUDPDropped           = "udp_dropped_packets_total"
+	metricUDPImpaired          = "udp_impaired_packets_total"
+	metricSpaceLatency         = "space_latency_seconds"
+	metricSOCKSHandshake       = "socks_handshake_duration_seconds"
+	metricSOCKSDial            = "socks_dial_duration_seconds"
+	metricSOCKSSession         = "socks_session_duration_seconds"
+	metricSOCKSFailures        = "socks_failures_total"
+	metricSOCKSLinkLost        = "socks_occluded_during_setup_total"
+	metricRelayEnds            = "relay_session_outcomes_total"
+	metricSimulatedLatency     = "simulated_latency_seconds"
+	metricUpstreamDuration     = "upstream_duration_seconds"
+	metricUpstreamConnsCreated = "proxy_upstream_connections_created_total"
+	metricUpstreamConnsReused  = "proxy_upstream_connections_reused_total"
+	metricUpstreamRetries      = "proxy_upstream_retries_total"
+	metricUpstreamOutcomes     = "proxy_upstream_outcomes_total"
+	metricSizeLimited          = "proxy_size_limit_exceeded_total"
+	metricPreflightProbes      = "proxy_preflight_probes_total"
+	metricPreflightCache       = "proxy_preflight_cache_total"
+	metricOverhead             = "latency_space_overhead_seconds"
+	metricDestinationDenied    = "proxy_destination_denied_total"
+	metricDebugDenied          = "proxy_debug_denied_total"
+	metricRemoteDNSCache       = "proxy_remote_dns_cache_total"
+	metricCoalesced            = "proxy_coalesced_requests_total"
+	metricCoalescedBytes       = "proxy_coalesced_bytes_total"
+	metricInternalState        = "proxy_internal_state_errors_total"
+	metricOcclusionRejections  = "proxy_occlusion_rejections_total"
+)
+
+// MetricsCollector records Metrics in Prometheus collectors. A nil
+// *MetricsCollector records nothing.
 type MetricsCollector struct {
 	requestDuration *prometheus.HistogramVec
 	requestsTotal   *prometheus.CounterVec
 	bandwidthUsage  *prometheus.CounterVec
 	udpPackets      *prometheus.CounterVec // Counter for UDP packets handled by SOCKS UDP associate
+	udpDropped      *prometheus.CounterVec // UDP packets dropped by per-association caps or source filtering, by reason
+	udpImpaired     *prometheus.CounterVec // UDP packets dropped, reordered or duplicated by the link model
 	spaceLatency    *prometheus.GaugeVec   // Current one-way light latency per body (for the dashboard)
+
+	// SOCKS phase timings, kept apart so simulated latency and relay lifetime
+	// don't swamp the setup cost.
+	socksHandshake *prometheus.HistogramVec // greeting through reply sent, by result
+	socksDial      *prometheus.HistogramVec // upstream dial only, excluding simulated latency
+	socksSession   *prometheus.HistogramVec // success reply through relay teardown
+	socksFailures  *prometheus.CounterVec   // rejected requests, by SOCKS reply code
+	socksLinkLost  *prometheus.CounterVec   // link went down during the latency sleep, by body and reason
+	relayEnds      *prometheus.CounterVec   // TCP relays (SOCKS, -tcp-forward) by how they ended
+
+	// HTTP (DTN) timings: the simulated light delay and the real upstream fetch.
+	simulatedLatency *prometheus.HistogramVec
+	upstreamDuration *prometheus.HistogramVec
+
+	// Upstream connection pooling: new connections vs idle ones reused.
+	upstreamConnsCreated prometheus.Counter
+	upstreamConnsReused  prometheus.Counter
+
+	// Upstream retries of transient failures, and how fetches finally ended.
+	upstreamRetries  *prometheus.CounterVec
+	upstreamOutcomes *prometheus.CounterVec
+
+	// Requests and sessions that ran into a size cap (size_limits.go).
+	sizeLimited *prometheus.CounterVec
+
+	// Pre-flight reachability probes (preflight.go) and their cache.
+	preflightProbes  *prometheus.CounterVec
+	preflightLookups *prometheus.CounterVec
+
+	// Sampled real time spent outside the simulated delays (overhead.go).
+	overhead *prometheus.HistogramVec
+
+	// Destinations refused by the shared policy (ValidateDestination).
+	destinationDenied *prometheus.CounterVec
+
+	// Admin /_debug requests refused (debugmux.go).
+	debugDenied *prometheus.CounterVec
+
+	// SOCKS name lookups over the simulated link (remotedns.go).
+	remoteDNSLookups *prometheus.CounterVec
+
+	// DTN fetches answered from an identical one in flight (coalesce.go).
+	coalesced      *prometheus.CounterVec
+	coalescedBytes *prometheus.CounterVec
+
+	// Requests failed on an impossible state (internal_state.go).
+	internalState *prometheus.CounterVec
+
+	// Requests, connections and packets refused over an occluded link
+	// (occlusion_policy.go).
+	occlusionRejections *prometheus.CounterVec
+}
+
+// sessionBuckets spans short API calls through hour-long tunnels to the
+// outer planets; the default buckets top out at 10s.
+var sessionBuckets = prometheus.ExponentialBuckets(0.01, 4, 12)
+
+// overheadBuckets run from half a millisecond to a few seconds: small next
+// to the Moon's light time at the bottom, an upstream stall at the top.
+var overheadBuckets = prometheus.ExponentialBuckets(0.0005, 2, 14)
+
+// newMetricsCollector builds the collectors without registering them.
+// prefix goes in front of every name, so a test's copy can sit in a
+// registry beside the real one.
+func newMetricsCollector(prefix string) *MetricsCollector {
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: prefix + name, Help: help}, labels)
+	}
+	histogram := func(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: prefix + name, Help: help, Buckets: buckets}, labels)
+	}
+	return &MetricsCollector{
+		requestDuration: histogram(metricRequestDuration, "Time spent processing request", nil, "body", "type"),
+		requestsTotal:   counter(metricRequests, "Total number of requests", "body", "type"),
+		bandwidthUsage:  counter(metricBandwidth, "Application payload bytes relayed, by direction and protocol", "body", "direction", "protocol"),
+		udpPackets:      counter(metricUDPPackets, "Total UDP packets processed", "body"),
+		udpDropped:      counter(metricUDPDropped, "UDP packets dropped by per-association caps or as unsolicited", "body", "reason"),
+		udpImpaired:     counter(metricUDPImpaired, "UDP relay packets dropped, reordered or duplicated by the simulated link", "body", "effect"),
+		spaceLatency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: prefix + metricSpaceLatency,
+			Help: "Current one-way light-travel latency to each celestial body",
+		}, []string{"body"}),
+
+		socksHandshake: histogram(metricSOCKSHandshake, "Time from SOCKS greeting until the request reply is sent", sessionBuckets, "result"),
+		socksDial:      histogram(metricSOCKSDial, "Time spent dialing the upstream target, excluding simulated latency", nil, "body"),
+		socksSession:   histogram(metricSOCKSSession, "Lifetime of an established SOCKS CONNECT relay", sessionBuckets, "body"),
+		socksFailures:  counter(metricSOCKSFailures, "SOCKS requests rejected, by reply code", "code"),
+		socksLinkLost:  counter(metricSOCKSLinkLost, "SOCKS CONNECTs refused because the link went down (occlusion or contact window) during the latency sleep", "body", "reason"),
+		relayEnds:      counter(metricRelayEnds, "Established TCP relays by how they ended: ok (FIN both ways), reset, idle_timeout (after a half-close), closed (by the proxy) or error", "body", "protocol", "outcome"),
+
+		simulatedLatency: histogram(metricSimulatedLatency, "Simulated light-travel delay applied to a request", sessionBuckets, "body", "type"),
+		upstreamDuration: histogram(metricUpstreamDuration, "Real time spent on the upstream request, excluding simulated latency", nil, "body", "type"),
+
+		upstreamConnsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: prefix + metricUpstreamConnsCreated,
+			Help: "Upstream HTTP connections newly dialed",
+		}),
+		upstreamConnsReused: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: prefix + metricUpstreamConnsReused,
+			Help: "Upstream HTTP requests served over a pooled connection",
+		}),
+		upstreamRetries:  counter(metricUpstreamRetries, "Upstream requests retried after a transient failure", "body"),
+		upstreamOutcomes: counter(metricUpstreamOutcomes, "Upstream fetches by final outcome: ok, recovered (ok after a retry) or failed", "body", "outcome"),
+
+		sizeLimited: counter(metricSizeLimited, "Requests refused, responses truncated and SOCKS sessions closed for passing a size cap, by limit", "body", "limit"),
+
+		preflightProbes:  counter(metricPreflightProbes, "Direct reachability probes of upstream targets, by result: up, or why the target is down (refused, timeout, dns, tls, network)", "result"),
+		preflightLookups: counter(metricPreflightCache, "Pre-flight reachability lookups answered from the cache (hit) or by a new probe (miss)", "result"),
+
+		overhead: histogram(metricOverhead, "Real time a sampled proxied request spent outside the simulated delays: parsing, checks, upstream dial and response (-overhead-sampling-pct)", overheadBuckets, "body", "path"),
+
+		destinationDenied: counter(metricDestinationDenied, "Proxy destinations refused by the allowlist policy, by protocol and reason (scheme, ip_literal, host, port, resolved_ip)", "protocol", "reason"),
+
+		debugDenied: counter(metricDebugDenied, "Admin /_debug requests answered 404 for a missing or wrong token or an address outside -admin-cidrs, by endpoint and reason (token, address)", "endpoint", "reason"),
+
+		remoteDNSLookups: counter(metricRemoteDNSCache, "SOCKS target names looked up with -remote-dns-latency, by body and result: hit (cached, no round trip) or miss", "body", "result"),
+
+		coalesced:      counter(metricCoalesced, "DTN GETs answered with a copy of an identical fetch in flight instead of their own (-coalesce-gets)", "body"),
+		coalescedBytes: counter(metricCoalescedBytes, "Upstream response bytes not fetched again thanks to -coalesce-gets", "body"),
+
+		internalState: counter(metricInternalState, "Requests failed because the server was in a state it can't get into by design, by reason (no_objects, no_earth, no_distances)", "reason"),
+
+		occlusionRejections: counter(metricOcclusionRejections, "HTTP requests, SOCKS CONNECTs and UDP packets refused because the body was occluded, by body and protocol (http, socks_tcp, socks_udp, tcp_forward)", "body", "protocol"),
+	}
+}
+
+// collectors lists m's collectors, for registration.
+func (m *MetricsCollector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.requestDuration, m.requestsTotal, m.bandwidthUsage,
+		m.udpPackets, m.udpDropped, m.udpImpaired, m.spaceLatency,
+		m.socksHandshake, m.socksDial, m.socksSession, m.socksFailures, m.socksLinkLost, m.relayEnds,
+		m.simulatedLatency, m.upstreamDuration,
+		m.upstreamConnsCreated, m.upstreamConnsReused,
+		m.upstreamRetries, m.upstreamOutcomes,
+		m.sizeLimited,
+		m.preflightProbes, m.preflightLookups,
+		m.overhead,
+		m.destinationDenied,
+		m.debugDenied,
+		m.remoteDNSLookups,
+		m.coalesced, m.coalescedBytes,
+		m.internalState,
+		m.occlusionRejections,
+	}
 }
 
 // NewMetricsCollector creates and registers Prometheus metrics collectors.
 func NewMetricsCollector() *MetricsCollector {
-	m := &MetricsCollector{
-		requestDuration: prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Name: "request_duration_seconds",
-				Help: "Time spent processing request",
-			},
-			[]string{"body", "type"},
-		),
-		requestsTotal: prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "requests_total",
-				Help: "Total number of requests",
-			},
-			[]string{"body", "type"},
-		),
-		bandwidthUsage: prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "bandwidth_bytes_total",
-				Help: "Total bandwidth usage in bytes",
-			},
-			[]string{"body", "direction"},
-		),
-		udpPackets: prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "udp_packets_total",
-				Help: "Total UDP packets processed",
-			},
-			[]string{"body"}, // Label by celestial body
-		),
-		spaceLatency: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "space_latency_seconds",
-				Help: "Current one-way light-travel latency to each celestial body",
-			},
-			[]string{"body"},
-		),
-	}
-
-	// Register Prometheus metrics.
-	prometheus.MustRegister(m.requestDuration)
-	prometheus.MustRegister(m.requestsTotal)
-	prometheus.MustRegister(m.bandwidthUsage)
-	prometheus.MustRegister(m.udpPackets)
-	prometheus.MustRegister(m.spaceLatency)
-
+	m := newMetricsCollector("")
+	prometheus.MustRegister(m.collectors()...)
+	prometheus.MustRegister(relayBufferMetrics()...)
+	prometheus.MustRegister(distanceCacheMetrics())
+	prometheus.MustRegister(newDistanceMetrics())
+	prometheus.MustRegister(accessLogMetrics())
 	return m
 }
 
 // SetBodyLatency publishes the current one-way latency (seconds) to a body.
 func (m *MetricsCollector) SetBodyLatency(body string, seconds float64) {
-	if m.spaceLatency != nil {
-		m.spaceLatency.WithLabelValues(body).Set(seconds)
+	if m == nil || m.spaceLatency == nil {
+		return
 	}
+	m.spaceLatency.WithLabelValues(body).Set(seconds)
 }
 
 // RecordRequest observes request duration and increments the total request count.
 // Labels: body (celestial body name), type (http/socks).
 func (m *MetricsCollector) RecordRequest(body, reqType string, duration time.Duration) {
+	if m == nil || m.requestsTotal == nil {
+		return
+	}
 	m.requestDuration.WithLabelValues(body, reqType).Observe(duration.Seconds())
 	m.requestsTotal.WithLabelValues(body, reqType).Inc()
 }
 
-// TrackBandwidth tracks outgoing bandwidth usage (client -> target).
-// Labels: body (celestial body name), direction ("out").
-func (m *MetricsCollector) TrackBandwidth(body string, bytes int64) {
-	if bytes > 0 {
-		// Assuming this tracks bytes sent *from* the proxy *to* the target
-		m.bandwidthUsage.WithLabelValues(body, "out").Add(float64(bytes))
+// Bandwidth directions, as seen from the client; the relay package's
+// directions (relay.Direction) carry the same labels.
+const (
+	dirToTarget = string(relay.ToTarget)
+	dirToClient = string(relay.ToClient)
+)
+
+// Protocols: which relay carried the bytes, or which path refused a
+// destination (RecordDestinationDenied).
+const (
+	protoSOCKSTCP   = "socks_tcp"
+	protoSOCKSUDP   = "socks_udp"
+	protoTCPForward = "tcp_forward"
+	protoHTTP       = "http"
+	protoGopher     = "gopher"
+)
+
+// TrackBandwidthDir counts application payload bytes relayed for body.
+// direction is dirToTarget or dirToClient; protocol one of the proto*
+// constants. Protocol framing (SOCKS UDP headers, HTTP headers) is not
+// counted.
+func (m *MetricsCollector) TrackBandwidthDir(body, protocol, direction string, bytes int64) {
+	if m == nil || m.bandwidthUsage == nil || bytes <= 0 {
+		return
 	}
+	m.bandwidthUsage.WithLabelValues(body, direction, protocol).Add(float64(bytes))
 }
 
-// RecordUDPPacket increments the UDP packet count and tracks incoming UDP bandwidth.
-// Labels: body (celestial body name), direction ("in").
-func (m *MetricsCollector) RecordUDPPacket(body string, bytes int64) {
+// TrackBandwidth counts bytes sent to the target over SOCKS TCP.
+//
+// Deprecated: use TrackBandwidthDir, which records direction and protocol.
+func (m *MetricsCollector) TrackBandwidth(body string, bytes int64) {
+	m.TrackBandwidthDir(body, protoSOCKSTCP, dirToTarget, bytes)
+}
+
+// RecordUDPPacket counts a UDP packet relayed back to the client. Its bytes
+// are tracked separately with TrackBandwidthDir.
+func (m *MetricsCollector) RecordUDPPacket(body string) {
+	if m == nil || m.udpPackets == nil {
+		return
+	}
 	m.udpPackets.WithLabelValues(body).Inc()
-	// Assuming this tracks bytes received *by* the proxy *from* the UDP client
-	m.bandwidthUsage.WithLabelValues(body, "in").Add(float64(bytes))
+}
+
+// RecordUDPDrop counts a UDP packet dropped by a per-association cap.
+func (m *MetricsCollector) RecordUDPDrop(body, reason string) {
+	if m == nil || m.udpDropped == nil {
+		return
+	}
+	m.udpDropped.WithLabelValues(body, reason).Inc()
+}
+
+// RecordUDPImpairment counts a UDP packet affected by the simulated link
+// (udpImpairDrop, udpImpairReorder or udpImpairDuplicate).
+func (m *MetricsCollector) RecordUDPImpairment(body, effect string) {
+	if m == nil || m.udpImpaired == nil {
+		return
+	}
+	m.udpImpaired.WithLabelValues(body, effect).Inc()
+}
+
+// socksReplyNames maps SOCKS5 reply codes to the socks_failures_total label.
+var socksReplyNames = map[byte]string{
+	SOCKS5_REP_GENERAL_FAILURE:     "general_failure",
+	SOCKS5_REP_CONN_NOT_ALLOWED:    "not_allowed",
+	SOCKS5_REP_NETWORK_UNREACHABLE: "network_unreachable",
+	SOCKS5_REP_HOST_UNREACHABLE:    "host_unreachable",
+	SOCKS5_REP_CONN_REFUSED:        "connection_refused",
+	SOCKS5_REP_TTL_EXPIRED:         "ttl_expired",
+	SOCKS5_REP_CMD_NOT_SUPPORTED:   "command_not_supported",
+	SOCKS5_REP_ADDR_NOT_SUPPORTED:  "address_not_supported",
+}
+
+// socksFailureLabel names a failure for socks_failures_total. The greeting's
+// "no acceptable auth method" is not a reply code but is counted alongside.
+func socksFailureLabel(rep byte) string {
+	if rep == SOCKS5_AUTH_NO_ACCEPTABLE {
+		return "no_acceptable_auth"
+	}
+	if name, ok := socksReplyNames[rep]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", rep)
+}
+
+// RecordSOCKSHandshake observes the greeting-to-reply time of one request.
+func (m *MetricsCollector) RecordSOCKSHandshake(success bool, d time.Duration) {
+	if m == nil || m.socksHandshake == nil {
+		return
+	}
+	m.socksHandshake.WithLabelValues(handshakeResult(success)).Observe(d.Seconds())
+}
+
+// handshakeResult labels a SOCKS handshake for socks_handshake_duration_seconds.
+func handshakeResult(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
+
+// RecordSOCKSDial observes the time spent dialing the upstream target.
+func (m *MetricsCollector) RecordSOCKSDial(body string, d time.Duration) {
+	if m == nil || m.socksDial == nil {
+		return
+	}
+	m.socksDial.WithLabelValues(body).Observe(d.Seconds())
+}
+
+// RecordSOCKSSession observes the lifetime of an established relay.
+func (m *MetricsCollector) RecordSOCKSSession(body string, d time.Duration) {
+	if m == nil || m.socksSession == nil {
+		return
+	}
+	m.socksSession.WithLabelValues(body).Observe(d.Seconds())
+}
+
+// RecordRelayEnd counts an established TCP relay by how it ended (relayEnd).
+func (m *MetricsCollector) RecordRelayEnd(body, protocol, outcome string) {
+	if m == nil || m.relayEnds == nil {
+		return
+	}
+	m.relayEnds.WithLabelValues(body, protocol, outcome).Inc()
+}
+
+// RecordPreflightProbe counts a reachability probe by its result.
+func (m *MetricsCollector) RecordPreflightProbe(result string) {
+	if m == nil || m.preflightProbes == nil {
+		return
+	}
+	m.preflightProbes.WithLabelValues(result).Inc()
+}
+
+// RecordPreflightLookup counts a pre-flight cache hit or miss.
+func (m *MetricsCollector) RecordPreflightLookup(result string) {
+	if m == nil || m.preflightLookups == nil {
+		return
+	}
+	m.preflightLookups.WithLabelValues(result).Inc()
+}
+
+// RecordOverhead observes the real time a sampled request spent outside
+// its simulated delays.
+func (m *MetricsCollector) RecordOverhead(body, path string, d time.Duration) {
+	if m == nil || m.overhead == nil {
+		return
+	}
+	m.overhead.WithLabelValues(body, path).Observe(d.Seconds())
+}
+
+// RecordDestinationDenied counts a destination refused by the allowlist
+// policy. protocol is one of the proto* constants, reason one of the deny*
+// constants.
+func (m *MetricsCollector) RecordDestinationDenied(protocol, reason string) {
+	if m == nil || m.destinationDenied == nil {
+		return
+	}
+	m.destinationDenied.WithLabelValues(protocol, reason).Inc()
+}
+
+// RecordDebugDenied counts an admin /_debug request refused by debugMux.
+// endpoint is the registered pattern, reason one of the debugDenied*
+// constants.
+func (m *MetricsCollector) RecordDebugDenied(endpoint, reason string) {
+	if m == nil || m.debugDenied == nil {
+		return
+	}
+	m.debugDenied.WithLabelValues(endpoint, reason).Inc()
+}
+
+// RecordRemoteDNSLookup counts a SOCKS target name looked up from body;
+// result is "hit" or "miss".
+func (m *MetricsCollector) RecordRemoteDNSLookup(body, result string) {
+	if m == nil || m.remoteDNSLookups == nil {
+		return
+	}
+	m.remoteDNSLookups.WithLabelValues(body, result).Inc()
+}
+
+// RecordCoalesced counts a DTN GET answered from an identical fetch in
+// flight, and the response bytes it didn't fetch.
+func (m *MetricsCollector) RecordCoalesced(body string, bytes int64) {
+	if m == nil || m.coalesced == nil {
+		return
+	}
+	m.coalesced.WithLabelValues(body).Inc()
+	m.coalescedBytes.WithLabelValues(body).Add(float64(bytes))
+}
+
+// RecordInternalState counts a request failed on an impossible state.
+func (m *MetricsCollector) RecordInternalState(reason string) {
+	if m == nil || m.internalState == nil {
+		return
+	}
+	m.internalState.WithLabelValues(reason).Inc()
+}
+
+// RecordOcclusionRejection counts a request, connection or packet refused
+// because body was occluded.
+func (m *MetricsCollector) RecordOcclusionRejection(body, protocol string) {
+	if m == nil || m.occlusionRejections == nil {
+		return
+	}
+	m.occlusionRejections.WithLabelValues(body, protocol).Inc()
+}
+
+// RecordSOCKSFailure counts a rejected SOCKS request by its reply code.
+func (m *MetricsCollector) RecordSOCKSFailure(rep byte) {
+	if m == nil || m.socksFailures == nil {
+		return
+	}
+	m.socksFailures.WithLabelValues(socksFailureLabel(rep)).Inc()
+}
+
+// RecordSOCKSLinkLostDuringSetup counts a CONNECT whose link was up when
+// requested but down once the latency sleep ended.
+func (m *MetricsCollector) RecordSOCKSLinkLostDuringSetup(body, reason string) {
+	if m == nil || m.socksLinkLost == nil {
+		return
+	}
+	m.socksLinkLost.WithLabelValues(body, reason).Inc()
+}
+
+// RecordLatencySplit observes the simulated delay and the real upstream time
+// of one request separately, so a slow destination is not mistaken for a far
+// away body (or vice versa).
+func (m *MetricsCollector) RecordLatencySplit(body, reqType string, simulated, upstream time.Duration) {
+	if m == nil || m.simulatedLatency == nil {
+		return
+	}
+	m.simulatedLatency.WithLabelValues(body, reqType).Observe(simulated.Seconds())
+	m.upstreamDuration.WithLabelValues(body, reqType).Observe(upstream.Seconds())
+}
+
+// RecordUpstreamConn counts one upstream request by whether its connection
+// was reused from the pool.
+func (m *MetricsCollector) RecordUpstreamConn(reused bool) {
+	if m == nil || m.upstreamConnsCreated == nil {
+		return
+	}
+	if reused {
+		m.upstreamConnsReused.Inc()
+	} else {
+		m.upstreamConnsCreated.Inc()
+	}
+}
+
+// RecordUpstreamRetry counts one retry of a failed upstream request.
+func (m *MetricsCollector) RecordUpstreamRetry(body string) {
+	if m == nil || m.upstreamRetries == nil {
+		return
+	}
+	m.upstreamRetries.WithLabelValues(body).Inc()
+}
+
+// RecordUpstreamOutcome counts how an upstream fetch finally ended after the
+// given number of attempts.
+func (m *MetricsCollector) RecordUpstreamOutcome(body string, attempts int, failed bool) {
+	if m == nil || m.upstreamOutcomes == nil {
+		return
+	}
+	m.upstreamOutcomes.WithLabelValues(body, upstreamOutcome(attempts, failed)).Inc()
+}
+
+// upstreamOutcome labels a finished fetch for proxy_upstream_outcomes_total.
+func upstreamOutcome(attempts int, failed bool) string {
+	switch {
+	case failed:
+		return "failed"
+	case attempts > 1:
+		return "recovered"
+	}
+	return "ok"
+}
+
+// RecordSizeLimit counts one request, response or session that passed the
+// size cap named by limit.
+func (m *MetricsCollector) RecordSizeLimit(body, limit string) {
+	if m == nil || m.sizeLimited == nil {
+		return
+	}
+	m.sizeLimited.WithLabelValues(body, limit).Inc()
 }
 
 // ServeMetrics starts an HTTP server to expose Prometheus metrics on the given
 // address. Intended to run in its own goroutine. A bind failure is logged but
 // NOT fatal: losing metrics scraping must never take down the proxy itself.
-func (m *MetricsCollector) ServeMetrics(addr string) {
+func ServeMetrics(addr string) {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
 	log.Printf("Starting Prometheus metrics server on %s", addr)
@@ -109,3 +575,12 @@ func (m *MetricsCollector) ServeMetrics(addr string) {
 		log.Printf("metrics server on %s stopped: %v", addr, err)
 	}
 }
+
+// distanceCacheMetrics exports the distance cache miss count. It is
+// process-wide, so only the registered collector publishes it.
+func distanceCacheMetrics() prometheus.Collector {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "distance_cache_misses_total",
+		Help: "Distance lookups the cache could not answer before a refresh",
+	}, func() float64 { return float64(distanceCacheMisses.Load()) })
+}
@@ -0,0 +1,195 @@
+// metrics_public.go - aggregate metrics anyone may scrape.
+//
+//	GET /metrics/public
+//
+// Community dashboards want service health, requests per body and current
+// light times, without the operator exposing client detail or quota
+// internals. /metrics/public passes on only publicMetricFamilies, and of
+// their labels only body and protocol: series that differed only in a
+// stripped label are added together (histograms bucket by bucket). It needs
+// no token, allows any origin and may be cached for publicMetricsMaxAge.
+//
+// latency_space_current_distance_km and latency_space_current_oneway_seconds
+// are read from the distance cache, so they change each time it is
+// refreshed and never report a body the cache doesn't hold.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	metricCurrentDistance = "latency_space_current_distance_km"
+	metricCurrentOneWay   = "latency_space_current_oneway_seconds"
+)
+
+// publicMetricsMaxAge is the Cache-Control max-age of /metrics/public, in
+// seconds. Counters move faster, but no dashboard needs them fresher.
+const publicMetricsMaxAge = 60
+
+// publicMetricFamilies are the families /metrics/public passes on: request
+// counts, the latency applied, links lost or refused to occlusion and the
+// distances.
+var publicMetricFamilies = map[string]bool{
+	metricRequests:            true,
+	metricRequestDuration:     true,
+	metricSimulatedLatency:    true,
+	metricSOCKSLinkLost:       true,
+	metricOcclusionRejections: true,
+	metricSpaceLatency:        true,
+	metricCurrentDistance:     true,
+	metricCurrentOneWay:       true,
+}
+
+// publicMetricLabels are the labels /metrics/public keeps.
+var publicMetricLabels = map[string]bool{"body": true, "protocol": true}
+
+// distanceMetrics exports the cached distance and one-way light time of
+// every body. It is process-wide, so only the registered collector
+// publishes it.
+type distanceMetrics struct {
+	distance, oneWay *prometheus.Desc
+}
+
+func newDistanceMetrics() distanceMetrics {
+	return distanceMetrics{
+		distance: prometheus.NewDesc(metricCurrentDistance, "Light-time corrected distance from Earth to each body, as of the last distance cache refresh", []string{"body"}, nil),
+		oneWay:   prometheus.NewDesc(metricCurrentOneWay, "One-way light time from Earth to each body, as of the last distance cache refresh", []string{"body"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (d distanceMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.distance
+	ch <- d.oneWay
+}
+
+// Collect implements prometheus.Collector.
+func (d distanceMetrics) Collect(ch chan<- prometheus.Metric) {
+	DistanceCacheMutex.RLock()
+	entries := distanceEntries
+	DistanceCacheMutex.RUnlock()
+	for _, e := range entries {
+		if e.Object.Type == "star" {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(d.distance, prometheus.GaugeValue, e.Distance, e.Object.Name)
+		ch <- prometheus.MustNewConstMetric(d.oneWay, prometheus.GaugeValue, CalculateLatency(e.Distance).Seconds(), e.Object.Name)
+	}
+}
+
+// publicGatherer passes on the families in allow with every label outside
+// publicMetricLabels stripped.
+type publicGatherer struct {
+	prometheus.Gatherer
+	allow map[string]bool
+}
+
+// Gather implements prometheus.Gatherer.
+func (g publicGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	var out []*dto.MetricFamily
+	for _, mf := range families {
+		if !g.allow[mf.GetName()] {
+			continue
+		}
+		if mf.Metric = aggregatePublic(mf.GetType(), mf.GetMetric()); len(mf.Metric) > 0 {
+			out = append(out, mf)
+		}
+	}
+	return out, err
+}
+
+// aggregatePublic strips metrics' private labels and adds up the series
+// that end up alike. Summaries, which can't be added, are dropped.
+func aggregatePublic(typ dto.MetricType, metrics []*dto.Metric) []*dto.Metric {
+	if typ == dto.MetricType_SUMMARY {
+		return nil
+	}
+	merged := make(map[string]*dto.Metric)
+	var order []string
+	for _, m := range metrics {
+		var kept []*dto.LabelPair
+		for _, lp := range m.GetLabel() {
+			if publicMetricLabels[lp.GetName()] {
+				kept = append(kept, lp)
+			}
+		}
+		m.Label = kept
+		m.TimestampMs = nil
+		key := labelKey(kept)
+		into, seen := merged[key]
+		if !seen {
+			merged[key] = m
+			order = append(order, key)
+			continue
+		}
+		addMetric(into, m)
+	}
+	sort.Strings(order)
+	out := make([]*dto.Metric, len(order))
+	for i, key := range order {
+		out[i] = merged[key]
+	}
+	return out
+}
+
+// labelKey identifies a label set.
+func labelKey(labels []*dto.LabelPair) string {
+	var b strings.Builder
+	for _, lp := range labels {
+		fmt.Fprintf(&b, "%s=%q,", lp.GetName(), lp.GetValue())
+	}
+	return b.String()
+}
+
+// addMetric adds m's value into into; both are of the same family.
+func addMetric(into, m *dto.Metric) {
+	add := func(a *float64, b float64) *float64 {
+		v := b
+		if a != nil {
+			v += *a
+		}
+		return &v
+	}
+	switch {
+	case into.Counter != nil && m.Counter != nil:
+		into.Counter.Value = add(into.Counter.Value, m.Counter.GetValue())
+		if m.Counter.CreatedTimestamp != nil && m.Counter.CreatedTimestamp.AsTime().Before(into.Counter.CreatedTimestamp.AsTime()) {
+			into.Counter.CreatedTimestamp = m.Counter.CreatedTimestamp
+		}
+	case into.Gauge != nil && m.Gauge != nil:
+		into.Gauge.Value = add(into.Gauge.Value, m.Gauge.GetValue())
+	case into.Untyped != nil && m.Untyped != nil:
+		into.Untyped.Value = add(into.Untyped.Value, m.Untyped.GetValue())
+	case into.Histogram != nil && m.Histogram != nil:
+		h, o := into.Histogram, m.Histogram
+		count := h.GetSampleCount() + o.GetSampleCount()
+		h.SampleCount = &count
+		h.SampleSum = add(h.SampleSum, o.GetSampleSum())
+		for i, b := range h.GetBucket() {
+			if i < len(o.GetBucket()) && o.Bucket[i].GetUpperBound() == b.GetUpperBound() {
+				cum := b.GetCumulativeCount() + o.Bucket[i].GetCumulativeCount()
+				b.CumulativeCount = &cum
+			}
+		}
+		if o.CreatedTimestamp != nil && o.CreatedTimestamp.AsTime().Before(h.CreatedTimestamp.AsTime()) {
+			h.CreatedTimestamp = o.CreatedTimestamp
+		}
+	}
+}
+
+// servePublicMetrics serves /metrics/public.
+func (s *Server) servePublicMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", publicMetricsMaxAge))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	opts := promhttp.HandlerOpts{EnableOpenMetrics: true}
+	promhttp.HandlerFor(publicGatherer{s.metricsGatherer(), publicMetricFamilies}, opts).ServeHTTP(w, r)
+}
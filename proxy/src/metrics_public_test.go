@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestPublicMetrics checks /metrics/public answers without a token, passes
+// on only the allowed families, and adds up series that differed only in a
+// stripped label.
+func TestPublicMetrics(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	m := newMetricsCollector("")
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m.requestsTotal, m.requestDuration, m.socksLinkLost, m.bandwidthUsage, m.socksHandshake, m.destinationDenied)
+	m.RecordRequest("Mars", "socks", time.Second)
+	m.RecordRequest("Mars", "socks", 2*time.Second)
+	m.RecordRequest("Mars", "http", 30*time.Second)
+	m.RecordRequest("Venus", "http", time.Second)
+	m.RecordSOCKSLinkLostDuringSetup("Mars", "occluded")
+	m.RecordSOCKSLinkLostDuringSetup("Mars", "no_contact")
+	m.TrackBandwidthDir("Mars", protoSOCKSTCP, dirToClient, 100)
+	m.RecordSOCKSHandshake(true, time.Millisecond)
+	m.RecordDestinationDenied(protoSOCKSTCP, denyHost)
+
+	families, err := publicGatherer{reg, publicMetricFamilies}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*dto.MetricFamily)
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+		for _, metric := range mf.GetMetric() {
+			for _, lp := range metric.GetLabel() {
+				if lp.GetName() != "body" && lp.GetName() != "protocol" {
+					t.Errorf("%s kept label %s", mf.GetName(), lp.GetName())
+				}
+			}
+		}
+	}
+	if len(byName) != 3 || byName[metricRequests] == nil || byName[metricRequestDuration] == nil || byName[metricSOCKSLinkLost] == nil {
+		t.Fatalf("gathered %v, want requests, their durations and links lost only", byName)
+	}
+	for _, metric := range byName[metricRequests].GetMetric() {
+		want := map[string]float64{"Mars": 3, "Venus": 1}[labelValue(metric, "body")]
+		if got := metric.GetCounter().GetValue(); got != want {
+			t.Errorf("%s for %s = %v, want %v", metricRequests, labelValue(metric, "body"), got, want)
+		}
+	}
+	if n := len(byName[metricRequests].GetMetric()); n != 2 {
+		t.Errorf("%d request series, want one per body", n)
+	}
+	mars := byName[metricRequestDuration].GetMetric()[0]
+	if h := mars.GetHistogram(); labelValue(mars, "body") != "Mars" || h.GetSampleCount() != 3 || h.GetSampleSum() != 33 {
+		t.Errorf("Mars request durations: %v", h)
+	}
+	if h := mars.GetHistogram(); h.GetBucket()[len(h.GetBucket())-1].GetCumulativeCount() != 2 {
+		t.Errorf("Mars's largest finite bucket holds %d, want 2 (the 30s request is above it)", h.GetBucket()[len(h.GetBucket())-1].GetCumulativeCount())
+	}
+	if got := byName[metricSOCKSLinkLost].GetMetric()[0].GetCounter().GetValue(); got != 2 {
+		t.Errorf("%s for Mars = %v, want 2", metricSOCKSLinkLost, got)
+	}
+
+	s := &Server{security: NewSecurityValidator(), metrics: m, gatherer: reg, adminToken: "t0k"}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/metrics/public", nil))
+	page := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(page, `requests_total{body="Mars"} 3`) {
+		t.Fatalf("/metrics/public: %d\n%s", rec.Code, page)
+	}
+	for _, leak := range []string{"bandwidth", "socks_handshake", "destination_denied", `type="`, `reason="`} {
+		if strings.Contains(page, leak) {
+			t.Errorf("/metrics/public exposes %s:\n%s", leak, page)
+		}
+	}
+	if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "public") || !strings.Contains(cc, "max-age=60") {
+		t.Errorf("Cache-Control %q", cc)
+	}
+}
+
+// TestDistanceMetricsRefresh checks the distance gauges follow the distance
+// cache from one refresh to the next.
+func TestDistanceMetricsRefresh(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newDistanceMetrics())
+	gauges := func() (km, seconds float64) {
+		t.Helper()
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, mf := range families {
+			for _, metric := range mf.GetMetric() {
+				if labelValue(metric, "body") == "Sun" {
+					t.Errorf("%s reports the Sun", mf.GetName())
+				}
+				if labelValue(metric, "body") != "Mars" {
+					continue
+				}
+				switch mf.GetName() {
+				case metricCurrentDistance:
+					km = metric.GetGauge().GetValue()
+				case metricCurrentOneWay:
+					seconds = metric.GetGauge().GetValue()
+				}
+			}
+		}
+		return km, seconds
+	}
+
+	if km, _ := gauges(); km != 0 {
+		t.Errorf("Mars at %v km before the cache was filled", km)
+	}
+	var last float64
+	for _, epoch := range []time.Time{classEpoch, classEpoch.AddDate(0, 3, 0)} {
+		withPinnedEpoch(t, epoch)
+		want := mustDistance(t, "Mars")
+		km, seconds := gauges()
+		if km != want || seconds != CalculateLatency(want).Seconds() {
+			t.Errorf("at %v: %v km, %v s; want %v km, %v s", epoch, km, seconds, want, CalculateLatency(want).Seconds())
+		}
+		if km == last {
+			t.Errorf("at %v: the gauge didn't move", epoch)
+		}
+		last = km
+	}
+}
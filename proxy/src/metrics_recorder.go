@@ -0,0 +1,278 @@
+// metrics_recorder.go - Metrics kept in memory.
+//
+// RecordingMetrics records what MetricsCollector would export, under the
+// same metric names and label values, in plain maps a test can read back
+// without going through Prometheus:
+//
+//	m := NewRecordingMetrics()
+//	... run a SOCKS CONNECT with m ...
+//	m.Count("requests_total", "Mars", "socks")          // 1
+//	len(m.Observations("socks_dial_duration_seconds"))  // 1
+//
+// A nil *RecordingMetrics records nothing; NopMetrics is one, for
+// benchmarks that shouldn't pay for metrics at all.
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// NopMetrics discards everything recorded to it.
+var NopMetrics Metrics = (*RecordingMetrics)(nil)
+
+// RecordingMetrics is an in-memory Metrics. Series are keyed by metric name
+// and label values, in the order MetricsCollector labels them.
+type RecordingMetrics struct {
+	mu           sync.Mutex
+	counters     map[string]map[string]float64   // name -> labels -> value
+	gauges       map[string]map[string]float64   // name -> labels -> value
+	observations map[string]map[string][]float64 // name -> labels -> seconds
+}
+
+// NewRecordingMetrics returns an empty recorder.
+func NewRecordingMetrics() *RecordingMetrics {
+	return &RecordingMetrics{
+		counters:     make(map[string]map[string]float64),
+		gauges:       make(map[string]map[string]float64),
+		observations: make(map[string]map[string][]float64),
+	}
+}
+
+// seriesKey joins label values; \xff can't occur in a UTF-8 label.
+func seriesKey(labels []string) string {
+	return strings.Join(labels, "\xff")
+}
+
+// matches reports whether the series key has the given leading label
+// values; no labels match every series.
+func matches(key string, labels []string) bool {
+	if len(labels) == 0 {
+		return true
+	}
+	prefix := seriesKey(labels)
+	return key == prefix || strings.HasPrefix(key, prefix+"\xff")
+}
+
+func (r *RecordingMetrics) add(name string, v float64, labels ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.counters[name] == nil {
+		r.counters[name] = make(map[string]float64)
+	}
+	r.counters[name][seriesKey(labels)] += v
+}
+
+func (r *RecordingMetrics) observe(name string, d time.Duration, labels ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.observations[name] == nil {
+		r.observations[name] = make(map[string][]float64)
+	}
+	key := seriesKey(labels)
+	r.observations[name][key] = append(r.observations[name][key], d.Seconds())
+}
+
+// Count is the counter name summed over the series whose leading label
+// values are labels: all of them when labels is empty.
+func (r *RecordingMetrics) Count(name string, labels ...string) float64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var total float64
+	for key, v := range r.counters[name] {
+		if matches(key, labels) {
+			total += v
+		}
+	}
+	return total
+}
+
+// Gauge is the last value set on the gauge series name{labels}.
+func (r *RecordingMetrics) Gauge(name string, labels ...string) float64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gauges[name][seriesKey(labels)]
+}
+
+// Observations are the histogram name's observations, in seconds, from the
+// series whose leading label values are labels.
+func (r *RecordingMetrics) Observations(name string, labels ...string) []float64 {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []float64
+	for key, obs := range r.observations[name] {
+		if matches(key, labels) {
+			out = append(out, obs...)
+		}
+	}
+	return out
+}
+
+// SetBodyLatency implements Metrics.
+func (r *RecordingMetrics) SetBodyLatency(body string, seconds float64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.gauges[metricSpaceLatency] == nil {
+		r.gauges[metricSpaceLatency] = make(map[string]float64)
+	}
+	r.gauges[metricSpaceLatency][body] = seconds
+}
+
+// RecordRequest implements Metrics.
+func (r *RecordingMetrics) RecordRequest(body, reqType string, duration time.Duration) {
+	r.observe(metricRequestDuration, duration, body, reqType)
+	r.add(metricRequests, 1, body, reqType)
+}
+
+// TrackBandwidthDir implements Metrics.
+func (r *RecordingMetrics) TrackBandwidthDir(body, protocol, direction string, bytes int64) {
+	if bytes <= 0 {
+		return
+	}
+	r.add(metricBandwidth, float64(bytes), body, direction, protocol)
+}
+
+// TrackBandwidth implements Metrics.
+func (r *RecordingMetrics) TrackBandwidth(body string, bytes int64) {
+	r.TrackBandwidthDir(body, protoSOCKSTCP, dirToTarget, bytes)
+}
+
+// RecordUDPPacket implements Metrics.
+func (r *RecordingMetrics) RecordUDPPacket(body string) {
+	r.add(metricUDPPackets, 1, body)
+}
+
+// RecordUDPDrop implements Metrics.
+func (r *RecordingMetrics) RecordUDPDrop(body, reason string) {
+	r.add(metricUDPDropped, 1, body, reason)
+}
+
+// RecordUDPImpairment implements Metrics.
+func (r *RecordingMetrics) RecordUDPImpairment(body, effect string) {
+	r.add(metricUDPImpaired, 1, body, effect)
+}
+
+// RecordSOCKSHandshake implements Metrics.
+func (r *RecordingMetrics) RecordSOCKSHandshake(success bool, d time.Duration) {
+	r.observe(metricSOCKSHandshake, d, handshakeResult(success))
+}
+
+// RecordSOCKSDial implements Metrics.
+func (r *RecordingMetrics) RecordSOCKSDial(body string, d time.Duration) {
+	r.observe(metricSOCKSDial, d, body)
+}
+
+// RecordSOCKSSession implements Metrics.
+func (r *RecordingMetrics) RecordSOCKSSession(body string, d time.Duration) {
+	r.observe(metricSOCKSSession, d, body)
+}
+
+// RecordSOCKSFailure implements Metrics.
+func (r *RecordingMetrics) RecordSOCKSFailure(rep byte) {
+	r.add(metricSOCKSFailures, 1, socksFailureLabel(rep))
+}
+
+// RecordSOCKSLinkLostDuringSetup implements Metrics.
+func (r *RecordingMetrics) RecordSOCKSLinkLostDuringSetup(body, reason string) {
+	r.add(metricSOCKSLinkLost, 1, body, reason)
+}
+
+// RecordRelayEnd implements Metrics.
+func (r *RecordingMetrics) RecordRelayEnd(body, protocol, outcome string) {
+	r.add(metricRelayEnds, 1, body, protocol, outcome)
+}
+
+// RecordLatencySplit implements Metrics.
+func (r *RecordingMetrics) RecordLatencySplit(body, reqType string, simulated, upstream time.Duration) {
+	r.observe(metricSimulatedLatency, simulated, body, reqType)
+	r.observe(metricUpstreamDuration, upstream, body, reqType)
+}
+
+// RecordUpstreamConn implements Metrics.
+func (r *RecordingMetrics) RecordUpstreamConn(reused bool) {
+	if reused {
+		r.add(metricUpstreamConnsReused, 1)
+	} else {
+		r.add(metricUpstreamConnsCreated, 1)
+	}
+}
+
+// RecordUpstreamRetry implements Metrics.
+func (r *RecordingMetrics) RecordUpstreamRetry(body string) {
+	r.add(metricUpstreamRetries, 1, body)
+}
+
+// RecordUpstreamOutcome implements Metrics.
+func (r *RecordingMetrics) RecordUpstreamOutcome(body string, attempts int, failed bool) {
+	r.add(metricUpstreamOutcomes, 1, body, upstreamOutcome(attempts, failed))
+}
+
+// RecordSizeLimit implements Metrics.
+func (r *RecordingMetrics) RecordSizeLimit(body, limit string) {
+	r.add(metricSizeLimited, 1, body, limit)
+}
+
+// RecordPreflightProbe implements Metrics.
+func (r *RecordingMetrics) RecordPreflightProbe(result string) {
+	r.add(metricPreflightProbes, 1, result)
+}
+
+// RecordPreflightLookup implements Metrics.
+func (r *RecordingMetrics) RecordPreflightLookup(result string) {
+	r.add(metricPreflightCache, 1, result)
+}
+
+// RecordOverhead implements Metrics.
+func (r *RecordingMetrics) RecordOverhead(body, path string, d time.Duration) {
+	r.observe(metricOverhead, d, body, path)
+}
+
+// RecordDestinationDenied implements Metrics.
+func (r *RecordingMetrics) RecordDestinationDenied(protocol, reason string) {
+	r.add(metricDestinationDenied, 1, protocol, reason)
+}
+
+// RecordDebugDenied implements Metrics.
+func (r *RecordingMetrics) RecordDebugDenied(endpoint, reason string) {
+	r.add(metricDebugDenied, 1, endpoint, reason)
+}
+
+// RecordRemoteDNSLookup implements Metrics.
+func (r *RecordingMetrics) RecordRemoteDNSLookup(body, result string) {
+	r.add(metricRemoteDNSCache, 1, body, result)
+}
+
+// RecordInternalState implements Metrics.
+func (r *RecordingMetrics) RecordInternalState(reason string) {
+	r.add(metricInternalState, 1, reason)
+}
+
+// RecordOcclusionRejection implements Metrics.
+func (r *RecordingMetrics) RecordOcclusionRejection(body, protocol string) {
+	r.add(metricOcclusionRejections, 1, body, protocol)
+}
+
+// RecordCoalesced implements Metrics.
+func (r *RecordingMetrics) RecordCoalesced(body string, bytes int64) {
+	r.add(metricCoalesced, 1, body)
+	r.add(metricCoalescedBytes, float64(bytes), body)
+}
@@ -0,0 +1,315 @@
+// metrics_scope.go - per-body views of the Prometheus metrics.
+//
+//	GET <body>.latency.space/metrics           only the series labelled body=<body>
+//	GET latency.space/metrics                   every series; needs the admin token
+//	GET /metrics/public                         aggregates for anyone (metrics_public.go)
+//	GET /api/metrics-summary[?body=<body>]      a compact JSON summary for one body
+//
+// A public "Mars link status" page can point at mars.latency.space/metrics
+// without exposing other bodies' traffic: series without a body label (SOCKS
+// handshakes, the distance cache, ...) are left out too. The dedicated
+// METRICS_ADDR listener that Prometheus scrapes is unaffected.
+//
+// The summary is read from the collectors directly, so it needs no
+// Prometheus server. Counters only ever grow, so the last hour's requests
+// come from per-minute samples of requests_total kept by requestHistory.
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// requestHistoryInterval is how often requests_total is sampled.
+	requestHistoryInterval = time.Minute
+	// metricsSummaryWindow is the span of the summary's request count.
+	metricsSummaryWindow = time.Hour
+)
+
+// bodyGatherer passes on only the series whose body label is body.
+type bodyGatherer struct {
+	prometheus.Gatherer
+	body string
+}
+
+// Gather implements prometheus.Gatherer.
+func (g bodyGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	var out []*dto.MetricFamily
+	for _, mf := range families {
+		var kept []*dto.Metric
+		for _, m := range mf.GetMetric() {
+			if hasBodyLabel(m, g.body) {
+				kept = append(kept, m)
+			}
+		}
+		if len(kept) > 0 {
+			mf.Metric = kept
+			out = append(out, mf)
+		}
+	}
+	return out, err
+}
+
+// hasBodyLabel reports whether m is labelled with body.
+func hasBodyLabel(m *dto.Metric, body string) bool {
+	for _, lp := range m.GetLabel() {
+		if lp.GetName() == "body" {
+			return strings.EqualFold(lp.GetValue(), body)
+		}
+	}
+	return false
+}
+
+// metricsGatherer is where /metrics reads from.
+func (s *Server) metricsGatherer() prometheus.Gatherer {
+	if s.gatherer != nil {
+		return s.gatherer
+	}
+	return prometheus.DefaultGatherer
+}
+
+// serveMetrics serves /metrics: a body's series on its own host, everything
+// on the apex for the operator.
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	opts := promhttp.HandlerOpts{EnableOpenMetrics: true}
+	if body := s.resolveCelestialHost(getCelestialObjects(), r.Host); body != "" {
+		promhttp.HandlerFor(bodyGatherer{s.metricsGatherer(), body}, opts).ServeHTTP(w, r)
+		return
+	}
+	if s.requireAdmin(w, r) {
+		promhttp.HandlerFor(s.metricsGatherer(), opts).ServeHTTP(w, r)
+	}
+}
+
+// collect returns c's series.
+func collect(c prometheus.Collector) []*dto.Metric {
+	if c == nil {
+		return nil
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var out []*dto.Metric
+	for metric := range ch {
+		var m dto.Metric
+		if metric.Write(&m) == nil {
+			out = append(out, &m)
+		}
+	}
+	return out
+}
+
+// collectBody returns c's series labelled with body.
+func collectBody(c prometheus.Collector, body string) []*dto.Metric {
+	var out []*dto.Metric
+	for _, m := range collect(c) {
+		if hasBodyLabel(m, body) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// labelValue returns m's value for the label name.
+func labelValue(m *dto.Metric, name string) string {
+	for _, lp := range m.GetLabel() {
+		if lp.GetName() == name {
+			return lp.GetValue()
+		}
+	}
+	return ""
+}
+
+// sumCounters adds up the counters in metrics.
+func sumCounters(metrics []*dto.Metric) float64 {
+	var total float64
+	for _, m := range metrics {
+		total += m.GetCounter().GetValue()
+	}
+	return total
+}
+
+// histogramQuantile estimates the q quantile of the histograms in metrics,
+// merged, by interpolating linearly within the bucket it falls in, as
+// Prometheus's histogram_quantile does. It is NaN without observations.
+func histogramQuantile(q float64, metrics []*dto.Metric) float64 {
+	counts := make(map[float64]uint64)
+	var total uint64
+	for _, m := range metrics {
+		h := m.GetHistogram()
+		total += h.GetSampleCount()
+		for _, b := range h.GetBucket() {
+			counts[b.GetUpperBound()] += b.GetCumulativeCount()
+		}
+	}
+	if total == 0 {
+		return math.NaN()
+	}
+	bounds := make([]float64, 0, len(counts))
+	for ub := range counts {
+		bounds = append(bounds, ub)
+	}
+	sort.Float64s(bounds)
+
+	rank := q * float64(total)
+	lower, below := 0.0, uint64(0)
+	for _, ub := range bounds {
+		cum := counts[ub]
+		if float64(cum) >= rank {
+			if cum == below {
+				return ub
+			}
+			return lower + (ub-lower)*(rank-float64(below))/float64(cum-below)
+		}
+		lower, below = ub, cum
+	}
+	// In the +Inf bucket: the highest finite bound is the best estimate.
+	return lower
+}
+
+// requestHistory keeps per-minute samples of each body's request total, an
+// hour and a bit of them, so a window's requests are the difference between
+// now and the sample at its start.
+type requestHistory struct {
+	mu      sync.Mutex
+	samples []requestSample // oldest first
+}
+
+type requestSample struct {
+	at     time.Time
+	totals map[string]float64 // body -> requests_total
+}
+
+// record appends a sample, dropping those no window needs any more.
+func (h *requestHistory) record(at time.Time, totals map[string]float64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples = append(h.samples, requestSample{at: at, totals: totals})
+	// Keep the newest sample at or before the window's start; older ones go.
+	cut := 0
+	for i, sample := range h.samples {
+		if !sample.at.After(at.Add(-metricsSummaryWindow)) {
+			cut = i
+		}
+	}
+	h.samples = h.samples[cut:]
+}
+
+// since returns body's request total at the start of the window ending at
+// now: the newest sample at or before then. Without one the process is
+// younger than the window, so every request counted falls inside it.
+func (h *requestHistory) since(body string, now time.Time) float64 {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var base float64
+	for _, sample := range h.samples {
+		if sample.at.After(now.Add(-metricsSummaryWindow)) {
+			break
+		}
+		base = sample.totals[body]
+	}
+	return base
+}
+
+// collector is the Prometheus collector behind s.metrics; nil when metrics
+// are recorded elsewhere, and the summary then has nothing to read.
+func (s *Server) collector() *MetricsCollector {
+	m, _ := s.metrics.(*MetricsCollector)
+	return m
+}
+
+// requestTotals returns requests_total summed per body.
+func (m *MetricsCollector) requestTotals() map[string]float64 {
+	totals := make(map[string]float64)
+	if m == nil || m.requestsTotal == nil {
+		return totals
+	}
+	for _, c := range collect(m.requestsTotal) {
+		totals[labelValue(c, "body")] += c.GetCounter().GetValue()
+	}
+	return totals
+}
+
+// MetricsSummary is the JSON returned by /api/metrics-summary.
+type MetricsSummary struct {
+	Body             string           `json:"body"`
+	RequestsLastHour int64            `json:"requestsLastHour"`
+	RequestsTotal    int64            `json:"requestsTotal"`
+	Bytes            map[string]int64 `json:"bytes"`                              // by direction: to_target, to_client
+	LatencyP50       *float64         `json:"appliedLatencyP50Seconds,omitempty"` // absent before any request
+	LatencyP95       *float64         `json:"appliedLatencyP95Seconds,omitempty"` // absent before any request
+	Rejections       map[string]int64 `json:"rejections"`                         // by cause
+}
+
+// metricsSummary summarises body's series at now.
+func (s *Server) metricsSummary(body string, now time.Time) MetricsSummary {
+	out := MetricsSummary{
+		Body:  body,
+		Bytes: map[string]int64{dirToTarget: 0, dirToClient: 0},
+		Rejections: map[string]int64{
+			"size_limit_" + sizeLimitRequest:      0,
+			"size_limit_" + sizeLimitResponse:     0,
+			"size_limit_" + sizeLimitSOCKSSession: 0,
+		},
+	}
+	m := s.collector()
+	if m == nil {
+		return out
+	}
+	total := sumCounters(collectBody(m.requestsTotal, body))
+	out.RequestsTotal = int64(total)
+	out.RequestsLastHour = int64(total - s.requestHistory.since(body, now))
+	for _, b := range collectBody(m.bandwidthUsage, body) {
+		out.Bytes[labelValue(b, "direction")] += int64(b.GetCounter().GetValue())
+	}
+
+	latency := collectBody(m.simulatedLatency, body)
+	if p50 := histogramQuantile(0.5, latency); !math.IsNaN(p50) {
+		p95 := histogramQuantile(0.95, latency)
+		out.LatencyP50, out.LatencyP95 = &p50, &p95
+	}
+
+	for _, r := range collectBody(m.sizeLimited, body) {
+		out.Rejections["size_limit_"+labelValue(r, "limit")] += int64(r.GetCounter().GetValue())
+	}
+	out.Rejections["link_lost"] = int64(sumCounters(collectBody(m.socksLinkLost, body)))
+	out.Rejections["udp_dropped"] = int64(sumCounters(collectBody(m.udpDropped, body)))
+	return out
+}
+
+// handleMetricsSummary serves /api/metrics-summary for the host's body or
+// ?body=.
+func (s *Server) handleMetricsSummary(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Query().Get("body"))
+	if name == "" {
+		name = s.resolveCelestialHost(getCelestialObjects(), r.Host)
+	}
+	if name == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.metrics_body")
+		return
+	}
+	obj, ok := findObjectByName(getCelestialObjects(), name)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotFound, "error.unknown_body_named", name)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.metricsSummary(obj.Name, s.now()))
+}
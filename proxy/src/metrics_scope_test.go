@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestMetricsScopedByHost gathers through the body filter and checks no
+// other body's series, or unlabelled ones, get through.
+func TestMetricsScopedByHost(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	m := newMetricsCollector("test_")
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(m.requestsTotal, m.bandwidthUsage, m.socksHandshake)
+	for _, body := range []string{"Mars", "Venus", "Voyager 1"} {
+		m.RecordRequest(body, "socks", time.Second)
+		m.TrackBandwidthDir(body, protoSOCKSTCP, dirToClient, 100)
+	}
+	m.RecordSOCKSHandshake(true, time.Millisecond)
+	s := &Server{security: NewSecurityValidator(), metrics: m, gatherer: reg, adminToken: "t0k"}
+
+	families, err := bodyGatherer{reg, "mars"}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 2 {
+		t.Errorf("%d families gathered for Mars, want requests and bandwidth only", len(families))
+	}
+	for _, mf := range families {
+		for _, metric := range mf.GetMetric() {
+			if got := labelValue(metric, "body"); got != "Mars" {
+				t.Errorf("%s: series for %q gathered for Mars", mf.GetName(), got)
+			}
+		}
+	}
+
+	get := func(url string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, req)
+		return rec
+	}
+	openMetrics := http.Header{"Accept": {"application/openmetrics-text; version=1.0.0"}}
+	rec := get("http://voyager-1.latency.space/metrics", openMetrics)
+	page := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(page, `test_requests_total{body="Voyager 1",type="socks"} 1`) {
+		t.Fatalf("voyager-1 /metrics: %d\n%s", rec.Code, page)
+	}
+	if strings.Contains(page, "Mars") || strings.Contains(page, "Venus") || strings.Contains(page, "socks_handshake") {
+		t.Errorf("voyager-1 /metrics exposes other series:\n%s", page)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") || !strings.HasSuffix(page, "# EOF\n") {
+		t.Errorf("not an OpenMetrics exposition: %q", ct)
+	}
+
+	// The apex has everything, for the operator only.
+	if rec := get("http://latency.space/metrics", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("apex /metrics without the token: %d", rec.Code)
+	}
+	rec = get("http://latency.space/_debug/metrics", http.Header{"Authorization": {"Bearer t0k"}})
+	for _, want := range []string{`body="Mars"`, `body="Venus"`, "test_socks_handshake_duration_seconds"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("apex /_debug/metrics with the token lacks %s: %d", want, rec.Code)
+		}
+	}
+}
+
+// TestMetricsSummary seeds Mars's counters and history and checks the
+// summary's arithmetic; Venus's series must not leak into it.
+func TestMetricsSummary(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	m := newMetricsCollector("test_")
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	s := &Server{security: NewSecurityValidator(), metrics: m, requestHistory: &requestHistory{},
+		timing: timing{clock: newFakeClock(now)}}
+
+	for body, n := range map[string]int{"Mars": 12, "Venus": 40} {
+		for i := 0; i < n; i++ {
+			m.RecordRequest(body, []string{"http", "socks"}[i%2], time.Second)
+		}
+	}
+	// Hour-old samples: the newest at or before 11:00 is the baseline.
+	for _, sample := range []struct {
+		ago  time.Duration
+		mars float64
+	}{{90 * time.Minute, 2}, {61 * time.Minute, 4}, {30 * time.Minute, 9}, {0, 12}} {
+		s.requestHistory.record(now.Add(-sample.ago), map[string]float64{"Mars": sample.mars, "Venus": 1})
+	}
+	if len(s.requestHistory.samples) != 3 {
+		t.Errorf("history kept %d samples, want the baseline and the two after it", len(s.requestHistory.samples))
+	}
+
+	m.TrackBandwidthDir("Mars", protoSOCKSTCP, dirToTarget, 100)
+	m.TrackBandwidthDir("Mars", protoHTTP, dirToTarget, 50)
+	m.TrackBandwidthDir("Mars", protoSOCKSTCP, dirToClient, 300)
+	m.TrackBandwidthDir("Venus", protoSOCKSTCP, dirToClient, 7000)
+	// Four delays in the (0.04, 0.16] bucket and six in (0.16, 0.64].
+	for i := 0; i < 10; i++ {
+		d := 100 * time.Millisecond
+		if i >= 4 {
+			d = 500 * time.Millisecond
+		}
+		m.RecordLatencySplit("Mars", "socks", d, 0)
+	}
+	m.RecordLatencySplit("Venus", "socks", time.Minute, 0)
+	m.RecordSizeLimit("Mars", sizeLimitRequest)
+	m.RecordSizeLimit("Mars", sizeLimitRequest)
+	m.RecordSOCKSLinkLostDuringSetup("Mars", "occluded")
+	m.RecordUDPDrop("Mars", "rate")
+	m.RecordUDPDrop("Venus", "rate")
+
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://mars.latency.space/api/metrics-summary", nil))
+	var got MetricsSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	if got.Body != "Mars" || got.RequestsTotal != 12 || got.RequestsLastHour != 12-4 {
+		t.Errorf("requests: %+v", got)
+	}
+	if got.Bytes[dirToTarget] != 150 || got.Bytes[dirToClient] != 300 {
+		t.Errorf("bytes: %v", got.Bytes)
+	}
+	// Ranks 5 and 9.5 of 10 fall 1/6 and 5.5/6 of the way through (0.16, 0.64].
+	if got.LatencyP50 == nil || math.Abs(*got.LatencyP50-0.24) > 1e-9 || math.Abs(*got.LatencyP95-0.6) > 1e-9 {
+		t.Errorf("latency percentiles: %v, %v", got.LatencyP50, got.LatencyP95)
+	}
+	want := map[string]int64{"size_limit_request": 2, "size_limit_response": 0, "size_limit_socks_session": 0, "link_lost": 1, "udp_dropped": 1}
+	for k, v := range want {
+		if got.Rejections[k] != v {
+			t.Errorf("rejections[%s] = %d, want %d (%v)", k, got.Rejections[k], v, got.Rejections)
+		}
+	}
+
+	// A body with no traffic yet has no percentiles; an unknown one is a 404.
+	rec = httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/metrics-summary?body=jupiter", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if rec.Code != http.StatusOK || strings.Contains(string(body), "appliedLatency") {
+		t.Errorf("quiet body: %d %s", rec.Code, body)
+	}
+	rec = httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/metrics-summary?body=vulcan", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown body: %d", rec.Code)
+	}
+}
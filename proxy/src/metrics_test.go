@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// startSizedServer accepts connections that read request bytes and answer
+// with reply bytes, so the two directions carry different amounts.
+func startSizedServer(t *testing.T, request, reply int) *net.TCPAddr {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if _, err := io.ReadFull(c, make([]byte, request)); err == nil {
+					c.Write(make([]byte, reply))
+				}
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr)
+}
+
+// exchange sends request bytes on conn, reads the reply to EOF and returns
+// its length.
+func exchange(t *testing.T, conn net.Conn, request int) int {
+	t.Helper()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(make([]byte, request)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(got)
+}
+
+// recorded is the RecordingMetrics a test server or handler records to.
+func recorded(t *testing.T, m Metrics) *RecordingMetrics {
+	t.Helper()
+	r, ok := m.(*RecordingMetrics)
+	if !ok {
+		t.Fatalf("metrics are a %T, not a *RecordingMetrics", m)
+	}
+	return r
+}
+
+// wantBandwidth waits for the relay to finish counting, then checks both
+// directions for body and protocol.
+func wantBandwidth(t *testing.T, m *RecordingMetrics, body, protocol string, toTarget, toClient float64) {
+	t.Helper()
+	read := func() (float64, float64) {
+		return m.Count(metricBandwidth, body, dirToTarget, protocol),
+			m.Count(metricBandwidth, body, dirToClient, protocol)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		up, down := read()
+		if up == toTarget && down == toClient {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("%s: counted %v to target and %v to client, want %v and %v", protocol, up, down, toTarget, toClient)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestBandwidthByDirection pushes known byte counts through each relay and
+// checks each direction is counted once, as payload only.
+func TestBandwidthByDirection(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+
+	t.Run("socks tcp", func(t *testing.T) {
+		dest := startSizedServer(t, 1000, 300)
+		s := &Server{security: newTestSecurity(), metrics: NewRecordingMetrics(),
+			limiter: NewRateLimiter(60, 5, 3, 0), fixedCelestialBody: "Mars", timing: fixedLatency(5 * time.Millisecond)}
+		proxy := startTestSOCKS(t, s)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, _, err := client.Dial(ctx, proxy, dest.String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		if n := exchange(t, conn, 1000); n != 300 {
+			t.Fatalf("got %d reply bytes, want 300", n)
+		}
+		wantBandwidth(t, recorded(t, s.metrics), "Mars", protoSOCKSTCP, 1000, 300)
+	})
+
+	t.Run("tcp forward", func(t *testing.T) {
+		dest := startSizedServer(t, 700, 2000)
+		f := newTestForwarder(t, "mars", dest.String())
+		if err := f.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		go f.Serve()
+		defer f.Stop()
+		conn, err := net.Dial("tcp", f.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := exchange(t, conn, 700); n != 2000 {
+			t.Fatalf("got %d reply bytes, want 2000", n)
+		}
+		wantBandwidth(t, recorded(t, f.metrics), f.body, protoTCPForward, 700, 2000)
+	})
+
+	t.Run("socks udp", func(t *testing.T) {
+		security, metrics := newTestSecurity(), NewRecordingMetrics()
+		echo := startUDPEcho(t, security)
+		code, relay := udpAssociate(t, security, metrics, UDPLimits{})
+		if code != SOCKS5_REP_SUCCESS {
+			t.Fatalf("UDP ASSOCIATE failed: 0x%02x", code)
+		}
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+		for i := 0; i < 5; i++ {
+			pc.WriteTo(buildUDPSocksPacket(echo, make([]byte, 100)), relay)
+		}
+		if got := countEchoes(pc, 300*time.Millisecond); got != 5 {
+			t.Fatalf("got %d replies, want 5", got)
+		}
+		// The replies carry 10-byte SOCKS headers; only the payload counts.
+		wantBandwidth(t, metrics, "Mars", protoSOCKSUDP, 500, 500)
+		if n := metrics.Count(metricUDPPackets, "Mars"); n != 5 {
+			t.Errorf("counted %v UDP packets, want 5", n)
+		}
+	})
+
+	t.Run("http", func(t *testing.T) {
+		const reqBody = "0123456789abcdef0123"
+		dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			fmt.Fprint(w, strings.Repeat("x", 4096))
+		}))
+		defer dest.Close()
+		s := newDTNTestServer(t)
+		s.timing = fixedLatency(5 * time.Millisecond)
+		code, out := dtnSend(t, s, "mars.latency.space",
+			fmt.Sprintf(`{"url":%q,"method":"POST","payload":%q}`, dest.URL, reqBody))
+		if code != http.StatusAccepted {
+			t.Fatalf("send: expected 202, got %d (%v)", code, out)
+		}
+		wantBandwidth(t, recorded(t, s.metrics), "Mars", protoHTTP, float64(len(reqBody)), 4096)
+	})
+
+	t.Run("deprecated shim", func(t *testing.T) {
+		m := NewRecordingMetrics()
+		m.TrackBandwidth("Mars", 42)
+		wantBandwidth(t, m, "Mars", protoSOCKSTCP, 42, 0)
+	})
+}
+
+// TestRecordingMetricsMatchesCollector records the same events to both
+// Metrics and checks the recorder reads back what Prometheus exports.
+func TestRecordingMetricsMatchesCollector(t *testing.T) {
+	prom, rec := newMetricsCollector(""), NewRecordingMetrics()
+	for _, m := range []Metrics{prom, rec, NopMetrics} {
+		m.RecordRequest("Mars", "socks", time.Second)
+		m.RecordRequest("Mars", "dtn", time.Second)
+		m.TrackBandwidthDir("Mars", protoSOCKSTCP, dirToClient, 300)
+		m.TrackBandwidthDir("Venus", protoSOCKSTCP, dirToClient, 0)
+		m.RecordSOCKSFailure(SOCKS5_REP_CONN_REFUSED)
+		m.RecordUpstreamOutcome("Mars", 2, false)
+		m.RecordUpstreamConn(true)
+		m.RecordSOCKSHandshake(false, time.Millisecond)
+		m.SetBodyLatency("Mars", 180)
+	}
+
+	for _, c := range []struct {
+		prom   float64
+		rec    float64
+		series string
+	}{
+		{testutil.ToFloat64(prom.requestsTotal.WithLabelValues("Mars", "socks")), rec.Count(metricRequests, "Mars", "socks"), "requests{Mars,socks}"},
+		{2, rec.Count(metricRequests, "Mars"), "requests{Mars}"},
+		{testutil.ToFloat64(prom.bandwidthUsage.WithLabelValues("Mars", dirToClient, protoSOCKSTCP)), rec.Count(metricBandwidth, "Mars", dirToClient, protoSOCKSTCP), "bandwidth"},
+		{float64(testutil.CollectAndCount(prom.bandwidthUsage)), rec.Count(metricBandwidth, "Venus") + 1, "bandwidth series (zero bytes not counted)"},
+		{testutil.ToFloat64(prom.socksFailures.WithLabelValues("connection_refused")), rec.Count(metricSOCKSFailures, "connection_refused"), "socks failures"},
+		{testutil.ToFloat64(prom.upstreamOutcomes.WithLabelValues("Mars", "recovered")), rec.Count(metricUpstreamOutcomes, "Mars", "recovered"), "upstream outcomes"},
+		{testutil.ToFloat64(prom.upstreamConnsReused), rec.Count(metricUpstreamConnsReused), "reused connections"},
+		{float64(testutil.CollectAndCount(prom.socksHandshake)), float64(len(rec.Observations(metricSOCKSHandshake, "failure"))), "handshakes"},
+		{testutil.ToFloat64(prom.spaceLatency.WithLabelValues("Mars")), rec.Gauge(metricSpaceLatency, "Mars"), "latency gauge"},
+	} {
+		if c.prom != c.rec {
+			t.Errorf("%s: Prometheus has %v, the recorder %v", c.series, c.prom, c.rec)
+		}
+	}
+	if n := NopMetrics.(*RecordingMetrics).Count(metricRequests); n != 0 {
+		t.Errorf("NopMetrics recorded %v requests", n)
+	}
+}
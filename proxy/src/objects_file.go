@@ -0,0 +1,250 @@
+// objects_file.go - celestial object definitions loaded from an external file.
+//
+// With -objects-file set, the file's objects are merged by name (case-
+// insensitive) over the built-in InitSolarSystemObjects list: a matching name
+// replaces the built-in entry, a new name is appended. This lets operators add
+// a spacecraft or fix an orbital element without a rebuild. The file is a JSON
+// array of CelestialObject values using the struct's field names, e.g.
+//
+//	[{"Name": "Psyche", "Type": "spacecraft", "ParentName": "Sun", "Radius": 0.01, "A": 2.9}]
+//
+// Only JSON is accepted: YAML would need a third-party parser, and this proxy
+// otherwise keeps its dependency list short. SIGHUP or an admin
+// POST /_debug/reload-objects re-reads the file; a file that fails to parse or
+// validate is rejected and the previous objects stay live.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// knownObjectTypes lists the Type values the calculations understand.
+var knownObjectTypes = map[string]bool{
+	"star": true, "planet": true, "dwarf_planet": true, "moon": true,
+	"spacecraft": true, "asteroid": true, "comet": true,
+}
+
+// loadObjectsFile reads path and merges its objects over base, returning the
+// merged, normalized and validated list. base is not modified.
+func loadObjectsFile(path string, base []celestial.CelestialObject) ([]celestial.CelestialObject, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return nil, fmt.Errorf("%s: YAML object files are not supported; use a JSON array", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defs []celestial.CelestialObject
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields() // catch misspelled element names instead of silently zeroing them
+	if err := dec.Decode(&defs); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	merged := make([]celestial.CelestialObject, len(base))
+	copy(merged, base)
+	for _, def := range defs {
+		if def.Type != "star" && def.Type != "spacecraft" {
+			def.L = celestial.NormalizeDegrees(def.L)
+		}
+		replaced := false
+		for i := range merged {
+			if strings.EqualFold(merged[i].Name, def.Name) {
+				merged[i] = def
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, def)
+		}
+	}
+
+	// File entries may leave out rates just like the built-ins did, and
+	// comets are given by their cometary elements.
+	celestial.DeriveCometElements(merged)
+	notes := celestial.DeriveMissingRates(merged)
+	if err := validateObjects(merged); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, note := range notes {
+		log.Printf("Warning: %s: %s", path, note)
+	}
+	return merged, nil
+}
+
+// objectUnitThreshold separates the units of A: a moon's is in km and a
+// heliocentric one in AU, so a value on the wrong side of it is almost
+// certainly in the other unit. Moons of bodies smaller than this (an
+// asteroid's) orbit within a few km, so for them only an orbit inside the
+// parent is taken for AU.
+const objectUnitThreshold = 1000
+
+// validateObjects checks the invariants the position and occlusion code rely
+// on: unique names and subdomains, known types, a radius and mass, a bound
+// orbit with a semi-major axis in the right unit around a parent that exists
+// (moons must orbit a planet, dwarf planet or asteroid), and a mean motion for every
+// planet. Comets instead need a perihelion distance and time, and may be on
+// open orbits, but must orbit the Sun. Names of group subdomains (celestial.BodyGroups) are refused too.
+// Every violation is reported, one per line, naming the object and field.
+func validateObjects(objects []celestial.CelestialObject) error {
+	var errs []error
+	fail := func(obj celestial.CelestialObject, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]interface{}{obj.Name}, args...)...))
+	}
+
+	byName := make(map[string]celestial.CelestialObject, len(objects))
+	bySlug := make(map[string]string, len(objects))
+	for _, obj := range objects {
+		if strings.TrimSpace(obj.Name) == "" {
+			errs = append(errs, fmt.Errorf("object with empty Name (Type %q)", obj.Type))
+			continue
+		}
+		slug := FormatDomainName(obj.Name)
+		if celestial.IsReservedName(slug) {
+			fail(obj, "name is reserved for the %s.latency.space group page", slug)
+		}
+		key := strings.ToLower(obj.Name)
+		if _, dup := byName[key]; dup {
+			fail(obj, "duplicate name")
+			continue
+		}
+		if other, dup := bySlug[slug]; dup {
+			fail(obj, "subdomain %s.latency.space already belongs to %s", slug, other)
+		}
+		byName[key] = obj
+		bySlug[slug] = obj.Name
+	}
+
+	for _, obj := range objects {
+		if strings.TrimSpace(obj.Name) == "" {
+			continue
+		}
+		if !knownObjectTypes[obj.Type] {
+			fail(obj, "unknown Type %q", obj.Type)
+		}
+		if obj.Radius <= 0 {
+			fail(obj, "Radius must be positive (km), got %v", obj.Radius)
+		}
+		if obj.DownlinkBps < 0 || obj.UplinkBps < 0 {
+			fail(obj, "DownlinkBps and UplinkBps must not be negative")
+		}
+		if obj.TxPowerWatts < 0 {
+			fail(obj, "TxPowerWatts must not be negative")
+		}
+		if obj.Type == "star" {
+			continue
+		}
+		if obj.Mass <= 0 {
+			fail(obj, "Mass must be positive (kg), got %v", obj.Mass)
+		}
+		if obj.Type == "planet" && obj.DL == 0 {
+			fail(obj, "DL (mean motion, degrees/century) must be nonzero for a planet")
+		}
+		switch {
+		case obj.Type == "comet":
+			if obj.Q <= 0 {
+				fail(obj, "perihelion distance Q must be positive (AU), got %v", obj.Q)
+			}
+			if obj.E < 0 {
+				fail(obj, "eccentricity E must not be negative, got %v", obj.E)
+			}
+			if obj.TP == 0 {
+				fail(obj, "TP (time of perihelion, Julian date) is required for a comet")
+			}
+		case obj.A <= 0:
+			fail(obj, "semi-major axis A must be positive")
+		case obj.E < 0:
+			fail(obj, "eccentricity E must be in [0, 1), got %v", obj.E)
+		case obj.E >= 1 && obj.Type == "spacecraft":
+			fail(obj, "E %v is a hyperbolic (escape) trajectory, which the orbit model cannot propagate; "+
+				"model it as a radial recession with E 0 and a DA, as the Voyagers are", obj.E)
+		case obj.E >= 1:
+			fail(obj, "eccentricity E must be in [0, 1) for a bound orbit, got %v", obj.E)
+		}
+
+		if obj.ParentName == "" {
+			fail(obj, "ParentName is required for a %s", obj.Type)
+			continue
+		}
+		parent, ok := byName[strings.ToLower(obj.ParentName)]
+		if !ok {
+			fail(obj, "parent %q does not exist", obj.ParentName)
+			continue
+		}
+		if obj.Type == "moon" {
+			if parent.Type != "planet" && parent.Type != "dwarf_planet" && parent.Type != "asteroid" {
+				fail(obj, "moons must orbit a planet, dwarf_planet or asteroid, not %s (%s)", parent.Name, parent.Type)
+			} else if obj.A > 0 && obj.A <= min(objectUnitThreshold, parent.Radius) {
+				fail(obj, "A %v looks like AU; a moon's semi-major axis is in km", obj.A)
+			}
+		}
+		if obj.Type == "comet" && parent.Type != "star" {
+			fail(obj, "comets must orbit the Sun, not %s (%s)", parent.Name, parent.Type)
+		}
+		if parent.Type == "star" && obj.A >= objectUnitThreshold {
+			fail(obj, "A %v looks like km; a heliocentric semi-major axis is in AU", obj.A)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// invalidateDistanceCache drops the Earth-distance cache so the next lookup
+// recomputes it against the current objects.
+func invalidateDistanceCache() {
+	DistanceCacheMutex.Lock()
+	defer DistanceCacheMutex.Unlock()
+	distanceEntries = nil
+	distanceUpdated = clockReading{}
+	distanceEpoch = time.Time{}
+	distanceFromDisk = false
+	distanceInvalidations.Add(1)
+}
+
+// reloadObjects re-reads the objects file and atomically swaps it in. On any
+// error the current objects are left untouched. In-flight connections keep
+// the snapshot they already hold.
+func (s *Server) reloadObjects() (int, error) {
+	if s.objectsFile == "" {
+		return 0, fmt.Errorf("no -objects-file configured")
+	}
+	objects, err := loadObjectsFile(s.objectsFile, celestial.InitSolarSystemObjects())
+	if err != nil {
+		return 0, err
+	}
+	if err := checkObjects(objects); err != nil {
+		return 0, err
+	}
+	setCelestialObjects(objects)
+	invalidateDistanceCache()
+	log.Printf("Reloaded %d celestial objects from %s", len(objects), s.objectsFile)
+	return len(objects), nil
+}
+
+// handleReloadObjects serves the admin POST /_debug/reload-objects.
+func (s *Server) handleReloadObjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+		return
+	}
+	count, err := s.reloadObjects()
+	if err != nil {
+		log.Printf("Object reload rejected, keeping current objects: %v", err)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"reloaded": count, "file": s.objectsFile})
+}
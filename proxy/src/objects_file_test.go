@@ -0,0 +1,225 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func writeObjectsFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuiltinObjectsValidate(t *testing.T) {
+	if err := validateObjects(celestial.InitSolarSystemObjects()); err != nil {
+		t.Fatalf("built-in objects must pass validation: %v", err)
+	}
+}
+
+func TestLoadObjectsFileMergeByName(t *testing.T) {
+	base := celestial.InitSolarSystemObjects()
+	path := writeObjectsFile(t, "objects.json", `[
+		{"Name": "mars", "Type": "planet", "ParentName": "Sun", "Radius": 3396.2, "Mass": 6.417e23, "A": 1.6, "E": 0.09, "L": 400},
+		{"Name": "Psyche", "Type": "spacecraft", "ParentName": "Sun", "Radius": 0.01, "Mass": 2608, "A": 2.9}
+	]`)
+
+	merged, err := loadObjectsFile(path, base)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(merged) != len(base)+1 {
+		t.Errorf("expected one appended object (%d), got %d", len(base)+1, len(merged))
+	}
+	mars, ok := findObjectByName(merged, "Mars")
+	if !ok || mars.A != 1.6 {
+		t.Errorf("Mars should be replaced by the file entry, got %+v", mars)
+	}
+	if mars.L != 40 {
+		t.Errorf("mean longitude should be normalized to [0,360), got %v", mars.L)
+	}
+	if _, ok := findObjectByName(merged, "psyche"); !ok {
+		t.Error("new spacecraft should be appended")
+	}
+	if orig, _ := findObjectByName(base, "Mars"); orig.A == 1.6 {
+		t.Error("base list must not be modified")
+	}
+}
+
+// TestValidateObjectsRules breaks a small valid system one way at a time
+// and checks the matching rule fires, naming the object.
+func TestValidateObjectsRules(t *testing.T) {
+	system := func() []celestial.CelestialObject {
+		return []celestial.CelestialObject{
+			{Name: "Sun", Type: "star", Radius: 695700, Mass: 1.989e30},
+			{Name: "Earth", Type: "planet", ParentName: "Sun", Radius: 6371, Mass: 5.972e24, A: 1, E: 0.0167, DL: 35999.37},
+			{Name: "Moon", Type: "moon", ParentName: "Earth", Radius: 1737.4, Mass: 7.342e22, A: 384400, E: 0.0549},
+			{Name: "Probe", Type: "spacecraft", ParentName: "Sun", Radius: 0.01, Mass: 500, A: 40},
+		}
+	}
+	if err := validateObjects(system()); err != nil {
+		t.Fatalf("valid system rejected: %v", err)
+	}
+
+	type objects = []celestial.CelestialObject
+	cases := []struct {
+		name   string
+		mutate func(o objects) objects
+		want   string
+	}{
+		{"orphan", func(o objects) objects { o[2].ParentName = "Terra"; return o }, `Moon: parent "Terra" does not exist`},
+		{"no radius", func(o objects) objects { o[1].Radius = 0; return o }, "Earth: Radius must be positive"},
+		{"no mass", func(o objects) objects { o[3].Mass = 0; return o }, "Probe: Mass must be positive"},
+		{"unbound", func(o objects) objects { o[2].E = 1; return o }, "Moon: eccentricity E must be in [0, 1) for a bound orbit"},
+		{"hyperbolic", func(o objects) objects { o[3].E = 3.7; return o }, "Probe: E 3.7 is a hyperbolic"},
+		{"moon in AU", func(o objects) objects { o[2].A = 0.00257; return o }, "Moon: A 0.00257 looks like AU"},
+		{"planet in km", func(o objects) objects { o[1].A = 149597870.7; return o }, "Earth: A 1.495978707e+08 looks like km"},
+		{"no mean motion", func(o objects) objects { o[1].DL = 0; return o }, "Earth: DL (mean motion"},
+		{"duplicate name", func(o objects) objects {
+			dup := o[2]
+			dup.Name = "moon"
+			return append(o, dup)
+		}, "moon: duplicate name"},
+		{"duplicate subdomain", func(o objects) objects {
+			dup := o[3]
+			o[3].Name, dup.Name = "Deep Probe", "Deep-Probe"
+			return append(o, dup)
+		}, "Deep-Probe: subdomain deep-probe.latency.space already belongs to Deep Probe"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateObjects(tc.mutate(system()))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+
+	// Every violation is reported, not just the first.
+	objs := system()
+	objs[1].Mass, objs[2].A, objs[3].E = 0, 0.5, 2
+	err := validateObjects(objs)
+	if err == nil || len(strings.Split(err.Error(), "\n")) != 3 {
+		t.Errorf("expected three violations, got %v", err)
+	}
+}
+
+func TestLoadObjectsFileValidation(t *testing.T) {
+	base := celestial.InitSolarSystemObjects()
+	cases := []struct {
+		name, file, content, want string
+	}{
+		{"missing parent", "a.json", `[{"Name":"Lost","Type":"moon","ParentName":"Vulcan","Radius":1,"A":1000}]`, "does not exist"},
+		{"moon of a spacecraft", "b.json", `[{"Name":"Pebble","Type":"moon","ParentName":"JWST","Radius":1,"A":1000}]`, "dwarf_planet or asteroid"},
+		{"unknown type", "c.json", `[{"Name":"Blob","Type":"nebula","ParentName":"Sun","Radius":1,"A":1}]`, "unknown Type"},
+		{"no radius", "d.json", `[{"Name":"Dot","Type":"asteroid","ParentName":"Sun","A":1}]`, "Radius"},
+		{"missing name", "e.json", `[{"Type":"asteroid","ParentName":"Sun","Radius":1,"A":1}]`, "empty Name"},
+		{"unbound orbit", "f.json", `[{"Name":"Comet","Type":"asteroid","ParentName":"Sun","Radius":1,"A":1,"E":1.2}]`, "eccentricity"},
+		{"misspelled field", "g.json", `[{"Name":"Typo","Type":"asteroid","ParentName":"Sun","Radius":1,"SemiMajor":1}]`, "unknown field"},
+		{"negative rate", "h.json", `[{"Name":"Slow","Type":"spacecraft","ParentName":"Sun","Radius":1,"A":1,"DownlinkBps":-1}]`, "DownlinkBps"},
+		{"comet without perihelion", "l.json", `[{"Name":"Wild","Type":"comet","ParentName":"Sun","Radius":1,"Mass":1,"E":0.5,"TP":2460000}]`, "perihelion distance Q"},
+		{"comet of a planet", "m.json", `[{"Name":"Shoemaker","Type":"comet","ParentName":"Jupiter","Radius":1,"Mass":1,"Q":1,"E":0.5,"TP":2460000}]`, "must orbit the Sun"},
+		{"negative power", "k.json", `[{"Name":"Faint","Type":"spacecraft","ParentName":"Sun","Radius":1,"A":1,"TxPowerWatts":-5}]`, "TxPowerWatts"},
+		{"malformed", "i.json", `[{"Name":`, "unexpected EOF"},
+		{"yaml", "j.yaml", "- Name: Psyche\n", "YAML"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadObjectsFile(writeObjectsFile(t, tc.file, tc.content), base)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestReloadObjectsEndpoint(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	path := writeObjectsFile(t, "objects.json", `[{"Name":"Psyche","Type":"spacecraft","ParentName":"Sun","Radius":0.01,"Mass":2608,"A":2.9}]`)
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), adminToken: "t0k", objectsFile: path}
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://latency.space/_debug/reload-objects", nil)
+		req.Header.Set("Authorization", "Bearer t0k")
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, req)
+		return rec
+	}
+
+	mustDistance(t, "Mars") // warm the cache so the reload has something to invalidate
+	if rec := post(); rec.Code != http.StatusOK {
+		t.Fatalf("reload: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, ok := findObjectByName(getCelestialObjects(), "Psyche"); !ok {
+		t.Fatal("reloaded objects should include Psyche")
+	}
+	if d := mustDistance(t, "Psyche"); d <= 0 {
+		t.Errorf("distance cache should be rebuilt with the new object, got %v", d)
+	}
+
+	// A broken file is rejected and the previous objects stay live.
+	if err := os.WriteFile(path, []byte(`[{"Name":"Broken"`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if rec := post(); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("malformed file: expected 422, got %d", rec.Code)
+	}
+	if _, ok := findObjectByName(getCelestialObjects(), "Psyche"); !ok {
+		t.Error("old objects must be retained after a rejected reload")
+	}
+}
+
+// TestReloadObjectsConcurrentReads swaps the object list while readers
+// iterate it; run with -race to check the swap is atomic.
+func TestReloadObjectsConcurrentReads(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+
+	path := writeObjectsFile(t, "objects.json", `[{"Name":"Psyche","Type":"spacecraft","ParentName":"Sun","Radius":0.01,"Mass":2608,"A":2.9}]`)
+	s := &Server{objectsFile: path}
+	base := len(celestial.InitSolarSystemObjects())
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Each reader sees either the old or the new list, never a mix.
+				if n := len(getCelestialObjects()); n != base && n != base+1 {
+					t.Errorf("reader saw a torn object list of length %d", n)
+					return
+				}
+				_, _ = findObjectByName(getCelestialObjects(), "Mars")
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		setCelestialObjects(celestial.InitSolarSystemObjects())
+		if _, err := s.reloadObjects(); err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+}
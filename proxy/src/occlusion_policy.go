@@ -0,0 +1,198 @@
+// occlusion_policy.go - what each protocol does when its body is occluded.
+//
+// A body behind the Sun, a planet or a moon can't be reached, and each
+// protocol says so in the way its clients can hear:
+//
+//   - HTTP explains. /api/demo answers 503 with code OCCLUDED, the occluder
+//     and when the line of sight clears, and Retry-After. The DTN API is the
+//     exception: store-and-forward is meant to ride out geometry, so it
+//     only refuses outside a DSN pass (dtn_http.go).
+//   - TCP fails loudly. A SOCKS CONNECT gets HOST_UNREACHABLE, and with the
+//     metadata extension its frame names the occluder (error_code OCCLUDED);
+//     a -tcp-forward connection is closed.
+//   - UDP drops, as a real link would. But the first dropped packet of an
+//     outage sends the client one notice datagram (udpDropNotice), at most
+//     one a minute per association, so a client can tell the drop from
+//     network loss.
+//
+// Every refusal counts proxy_occlusion_rejections_total{body,protocol}. A
+// link down for a closed contact window or no ground station isn't counted
+// there; the UDP notice still names it.
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// occludedCode is the structured error code for a request refused because
+// the body is occluded.
+const occludedCode = "OCCLUDED"
+
+// udpDropNoticeMagic starts every UDP drop notice; a JSON udpDropNotice
+// follows it.
+const udpDropNoticeMagic = "LATENCY-SPACE-DROP/1 "
+
+// OcclusionPolicy is the per-protocol behaviour above.
+type OcclusionPolicy struct {
+	// UDPNoticeEvery is the least time between drop notices to one UDP
+	// association.
+	UDPNoticeEvery time.Duration
+}
+
+// occlusionPolicy is the policy every path consults.
+var occlusionPolicy = OcclusionPolicy{UDPNoticeEvery: time.Minute}
+
+// refuseHTTP answers a request for obj while outage has its link down with
+// 503: OCCLUDED and the occluder when it is occluded, otherwise
+// NO_CONTACT_WINDOW or the outage in words. Retry-After is when it clears.
+func (p OcclusionPolicy) refuseHTTP(w http.ResponseWriter, metrics Metrics, obj celestial.CelestialObject, outage LinkOutage, now time.Time) {
+	out := map[string]interface{}{"error": obj.Name + ": " + outage.String()}
+	var clears time.Time
+	switch {
+	case outage.Occluder != "":
+		metrics.RecordOcclusionRejection(obj.Name, protoHTTP)
+		out["code"] = occludedCode
+		out["occluder"] = outage.Occluder
+		var ok bool
+		if clears, ok = occlusionClears(obj, now); ok {
+			out["clears"] = clears
+		}
+	case !outage.NextContact.IsZero():
+		out["code"] = noContactWindowCode
+		out["nextContact"] = outage.NextContact
+		clears = outage.NextContact
+	}
+	// Without a known end (an occlusion shorter than the forecast sees,
+	// or no ground station), try again after a forecast step.
+	retry := forecastStep.Seconds()
+	if clears.After(now) {
+		retry = math.Ceil(clears.Sub(now).Seconds())
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(retry)))
+	writeJSON(w, http.StatusServiceUnavailable, out)
+}
+
+// refuseSOCKS records a CONNECT refused for outage; the caller replies
+// HOST_UNREACHABLE.
+func (p OcclusionPolicy) refuseSOCKS(h *SOCKSHandler, body string, outage LinkOutage) {
+	if outage.Occluder != "" {
+		h.metrics.RecordOcclusionRejection(body, protoSOCKSTCP)
+	}
+	h.describeOutage(outage)
+}
+
+// refuseTCPForward records a -tcp-forward connection refused for outage;
+// the caller closes it.
+func (p OcclusionPolicy) refuseTCPForward(metrics Metrics, body string, outage LinkOutage) {
+	if outage.Occluder != "" {
+		metrics.RecordOcclusionRejection(body, protoTCPForward)
+	}
+}
+
+// udpNotifier returns the drop-notice state for one UDP association.
+func (p OcclusionPolicy) udpNotifier() *udpDropNotifier {
+	return &udpDropNotifier{every: p.UDPNoticeEvery}
+}
+
+// occlusionClears returns when obj's line of sight from Earth, blocked at
+// now, clears: the end of the blocked window open at now in its forecast.
+// The window is found from hourly samples, so one that opened since the
+// last sample isn't known.
+func occlusionClears(obj celestial.CelestialObject, now time.Time) (time.Time, bool) {
+	for _, e := range cachedForecast(obj, now, 1).Events {
+		if e.Kind == "blocked" && !e.Start.After(now) && e.End.After(now) {
+			return e.End, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// udpDropNotice is the JSON after udpDropNoticeMagic.
+type udpDropNotice struct {
+	Reason   string     `json:"reason"` // outcomeOccluded, outcomeNoContact or outcomeNoStation
+	Body     string     `json:"body"`
+	Target   string     `json:"target"`             // where the dropped packet was going
+	Occluder string     `json:"occluder,omitempty"` // with reason occluded
+	Clears   *time.Time `json:"clears,omitempty"`   // when the link is expected back, if known
+	// NoticeEverySeconds is how often, at most, a notice is sent; packets
+	// dropped in between get none.
+	NoticeEverySeconds float64 `json:"notice_every_seconds"`
+}
+
+// udpDropNotifier decides when a UDP association is owed a drop notice:
+// on the first drop of an outage, and no sooner than every after the last.
+type udpDropNotifier struct {
+	every time.Duration
+
+	mu       sync.Mutex
+	inOutage bool      // a drop since the link was last up
+	last     time.Time // the last notice sent; zero if none
+}
+
+// drop reports whether a packet dropped at now is owed a notice: the first
+// of an outage, with none sent for the last every.
+func (n *udpDropNotifier) drop(now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.inOutage {
+		return false
+	}
+	n.inOutage = true
+	if !n.last.IsZero() && now.Sub(n.last) < n.every {
+		return false
+	}
+	n.last = now
+	return true
+}
+
+// up records a packet that got through, ending the outage.
+func (n *udpDropNotifier) up() {
+	n.mu.Lock()
+	n.inOutage = false
+	n.mu.Unlock()
+}
+
+// udpDropNoticePacket builds the SOCKS UDP datagram telling a client its
+// packet to target was dropped for outage. It comes from 0.0.0.0:0, like an
+// ICMP error from the relay rather than a reply from the target.
+func (p OcclusionPolicy) udpDropNoticePacket(obj celestial.CelestialObject, target string, outage LinkOutage, now time.Time) []byte {
+	notice := udpDropNotice{
+		Reason:             outage.outcome(),
+		Body:               obj.Name,
+		Target:             target,
+		Occluder:           outage.Occluder,
+		NoticeEverySeconds: p.UDPNoticeEvery.Seconds(),
+	}
+	switch {
+	case outage.Occluder != "":
+		if clears, ok := occlusionClears(obj, now); ok {
+			notice.Clears = &clears
+		}
+	case !outage.NextContact.IsZero():
+		notice.Clears = &outage.NextContact
+	}
+	blob, _ := json.Marshal(notice) // can't fail for this struct
+	packet := []byte{0x00, 0x00, 0x00, SOCKS5_ADDR_IPV4}
+	packet = append(packet, net.IPv4zero.To4()...)
+	packet = append(packet, 0x00, 0x00)
+	packet = append(packet, udpDropNoticeMagic...)
+	return append(packet, blob...)
+}
+
+// linkDownError is a packet refused by the relay's link check.
+type linkDownError struct {
+	body   string
+	outage LinkOutage
+}
+
+func (e *linkDownError) Error() string {
+	return "path to " + e.body + " " + e.outage.String()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// TestOcclusionHTTP checks the demo explains an occlusion: 503 OCCLUDED
+// naming the occluder, and Retry-After when the line of sight clears.
+func TestOcclusionHTTP(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	at := time.Date(2026, 10, 20, 11, 0, 0, 0, time.UTC)
+	fakeLinkClock(t, at)
+	objects := getCelestialObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+	europa, _ := findObjectByName(objects, "Europa")
+	if v, by := IsOccluded(earth, europa, objects, at); !v.Blocked() || by.Name != "Jupiter" {
+		t.Fatalf("Europa at %v: %v by %q, want blocked by Jupiter", at, v, by.Name)
+	}
+
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), timing: fixedLatency(time.Millisecond)}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/demo/drip?body=europa", nil))
+	var out struct {
+		Error, Code, Occluder string
+		Clears                time.Time
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil || rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	loadOpenAPI(t).checkResponse(t, http.MethodGet, "/api/demo/drip", rec)
+	if out.Code != occludedCode || out.Occluder != "Jupiter" || !out.Clears.After(at) {
+		t.Errorf("%+v, want OCCLUDED by Jupiter clearing after %v", out, at)
+	}
+	retry, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if want := int(out.Clears.Sub(at).Seconds()); err != nil || retry < want || retry > want+1 {
+		t.Errorf("Retry-After %q, want %d", rec.Header().Get("Retry-After"), want)
+	}
+	if n := recorded(t, s.metrics).Count(metricOcclusionRejections, "Europa", protoHTTP); n != 1 {
+		t.Errorf("%v HTTP occlusion rejections counted, want 1", n)
+	}
+}
+
+// TestOcclusionSOCKS checks a refused CONNECT names the occluder in the
+// metadata frame and is counted.
+func TestOcclusionSOCKS(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	orig := checkLink
+	checkLink = func(earth, target CelestialObject, objects []CelestialObject, at time.Time) (LinkOutage, bool) {
+		return LinkOutage{Occluder: "Sun"}, true
+	}
+	t.Cleanup(func() { checkLink = orig })
+
+	metrics := NewRecordingMetrics()
+	proxy := startTestSOCKS(t, &Server{security: newTestSecurity(), metrics: metrics, limiter: NewRateLimiter(60, 5, 3, 0),
+		fixedCelestialBody: "Mars", timing: fixedLatency(5 * time.Millisecond)})
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, meta, err := client.Dial(ctx, proxy, "127.0.0.1:9")
+	var re *client.ReplyError
+	if !errors.As(err, &re) || re.Code != SOCKS5_REP_HOST_UNREACHABLE {
+		t.Fatalf("dial: %v, want a host-unreachable reply", err)
+	}
+	if meta == nil || !meta.Occluded || meta.Occluder != "Sun" || meta.ErrorCode != occludedCode {
+		t.Errorf("metadata = %+v, want occluded by the Sun", meta)
+	}
+	if n := recorded(t, metrics).Count(metricOcclusionRejections, "Mars", protoSOCKSTCP); n != 1 {
+		t.Errorf("%v SOCKS occlusion rejections counted, want 1", n)
+	}
+}
+
+// TestOcclusionUDP checks packets dropped for occlusion earn the client one
+// notice from 0.0.0.0:0, each drop is counted, and the echo resumes once
+// the line of sight is back.
+func TestOcclusionUDP(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	var occluded atomic.Bool
+	occluded.Store(true)
+	orig := checkLink
+	checkLink = func(earth, target CelestialObject, objects []CelestialObject, at time.Time) (LinkOutage, bool) {
+		if occluded.Load() {
+			return LinkOutage{Occluder: "Sun"}, true
+		}
+		return LinkOutage{}, false
+	}
+	t.Cleanup(func() { checkLink = orig })
+
+	security, metrics := newTestSecurity(), NewRecordingMetrics()
+	echo := startUDPEcho(t, security)
+	code, relayAddr := udpAssociateWith(t, security, metrics, func(*SOCKSHandler) {})
+	if code != SOCKS5_REP_SUCCESS {
+		t.Fatalf("UDP ASSOCIATE failed: 0x%02x", code)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		conn.WriteTo(buildUDPSocksPacket(echo, []byte("ping")), relayAddr)
+	}
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no drop notice: %v", err)
+	}
+	header := []byte{0, 0, 0, SOCKS5_ADDR_IPV4, 0, 0, 0, 0, 0, 0}
+	if !bytes.HasPrefix(buf[:n], append(header, udpDropNoticeMagic...)) {
+		t.Fatalf("notice %q, want the magic from 0.0.0.0:0", buf[:n])
+	}
+	var notice udpDropNotice
+	if err := json.Unmarshal(buf[len(header)+len(udpDropNoticeMagic):n], &notice); err != nil {
+		t.Fatal(err)
+	}
+	if notice.Reason != outcomeOccluded || notice.Body != "Mars" || notice.Occluder != "Sun" || notice.Target != echo.String() {
+		t.Errorf("notice %+v", notice)
+	}
+	if more := countEchoes(conn, 200*time.Millisecond); more != 0 {
+		t.Errorf("%d more datagrams in the same outage, want none", more)
+	}
+	if n := recorded(t, metrics).Count(metricOcclusionRejections, "Mars", protoSOCKSUDP); n != 3 {
+		t.Errorf("%v UDP occlusion rejections counted, want 3", n)
+	}
+
+	occluded.Store(false)
+	conn.WriteTo(buildUDPSocksPacket(echo, []byte("ping")), relayAddr)
+	if got := countEchoes(conn, 500*time.Millisecond); got != 1 {
+		t.Errorf("%d datagrams once the link is back, want the echo", got)
+	}
+}
+
+// TestUDPDropNotifier checks a notice per outage, and no more than one per
+// interval however often the link flaps.
+func TestUDPDropNotifier(t *testing.T) {
+	n := OcclusionPolicy{UDPNoticeEvery: time.Minute}.udpNotifier()
+	t0 := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for _, step := range []struct {
+		after time.Duration
+		up    bool // a packet got through before this drop
+		want  bool
+	}{
+		{0, false, true},                  // first drop of an outage
+		{time.Second, false, false},       // same outage
+		{30 * time.Second, true, false},   // a new outage, but too soon
+		{40 * time.Second, false, false},  // still that outage
+		{61 * time.Second, true, true},    // a new outage a minute on
+		{200 * time.Second, false, false}, // long, but the same outage
+	} {
+		if step.up {
+			n.up()
+		}
+		if got := n.drop(t0.Add(step.after)); got != step.want {
+			t.Errorf("drop at +%v (up %v) = %v, want %v", step.after, step.up, got, step.want)
+		}
+	}
+}
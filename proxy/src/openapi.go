@@ -0,0 +1,23 @@
+// openapi.go - the OpenAPI 3 description of the JSON API.
+//
+// openapi.json is hand-written and embedded, and served at /api/openapi.json.
+// It is the contract third-party dashboards build against: openapi_test.go
+// validates real handler responses against it, so renaming or retyping a
+// field fails the tests until the document is updated. Superseded fields are
+// kept and marked "deprecated": true rather than removed.
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves the embedded OpenAPI document.
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPISpec)
+}
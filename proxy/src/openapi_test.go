@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// openAPIValidator checks JSON values against the schemas in openapi.json.
+// It covers the subset of OpenAPI 3.0 the document uses: $ref, type,
+// properties, required, additionalProperties, items, enum and the date-time
+// format.
+type openAPIValidator struct {
+	doc map[string]interface{}
+}
+
+func loadOpenAPI(t *testing.T) *openAPIValidator {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	return &openAPIValidator{doc: doc}
+}
+
+// resolve follows a local "#/a/b" reference.
+func (v *openAPIValidator) resolve(ref string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	var node interface{} = v.doc
+	for _, part := range strings.Split(ref[2:], "/") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("$ref %q: %q is not an object", ref, part)
+		}
+		if node, ok = m[part]; !ok {
+			return nil, fmt.Errorf("$ref %q: no %q", ref, part)
+		}
+	}
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("$ref %q is not an object", ref)
+	}
+	return m, nil
+}
+
+func (v *openAPIValidator) deref(node map[string]interface{}) (map[string]interface{}, error) {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node, nil
+		}
+		var err error
+		if node, err = v.resolve(ref); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// responseSchema returns the JSON schema for method+path at status.
+func (v *openAPIValidator) responseSchema(method, path string, status int) (map[string]interface{}, error) {
+	lookup := func(node interface{}, keys ...string) (map[string]interface{}, error) {
+		for _, k := range keys {
+			m, ok := node.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s %s %d: %q is not an object", method, path, status, k)
+			}
+			m, err := v.deref(m)
+			if err != nil {
+				return nil, err
+			}
+			if node, ok = m[k]; !ok {
+				return nil, fmt.Errorf("%s %s: response %d not documented (missing %q)", method, path, status, k)
+			}
+		}
+		m, _ := node.(map[string]interface{})
+		return v.deref(m)
+	}
+	return lookup(v.doc, "paths", path, strings.ToLower(method), "responses", strconv.Itoa(status),
+		"content", "application/json", "schema")
+}
+
+// validate returns every mismatch between val and schema, by JSON path.
+func (v *openAPIValidator) validate(schema map[string]interface{}, val interface{}, at string) []string {
+	schema, err := v.deref(schema)
+	if err != nil {
+		return []string{at + ": " + err.Error()}
+	}
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if e == val {
+				found = true
+			}
+		}
+		if !found {
+			fail("%v not in enum %v", val, enum)
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			fail("want object, got %T", val)
+			break
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		if req, ok := schema["required"].([]interface{}); ok {
+			for _, r := range req {
+				if _, ok := obj[r.(string)]; !ok {
+					fail("missing required %q", r)
+				}
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, ok := props[k].(map[string]interface{}); ok {
+				errs = append(errs, v.validate(p, obj[k], at+"."+k)...)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					fail("undocumented field %q", k)
+				}
+			case map[string]interface{}:
+				errs = append(errs, v.validate(extra, obj[k], at+"."+k)...)
+			}
+		}
+	case "array":
+		arr, ok := val.([]interface{})
+		if !ok {
+			fail("want array, got %T", val)
+			break
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range arr {
+			errs = append(errs, v.validate(items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		s, ok := val.(string)
+		if !ok {
+			fail("want string, got %T", val)
+			break
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				fail("bad date-time %q", s)
+			}
+		}
+	case "number":
+		if _, ok := val.(json.Number); !ok {
+			fail("want number, got %T", val)
+		}
+	case "integer":
+		n, ok := val.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			fail("want integer, got %v", val)
+		}
+	case "boolean":
+		if _, ok := val.(bool); !ok {
+			fail("want boolean, got %T", val)
+		}
+	}
+	return errs
+}
+
+// checkResponse validates a recorded response against the documented schema.
+func (v *openAPIValidator) checkResponse(t *testing.T, method, path string, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	schema, err := v.responseSchema(method, path, rec.Code)
+	if err != nil {
+		t.Errorf("%v (body %s)", err, rec.Body.String())
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(rec.Body.Bytes()))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		t.Errorf("%s %s %d: response is not JSON: %v", method, path, rec.Code, err)
+		return
+	}
+	for _, e := range v.validate(schema, val, "$") {
+		t.Errorf("%s %s %d: %s", method, path, rec.Code, e)
+	}
+}
+
+// TestOpenAPIDocument checks the document is served and every $ref resolves.
+func TestOpenAPIDocument(t *testing.T) {
+	v := loadOpenAPI(t)
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/openapi.json", nil))
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), openAPISpec) {
+		t.Fatalf("GET /api/openapi.json: %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var walk func(node interface{})
+	walk = func(node interface{}) {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if ref, ok := n["$ref"].(string); ok {
+				if _, err := v.resolve(ref); err != nil {
+					t.Error(err)
+				}
+			}
+			for _, c := range n {
+				walk(c)
+			}
+		case []interface{}:
+			for _, c := range n {
+				walk(c)
+			}
+		}
+	}
+	walk(v.doc)
+}
+
+// TestAPIResponsesMatchSchema drives the real handlers and validates each
+// response, success and error, against openapi.json.
+func TestAPIResponsesMatchSchema(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	withContactSchedules(t, "voyager-1=00:00-00:01UTC")
+	fakeLinkClock(t, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+
+	v := loadOpenAPI(t)
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(10 * time.Millisecond)
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, req)
+		return rec
+	}
+
+	v.checkResponse(t, "GET", "/api/status-data", do("GET", "http://latency.space/api/status-data", ""))
+
+	for _, url := range []string{
+		"http://latency.space/api/distance?from=europa&to=enceladus",
+		"http://latency.space/api/distance?from=mars&to=earth&t=2030-01-01T00:00:00Z",
+		"http://latency.space/api/distance?from=mars",
+		"http://latency.space/api/distance?from=mars&to=vulcan",
+		"http://latency.space/api/distance?from=mars&to=earth&t=yesterday",
+	} {
+		v.checkResponse(t, "GET", "/api/distance", do("GET", url, ""))
+	}
+	for _, url := range []string{
+		"http://latency.space/api/orbit?body=mars&points=16",
+		"http://latency.space/api/orbit?body=phobos&points=16",
+		"http://latency.space/api/orbit?body=voyager-1&points=16",
+		"http://latency.space/api/orbit?body=halley&points=16",
+		"http://latency.space/api/orbit?body=mars&points=1",
+		"http://latency.space/api/orbit?body=vulcan",
+	} {
+		v.checkResponse(t, "GET", "/api/orbit", do("GET", url, ""))
+	}
+	for _, url := range []string{
+		"http://latency.space/api/state?body=mars",
+		"http://latency.space/api/state?body=phobos&t=2030-01-01T00:00:00Z",
+		"http://latency.space/api/state?body=moon",
+		"http://latency.space/api/state?body=voyager-1",
+		"http://latency.space/api/state?body=tsuchinshan-atlas",
+		"http://latency.space/api/state",
+		"http://latency.space/api/state?body=sun",
+		"http://latency.space/api/state?body=vulcan",
+		"http://latency.space/api/state?body=mars&t=yesterday",
+	} {
+		v.checkResponse(t, "GET", "/api/state", do("GET", url, ""))
+	}
+	for _, url := range []string{
+		"http://latency.space/api/matrix?types=planet&occlusion=true&t=2030-01-01T00:00:00Z",
+		"http://latency.space/api/matrix?types=comet",
+		"http://latency.space/api/matrix?occlusion=true",
+	} {
+		v.checkResponse(t, "GET", "/api/matrix", do("GET", url, ""))
+	}
+	for _, url := range []string{
+		"http://latency.space/api/scene.json?types=planet,moon&scale=10",
+		"http://latency.space/api/scene.json?scale=0",
+	} {
+		v.checkResponse(t, "GET", "/api/scene.json", do("GET", url, ""))
+	}
+	v.checkResponse(t, "GET", "/api/scene.gltf", do("GET", "http://latency.space/api/scene.gltf?t=yesterday", ""))
+	v.checkResponse(t, "GET", "/api/bodies", do("GET", "http://latency.space/api/bodies", ""))
+	for _, url := range []string{
+		"http://latency.space/api/ping?clientTimestamp=1760605200123",
+		"http://mars.latency.space/api/ping",
+		"http://latency.space/api/ping?body=vulcan",
+	} {
+		v.checkResponse(t, "GET", "/api/ping", do("GET", url, ""))
+	}
+	for _, url := range []string{
+		"http://mars.latency.space/api/time",
+		"http://latency.space/api/time?body=voyager-1",
+		"http://latency.space/api/time",
+		"http://latency.space/api/time?body=vulcan",
+	} {
+		v.checkResponse(t, "GET", "/api/time", do("GET", url, ""))
+	}
+	for _, url := range []string{
+		"http://mars.latency.space/api/metrics-summary",
+		"http://latency.space/api/metrics-summary",
+		"http://latency.space/api/metrics-summary?body=vulcan",
+	} {
+		v.checkResponse(t, "GET", "/api/metrics-summary", do("GET", url, ""))
+	}
+
+	for _, url := range []string{
+		"http://mars.latency.space/api/traceroute?target=example.com",
+		"http://latency.space/api/traceroute?body=voyager-1&target=https://example.com/",
+		"http://mars.latency.space/api/traceroute",
+		"http://mars.latency.space/api/traceroute?target=evil.invalid",
+		"http://latency.space/api/traceroute?body=vulcan&target=example.com",
+	} {
+		v.checkResponse(t, "GET", "/api/traceroute", do("GET", url, ""))
+	}
+	for _, url := range []string{
+		"http://mars.latency.space/api/whatif?scenario=webpage",
+		"http://latency.space/api/whatif?body=mars&scenario=ssh",
+		"http://latency.space/api/whatif?body=moon&scenario=video_call",
+		"http://latency.space/api/whatif?body=voyager-1&scenario=git_clone&size=10MB",
+		"http://latency.space/api/whatif?scenario=ssh",
+		"http://latency.space/api/whatif?body=vulcan&scenario=ssh",
+		"http://mars.latency.space/api/whatif?scenario=teleport",
+	} {
+		v.checkResponse(t, "GET", "/api/whatif", do("GET", url, ""))
+	}
+
+	for _, url := range []string{
+		"http://mars.latency.space/api/forecast?days=3",
+		"http://latency.space/api/forecast?body=europa&days=2&format=json",
+		"http://latency.space/api/forecast?body=mars&days=366",
+		"http://latency.space/api/forecast?body=mars&format=xml",
+		"http://latency.space/api/forecast",
+		"http://latency.space/api/forecast?body=vulcan",
+	} {
+		v.checkResponse(t, "GET", "/api/forecast", do("GET", url, ""))
+	}
+
+	cmd := do("POST", "http://voyager-1.latency.space/api/command", "")
+	v.checkResponse(t, "POST", "/api/command", cmd)
+	v.checkResponse(t, "POST", "/api/command", do("POST", "http://latency.space/api/command?body=mars", ""))
+	v.checkResponse(t, "POST", "/api/command", do("POST", "http://latency.space/api/command?body=vulcan", ""))
+	var command struct{ ID string }
+	json.Unmarshal(cmd.Body.Bytes(), &command)
+	v.checkResponse(t, "GET", "/api/command/{id}", do("GET", "http://latency.space/api/command/"+command.ID, ""))
+	v.checkResponse(t, "GET", "/api/command/{id}", do("GET", "http://latency.space/api/command/nope", ""))
+
+	for _, url := range []string{
+		"http://mars.latency.space/api/demo/drip?bytes=0",
+		"http://latency.space/api/demo/drip",
+		"http://latency.space/api/demo/drip?body=vulcan",
+	} {
+		v.checkResponse(t, "GET", "/api/demo/drip", do("GET", url, ""))
+	}
+	v.checkResponse(t, "POST", "/api/demo/echo", do("POST", "http://latency.space/api/demo/echo?body=vulcan", "x"))
+
+	// DTN: accepted, rejected, outside a contact window, then a failed fetch.
+	rec := do("POST", "http://mars.latency.space/dtn/send", fmt.Sprintf(`{"url":"http://%s/"}`, closedPort(t)))
+	v.checkResponse(t, "POST", "/dtn/send", rec)
+	v.checkResponse(t, "POST", "/dtn/send", do("POST", "http://mars.latency.space/dtn/send", "{"))
+	v.checkResponse(t, "POST", "/dtn/send", do("POST", "http://voyager-1.latency.space/dtn/send", `{"url":"http://example.com/"}`))
+	v.checkResponse(t, "GET", "/dtn/status/{id}", do("GET", "http://mars.latency.space/dtn/status/nope", ""))
+
+	var job struct{ ID string }
+	json.Unmarshal(rec.Body.Bytes(), &job)
+	v.checkResponse(t, "GET", "/dtn/status/{id}", do("GET", "http://mars.latency.space/dtn/status/"+job.ID, ""))
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		st := do("GET", "http://mars.latency.space/dtn/status/"+job.ID, "")
+		if st.Code == http.StatusBadGateway {
+			v.checkResponse(t, "GET", "/dtn/status/{id}", st)
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job never failed: %d %s", st.Code, st.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestStatusDataSubSecondLatency guards against integer-second truncation of
+// latency_seconds: the Moon is ~1.3 light-seconds away.
+func TestStatusDataSubSecondLatency(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/status-data", nil))
+	var out ApiResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range out.Objects["moons"] {
+		if e.Name != "Moon" {
+			continue
+		}
+		if e.Latency < 1.1 || e.Latency > 1.5 || e.Latency == float64(int(e.Latency)) {
+			t.Errorf("Moon latency_seconds = %v, want ~1.3 with a fractional part", e.Latency)
+		}
+		return
+	}
+	t.Fatal("Moon missing from status data")
+}
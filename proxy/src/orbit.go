@@ -0,0 +1,246 @@
+// orbit.go - low-resolution orbit paths for drawing in the status UI.
+//
+//	GET /api/orbit?body=mars[&points=360]
+//
+// A closed orbit is traced by sweeping the mean anomaly once round, one
+// Kepler solve per point, rather than by stepping wall-clock time; the first
+// and last samples are the same point. Moons (and spacecraft orbiting a
+// planet) are traced relative to their parent. Bodies with no closed orbit -
+// the receding deep-space probes, anything parked on a surface - get the arc
+// they trace from two years before now to two years after.
+//
+// Paths don't depend on the epoch at this resolution, so each (body, points)
+// is computed once and kept, up to orbitCacheSize of them; only
+// current_index, the sample nearest the body's position now, is worked out
+// per request. Requests share /api/distance's per-IP limiter.
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+const (
+	defaultOrbitPoints = 360
+	maxOrbitPoints     = 2048
+
+	// orbitArcSpan is how far either side of now an open arc reaches.
+	orbitArcSpan = 2 * 365.25 * 24 * time.Hour
+	// orbitArcTTL is how long a cached arc is reused before it is re-centred.
+	orbitArcTTL = 24 * time.Hour
+	// orbitCacheSize is how many paths are kept: every body at a few
+	// resolutions.
+	orbitCacheSize = 256
+)
+
+// OrbitResponse is the JSON returned by /api/orbit.
+type OrbitResponse struct {
+	Body         string       `json:"body"`
+	Kind         string       `json:"kind"`             // "orbit" (closed) or "arc"
+	Frame        string       `json:"frame"`            // "heliocentric" or "parent"
+	Parent       string       `json:"parent,omitempty"` // set when Frame is "parent"
+	PeriodDays   float64      `json:"period_days,omitempty"`
+	ArcStart     *time.Time   `json:"arc_start,omitempty"`
+	ArcEnd       *time.Time   `json:"arc_end,omitempty"`
+	Timestamp    time.Time    `json:"timestamp"`
+	Points       int          `json:"points"`
+	CurrentIndex int          `json:"current_index"`
+	Path         []positionAU `json:"path_au"`
+}
+
+// orbitPath is a computed path and what it was computed from.
+type orbitPath struct {
+	obj        celestial.CelestialObject // a changed objects file invalidates the path
+	closed     bool
+	periodDays float64
+	start, end time.Time // arcs only
+	computed   time.Time
+	path       []positionAU
+}
+
+type orbitKey struct {
+	body   string
+	points int
+}
+
+var (
+	orbitCacheMu sync.Mutex
+	orbitCache   = make(map[orbitKey]*orbitPath)
+)
+
+// hasClosedOrbit reports whether obj follows an ellipse that sweeping the
+// mean anomaly can trace: it moves, it is bound, and its orbit changes size
+// by under 1% a century (the planets' secular drift, not a probe receding).
+func hasClosedOrbit(obj celestial.CelestialObject) bool {
+	return obj.DL != 0 && obj.A > 0 && math.Abs(obj.DA) < 0.01*obj.A && obj.E >= 0 && obj.E < 1
+}
+
+// orbitPeriodDays is obj's orbital period: its Period where set, else one
+// turn of its mean longitude.
+func orbitPeriodDays(obj celestial.CelestialObject) float64 {
+	if obj.Period > 0 {
+		return obj.Period
+	}
+	return 360 * celestial.DAYS_PER_CENTURY / math.Abs(obj.DL)
+}
+
+// orbitFrameParent is the body obj's path is drawn relative to, or "" for
+// heliocentric paths.
+func orbitFrameParent(obj celestial.CelestialObject) string {
+	if obj.Type == "moon" || obj.Type == "spacecraft" {
+		if obj.ParentName != "Sun" {
+			return obj.ParentName
+		}
+	}
+	return ""
+}
+
+// toAU converts a parent-relative position to AU where obj's elements are
+// in km.
+func toAU(obj celestial.CelestialObject, p celestial.Vector3) positionAU {
+	if elementsInKm(obj) {
+		p = p.Scale(1 / celestial.AU)
+	}
+	return positionAU{X: p.X, Y: p.Y, Z: p.Z}
+}
+
+// computeOrbitPath traces obj with the given number of samples, centring an
+// arc on now.
+func computeOrbitPath(obj celestial.CelestialObject, objects []celestial.CelestialObject, points int, now time.Time) *orbitPath {
+	p := &orbitPath{obj: obj, computed: now, path: make([]positionAU, points)}
+	if hasClosedOrbit(obj) {
+		p.closed = true
+		p.periodDays = orbitPeriodDays(obj)
+		el := elementsAt(obj, 0)
+		for k := range p.path {
+			M := 2 * math.Pi * float64(k) / float64(points-1)
+			p.path[k] = toAU(obj, el.atMeanAnomaly(M).position())
+		}
+		return p
+	}
+
+	p.start, p.end = now.Add(-orbitArcSpan).UTC(), now.Add(orbitArcSpan).UTC()
+	step := p.end.Sub(p.start) / time.Duration(points-1)
+	for k := range p.path {
+		at := p.start.Add(time.Duration(k) * step)
+		if orbitFrameParent(obj) != "" {
+			p.path[k] = toAU(obj, parentRelativePosition(obj, centuriesSinceJ2000TDB(at)))
+		} else {
+			pos := GetObjectPosition(obj, objects, at)
+			p.path[k] = positionAU{X: pos.X, Y: pos.Y, Z: pos.Z}
+		}
+	}
+	return p
+}
+
+// cachedOrbitPath returns the path for (obj, points), computing it if it is
+// missing, was computed from different elements, or is an arc gone stale.
+func cachedOrbitPath(obj celestial.CelestialObject, objects []celestial.CelestialObject, points int, now time.Time) *orbitPath {
+	key := orbitKey{obj.Name, points}
+	orbitCacheMu.Lock()
+	p, ok := orbitCache[key]
+	orbitCacheMu.Unlock()
+	if ok && reflect.DeepEqual(p.obj, obj) && (p.closed || now.Sub(p.computed) < orbitArcTTL) {
+		return p
+	}
+	// Computed outside the lock, so one slow path doesn't hold up the rest.
+	p = computeOrbitPath(obj, objects, points, now)
+	orbitCacheMu.Lock()
+	defer orbitCacheMu.Unlock()
+	if _, ok := orbitCache[key]; !ok && len(orbitCache) >= orbitCacheSize {
+		for k := range orbitCache { // evict an arbitrary entry
+			delete(orbitCache, k)
+			break
+		}
+	}
+	orbitCache[key] = p
+	return p
+}
+
+// currentIndex is the sample of p nearest obj's position at now.
+func (p *orbitPath) currentIndex(obj celestial.CelestialObject, now time.Time) int {
+	n := len(p.path)
+	if p.closed {
+		M := elementsAt(obj, centuriesSinceJ2000TDB(now)).M
+		// The last sample repeats the first.
+		return int(math.Round(M/(2*math.Pi)*float64(n-1))) % (n - 1)
+	}
+	frac := float64(now.Sub(p.start)) / float64(p.end.Sub(p.start))
+	return min(max(int(math.Round(frac*float64(n-1))), 0), n-1)
+}
+
+// handleOrbit serves /api/orbit.
+func (s *Server) handleOrbit(w http.ResponseWriter, r *http.Request) {
+	release, err := s.distanceLimiter.Acquire(s.requestClientIP(r))
+	if err != nil {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	q := r.URL.Query()
+	name := strings.TrimSpace(q.Get("body"))
+	if name == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.orbit_body")
+		return
+	}
+	points := defaultOrbitPoints
+	if ps := q.Get("points"); ps != "" {
+		n, err := strconv.Atoi(ps)
+		if err != nil || n < 2 {
+			writeJSONError(w, r, http.StatusBadRequest, "error.orbit_points")
+			return
+		}
+		points = min(n, maxOrbitPoints)
+	}
+
+	resp, status, err := computeOrbit(name, points, simTime(time.Now()))
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// computeOrbit builds the /api/orbit response for the named body. On failure
+// it returns the HTTP status the caller should use alongside the error.
+func computeOrbit(name string, points int, now time.Time) (*OrbitResponse, int, error) {
+	objects := getCelestialObjects()
+	obj, ok := findObjectByName(objects, name)
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("unknown celestial body: %q", name)
+	}
+	if obj.Type == "star" {
+		return nil, http.StatusBadRequest, fmt.Errorf("%s does not orbit anything", obj.Name)
+	}
+
+	p := cachedOrbitPath(obj, objects, points, now)
+	resp := &OrbitResponse{
+		Body:         obj.Name,
+		Kind:         "arc",
+		Frame:        "heliocentric",
+		Parent:       orbitFrameParent(obj),
+		Timestamp:    now.UTC(),
+		Points:       len(p.path),
+		CurrentIndex: p.currentIndex(obj, now),
+		Path:         p.path,
+	}
+	if resp.Parent != "" {
+		resp.Frame = "parent"
+	}
+	if p.closed {
+		resp.Kind = "orbit"
+		resp.PeriodDays = p.periodDays
+	} else {
+		resp.ArcStart, resp.ArcEnd = &p.start, &p.end
+	}
+	return resp, http.StatusOK, nil
+}
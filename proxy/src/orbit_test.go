@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func orbitRequest(t *testing.T, query string) (int, *OrbitResponse) {
+	t.Helper()
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/orbit?"+query, nil))
+	var out OrbitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("response not JSON (%d): %q", rec.Code, rec.Body.String())
+	}
+	return rec.Code, &out
+}
+
+func auDistance(a, b positionAU) float64 {
+	return math.Sqrt((a.X-b.X)*(a.X-b.X) + (a.Y-b.Y)*(a.Y-b.Y) + (a.Z-b.Z)*(a.Z-b.Z))
+}
+
+// TestOrbitClosed checks elliptical orbits come back to their start, with
+// the requested number of samples.
+func TestOrbitClosed(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	for _, tc := range []struct {
+		body, parent string
+		points       int
+		periodDays   float64
+	}{
+		{"mars", "", 360, 687},
+		{"earth", "", 100, 365.25},
+		{"bennu", "", 64, 436.6},
+		{"parker-solar-probe", "", 90, 88},
+		{"phobos", "Mars", 50, 0.319},
+		{"moon", "Earth", 2048, 27.3},
+	} {
+		t.Run(tc.body, func(t *testing.T) {
+			code, o := orbitRequest(t, "body="+tc.body+"&points="+strconv.Itoa(tc.points))
+			if code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", code)
+			}
+			if o.Kind != "orbit" || o.Parent != tc.parent {
+				t.Errorf("kind %q parent %q, want orbit relative to %q", o.Kind, o.Parent, tc.parent)
+			}
+			if o.Points != tc.points || len(o.Path) != tc.points {
+				t.Fatalf("got %d points (%d in path), want %d", o.Points, len(o.Path), tc.points)
+			}
+			if math.Abs(o.PeriodDays-tc.periodDays) > 0.01*tc.periodDays {
+				t.Errorf("period %.3f days, want ~%.3f", o.PeriodDays, tc.periodDays)
+			}
+			first, last, second := o.Path[0], o.Path[len(o.Path)-1], o.Path[1]
+			if d := auDistance(first, last); d > 1e-9*math.Hypot(first.X, first.Y) {
+				t.Errorf("path not closed: first %+v, last %+v", first, last)
+			}
+			if auDistance(first, second) == 0 {
+				t.Error("path doesn't move")
+			}
+			if o.CurrentIndex < 0 || o.CurrentIndex >= tc.points-1 {
+				t.Errorf("current_index %d out of range", o.CurrentIndex)
+			}
+		})
+	}
+}
+
+// TestOrbitCurrentIndex checks current_index picks the sample beside the
+// body's actual position.
+func TestOrbitCurrentIndex(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	setCelestialObjects(objects)
+	mars, _ := findObjectByName(objects, "Mars")
+
+	for _, at := range []time.Time{
+		time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+		time.Date(2031, 3, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		o, _, err := computeOrbit("mars", 360, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos := GetObjectPosition(mars, objects, at)
+		// Adjacent samples are ~0.027 AU apart on Mars's orbit.
+		if d := auDistance(o.Path[o.CurrentIndex], positionAU{pos.X, pos.Y, pos.Z}); d > 0.03 {
+			t.Errorf("%s: sample %d is %.4f AU from Mars", at.Format("2006-01-02"), o.CurrentIndex, d)
+		}
+	}
+}
+
+// TestOrbitArc checks bodies without a closed orbit get a bounded arc.
+func TestOrbitArc(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	code, o := orbitRequest(t, "body=voyager-1&points=101")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if o.Kind != "arc" || o.Frame != "heliocentric" || o.PeriodDays != 0 {
+		t.Errorf("got kind %q frame %q period %v, want a heliocentric arc", o.Kind, o.Frame, o.PeriodDays)
+	}
+	if o.ArcStart == nil || o.ArcEnd == nil {
+		t.Fatal("arc_start/arc_end missing")
+	}
+	if span := o.ArcEnd.Sub(*o.ArcStart); span != 2*orbitArcSpan {
+		t.Errorf("arc spans %v, want %v", span, 2*orbitArcSpan)
+	}
+	if len(o.Path) != 101 || o.CurrentIndex != 50 {
+		t.Errorf("got %d points, current_index %d; want 101 and 50", len(o.Path), o.CurrentIndex)
+	}
+	// Voyager recedes at ~3.6 AU/yr: four years is ~14 AU.
+	if d := auDistance(o.Path[0], o.Path[100]); d < 10 || d > 20 {
+		t.Errorf("arc is %.1f AU long, want ~14", d)
+	}
+}
+
+func TestOrbitPoints(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	if _, o := orbitRequest(t, "body=mars"); o.Points != defaultOrbitPoints {
+		t.Errorf("default points = %d, want %d", o.Points, defaultOrbitPoints)
+	}
+	if _, o := orbitRequest(t, "body=mars&points=100000"); o.Points != maxOrbitPoints || len(o.Path) != maxOrbitPoints {
+		t.Errorf("points not capped: %d", o.Points)
+	}
+	for _, q := range []string{"body=mars&points=1", "body=mars&points=lots", "points=10", "body=sun"} {
+		if code, _ := orbitRequest(t, q); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", q, code)
+		}
+	}
+	if code, _ := orbitRequest(t, "body=vulcan"); code != http.StatusNotFound {
+		t.Errorf("unknown body: expected 404, got %d", code)
+	}
+}
+
+// TestOrbitCache checks paths are reused across requests and recomputed when
+// the body's elements change.
+func TestOrbitCache(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	now := time.Now()
+	mars, _ := findObjectByName(objects, "Mars")
+
+	p := cachedOrbitPath(mars, objects, 123, now)
+	if again := cachedOrbitPath(mars, objects, 123, now.Add(48*time.Hour)); again != p {
+		t.Error("closed orbit recomputed")
+	}
+	mars.E += 0.01
+	if changed := cachedOrbitPath(mars, objects, 123, now); changed == p {
+		t.Error("path not recomputed after the elements changed")
+	}
+
+	voyager, _ := findObjectByName(objects, "Voyager 1")
+	arc := cachedOrbitPath(voyager, objects, 11, now)
+	if again := cachedOrbitPath(voyager, objects, 11, now.Add(time.Hour)); again != arc {
+		t.Error("fresh arc recomputed")
+	}
+	if stale := cachedOrbitPath(voyager, objects, 11, now.Add(orbitArcTTL)); stale == arc {
+		t.Error("stale arc reused")
+	}
+
+	// Walking every resolution can't grow the cache without bound.
+	for points := 2; points <= 2*orbitCacheSize; points++ {
+		cachedOrbitPath(mars, objects, points, now)
+	}
+	orbitCacheMu.Lock()
+	n := len(orbitCache)
+	orbitCacheMu.Unlock()
+	if n > orbitCacheSize {
+		t.Errorf("%d paths cached, want at most %d", n, orbitCacheSize)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// outerMoons are the major moons of Saturn, Uranus and Neptune, by host.
+var outerMoons = map[string]string{
+	"tethys.saturn.latency.space":  "Tethys",
+	"dione.saturn.latency.space":   "Dione",
+	"iapetus.saturn.latency.space": "Iapetus",
+	"miranda.uranus.latency.space": "Miranda",
+	"ariel.uranus.latency.space":   "Ariel",
+	"umbriel.uranus.latency.space": "Umbriel",
+	"titania.uranus.latency.space": "Titania",
+	"oberon.uranus.latency.space":  "Oberon",
+	"triton.neptune.latency.space": "Triton",
+}
+
+// TestOuterPlanetMoons checks each major outer moon resolves from its host,
+// is listed under moons in /api/status-data, and sits no further from its
+// parent than its orbit allows.
+func TestOuterPlanetMoons(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	withObjects(t, objects)
+	fakeDistanceClock(t, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/status-data", nil))
+	var status ApiResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	entries := make(map[string]StatusEntry)
+	for key, list := range status.Objects {
+		for _, e := range list {
+			if key == "moons" || key == "planets" {
+				entries[e.Name] = e
+			}
+		}
+	}
+
+	for host, name := range outerMoons {
+		got, err := parseCelestialHost(objects, host)
+		if err != nil || got != name {
+			t.Errorf("%s: %q, %v; want %s", host, got, err, name)
+		}
+		moon, ok := entries[name]
+		if !ok || moon.Type != "moon" {
+			t.Errorf("%s isn't under moons in /api/status-data", name)
+			continue
+		}
+		obj, _ := findObjectByName(objects, name)
+		parent := entries[obj.ParentName]
+		if moon.ParentName != obj.ParentName || parent.Name == "" {
+			t.Errorf("%s: parent %q in status-data, want %s", name, moon.ParentName, obj.ParentName)
+			continue
+		}
+		// Both distances are light-time corrected to slightly different
+		// instants, hence the margin past the apoapsis.
+		if reach := obj.A*(1+obj.E) + 1000; math.Abs(moon.Distance-parent.Distance) > reach {
+			t.Errorf("%s is %.0f km from Earth, %s %.0f km: more than its %.0f km orbit apart",
+				name, moon.Distance, obj.ParentName, parent.Distance, reach)
+		}
+	}
+
+	var order []string
+	for _, m := range moonsOf(objects, "Uranus") {
+		order = append(order, m.Name)
+	}
+	if got, want := strings.Join(order, ","), "Miranda,Ariel,Umbriel,Titania,Oberon"; got != want {
+		t.Errorf("Uranus's moons %s, want %s (innermost first)", got, want)
+	}
+}
+
+// TestRetrogradeMoons checks Triton and Phoebe go round their parents the
+// opposite way to the prograde moons, always the same way through a day,
+// at about their mean motions.
+func TestRetrogradeMoons(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	start := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name       string
+		retrograde bool
+	}{{"Triton", true}, {"Phoebe", true}, {"Titania", false}, {"Titan", false}} {
+		obj, _ := findObjectByName(objects, tc.name)
+		var swept float64 // degrees around the orbit normal of the first step
+		var normal celestial.Vector3
+		prev := parentRelativePosition(obj, centuriesSinceJ2000TDB(start))
+		for h := 1; h <= 24; h++ {
+			pos := parentRelativePosition(obj, centuriesSinceJ2000TDB(start.Add(time.Duration(h)*time.Hour)))
+			step := cross(prev, pos)
+			if h == 1 {
+				normal = step
+			}
+			if dot(step, normal) <= 0 {
+				t.Fatalf("%s turned back at hour %d", tc.name, h)
+			}
+			swept += math.Atan2(math.Sqrt(dot(step, step)), dot(prev, pos)) * 180 / math.Pi
+			prev = pos
+		}
+		if retrograde := normal.Z < 0; retrograde != tc.retrograde {
+			t.Errorf("%s: retrograde %v, want %v (orbit normal %v)", tc.name, retrograde, tc.retrograde, normal)
+		}
+		// The angle swept varies with the anomaly on eccentric orbits.
+		if want := 360 / obj.Period; math.Abs(swept-want) > 0.25*want {
+			t.Errorf("%s swept %.3f degrees in a day, want about %.3f", tc.name, swept, want)
+		}
+	}
+}
+
+func cross(a, b celestial.Vector3) celestial.Vector3 {
+	return celestial.Vector3{X: a.Y*b.Z - a.Z*b.Y, Y: a.Z*b.X - a.X*b.Z, Z: a.X*b.Y - a.Y*b.X}
+}
+
+func dot(a, b celestial.Vector3) float64 {
+	return a.X*b.X + a.Y*b.Y + a.Z*b.Z
+}
@@ -0,0 +1,68 @@
+// overhead.go - how much of a proxied request's delay is not simulated.
+//
+// What a user experiences is the simulated light time plus their own
+// network's round trip plus whatever the proxy spends on the request
+// itself. The first is exact; the other two are error. /api/ping (ping.go)
+// lets a client measure its side; this file measures ours.
+//
+// A -overhead-sampling-pct share of proxied requests records the real time
+// they spent outside the intentional delays, in latency_space_overhead_seconds
+// by body and path:
+//
+//	socks  SOCKS CONNECT: greeting to success reply, less the latency sleep
+//	       (address parsing, security and link checks, upstream dial)
+//	dtn    store-and-forward fetch: upstream dial, time to first byte and
+//	       the response (the light-time legs are timers, not time spent)
+//
+// Next to the Moon's 1.3 s, a 50 ms overhead is already a few percent.
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// defaultOverheadSamplingPct is -overhead-sampling-pct's default.
+const defaultOverheadSamplingPct = 1.0
+
+// Overhead paths: the path label of latency_space_overhead_seconds.
+const (
+	overheadSOCKS = "socks"
+	overheadDTN   = "dtn"
+)
+
+// overheadSampler picks the requests whose overhead is recorded. A nil
+// *overheadSampler samples nothing.
+type overheadSampler struct {
+	pct  float64
+	draw func() float64 // uniform in [0, 1)
+}
+
+// newOverheadSampler samples pct percent of requests, 0-100.
+func newOverheadSampler(pct float64) (*overheadSampler, error) {
+	if pct < 0 || pct > 100 {
+		return nil, fmt.Errorf("%v: want a percentage, 0-100", pct)
+	}
+	return &overheadSampler{pct: pct, draw: rand.Float64}, nil
+}
+
+// sample reports whether to record this request.
+func (o *overheadSampler) sample() bool {
+	if o == nil || o.pct <= 0 {
+		return false
+	}
+	return o.pct >= 100 || o.draw()*100 < o.pct
+}
+
+// record observes d for body on path, if this request is sampled. A
+// negative d (a clock step) is recorded as 0.
+func (o *overheadSampler) record(metrics Metrics, body, path string, d time.Duration) {
+	if !o.sample() {
+		return
+	}
+	if d < 0 {
+		d = 0
+	}
+	orNop(metrics).RecordOverhead(body, path, d)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestOverheadSampling(t *testing.T) {
+	const n = 100000
+	for _, pct := range []float64{0, 1, 25, 100} {
+		o, err := newOverheadSampler(pct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.draw = rand.New(rand.NewSource(1)).Float64
+		sampled := 0
+		for i := 0; i < n; i++ {
+			if o.sample() {
+				sampled++
+			}
+		}
+		if got := 100 * float64(sampled) / n; math.Abs(got-pct) > 0.5 {
+			t.Errorf("%v%%: sampled %.2f%%", pct, got)
+		}
+	}
+
+	var none *overheadSampler
+	if none.sample() {
+		t.Error("nil sampler sampled")
+	}
+	for _, pct := range []float64{-1, 100.5} {
+		if _, err := newOverheadSampler(pct); err == nil {
+			t.Errorf("%v%% accepted", pct)
+		}
+	}
+}
+
+func TestOverheadRecord(t *testing.T) {
+	m := NewRecordingMetrics()
+	all := &overheadSampler{pct: 100}
+	all.record(m, "Moon", overheadDTN, 40*time.Millisecond)
+	all.record(m, "Moon", overheadDTN, -time.Millisecond)
+	(*overheadSampler)(nil).record(m, "Moon", overheadDTN, time.Second)
+	if got := m.Observations(metricOverhead, "Moon", overheadDTN); len(got) != 2 || got[0] != 0.04 || got[1] != 0 {
+		t.Errorf("observations %v, want [0.04 0]", got)
+	}
+}
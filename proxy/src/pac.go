@@ -0,0 +1,161 @@
+// pac.go - browser proxy auto-config (PAC) and setup instructions per body.
+//
+//	GET mars.latency.space/proxy.pac         PAC script routing via Mars
+//	GET latency.space/proxy.pac?body=mars    the same, from the apex
+//	GET mars.latency.space/setup             Firefox/Chrome/CLI instructions
+//
+// The PAC sends everything through the body's SOCKS5 endpoint except
+// latency.space itself (so the status UI and these pages stay reachable),
+// plain and loopback hosts, and RFC 1918 addresses. There is no DIRECT
+// fallback: a request that silently skipped the proxy would also skip the
+// latency. Both are generated from the bound or configured SOCKS port and
+// served without latency.
+package main
+
+import (
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	texttemplate "text/template"
+)
+
+// pacMaxAge is how long browsers may cache the PAC and setup page. The
+// content only changes with the deployment's SOCKS port.
+const pacMaxAge = 3600
+
+// pacDirectNets are the networks the PAC never proxies: loopback and the
+// RFC 1918 private ranges, as isInNet pattern/mask pairs.
+var pacDirectNets = [][2]string{
+	{"127.0.0.0", "255.0.0.0"},
+	{"10.0.0.0", "255.0.0.0"},
+	{"172.16.0.0", "255.240.0.0"},
+	{"192.168.0.0", "255.255.0.0"},
+}
+
+// proxySetup is the configuration the PAC and setup page are rendered from.
+type proxySetup struct {
+	Body       string
+	SOCKSHost  string
+	SOCKSPort  int
+	Apex       string // never proxied
+	PACURL     string
+	DirectNets [][2]string
+}
+
+// SOCKSAddr is host:port for the SOCKS5 endpoint.
+func (p proxySetup) SOCKSAddr() string {
+	return p.SOCKSHost + ":" + strconv.Itoa(p.SOCKSPort)
+}
+
+var pacTemplate = texttemplate.Must(texttemplate.New("pac").Parse(`// latency.space proxy auto-config: route through {{.Body}}.
+// Generated for SOCKS5 {{.SOCKSAddr}}; {{.Apex}}, local hosts and private
+// networks are reached directly.
+function FindProxyForURL(url, host) {
+  host = host.toLowerCase();
+  if (host === "{{.Apex}}" || dnsDomainIs(host, ".{{.Apex}}")) {
+    return "DIRECT";
+  }
+  if (isPlainHostName(host) || host === "localhost" || host === "::1" || host === "[::1]") {
+    return "DIRECT";
+  }
+  if (/^\d+\.\d+\.\d+\.\d+$/.test(host) && ({{range $i, $n := .DirectNets}}{{if $i}} ||
+      {{end}}isInNet(host, "{{index $n 0}}", "{{index $n 1}}"){{end}})) {
+    return "DIRECT";
+  }
+  return "SOCKS5 {{.SOCKSAddr}}";
+}
+`))
+
+var setupTemplate = htmltemplate.Must(htmltemplate.New("setup").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Browse via {{.Body}} - latency.space</title>
+<style>body{font-family:sans-serif;max-width:48em;margin:2em auto;padding:0 1em}pre{background:#f4f4f4;padding:.75em;overflow-x:auto}</style>
+</head>
+<body>
+<h1>Browse via {{.Body}}</h1>
+<p>Traffic goes through the SOCKS5 proxy at <code>{{.SOCKSAddr}}</code>, delayed by the light-travel time to {{.Body}} in each direction. {{.Apex}} itself and local addresses are not proxied.</p>
+
+<h2>Automatic (any browser)</h2>
+<p>Use this proxy auto-config URL:</p>
+<pre><code>{{.PACURL}}</code></pre>
+
+<h2>Firefox</h2>
+<ol>
+<li>Settings &rarr; General &rarr; Network Settings &rarr; Settings&hellip;</li>
+<li>Either choose <em>Automatic proxy configuration URL</em> and enter <code>{{.PACURL}}</code>,</li>
+<li>or choose <em>Manual proxy configuration</em>: SOCKS Host <code>{{.SOCKSHost}}</code>, Port <code>{{.SOCKSPort}}</code>, SOCKS v5, and tick <em>Proxy DNS when using SOCKS v5</em>.</li>
+</ol>
+
+<h2>Chrome / Chromium</h2>
+<p>Chrome uses the system proxy settings; to try it without changing them, start a separate profile:</p>
+<pre><code>chrome --user-data-dir=/tmp/latency-space --proxy-pac-url="{{.PACURL}}"</code></pre>
+
+<h2>Command line</h2>
+<pre><code>curl --socks5-hostname {{.SOCKSAddr}} https://example.com/
+export ALL_PROXY=socks5h://{{.SOCKSAddr}}</code></pre>
+<p>Use remote DNS (<code>--socks5-hostname</code>, <code>socks5h://</code>): the proxy accepts domain names, not resolved IP addresses.</p>
+</body>
+</html>
+`))
+
+// proxySetupFor returns the setup for r: the body named by ?body=, else by
+// the host. ok is false when neither names a body.
+func (s *Server) proxySetupFor(r *http.Request) (proxySetup, bool) {
+	var body string
+	objects := getCelestialObjects()
+	if q := r.URL.Query().Get("body"); q != "" {
+		if obj, found := findObjectByName(objects, q); found {
+			body = obj.Name
+		}
+	} else {
+		body = s.resolveCelestialHost(objects, r.Host)
+	}
+	if body == "" {
+		return proxySetup{}, false
+	}
+	domain := FormatFullDomain(body)
+	pacURL := s.webOrigin(domain) + "/proxy.pac"
+	if r.URL.Query().Get("body") != "" {
+		pacURL += "?body=" + url.QueryEscape(FormatDomainName(body))
+	}
+	return proxySetup{
+		Body:       body,
+		SOCKSHost:  domain,
+		SOCKSPort:  s.socksPort(),
+		Apex:       "latency.space",
+		PACURL:     pacURL,
+		DirectNets: pacDirectNets,
+	}, true
+}
+
+// handlePAC serves /proxy.pac.
+func (s *Server) handlePAC(w http.ResponseWriter, r *http.Request) {
+	setup, ok := s.proxySetupFor(r)
+	if !ok {
+		http.Error(w, "Unknown celestial body: use <body>.latency.space/proxy.pac or /proxy.pac?body=<body>", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(pacMaxAge))
+	if err := pacTemplate.Execute(w, setup); err != nil {
+		log.Printf("Error rendering PAC for %s: %v", setup.Body, err)
+	}
+}
+
+// handleSetup serves /setup.
+func (s *Server) handleSetup(w http.ResponseWriter, r *http.Request) {
+	setup, ok := s.proxySetupFor(r)
+	if !ok {
+		http.Error(w, "Unknown celestial body", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(pacMaxAge))
+	if err := setupTemplate.Execute(w, setup); err != nil {
+		log.Printf("Error rendering setup page for %s: %v", setup.Body, err)
+	}
+}
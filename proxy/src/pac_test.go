@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pacShape is a sanity check that the PAC is one FindProxyForURL function
+// (after leading comments) ending in a SOCKS5 return.
+var pacShape = regexp.MustCompile(`^(//[^\n]*\n)*function FindProxyForURL\(url, host\) \{\n(?s:.*)\n  return "SOCKS5 [a-z0-9.-]+:\d+";\n\}\n$`)
+
+func getPAC(t *testing.T, s *Server, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+// newPACTestServer serves with a second of latency, so a delayed response
+// shows.
+func newPACTestServer() *Server {
+	return &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), socksAddr: ":1080",
+		timing: fixedLatency(time.Second)}
+}
+
+func TestProxyPAC(t *testing.T) {
+	s := newPACTestServer()
+
+	start := time.Now()
+	rec := getPAC(t, s, "http://mars.latency.space/proxy.pac")
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("proxy.pac took %v; it must not be delayed", elapsed)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-ns-proxy-autoconfig" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "max-age=") {
+		t.Errorf("Cache-Control = %q, want a max-age", cc)
+	}
+	pac := rec.Body.String()
+	if !pacShape.MatchString(pac) {
+		t.Fatalf("PAC doesn't look like a FindProxyForURL script:\n%s", pac)
+	}
+	if strings.Count(pac, "{") != strings.Count(pac, "}") || strings.Count(pac, "(") != strings.Count(pac, ")") {
+		t.Errorf("unbalanced brackets in PAC:\n%s", pac)
+	}
+	if !strings.Contains(pac, `return "SOCKS5 mars.latency.space:1080";`) {
+		t.Errorf("PAC doesn't route via mars.latency.space:1080:\n%s", pac)
+	}
+	for _, direct := range []string{
+		`host === "latency.space"`,
+		`dnsDomainIs(host, ".latency.space")`,
+		`host === "localhost"`,
+		`isInNet(host, "127.0.0.0", "255.0.0.0")`,
+		`isInNet(host, "10.0.0.0", "255.0.0.0")`,
+		`isInNet(host, "172.16.0.0", "255.240.0.0")`,
+		`isInNet(host, "192.168.0.0", "255.255.0.0")`,
+	} {
+		if !strings.Contains(pac, direct) {
+			t.Errorf("PAC missing exclusion %s", direct)
+		}
+	}
+	if strings.Contains(pac, "PROXY") {
+		t.Errorf("PAC should only use SOCKS5:\n%s", pac)
+	}
+}
+
+func TestProxyPACPorts(t *testing.T) {
+	// Configured but not bound (SOCKS runs elsewhere): -socks-addr's port.
+	s := newPACTestServer()
+	s.socksAddr = "0.0.0.0:1085"
+	if pac := getPAC(t, s, "http://saturn.latency.space/proxy.pac").Body.String(); !strings.Contains(pac, `"SOCKS5 saturn.latency.space:1085"`) {
+		t.Errorf("PAC ignores -socks-addr port:\n%s", pac)
+	}
+
+	// Bound: the listener's actual port.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s.socksAddr = "127.0.0.1:0"
+	s.socksListener = ln
+	want := `"SOCKS5 saturn.latency.space:` + strings.TrimPrefix(ln.Addr().String(), "127.0.0.1:") + `"`
+	if pac := getPAC(t, s, "http://saturn.latency.space/proxy.pac").Body.String(); !strings.Contains(pac, want) {
+		t.Errorf("PAC doesn't use the bound port, want %s:\n%s", want, pac)
+	}
+}
+
+func TestProxyPACApex(t *testing.T) {
+	s := newPACTestServer()
+
+	rec := getPAC(t, s, "http://latency.space/proxy.pac?body=voyager-1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"SOCKS5 voyager-1.latency.space:1080"`) {
+		t.Errorf("apex PAC doesn't route via Voyager 1:\n%s", rec.Body.String())
+	}
+
+	for _, target := range []string{
+		"http://latency.space/proxy.pac",
+		"http://latency.space/proxy.pac?body=vulcan",
+	} {
+		if rec := getPAC(t, s, target); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", target, rec.Code)
+		}
+	}
+}
+
+func TestSetupPage(t *testing.T) {
+	s := newPACTestServer()
+
+	rec := getPAC(t, s, "http://mars.latency.space/setup")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	page := rec.Body.String()
+	for _, want := range []string{
+		"https://mars.latency.space/proxy.pac",
+		"--socks5-hostname mars.latency.space:1080",
+		"Firefox",
+		"Chrome",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("setup page missing %q", want)
+		}
+	}
+}
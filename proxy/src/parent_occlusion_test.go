@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// surfaceAsset returns a synthetic lander on the parent's equator at ecliptic
+// longitude lonDeg (a circular, zero-inclination "orbit" at the surface).
+func surfaceAsset(parent celestial.CelestialObject, lonDeg float64) celestial.CelestialObject {
+	return celestial.CelestialObject{
+		Name:       "Test Lander",
+		Type:       "spacecraft",
+		ParentName: parent.Name,
+		Radius:     0.003,
+		A:          parent.Radius + 0.01,
+		L:          lonDeg,
+	}
+}
+
+func TestSurfaceAssetOccludedByParent(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+	mars, _ := findObjectByName(objects, "Mars")
+	at := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+
+	// Ecliptic longitude of Earth as seen from Mars: the sub-Earth point.
+	toEarth := GetObjectPosition(earth, objects, at).Subtract(GetObjectPosition(mars, objects, at))
+	subEarth := math.Atan2(toEarth.Y, toEarth.X) * 180 / math.Pi
+
+	cases := []struct {
+		name     string
+		offset   float64 // degrees of longitude from the sub-Earth point
+		occluded bool
+	}{
+		{"sub-Earth", 0, false},
+		{"anti-Earth", 180, true},
+		{"well inside the near hemisphere", 60, false},
+		{"well inside the far hemisphere", 120, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lander := surfaceAsset(mars, subEarth+tc.offset)
+			withLander := append(append([]celestial.CelestialObject(nil), objects...), lander)
+
+			// The lander really is where the test intends.
+			rel := parentRelativePosition(lander, centuriesSinceJ2000TDB(at))
+			if cos := rel.Normalize().DotProduct(toEarth.Normalize()); math.Abs(cos-math.Cos(tc.offset*math.Pi/180)) > 0.01 {
+				t.Fatalf("lander not placed at %v° from sub-Earth (cos %.3f)", tc.offset, cos)
+			}
+
+			v, occluder := IsOccluded(earth, lander, withLander, at)
+			if occluded := v.Blocked(); occluded != tc.occluded {
+				t.Errorf("expected occluded=%v, got %v (by %q)", tc.occluded, occluded, occluder.Name)
+			}
+			if v.Blocked() && occluder.Name != "Mars" {
+				t.Errorf("expected Mars as the occluder, got %q", occluder.Name)
+			}
+		})
+	}
+}
+
+func TestParentBlocksLineGrazingMargin(t *testing.T) {
+	const radius = 3396.2
+	observer := celestial.Vector3{X: 2e8} // far away along +X
+	// A surface point just past the limb: 0.05° below the horizon.
+	angle := (90 + 0.05) * math.Pi / 180
+	target := celestial.Vector3{X: radius * math.Cos(angle), Y: radius * math.Sin(angle)}
+
+	if !parentBlocksLine(target, observer, radius, 0) {
+		t.Error("without a margin a point below the horizon must be blocked")
+	}
+	if parentBlocksLine(target, observer, radius, 0.1) {
+		t.Error("a 0.1° refraction margin should let a 0.05° dip through")
+	}
+	if parentBlocksLine(celestial.Vector3{X: radius}, observer, radius, 0) {
+		t.Error("the sub-observer point must be visible")
+	}
+	if !parentBlocksLine(celestial.Vector3{X: -radius - 100}, observer, radius, 0.1) {
+		t.Error("a low orbiter behind the parent must be blocked")
+	}
+}
+
+// TestPerseveranceUsesParentOcclusion checks the built-in rover is handled
+// by the parent-relative path.
+func TestPerseveranceUsesParentOcclusion(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+	rover, ok := findObjectByName(objects, "Mars Perseverance")
+	if !ok {
+		t.Fatal("Mars Perseverance missing from built-in objects")
+	}
+	if parent, near := closeParent(rover, earth, objects); !near || parent.Name != "Mars" {
+		t.Errorf("expected Mars as the close parent, got %q (%v)", parent.Name, near)
+	}
+	jwst, _ := findObjectByName(objects, "JWST")
+	if _, near := closeParent(jwst, earth, objects); near {
+		t.Error("JWST orbits the observer and must not use the parent check")
+	}
+	moon, _ := findObjectByName(objects, "Moon")
+	if _, near := closeParent(moon, earth, objects); near {
+		t.Error("the Moon's parent is the observer and must not use the parent check")
+	}
+}
@@ -0,0 +1,64 @@
+// ping.go - /api/ping, the client's half of overhead calibration.
+//
+//	GET /api/ping?clientTimestamp=1760605200123
+//
+// Nothing is delayed: the reply says when the server received the request,
+// when it answered, and how long it spent in between, measured on its
+// monotonic clock. A client that times the round trip itself then knows
+// its network's share:
+//
+//	network = client round trip - server_processing_seconds
+//
+// which is the error on top of the simulated light time it sees through the
+// proxy. clientTimestamp is echoed untouched, so a client can match replies
+// to pings. On a body host (mars.latency.space), or with body, the body's
+// simulated one-way light time is included to compare against.
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PingResponse is the JSON returned by /api/ping.
+type PingResponse struct {
+	ClientTimestamp   string    `json:"client_timestamp,omitempty"`
+	ServerReceived    time.Time `json:"server_received"`
+	ServerResponded   time.Time `json:"server_responded"`
+	ProcessingSeconds float64   `json:"server_processing_seconds"`
+	Body              string    `json:"body,omitempty"`
+	OneWaySeconds     float64   `json:"simulated_one_way_seconds,omitempty"`
+}
+
+// handlePing serves /api/ping for a request that reached handleHTTP at
+// received.
+func (s *Server) handlePing(w http.ResponseWriter, r *http.Request, received time.Time) {
+	out := PingResponse{ClientTimestamp: r.URL.Query().Get("clientTimestamp")}
+
+	name := strings.TrimSpace(r.URL.Query().Get("body"))
+	if name == "" {
+		name = s.resolveCelestialHost(getCelestialObjects(), r.Host)
+	}
+	if name != "" {
+		obj, ok := findObjectByName(getCelestialObjects(), name)
+		if !ok {
+			writeJSONError(w, r, http.StatusNotFound, "error.unknown_body_named", name)
+			return
+		}
+		distance, err := getCurrentDistance(obj.Name)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		out.Body = obj.Name
+		out.OneWaySeconds = s.oneWay(obj.Name, distance).Seconds()
+	}
+
+	responded := s.now()
+	out.ServerReceived = received.UTC()
+	out.ServerResponded = responded.UTC()
+	out.ProcessingSeconds = responded.Sub(received).Seconds()
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, out)
+}
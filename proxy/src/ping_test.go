@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestPing(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(),
+		timing: timing{clock: clock, latencies: &testLatencies{fixed: 12 * time.Minute}}}
+
+	ping := func(url string, received time.Time) (*httptest.ResponseRecorder, PingResponse) {
+		rec := httptest.NewRecorder()
+		s.handlePing(rec, httptest.NewRequest(http.MethodGet, url, nil), received)
+		var out PingResponse
+		json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec, out
+	}
+
+	// Received 7ms before the reply: that is the server's share.
+	clock.Advance(7 * time.Millisecond)
+	rec, got := ping("http://latency.space/api/ping?clientTimestamp=1760605200123", start)
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("%d %v: %s", rec.Code, rec.Header(), rec.Body.String())
+	}
+	want := PingResponse{ClientTimestamp: "1760605200123", ServerReceived: start,
+		ServerResponded: start.Add(7 * time.Millisecond), ProcessingSeconds: 0.007}
+	if got != want {
+		t.Errorf("ping = %+v, want %+v", got, want)
+	}
+
+	// A client that timed 57ms for the round trip spent 50ms on its network.
+	if network := 0.057 - got.ProcessingSeconds; network < 0.04999 || network > 0.05001 {
+		t.Errorf("network share %v", network)
+	}
+
+	if _, got := ping("http://mars.latency.space/api/ping", clock.Now()); got.Body != "Mars" || got.OneWaySeconds != 720 || got.ProcessingSeconds != 0 {
+		t.Errorf("mars ping = %+v", got)
+	}
+	if rec, _ := ping("http://latency.space/api/ping?body=vulcan", clock.Now()); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown body: %d", rec.Code)
+	}
+
+	// Through handleHTTP the receive time is taken on arrival.
+	rec = httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/ping", nil))
+	var routed PingResponse
+	if json.Unmarshal(rec.Body.Bytes(), &routed); !routed.ServerReceived.Equal(clock.Now()) || routed.ClientTimestamp != "" {
+		t.Errorf("routed ping = %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// BenchmarkCalculateDistance times one Earth to Mars distance.
+func BenchmarkCalculateDistance(b *testing.B) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+	mars, _ := findObjectByName(objects, "Mars")
+	at := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < b.N; i++ {
+		CalculateDistance(earth, mars, objects, at)
+	}
+}
+
+// BenchmarkComputeDistances times a full distance-table refresh: every
+// body's distance, visibility and elongation from Earth.
+func BenchmarkComputeDistances(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	b.Cleanup(func() { os.Stdout = stdout })
+
+	objects := celestial.InitSolarSystemObjects()
+	at := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < b.N; i++ {
+		computeDistances(objects, at.Add(time.Duration(i)*time.Second))
+	}
+}
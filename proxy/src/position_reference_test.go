@@ -0,0 +1,143 @@
+//go:build reference
+
+// The position code as it was before the Kepler and rotation fast paths,
+// kept to check them against:
+//
+//	go test -tags reference -run Reference .
+//
+// Delete this file once the fast paths have been in production a while.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func referenceSolveKepler(M float64, e float64) float64 {
+	M = math.Remainder(M, 2*math.Pi)
+	var E float64
+	if e < 0.8 {
+		E = M + e*math.Sin(M)*(1.0+e*math.Cos(M))
+	} else {
+		E = M + 0.85*e*math.Copysign(1, math.Sin(M))
+	}
+	lo, hi := -math.Pi, math.Pi
+	for iter := 0; iter < 50; iter++ {
+		error := E - e*math.Sin(E) - M
+		if math.Abs(error) < 1e-14 {
+			break
+		}
+		if error < 0 {
+			lo = E
+		} else {
+			hi = E
+		}
+		next := E - error/(1.0-e*math.Cos(E))
+		if next <= lo || next >= hi {
+			next = (lo + hi) / 2
+		}
+		E = next
+	}
+	return normalizeRadians(E)
+}
+
+func referenceTrueAnomaly(e, E float64) float64 {
+	return 2.0 * math.Atan2(
+		math.Sqrt(1.0+e)*math.Sin(E/2.0),
+		math.Sqrt(1.0-e)*math.Cos(E/2.0),
+	)
+}
+
+func referenceKeplerPerifocal(a, e, M float64) (x, y float64) {
+	E := referenceSolveKepler(M, e)
+	v := referenceTrueAnomaly(e, E)
+	r := a * (1.0 - e*math.Cos(E))
+	return r * math.Cos(v), r * math.Sin(v)
+}
+
+func referenceOrbitalToReference(xOrb, yOrb, i, w, node float64) celestial.Vector3 {
+	zOrb := 0.0
+	xRef := xOrb*math.Cos(w) - yOrb*math.Sin(w)
+	yRef := xOrb*math.Sin(w) + yOrb*math.Cos(w)
+	zRef := zOrb
+	xInc := xRef
+	yInc := yRef*math.Cos(i) - zRef*math.Sin(i)
+	zInc := yRef*math.Sin(i) + zRef*math.Cos(i)
+	x := xInc*math.Cos(node) - yInc*math.Sin(node)
+	y := xInc*math.Sin(node) + yInc*math.Cos(node)
+	return celestial.Vector3{X: x, Y: y, Z: zInc}
+}
+
+func referenceCenturies(t time.Time) float64 {
+	ttJD := timeToJulianDate(t) + 70.0/celestial.SECONDS_PER_DAY
+	return (TTtoTDB(ttJD) - celestial.J2000_EPOCH) / celestial.DAYS_PER_CENTURY
+}
+
+// referencePosition is GetObjectPosition built from the reference parts.
+func referencePosition(obj celestial.CelestialObject, objects []celestial.CelestialObject, t time.Time) celestial.Vector3 {
+	if obj.Name == "Sun" {
+		return celestial.Vector3{}
+	}
+	T := referenceCenturies(t)
+	local := func() celestial.Vector3 {
+		if isEarthMoon(obj) {
+			return calculateLunarPosition(T)
+		}
+		el := elementsAt(obj, T)
+		if el.nearParabolic() {
+			x, y := universalPerifocal(el.q, el.e, el.dt)
+			return referenceOrbitalToReference(x, y, el.i, el.w, el.node)
+		}
+		x, y := referenceKeplerPerifocal(el.a, el.e, el.M)
+		return referenceOrbitalToReference(x, y, el.i, el.w, el.node)
+	}
+	if hasHeliocentricElements(obj) || obj.Type == "comet" {
+		return local()
+	}
+	parent, ok := findObjectByName(objects, obj.ParentName)
+	if !ok {
+		return celestial.Vector3{}
+	}
+	pos := local()
+	if elementsInKm(obj) {
+		pos = pos.Scale(1 / celestial.AU)
+	}
+	return referencePosition(parent, objects, t).Add(pos)
+}
+
+// TestReferencePositions checks every body over a decade against the
+// reference implementation.
+func TestReferencePositions(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var worst float64
+	for at := start; at.Before(start.AddDate(10, 0, 0)); at = at.Add(97*time.Hour + 13*time.Minute) {
+		for _, obj := range objects {
+			d := GetObjectPosition(obj, objects, at).Subtract(referencePosition(obj, objects, at)).Magnitude()
+			if d > 1e-9 {
+				t.Errorf("%s on %s: %.3g AU from the reference", obj.Name, at.Format(time.DateTime), d)
+			}
+			worst = math.Max(worst, d)
+		}
+	}
+	t.Logf("largest difference %.3g AU", worst)
+}
+
+// TestReferenceKepler checks the Kepler solve and the perifocal position
+// over the whole range of mean anomaly and eccentricity.
+func TestReferenceKepler(t *testing.T) {
+	for _, e := range []float64{0, 0.0167, 0.2, 0.5, 0.79, 0.8, 0.9, 0.967143, 0.99} {
+		for k := 0; k < 7200; k++ {
+			M := 2*math.Pi*float64(k)/7200 - math.Pi/3
+			x, y := keplerPerifocal(1, e, M)
+			rx, ry := referenceKeplerPerifocal(1, e, M)
+			if d := math.Hypot(x-rx, y-ry); d > 1e-12 {
+				t.Fatalf("e %v, M %.5f: %.3g from the reference", e, M, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// preflight.go - direct reachability checks before paying the latency.
+//
+// A SOCKS CONNECT to Mars sleeps the one-way light time before dialing, and a
+// DTN job waits a full round trip; finding out then that the target refuses
+// connections is the worst way to learn it. Before the sleep, a target the
+// proxy hasn't reached recently is probed directly - a TCP connect, plus a
+// TLS handshake for https URLs - bounded to preflightTimeout and not subject
+// to the simulated latency: the ground station checking the schedule before
+// it transmits. The result is cached per host:port for the TTL
+// (-preflight-ttl), and real dials refresh it, so an active target is not
+// probed again. A known-down target fails at once with UPSTREAM_UNREACHABLE
+// and the age of the probe that found it down.
+//
+// -no-preflight turns this off, and an HTTP request can opt out with
+// X-Latency-Space-Preflight: off, for anyone who wants the authentic wait.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// preflightUnreachableCode is the structured error code for a target a
+	// probe found down.
+	preflightUnreachableCode = "UPSTREAM_UNREACHABLE"
+	// preflightHeader set to "off" skips the probe for one request.
+	preflightHeader = "X-Latency-Space-Preflight"
+
+	preflightTimeout    = 5 * time.Second // bound on one probe, dial and handshake
+	defaultPreflightTTL = time.Minute     // default -preflight-ttl
+	preflightMaxEntries = 10000           // cached targets before expired ones are swept
+)
+
+// probeResult is what is known about one target.
+type probeResult struct {
+	Up    bool
+	Cause string    // refused, timeout, dns, tls, network (see classifyUpstreamError); empty when up
+	At    time.Time // when the probe ran or the target was last dialed
+}
+
+// Preflight probes and caches target reachability. A nil *Preflight probes
+// nothing and reports every target up.
+type Preflight struct {
+	ttl     time.Duration
+	metrics Metrics
+
+	// dial and now are replaced by tests.
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	now  func() time.Time
+
+	mu      sync.Mutex
+	results map[string]probeResult
+}
+
+// NewPreflight caches probe results for ttl; ttl <= 0 disables probing and
+// returns nil.
+func NewPreflight(ttl time.Duration, metrics Metrics) *Preflight {
+	if ttl <= 0 {
+		return nil
+	}
+	d := &net.Dialer{}
+	return &Preflight{
+		ttl:     ttl,
+		metrics: orNop(metrics),
+		dial:    d.DialContext,
+		now:     time.Now,
+		results: make(map[string]probeResult),
+	}
+}
+
+// TTL is how long a result is trusted, 0 when probing is off.
+func (p *Preflight) TTL() time.Duration {
+	if p == nil {
+		return 0
+	}
+	return p.ttl
+}
+
+// Check returns what is known about addr (host:port), probing it if there is
+// no result younger than the TTL. serverName, when set, adds a TLS handshake
+// for that name to the probe. ok is false only for a target known to be down.
+func (p *Preflight) Check(ctx context.Context, addr, serverName string) (res probeResult, ok bool) {
+	if p == nil {
+		return probeResult{Up: true}, true
+	}
+	p.mu.Lock()
+	res, cached := p.results[addr]
+	p.mu.Unlock()
+	if cached && p.now().Sub(res.At) < p.ttl {
+		p.metrics.RecordPreflightLookup("hit")
+		return res, res.Up
+	}
+	p.metrics.RecordPreflightLookup("miss")
+
+	res = p.probe(ctx, addr, serverName)
+	result := "up"
+	if !res.Up {
+		result = res.Cause
+	}
+	p.metrics.RecordPreflightProbe(result)
+	p.store(addr, res)
+	return res, res.Up
+}
+
+// Record notes the outcome of a real dial to addr, so a target in use is not
+// probed again and one that just failed is known down.
+func (p *Preflight) Record(addr string, err error) {
+	if p == nil {
+		return
+	}
+	res := probeResult{Up: err == nil, At: p.now()}
+	if err != nil {
+		res.Cause = classifyUpstreamError(err)
+	}
+	p.store(addr, res)
+}
+
+// Age is how long ago res was observed.
+func (p *Preflight) Age(res probeResult) time.Duration {
+	if p == nil || res.At.IsZero() {
+		return 0
+	}
+	return p.now().Sub(res.At)
+}
+
+// probe dials addr directly, then shakes hands as serverName if one is given.
+func (p *Preflight) probe(ctx context.Context, addr, serverName string) probeResult {
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	res := probeResult{At: p.now()}
+	conn, err := p.dial(ctx, "tcp", addr)
+	if err == nil {
+		defer conn.Close()
+		if serverName != "" {
+			err = tls.Client(conn, &tls.Config{ServerName: serverName}).HandshakeContext(ctx)
+		}
+	}
+	if err != nil {
+		res.Cause = classifyUpstreamError(err)
+		return res
+	}
+	res.Up = true
+	return res
+}
+
+func (p *Preflight) store(addr string, res probeResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.results) >= preflightMaxEntries {
+		now := p.now()
+		for k, old := range p.results {
+			if now.Sub(old.At) >= p.ttl {
+				delete(p.results, k)
+			}
+		}
+	}
+	if _, known := p.results[addr]; known || len(p.results) < preflightMaxEntries {
+		p.results[addr] = res
+	}
+}
+
+// preflightURL checks the host of an HTTP target URL before a request for it
+// is accepted. Requests that opted out, and URLs the security validator
+// refuses anyway, are not probed. up is false only for a target known down.
+func (s *Server) preflightURL(r *http.Request, rawURL string) (addr string, res probeResult, up bool) {
+	if s.preflight == nil || preflightOff(r) {
+		return "", res, true
+	}
+	validated, err := s.security.ValidateHTTPTarget(rawURL)
+	if err != nil {
+		return "", res, true
+	}
+	addr, serverName, ok := preflightTarget(validated)
+	if !ok {
+		return "", res, true
+	}
+	res, up = s.preflight.Check(r.Context(), addr, serverName)
+	return addr, res, up
+}
+
+// preflightOff reports whether r asked to skip the probe.
+func preflightOff(r *http.Request) bool {
+	return strings.EqualFold(strings.TrimSpace(r.Header.Get(preflightHeader)), "off")
+}
+
+// preflightTarget is the host:port and TLS server name a probe of rawURL
+// dials; ok is false for URLs that can't be fetched anyway.
+func preflightTarget(rawURL string) (addr, serverName string, ok bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return "", "", false
+	}
+	port := u.Port()
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		serverName = u.Hostname()
+		if port == "" {
+			port = "443"
+		}
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	default:
+		return "", "", false
+	}
+	return net.JoinHostPort(u.Hostname(), port), serverName, true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// TestPreflightShortCircuitsDownTarget checks that a CONNECT to a target
+// that refuses connections fails before the simulated latency, with the
+// reason in the metadata frame, and that the next one is answered from the
+// cache without a second probe.
+func TestPreflightShortCircuitsDownTarget(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	metrics := NewRecordingMetrics()
+	proxy := startTestSOCKS(t, &Server{security: newTestSecurity(), metrics: metrics,
+		fixedCelestialBody: "Mars", timing: fixedLatency(10 * time.Second),
+		preflight: NewPreflight(time.Minute, metrics)})
+	down := closedPort(t)
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		start := time.Now()
+		_, meta, err := client.Dial(ctx, proxy, down)
+		cancel()
+		var re *client.ReplyError
+		if !errors.As(err, &re) || re.Code != SOCKS5_REP_CONN_REFUSED {
+			t.Fatalf("dial %d: %v, want a connection-refused reply", i, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("dial %d took %v: the latency was paid before failing", i, elapsed)
+		}
+		if meta == nil || meta.ErrorCode != preflightUnreachableCode {
+			t.Errorf("dial %d metadata = %+v, want %s", i, meta, preflightUnreachableCode)
+		}
+	}
+
+	if v := metrics.Count(metricPreflightProbes, "refused"); v != 1 {
+		t.Errorf("refused probes = %v, want 1", v)
+	}
+	if v := metrics.Count(metricPreflightCache, "miss"); v != 1 {
+		t.Errorf("cache misses = %v, want 1", v)
+	}
+	if v := metrics.Count(metricPreflightCache, "hit"); v != 1 {
+		t.Errorf("cache hits = %v, want 1", v)
+	}
+}
+
+// TestPreflightCacheExpiry checks that a result is reused within the TTL,
+// probed again once it expires, and refreshed by a real dial.
+func TestPreflightCacheExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := NewPreflight(time.Minute, NewRecordingMetrics())
+	p.now = func() time.Time { return now }
+	probes := 0
+	refuse := true
+	p.dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		probes++
+		if refuse {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+		}
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	}
+	const target = "example.com:443"
+
+	if res, ok := p.Check(context.Background(), target, ""); ok || res.Cause != "refused" {
+		t.Fatalf("first check = %+v, %v; want refused", res, ok)
+	}
+	now = now.Add(30 * time.Second)
+	res, ok := p.Check(context.Background(), target, "")
+	if ok || probes != 1 {
+		t.Fatalf("within TTL: ok=%v after %d probes, want cached down", ok, probes)
+	}
+	if age := p.Age(res); age != 30*time.Second {
+		t.Errorf("probe age = %v, want 30s", age)
+	}
+
+	refuse = false
+	now = now.Add(31 * time.Second)
+	if _, ok := p.Check(context.Background(), target, ""); !ok || probes != 2 {
+		t.Fatalf("after TTL: ok=%v after %d probes, want a new probe finding it up", ok, probes)
+	}
+
+	// A failed real dial marks the target down without a probe.
+	p.Record(target, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED})
+	if _, ok := p.Check(context.Background(), target, ""); ok || probes != 2 {
+		t.Errorf("after a failed dial: ok=%v after %d probes, want cached down", ok, probes)
+	}
+}
+
+// TestPreflightOptOutHeader checks that a DTN submission to a down target is
+// refused at once with UPSTREAM_UNREACHABLE, unless it sends
+// X-Latency-Space-Preflight: off, when it is queued as before.
+func TestPreflightOptOutHeader(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(time.Hour)
+	s.preflight = NewPreflight(time.Minute, s.metrics)
+	body := fmt.Sprintf(`{"url":"http://%s/","method":"GET"}`, closedPort(t))
+
+	send := func(header string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "http://x/dtn/send", strings.NewReader(body))
+		req.Host = "mars.latency.space"
+		if header != "" {
+			req.Header.Set(preflightHeader, header)
+		}
+		rec := httptest.NewRecorder()
+		s.handleDTN(rec, req)
+		var out map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out
+	}
+
+	code, out := send("")
+	if code != http.StatusBadGateway || out["code"] != preflightUnreachableCode || out["cause"] != "refused" {
+		t.Fatalf("probed send = %d %v, want 502 %s", code, out, preflightUnreachableCode)
+	}
+	if _, ok := out["probeAgeSeconds"].(float64); !ok {
+		t.Errorf("no probeAgeSeconds in %v", out)
+	}
+
+	code, out = send("off")
+	if code != http.StatusAccepted {
+		t.Fatalf("opted-out send = %d %v, want 202", code, out)
+	}
+}
@@ -0,0 +1,348 @@
+// proxyproto.go - PROXY protocol (v1 text and v2 binary) on the listeners.
+//
+// Behind nginx or HAProxy every connection's RemoteAddr is the load
+// balancer, which defeats per-IP rate limiting, the recent-requests log and
+// any address-based body detection. -proxy-protocol (SOCKS) and
+// -proxy-protocol-http (HTTP/HTTPS) wrap the listeners so each connection
+// must begin with a PROXY header; RemoteAddr then reports the original
+// client. A connection without a valid header is closed: accepting it
+// would let anyone who can reach the port directly claim the balancer's
+// (trusted) address.
+//
+// The header is parsed lazily on the connection's first Read or RemoteAddr,
+// i.e. in the per-connection goroutine, so a slow client can't stall Accept.
+//
+// For HTTP, X-Forwarded-For, Forwarded and X-Forwarded-Host are honoured only
+// when the peer is in -trusted-proxies; from anyone else they are trivially
+// spoofed. A SOCKS connection arriving straight from a trusted proxy carries
+// no client address at all, so without -proxy-protocol it is refused rather
+// than silently given the default body.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long a client may take to send its header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errNoProxyHeader = errors.New("missing PROXY protocol header")
+
+// proxyListener wraps accepted connections in proxyConn.
+type proxyListener struct {
+	net.Listener
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newProxyConn(c), nil
+}
+
+// proxyConn is a connection whose RemoteAddr is taken from its PROXY header.
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr // nil for LOCAL/UNKNOWN headers: use the real peer
+	err    error
+}
+
+func newProxyConn(c net.Conn) *proxyConn {
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}
+}
+
+// init reads the header once. On failure the connection is closed and every
+// Read returns the error.
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			log.Printf("PROXY protocol: rejecting connection from %s: %v", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+// CloseWrite half-closes the underlying connection, if it can be.
+func (c *proxyConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the original client address from the PROXY header.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader parses a v1 or v2 PROXY header. It returns the source
+// address, or nil for headers that carry none (v1 UNKNOWN, v2 LOCAL, or a
+// non-TCP v2 family).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// Fail fast on the first byte so a client speaking SOCKS or HTTP directly
+	// isn't left waiting for the header timeout.
+	first, err := r.Peek(1)
+	if err != nil || (first[0] != 'P' && first[0] != proxyV2Signature[0]) {
+		return nil, errNoProxyHeader
+	}
+	peek, err := r.Peek(len(proxyV2Signature))
+	if err != nil && len(peek) < 6 {
+		return nil, errNoProxyHeader
+	}
+	if bytes.Equal(peek, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if bytes.HasPrefix(peek, []byte("PROXY ")) {
+		return readProxyV1(r)
+	}
+	return nil, errNoProxyHeader
+}
+
+// readProxyV1 parses "PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	// A v1 header is at most 107 bytes including the CRLF.
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("PROXY v1: %v", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY v1: header too long or not CRLF terminated")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("PROXY v1: malformed header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("PROXY v1: bad source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("PROXY v1: bad source port %q", fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 parses the binary v2 header.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("PROXY v2: %v", err)
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("PROXY v2: unsupported version %d", verCmd>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("PROXY v2: %v", err)
+	}
+	switch verCmd & 0x0F {
+	case 0x0: // LOCAL: the balancer's own connection (health checks)
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("PROXY v2: unsupported command %d", verCmd&0x0F)
+	}
+	switch fam {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("PROXY v2: short IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]).To16(), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("PROXY v2: short IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil // UNSPEC, UDP or unix: no usable TCP source
+}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs (or bare IPs),
+// as -trusted-proxies and -admin-cidrs take.
+func parseTrustedProxies(spec string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q: not an IP or CIDR", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", entry, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy reports whether ip falls in one of the trusted networks.
+func isTrustedProxy(trusted []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(strings.Trim(ip, "[]"))
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestClientIP returns the client IP for an HTTP request. The peer address
+// is used unless it is a trusted proxy, in which case the forwarding headers
+// are walked from the nearest hop outward and the first untrusted address
+// wins. Forwarded (RFC 7239) takes precedence over X-Forwarded-For.
+func (s *Server) requestClientIP(r *http.Request) string {
+	ip := clientIP(r.RemoteAddr)
+	if !isTrustedProxy(s.trustedProxies, ip) {
+		if r.Header.Get("Forwarded") != "" || r.Header.Get("X-Forwarded-For") != "" {
+			debugf("HTTP client %s from the peer address; forwarding headers from an untrusted peer ignored", ip)
+		}
+		return ip
+	}
+	peer, source := ip, "Forwarded"
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		source = "X-Forwarded-For"
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			for _, h := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(h))
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if net.ParseIP(hop) == nil {
+			break // obfuscated or garbage: stop trusting the chain here
+		}
+		ip = hop
+		if !isTrustedProxy(s.trustedProxies, hop) {
+			break
+		}
+	}
+	if ip == peer {
+		source = "the peer address"
+	}
+	debugf("HTTP client %s from %s (trusted peer %s)", ip, source, peer)
+	return ip
+}
+
+// requestHost returns the host an HTTP request was addressed to. Some nginx
+// configurations rewrite Host to the upstream's name, so when the peer is a
+// trusted proxy the first X-Forwarded-Host value (the one the client sent)
+// wins. From any other peer the header is ignored.
+func (s *Server) requestHost(r *http.Request) string {
+	forwarded := r.Header.Get("X-Forwarded-Host")
+	if forwarded == "" {
+		return r.Host
+	}
+	peer := clientIP(r.RemoteAddr)
+	if !isTrustedProxy(s.trustedProxies, peer) {
+		debugf("HTTP host %q from Host; X-Forwarded-Host from untrusted peer %s ignored", r.Host, peer)
+		return r.Host
+	}
+	first, _, _ := strings.Cut(forwarded, ",")
+	if host := strings.TrimSpace(first); host != "" {
+		debugf("HTTP host %q from X-Forwarded-Host (trusted peer %s, Host %q)", host, peer, r.Host)
+		return host
+	}
+	return r.Host
+}
+
+// withForwardedHost serves next with r.Host set to requestHost, so body
+// detection, the access log and every handler see the host the client asked
+// for.
+func (s *Server) withForwardedHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if host := s.requestHost(r); host != r.Host {
+			r = r.WithContext(r.Context()) // shallow copy: don't edit the server's request
+			r.Host = host
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor extracts the for= addresses from Forwarded header values, in
+// order, stripping quotes, IPv6 brackets and ports.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(k, "for") {
+					continue
+				}
+				val = strings.Trim(val, `"`)
+				if strings.HasPrefix(val, "[") {
+					if end := strings.Index(val, "]"); end > 0 {
+						val = val[1:end]
+					}
+				} else if host, _, err := net.SplitHostPort(val); err == nil {
+					val = host
+				}
+				hops = append(hops, val)
+			}
+		}
+	}
+	return hops
+}
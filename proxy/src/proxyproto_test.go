@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// proxyV2Header builds a v2 PROXY header for a TCP source and destination.
+func proxyV2Header(cmd byte, src, dst *net.TCPAddr) []byte {
+	var fam byte
+	var addrs []byte
+	if src.IP.To4() != nil {
+		fam = 0x11
+		addrs = append(append(addrs, src.IP.To4()...), dst.IP.To4()...)
+	} else {
+		fam = 0x21
+		addrs = append(append(addrs, src.IP.To16()...), dst.IP.To16()...)
+	}
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(src.Port))
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(dst.Port))
+	h := append([]byte{}, proxyV2Signature...)
+	h = append(h, 0x20|cmd, fam)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(addrs)))
+	return append(h, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4 := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51000}
+	v6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4711}
+	lb := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1080}
+	lb6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::ff"), Port: 1080}
+
+	cases := []struct {
+		name    string
+		header  string
+		want    string // "" = no source address
+		wantErr bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 203.0.113.7 10.0.0.1 51000 1080\r\n", "203.0.113.7:51000", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::ff 4711 1080\r\n", "[2001:db8::1]:4711", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "", false},
+		{"v2 tcp4", string(proxyV2Header(0x1, v4, lb)), "203.0.113.7:51000", false},
+		{"v2 tcp6", string(proxyV2Header(0x1, v6, lb6)), "[2001:db8::1]:4711", false},
+		{"v2 local", string(proxyV2Header(0x0, v4, lb)), "", false},
+		{"no header", "\x05\x01\x00", "", true},
+		{"http request", "GET / HTTP/1.1\r\n", "", true},
+		{"v1 wrong family", "PROXY TCP4 2001:db8::1 10.0.0.1 4711 1080\r\n", "", true},
+		{"v1 bad port", "PROXY TCP4 203.0.113.7 10.0.0.1 99999 1080\r\n", "", true},
+		{"v1 no crlf", "PROXY TCP4 203.0.113.7 10.0.0.1 51000 1080\n", "", true},
+		{"v1 too long", "PROXY " + strings.Repeat("x", 200) + "\r\n", "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The header is followed by payload, which must survive intact.
+			r := bufio.NewReader(strings.NewReader(tc.header + "payload"))
+			addr, err := readProxyHeader(r)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tc.want {
+				t.Errorf("source = %q, want %q", got, tc.want)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+				t.Errorf("payload after header = %q", rest)
+			}
+		})
+	}
+}
+
+// startProxiedSOCKS runs s.serveSOCKSConn behind a PROXY protocol listener.
+func startProxiedSOCKS(t *testing.T, s *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveSOCKSConn(newProxyConn(conn))
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// socksGreeting connects to addr, sends header then a SOCKS5 greeting, and
+// reports whether the server answered it.
+func socksGreeting(t *testing.T, addr, header string) (net.Conn, bool) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(header + "\x05\x01\x00")); err != nil {
+		return conn, false
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return conn, false
+	}
+	return conn, reply[0] == 0x05
+}
+
+// TestProxyProtocolSOCKSLimiter checks that the per-IP limiter sees the address
+// from the PROXY header rather than the balancer's.
+func TestProxyProtocolSOCKSLimiter(t *testing.T) {
+	s := &Server{
+		security: NewSecurityValidator(),
+		metrics:  NewRecordingMetrics(),
+		limiter:  NewRateLimiter(0, 0, 1 /* maxPerIP */, 100),
+	}
+	addr := startProxiedSOCKS(t, s)
+
+	// All three connections arrive from 127.0.0.1 (the "balancer").
+	if _, ok := socksGreeting(t, addr, "PROXY TCP4 203.0.113.7 10.0.0.1 50000 1080\r\n"); !ok {
+		t.Fatal("first client should be admitted")
+	}
+	if _, ok := socksGreeting(t, addr, "PROXY TCP4 203.0.113.7 10.0.0.1 50001 1080\r\n"); ok {
+		t.Error("second concurrent connection from 203.0.113.7 should be rejected")
+	}
+	v2 := proxyV2Header(0x1, &net.TCPAddr{IP: net.ParseIP("198.51.100.9"), Port: 50002},
+		&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1080})
+	if _, ok := socksGreeting(t, addr, string(v2)); !ok {
+		t.Error("a different proxied client should be admitted")
+	}
+}
+
+func TestProxyProtocolRejectsMissingHeader(t *testing.T) {
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	addr := startProxiedSOCKS(t, s)
+	if _, ok := socksGreeting(t, addr, ""); ok {
+		t.Error("a connection without a PROXY header should be closed")
+	}
+}
+
+// TestProxyProtocolHTTP serves HTTP through the wrapped listener and checks the
+// handler sees the proxied client.
+func TestProxyProtocolHTTP(t *testing.T) {
+	s := &Server{}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, s.requestClientIP(r))
+	})}
+	go srv.Serve(proxyListener{ln})
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	v2 := proxyV2Header(0x1, &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 50000},
+		&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80})
+	fmt.Fprintf(conn, "%sGET / HTTP/1.1\r\nHost: mars.latency.space\r\nConnection: close\r\n\r\n", v2)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "203.0.113.7" {
+		t.Errorf("handler saw client %q, want 203.0.113.7", body)
+	}
+}
+
+func TestRequestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies("192.0.2.0/24, 10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{trustedProxies: trusted}
+
+	cases := []struct {
+		name   string
+		remote string
+		header string
+		value  string
+		want   string
+	}{
+		{"untrusted peer ignores XFF", "198.51.100.1:1234", "X-Forwarded-For", "203.0.113.7", "198.51.100.1"},
+		{"trusted peer", "192.0.2.1:1234", "X-Forwarded-For", "203.0.113.7", "203.0.113.7"},
+		{"trusted chain", "192.0.2.1:1234", "X-Forwarded-For", "203.0.113.7, 10.0.0.5", "203.0.113.7"},
+		{"spoofed leftmost", "192.0.2.1:1234", "X-Forwarded-For", "1.1.1.1, 203.0.113.7", "203.0.113.7"},
+		{"garbage hop", "192.0.2.1:1234", "X-Forwarded-For", "bogus, 203.0.113.7", "203.0.113.7"},
+		{"forwarded v6", "192.0.2.1:1234", "Forwarded", `for="[2001:db8::1]:4711";proto=https`, "2001:db8::1"},
+		{"forwarded v4", "192.0.2.1:1234", "Forwarded", "for=203.0.113.7, for=10.0.0.5", "203.0.113.7"},
+		{"no header", "192.0.2.1:1234", "", "", "192.0.2.1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://x/", nil)
+			r.RemoteAddr = tc.remote
+			if tc.header != "" {
+				r.Header.Set(tc.header, tc.value)
+			}
+			if got := s.requestClientIP(r); got != tc.want {
+				t.Errorf("requestClientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("invalid CIDR should be rejected")
+	}
+}
+
+// TestDTNLimiterUsesForwardedIP checks that HTTP rate limiting keys on the
+// forwarded client when the peer is a trusted proxy.
+func TestDTNLimiterUsesForwardedIP(t *testing.T) {
+	s := newDTNTestServer(t)
+	s.trustedProxies, _ = parseTrustedProxies("192.0.2.0/24") // httptest's RemoteAddr
+	s.limiter = NewRateLimiter(1 /* per min */, 1 /* burst */, 0, 0)
+
+	send := func(client string) int {
+		req := httptest.NewRequest(http.MethodPost, "http://x/dtn/send", strings.NewReader("not json"))
+		req.Header.Set("X-Forwarded-For", client)
+		rec := httptest.NewRecorder()
+		s.handleDTN(rec, req)
+		return rec.Code
+	}
+	if code := send("203.0.113.7"); code == http.StatusTooManyRequests {
+		t.Fatal("first request should pass the limiter")
+	}
+	if code := send("203.0.113.7"); code != http.StatusTooManyRequests {
+		t.Errorf("second request from the same client: got %d, want 429", code)
+	}
+	if code := send("198.51.100.9"); code == http.StatusTooManyRequests {
+		t.Error("a different forwarded client should have its own bucket")
+	}
+}
+
+// TestForwardedHost simulates nginx rewriting Host to the upstream name and
+// checks body selection and the rate-limit identity follow the forwarding
+// headers from a trusted peer only.
+func TestForwardedHost(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	trusted, _ := parseTrustedProxies("192.0.2.0/24")
+	s := &Server{trustedProxies: trusted}
+
+	var host, ip string
+	h := s.withForwardedHost(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, ip = r.Host, s.requestClientIP(r)
+	}))
+	cases := []struct {
+		name, remote, xfh      string
+		wantBody, wantClientIP string
+	}{
+		{"trusted peer", "192.0.2.1:1234", "jupiter.latency.space", "Jupiter", "203.0.113.7"},
+		{"trusted chain", "192.0.2.1:1234", "jupiter.latency.space, proxy.internal", "Jupiter", "203.0.113.7"},
+		{"spoofed by untrusted peer", "198.51.100.1:1234", "jupiter.latency.space", "Mars", "198.51.100.1"},
+		{"trusted peer without header", "192.0.2.1:1234", "", "Mars", "203.0.113.7"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://mars.latency.space/", nil)
+			r.RemoteAddr = tc.remote
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			if tc.xfh != "" {
+				r.Header.Set("X-Forwarded-Host", tc.xfh)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if body := s.resolveCelestialHost(getCelestialObjects(), host); body != tc.wantBody {
+				t.Errorf("host %q selects %s, want %s", host, body, tc.wantBody)
+			}
+			if ip != tc.wantClientIP {
+				t.Errorf("rate limited as %s, want %s", ip, tc.wantClientIP)
+			}
+			if r.Host != "mars.latency.space" {
+				t.Errorf("the server's request was modified: Host %q", r.Host)
+			}
+		})
+	}
+}
+
+// TestSOCKSFromTrustedProxy checks a SOCKS connection straight from a
+// trusted balancer is refused instead of silently getting Mars.
+func TestSOCKSFromTrustedProxy(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	trusted, _ := parseTrustedProxies("192.0.2.0/24")
+	h := &SOCKSHandler{trustedProxies: trusted}
+	if _, err := h.getCelestialBodyFromConn(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1080}); !errors.Is(err, errHiddenClient) {
+		t.Errorf("trusted peer: %v, want errHiddenClient", err)
+	}
+	if body, err := h.getCelestialBodyFromConn(&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1080}); err != nil || body != "Mars" {
+		t.Errorf("direct client: %q, %v, want the Mars default", body, err)
+	}
+	h.fixedCelestialBody = "Europa"
+	if body, err := h.getCelestialBodyFromConn(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1080}); err != nil || body != "Europa" {
+		t.Errorf("fixed body behind a proxy: %q, %v", body, err)
+	}
+
+	// End to end: the CONNECT fails and nothing is relayed.
+	echo := startEchoServer(t)
+	s := &Server{security: newTestSecurity(), metrics: NewRecordingMetrics(),
+		limiter: NewRateLimiter(600, 100, 0, 0), timing: fixedLatency(0)}
+	s.trustedProxies, _ = parseTrustedProxies("127.0.0.0/8")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if conn, _, err := client.Dial(ctx, startTestSOCKS(t, s), echo.String()); err == nil {
+		conn.Close()
+		t.Error("CONNECT through a trusted proxy without -proxy-protocol succeeded")
+	}
+}
@@ -19,6 +19,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"strconv"
 	"sync"
@@ -134,6 +135,41 @@ func (r *RateLimiter) Acquire(ip string) (func(), error) {
 	}, nil
 }
 
+// Remaining is how many more connections ip could open right now before a
+// limit refuses one: the smaller of its whole rate tokens and its free
+// per-IP and global slots. -1 means no limit applies.
+func (r *RateLimiter) Remaining(ip string) int {
+	if r == nil {
+		return -1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remaining := -1
+	lower := func(n int) {
+		if n < 0 {
+			n = 0
+		}
+		if remaining < 0 || n < remaining {
+			remaining = n
+		}
+	}
+	if r.ratePerSec > 0 {
+		tokens := r.burst
+		if b, ok := r.buckets[ip]; ok {
+			tokens = math.Min(r.burst, b.tokens+time.Since(b.lastRefill).Seconds()*r.ratePerSec)
+		}
+		lower(int(tokens))
+	}
+	if r.maxPerIP > 0 {
+		lower(r.maxPerIP - r.perIP[ip])
+	}
+	if r.maxTotal > 0 {
+		lower(r.maxTotal - r.total)
+	}
+	return remaining
+}
+
 // StartCleanup runs a background janitor that prunes idle per-IP buckets until
 // stop is closed. Call once from the server; tests may omit it.
 func (r *RateLimiter) StartCleanup(stop <-chan struct{}) {
@@ -73,3 +73,28 @@ func TestRateLimiterNilAndDisabled(t *testing.T) {
 		}
 	}
 }
+
+func TestRateLimiterRemaining(t *testing.T) {
+	var nilLimiter *RateLimiter
+	if got := nilLimiter.Remaining("x"); got != -1 {
+		t.Errorf("nil limiter: %d, want -1", got)
+	}
+	if got := NewRateLimiter(0, 0, 0, 0).Remaining("x"); got != -1 {
+		t.Errorf("disabled limiter: %d, want -1", got)
+	}
+
+	rl := NewRateLimiter(1 /* per min */, 3 /* burst */, 2 /* maxPerIP */, 0)
+	if got := rl.Remaining("1.2.3.4"); got != 2 {
+		t.Errorf("fresh client: %d, want 2 (per-IP cap below the burst)", got)
+	}
+	rel, _ := rl.Acquire("1.2.3.4")
+	if got := rl.Remaining("1.2.3.4"); got != 1 {
+		t.Errorf("one open: %d, want 1", got)
+	}
+	rel()
+	rl.Acquire("1.2.3.4")
+	rl.Acquire("1.2.3.4")
+	if got := rl.Remaining("1.2.3.4"); got != 0 {
+		t.Errorf("burst spent: %d, want 0", got)
+	}
+}
@@ -0,0 +1,156 @@
+// recent.go - in-memory ring buffer of recent proxy transactions.
+//
+// When something misbehaves in production the only other forensic tool is
+// grepping stdout. Each proxied transaction (SOCKS CONNECT/UDP ASSOCIATE, DTN
+// submission) appends one entry here; /_debug/recent renders the buffer with
+// simple filters. Writes take a single short mutex and copy a small struct, so
+// recording adds nothing measurable to the relay hot path.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transaction outcomes recorded in the ring.
+const (
+	outcomeOK        = "ok"
+	outcomeOccluded  = "occluded"
+	outcomeNoContact = "no_contact" // outside the body's DSN contact windows
+	outcomeNoStation = "no_station" // below every ground station's horizon
+	outcomeDenied    = "denied"
+	outcomeError     = "error"
+)
+
+// RecentTransaction is one entry in the recent-transactions ring.
+type RecentTransaction struct {
+	Time     time.Time     `json:"time"`
+	ClientIP string        `json:"clientIp"`
+	Protocol string        `json:"protocol"` // "socks", "socks-udp", "dtn"
+	Body     string        `json:"body"`
+	Target   string        `json:"target,omitempty"`
+	Latency  time.Duration `json:"latencyNs"`
+	Upstream time.Duration `json:"upstreamNs,omitempty"` // connecting to the destination
+	BytesIn  int64         `json:"bytesIn"`              // received from the client
+	BytesOut int64         `json:"bytesOut"`             // sent back to the client
+	Duration time.Duration `json:"durationNs"`
+	Outcome  string        `json:"outcome"`
+}
+
+// RecentFilter selects entries from the ring. Zero values match everything.
+type RecentFilter struct {
+	Body    string
+	Outcome string
+	Limit   int
+}
+
+// RecentLog is a fixed-size ring of recent transactions. A nil *RecentLog is a
+// valid no-op recorder, so handlers built without one (tests) need no checks.
+type RecentLog struct {
+	anonymize bool
+
+	mu      sync.Mutex
+	entries []RecentTransaction
+	next    int  // slot the next Record writes
+	full    bool // whether the ring has wrapped at least once
+}
+
+// NewRecentLog creates a ring holding up to size entries. When anonymize is
+// set, client IPs are truncated (last IPv4 octet / last 80 IPv6 bits zeroed)
+// before they are stored.
+func NewRecentLog(size int, anonymize bool) *RecentLog {
+	if size <= 0 {
+		size = 1
+	}
+	return &RecentLog{anonymize: anonymize, entries: make([]RecentTransaction, size)}
+}
+
+// Record appends tx, overwriting the oldest entry once the ring is full.
+func (l *RecentLog) Record(tx RecentTransaction) {
+	if l == nil {
+		return
+	}
+	if l.anonymize {
+		tx.ClientIP = anonymizeIP(tx.ClientIP)
+	}
+	l.mu.Lock()
+	l.entries[l.next] = tx
+	l.next++
+	if l.next == len(l.entries) {
+		l.next = 0
+		l.full = true
+	}
+	l.mu.Unlock()
+}
+
+// Snapshot returns matching entries, newest first.
+func (l *RecentLog) Snapshot(f RecentFilter) []RecentTransaction {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	ordered := make([]RecentTransaction, 0, n)
+	for i := 1; i <= n; i++ {
+		idx := (l.next - i + len(l.entries)) % len(l.entries)
+		ordered = append(ordered, l.entries[idx])
+	}
+	l.mu.Unlock()
+
+	out := ordered[:0]
+	for _, tx := range ordered {
+		if f.Body != "" && !strings.EqualFold(tx.Body, f.Body) &&
+			!strings.EqualFold(FormatDomainName(tx.Body), f.Body) {
+			continue
+		}
+		if f.Outcome != "" && !strings.EqualFold(tx.Outcome, f.Outcome) {
+			continue
+		}
+		out = append(out, tx)
+		if f.Limit > 0 && len(out) == f.Limit {
+			break
+		}
+	}
+	return out
+}
+
+// anonymizeIP strips host detail from an IP: the last octet of an IPv4
+// address, or everything past the /48 of an IPv6 address. Non-IP input is
+// returned unchanged.
+func anonymizeIP(ip string) string {
+	parsed := net.ParseIP(strings.Trim(ip, "[]"))
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// writeRecentText renders entries as a fixed-width table.
+func writeRecentText(w io.Writer, entries []RecentTransaction) {
+	fmt.Fprintf(w, "%-20s | %-15s | %-9s | %-12s | %-28s | %-10s | %10s | %10s | %-10s | %s\n",
+		"Time", "Client", "Protocol", "Body", "Target", "Latency", "Bytes In", "Bytes Out", "Duration", "Outcome")
+	fmt.Fprintln(w, strings.Repeat("-", 160))
+	for _, tx := range entries {
+		fmt.Fprintf(w, "%-20s | %-15s | %-9s | %-12s | %-28s | %-10s | %10d | %10d | %-10s | %s\n",
+			tx.Time.UTC().Format(time.RFC3339),
+			tx.ClientIP,
+			tx.Protocol,
+			tx.Body,
+			tx.Target,
+			tx.Latency.Round(time.Millisecond).String(),
+			tx.BytesIn,
+			tx.BytesOut,
+			tx.Duration.Round(time.Millisecond).String(),
+			tx.Outcome)
+	}
+}
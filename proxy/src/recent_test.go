@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestRecentLogWraparound(t *testing.T) {
+	l := NewRecentLog(3, false)
+	for i := 0; i < 5; i++ {
+		l.Record(RecentTransaction{Target: fmt.Sprintf("t%d", i)})
+	}
+	got := l.Snapshot(RecentFilter{})
+	if len(got) != 3 {
+		t.Fatalf("expected ring capped at 3 entries, got %d", len(got))
+	}
+	// Newest first; t0 and t1 were overwritten.
+	for i, want := range []string{"t4", "t3", "t2"} {
+		if got[i].Target != want {
+			t.Errorf("entry %d: expected %s, got %s", i, want, got[i].Target)
+		}
+	}
+}
+
+func TestRecentLogFilter(t *testing.T) {
+	l := NewRecentLog(10, false)
+	l.Record(RecentTransaction{Body: "Mars", Outcome: outcomeOK})
+	l.Record(RecentTransaction{Body: "Mars", Outcome: outcomeDenied})
+	l.Record(RecentTransaction{Body: "Voyager 1", Outcome: outcomeDenied})
+	l.Record(RecentTransaction{Body: "Mars", Outcome: outcomeDenied})
+
+	if got := l.Snapshot(RecentFilter{Body: "mars"}); len(got) != 3 {
+		t.Errorf("body filter: expected 3, got %d", len(got))
+	}
+	if got := l.Snapshot(RecentFilter{Body: "voyager-1"}); len(got) != 1 {
+		t.Errorf("body filter by slug: expected 1, got %d", len(got))
+	}
+	if got := l.Snapshot(RecentFilter{Body: "mars", Outcome: "denied"}); len(got) != 2 {
+		t.Errorf("body+outcome filter: expected 2, got %d", len(got))
+	}
+	if got := l.Snapshot(RecentFilter{Outcome: "denied", Limit: 1}); len(got) != 1 || got[0].Body != "Mars" {
+		t.Errorf("limit: expected the newest denied entry only, got %+v", got)
+	}
+
+	var nilLog *RecentLog
+	nilLog.Record(RecentTransaction{}) // must not panic
+	if got := nilLog.Snapshot(RecentFilter{}); got != nil {
+		t.Errorf("nil log should snapshot to nil, got %v", got)
+	}
+}
+
+func TestRecentLogAnonymize(t *testing.T) {
+	plain := NewRecentLog(2, false)
+	plain.Record(RecentTransaction{ClientIP: "203.0.113.77"})
+	if got := plain.Snapshot(RecentFilter{})[0].ClientIP; got != "203.0.113.77" {
+		t.Errorf("without -anonymize-ips the IP must be kept, got %s", got)
+	}
+
+	anon := NewRecentLog(2, true)
+	anon.Record(RecentTransaction{ClientIP: "203.0.113.77"})
+	anon.Record(RecentTransaction{ClientIP: "2001:db8:1234:5678::abcd"})
+	got := anon.Snapshot(RecentFilter{})
+	if got[1].ClientIP != "203.0.113.0" {
+		t.Errorf("IPv4 last octet not stripped: %s", got[1].ClientIP)
+	}
+	if got[0].ClientIP != "2001:db8:1234::" {
+		t.Errorf("IPv6 host bits not stripped: %s", got[0].ClientIP)
+	}
+}
+
+func TestDebugRecentEndpoint(t *testing.T) {
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), recent: NewRecentLog(10, false)}
+	s.recent.Record(RecentTransaction{Body: "Mars", Protocol: "socks", Outcome: outcomeDenied, Target: "evil.example:22"})
+	s.recent.Record(RecentTransaction{Body: "Moon", Protocol: "socks", Outcome: outcomeOK})
+
+	get := func(url, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, req)
+		return rec
+	}
+
+	// Hidden entirely while no admin token is configured.
+	if rec := get("http://latency.space/_debug/recent", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with ADMIN_TOKEN unset, got %d", rec.Code)
+	}
+
+	s.adminToken = "sekrit"
+	if rec := get("http://latency.space/_debug/recent", "wrong"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with a bad token, got %d", rec.Code)
+	}
+
+	rec := get("http://latency.space/_debug/recent?body=mars&outcome=denied", "sekrit")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var entries []RecentTransaction
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("response not JSON: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Target != "evil.example:22" {
+		t.Errorf("filtered JSON mismatch: %+v", entries)
+	}
+
+	rec = get("http://latency.space/_debug/recent?format=text", "sekrit")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "evil.example:22") {
+		t.Errorf("text format failed (%d): %s", rec.Code, rec.Body.String())
+	}
+	// The token isn't taken from the query, which ends up in logs.
+	if rec := get("http://latency.space/_debug/recent?token=sekrit", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("?token= accepted: %d", rec.Code)
+	}
+}
+
+// TestSocksConnectRecordsRecent checks a denied CONNECT lands in the ring.
+func TestSocksConnectRecordsRecent(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	server, client := net.Pipe()
+	defer client.Close()
+
+	h := NewSOCKSHandler(server, NewSecurityValidator(), NewRecordingMetrics(), "Mars", nil)
+	h.recent = NewRecentLog(4, false)
+	done := make(chan struct{})
+	go func() {
+		h.Handle()
+		close(done)
+	}()
+
+	// Greeting, then CONNECT to an IP literal (always denied in production).
+	if _, err := client.Write([]byte{SOCKS5_VERSION, 1, SOCKS5_NO_AUTH}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	req := []byte{SOCKS5_VERSION, SOCKS5_CMD_CONNECT, 0x00, SOCKS5_ADDR_IPV4, 8, 8, 8, 8, 0x01, 0xBB}
+	if _, err := client.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not finish")
+	}
+
+	got := h.recent.Snapshot(RecentFilter{})
+	if len(got) != 1 {
+		t.Fatalf("expected one recorded transaction, got %d", len(got))
+	}
+	if got[0].Outcome != outcomeDenied || got[0].Target != "8.8.8.8:443" || got[0].Body != "Mars" || got[0].Protocol != "socks" {
+		t.Errorf("unexpected entry: %+v", got[0])
+	}
+}
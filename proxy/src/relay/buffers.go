@@ -0,0 +1,67 @@
+package relay
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// BufferPool hands out []byte of a fixed size. Pointers are pooled so Put
+// doesn't allocate. Slow links keep buffers for the whole light delay, so
+// reusing them saves a lot of garbage.
+type BufferPool struct {
+	size   int
+	pool   sync.Pool
+	gets   atomic.Int64 // buffers handed out
+	allocs atomic.Int64 // of which freshly allocated
+	inUse  atomic.Int64 // handed out and not yet returned
+}
+
+// NewBufferPool returns a pool of size-byte buffers.
+func NewBufferPool(size int) *BufferPool {
+	p := &BufferPool{size: size}
+	p.pool.New = func() any {
+		p.allocs.Add(1)
+		b := make([]byte, size)
+		return &b
+	}
+	return p
+}
+
+// Size is the length of the pool's buffers.
+func (p *BufferPool) Size() int { return p.size }
+
+// Get returns a buffer of p.Size() bytes; return it with Put.
+func (p *BufferPool) Get() *[]byte {
+	p.gets.Add(1)
+	p.inUse.Add(1)
+	return p.pool.Get().(*[]byte)
+}
+
+// Put returns b to the pool. b must not be used afterwards.
+func (p *BufferPool) Put(b *[]byte) {
+	p.inUse.Add(-1)
+	*b = (*b)[:p.size]
+	p.pool.Put(b)
+}
+
+// PoolStats are a pool's counters.
+type PoolStats struct {
+	Gets   int64 // buffers handed out
+	Allocs int64 // of which freshly allocated
+	InUse  int64 // handed out and not yet returned
+}
+
+// Stats returns the pool's counters.
+func (p *BufferPool) Stats() PoolStats {
+	return PoolStats{Gets: p.gets.Load(), Allocs: p.allocs.Load(), InUse: p.inUse.Load()}
+}
+
+// ChunkBuffers backs stream copies that don't name a pool of their own.
+var ChunkBuffers = NewBufferPool(DefaultChunkSize)
+
+// queued counts chunks read but not yet released, across all pipes.
+var queued atomic.Int64
+
+// Queued returns the number of chunks waiting out the latency in stream
+// copies right now.
+func Queued() int64 { return queued.Load() }
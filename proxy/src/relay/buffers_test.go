@@ -0,0 +1,46 @@
+package relay
+
+import "testing"
+
+func TestBufferPool(t *testing.T) {
+	p := NewBufferPool(1024)
+	a := p.Get()
+	if len(*a) != 1024 {
+		t.Fatalf("got a %d byte buffer, want 1024", len(*a))
+	}
+	*a = (*a)[:10]
+	p.Put(a)
+	b := p.Get()
+	if len(*b) != 1024 {
+		t.Errorf("reused buffer is %d bytes, want it restored to 1024", len(*b))
+	}
+	if st := p.Stats(); st.Gets != 2 || st.InUse != 1 || st.Allocs < 1 {
+		t.Errorf("gets %d, in use %d, allocs %d", st.Gets, st.InUse, st.Allocs)
+	}
+	p.Put(b)
+	if st := p.Stats(); st.InUse != 0 {
+		t.Errorf("in use %d after returning everything", st.InUse)
+	}
+}
+
+// BenchmarkRelayBuffer compares taking a chunk buffer from the pool with
+// allocating one per read.
+func BenchmarkRelayBuffer(b *testing.B) {
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := ChunkBuffers.Get()
+			(*buf)[0] = 1
+			ChunkBuffers.Put(buf)
+		}
+	})
+	b.Run("make", func(b *testing.B) {
+		b.ReportAllocs()
+		var sink []byte
+		for i := 0; i < b.N; i++ {
+			sink = make([]byte, DefaultChunkSize)
+			sink[0] = 1
+		}
+		_ = sink
+	})
+}
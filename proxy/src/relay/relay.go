@@ -0,0 +1,423 @@
+// Package relay carries proxied traffic across a simulated link.
+//
+// Light-speed delay shifts every byte in time by a constant; it does not
+// reduce throughput. An early SOCKS relay slept one full one-way latency
+// per 32KB chunk, so a Mars link (~12 min one-way) carried roughly 45
+// bytes/s and a TLS handshake could take over an hour. A Pipe's stream copy
+// instead timestamps chunks as they arrive and releases each one exactly
+// the latency later: throughput is preserved while every byte still arrives
+// late by the light-travel time.
+//
+// Light-speed delay does, though, throttle a protocol that waits for
+// acknowledgments. WithWindow models TCP's: a stream copy keeps at most a
+// window of unacknowledged data in flight, and each chunk is acknowledged
+// one round trip after it was sent, so throughput is capped at window/RTT
+// however fast the link - about 43 bytes/s for a 64KB window on a 25 minute
+// round trip to Mars.
+//
+// A Pipe is configured by options: the clock and latency, a bandwidth
+// limiter, a TCP window, the chunk size, a metrics sink, a link check and a
+// context. The primitives, CopyTCP for streams and RelayPacket for
+// datagrams (or SendPacket, which leaves the wait to a Scheduler), are where
+// a link model (bandwidth, jitter, occlusion) is implemented once for every
+// proxy path.
+package relay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultChunkSize is the most a stream copy reads at once.
+	DefaultChunkSize = 32 * 1024
+	// queueLen bounds buffered in-flight data per stream copy (queueLen *
+	// DefaultChunkSize = 512KB). When the queue is full the reader stalls,
+	// which acts as crude bandwidth backpressure.
+	queueLen = 16
+)
+
+// Direction is the way data moves through a pipe. Its value is the
+// "direction" metric label.
+type Direction string
+
+const (
+	ToTarget Direction = "to_target" // client to destination: the uplink
+	ToClient Direction = "to_client" // destination to client: the downlink
+)
+
+// Clock is the source of time and waits.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Limiter paces data to a bandwidth. WaitN blocks until n more bytes may
+// go, or ctx is done; golang.org/x/time/rate.Limiter satisfies it.
+type Limiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// Metrics receives the bytes a pipe has carried.
+type Metrics interface {
+	Bytes(dir Direction, n int)
+}
+
+// MetricsFunc adapts a function to Metrics.
+type MetricsFunc func(dir Direction, n int)
+
+func (f MetricsFunc) Bytes(dir Direction, n int) { f(dir, n) }
+
+// LinkCheck reports why data can't go in dir right now (occlusion, no
+// contact window), or nil if it can.
+type LinkCheck func(dir Direction) error
+
+// ErrShortWrite is io.ErrShortWrite: the destination took fewer bytes than
+// it was given without saying why.
+var ErrShortWrite = io.ErrShortWrite
+
+// Pipe is a simulated link between a client and a destination. The zero
+// value is not usable; build one with New.
+type Pipe struct {
+	ctx       context.Context
+	clock     Clock
+	latency   func() time.Duration
+	limiter   Limiter
+	metrics   Metrics
+	check     LinkCheck
+	buffers   *BufferPool
+	chunkSize int
+	window    int
+	dir       Direction
+}
+
+// Option configures a Pipe.
+type Option func(*Pipe)
+
+// New returns a pipe with no latency, limit, metrics or link check on the
+// system clock, configured by opts.
+func New(opts ...Option) *Pipe {
+	p := &Pipe{
+		ctx:     context.Background(),
+		clock:   systemClock{},
+		latency: func() time.Duration { return 0 },
+		buffers: ChunkBuffers,
+		dir:     ToTarget,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.chunkSize <= 0 || p.chunkSize > p.buffers.Size() {
+		p.chunkSize = p.buffers.Size()
+	}
+	return p
+}
+
+// WithContext ends the pipe's copies and waits when ctx is done. A deadline
+// on ctx is also set on connections that take one (see CopyTCP).
+func WithContext(ctx context.Context) Option { return func(p *Pipe) { p.ctx = ctx } }
+
+// WithClock sets the clock that timestamps and releases data.
+func WithClock(c Clock) Option { return func(p *Pipe) { p.clock = c } }
+
+// WithLatency sets a constant one-way latency.
+func WithLatency(d time.Duration) Option {
+	return func(p *Pipe) { p.latency = func() time.Duration { return d } }
+}
+
+// WithLatencyFunc sets a latency that is asked for again per chunk or
+// packet.
+func WithLatencyFunc(f func() time.Duration) Option { return func(p *Pipe) { p.latency = f } }
+
+// WithLimiter paces data through l before it enters the delay.
+func WithLimiter(l Limiter) Option { return func(p *Pipe) { p.limiter = l } }
+
+// WithMetrics reports carried bytes to m once they are delivered.
+func WithMetrics(m Metrics) Option { return func(p *Pipe) { p.metrics = m } }
+
+// WithLinkCheck refuses data the check says can't go.
+func WithLinkCheck(c LinkCheck) Option { return func(p *Pipe) { p.check = c } }
+
+// WithBuffers takes stream chunks from pool instead of ChunkBuffers.
+func WithBuffers(pool *BufferPool) Option { return func(p *Pipe) { p.buffers = pool } }
+
+// WithChunkSize caps how much a stream copy reads at once; it can't exceed
+// the buffer size.
+func WithChunkSize(n int) Option { return func(p *Pipe) { p.chunkSize = n } }
+
+// WithWindow caps a stream copy's unacknowledged data at n bytes, with each
+// chunk acknowledged one latency after it is delivered; 0 (the default)
+// leaves it uncapped.
+func WithWindow(n int) Option { return func(p *Pipe) { p.window = n } }
+
+// WithDirection sets the direction CopyTCP carries data in (default
+// ToTarget). Packets name their own.
+func WithDirection(dir Direction) Option { return func(p *Pipe) { p.dir = dir } }
+
+// chunk is data read from a stream, due for release at deliverAt.
+type chunk struct {
+	buf       *[]byte // pooled backing buffer
+	data      []byte  // the bytes read, within buf
+	deliverAt time.Time
+}
+
+// readDeadliner and writeDeadliner are connections that take a deadline.
+type readDeadliner interface{ SetReadDeadline(time.Time) error }
+type writeDeadliner interface{ SetWriteDeadline(time.Time) error }
+
+// CopyTCP copies src to dst in the pipe's direction, releasing each chunk
+// one latency after it was read. It returns the first error from either
+// side, the context, the link check or the limiter; io.EOF is reported as
+// nil. A short write without an error is ErrShortWrite.
+//
+// If the pipe's context has a deadline, it becomes src's read deadline and
+// dst's write deadline where they take one, so a copy blocked in the
+// kernel ends with it.
+func (p *Pipe) CopyTCP(dst io.Writer, src io.Reader) error {
+	ctx := p.ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		if r, ok := src.(readDeadliner); ok {
+			r.SetReadDeadline(deadline)
+		}
+		if w, ok := dst.(writeDeadliner); ok {
+			w.SetWriteDeadline(deadline)
+		}
+	}
+
+	queue := make(chan chunk, queueLen)
+	readErr := make(chan error, 1)
+	var win *window
+	if p.window > 0 {
+		win = newWindow(p.window)
+		defer close(win.done) // acknowledgments still due are dropped
+	}
+
+	go func() {
+		defer close(queue)
+		for {
+			size := p.chunkSize
+			if win != nil {
+				room, err := win.room(ctx)
+				if err != nil {
+					readErr <- err
+					return
+				}
+				size = min(size, room)
+			}
+			buf := p.buffers.Get()
+			n, err := src.Read((*buf)[:size])
+			win.send(n)
+			if n > 0 {
+				if p.limiter != nil {
+					if lerr := p.limiter.WaitN(ctx, n); lerr != nil {
+						p.buffers.Put(buf)
+						readErr <- lerr
+						return
+					}
+				}
+				queued.Add(1)
+				select {
+				case queue <- chunk{buf: buf, data: (*buf)[:n], deliverAt: p.clock.Now().Add(p.latency())}:
+				case <-ctx.Done():
+					queued.Add(-1)
+					p.buffers.Put(buf)
+					readErr <- ctx.Err()
+					return
+				}
+			} else {
+				p.buffers.Put(buf)
+			}
+			if err != nil {
+				if err == io.EOF {
+					readErr <- nil
+				} else {
+					readErr <- err
+				}
+				return
+			}
+		}
+	}()
+
+	// On an early return, whatever is still queued goes back to the pool. The
+	// reader only closes the queue once ctx is cancelled or src fails, which
+	// our caller arranges after we return, so drain in the background.
+	defer func() {
+		go func() {
+			for c := range queue {
+				p.release(c)
+			}
+		}()
+	}()
+	for c := range queue {
+		if err := p.wait(c.deliverAt.Sub(p.clock.Now())); err != nil {
+			p.release(c)
+			return err
+		}
+		if p.check != nil {
+			if err := p.check(p.dir); err != nil {
+				p.release(c)
+				return err
+			}
+		}
+		n, err := dst.Write(c.data)
+		short := n < len(c.data)
+		p.release(c)
+		p.record(p.dir, n)
+		p.acknowledge(win, len(c.data), c.deliverAt)
+		if err != nil {
+			return err
+		}
+		if short {
+			return ErrShortWrite
+		}
+	}
+	err := <-readErr
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if _, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) {
+		// The propagated deadline fired a moment before the context's.
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// RelayPacket carries one packet of payload in dir: it checks the link,
+// waits for the bandwidth, holds the packet for the latency and records
+// it. The caller sends the packet once RelayPacket returns nil; on an
+// error the packet is dropped. The link is checked when the packet leaves,
+// so a packet already in flight is delivered.
+func (p *Pipe) RelayPacket(payload []byte, dir Direction) error {
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	if p.check != nil {
+		if err := p.check(dir); err != nil {
+			return err
+		}
+	}
+	if p.limiter != nil {
+		if err := p.limiter.WaitN(p.ctx, len(payload)); err != nil {
+			return err
+		}
+	}
+	if err := p.wait(p.latency()); err != nil {
+		return err
+	}
+	p.record(dir, len(payload))
+	return nil
+}
+
+// Sleep waits d on the pipe's clock, or until its context is done.
+func (p *Pipe) Sleep(d time.Duration) error { return p.wait(d) }
+
+// wait blocks for d on the clock; a non-positive d only checks the context.
+func (p *Pipe) wait(d time.Duration) error {
+	if d <= 0 {
+		return p.ctx.Err()
+	}
+	select {
+	case <-p.clock.After(d):
+		return nil
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+// acknowledge frees n bytes of win one latency after deliveredAt, when the
+// acknowledgment would reach the sender.
+func (p *Pipe) acknowledge(win *window, n int, deliveredAt time.Time) {
+	if win == nil {
+		return
+	}
+	wake := p.clock.After(deliveredAt.Add(p.latency()).Sub(p.clock.Now()))
+	go func() {
+		select {
+		case <-wake:
+			win.ack(n)
+		case <-win.done:
+		case <-p.ctx.Done():
+		}
+	}()
+}
+
+func (p *Pipe) release(c chunk) {
+	queued.Add(-1)
+	p.buffers.Put(c.buf)
+}
+
+func (p *Pipe) record(dir Direction, n int) {
+	if p.metrics != nil && n > 0 {
+		p.metrics.Bytes(dir, n)
+	}
+}
+
+// IsDone reports whether err only says the pipe's context ended.
+func IsDone(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// window counts a stream copy's unacknowledged bytes. A nil *window is
+// uncapped.
+type window struct {
+	size  int
+	acked chan struct{} // signalled when bytes are acknowledged
+	done  chan struct{} // closed when the copy returns
+
+	mu       sync.Mutex
+	inFlight int
+}
+
+func newWindow(size int) *window {
+	return &window{size: size, acked: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+// room waits until the window has space and returns how much, or an error
+// if ctx or the copy ends first.
+func (w *window) room(ctx context.Context) (int, error) {
+	for {
+		w.mu.Lock()
+		room := w.size - w.inFlight
+		w.mu.Unlock()
+		if room > 0 {
+			return room, nil
+		}
+		select {
+		case <-w.acked:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-w.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+}
+
+// send counts n more bytes in flight.
+func (w *window) send(n int) {
+	if w == nil || n <= 0 {
+		return
+	}
+	w.mu.Lock()
+	w.inFlight += n
+	w.mu.Unlock()
+}
+
+// ack takes n bytes out of flight.
+func (w *window) ack(n int) {
+	w.mu.Lock()
+	w.inFlight -= n
+	w.mu.Unlock()
+	select {
+	case w.acked <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,476 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestCopyTCPShiftsButDoesNotThrottle is the core throughput regression: the
+// delay line must shift the whole stream by one latency, NOT sleep once per
+// chunk (which the old relay did, throttling throughput to chunk/latency).
+func TestCopyTCPShiftsButDoesNotThrottle(t *testing.T) {
+	latency := 100 * time.Millisecond
+	payload := bytes.Repeat([]byte("x"), 256*1024) // 8 chunks of 32KB
+
+	var out bytes.Buffer
+	start := time.Now()
+	err := New(WithLatency(latency)).CopyTCP(&out, bytes.NewReader(payload))
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("CopyTCP: %v", err)
+	}
+	if out.Len() != len(payload) {
+		t.Fatalf("copied %d bytes, want %d", out.Len(), len(payload))
+	}
+	if elapsed < latency {
+		t.Errorf("finished in %v, expected at least the %v delay", elapsed, latency)
+	}
+	// Old behavior: 8 chunks * 100ms = 800ms minimum. Delay-line behavior:
+	// one 100ms shift for the whole stream.
+	if elapsed > latency+200*time.Millisecond {
+		t.Errorf("took %v: per-chunk sleeping detected, expected about %v total", elapsed, latency)
+	}
+}
+
+// TestCopyTCPCancellation ensures a cancelled context tears the copy down
+// promptly instead of holding the full (possibly interplanetary) delay, and
+// that the stranded chunk's buffer goes back to the pool.
+func TestCopyTCPCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := NewBufferPool(DefaultChunkSize)
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		var out bytes.Buffer
+		done <- New(WithContext(ctx), WithLatency(time.Hour), WithBuffers(pool)).CopyTCP(&out, pr)
+	}()
+
+	if _, err := pw.Write([]byte("stranded in transit")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) || !IsDone(err) {
+			t.Errorf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CopyTCP did not return after cancellation")
+	}
+
+	// The reader is still blocked on the pipe, holding one buffer; ending
+	// the source lets it drain.
+	pw.Close()
+	waitFor(t, func() bool { return pool.Stats().InUse == 0 }, "buffers returned to the pool")
+}
+
+// TestCopyTCPDeadline checks a deadline on the pipe's context ends a copy
+// blocked in Read, both through the context and by being set on the
+// connection itself.
+func TestCopyTCPDeadline(t *testing.T) {
+	src, peer := net.Pipe()
+	defer src.Close()
+	defer peer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- New(WithContext(ctx)).CopyTCP(io.Discard, src) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want a deadline error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("CopyTCP outlived its context's deadline")
+	}
+	// The deadline was also set on src, so a later Read fails at once.
+	if _, err := src.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("read after the copy: %v, want the propagated deadline", err)
+	}
+}
+
+// TestCopyTCPDeadlineWhileQueued checks the deadline also ends a copy
+// holding data in the delay line.
+func TestCopyTCPDeadlineWhileQueued(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	err := New(WithContext(ctx), WithLatency(time.Hour)).CopyTCP(&out, strings.NewReader("late"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("delivered %q before its release time", out.String())
+	}
+}
+
+// shortWriter takes at most limit bytes per write, failing with err if
+// non-nil.
+type shortWriter struct {
+	limit int
+	err   error
+	got   bytes.Buffer
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	n := min(len(p), w.limit)
+	w.got.Write(p[:n])
+	return n, w.err
+}
+
+// TestCopyTCPPartialWrites checks a destination taking less than it was
+// given ends the copy, and only the bytes it took are counted.
+func TestCopyTCPPartialWrites(t *testing.T) {
+	broken := errors.New("connection reset")
+	for _, tc := range []struct {
+		name string
+		w    *shortWriter
+		want error
+	}{
+		{"silent", &shortWriter{limit: 5}, ErrShortWrite},
+		{"with error", &shortWriter{limit: 5, err: broken}, broken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var counted int
+			m := MetricsFunc(func(_ Direction, n int) { counted += n })
+			err := New(WithMetrics(m)).CopyTCP(tc.w, strings.NewReader("twelve bytes"))
+			if !errors.Is(err, tc.want) {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+			if tc.w.got.String() != "twelv" || counted != 5 {
+				t.Errorf("wrote %q and counted %d, want \"twelv\" and 5", tc.w.got.String(), counted)
+			}
+		})
+	}
+}
+
+// TestCopyTCPMetrics checks the sink sees every byte, in the pipe's
+// direction, and only once it has been written.
+func TestCopyTCPMetrics(t *testing.T) {
+	payload := bytes.Repeat([]byte("y"), 100*1024)
+	var out bytes.Buffer
+	var counted int
+	m := MetricsFunc(func(dir Direction, n int) {
+		if dir != ToClient {
+			t.Errorf("bytes counted %s, want %s", dir, ToClient)
+		}
+		if out.Len() < counted+n {
+			t.Errorf("%d bytes counted before they were written", n)
+		}
+		counted += n
+	})
+	err := New(WithLatency(time.Millisecond), WithDirection(ToClient), WithMetrics(m)).CopyTCP(&out, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("CopyTCP: %v", err)
+	}
+	if counted != len(payload) {
+		t.Errorf("counted %d, want %d", counted, len(payload))
+	}
+}
+
+// TestCopyTCPChunkSize checks reads are capped at the chunk size.
+func TestCopyTCPChunkSize(t *testing.T) {
+	out := &stampedWriter{}
+	if err := New(WithChunkSize(4)).CopyTCP(out, strings.NewReader("abcdefghij")); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(out.writes, "|"); got != "abcd|efgh|ij" {
+		t.Errorf("chunks %q, want abcd|efgh|ij", got)
+	}
+}
+
+// TestCopyTCPLinkCheck checks a refused link stops the copy before the
+// chunk is written.
+func TestCopyTCPLinkCheck(t *testing.T) {
+	occluded := errors.New("occluded by Sun")
+	var out bytes.Buffer
+	err := New(WithLinkCheck(func(Direction) error { return occluded })).CopyTCP(&out, strings.NewReader("hello"))
+	if !errors.Is(err, occluded) || out.Len() != 0 {
+		t.Errorf("got %v with %q written, want the check's error and nothing", err, out.String())
+	}
+}
+
+// stampedWriter records when each write arrived.
+type stampedWriter struct {
+	writes []string
+	at     []time.Time
+}
+
+func (w *stampedWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	w.at = append(w.at, time.Now())
+	return len(p), nil
+}
+
+// TestCopyTCPWakeOrder checks chunks are released in the order they were
+// read, each no earlier than its own wake time, and that the pooled buffers
+// they came in are not reused while still queued.
+func TestCopyTCPWakeOrder(t *testing.T) {
+	latency := 60 * time.Millisecond
+	pr, pw := io.Pipe()
+
+	out := &stampedWriter{}
+	done := make(chan error, 1)
+	go func() { done <- New(WithLatency(latency)).CopyTCP(out, pr) }()
+
+	var sent []time.Time
+	for i, gap := range []time.Duration{0, 5, 30, 1, 1, 20} {
+		time.Sleep(gap * time.Millisecond)
+		sent = append(sent, time.Now())
+		if _, err := pw.Write([]byte{'a' + byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(out.writes, ""); got != "abcdef" {
+		t.Fatalf("released %q, want \"abcdef\" in order", got)
+	}
+	for i := range sent {
+		if early := sent[i].Add(latency).Sub(out.at[i]); early > time.Millisecond {
+			t.Errorf("chunk %d released %v before its wake time", i, early)
+		}
+	}
+}
+
+// fastClock runs speed times faster than the wall clock, so a test can
+// model interplanetary round trips in milliseconds.
+type fastClock struct {
+	start time.Time
+	speed time.Duration
+}
+
+func (c fastClock) Now() time.Time {
+	return c.start.Add(time.Since(c.start) * c.speed)
+}
+
+func (c fastClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	time.AfterFunc(d/c.speed, func() { ch <- c.Now() })
+	return ch
+}
+
+// TestCopyTCPWindow streams ten windows over a 10s round trip with and
+// without WithWindow. Uncapped, the stream arrives one latency late;
+// capped, it moves one window per round trip, at window/RTT.
+func TestCopyTCPWindow(t *testing.T) {
+	const (
+		latency = 5 * time.Second // a 10s round trip
+		win     = 64 * 1024
+		size    = 10 * win
+	)
+	transfer := func(opts ...Option) (rate float64) {
+		clock := fastClock{start: time.Now(), speed: 200}
+		start := clock.Now()
+		var out bytes.Buffer
+		opts = append(opts, WithClock(clock), WithLatency(latency))
+		if err := New(opts...).CopyTCP(&out, &chunkReader{left: size}); err != nil {
+			t.Fatal(err)
+		}
+		if out.Len() != size {
+			t.Fatalf("copied %d bytes, want %d", out.Len(), size)
+		}
+		return float64(size) / clock.Now().Sub(start).Seconds()
+	}
+
+	// The first window arrives after one latency, the last nine round trips
+	// later: ten windows in 95s, against window/RTT's 100s.
+	want := float64(win) / (2 * latency).Seconds()
+	capped := transfer(WithWindow(win))
+	if capped < 0.8*want || capped > 1.2*want {
+		t.Errorf("windowed throughput %.0f B/s, want window/RTT = %.0f B/s within 20%%", capped, want)
+	}
+	if uncapped := transfer(); uncapped < 5*capped {
+		t.Errorf("uncapped throughput %.0f B/s, barely above the windowed %.0f B/s", uncapped, capped)
+	}
+}
+
+// fakeClock fires every wait at once and records how long each was.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// countingLimiter records what it was asked to pace.
+type countingLimiter struct {
+	asked []int
+	err   error
+}
+
+func (l *countingLimiter) WaitN(_ context.Context, n int) error {
+	l.asked = append(l.asked, n)
+	return l.err
+}
+
+func TestRelayPacket(t *testing.T) {
+	clock := &fakeClock{}
+	limiter := &countingLimiter{}
+	counted := map[Direction]int{}
+	var checked []Direction
+	p := New(
+		WithClock(clock),
+		WithLatency(3*time.Minute),
+		WithLimiter(limiter),
+		WithMetrics(MetricsFunc(func(dir Direction, n int) { counted[dir] += n })),
+		WithLinkCheck(func(dir Direction) error {
+			checked = append(checked, dir)
+			return nil
+		}),
+	)
+
+	if err := p.RelayPacket(make([]byte, 100), ToTarget); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RelayPacket(make([]byte, 40), ToClient); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.waits) != 2 || clock.waits[0] != 3*time.Minute || clock.waits[1] != 3*time.Minute {
+		t.Errorf("waited %v, want the latency once per packet", clock.waits)
+	}
+	if len(limiter.asked) != 2 || limiter.asked[0] != 100 || limiter.asked[1] != 40 {
+		t.Errorf("limiter asked for %v, want [100 40]", limiter.asked)
+	}
+	if counted[ToTarget] != 100 || counted[ToClient] != 40 {
+		t.Errorf("counted %v, want 100 to target and 40 to client", counted)
+	}
+	if len(checked) != 2 || checked[0] != ToTarget || checked[1] != ToClient {
+		t.Errorf("link checked for %v", checked)
+	}
+}
+
+// TestRelayPacketRefused checks a refused link or limiter drops the packet
+// without waiting or counting it.
+func TestRelayPacketRefused(t *testing.T) {
+	refused := errors.New("no contact window")
+	for _, tc := range []struct {
+		name string
+		opt  Option
+	}{
+		{"link", WithLinkCheck(func(Direction) error { return refused })},
+		{"limiter", WithLimiter(&countingLimiter{err: refused})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &fakeClock{}
+			var counted int
+			p := New(WithClock(clock), WithLatency(time.Second), tc.opt,
+				WithMetrics(MetricsFunc(func(_ Direction, n int) { counted += n })))
+			if err := p.RelayPacket([]byte("ping"), ToTarget); !errors.Is(err, refused) {
+				t.Errorf("got %v, want %v", err, refused)
+			}
+			if len(clock.waits) != 0 || counted != 0 {
+				t.Errorf("waited %v and counted %d for a dropped packet", clock.waits, counted)
+			}
+		})
+	}
+}
+
+// TestRelayPacketCancellation checks a packet held for the latency is
+// dropped when the context ends, and that a done context drops packets at
+// once.
+func TestRelayPacketCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var counted int
+	p := New(WithContext(ctx), WithLatency(time.Hour),
+		WithMetrics(MetricsFunc(func(_ Direction, n int) { counted += n })))
+
+	done := make(chan error, 1)
+	go func() { done <- p.RelayPacket([]byte("ping"), ToTarget) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RelayPacket held the packet after cancellation")
+	}
+	if err := p.RelayPacket([]byte("pong"), ToClient); !errors.Is(err, context.Canceled) {
+		t.Errorf("after cancellation: %v", err)
+	}
+	if counted != 0 {
+		t.Errorf("counted %d bytes of dropped packets", counted)
+	}
+}
+
+func TestQueuedDrains(t *testing.T) {
+	before := Queued()
+	if err := New(WithChunkSize(1)).CopyTCP(io.Discard, strings.NewReader("some bytes")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return Queued() == before }, "queued chunks released")
+}
+
+// waitFor polls cond until it holds or a few seconds pass.
+func waitFor(t *testing.T, cond func() bool, what string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// chunkReader returns its payload DefaultChunkSize bytes at a time, like a
+// busy TCP connection.
+type chunkReader struct{ left int }
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		return 0, io.EOF
+	}
+	n := min(r.left, len(p), DefaultChunkSize)
+	r.left -= n
+	return n, nil
+}
+
+// BenchmarkCopyTCP pushes 4MB through a delay line. With pooled buffers the
+// per-op allocations stay flat instead of growing with the chunk count
+// (128 x 32KB for the old make-per-read loop).
+func BenchmarkCopyTCP(b *testing.B) {
+	const size = 4 << 20
+	b.ReportAllocs()
+	b.SetBytes(size)
+	p := New()
+	for i := 0; i < b.N; i++ {
+		if err := p.CopyTCP(io.Discard, &chunkReader{left: size}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package relay
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Scheduler holds packets in flight and releases each at its due time, so
+// their propagation delays overlap: a relay hands a packet over and goes
+// straight back to reading, where RelayPacket would hold it for the whole
+// latency. One goroutine serves the queue, a min-heap on release time,
+// waking for the earliest packet only.
+//
+// Packets in one direction leave in the order they were scheduled, even if
+// the latency shrank between them. With a cap, a full queue drops its
+// oldest packet to make room, as a link buffer would.
+type Scheduler struct {
+	clock  Clock
+	max    int
+	onDrop func()
+
+	mu      sync.Mutex
+	queue   packetQueue
+	seq     uint64
+	last    map[Direction]time.Time // latest release scheduled per direction
+	stopped bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// scheduled is a packet waiting in a Scheduler.
+type scheduled struct {
+	at      time.Time
+	seq     uint64 // schedule order, breaking ties in at
+	deliver func()
+}
+
+// NewScheduler starts a scheduler on clock holding at most max packets
+// (0 = no cap), calling onDrop, if set, for each packet dropped to make
+// room. Stop ends it.
+func NewScheduler(clock Clock, max int, onDrop func()) *Scheduler {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	s := &Scheduler{
+		clock:  clock,
+		max:    max,
+		onDrop: onDrop,
+		last:   make(map[Direction]time.Time),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Schedule calls deliver, on the scheduler's goroutine, at at or just after
+// the packet last scheduled in dir.
+func (s *Scheduler) Schedule(dir Direction, at time.Time, deliver func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	if last := s.last[dir]; at.Before(last) {
+		at = last
+	}
+	s.last[dir] = at
+	if s.max > 0 && len(s.queue) >= s.max {
+		heap.Pop(&s.queue)
+		if s.onDrop != nil {
+			s.onDrop()
+		}
+	}
+	s.seq++
+	heap.Push(&s.queue, scheduled{at: at, seq: s.seq, deliver: deliver})
+	if s.queue[0].seq == s.seq {
+		// A new earliest packet: the goroutine's wait is too long.
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Len is the number of packets waiting.
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// Stop discards the waiting packets and ends the goroutine. Packets
+// scheduled afterwards are dropped.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	s.queue = nil
+	close(s.done)
+}
+
+func (s *Scheduler) run() {
+	for {
+		s.mu.Lock()
+		var due <-chan time.Time
+		for len(s.queue) > 0 && !s.stopped {
+			wait := s.queue[0].at.Sub(s.clock.Now())
+			if wait > 0 {
+				due = s.clock.After(wait)
+				break
+			}
+			p := heap.Pop(&s.queue).(scheduled)
+			s.mu.Unlock()
+			p.deliver()
+			s.mu.Lock()
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-due:
+		}
+	}
+}
+
+// SendPacket is RelayPacket without the wait: it checks the link and paces
+// the packet, then has sched record it and call deliver one latency later.
+// On an error the packet is dropped.
+func (p *Pipe) SendPacket(sched *Scheduler, payload []byte, dir Direction, deliver func()) error {
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	if p.check != nil {
+		if err := p.check(dir); err != nil {
+			return err
+		}
+	}
+	if p.limiter != nil {
+		if err := p.limiter.WaitN(p.ctx, len(payload)); err != nil {
+			return err
+		}
+	}
+	n := len(payload)
+	sched.Schedule(dir, p.clock.Now().Add(p.latency()), func() {
+		p.record(dir, n)
+		deliver()
+	})
+	return nil
+}
+
+// packetQueue is a min-heap of scheduled packets by release time.
+type packetQueue []scheduled
+
+func (q packetQueue) Len() int { return len(q) }
+func (q packetQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q packetQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *packetQueue) Push(x any)   { *q = append(*q, x.(scheduled)) }
+func (q *packetQueue) Pop() any {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
@@ -0,0 +1,131 @@
+package relay
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSendPacketOverlaps is the datagram counterpart of
+// TestCopyTCPShiftsButDoesNotThrottle: a burst of packets sent through a
+// scheduler each arrives one latency after it was sent, not one latency
+// after the packet before it.
+func TestSendPacketOverlaps(t *testing.T) {
+	const latency = 100 * time.Millisecond
+	const n = 20
+	counted := 0
+	var mu sync.Mutex
+	p := New(WithLatency(latency),
+		WithMetrics(MetricsFunc(func(_ Direction, n int) { counted += n })))
+	sched := NewScheduler(nil, 0, nil)
+	defer sched.Stop()
+
+	sent := make([]time.Time, n)
+	arrived := make([]time.Time, n)
+	var order []int
+	for i := 0; i < n; i++ {
+		i := i
+		sent[i] = time.Now()
+		if err := p.SendPacket(sched, []byte("ping"), ToTarget, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			arrived[i] = time.Now()
+			order = append(order, i)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == n
+	}, "the burst to arrive")
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range order {
+		if order[i] != i {
+			t.Fatalf("arrived in order %v", order)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if d := arrived[i].Sub(sent[i]); d < latency || d > 2*latency {
+			t.Errorf("packet %d took %v, want about %v", i, d, latency)
+		}
+	}
+	if counted != 4*n {
+		t.Errorf("counted %d bytes, want %d", counted, 4*n)
+	}
+}
+
+// TestSchedulerKeepsOrder checks a packet scheduled earlier than the one
+// before it in the same direction waits for it, and directions don't hold
+// each other up.
+func TestSchedulerKeepsOrder(t *testing.T) {
+	sched := NewScheduler(nil, 0, nil)
+	defer sched.Stop()
+	var mu sync.Mutex
+	var got []string
+	deliver := func(name string) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, name)
+		}
+	}
+	now := time.Now()
+	sched.Schedule(ToTarget, now.Add(60*time.Millisecond), deliver("out 1"))
+	sched.Schedule(ToTarget, now.Add(10*time.Millisecond), deliver("out 2"))
+	sched.Schedule(ToClient, now.Add(30*time.Millisecond), deliver("back"))
+	waitFor(t, func() bool { return sched.Len() == 0 }, "the queue to empty")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"back", "out 1", "out 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+// TestSchedulerDropsOldest checks a full queue drops its earliest packet
+// for a new one, and Stop discards what is left.
+func TestSchedulerDropsOldest(t *testing.T) {
+	drops := 0
+	sched := NewScheduler(nil, 2, func() { drops++ })
+	delivered := false
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		sched.Schedule(ToClient, now.Add(time.Duration(i)*time.Hour), func() { delivered = true })
+	}
+	if sched.Len() != 2 || drops != 1 {
+		t.Errorf("%d queued, %d dropped; want 2 and 1", sched.Len(), drops)
+	}
+	sched.mu.Lock()
+	if head := sched.queue[0].at; !head.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("head due at %v, want the second packet", head)
+	}
+	sched.mu.Unlock()
+
+	sched.Stop()
+	sched.Schedule(ToClient, now, func() { delivered = true })
+	if sched.Len() != 0 || delivered {
+		t.Errorf("after Stop: %d queued, delivered %v", sched.Len(), delivered)
+	}
+}
+
+// TestSendPacketRefused checks a refused link drops the packet before it
+// reaches the scheduler.
+func TestSendPacketRefused(t *testing.T) {
+	refused := errors.New("no contact window")
+	sched := NewScheduler(nil, 0, nil)
+	defer sched.Stop()
+	p := New(WithLatency(time.Hour), WithLinkCheck(func(Direction) error { return refused }))
+	if err := p.SendPacket(sched, []byte("ping"), ToTarget, func() {}); !errors.Is(err, refused) {
+		t.Errorf("got %v, want %v", err, refused)
+	}
+	if sched.Len() != 0 {
+		t.Errorf("%d packets queued", sched.Len())
+	}
+}
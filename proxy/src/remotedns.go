@@ -0,0 +1,242 @@
+// remotedns.go - name resolution over the interplanetary link.
+//
+// A SOCKS CONNECT by name normally hands the name to the dialer, so it
+// resolves on Earth in milliseconds. A client on Mars would send its DNS
+// query over the link too. With -remote-dns-latency the proxy resolves the
+// name itself before dialing, after one round trip of the body's latency:
+// the query goes to Earth and the answer comes back. Answers are cached per
+// body for their DNS TTL, so a second connection to the same name pays only
+// the one-way delay, as it would behind a resolver on Mars.
+//
+// Every address a name resolves to is re-checked against the destination
+// policy (SecurityValidator.ValidateResolvedIP): an allowlisted name
+// pointing at loopback or the cloud metadata address is refused rather
+// than dialed. Lookups are counted in proxy_remote_dns_cache_total by body
+// and result (hit or miss).
+//
+// net.Resolver doesn't report TTLs, so its pure-Go resolver dials through
+// ttlConn, which reads the TTLs off the DNS responses it carries. A name
+// answered without DNS (from /etc/hosts) is cached for
+// remoteDNSDefaultTTL.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	remoteDNSDefaultTTL = time.Minute // for answers that carried no TTL
+	remoteDNSMaxTTL     = time.Hour   // longer TTLs are cut to this
+	remoteDNSMaxEntries = 10000       // cached names before expired ones are swept
+	remoteDNSTimeout    = 10 * time.Second
+)
+
+// dnsCacheKey is a name as looked up from one body.
+type dnsCacheKey struct {
+	body, host string
+}
+
+type dnsCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// RemoteDNS resolves names on behalf of clients on other bodies and caches
+// the answers per body. A nil *RemoteDNS resolves nothing: names are passed
+// to the dialer as before.
+type RemoteDNS struct {
+	metrics Metrics
+
+	// server, when set, is dialed for every query instead of the system's
+	// name servers; tests point it at a stub. now is replaced by tests.
+	server string
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[dnsCacheKey]dnsCacheEntry
+}
+
+// NewRemoteDNS returns a RemoteDNS with an empty cache.
+func NewRemoteDNS(metrics Metrics) *RemoteDNS {
+	return &RemoteDNS{
+		metrics: orNop(metrics),
+		now:     time.Now,
+		cache:   make(map[dnsCacheKey]dnsCacheEntry),
+	}
+}
+
+// Lookup returns host's addresses as seen from body. A cached answer is
+// returned at once; otherwise wait is called first, to spend the round
+// trip, and the answer is cached for its TTL. Errors aren't cached.
+func (d *RemoteDNS) Lookup(ctx context.Context, body, host string, wait func()) ([]net.IP, error) {
+	key := dnsCacheKey{body, strings.ToLower(host)}
+	d.mu.Lock()
+	e, cached := d.cache[key]
+	d.mu.Unlock()
+	if cached && d.now().Before(e.expires) {
+		d.metrics.RecordRemoteDNSLookup(body, "hit")
+		return e.ips, nil
+	}
+	d.metrics.RecordRemoteDNSLookup(body, "miss")
+
+	wait()
+	ips, ttl, err := d.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		d.store(key, dnsCacheEntry{ips: ips, expires: d.now().Add(ttl)})
+	}
+	return ips, nil
+}
+
+// resolve looks host up and returns its addresses and the smallest TTL
+// among the records that gave them.
+func (d *RemoteDNS) resolve(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteDNSTimeout)
+	defer cancel()
+	ttls := &ttlRecorder{}
+	dialer := &net.Dialer{}
+	resolver := &net.Resolver{
+		PreferGo: true, // the cgo resolver doesn't use Dial
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if d.server != "" {
+				addr = d.server
+			}
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			if pc, ok := conn.(net.PacketConn); ok {
+				return &ttlPacketConn{Conn: conn, PacketConn: pc, ttls: ttls}, nil
+			}
+			return &ttlConn{Conn: conn, ttls: ttls}, nil
+		},
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	ttl, seen := ttls.min()
+	switch {
+	case !seen:
+		ttl = remoteDNSDefaultTTL
+	case ttl > remoteDNSMaxTTL:
+		ttl = remoteDNSMaxTTL
+	}
+	return ips, ttl, nil
+}
+
+func (d *RemoteDNS) store(key dnsCacheKey, e dnsCacheEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.cache) >= remoteDNSMaxEntries {
+		now := d.now()
+		for k, old := range d.cache {
+			if !now.Before(old.expires) {
+				delete(d.cache, k)
+			}
+		}
+	}
+	if _, known := d.cache[key]; known || len(d.cache) < remoteDNSMaxEntries {
+		d.cache[key] = e
+	}
+}
+
+// ttlRecorder keeps the smallest TTL of the address records in the DNS
+// responses of one lookup.
+type ttlRecorder struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen bool
+}
+
+// note reads the answers of the DNS message msg.
+func (r *ttlRecorder) note(msg []byte) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			return // dnsmessage.ErrSectionDone, or a malformed message
+		}
+		if err := p.SkipAnswer(); err != nil {
+			return
+		}
+		switch h.Type {
+		case dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeCNAME:
+			ttl := time.Duration(h.TTL) * time.Second
+			r.mu.Lock()
+			if !r.seen || ttl < r.ttl {
+				r.ttl, r.seen = ttl, true
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+func (r *ttlRecorder) min() (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ttl, r.seen
+}
+
+// ttlPacketConn passes a UDP exchange through, noting each response.
+type ttlPacketConn struct {
+	net.Conn
+	net.PacketConn
+	ttls *ttlRecorder
+}
+
+func (c *ttlPacketConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.ttls.note(b[:n])
+	}
+	return n, err
+}
+
+// The methods both embedded types have go to the Conn.
+func (c *ttlPacketConn) Close() error                       { return c.Conn.Close() }
+func (c *ttlPacketConn) LocalAddr() net.Addr                { return c.Conn.LocalAddr() }
+func (c *ttlPacketConn) SetDeadline(t time.Time) error      { return c.Conn.SetDeadline(t) }
+func (c *ttlPacketConn) SetReadDeadline(t time.Time) error  { return c.Conn.SetReadDeadline(t) }
+func (c *ttlPacketConn) SetWriteDeadline(t time.Time) error { return c.Conn.SetWriteDeadline(t) }
+
+// ttlConn passes a TCP exchange through, noting each length-prefixed
+// response.
+type ttlConn struct {
+	net.Conn
+	ttls *ttlRecorder
+	buf  []byte
+}
+
+func (c *ttlConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.buf = append(c.buf, b[:n]...)
+	for len(c.buf) >= 2 {
+		size := int(binary.BigEndian.Uint16(c.buf))
+		if len(c.buf) < 2+size {
+			break
+		}
+		c.ttls.note(c.buf[2 : 2+size])
+		c.buf = c.buf[2+size:]
+	}
+	return n, err
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsStub is a UDP name server answering A queries from a fixed table.
+type dnsStub struct {
+	addr string
+
+	mu      sync.Mutex
+	records map[string]dnsStubRecord // by name, without the trailing dot
+	queries map[string]int           // A queries by name
+}
+
+type dnsStubRecord struct {
+	ip  net.IP
+	ttl uint32
+}
+
+func startDNSStub(t *testing.T, records map[string]dnsStubRecord) *dnsStub {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	stub := &dnsStub{addr: pc.LocalAddr().String(), records: records, queries: make(map[string]int)}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if reply := stub.answer(buf[:n]); reply != nil {
+				pc.WriteTo(reply, from)
+			}
+		}
+	}()
+	return stub
+}
+
+func (s *dnsStub) answer(query []byte) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil || len(msg.Questions) != 1 {
+		return nil
+	}
+	q := msg.Questions[0]
+	name := strings.TrimSuffix(q.Name.String(), ".")
+	s.mu.Lock()
+	rec, known := s.records[name]
+	if q.Type == dnsmessage.TypeA {
+		s.queries[name]++
+	}
+	s.mu.Unlock()
+	msg.Header.Response, msg.Header.Authoritative = true, true
+	msg.Additionals = nil
+	switch {
+	case !known:
+		msg.Header.RCode = dnsmessage.RCodeNameError
+	case q.Type == dnsmessage.TypeA:
+		var a dnsmessage.AResource
+		copy(a.A[:], rec.ip.To4())
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: rec.ttl},
+			Body:   &a,
+		}}
+	}
+	reply, _ := msg.Pack()
+	return reply
+}
+
+func (s *dnsStub) count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[name]
+}
+
+// TestRemoteDNSCache checks a lookup waits and queries once, is then
+// answered from the body's cache until its TTL runs out, and that bodies
+// don't share answers.
+func TestRemoteDNSCache(t *testing.T) {
+	stub := startDNSStub(t, map[string]dnsStubRecord{
+		"short.test": {net.IPv4(192, 0, 2, 10), 30},
+		"long.test":  {net.IPv4(192, 0, 2, 11), 86400},
+		"zero.test":  {net.IPv4(192, 0, 2, 12), 0},
+	})
+	metrics := NewRecordingMetrics()
+	d := NewRemoteDNS(metrics)
+	d.server = stub.addr
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	waits := 0
+	lookup := func(body, host string) net.IP {
+		t.Helper()
+		ips, err := d.Lookup(context.Background(), body, host, func() { waits++ })
+		if err != nil || len(ips) != 1 {
+			t.Fatalf("%s from %s: %v %v", host, body, ips, err)
+		}
+		return ips[0]
+	}
+
+	if ip := lookup("Mars", "short.test"); !ip.Equal(net.IPv4(192, 0, 2, 10)) {
+		t.Errorf("short.test = %v", ip)
+	}
+	lookup("Mars", "SHORT.test")
+	if waits != 1 || stub.count("short.test") != 1 {
+		t.Errorf("second lookup: %d waits, %d queries; want 1 and 1", waits, stub.count("short.test"))
+	}
+	lookup("Venus", "short.test")
+	if waits != 2 {
+		t.Errorf("Venus used Mars's answer")
+	}
+
+	now = now.Add(31 * time.Second) // past the TTL
+	lookup("Mars", "short.test")
+	if waits != 3 || stub.count("short.test") != 3 {
+		t.Errorf("after the TTL: %d waits, %d queries; want 3 and 3", waits, stub.count("short.test"))
+	}
+	if hits, misses := metrics.Count(metricRemoteDNSCache, "Mars", "hit"), metrics.Count(metricRemoteDNSCache, "Mars", "miss"); hits != 1 || misses != 2 {
+		t.Errorf("Mars: %v hits, %v misses; want 1 and 2", hits, misses)
+	}
+
+	// A day's TTL is kept an hour; a zero TTL isn't cached at all.
+	lookup("Mars", "long.test")
+	now = now.Add(remoteDNSMaxTTL)
+	lookup("Mars", "long.test")
+	lookup("Mars", "zero.test")
+	lookup("Mars", "zero.test")
+	if stub.count("long.test") != 2 || stub.count("zero.test") != 2 {
+		t.Errorf("queries: long.test %d, zero.test %d; want 2 each", stub.count("long.test"), stub.count("zero.test"))
+	}
+
+	// Failures aren't cached.
+	for i := 0; i < 2; i++ {
+		if _, err := d.Lookup(context.Background(), "Mars", "missing.test", func() {}); err == nil {
+			t.Fatal("missing.test resolved")
+		}
+	}
+	if n := stub.count("missing.test"); n != 2 {
+		t.Errorf("missing.test queried %d times, want 2", n)
+	}
+}
+
+// TestValidateResolvedIP checks which addresses an allowlisted name may
+// resolve to.
+func TestValidateResolvedIP(t *testing.T) {
+	prod, lab := NewSecurityValidator(), newTestSecurity()
+	lab.allowPrivate = true
+	for _, tc := range []struct {
+		ip        string
+		prod, lab bool
+	}{
+		{"93.184.216.34", true, true},
+		{"2606:2800:220:1::1", true, true},
+		{"127.0.0.1", false, true},
+		{"::1", false, true},
+		{"10.0.0.5", false, true},
+		{"fd00::5", false, true},
+		{"169.254.169.254", false, false},
+		{"fe80::1", false, false},
+		{"0.0.0.0", false, false},
+		{"224.0.0.1", false, false},
+	} {
+		ip := net.ParseIP(tc.ip)
+		if err := prod.ValidateResolvedIP("example.com", 443, ip); (err == nil) != tc.prod {
+			t.Errorf("production, %s: %v", tc.ip, err)
+		}
+		if err := lab.ValidateResolvedIP("example.com", 443, ip); (err == nil) != tc.lab {
+			t.Errorf("loopback and private allowed, %s: %v", tc.ip, err)
+		}
+	}
+	var d *DestinationError
+	if err := prod.ValidateResolvedIP("example.com", 443, net.ParseIP("169.254.169.254")); !errors.As(err, &d) || d.Reason != denyResolved {
+		t.Errorf("refusal %#v", err)
+	}
+}
+
+// TestSOCKSRemoteDNSLatency proxies to a name on a twelve-minute Mars link
+// with -remote-dns-latency, on a fake clock: the first CONNECT waits a
+// round trip before the query and the one-way delay after it, the second
+// is answered from the cache and waits only the one-way delay, and a name
+// resolving to a private address is refused after its lookup.
+func TestSOCKSRemoteDNSLatency(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	fakeLinkClock(t, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+
+	echo := startEchoServer(t)
+	stub := startDNSStub(t, map[string]dnsStubRecord{
+		"echo.test":   {net.IPv4(127, 0, 0, 1), 300},
+		"inside.test": {net.IPv4(10, 0, 0, 5), 300},
+	})
+	const mars = 12 * time.Minute
+	clock := newFakeClock(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	latencies := &testLatencies{fixed: testLatency}
+	latencies.set("Mars", mars)
+	metrics := NewRecordingMetrics()
+	security := newTestSecurity()
+	security.allowedHosts["echo.test"] = true
+	security.allowedHosts["inside.test"] = true
+	security.allowedPorts[strconv.Itoa(echo.Port)] = true
+	s := &Server{security: security, metrics: metrics, fixedCelestialBody: "Mars",
+		timing: timing{clock: clock, latencies: latencies}, remoteDNS: NewRemoteDNS(metrics)}
+	s.remoteDNS.server = stub.addr
+	proxy := startTestSOCKS(t, s)
+
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	dial := func(host string) <-chan dialed {
+		done := make(chan dialed, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, _, err := client.Dial(ctx, proxy, net.JoinHostPort(host, strconv.Itoa(echo.Port)))
+			if conn != nil {
+				t.Cleanup(func() { conn.Close() })
+			}
+			done <- dialed{conn, err}
+		}()
+		return done
+	}
+	notYet := func(done <-chan dialed, what string) {
+		t.Helper()
+		select {
+		case d := <-done:
+			t.Fatalf("%s: finished early: %v", what, d.err)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	// First CONNECT: a round trip, the query, then the one-way delay.
+	done := dial("echo.test")
+	clock.BlockUntil(t, 1)
+	if n := stub.count("echo.test"); n != 0 {
+		t.Fatalf("queried %d times before the round trip", n)
+	}
+	clock.Advance(2*mars - time.Second)
+	notYet(done, "first lookup")
+	clock.Advance(time.Second)
+	clock.BlockUntil(t, 1)
+	if n := stub.count("echo.test"); n != 1 {
+		t.Fatalf("queried %d times after the round trip", n)
+	}
+	notYet(done, "first connect")
+	clock.Advance(mars)
+	if d := <-done; d.err != nil {
+		t.Fatal(d.err)
+	}
+
+	// Second: cached, so only the one-way delay.
+	done = dial("echo.test")
+	clock.BlockUntil(t, 1)
+	clock.Advance(mars - time.Second)
+	notYet(done, "second connect")
+	clock.Advance(time.Second)
+	if d := <-done; d.err != nil {
+		t.Fatal(d.err)
+	}
+	if n := stub.count("echo.test"); n != 1 {
+		t.Errorf("queried %d times for two connections", n)
+	}
+	if hits, misses := metrics.Count(metricRemoteDNSCache, "Mars", "hit"), metrics.Count(metricRemoteDNSCache, "Mars", "miss"); hits != 1 || misses != 1 {
+		t.Errorf("%v hits, %v misses; want 1 and 1", hits, misses)
+	}
+
+	// An allowlisted name resolving to a private address.
+	done = dial("inside.test")
+	clock.BlockUntil(t, 1)
+	clock.Advance(2 * mars)
+	d := <-done
+	var reply *client.ReplyError
+	if !errors.As(d.err, &reply) || reply.Code != SOCKS5_REP_CONN_NOT_ALLOWED {
+		t.Fatalf("inside.test: %v, want connection not allowed", d.err)
+	}
+	if n := metrics.Count(metricDestinationDenied, protoSOCKSTCP, denyResolved); n != 1 {
+		t.Errorf("%s{socks_tcp, resolved_ip} = %v, want 1", metricDestinationDenied, n)
+	}
+}
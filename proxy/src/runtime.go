@@ -0,0 +1,155 @@
+// runtime.go - /_debug/runtime and /_debug/pprof/, for finding leaks in
+// production.
+//
+// Goroutine and file descriptor totals alone don't say which part of the
+// proxy is holding them, so the long-lived handlers count themselves in and
+// out: HTTP requests, SOCKS CONNECT sessions, UDP ASSOCIATE control
+// connections and their relay loops. A count that keeps climbing while
+// traffic is flat is the leak. Both endpoints need the admin token.
+//
+//	GET /_debug/runtime                  JSON summary
+//	GET /_debug/runtime?pprof=goroutine  the standard goroutine dump
+//	GET /_debug/pprof/...                net/http/pprof
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// activeCounter counts the calls of a handler that are still running.
+type activeCounter struct{ n atomic.Int64 }
+
+// enter counts a call in and returns the function that counts it out:
+//
+//	defer activeHTTP.enter()()
+func (c *activeCounter) enter() func() {
+	c.n.Add(1)
+	return func() { c.n.Add(-1) }
+}
+
+func (c *activeCounter) load() int64 { return c.n.Load() }
+
+var (
+	activeHTTP         activeCounter // handleHTTP
+	activeConnect      activeCounter // SOCKS CONNECT, handshake to teardown
+	activeUDPAssociate activeCounter // UDP ASSOCIATE control connections
+	activeUDPRelay     activeCounter // UDP relay loops
+)
+
+// RuntimeStats is the JSON returned by /_debug/runtime.
+type RuntimeStats struct {
+	Goroutines    int                 `json:"goroutines"`
+	OpenFDs       *int                `json:"openFds"` // null where /proc/self/fd can't be read
+	Active        map[string]int64    `json:"active"`  // per subsystem
+	Heap          RuntimeHeap         `json:"heap"`
+	DistanceCache RuntimeDistanceInfo `json:"distanceCache"`
+}
+
+// RuntimeHeap is a few of runtime.MemStats' fields.
+type RuntimeHeap struct {
+	AllocBytes    uint64  `json:"allocBytes"`
+	InuseBytes    uint64  `json:"inuseBytes"`
+	SysBytes      uint64  `json:"sysBytes"`
+	Objects       uint64  `json:"objects"`
+	NumGC         uint32  `json:"numGC"`
+	PauseTotalSec float64 `json:"pauseTotalSeconds"`
+}
+
+// RuntimeDistanceInfo describes the distance cache.
+type RuntimeDistanceInfo struct {
+	Generation uint64    `json:"generation"` // refreshes since start
+	Entries    int       `json:"entries"`
+	ComputedAt time.Time `json:"computedAt"` // the instant the distances describe
+	AgeSeconds float64   `json:"ageSeconds"` // since the last refresh
+}
+
+// openFDCount counts this process's open file descriptors.
+func openFDCount() (int, bool) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, false
+	}
+	return len(entries) - 1, true // less the descriptor reading the directory
+}
+
+// collectRuntimeStats takes a RuntimeStats now.
+func collectRuntimeStats() RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := RuntimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		Active: map[string]int64{
+			"http":                activeHTTP.load(),
+			"socks_connect":       activeConnect.load(),
+			"socks_udp_associate": activeUDPAssociate.load(),
+			"socks_udp_relay":     activeUDPRelay.load(),
+		},
+		Heap: RuntimeHeap{
+			AllocBytes:    mem.HeapAlloc,
+			InuseBytes:    mem.HeapInuse,
+			SysBytes:      mem.HeapSys,
+			Objects:       mem.HeapObjects,
+			NumGC:         mem.NumGC,
+			PauseTotalSec: float64(mem.PauseTotalNs) / 1e9,
+		},
+	}
+	if n, ok := openFDCount(); ok {
+		stats.OpenFDs = &n
+	}
+	DistanceCacheMutex.RLock()
+	stats.DistanceCache = RuntimeDistanceInfo{
+		Generation: distanceGeneration,
+		Entries:    len(distanceEntries),
+		ComputedAt: distanceEpoch,
+	}
+	if !distanceUpdated.Wall.IsZero() {
+		stats.DistanceCache.AgeSeconds = (uptime() - distanceUpdated.Mono).Seconds()
+	}
+	DistanceCacheMutex.RUnlock()
+	return stats
+}
+
+// handleRuntime serves /_debug/runtime.
+func handleRuntime(w http.ResponseWriter, r *http.Request) {
+	if profile := r.URL.Query().Get("pprof"); profile != "" {
+		if profile != "goroutine" {
+			http.Error(w, "only ?pprof=goroutine is served here; see /_debug/pprof/ for the rest", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("debug") == "" {
+			q := r.URL.Query()
+			q.Set("debug", "2") // every goroutine's full stack, as text
+			r.URL.RawQuery = q.Encode()
+		}
+		pprof.Handler("goroutine").ServeHTTP(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, collectRuntimeStats())
+}
+
+// handlePprof serves net/http/pprof under /_debug/pprof/. pprof.Index only
+// resolves profile names under /debug/pprof/, so they are dispatched here.
+func handlePprof(w http.ResponseWriter, r *http.Request) {
+	switch name := strings.TrimPrefix(r.URL.Path, "/_debug/pprof/"); name {
+	case "/_debug/pprof": // the index's links are relative
+		http.Redirect(w, r, "/_debug/pprof/", http.StatusMovedPermanently)
+	case "":
+		pprof.Index(w, r)
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Handler(name).ServeHTTP(w, r)
+	}
+}
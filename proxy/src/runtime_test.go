@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// TestDebugRuntimeCountsSubsystems holds a SOCKS CONNECT session and a UDP
+// association open, checks /_debug/runtime counts them, then checks the
+// counts drop back to zero once they are closed.
+func TestDebugRuntimeCountsSubsystems(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), adminToken: "ops"}
+	get := func(t *testing.T, url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, req)
+		return rec
+	}
+	stats := func(t *testing.T) RuntimeStats {
+		rec := get(t, "http://latency.space/_debug/runtime", "ops")
+		var out RuntimeStats
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &out) != nil {
+			t.Fatalf("%d %s", rec.Code, rec.Body)
+		}
+		return out
+	}
+	idle := func() bool {
+		a := stats(t).Active
+		return a["socks_connect"] == 0 && a["socks_udp_associate"] == 0 && a["socks_udp_relay"] == 0
+	}
+	waitFor(t, idle) // sessions from earlier tests
+
+	t.Run("active", func(t *testing.T) {
+		sec := newTestSecurity()
+		echo := startEchoServer(t)
+		proxy := startTestSOCKS(t, &Server{security: sec, metrics: NewRecordingMetrics(),
+			fixedCelestialBody: "Mars", timing: fixedLatency(time.Millisecond)})
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		conn, _, err := client.Dial(ctx, proxy, echo.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if code, _ := udpAssociate(t, sec, NewRecordingMetrics(), defaultUDPLimits); code != SOCKS5_REP_SUCCESS {
+			t.Fatalf("UDP ASSOCIATE: reply %#x", code)
+		}
+
+		waitFor(t, func() bool { return stats(t).Active["socks_udp_relay"] == 1 })
+		got := stats(t)
+		for name, want := range map[string]int64{"http": 1, "socks_connect": 1, "socks_udp_associate": 1, "socks_udp_relay": 1} {
+			if got.Active[name] != want {
+				t.Errorf("active %s = %d, want %d", name, got.Active[name], want)
+			}
+		}
+		if got.Goroutines < 4 || (got.OpenFDs != nil && *got.OpenFDs < 4) {
+			t.Errorf("%d goroutines, %v descriptors", got.Goroutines, got.OpenFDs)
+		}
+		if got.Heap.InuseBytes == 0 || got.DistanceCache.Generation == 0 || got.DistanceCache.Entries == 0 {
+			t.Errorf("heap %+v, distance cache %+v", got.Heap, got.DistanceCache)
+		}
+
+		dump := get(t, "http://latency.space/_debug/runtime?pprof=goroutine", "ops")
+		if dump.Code != http.StatusOK || !strings.Contains(dump.Body.String(), ".handleUDPRelay(") {
+			t.Errorf("goroutine dump: %d, relay goroutine not in it", dump.Code)
+		}
+	})
+
+	waitFor(t, idle)
+
+	for _, tc := range []struct {
+		url, token string
+		code       int
+	}{
+		{"http://latency.space/_debug/runtime", "", http.StatusNotFound},
+		{"http://latency.space/_debug/pprof/", "", http.StatusNotFound},
+		{"http://latency.space/_debug/pprof/", "ops", http.StatusOK},
+		{"http://latency.space/_debug/pprof", "ops", http.StatusMovedPermanently},
+		{"http://latency.space/_debug/pprof/heap?debug=1", "ops", http.StatusOK},
+		{"http://latency.space/_debug/pprof/nonsense", "ops", http.StatusNotFound},
+		{"http://latency.space/_debug/runtime?pprof=heap", "ops", http.StatusBadRequest},
+	} {
+		if rec := get(t, tc.url, tc.token); rec.Code != tc.code {
+			t.Errorf("%s (token %q): %d, want %d", tc.url, tc.token, rec.Code, tc.code)
+		}
+	}
+}
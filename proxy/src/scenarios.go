@@ -0,0 +1,174 @@
+// scenarios.go - everyday tasks timed over a body's link.
+//
+// Each scenario counts the round trips (RTT, twice the one-way light time)
+// a task needs before its data flows, then adds the time to serialize its
+// bytes at the link's rate, as TransferTime does. Server time, packet loss
+// and congestion control are ignored, so the figures are lower bounds:
+//
+//   - webpage: a page of pageLoadRequests requests. HTTP/1.1 opens
+//     pageLoadConnections connections in parallel and sends one request per
+//     connection per RTT (the ProtocolImpact page load); HTTP/2 multiplexes
+//     every subresource on one connection; HTTP/3 also folds the transport
+//     handshake into TLS.
+//   - ssh: an OpenSSH public-key login to the first shell prompt, then one
+//     RTT for each keystroke to be echoed.
+//   - video_call: usable only up to videoCallMaxOneWay one way (ITU-T G.114
+//     puts the limit for conversation between 150 and 400 ms) and from
+//     videoCallMinBps; the gap before a reply is heard is one RTT.
+//   - git_clone: smart HTTP protocol v2 - the ref advertisement, then a
+//     single fetch whose response is the pack.
+package main
+
+import (
+	"time"
+)
+
+// Scenario names, as /api/whatif takes them.
+const (
+	scenarioWebpage   = "webpage"
+	scenarioSSH       = "ssh"
+	scenarioVideoCall = "video_call"
+	scenarioGitClone  = "git_clone"
+)
+
+// scenarioNames lists the scenarios in the order the info page links them.
+var scenarioNames = []string{scenarioWebpage, scenarioSSH, scenarioVideoCall, scenarioGitClone}
+
+const (
+	defaultWebpageBytes  = 2 << 20   // a typical page with its subresources
+	defaultGitCloneBytes = 100 << 20 // a mid-sized repository's pack
+
+	videoCallMaxOneWay = 300 * time.Millisecond
+	videoCallMinBps    = 500e3 // a low-resolution video stream
+)
+
+// ScenarioStep is one stage of a scenario: its round trips, and its
+// duration, which includes any serialization time.
+type ScenarioStep struct {
+	Name       string
+	RoundTrips int
+	Duration   time.Duration
+}
+
+// ScenarioPlan is a scenario's stages, run one after another.
+type ScenarioPlan struct {
+	Steps []ScenarioStep
+}
+
+// RoundTrips is the number of round trips in all the steps.
+func (p ScenarioPlan) RoundTrips() int {
+	n := 0
+	for _, s := range p.Steps {
+		n += s.RoundTrips
+	}
+	return n
+}
+
+// Total is how long the whole plan takes.
+func (p ScenarioPlan) Total() time.Duration {
+	var d time.Duration
+	for _, s := range p.Steps {
+		d += s.Duration
+	}
+	return d
+}
+
+// planOf builds a plan from step names and round trips at rtt.
+func planOf(rtt time.Duration, steps ...ScenarioStep) ScenarioPlan {
+	for i := range steps {
+		steps[i].Duration += time.Duration(steps[i].RoundTrips) * rtt
+	}
+	return ScenarioPlan{Steps: steps}
+}
+
+// serialization is how long bytes take to send at bps, nothing if bps is
+// zero or less.
+func serialization(bytes int64, bps float64) time.Duration {
+	return TransferTime(bytes, bps, 0)
+}
+
+// WebpageScenario is a page load over each HTTP version.
+type WebpageScenario struct {
+	HTTP1, HTTP2, HTTP3 ScenarioPlan
+}
+
+// WebpageLoad times a page of size bytes over a link of oneWay and bps.
+func WebpageLoad(oneWay time.Duration, bps float64, size int64) WebpageScenario {
+	rtt := 2 * oneWay
+	body := ScenarioStep{Name: "transfer", Duration: serialization(size, bps)}
+	rounds := (pageLoadRequests - 1 + pageLoadConnections - 1) / pageLoadConnections
+	return WebpageScenario{
+		HTTP1: planOf(rtt,
+			ScenarioStep{Name: "dns", RoundTrips: 1},
+			ScenarioStep{Name: "tcp", RoundTrips: 1},
+			ScenarioStep{Name: "tls", RoundTrips: 1},
+			ScenarioStep{Name: "html", RoundTrips: 1},
+			ScenarioStep{Name: "subresources", RoundTrips: rounds},
+			body),
+		HTTP2: planOf(rtt,
+			ScenarioStep{Name: "dns", RoundTrips: 1},
+			ScenarioStep{Name: "tcp", RoundTrips: 1},
+			ScenarioStep{Name: "tls", RoundTrips: 1},
+			ScenarioStep{Name: "html", RoundTrips: 1},
+			ScenarioStep{Name: "subresources", RoundTrips: 1},
+			body),
+		HTTP3: planOf(rtt,
+			ScenarioStep{Name: "dns", RoundTrips: 1},
+			ScenarioStep{Name: "quic", RoundTrips: 1},
+			ScenarioStep{Name: "html", RoundTrips: 1},
+			ScenarioStep{Name: "subresources", RoundTrips: 1},
+			body),
+	}
+}
+
+// SSHScenario is an interactive SSH session.
+type SSHScenario struct {
+	Login         ScenarioPlan  // to the first prompt
+	KeystrokeEcho time.Duration // from a key press to its echo
+}
+
+// SSHSession times an SSH login and typing over a link of oneWay.
+func SSHSession(oneWay time.Duration) SSHScenario {
+	rtt := 2 * oneWay
+	return SSHScenario{
+		Login: planOf(rtt,
+			ScenarioStep{Name: "tcp", RoundTrips: 1},
+			ScenarioStep{Name: "version_exchange", RoundTrips: 1},
+			ScenarioStep{Name: "key_exchange", RoundTrips: 2},
+			ScenarioStep{Name: "service_request", RoundTrips: 1},
+			ScenarioStep{Name: "public_key_auth", RoundTrips: 2},
+			ScenarioStep{Name: "channel_open", RoundTrips: 1},
+			ScenarioStep{Name: "shell_prompt", RoundTrips: 1}),
+		KeystrokeEcho: rtt,
+	}
+}
+
+// VideoCallScenario is whether a live call works, and the wait for a reply.
+type VideoCallScenario struct {
+	Possible          bool
+	Reason            string // "latency" or "bandwidth" when not possible
+	ConversationalGap time.Duration
+}
+
+// VideoCall judges a video call over a link of oneWay and bps.
+func VideoCall(oneWay time.Duration, bps float64) VideoCallScenario {
+	v := VideoCallScenario{Possible: true, ConversationalGap: 2 * oneWay}
+	switch {
+	case oneWay > videoCallMaxOneWay:
+		v.Possible, v.Reason = false, "latency"
+	case bps > 0 && bps < videoCallMinBps:
+		v.Possible, v.Reason = false, "bandwidth"
+	}
+	return v
+}
+
+// GitClone times cloning a repository whose pack is size bytes.
+func GitClone(oneWay time.Duration, bps float64, size int64) ScenarioPlan {
+	return planOf(2*oneWay,
+		ScenarioStep{Name: "dns", RoundTrips: 1},
+		ScenarioStep{Name: "tcp", RoundTrips: 1},
+		ScenarioStep{Name: "tls", RoundTrips: 1},
+		ScenarioStep{Name: "ref_advertisement", RoundTrips: 1},
+		ScenarioStep{Name: "fetch", RoundTrips: 1},
+		ScenarioStep{Name: "transfer", Duration: serialization(size, bps)})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebpageLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		oneWay              time.Duration
+		bps                 float64
+		size                int64
+		http1, http2, http3 time.Duration
+	}{
+		// 2 MB at 1 Mbps is 16.777216 s on the wire; RTT 20 s.
+		{"mars-ish", 10 * time.Second, 1e6, 2 << 20,
+			276777216 * time.Microsecond, 116777216 * time.Microsecond, 96777216 * time.Microsecond},
+		// No bandwidth model: round trips only. RTT 2.56 s.
+		{"moon, no rate", 1280 * time.Millisecond, 0, 2 << 20,
+			33280 * time.Millisecond, 12800 * time.Millisecond, 10240 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			page := WebpageLoad(tc.oneWay, tc.bps, tc.size)
+			for _, p := range []struct {
+				proto  string
+				plan   ScenarioPlan
+				rounds int
+				want   time.Duration
+			}{
+				{"HTTP/1.1", page.HTTP1, 13, tc.http1},
+				{"HTTP/2", page.HTTP2, 5, tc.http2},
+				{"HTTP/3", page.HTTP3, 4, tc.http3},
+			} {
+				if got := p.plan.RoundTrips(); got != p.rounds {
+					t.Errorf("%s: %d round trips, want %d", p.proto, got, p.rounds)
+				}
+				if got := p.plan.Total(); got != p.want {
+					t.Errorf("%s: %v, want %v", p.proto, got, p.want)
+				}
+			}
+		})
+	}
+
+	// HTTP/1.1 matches the page load of ProtocolImpact.
+	if got, want := WebpageLoad(3*time.Second, 0, 0).HTTP1.Total(), CalculateProtocolImpact(3*time.Second).PageLoad; got != want {
+		t.Errorf("HTTP/1.1 page load %v, ProtocolImpact says %v", got, want)
+	}
+}
+
+func TestSSHSession(t *testing.T) {
+	for _, tc := range []struct {
+		oneWay      time.Duration
+		firstPrompt time.Duration
+		echo        time.Duration
+	}{
+		{10 * time.Second, 180 * time.Second, 20 * time.Second}, // 9 RTT to the prompt
+		{1280 * time.Millisecond, 23040 * time.Millisecond, 2560 * time.Millisecond},
+	} {
+		ssh := SSHSession(tc.oneWay)
+		if got := ssh.Login.Total(); got != tc.firstPrompt {
+			t.Errorf("one way %v: first prompt after %v, want %v", tc.oneWay, got, tc.firstPrompt)
+		}
+		if ssh.KeystrokeEcho != tc.echo {
+			t.Errorf("one way %v: echo after %v, want %v", tc.oneWay, ssh.KeystrokeEcho, tc.echo)
+		}
+	}
+}
+
+func TestVideoCall(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		oneWay   time.Duration
+		bps      float64
+		possible bool
+		reason   string
+		gap      time.Duration
+	}{
+		{"geostationary", 120 * time.Millisecond, 1e9, true, "", 240 * time.Millisecond},
+		{"at the threshold", 300 * time.Millisecond, 1e9, true, "", 600 * time.Millisecond},
+		{"moon", 1280 * time.Millisecond, 1e9, false, "latency", 2560 * time.Millisecond},
+		{"thin link", 100 * time.Millisecond, 160, false, "bandwidth", 200 * time.Millisecond},
+		{"no rate", 100 * time.Millisecond, 0, true, "", 200 * time.Millisecond},
+	} {
+		call := VideoCall(tc.oneWay, tc.bps)
+		if call.Possible != tc.possible || call.Reason != tc.reason || call.ConversationalGap != tc.gap {
+			t.Errorf("%s: %+v, want possible=%v reason=%q gap=%v", tc.name, call, tc.possible, tc.reason, tc.gap)
+		}
+	}
+}
+
+func TestGitClone(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		oneWay time.Duration
+		bps    float64
+		size   int64
+		want   time.Duration
+	}{
+		// 100 MB at 8 Mbps is 104.8576 s; 5 RTT of 20 s.
+		{"mars-ish", 10 * time.Second, 8e6, 100 << 20, 204857600 * time.Microsecond},
+		// Voyager's 160 bps: 1 MB takes 52428.8 s; 5 RTT of 2 days.
+		{"voyager", 24 * time.Hour, 160, 1 << 20, 10*24*time.Hour + 52428800*time.Millisecond},
+	} {
+		clone := GitClone(tc.oneWay, tc.bps, tc.size)
+		if clone.RoundTrips() != 5 {
+			t.Errorf("%s: %d round trips, want 5", tc.name, clone.RoundTrips())
+		}
+		if got := clone.Total(); got != tc.want {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// scene.go - the solar system at one instant as a 3D scene, for Blender,
+// three.js and other visualization tools.
+//
+//	GET /api/scene.gltf[?t=RFC3339][&scale=1][&types=planet,moon]
+//	GET /api/scene.json[?t=RFC3339][&scale=1][&types=planet,moon]
+//
+// scene.gltf is a self-contained glTF 2.0 document: one node per body and
+// one shared unit-sphere mesh, its vertices in a base64 data: URI. Each
+// body's node holds its name, and its type and radius in extras; a child
+// node draws the sphere. A body whose parent is in the scene (a moon, or a
+// spacecraft orbiting a planet; planets under the Sun) is a child of the
+// parent's node, its translation relative to it, so a tool can animate it
+// around the parent. scene.json is the same scene as a flat list of bodies
+// with world positions.
+//
+// Positions are the heliocentric ones the latency model uses, ?scale units
+// per AU (default 1). glTF is Y-up: ecliptic north is +Y, the vernal
+// equinox +X and the ecliptic's +Y is -Z. scene.json uses the same axes.
+//
+// True to scale, every body but the Sun would be invisible, so a sphere's
+// radius grows with the log of the body's: sceneMinRadiusAU, plus
+// sceneRadiusAUPerDecade for each power of ten in kilometres, and never
+// less than the body's own. A body with no radius gets the minimum. The
+// sizes are exaggerated, not the distances; a moon close in to a big
+// planet can sit inside its sphere.
+//
+// The instant is rounded down to the minute, like /api/matrix, and each
+// minute's scene is cached per scale and type filter. A scene over
+// sceneMaxBytes is refused; narrow it with ?types. The endpoints share the
+// /api/distance rate limit.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+const (
+	// sceneMinRadiusAU is the smallest sphere drawn, about 75,000 km.
+	sceneMinRadiusAU = 0.0005
+	// sceneRadiusAUPerDecade is what each power of ten in a body's radius
+	// (km) adds to its sphere.
+	sceneRadiusAUPerDecade = 0.0002
+	// sceneSphereSegments and sceneSphereRings are the shared sphere's
+	// divisions around and from pole to pole.
+	sceneSphereSegments = 24
+	sceneSphereRings    = 12
+	// sceneCacheSize is how many encoded scenes are kept.
+	sceneCacheSize = 32
+	// sceneGenerator is asset.generator in the glTF.
+	sceneGenerator = "latency.space"
+)
+
+// sceneMaxBytes caps an encoded scene. The built-in objects come to a few
+// tens of kilobytes; an -objects-file could add thousands.
+var sceneMaxBytes = 4 << 20
+
+// SceneBody is a body in /api/scene.json.
+type SceneBody struct {
+	Name     string     `json:"name"`
+	Type     string     `json:"type"`
+	Parent   string     `json:"parent,omitempty"` // when the parent is in the scene
+	Position [3]float64 `json:"position"`         // world, scene units
+	Radius   float64    `json:"radius"`           // of the drawn sphere, scene units
+	RadiusKm float64    `json:"radius_km"`
+}
+
+// SceneResponse is the JSON returned by /api/scene.json.
+type SceneResponse struct {
+	Timestamp  time.Time   `json:"timestamp"` // the instant solved, rounded down to the minute
+	UnitsPerAU float64     `json:"units_per_au"`
+	Up         string      `json:"up"` // always "+Y, ecliptic north"
+	Bodies     []SceneBody `json:"bodies"`
+}
+
+// The glTF 2.0 subset the scene uses.
+type (
+	gltfDocument struct {
+		Asset       gltfAsset        `json:"asset"`
+		Scene       int              `json:"scene"`
+		Scenes      []gltfScene      `json:"scenes"`
+		Nodes       []gltfNode       `json:"nodes"`
+		Meshes      []gltfMesh       `json:"meshes"`
+		Accessors   []gltfAccessor   `json:"accessors"`
+		BufferViews []gltfBufferView `json:"bufferViews"`
+		Buffers     []gltfBuffer     `json:"buffers"`
+	}
+	gltfAsset struct {
+		Version   string `json:"version"`
+		Generator string `json:"generator"`
+	}
+	gltfScene struct {
+		Name   string         `json:"name"`
+		Nodes  []int          `json:"nodes"`
+		Extras map[string]any `json:"extras,omitempty"`
+	}
+	gltfNode struct {
+		Name        string         `json:"name"`
+		Mesh        *int           `json:"mesh,omitempty"`
+		Children    []int          `json:"children,omitempty"`
+		Translation *[3]float64    `json:"translation,omitempty"`
+		Scale       *[3]float64    `json:"scale,omitempty"`
+		Extras      map[string]any `json:"extras,omitempty"`
+	}
+	gltfMesh struct {
+		Name       string          `json:"name"`
+		Primitives []gltfPrimitive `json:"primitives"`
+	}
+	gltfPrimitive struct {
+		Attributes map[string]int `json:"attributes"`
+		Indices    int            `json:"indices"`
+		Mode       int            `json:"mode"`
+	}
+	gltfAccessor struct {
+		BufferView    int       `json:"bufferView"`
+		ComponentType int       `json:"componentType"`
+		Count         int       `json:"count"`
+		Type          string    `json:"type"`
+		Min           []float64 `json:"min,omitempty"`
+		Max           []float64 `json:"max,omitempty"`
+	}
+	gltfBufferView struct {
+		Buffer     int `json:"buffer"`
+		ByteOffset int `json:"byteOffset"`
+		ByteLength int `json:"byteLength"`
+		Target     int `json:"target"`
+	}
+	gltfBuffer struct {
+		ByteLength int    `json:"byteLength"`
+		URI        string `json:"uri"`
+	}
+)
+
+// glTF constants.
+const (
+	gltfFloat         = 5126
+	gltfUnsignedShort = 5123
+	gltfArrayBuffer   = 34962
+	gltfElementBuffer = 34963
+	gltfTriangles     = 4
+)
+
+// sceneSphere is the shared unit sphere: its buffer, accessors and views.
+type sceneSphere struct {
+	buffer      []byte
+	accessors   []gltfAccessor
+	bufferViews []gltfBufferView
+}
+
+// unitSphere builds the shared sphere once.
+var unitSphere = sync.OnceValue(func() sceneSphere {
+	var vertices []float32
+	for r := 0; r <= sceneSphereRings; r++ {
+		theta := math.Pi * float64(r) / sceneSphereRings
+		for s := 0; s <= sceneSphereSegments; s++ {
+			phi := 2 * math.Pi * float64(s) / sceneSphereSegments
+			vertices = append(vertices,
+				float32(math.Sin(theta)*math.Cos(phi)),
+				float32(math.Cos(theta)),
+				float32(math.Sin(theta)*math.Sin(phi)))
+		}
+	}
+	// Counter-clockwise seen from outside; the poles' quads are single
+	// triangles.
+	var indices []uint16
+	for r := 0; r < sceneSphereRings; r++ {
+		for s := 0; s < sceneSphereSegments; s++ {
+			a := uint16(r*(sceneSphereSegments+1) + s)
+			b := a + sceneSphereSegments + 1
+			if r != 0 {
+				indices = append(indices, a, a+1, b)
+			}
+			if r != sceneSphereRings-1 {
+				indices = append(indices, a+1, b+1, b)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	// A unit sphere's normals are its positions.
+	_ = binary.Write(&buf, binary.LittleEndian, vertices)
+	_ = binary.Write(&buf, binary.LittleEndian, vertices)
+	_ = binary.Write(&buf, binary.LittleEndian, indices)
+	vertexBytes := len(vertices) * 4
+	count := len(vertices) / 3
+
+	lo, hi := []float64{0, 0, 0}, []float64{0, 0, 0}
+	for i, v := range vertices {
+		lo[i%3] = math.Min(lo[i%3], float64(v))
+		hi[i%3] = math.Max(hi[i%3], float64(v))
+	}
+	return sceneSphere{
+		buffer: buf.Bytes(),
+		accessors: []gltfAccessor{
+			{BufferView: 0, ComponentType: gltfFloat, Count: count, Type: "VEC3", Min: lo, Max: hi},
+			{BufferView: 1, ComponentType: gltfFloat, Count: count, Type: "VEC3"},
+			{BufferView: 2, ComponentType: gltfUnsignedShort, Count: len(indices), Type: "SCALAR"},
+		},
+		bufferViews: []gltfBufferView{
+			{Buffer: 0, ByteOffset: 0, ByteLength: vertexBytes, Target: gltfArrayBuffer},
+			{Buffer: 0, ByteOffset: vertexBytes, ByteLength: vertexBytes, Target: gltfArrayBuffer},
+			{Buffer: 0, ByteOffset: 2 * vertexBytes, ByteLength: len(indices) * 2, Target: gltfElementBuffer},
+		},
+	}
+})
+
+type sceneKey struct {
+	minute int64 // Unix minutes
+	gltf   bool
+	scale  float64
+	types  string
+}
+
+type sceneEntry struct {
+	objects *[]celestial.CelestialObject // the object list it was built from
+	body    []byte
+}
+
+var (
+	sceneCacheMu sync.Mutex
+	sceneCache   = make(map[sceneKey]sceneEntry)
+)
+
+// handleScene serves /api/scene.gltf and /api/scene.json.
+func (s *Server) handleScene(w http.ResponseWriter, r *http.Request) {
+	release, err := s.distanceLimiter.Acquire(s.requestClientIP(r))
+	if err != nil {
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+		return
+	}
+	defer release()
+
+	q := r.URL.Query()
+	at := simTime(time.Now())
+	if ts := q.Get("t"); ts != "" {
+		parsed, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid 't' (want RFC3339): " + err.Error()})
+			return
+		}
+		at = parsed
+	}
+	scale := 1.0
+	if v := q.Get("scale"); v != "" {
+		scale, err = strconv.ParseFloat(v, 64)
+		if err != nil || scale <= 0 || math.IsInf(scale, 0) || math.IsNaN(scale) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "'scale' must be a positive number of units per AU"})
+			return
+		}
+	}
+	types, err := parseMatrixTypes(q.Get("types"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	gltf := strings.HasSuffix(r.URL.Path, ".gltf")
+	body, err := cachedScene(at, gltf, scale, types)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if gltf {
+		w.Header().Set("Content-Type", "model/gltf+json")
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(body)
+}
+
+// cachedScene returns the encoded scene for the minute containing at,
+// building it if it isn't cached for the current object list.
+func cachedScene(at time.Time, gltf bool, scale float64, types []string) ([]byte, error) {
+	at = at.UTC().Truncate(time.Minute)
+	objects := celestialObjectsPtr.Load()
+	key := sceneKey{at.Unix() / 60, gltf, scale, strings.Join(types, ",")}
+
+	sceneCacheMu.Lock()
+	defer sceneCacheMu.Unlock()
+	if e, ok := sceneCache[key]; ok && e.objects == objects {
+		return e.body, nil
+	}
+	scene := computeScene(*objects, at, scale, types)
+	var v any = scene
+	if gltf {
+		v = sceneGLTF(scene)
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > sceneMaxBytes {
+		return nil, fmt.Errorf("the scene is %d bytes, over the %d-byte cap; narrow it with 'types'", len(body), sceneMaxBytes)
+	}
+	if len(sceneCache) >= sceneCacheSize {
+		for k := range sceneCache { // evict an arbitrary entry
+			delete(sceneCache, k)
+			break
+		}
+	}
+	sceneCache[key] = sceneEntry{objects, body}
+	return body, nil
+}
+
+// computeScene places the bodies of the given types (all when empty) at at,
+// in objects order.
+func computeScene(objects []celestial.CelestialObject, at time.Time, scale float64, types []string) *SceneResponse {
+	positions := NewPositionCache(objects, at)
+	in := make(map[string]bool)
+	for _, obj := range objects {
+		if len(types) == 0 || slices.Contains(types, obj.Type) {
+			in[obj.Name] = true
+		}
+	}
+	resp := &SceneResponse{Timestamp: at, UnitsPerAU: scale, Up: "+Y, ecliptic north"}
+	for _, obj := range objects {
+		if !in[obj.Name] {
+			continue
+		}
+		body := SceneBody{
+			Name:     obj.Name,
+			Type:     obj.Type,
+			Position: sceneAxes(positions.Position(obj).Scale(scale)),
+			Radius:   sceneRadiusAU(obj.Radius) * scale,
+			RadiusKm: obj.Radius,
+		}
+		if obj.ParentName != obj.Name && in[obj.ParentName] {
+			body.Parent = obj.ParentName
+		}
+		resp.Bodies = append(resp.Bodies, body)
+	}
+	return resp
+}
+
+// sceneAxes turns an ecliptic vector into glTF's Y-up axes.
+func sceneAxes(v celestial.Vector3) [3]float64 {
+	return [3]float64{v.X, v.Z, -v.Y}
+}
+
+// sceneRadiusAU is the radius of the sphere drawn for a body of radiusKm.
+func sceneRadiusAU(radiusKm float64) float64 {
+	r := sceneMinRadiusAU + sceneRadiusAUPerDecade*math.Log10(1+math.Max(radiusKm, 0))
+	return math.Max(r, radiusKm/celestial.AU)
+}
+
+// sceneGLTF lays the scene out as glTF: body i is node 2i, its sphere node
+// 2i+1. A body whose parent is missing, or would make a loop, is a root.
+func sceneGLTF(scene *SceneResponse) *gltfDocument {
+	sphere := unitSphere()
+	index := make(map[string]int, len(scene.Bodies))
+	for i, b := range scene.Bodies {
+		index[b.Name] = i
+	}
+	// parentOf is the index of b's parent node's body, -1 for a root.
+	parentOf := make([]int, len(scene.Bodies))
+	for i, b := range scene.Bodies {
+		parentOf[i] = -1
+		if p, ok := index[b.Parent]; ok && b.Parent != "" {
+			parentOf[i] = p
+		}
+	}
+	for i := range parentOf {
+		// Objects files could make a loop; break it at the body found in it.
+		seen := map[int]bool{i: true}
+		for p := parentOf[i]; p >= 0; p = parentOf[p] {
+			if seen[p] {
+				parentOf[i] = -1
+				break
+			}
+			seen[p] = true
+		}
+	}
+
+	mesh := 0
+	doc := &gltfDocument{
+		Asset:       gltfAsset{Version: "2.0", Generator: sceneGenerator},
+		Meshes:      []gltfMesh{{Name: "sphere", Primitives: []gltfPrimitive{{Attributes: map[string]int{"POSITION": 0, "NORMAL": 1}, Indices: 2, Mode: gltfTriangles}}}},
+		Accessors:   sphere.accessors,
+		BufferViews: sphere.bufferViews,
+		Buffers: []gltfBuffer{{
+			ByteLength: len(sphere.buffer),
+			URI:        "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(sphere.buffer),
+		}},
+		Nodes: make([]gltfNode, 0, 2*len(scene.Bodies)),
+	}
+	root := gltfScene{
+		Name:   "Solar system " + scene.Timestamp.Format(time.RFC3339),
+		Extras: map[string]any{"timestamp": scene.Timestamp, "units_per_au": scene.UnitsPerAU},
+	}
+	for i, b := range scene.Bodies {
+		t := b.Position
+		if p := parentOf[i]; p >= 0 {
+			pp := scene.Bodies[p].Position
+			t = [3]float64{t[0] - pp[0], t[1] - pp[1], t[2] - pp[2]}
+		} else {
+			root.Nodes = append(root.Nodes, 2*i)
+		}
+		extras := map[string]any{"type": b.Type, "radius_km": b.RadiusKm}
+		if p := parentOf[i]; p >= 0 {
+			extras["parent"] = scene.Bodies[p].Name
+		}
+		size := [3]float64{b.Radius, b.Radius, b.Radius}
+		doc.Nodes = append(doc.Nodes,
+			gltfNode{Name: b.Name, Children: []int{2*i + 1}, Translation: &t, Extras: extras},
+			gltfNode{Name: b.Name + " sphere", Mesh: &mesh, Scale: &size})
+	}
+	for i, p := range parentOf {
+		if p >= 0 {
+			doc.Nodes[2*p].Children = append(doc.Nodes[2*p].Children, 2*i)
+		}
+	}
+	doc.Scenes = []gltfScene{root}
+	return doc
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func sceneRequest(t *testing.T, s *Server, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space"+path, nil))
+	return rec
+}
+
+// TestSceneGLTF checks the glTF has what loaders rely on: version 2.0,
+// every index in range, a tree of nodes, buffers the size they claim, and
+// the Moon under Earth at the Moon's distance.
+func TestSceneGLTF(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	at := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+	rec := sceneRequest(t, newMatrixServer(), "/api/scene.gltf?t=2030-06-01T00:00:30Z")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "model/gltf+json" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	var doc gltfDocument
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	validateGLTF(t, &doc)
+
+	parent := make(map[int]int)
+	for i, n := range doc.Nodes {
+		for _, c := range n.Children {
+			parent[c] = i
+		}
+	}
+	node := func(name string) (int, gltfNode) {
+		for i, n := range doc.Nodes {
+			if n.Name == name {
+				return i, n
+			}
+		}
+		t.Fatalf("no node %s", name)
+		return 0, gltfNode{}
+	}
+	objects := getCelestialObjects()
+	moon, moonNode := node("Moon")
+	earth, _ := node("Earth")
+	if parent[moon] != earth || moonNode.Extras["parent"] != "Earth" || moonNode.Extras["type"] != "moon" {
+		t.Fatalf("Moon's parent is node %d, extras %v; want Earth, node %d", parent[moon], moonNode.Extras, earth)
+	}
+	moonObj, _ := findObjectByName(objects, "Moon")
+	earthObj, _ := findObjectByName(objects, "Earth")
+	want := GetObjectPosition(moonObj, objects, at).Subtract(GetObjectPosition(earthObj, objects, at)).Magnitude()
+	if got := math.Sqrt(dot(vec(*moonNode.Translation), vec(*moonNode.Translation))); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Moon is %g AU from Earth's node, want %g", got, want)
+	}
+	if _, sun := node("Sun"); !slices.Contains(doc.Scenes[0].Nodes, 0) || sun.Translation == nil || *sun.Translation != [3]float64{} {
+		t.Errorf("the Sun isn't a root at the origin: %+v", sun)
+	}
+	if _, mars := node("Mars"); mars.Extras["type"] != "planet" || mars.Extras["radius_km"] == nil {
+		t.Errorf("Mars's extras %v", mars.Extras)
+	}
+}
+
+// validateGLTF checks the index consistency and sizes the scene relies on.
+func validateGLTF(t *testing.T, doc *gltfDocument) {
+	t.Helper()
+	if doc.Asset.Version != "2.0" {
+		t.Errorf("asset.version %q", doc.Asset.Version)
+	}
+	if doc.Scene < 0 || doc.Scene >= len(doc.Scenes) {
+		t.Fatalf("scene %d of %d", doc.Scene, len(doc.Scenes))
+	}
+	parents := make(map[int]int)
+	for i, n := range doc.Nodes {
+		for _, c := range n.Children {
+			if c < 0 || c >= len(doc.Nodes) {
+				t.Fatalf("node %s: child %d of %d", n.Name, c, len(doc.Nodes))
+			}
+			if _, ok := parents[c]; ok {
+				t.Errorf("node %d has two parents", c)
+			}
+			parents[c] = i
+		}
+		if n.Mesh != nil && (*n.Mesh < 0 || *n.Mesh >= len(doc.Meshes)) {
+			t.Errorf("node %s: mesh %d of %d", n.Name, *n.Mesh, len(doc.Meshes))
+		}
+	}
+	// Every node is reached exactly once from the scene's roots.
+	reached := make(map[int]bool)
+	var walk func(i int)
+	walk = func(i int) {
+		if reached[i] {
+			t.Fatalf("node %d reached twice", i)
+		}
+		reached[i] = true
+		for _, c := range doc.Nodes[i].Children {
+			walk(c)
+		}
+	}
+	for _, root := range doc.Scenes[doc.Scene].Nodes {
+		if _, ok := parents[root]; ok || root < 0 || root >= len(doc.Nodes) {
+			t.Fatalf("root %d is a child, or out of range", root)
+		}
+		walk(root)
+	}
+	if len(reached) != len(doc.Nodes) {
+		t.Errorf("%d of %d nodes reachable from the scene", len(reached), len(doc.Nodes))
+	}
+
+	for _, m := range doc.Meshes {
+		for _, p := range m.Primitives {
+			for _, a := range append([]int{p.Indices}, p.Attributes["POSITION"], p.Attributes["NORMAL"]) {
+				if a < 0 || a >= len(doc.Accessors) {
+					t.Fatalf("mesh %s: accessor %d of %d", m.Name, a, len(doc.Accessors))
+				}
+			}
+			if pos := doc.Accessors[p.Attributes["POSITION"]]; len(pos.Min) != 3 || len(pos.Max) != 3 {
+				t.Errorf("POSITION accessor lacks min and max")
+			}
+		}
+	}
+	size := map[string]int{"SCALAR": 1, "VEC3": 3}
+	component := map[int]int{gltfFloat: 4, gltfUnsignedShort: 2}
+	for i, a := range doc.Accessors {
+		if a.BufferView < 0 || a.BufferView >= len(doc.BufferViews) {
+			t.Fatalf("accessor %d: view %d of %d", i, a.BufferView, len(doc.BufferViews))
+		}
+		if n := a.Count * size[a.Type] * component[a.ComponentType]; n == 0 || n > doc.BufferViews[a.BufferView].ByteLength {
+			t.Errorf("accessor %d needs %d bytes, its view has %d", i, n, doc.BufferViews[a.BufferView].ByteLength)
+		}
+	}
+	for i, v := range doc.BufferViews {
+		if v.Buffer < 0 || v.Buffer >= len(doc.Buffers) {
+			t.Fatalf("view %d: buffer %d of %d", i, v.Buffer, len(doc.Buffers))
+		}
+		if v.ByteOffset%4 != 0 || v.ByteOffset+v.ByteLength > doc.Buffers[v.Buffer].ByteLength {
+			t.Errorf("view %d: bytes %d+%d of %d", i, v.ByteOffset, v.ByteLength, doc.Buffers[v.Buffer].ByteLength)
+		}
+	}
+	for i, b := range doc.Buffers {
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(b.URI, "data:application/octet-stream;base64,"))
+		if err != nil || len(data) != b.ByteLength {
+			t.Errorf("buffer %d: %d bytes decoded (%v), byteLength %d", i, len(data), err, b.ByteLength)
+		}
+	}
+}
+
+// TestSceneSphere checks the shared sphere has unit radius and every
+// triangle faces outwards.
+func TestSceneSphere(t *testing.T) {
+	sphere := unitSphere()
+	view := func(i int) []byte {
+		v := sphere.bufferViews[i]
+		return sphere.buffer[v.ByteOffset : v.ByteOffset+v.ByteLength]
+	}
+	vertices := make([]float32, sphere.accessors[0].Count*3)
+	indices := make([]uint16, sphere.accessors[2].Count)
+	if err := binary.Read(bytes.NewReader(view(0)), binary.LittleEndian, vertices); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Read(bytes.NewReader(view(2)), binary.LittleEndian, indices); err != nil {
+		t.Fatal(err)
+	}
+	vertex := func(i uint16) celestial.Vector3 {
+		return celestial.Vector3{X: float64(vertices[3*i]), Y: float64(vertices[3*i+1]), Z: float64(vertices[3*i+2])}
+	}
+	for i := range vertices {
+		if i%3 == 0 {
+			if r := vertex(uint16(i / 3)).Magnitude(); math.Abs(r-1) > 1e-6 {
+				t.Fatalf("vertex %d at radius %v", i/3, r)
+			}
+		}
+	}
+	for i := 0; i < len(indices); i += 3 {
+		a, b, c := vertex(indices[i]), vertex(indices[i+1]), vertex(indices[i+2])
+		normal := cross(b.Subtract(a), c.Subtract(a))
+		if dot(normal, normal) == 0 || dot(normal, a.Add(b).Add(c)) <= 0 {
+			t.Fatalf("triangle %d (%v) faces inwards or is degenerate", i/3, indices[i:i+3])
+		}
+	}
+}
+
+// TestSceneJSON checks the flat scene: world positions in Y-up axes, at
+// ?scale, and parents only when they are in the scene.
+func TestSceneJSON(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	at := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+	s := newMatrixServer()
+	get := func(query string) SceneResponse {
+		rec := sceneRequest(t, s, "/api/scene.json?t=2030-06-01T00:00:00Z"+query)
+		var out SceneResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); rec.Code != http.StatusOK || err != nil {
+			t.Fatalf("%s: %d %s", query, rec.Code, rec.Body)
+		}
+		return out
+	}
+	find := func(scene SceneResponse, name string) SceneBody {
+		for _, b := range scene.Bodies {
+			if b.Name == name {
+				return b
+			}
+		}
+		t.Fatalf("no %s in the scene", name)
+		return SceneBody{}
+	}
+
+	scene := get("&scale=10")
+	objects := getCelestialObjects()
+	mars, _ := findObjectByName(objects, "Mars")
+	p := GetObjectPosition(mars, objects, at)
+	got := find(scene, "Mars")
+	if want := [3]float64{10 * p.X, 10 * p.Z, -10 * p.Y}; got.Position != want || got.Parent != "Sun" {
+		t.Errorf("Mars at %v under %q, want %v under Sun", got.Position, got.Parent, want)
+	}
+	if phobos := find(scene, "Phobos"); phobos.Parent != "Mars" || phobos.Radius >= got.Radius || phobos.Radius < 10*sceneMinRadiusAU {
+		t.Errorf("Phobos under %q with radius %v (Mars %v)", phobos.Parent, phobos.Radius, got.Radius)
+	}
+	if sun := find(scene, "Sun"); math.Abs(sun.Radius-10*sun.RadiusKm/celestial.AU) > 1e-12 {
+		t.Errorf("the Sun's sphere is %v, not its own radius", sun.Radius)
+	}
+
+	moons := get("&types=moon")
+	for _, b := range moons.Bodies {
+		if b.Type != "moon" || b.Parent != "" {
+			t.Errorf("%s (%s) under %q in a moons-only scene", b.Name, b.Type, b.Parent)
+		}
+	}
+}
+
+// TestSceneErrors checks bad parameters and the size cap are refused.
+func TestSceneErrors(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	s := newMatrixServer()
+	for _, query := range []string{"scale=0", "scale=-1", "scale=abc", "scale=Inf", "t=yesterday", "types=starship"} {
+		if rec := sceneRequest(t, s, "/api/scene.gltf?"+query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d %s", query, rec.Code, rec.Body)
+		}
+	}
+
+	defer func(n int) { sceneMaxBytes = n }(sceneMaxBytes)
+	sceneMaxBytes = 1000
+	if rec := sceneRequest(t, s, "/api/scene.gltf?t=2031-01-01T00:00:00Z"); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "types") {
+		t.Errorf("over the cap: %d %s", rec.Code, rec.Body)
+	}
+	if rec := sceneRequest(t, s, "/api/scene.json?t=2031-01-01T00:00:00Z&types=star"); rec.Code != http.StatusOK {
+		t.Errorf("narrowed: %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestSceneCache checks a minute's scene is built once, and rebuilt when
+// the objects are replaced.
+func TestSceneCache(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	first, err := cachedScene(time.Date(2032, 1, 1, 0, 0, 10, 0, time.UTC), true, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := cachedScene(time.Date(2032, 1, 1, 0, 0, 50, 0, time.UTC), true, 1, nil)
+	if &first[0] != &again[0] {
+		t.Error("the same minute was built twice")
+	}
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	if rebuilt, _ := cachedScene(time.Date(2032, 1, 1, 0, 0, 50, 0, time.UTC), true, 1, nil); &rebuilt[0] == &first[0] {
+		t.Error("a new object list was served the old scene")
+	}
+}
+
+func vec(v [3]float64) celestial.Vector3 {
+	return celestial.Vector3{X: v[0], Y: v[1], Z: v[2]}
+}
@@ -2,21 +2,41 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
 	"os"
 	"sort"
-	"strconv" // Required for port conversion in ValidateSocksDestination
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // SecurityValidator provides methods for validating proxy requests.
+//
+// The host and port allow-lists can be edited at runtime (security_config.go),
+// so reads go through the accessors below, which hold mu.
 type SecurityValidator struct {
+	mu             sync.RWMutex
 	allowedPorts   map[string]bool // Allowed destination ports (e.g., "80", "443")
+	portRanges     []portRange     // Allowed destination port ranges (e.g., 8000-8100)
+	httpPorts      []portRange     // Ports http(s) destinations may use besides 80 and 443
 	maxRequestSize int64           // Maximum allowed request size (currently unused)
 	allowedSchemes map[string]bool // Allowed URL schemes (e.g., "http", "https")
-	allowedHosts   map[string]bool // Map of explicitly allowed destination hosts/domains
+	allowedHosts   map[string]bool // Allowed destination hosts/domains, and "*.domain" patterns
+	configPath     string          // -security-config file that edits are written back to
+
+	// minLatency is the one-way latency floor below which proxying is
+	// refused (minProxyLatency). allowLoopback admits loopback destinations
+	// on any port. Tests lower the one and set the other to dial local echo
+	// servers; production never changes them.
+	minLatency    time.Duration
+	allowLoopback bool
+	// allowPrivate admits private-network IP literals (-allow-private),
+	// for lab demos; see allowsPrivateIP.
+	allowPrivate bool
 }
 
 // NewSecurityValidator creates a new SecurityValidator with default rules.
@@ -69,17 +89,8 @@ func NewSecurityValidator() *SecurityValidator {
 		allowedHostsMap[strings.ToLower(host)] = true // Store lowercase for case-insensitive checks
 	}
 
-	// Operators can extend the allowlist without a code change via the
-	// ALLOWED_HOSTS environment variable (comma-separated hostnames). These
-	// are merged with the built-in defaults; there is no way to remove a
-	// default this way, only add.
-	if extra := os.Getenv("ALLOWED_HOSTS"); extra != "" {
-		for _, host := range strings.Split(extra, ",") {
-			host = strings.TrimSpace(strings.ToLower(host))
-			if host != "" {
-				allowedHostsMap[host] = true
-			}
-		}
+	for _, host := range envAllowedHosts() {
+		allowedHostsMap[host] = true
 	}
 
 	return &SecurityValidator{
@@ -99,12 +110,29 @@ func NewSecurityValidator() *SecurityValidator {
 			// "wss":   true, // Secure WebSocket (enable if needed)
 		},
 		allowedHosts: allowedHostsMap,
+		minLatency:   minProxyLatency,
+	}
+}
+
+// envAllowedHosts returns the hosts in the ALLOWED_HOSTS environment variable
+// (comma-separated), lowercased. Operators can extend the allowlist this way
+// without a code change; the hosts are merged with the defaults (or the
+// -security-config file), so there is no way to remove an entry this way,
+// only add.
+func envAllowedHosts() []string {
+	var hosts []string
+	for _, host := range strings.Split(os.Getenv("ALLOWED_HOSTS"), ",") {
+		host = strings.TrimSpace(strings.ToLower(host))
+		if host != "" {
+			hosts = append(hosts, host)
+		}
 	}
+	return hosts
 }
 
 // ValidateHTTPTarget validates a destination URL for the DTN store-and-forward
-// path: it defaults a missing scheme to https, then enforces the same
-// scheme/host/port allowlist the SOCKS path uses. Returns the normalized URL.
+// path: it defaults a missing scheme to https, then applies ValidateDestination
+// to the URL's scheme, host and port. Returns the normalized URL.
 func (s *SecurityValidator) ValidateHTTPTarget(raw string) (string, error) {
 	if raw == "" {
 		return "", fmt.Errorf("url is required")
@@ -116,27 +144,23 @@ func (s *SecurityValidator) ValidateHTTPTarget(raw string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("invalid url: %v", err)
 	}
-	if !s.allowedSchemes[strings.ToLower(u.Scheme)] {
-		return "", fmt.Errorf("scheme %q is not allowed", u.Scheme)
-	}
-	host := u.Hostname()
-	// Loopback is permitted only in test mode (tests use local echo servers on
-	// arbitrary ports); in production isTestMode is false, so loopback falls
-	// through to the allowlist check and is rejected like any other IP literal.
-	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() && isTestMode.Load() {
-		return u.String(), nil
-	}
-	if p := u.Port(); p != "" && !s.allowedPorts[p] {
-		return "", fmt.Errorf("port %q is not allowed", p)
+	var port uint16
+	if p := u.Port(); p != "" {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || n == 0 {
+			return "", fmt.Errorf("invalid url: port %q", p)
+		}
+		port = uint16(n)
 	}
-	if !s.IsAllowedHost(host) {
-		return "", fmt.Errorf("host %q is not allowed", host)
+	if err := s.ValidateDestination(u.Hostname(), port, u.Scheme); err != nil {
+		return "", err
 	}
 	return u.String(), nil
 }
 
 // IsAllowedHost checks if the destination host (or its parent domain) is in the allowed list.
-// Performs case-insensitive matching.
+// Performs case-insensitive matching. A plain entry admits the host and its
+// subdomains; a "*.example.com" pattern admits only the subdomains.
 // Returns false for IP addresses (both IPv4 and IPv6).
 func (s *SecurityValidator) IsAllowedHost(host string) bool {
 	if host == "" {
@@ -144,13 +168,22 @@ func (s *SecurityValidator) IsAllowedHost(host string) bool {
 	}
 	lowerHost := strings.ToLower(host)
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	// Direct match in allowed list
 	if s.allowedHosts[lowerHost] {
 		return true
 	}
 
-	// Check if it's a subdomain of an allowed host
+	// Check if it's a subdomain of an allowed host or matches a pattern
 	for allowed := range s.allowedHosts {
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(lowerHost, allowed[1:]) {
+				return true
+			}
+			continue
+		}
 		// Ensure allowed host isn't empty and check suffix
 		if allowed != "" && strings.HasSuffix(lowerHost, "."+allowed) {
 			return true
@@ -167,32 +200,130 @@ func IsIPAddress(host string) bool {
 	return net.ParseIP(host) != nil
 }
 
-// ValidateSocksDestination checks if the SOCKS destination port is allowed.
-// Host validation is done separately using IsAllowedHost.
-func (s *SecurityValidator) ValidateSocksDestination(host string, port uint16) error {
-	// Allow loopback addresses (127.0.0.1, ::1) for testing
-	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
-		// Just validate port for loopback addresses
-		portStr := strconv.FormatUint(uint64(port), 10)
-		if port != 0 && !s.allowedPorts[portStr] {
-			return fmt.Errorf("destination port %s is not allowed", portStr)
+// Reasons a destination is refused: DestinationError.Reason, and the reason
+// label of proxy_destination_denied_total.
+const (
+	denyScheme = "scheme"     // not an allowed URL scheme
+	denyIP     = "ip_literal" // an IP address none of the exemptions cover
+	denyHost   = "host"       // not on the host allowlist
+	denyPort   = "port"       // not an allowed port for the scheme
+	// denyResolved is an allowed host name that resolved to an address an
+	// IP literal couldn't use (ValidateResolvedIP).
+	denyResolved = "resolved_ip"
+)
+
+// DestinationError is a destination refused by ValidateDestination.
+type DestinationError struct {
+	Host   string
+	Port   uint16
+	Scheme string // "" for a raw TCP or UDP destination
+	Reason string // one of the deny* constants
+	IP     net.IP // the address Host resolved to, for denyResolved
+}
+
+func (e *DestinationError) Error() string {
+	switch e.Reason {
+	case denyScheme:
+		return fmt.Sprintf("scheme %q is not allowed", e.Scheme)
+	case denyIP:
+		return fmt.Sprintf("destination %s is an IP address; use a host name", e.Host)
+	case denyHost:
+		return fmt.Sprintf("destination host '%s' is not allowed", e.Host)
+	case denyResolved:
+		return fmt.Sprintf("destination host '%s' resolves to %s, which is not allowed", e.Host, e.IP)
+	}
+	return fmt.Sprintf("destination port %d is not allowed", e.Port)
+}
+
+// ValidateDestination is the destination policy every proxy path applies:
+// SOCKS CONNECT and UDP, -tcp-forward, DTN fetches (and each redirect hop)
+// and gopher proxy selectors.
+//
+// IP literals are refused, except loopback with allowLoopback (on any port)
+// and private addresses with -allow-private; anything else must be on the
+// host allowlist. scheme is "" for a raw TCP or UDP destination, whose port
+// must be on the port allowlist; port 0 (a BIND, or an unspecified UDP
+// port) passes. For "http" and "https" the scheme must be allowed and the
+// port, 0 meaning the scheme's default, must be 80 or 443 or one of the
+// configured http_ports: the raw port allowlist doesn't apply, so a web
+// fetch can't be pointed at a mail or database port the raw list opens.
+//
+// A refusal is a *DestinationError.
+func (s *SecurityValidator) ValidateDestination(host string, port uint16, scheme string) error {
+	scheme = strings.ToLower(scheme)
+	deny := func(reason string) error {
+		return &DestinationError{Host: host, Port: port, Scheme: scheme, Reason: reason}
+	}
+	if scheme != "" && !s.allowedSchemes[scheme] {
+		return deny(denyScheme)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsLoopback() && s.allowLoopback {
+			return nil
+		}
+		if !s.allowsPrivateIP(ip) {
+			return deny(denyIP)
+		}
+	} else if !s.IsAllowedHost(host) {
+		return deny(denyHost)
+	}
+	if scheme == "" {
+		if port != 0 && !s.isAllowedPort(strconv.FormatUint(uint64(port), 10)) {
+			return deny(denyPort)
 		}
 		return nil
 	}
+	if !s.isAllowedHTTPPort(port) {
+		return deny(denyPort)
+	}
+	return nil
+}
+
+// ValidateSocksDestination applies ValidateDestination to a raw TCP or UDP
+// destination.
+func (s *SecurityValidator) ValidateSocksDestination(host string, port uint16) error {
+	return s.ValidateDestination(host, port, "")
+}
 
-	// Validate host first
-	if !s.IsAllowedHost(host) {
-		return fmt.Errorf("destination host '%s' is not allowed", host)
+// ValidateResolvedIP re-checks an address that host, already admitted by
+// ValidateDestination, resolved to, so an allowlisted name can't be pointed
+// at the proxy's own network. The address must be one an IP literal could
+// use: loopback only with allowLoopback, a private address only with
+// -allow-private, and otherwise a public unicast address - never
+// link-local (the cloud metadata address among them), multicast or
+// unspecified. A refusal is a *DestinationError with Reason denyResolved.
+func (s *SecurityValidator) ValidateResolvedIP(host string, port uint16, ip net.IP) error {
+	switch {
+	case ip.IsLoopback():
+		if s.allowLoopback {
+			return nil
+		}
+	case ip.IsPrivate():
+		if s.allowsPrivateIP(ip) {
+			return nil
+		}
+	case ip.IsGlobalUnicast():
+		return nil
 	}
+	return &DestinationError{Host: host, Port: port, Reason: denyResolved, IP: ip}
+}
 
-	// Validate port
-	portStr := strconv.FormatUint(uint64(port), 10)
-	// Allow port 0 (often used in BIND requests)
-	if port != 0 && !s.allowedPorts[portStr] {
-		return fmt.Errorf("destination port %s is not allowed", portStr)
+// recordDenial counts err in proxy_destination_denied_total under protocol
+// (one of the proto* constants) if it is a *DestinationError. Other errors,
+// such as a malformed URL, aren't policy decisions and aren't counted.
+func recordDenial(metrics Metrics, protocol string, err error) {
+	var d *DestinationError
+	if errors.As(err, &d) {
+		orNop(metrics).RecordDestinationDenied(protocol, d.Reason)
 	}
+}
 
-	return nil
+// allowsPrivateIP reports whether ip is a private-network address (RFC 1918,
+// or an IPv6 unique local address) and -allow-private is set. Loopback and
+// link-local addresses, cloud metadata at 169.254.169.254 among them, are
+// never private in this sense.
+func (s *SecurityValidator) allowsPrivateIP(ip net.IP) bool {
+	return s.allowPrivate && ip.IsPrivate()
 }
 
 // IsAllowedIP checks if a client IP address is allowed to use the proxy.
@@ -205,6 +336,8 @@ func (s *SecurityValidator) IsAllowedIP(ip string) bool {
 // AllowedHosts returns the sorted list of allowlisted destination hosts.
 // Used to render the live allowlist (e.g. the /_debug/allowed-hosts endpoint).
 func (s *SecurityValidator) AllowedHosts() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	hosts := make([]string, 0, len(s.allowedHosts))
 	for h := range s.allowedHosts {
 		hosts = append(hosts, h)
@@ -213,14 +346,57 @@ func (s *SecurityValidator) AllowedHosts() []string {
 	return hosts
 }
 
-// AllowedPorts returns the sorted list of allowlisted destination ports.
+// AllowedPorts returns the sorted list of allowlisted destination ports,
+// with ranges as "lo-hi".
 func (s *SecurityValidator) AllowedPorts() []string {
-	ports := make([]string, 0, len(s.allowedPorts))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ports := make([]string, 0, len(s.allowedPorts)+len(s.portRanges))
 	for p := range s.allowedPorts {
 		if p != "" {
 			ports = append(ports, p)
 		}
 	}
+	for _, r := range s.portRanges {
+		ports = append(ports, r.String())
+	}
 	sort.Strings(ports)
 	return ports
 }
+
+// isAllowedPort reports whether port (decimal, or "" for the scheme default)
+// is allowlisted individually or by a range.
+func (s *SecurityValidator) isAllowedPort(port string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.allowedPorts[port] {
+		return true
+	}
+	if n, err := strconv.Atoi(port); err == nil {
+		for _, r := range s.portRanges {
+			if r.contains(n) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// defaultHTTPPorts are the ports an http(s) destination may always use.
+var defaultHTTPPorts = []string{"80", "443"}
+
+// isAllowedHTTPPort reports whether an http(s) destination may use port, 0
+// being the scheme's default.
+func (s *SecurityValidator) isAllowedHTTPPort(port uint16) bool {
+	if port == 0 || port == 80 || port == 443 {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.httpPorts {
+		if r.contains(int(port)) {
+			return true
+		}
+	}
+	return false
+}
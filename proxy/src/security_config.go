@@ -0,0 +1,436 @@
+// security_config.go - runtime-editable, persistent destination allow-lists.
+//
+// With -security-config set, the host and port allow-lists come from a JSON
+// file instead of the built-in defaults in security.go:
+//
+//	{"hosts": ["example.com", "*.example.org"], "ports": ["443", "8000-8100"],
+//	 "http_ports": ["8443"]}
+//
+// A plain host admits itself and its subdomains; "*.example.org" admits only
+// the subdomains. ports apply to raw TCP and UDP destinations (SOCKS,
+// -tcp-forward, gopher); http(s) destinations (DTN) may use 80 and 443, plus
+// any http_ports. A missing file starts from the defaults and is created on
+// the first edit. ALLOWED_HOSTS is still merged on top of whichever is used.
+//
+// The admin GET /_debug/security returns the live lists and the port policy
+// they add up to for each kind of destination, and POST applies
+//
+//	{"add": {"hosts": [...], "ports": [...], "http_ports": [...]}, "remove": {...}}
+//
+// writing the result back to the file atomically. An edit that fails
+// validation or can't be saved changes nothing. Entries that would open the
+// proxy to everything - "*", a bare TLD, 0.0.0.0/0, port 0 - are rejected.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SecurityConfig is the allow-list file format, and the GET /_debug/security
+// response.
+type SecurityConfig struct {
+	Hosts     []string `json:"hosts"`
+	Ports     []string `json:"ports"`
+	HTTPPorts []string `json:"http_ports,omitempty"`
+}
+
+// SecurityStatus is the GET and POST /_debug/security response: the lists,
+// and the policy ValidateDestination applies with them.
+type SecurityStatus struct {
+	SecurityConfig
+	Policy PortPolicy `json:"policy"`
+}
+
+// PortPolicy is the effective destination port policy.
+type PortPolicy struct {
+	Raw           []string `json:"raw"`  // SOCKS CONNECT and UDP, -tcp-forward, gopher
+	HTTP          []string `json:"http"` // DTN fetches and their redirects
+	AllowLoopback bool     `json:"allow_loopback"`
+	AllowPrivate  bool     `json:"allow_private"`
+}
+
+// SecurityEdit is the POST /_debug/security request body. Removals are
+// applied before additions.
+type SecurityEdit struct {
+	Add    SecurityConfig `json:"add"`
+	Remove SecurityConfig `json:"remove"`
+}
+
+// portRange is an inclusive range of allowed destination ports.
+type portRange struct{ lo, hi int }
+
+func (r portRange) contains(port int) bool { return port >= r.lo && port <= r.hi }
+
+func (r portRange) String() string { return fmt.Sprintf("%d-%d", r.lo, r.hi) }
+
+// entry renders r in the file format: "443" or "8000-8100".
+func (r portRange) entry() string {
+	if r.lo == r.hi {
+		return strconv.Itoa(r.lo)
+	}
+	return r.String()
+}
+
+// normalizeHostEntry lowercases an allow-list host or "*.domain" pattern and
+// rejects anything malformed or broad enough to make this an open proxy.
+func normalizeHostEntry(entry string) (string, error) {
+	h := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+	if strings.Contains(h, "/") {
+		return "", fmt.Errorf("host %q: CIDR ranges are not supported", entry)
+	}
+	if ip := net.ParseIP(h); ip != nil {
+		if ip.IsUnspecified() {
+			return "", fmt.Errorf("host %q would allow every address", entry)
+		}
+		return h, nil
+	}
+	name := strings.TrimPrefix(h, "*.")
+	if name == "" || h == "*" {
+		return "", fmt.Errorf("host %q would allow every host", entry)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || strings.Trim(label, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+			return "", fmt.Errorf("host %q is not a hostname or *.domain pattern", entry)
+		}
+	}
+	// A plain entry admits its subdomains too, so "com" is as broad as "*.com".
+	if !strings.Contains(name, ".") && name != "localhost" {
+		return "", fmt.Errorf("host %q would allow a whole top-level domain", entry)
+	}
+	return h, nil
+}
+
+// parsePortEntry parses "443" or "8000-8100". A single port comes back as a
+// range of one.
+func parsePortEntry(entry string) (portRange, error) {
+	e := strings.TrimSpace(entry)
+	lo, hi, isRange := strings.Cut(e, "-")
+	if !isRange {
+		hi = lo
+	}
+	a, errA := strconv.Atoi(strings.TrimSpace(lo))
+	b, errB := strconv.Atoi(strings.TrimSpace(hi))
+	if errA != nil || errB != nil || a < 1 || b > 65535 || a > b {
+		return portRange{}, fmt.Errorf("port %q: want a port or range within 1-65535", entry)
+	}
+	return portRange{a, b}, nil
+}
+
+// securityLists is a copy of the validator's editable state.
+type securityLists struct {
+	hosts  map[string]bool
+	ports  map[string]bool
+	ranges []portRange
+	http   []portRange // http_ports
+}
+
+// listsLocked copies the current lists; s.mu must be held.
+func (s *SecurityValidator) listsLocked() securityLists {
+	l := securityLists{
+		hosts:  make(map[string]bool, len(s.allowedHosts)),
+		ports:  make(map[string]bool, len(s.allowedPorts)),
+		ranges: append([]portRange(nil), s.portRanges...),
+		http:   append([]portRange(nil), s.httpPorts...),
+	}
+	for h, ok := range s.allowedHosts {
+		l.hosts[h] = ok
+	}
+	for p, ok := range s.allowedPorts {
+		l.ports[p] = ok
+	}
+	return l
+}
+
+func (l *securityLists) addPort(r portRange) {
+	if r.lo == r.hi {
+		l.ports[strconv.Itoa(r.lo)] = true
+		return
+	}
+	for _, have := range l.ranges {
+		if have == r {
+			return
+		}
+	}
+	l.ranges = append(l.ranges, r)
+}
+
+func (l *securityLists) removePort(r portRange) bool {
+	if r.lo == r.hi {
+		key := strconv.Itoa(r.lo)
+		if !l.ports[key] {
+			return false
+		}
+		delete(l.ports, key)
+		return true
+	}
+	for i, have := range l.ranges {
+		if have == r {
+			l.ranges = append(l.ranges[:i], l.ranges[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (l *securityLists) addHTTPPort(r portRange) {
+	for _, have := range l.http {
+		if have == r {
+			return
+		}
+	}
+	l.http = append(l.http, r)
+}
+
+func (l *securityLists) removeHTTPPort(r portRange) bool {
+	for i, have := range l.http {
+		if have == r {
+			l.http = append(l.http[:i], l.http[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// config renders l in the file format, sorted.
+func (l securityLists) config() SecurityConfig {
+	c := SecurityConfig{Hosts: []string{}, Ports: []string{}}
+	for h := range l.hosts {
+		if h != "" {
+			c.Hosts = append(c.Hosts, h)
+		}
+	}
+	for p := range l.ports {
+		if p != "" {
+			c.Ports = append(c.Ports, p)
+		}
+	}
+	for _, r := range l.ranges {
+		c.Ports = append(c.Ports, r.String())
+	}
+	for _, r := range l.http {
+		c.HTTPPorts = append(c.HTTPPorts, r.entry())
+	}
+	sort.Strings(c.Hosts)
+	sort.Strings(c.Ports)
+	sort.Strings(c.HTTPPorts)
+	return c
+}
+
+// listsFromConfig validates c and builds the lists it describes. The
+// implicit default port ("") is always allowed.
+func listsFromConfig(c SecurityConfig) (securityLists, error) {
+	l := securityLists{hosts: make(map[string]bool), ports: map[string]bool{"": true}}
+	for _, entry := range c.Hosts {
+		h, err := normalizeHostEntry(entry)
+		if err != nil {
+			return securityLists{}, err
+		}
+		l.hosts[h] = true
+	}
+	for _, entry := range c.Ports {
+		r, err := parsePortEntry(entry)
+		if err != nil {
+			return securityLists{}, err
+		}
+		l.addPort(r)
+	}
+	for _, entry := range c.HTTPPorts {
+		r, err := parsePortEntry(entry)
+		if err != nil {
+			return securityLists{}, err
+		}
+		l.addHTTPPort(r)
+	}
+	return l, nil
+}
+
+// setListsLocked swaps in l; s.mu must be held.
+func (s *SecurityValidator) setListsLocked(l securityLists) {
+	s.allowedHosts, s.allowedPorts, s.portRanges, s.httpPorts = l.hosts, l.ports, l.ranges, l.http
+}
+
+// Config returns the live allow-lists in the file format.
+func (s *SecurityValidator) Config() SecurityConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listsLocked().config()
+}
+
+// Status returns the live allow-lists and the port policy they make.
+func (s *SecurityValidator) Status() SecurityStatus {
+	s.mu.RLock()
+	c := s.listsLocked().config()
+	s.mu.RUnlock()
+	return s.status(c)
+}
+
+// status adds the port policy to c.
+func (s *SecurityValidator) status(c SecurityConfig) SecurityStatus {
+	return SecurityStatus{
+		SecurityConfig: c,
+		Policy: PortPolicy{
+			Raw:           c.Ports,
+			HTTP:          append(append([]string{}, defaultHTTPPorts...), c.HTTPPorts...),
+			AllowLoopback: s.allowLoopback,
+			AllowPrivate:  s.allowPrivate,
+		},
+	}
+}
+
+// UseConfigFile loads the allow-lists from path, replacing the built-in
+// defaults, and makes path the file that edits are saved to. A missing file
+// keeps the defaults; it is created by the first edit.
+func (s *SecurityValidator) UseConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.mu.Lock()
+		s.configPath = path
+		s.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var c SecurityConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	l, err := listsFromConfig(c)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, h := range envAllowedHosts() {
+		l.hosts[h] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setListsLocked(l)
+	s.configPath = path
+	return nil
+}
+
+// Apply validates and applies e, saving the result to the config file if
+// there is one. On any error the lists are left unchanged.
+func (s *SecurityValidator) Apply(e SecurityEdit) (SecurityConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l := s.listsLocked()
+	for _, entry := range e.Remove.Hosts {
+		h, err := normalizeHostEntry(entry)
+		if err != nil {
+			return SecurityConfig{}, err
+		}
+		if !l.hosts[h] {
+			return SecurityConfig{}, fmt.Errorf("host %q is not in the allow-list", entry)
+		}
+		delete(l.hosts, h)
+	}
+	for _, entry := range e.Remove.Ports {
+		r, err := parsePortEntry(entry)
+		if err != nil {
+			return SecurityConfig{}, err
+		}
+		if !l.removePort(r) {
+			return SecurityConfig{}, fmt.Errorf("port %q is not in the allow-list", entry)
+		}
+	}
+	for _, entry := range e.Remove.HTTPPorts {
+		r, err := parsePortEntry(entry)
+		if err != nil {
+			return SecurityConfig{}, err
+		}
+		if !l.removeHTTPPort(r) {
+			return SecurityConfig{}, fmt.Errorf("http port %q is not in the allow-list", entry)
+		}
+	}
+	for _, entry := range e.Add.Hosts {
+		h, err := normalizeHostEntry(entry)
+		if err != nil {
+			return SecurityConfig{}, err
+		}
+		l.hosts[h] = true
+	}
+	for _, entry := range e.Add.Ports {
+		r, err := parsePortEntry(entry)
+		if err != nil {
+			return SecurityConfig{}, err
+		}
+		l.addPort(r)
+	}
+	for _, entry := range e.Add.HTTPPorts {
+		r, err := parsePortEntry(entry)
+		if err != nil {
+			return SecurityConfig{}, err
+		}
+		l.addHTTPPort(r)
+	}
+
+	c := l.config()
+	if s.configPath != "" {
+		if err := writeSecurityConfig(s.configPath, c); err != nil {
+			return SecurityConfig{}, err
+		}
+	}
+	s.setListsLocked(l)
+	return c, nil
+}
+
+// writeSecurityConfig replaces path with c via a temp file and rename, so a
+// crash never leaves a half-written allow-list.
+func writeSecurityConfig(path string, c SecurityConfig) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("saving %s: %v", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("saving %s: %v", path, err)
+	}
+	return nil
+}
+
+// handleSecurityConfig serves the admin GET/POST /_debug/security.
+func (s *Server) handleSecurityConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.security.Status())
+	case http.MethodPost:
+		var e SecurityEdit
+		dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&e); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid edit: " + err.Error()})
+			return
+		}
+		c, err := s.security.Apply(e)
+		if err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+		log.Printf("Allow-list edited: +hosts %v +ports %v +http_ports %v -hosts %v -ports %v -http_ports %v",
+			e.Add.Hosts, e.Add.Ports, e.Add.HTTPPorts, e.Remove.Hosts, e.Remove.Ports, e.Remove.HTTPPorts)
+		writeJSON(w, http.StatusOK, s.security.status(c))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET or POST"})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestSecurityWildcardMatching checks "*.domain" patterns admit only
+// subdomains, plain entries admit the apex too, and port ranges are inclusive.
+func TestSecurityWildcardMatching(t *testing.T) {
+	s := NewSecurityValidator()
+	if _, err := s.Apply(SecurityEdit{Add: SecurityConfig{
+		Hosts: []string{"*.Example.org", "example.net"},
+		Ports: []string{"8000-8100"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for host, want := range map[string]bool{
+		"a.example.org":    true,
+		"a.b.example.org":  true,
+		"example.org":      false, // the pattern alone doesn't cover the apex
+		"badexample.org":   false,
+		"example.org.evil": false,
+		"example.net":      true,
+		"www.example.net":  true,
+	} {
+		if got := s.IsAllowedHost(host); got != want {
+			t.Errorf("IsAllowedHost(%q) = %v, want %v", host, got, want)
+		}
+	}
+
+	// A plain entry alongside the pattern admits the apex as well.
+	if _, err := s.Apply(SecurityEdit{Add: SecurityConfig{Hosts: []string{"example.org"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsAllowedHost("example.org") || !s.IsAllowedHost("a.example.org") {
+		t.Error("plain entry plus pattern should admit apex and subdomains")
+	}
+
+	for port, ok := range map[uint16]bool{7999: false, 8000: true, 8050: true, 8100: true, 8101: false, 443: true} {
+		if err := s.ValidateSocksDestination("example.net", port); (err == nil) != ok {
+			t.Errorf("port %d: got %v, want allowed=%v", port, err, ok)
+		}
+	}
+	if !strings.Contains(strings.Join(s.AllowedPorts(), ","), "8000-8100") {
+		t.Errorf("AllowedPorts = %v, want the range listed", s.AllowedPorts())
+	}
+}
+
+func TestSecurityRejectsDangerousEntries(t *testing.T) {
+	s := NewSecurityValidator()
+	before := s.Config()
+
+	for _, e := range []SecurityEdit{
+		{Add: SecurityConfig{Hosts: []string{"*"}}},
+		{Add: SecurityConfig{Hosts: []string{"0.0.0.0/0"}}},
+		{Add: SecurityConfig{Hosts: []string{"0.0.0.0"}}},
+		{Add: SecurityConfig{Hosts: []string{"::"}}},
+		{Add: SecurityConfig{Hosts: []string{"*.com"}}},
+		{Add: SecurityConfig{Hosts: []string{"com"}}},
+		{Add: SecurityConfig{Hosts: []string{"ex ample.com"}}},
+		{Add: SecurityConfig{Hosts: []string{"a.*.example.com"}}},
+		{Add: SecurityConfig{Ports: []string{"0"}}},
+		{Add: SecurityConfig{Ports: []string{"0-100"}}},
+		{Add: SecurityConfig{Ports: []string{"70000"}}},
+		{Add: SecurityConfig{Ports: []string{"9000-8000"}}},
+		{Remove: SecurityConfig{Hosts: []string{"not-listed.example"}}},
+		// One bad entry rejects the whole edit.
+		{Add: SecurityConfig{Hosts: []string{"fine.example", "*"}}},
+	} {
+		if _, err := s.Apply(e); err == nil {
+			t.Errorf("%+v: expected an error", e)
+		}
+	}
+	if after := s.Config(); !reflect.DeepEqual(after, before) {
+		t.Error("rejected edits changed the allow-lists")
+	}
+}
+
+// TestSecurityConcurrentEdits edits the lists while handlers read them; run
+// with -race.
+func TestSecurityConcurrentEdits(t *testing.T) {
+	s := NewSecurityValidator()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				s.IsAllowedHost("www.github.com")
+				s.ValidateSocksDestination("github.com", 8443)
+				s.ValidateHTTPTarget("https://a.flip.example:8443/")
+				s.AllowedPorts()
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		edit := SecurityConfig{Hosts: []string{"*.flip.example"}, Ports: []string{"8443", "9000-9100"}}
+		if _, err := s.Apply(SecurityEdit{Add: edit}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Apply(SecurityEdit{Remove: edit}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if !s.IsAllowedHost("github.com") || s.IsAllowedHost("a.flip.example") {
+		t.Error("lists wrong after concurrent edits")
+	}
+}
+
+// TestSecurityConfigPersistence edits the lists, then loads the file into a
+// fresh validator as a restart would.
+func TestSecurityConfigPersistence(t *testing.T) {
+	os.Unsetenv("ALLOWED_HOSTS")
+	path := filepath.Join(t.TempDir(), "security.json")
+
+	s := NewSecurityValidator()
+	if err := s.UseConfigFile(path); err != nil {
+		t.Fatalf("missing file should keep the defaults: %v", err)
+	}
+	if !s.IsAllowedHost("github.com") {
+		t.Fatal("defaults lost")
+	}
+	if _, err := s.Apply(SecurityEdit{
+		Add:    SecurityConfig{Hosts: []string{"*.internal.example"}, Ports: []string{"2222", "6000-6010"}},
+		Remove: SecurityConfig{Hosts: []string{"github.com"}, Ports: []string{"8080"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temp file left behind")
+	}
+
+	restarted := NewSecurityValidator()
+	if err := restarted.UseConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restarted.Config(), s.Config()) {
+		t.Errorf("after restart got %+v, want %+v", restarted.Config(), s.Config())
+	}
+	if restarted.IsAllowedHost("github.com") || !restarted.IsAllowedHost("db.internal.example") {
+		t.Error("edits not persisted")
+	}
+	if restarted.ValidateSocksDestination("db.internal.example", 8080) == nil || restarted.ValidateSocksDestination("db.internal.example", 6005) != nil {
+		t.Error("port edits not persisted")
+	}
+
+	// A hand-written file replaces the built-in defaults.
+	if err := os.WriteFile(path, []byte(`{"hosts": ["only.example"], "ports": ["443"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fresh := NewSecurityValidator()
+	if err := fresh.UseConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if fresh.IsAllowedHost("google.com") || !fresh.IsAllowedHost("only.example") {
+		t.Errorf("file should replace the defaults, got %v", fresh.AllowedHosts())
+	}
+	if _, err := fresh.ValidateHTTPTarget("only.example"); err != nil {
+		t.Errorf("default port should stay allowed: %v", err)
+	}
+
+	for _, bad := range []string{`{"hosts": ["*"]}`, `{"hosts": [], "portz": []}`, `{"hosts": [`} {
+		if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewSecurityValidator().UseConfigFile(path); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
+
+func TestSecurityEndpoint(t *testing.T) {
+	os.Unsetenv("ALLOWED_HOSTS")
+	path := filepath.Join(t.TempDir(), "security.json")
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), adminToken: "t0k"}
+	if err := s.security.UseConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://latency.space/_debug/security", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("no token: expected 404, got %d", rec.Code)
+	}
+	rec := do(http.MethodGet, "t0k", "")
+	var c SecurityStatus
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &c) != nil || len(c.Hosts) == 0 {
+		t.Fatalf("GET: %d %s", rec.Code, rec.Body.String())
+	}
+	if !reflect.DeepEqual(c.Policy.HTTP, []string{"80", "443"}) || !reflect.DeepEqual(c.Policy.Raw, c.Ports) {
+		t.Errorf("GET policy: %+v", c.Policy)
+	}
+
+	rec = do(http.MethodPost, "t0k", `{"add": {"hosts": ["*.lab.example"], "ports": ["9443"]}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST: %d %s", rec.Code, rec.Body.String())
+	}
+	if !s.security.IsAllowedHost("x.lab.example") || s.security.ValidateSocksDestination("x.lab.example", 9443) != nil {
+		t.Error("edit not applied")
+	}
+	rec = do(http.MethodPost, "t0k", `{"add": {"http_ports": ["8443"]}}`)
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &c) != nil || !reflect.DeepEqual(c.Policy.HTTP, []string{"80", "443", "8443"}) {
+		t.Errorf("POST http_ports: %d %s", rec.Code, rec.Body.String())
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(saved), `"*.lab.example"`) || !strings.Contains(string(saved), `"9443"`) {
+		t.Errorf("edit not saved: %v %s", err, saved)
+	}
+
+	for body, want := range map[string]int{
+		`{"add": {"hosts": ["0.0.0.0/0"]}}`: http.StatusUnprocessableEntity,
+		`{"add": {"ports": ["0"]}}`:         http.StatusUnprocessableEntity,
+		`{"append": {"hosts": ["a.b"]}}`:    http.StatusBadRequest,
+		`not json`:                          http.StatusBadRequest,
+	} {
+		if rec := do(http.MethodPost, "t0k", body); rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, rec.Code)
+		}
+	}
+	if rec := do(http.MethodDelete, "t0k", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: expected 405, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestValidateDestination(t *testing.T) {
+	s := NewSecurityValidator()
+	s.allowPrivate = true
+	for _, tc := range []struct {
+		host   string
+		port   uint16
+		scheme string
+		reason string // "" if allowed
+	}{
+		{"github.com", 443, "", ""},
+		{"github.com", 8080, "", ""},
+		{"github.com", 0, "", ""},
+		{"github.com", 6379, "", denyPort},
+		{"github.com", 0, "https", ""},
+		{"github.com", 443, "https", ""},
+		{"github.com", 80, "http", ""},
+		{"github.com", 8080, "http", denyPort}, // on the raw list, not the http one
+		{"github.com", 443, "ftp", denyScheme},
+		{"evil.not-listed.example", 443, "", denyHost},
+		{"evil.not-listed.example", 443, "https", denyHost},
+		{"1.1.1.1", 443, "", denyIP},
+		{"1.1.1.1", 443, "https", denyIP},
+		{"127.0.0.1", 443, "", denyIP},
+		{"10.0.0.5", 8080, "", ""},
+		{"10.0.0.5", 22, "", denyPort},
+		{"10.0.0.5", 8080, "http", denyPort},
+	} {
+		err := s.ValidateDestination(tc.host, tc.port, tc.scheme)
+		var d *DestinationError
+		switch {
+		case tc.reason == "" && err != nil:
+			t.Errorf("%s %s:%d: %v", tc.scheme, tc.host, tc.port, err)
+		case tc.reason != "" && (!errors.As(err, &d) || d.Reason != tc.reason):
+			t.Errorf("%s %s:%d: %v, want a %s refusal", tc.scheme, tc.host, tc.port, err, tc.reason)
+		}
+	}
+
+	// Tests admit loopback on any port, for their echo servers.
+	s.allowLoopback = true
+	if err := s.ValidateDestination("127.0.0.1", 6379, "http"); err != nil {
+		t.Errorf("loopback with allowLoopback: %v", err)
+	}
+
+	// http_ports extends the http policy, and only it.
+	if _, err := s.Apply(SecurityEdit{Add: SecurityConfig{HTTPPorts: []string{"8443"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ValidateDestination("github.com", 8443, "https"); err != nil {
+		t.Errorf("https on an added http port: %v", err)
+	}
+	if err := s.ValidateDestination("github.com", 8443, ""); err == nil {
+		t.Error("http_ports opened a raw port")
+	}
+	if _, err := s.ValidateHTTPTarget("https://github.com:8443/"); err != nil {
+		t.Errorf("ValidateHTTPTarget on an added http port: %v", err)
+	}
+}
+
+// TestDestinationPolicyPaths drives every proxy path at an allowed and a
+// disallowed port of an allowlisted host, and checks that each refuses the
+// same way and counts it under its protocol.
+func TestDestinationPolicyPaths(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	echo := startEchoServer(t)
+	sec := newTestSecurity()
+	sec.allowedHosts["localhost"] = true
+	udpEcho := startUDPEcho(t, sec)
+	sec.allowedPorts[strconv.Itoa(echo.Port)] = true
+	if _, err := sec.Apply(SecurityEdit{Add: SecurityConfig{HTTPPorts: []string{strconv.Itoa(echo.Port)}}}); err != nil {
+		t.Fatal(err)
+	}
+	const closed = 6379
+	metrics := NewRecordingMetrics()
+	denied := func(protocol string) float64 {
+		return metrics.Count(metricDestinationDenied, protocol, denyPort)
+	}
+
+	t.Run("socks connect", func(t *testing.T) {
+		if rep := socksConnectTo(t, sec, metrics, "localhost", uint16(echo.Port)); rep != SOCKS5_REP_SUCCESS {
+			t.Errorf("allowed port: reply %d", rep)
+		}
+		if rep := socksConnectTo(t, sec, metrics, "localhost", closed); rep != SOCKS5_REP_CONN_NOT_ALLOWED {
+			t.Errorf("disallowed port: reply %d, want %d", rep, SOCKS5_REP_CONN_NOT_ALLOWED)
+		}
+		if n := denied(protoSOCKSTCP); n != 1 {
+			t.Errorf("%s{socks_tcp, port} = %v, want 1", metricDestinationDenied, n)
+		}
+	})
+
+	t.Run("socks udp", func(t *testing.T) {
+		rep, relay := udpAssociateWith(t, sec, metrics, func(*SOCKSHandler) {})
+		if rep != SOCKS5_REP_SUCCESS {
+			t.Fatalf("UDP ASSOCIATE: reply %d", rep)
+		}
+		client := listenUDPClient(t)
+		send := func(port uint16) int {
+			pkt := append([]byte{0, 0, 0, SOCKS5_ADDR_DOMAIN, byte(len("localhost"))}, "localhost"...)
+			pkt = append(binary.BigEndian.AppendUint16(pkt, port), "ping"...)
+			client.WriteTo(pkt, relay)
+			return countEchoes(client, 200*time.Millisecond)
+		}
+		if n := send(uint16(udpEcho.Port)); n != 1 {
+			t.Errorf("allowed port: %d echoes, want 1", n)
+		}
+		if n := send(closed); n != 0 {
+			t.Errorf("disallowed port: %d echoes, want 0", n)
+		}
+		if n := denied(protoSOCKSUDP); n != 1 {
+			t.Errorf("%s{socks_udp, port} = %v, want 1", metricDestinationDenied, n)
+		}
+	})
+
+	t.Run("tcp forward", func(t *testing.T) {
+		for port, allowed := range map[int]bool{echo.Port: true, closed: false} {
+			f := NewTCPForwarder(tcpForward{Body: "mars", Listen: "127.0.0.1:0", Dest: net.JoinHostPort("localhost", strconv.Itoa(port))}, sec, metrics)
+			err := f.Listen()
+			if err == nil {
+				f.listener.Close()
+			}
+			var d *DestinationError
+			if allowed && err != nil || !allowed && (!errors.As(err, &d) || d.Reason != denyPort) {
+				t.Errorf("port %d: %v", port, err)
+			}
+		}
+		if n := denied(protoTCPForward); n != 1 {
+			t.Errorf("%s{tcp_forward, port} = %v, want 1", metricDestinationDenied, n)
+		}
+	})
+
+	t.Run("dtn", func(t *testing.T) {
+		s := &Server{security: sec, metrics: metrics, httpEnabled: true, sizeLimits: defaultSizeLimits}
+		s.dtn = NewDTNStore(t.TempDir()+"/dtn.json", sec, metrics)
+		s.timing = fixedLatency(testLatency)
+		for port, want := range map[int]int{echo.Port: http.StatusAccepted, 8080: http.StatusForbidden} {
+			body := `{"url": "http://localhost:` + strconv.Itoa(port) + `/"}`
+			if code, out := dtnSend(t, s, "mars.latency.space", body); code != want {
+				t.Errorf("port %d: %d %v, want %d", port, code, out, want)
+			}
+		}
+		if n := denied(protoHTTP); n != 1 {
+			t.Errorf("%s{http, port} = %v, want 1", metricDestinationDenied, n)
+		}
+	})
+
+	t.Run("gopher", func(t *testing.T) {
+		g := NewGopherServer("127.0.0.1:0", "gopher.test", sec, nil, metrics)
+		g.timing = fixedLatency(time.Millisecond)
+		if _, err := g.proxyTarget("Mars", "localhost:"+strconv.Itoa(echo.Port)+"/"); err != nil {
+			t.Errorf("allowed port: %v", err)
+		}
+		var d *DestinationError
+		if _, err := g.proxyTarget("Mars", "localhost:6379/"); !errors.As(err, &d) || d.Reason != denyPort {
+			t.Errorf("disallowed port: %v", err)
+		}
+		if n := denied(protoGopher); n != 1 {
+			t.Errorf("%s{gopher, port} = %v, want 1", metricDestinationDenied, n)
+		}
+	})
+}
+
+// socksConnectTo runs a SOCKS CONNECT to host:port through a Mars handler and
+// returns the reply code.
+func socksConnectTo(t *testing.T, security *SecurityValidator, metrics Metrics, host string, port uint16) byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h := NewSOCKSHandler(server, security, metrics, "Mars", nil)
+		h.timing = fixedLatency(time.Millisecond)
+		h.Handle()
+	}()
+	defer func() {
+		client.Close()
+		<-done
+	}()
+
+	client.Write([]byte{SOCKS5_VERSION, 1, SOCKS5_NO_AUTH})
+	if _, err := io.ReadFull(client, make([]byte, 2)); err != nil {
+		t.Fatalf("read auth choice: %v", err)
+	}
+	req := append([]byte{SOCKS5_VERSION, SOCKS5_CMD_CONNECT, 0x00, SOCKS5_ADDR_DOMAIN, byte(len(host))}, host...)
+	client.Write(binary.BigEndian.AppendUint16(req, port))
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("read CONNECT reply: %v", err)
+	}
+	return reply[1]
+}
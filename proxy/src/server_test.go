@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestServerStartEphemeralPorts boots the whole Server on 127.0.0.1:0 and
+// drives HTTP and SOCKS end to end, then checks Stop ends Serve.
+func TestServerStartEphemeralPorts(t *testing.T) {
+	t.Setenv("METRICS_ADDR", "-")
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	origTemplate := infoTemplate
+	defer func() { infoTemplate = origTemplate }()
+	infoTemplate = template.Must(template.ParseFiles("templates/info_page.html"))
+
+	s := &Server{
+		httpAddr:           "127.0.0.1:0",
+		socksAddr:          "127.0.0.1:0",
+		metrics:            NewRecordingMetrics(),
+		security:           newTestSecurity(),
+		limiter:            NewRateLimiter(6000, 100, 100, 100),
+		recent:             NewRecentLog(16, false),
+		udpLimits:          defaultUDPLimits,
+		sizeLimits:         defaultSizeLimits,
+		httpEnabled:        true,
+		socksEnabled:       true,
+		fixedCelestialBody: "Mars",
+		timing:             fixedLatency(5 * time.Millisecond),
+	}
+	if err := s.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if s.httpsListener != nil {
+		t.Error("HTTPS should be off with an empty -https-addr")
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve() }()
+	defer s.Stop()
+
+	httpPort := s.httpListener.Addr().(*net.TCPAddr).Port
+	socksPort := s.socksListener.Addr().(*net.TCPAddr).Port
+
+	// HTTP: the info page advertises the ports actually bound.
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/", httpPort), nil)
+	req.Host = "mars.latency.space"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET info page: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("info page: %d", resp.StatusCode)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("--socks5-hostname mars.latency.space:%d ", socksPort),
+		fmt.Sprintf("Port <code>%d</code>", socksPort),
+		fmt.Sprintf("http://mars.latency.space:%d/dtn/send", httpPort),
+		fmt.Sprintf(`href="http://phobos.mars.latency.space:%d/"`, httpPort),
+	} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("info page missing %q", want)
+		}
+	}
+
+	resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/readyz", httpPort))
+	if err != nil {
+		t.Fatalf("GET /readyz: %v", err)
+	}
+	ready, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/readyz: %d %s", resp.StatusCode, ready)
+	}
+
+	// SOCKS: CONNECT through the bound listener to an echo server.
+	echo := startEchoServer(t)
+	client, err := net.Dial("tcp", s.socksListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(3 * time.Second))
+	client.Write([]byte{SOCKS5_VERSION, 1, SOCKS5_NO_AUTH})
+	if _, err := io.ReadFull(client, make([]byte, 2)); err != nil {
+		t.Fatalf("greeting: %v", err)
+	}
+	connect := append([]byte{SOCKS5_VERSION, SOCKS5_CMD_CONNECT, 0, SOCKS5_ADDR_IPV4}, echo.IP.To4()...)
+	connect = binary.BigEndian.AppendUint16(connect, uint16(echo.Port))
+	client.Write(connect)
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != SOCKS5_REP_SUCCESS {
+		t.Fatalf("connect: reply %x, err %v", reply, err)
+	}
+	client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo: %q, %v", buf, err)
+	}
+	client.Close()
+
+	s.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Stop")
+	}
+	if _, err := net.DialTimeout("tcp", s.socksListener.Addr().String(), time.Second); err == nil {
+		t.Error("SOCKS listener still accepting after Stop")
+	}
+}
+
+// TestServerListenErrors checks a bad address fails Listen and releases
+// the listeners already bound.
+func TestServerListenErrors(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	s := &Server{httpAddr: "127.0.0.1:0", socksAddr: busy.Addr().String(),
+		httpEnabled: true, socksEnabled: true}
+	if err := s.Listen(); err == nil {
+		t.Fatal("expected an error for a SOCKS address in use")
+	}
+	if _, err := net.DialTimeout("tcp", s.httpListener.Addr().String(), time.Second); err == nil {
+		t.Error("the HTTP listener should be closed after a failed Listen")
+	}
+
+	// Empty addresses disable listeners individually.
+	s = &Server{httpEnabled: true, socksEnabled: true, socksAddr: "127.0.0.1:0"}
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.socksListener.Close()
+	if s.httpListener != nil || s.httpsListener != nil {
+		t.Error("empty HTTP/HTTPS addresses should bind nothing")
+	}
+}
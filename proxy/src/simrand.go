@@ -0,0 +1,34 @@
+// simrand.go - the seedable random source for simulated link effects.
+//
+// Every stochastic part of the link model draws from this one source, so a
+// fixed -sim-seed makes a run reproducible: tests seed it to assert exact
+// drop and reorder patterns. The default seed is the start time.
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	simRandMu sync.Mutex
+	simRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// seedSimRand reseeds the shared source; 0 seeds from the clock.
+func seedSimRand(seed int64) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	simRandMu.Lock()
+	simRand = rand.New(rand.NewSource(seed))
+	simRandMu.Unlock()
+}
+
+// simFloat64 returns a value in [0, 1) from the shared source.
+func simFloat64() float64 {
+	simRandMu.Lock()
+	defer simRandMu.Unlock()
+	return simRand.Float64()
+}
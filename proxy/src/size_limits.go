@@ -0,0 +1,103 @@
+// size_limits.go - caps on how many bytes one request or session may move.
+//
+// Without them a client could POST gigabytes to voyager-1.latency.space for
+// the store-and-forward path to buffer, have it fetch an unbounded response,
+// or keep a SOCKS tunnel busy for hours. The caps:
+//
+//   - request body, by body class: spacecraft 1 MB (-request-max-size-spacecraft),
+//     everything else 10 MB (-request-max-size). Over it is a 413 with code
+//     REQUEST_TOO_LARGE.
+//   - response body, per request: 500 MB (-response-max-size). The rest is
+//     dropped, and the delivered response carries the X-Latency-Space-Truncated
+//     trailer.
+//   - SOCKS CONNECT, per session: 1 GB in both directions together
+//     (-socks-session-max-size). The tunnel is closed once it is passed.
+//
+// Each cap that trips is counted in proxy_size_limit_exceeded_total, and
+// /_debug/limits lists them all. Zero disables a cap, except the response
+// cap: responses are held in memory and on disk, so they always have one.
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// SizeLimits caps the bytes a single request or session may move. Zero
+// disables a cap.
+type SizeLimits struct {
+	RequestBytes           int64 `json:"requestBytes"`           // request body via a natural body
+	SpacecraftRequestBytes int64 `json:"spacecraftRequestBytes"` // request body via a spacecraft
+	ResponseBytes          int64 `json:"responseBytes"`          // response body per request
+	SOCKSSessionBytes      int64 `json:"socksSessionBytes"`      // both directions of a SOCKS CONNECT session
+}
+
+// defaultSizeLimits applies when no flags are given.
+var defaultSizeLimits = SizeLimits{
+	RequestBytes:           10 << 20,
+	SpacecraftRequestBytes: 1 << 20,
+	ResponseBytes:          500 << 20,
+	SOCKSSessionBytes:      1 << 30,
+}
+
+// requestTooLargeCode is the structured error code for an oversized request body.
+const requestTooLargeCode = "REQUEST_TOO_LARGE"
+
+// Size limit kinds, used as the "limit" metric label.
+const (
+	sizeLimitRequest      = "request"
+	sizeLimitResponse     = "response"
+	sizeLimitSOCKSSession = "socks_session"
+)
+
+const (
+	requestAllowanceHeader  = "X-Latency-Space-Request-Allowance"  // bytes of request body left unused
+	responseAllowanceHeader = "X-Latency-Space-Response-Allowance" // bytes the response could still have grown
+	truncatedTrailer        = "X-Latency-Space-Truncated"          // set when the response was cut at the cap
+)
+
+// requestLimit returns the request body cap for body's class. An empty body
+// isn't known yet (it may come from the request itself), so it gets the
+// larger of the two caps.
+func (l SizeLimits) requestLimit(body string) int64 {
+	if body == "" {
+		if l.RequestBytes <= 0 || l.SpacecraftRequestBytes <= 0 {
+			return 0
+		}
+		return max(l.RequestBytes, l.SpacecraftRequestBytes)
+	}
+	if obj, ok := findObjectByName(getCelestialObjects(), body); ok && obj.Type == "spacecraft" {
+		return l.SpacecraftRequestBytes
+	}
+	return l.RequestBytes
+}
+
+// writeRequestTooLarge answers a request whose body passed limit bytes.
+func (s *Server) writeRequestTooLarge(w http.ResponseWriter, body string, limit int64) {
+	s.metrics.RecordSizeLimit(body, sizeLimitRequest)
+	writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{
+		"error": fmt.Sprintf("request body exceeds the %d byte limit", limit),
+		"code":  requestTooLargeCode,
+	})
+}
+
+// printLimits serves /_debug/limits: the size caps, the UDP ASSOCIATE caps
+// and the per-IP connection cap.
+func (s *Server) printLimits(w http.ResponseWriter) {
+	s.settingsMu.RLock()
+	udp := s.udpLimits
+	s.settingsMu.RUnlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"size": s.sizeLimits,
+		"udp": map[string]interface{}{
+			"packetsPerSec": udp.PacketsPerSec,
+			"bytesPerSec":   udp.BytesPerSec,
+			"maxTargets":    udp.MaxTargets,
+			"maxQueued":     udp.MaxQueued,
+		},
+		"connections": map[string]interface{}{
+			"perIP":    s.connLimit.Max(),
+			"rejected": s.connLimit.Rejected(),
+		},
+	})
+}
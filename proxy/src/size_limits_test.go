@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// TestRequestSizeLimitByClass sends the same store-and-forward request via
+// a planet and via a spacecraft, whose cap is smaller.
+func TestRequestSizeLimitByClass(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(time.Millisecond)
+	s.sizeLimits = SizeLimits{RequestBytes: 400, SpacecraftRequestBytes: 200, ResponseBytes: 1 << 20}
+	body := fmt.Sprintf(`{"url":"http://127.0.0.1:9/","payload":%q}`, strings.Repeat("x", 250))
+
+	for _, tc := range []struct {
+		host, body string
+		code       int
+		allowance  string
+	}{
+		{"mars.latency.space", body, http.StatusAccepted, fmt.Sprint(400 - len(body))},
+		{"voyager-1.latency.space", body, http.StatusRequestEntityTooLarge, ""},
+		// Only once the body has been read is "via" known to be a spacecraft.
+		{"latency.space", strings.Replace(body, "{", `{"via":"voyager-1",`, 1), http.StatusRequestEntityTooLarge, ""},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://x/dtn/send", strings.NewReader(tc.body))
+		req.Host = tc.host
+		rec := httptest.NewRecorder()
+		s.handleDTN(rec, req)
+		var out map[string]interface{}
+		_ = json.Unmarshal(rec.Body.Bytes(), &out)
+		if rec.Code != tc.code {
+			t.Errorf("%s: %d %v, want %d", tc.host, rec.Code, out, tc.code)
+		}
+		if tc.code == http.StatusRequestEntityTooLarge && out["code"] != requestTooLargeCode {
+			t.Errorf("%s: code %v", tc.host, out["code"])
+		}
+		if got := rec.Header().Get(requestAllowanceHeader); got != tc.allowance {
+			t.Errorf("%s: allowance %q, want %q", tc.host, got, tc.allowance)
+		}
+	}
+	if n := recorded(t, s.metrics).Count(metricSizeLimited, "Voyager 1", sizeLimitRequest); n != 2 {
+		t.Errorf("%v requests counted over the limit, want 2", n)
+	}
+}
+
+// TestResponseSizeLimitTruncates fetches a response longer than the cap and
+// one exactly at it: only the first is cut and marked.
+func TestResponseSizeLimitTruncates(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("y", len(r.URL.Path)*100))
+	}))
+	defer dest.Close()
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(5 * time.Millisecond)
+	s.dtn.SetMaxResponseBytes(500)
+
+	for _, tc := range []struct {
+		path      string
+		size      int
+		truncated bool
+	}{
+		{"/long-path", 500, true}, // 1000 bytes sent
+		{"/abcd", 500, false},     // exactly 500
+	} {
+		code, out := dtnSend(t, s, "mars.latency.space", fmt.Sprintf(`{"url":%q}`, dest.URL+tc.path))
+		if code != http.StatusAccepted {
+			t.Fatalf("send: %d %v", code, out)
+		}
+		rec := waitDTN(t, s, out["id"].(string))
+		var st struct {
+			Response struct {
+				Body      string `json:"body"`
+				Truncated bool   `json:"truncated"`
+			} `json:"response"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+			t.Fatal(err)
+		}
+		if len(st.Response.Body) != tc.size || st.Response.Truncated != tc.truncated {
+			t.Errorf("%s: %d bytes, truncated %v", tc.path, len(st.Response.Body), st.Response.Truncated)
+		}
+		if got, want := rec.Result().Trailer.Get(truncatedTrailer), map[bool]string{true: "true"}[tc.truncated]; got != want {
+			t.Errorf("%s: trailer %q, want %q", tc.path, got, want)
+		}
+		if got := rec.Header().Get(responseAllowanceHeader); got != "0" {
+			t.Errorf("%s: allowance %q", tc.path, got)
+		}
+	}
+	if n := recorded(t, s.metrics).Count(metricSizeLimited, "Mars", sizeLimitResponse); n != 1 {
+		t.Errorf("%v responses counted as truncated, want 1", n)
+	}
+}
+
+// TestSOCKSSessionSizeLimit streams through an echo server until the session
+// cap closes the tunnel.
+func TestSOCKSSessionSizeLimit(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	metrics := NewRecordingMetrics()
+	const limit = 64 << 10
+	proxy := startTestSOCKS(t, &Server{security: newTestSecurity(), metrics: metrics, fixedCelestialBody: "Mars",
+		sizeLimits: SizeLimits{SOCKSSessionBytes: limit}, timing: fixedLatency(time.Millisecond)})
+	echo := startEchoServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, _, err := client.Dial(ctx, proxy, echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	const sent = 1 << 20
+	go func() {
+		chunk := make([]byte, 4096)
+		for n := 0; n < sent; n += len(chunk) {
+			if _, err := conn.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+	echoed, err := io.Copy(io.Discard, conn)
+	if echoed >= sent || echoed > limit {
+		t.Errorf("%d bytes echoed back (%v); the %d byte cap should have ended the session", echoed, err, limit)
+	}
+	waitFor(t, func() bool {
+		return metrics.Count(metricSizeLimited, "Mars", sizeLimitSOCKSSession) == 1
+	})
+}
+
+// TestDebugLimits checks /_debug/limits lists the caps in effect.
+func TestDebugLimits(t *testing.T) {
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(),
+		sizeLimits: defaultSizeLimits, udpLimits: defaultUDPLimits, adminToken: "t0k"}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://latency.space/_debug/limits", nil)
+	req.Header.Set("Authorization", "Bearer t0k")
+	s.handleHTTP(rec, req)
+	var out struct {
+		Size SizeLimits `json:"size"`
+		UDP  struct {
+			MaxTargets int `json:"maxTargets"`
+		} `json:"udp"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	if out.Size != defaultSizeLimits || out.UDP.MaxTargets != defaultUDPLimits.MaxTargets {
+		t.Errorf("limits %+v", out)
+	}
+}
@@ -0,0 +1,64 @@
+// snapshot.go - one consistent view of the solar system per request.
+//
+// The objects can be swapped by an objects-file reload and the distance
+// cache refilled by any request, including a forced ?refresh=true. A
+// handler that read the globals at each step could see objects from one
+// generation and distances from another. Handlers instead take a
+// SystemSnapshot once and pass it down.
+//
+// A refresh builds a new entries slice rather than updating the old one in
+// place, so a snapshot shares the cached slice without copying it. Nothing
+// may modify a snapshot's slices.
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// SystemSnapshot is the objects and the Earth distances cached for them.
+type SystemSnapshot struct {
+	Objects    []celestial.CelestialObject
+	Entries    []DistanceEntry
+	ComputedAt time.Time // the instant the entries describe
+}
+
+// currentSnapshot refreshes the distance cache for now (or the pinned
+// epoch) if it is stale and returns the current generation.
+func currentSnapshot(now time.Time) *SystemSnapshot {
+	objects := getCelestialObjects()
+	entries, computedAt := calculateDistancesFromEarth(objects, simTime(now))
+	return &SystemSnapshot{Objects: objects, Entries: entries, ComputedAt: computedAt}
+}
+
+// Find looks an object up by name, as findObjectByName does.
+func (snap *SystemSnapshot) Find(name string) (celestial.CelestialObject, bool) {
+	return findObjectByName(snap.Objects, name)
+}
+
+// Entry returns the cached distance entry for name.
+func (snap *SystemSnapshot) Entry(name string) (DistanceEntry, bool) {
+	for _, e := range snap.Entries {
+		if strings.EqualFold(e.Object.Name, name) {
+			return e, true
+		}
+	}
+	return DistanceEntry{}, false
+}
+
+// Distance is getCurrentDistance answered from the snapshot: the light-time
+// distance to name in km, from the ground station if one has it above the
+// horizon. A body the snapshot lacks falls back to getCurrentDistance,
+// which refreshes the cache.
+func (snap *SystemSnapshot) Distance(name string) (float64, error) {
+	if strings.EqualFold(name, "Earth") {
+		return 0, nil
+	}
+	e, ok := snap.Entry(name)
+	if !ok {
+		return getCurrentDistance(name)
+	}
+	return stationDistance(e), nil
+}
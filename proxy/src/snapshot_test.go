@@ -0,0 +1,88 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestSnapshotSurvivesRefresh checks a snapshot keeps describing its own
+// generation after the cache is dropped and refilled.
+func TestSnapshotSurvivesRefresh(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	snap := currentSnapshot(distanceClock())
+	before, ok := snap.Entry("Voyager 1")
+	if !ok || len(snap.Objects) == 0 {
+		t.Fatalf("snapshot without Voyager 1: %d objects, %d entries", len(snap.Objects), len(snap.Entries))
+	}
+
+	withObjects(t, withoutBody("Voyager 1"))
+	if _, ok := currentSnapshot(distanceClock()).Entry("Voyager 1"); ok {
+		t.Fatal("the refilled cache still has Voyager 1")
+	}
+
+	if after, ok := snap.Entry("Voyager 1"); !ok || after.Distance != before.Distance {
+		t.Errorf("Voyager 1 changed under the snapshot: %v, %v", after.Distance, ok)
+	}
+	if _, ok := snap.Find("Earth"); !ok {
+		t.Error("the snapshot lost its objects")
+	}
+	if d, err := snap.Distance("Voyager 1"); err != nil || d <= 0 {
+		t.Errorf("Distance(Voyager 1) = %v, %v", d, err)
+	}
+}
+
+// TestConcurrentInfoPagesAndRefreshes renders info pages while status
+// requests force cache refreshes; run it with -race.
+func TestConcurrentInfoPagesAndRefreshes(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	origTemplate := infoTemplate
+	defer func() { infoTemplate = origTemplate }()
+	infoTemplate = template.Must(template.ParseFiles("templates/info_page.html"))
+
+	s := &Server{
+		security:       NewSecurityValidator(),
+		metrics:        NewRecordingMetrics(),
+		refreshLimiter: NewRateLimiter(1e6, 1000, 0, 0),
+	}
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if rec := get("http://mars.latency.space/"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Mars") {
+					t.Errorf("info page: %d", rec.Code)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if rec := get("http://latency.space/api/status-data?refresh=true"); rec.Code != http.StatusOK {
+					t.Errorf("status refresh: %d %s", rec.Code, rec.Body.String())
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if rec := get("http://latency.space/_debug/distances"); !strings.Contains(rec.Body.String(), "Mars") {
+					t.Errorf("distances: %s", rec.Body.String())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
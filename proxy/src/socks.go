@@ -4,6 +4,7 @@ package main
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,7 +12,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/latency-space/proxy/relay"
+	"github.com/latency-space/shared/client"
 )
 
 // SOCKS constants
@@ -50,23 +55,59 @@ const (
 type SOCKSHandler struct {
 	conn               net.Conn
 	security           *SecurityValidator
-	metrics            *MetricsCollector
-	fixedCelestialBody string // If set, use this body instead of detecting from hostname
+	metrics            Metrics
+	fixedCelestialBody string           // If set, use this body instead of detecting from hostname
+	recent             *RecentLog       // Optional ring of recent transactions (nil = not recorded)
+	access             *AccessLog       // Optional on-disk access log (nil = not logged)
+	udpLimits          UDPLimits        // Per-association caps for UDP ASSOCIATE
+	udpImpair          UDPImpairment    // Loss/reorder/duplicate rates for the UDP relay
+	sessionLimit       int64            // Bytes a CONNECT session may carry both ways (0 = unlimited)
+	tcpWindow          int              // Simulated TCP window for CONNECT relays (0 = off)
+	preflight          *Preflight       // Reachability probe before the latency sleep (nil = off)
+	remoteDNS          *RemoteDNS       // Resolves target names over the link (nil = the dialer resolves them)
+	overhead           *overheadSampler // Picks CONNECTs whose setup overhead is recorded (nil = none)
+	limiter            *RateLimiter     // Reported as quota_remaining to metadata clients (nil = unlimited)
+	trustedProxies     []*net.IPNet     // Balancers whose connections hide the client; refused (see proxyproto.go)
+	snapshots          SnapshotProvider // Objects and distances (internal_state.go; nil = the live ones)
+	timing                              // Clock and latency source (clock.go)
+
+	// meta is non-nil once the client negotiated the metadata extension
+	// (socks_metadata.go); every reply is then followed by a frame of it.
+	meta *client.Metadata
+
+	started time.Time       // Handle called; start of the handshake metric
+	replied bool            // first request reply sent (handshake observed)
+	ctx     context.Context // carries the socks.session span for per-phase spans
 }
 
 // NewSOCKSHandler creates a new SOCKS connection handler
-func NewSOCKSHandler(conn net.Conn, security *SecurityValidator, metrics *MetricsCollector, fixedBody string) *SOCKSHandler {
+func NewSOCKSHandler(conn net.Conn, security *SecurityValidator, metrics Metrics, fixedBody string, snapshots SnapshotProvider) *SOCKSHandler {
 	return &SOCKSHandler{
 		conn:               conn,
 		security:           security,
-		metrics:            metrics,
+		metrics:            orNop(metrics),
 		fixedCelestialBody: fixedBody,
+		snapshots:          snapshots,
+		udpLimits:          defaultUDPLimits,
+		sessionLimit:       defaultSizeLimits.SOCKSSessionBytes,
+		ctx:                context.Background(),
 	}
 }
 
 // Handle processes a SOCKS connection
 func (s *SOCKSHandler) Handle() {
 	defer s.conn.Close()
+	if s.started.IsZero() {
+		s.started = s.now()
+	}
+
+	// One trace per connection; the phases of the request are its children.
+	if s.ctx == nil {
+		s.ctx = context.Background()
+	}
+	var span *Span
+	s.ctx, span = startSpan(s.ctx, "socks.session", attr("net.peer", s.conn.RemoteAddr().String()))
+	defer span.End()
 
 	// Process client greeting
 	if !s.handleClientGreeting() {
@@ -75,7 +116,12 @@ func (s *SOCKSHandler) Handle() {
 
 	// Process client request
 	err := s.handleClientRequest()
+	if errors.Is(err, io.EOF) {
+		// Hung up after the greeting: a port check or the /readyz self-test.
+		return
+	}
 	if err != nil {
+		span.SetError(err)
 		log.Printf("SOCKS error: %v", err)
 	}
 }
@@ -102,7 +148,19 @@ func (s *SOCKSHandler) handleClientGreeting() bool {
 		return false
 	}
 
-	// We only support no authentication (method 0)
+	// The metadata extension is opt-in, so a client offering it gets it.
+	for _, method := range methods {
+		if method == client.MetadataMethod {
+			if _, err := s.conn.Write([]byte{SOCKS5_VERSION, client.MetadataMethod}); err != nil {
+				log.Printf("Failed to send auth method choice: %v", err)
+				return false
+			}
+			s.meta = &client.Metadata{}
+			return true
+		}
+	}
+
+	// Otherwise we only support no authentication (method 0)
 	for _, method := range methods {
 		if method == SOCKS5_NO_AUTH {
 			// Send auth method choice (no auth)
@@ -121,6 +179,8 @@ func (s *SOCKSHandler) handleClientGreeting() bool {
 		log.Printf("Failed to send auth rejection: %v", err)
 	}
 	log.Printf("No supported authentication method")
+	s.metrics.RecordSOCKSFailure(SOCKS5_AUTH_NO_ACCEPTABLE)
+	s.metrics.RecordSOCKSHandshake(false, s.since(s.started))
 	return false
 }
 
@@ -129,8 +189,11 @@ func (s *SOCKSHandler) handleClientRequest() error {
 	// Read request header
 	buf := make([]byte, 4)
 	if _, err := io.ReadFull(s.conn, buf); err != nil {
-		s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
-		return fmt.Errorf("failed to read SOCKS request: %v", err)
+		// A clean hangup (io.EOF) has nobody to reply to and is not a failure.
+		if err != io.EOF {
+			s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
+		}
+		return fmt.Errorf("failed to read SOCKS request: %w", err)
 	}
 
 	version, cmd, _, addrType := buf[0], buf[1], buf[2], buf[3]
@@ -154,10 +217,31 @@ func (s *SOCKSHandler) handleClientRequest() error {
 	}
 }
 
+// record ends a transaction in the recent ring and the access log.
+func (s *SOCKSHandler) record(tx RecentTransaction) {
+	s.recent.Record(tx)
+	s.access.LogTransaction(tx)
+}
+
 // handleConnect handles the SOCKS5 CONNECT command
 func (s *SOCKSHandler) handleConnect(addrType byte) error {
+	defer activeConnect.enter()()
+	// Every CONNECT leaves one entry in the recent-transactions ring; the
+	// outcome defaults to error and is refined at each decision point below.
+	tx := RecentTransaction{
+		Time:     s.now(),
+		ClientIP: clientIP(s.conn.RemoteAddr().String()),
+		Protocol: "socks",
+		Outcome:  outcomeError,
+	}
+	defer func() {
+		tx.Duration = s.since(tx.Time)
+		s.record(tx)
+	}()
+
 	// Read destination address based on address type
 	var dstAddr string
+	var portOverride uint16 // from a -p<port> target label
 	var err error
 
 	switch addrType {
@@ -187,7 +271,7 @@ func (s *SOCKSHandler) handleConnect(addrType byte) error {
 		dstAddr = string(domain)
 
 		// Process special domain format: address.celestialbody.latency.space
-		dstAddr, err = s.processDomainName(dstAddr)
+		dstAddr, portOverride, err = s.processDomainName(dstAddr)
 		if err != nil {
 			s.sendReply(SOCKS5_REP_HOST_UNREACHABLE, net.IPv4zero, 0)
 			return fmt.Errorf("failed to process domain name: %v", err)
@@ -214,92 +298,142 @@ func (s *SOCKSHandler) handleConnect(addrType byte) error {
 		return fmt.Errorf("failed to read port: %v", err)
 	}
 	dstPort := binary.BigEndian.Uint16(portBuf)
+	if portOverride != 0 {
+		dstPort = portOverride
+	}
 
 	// Destination address in host:port format
 	dstAddrPort := net.JoinHostPort(dstAddr, strconv.Itoa(int(dstPort)))
-
-	// Anti-DDoS: Check if destination is in allowed list
-	if !s.isAllowedDestination(dstAddr) {
-		s.sendReply(SOCKS5_REP_CONN_NOT_ALLOWED, net.IPv4zero, 0)
-		return fmt.Errorf("destination not in allowed list: %s", dstAddr)
-	}
-
-	// Enforce the destination port allowlist on the CONNECT/TCP path. This was
-	// previously only checked on the UDP path, so CONNECT could reach any port
-	// (e.g. an allowlisted host on port 22). Skipped in test mode, which dials
-	// echo servers on arbitrary loopback ports.
-	if !isTestMode.Load() {
-		if err := s.security.ValidateSocksDestination(dstAddr, dstPort); err != nil {
-			s.sendReply(SOCKS5_REP_CONN_NOT_ALLOWED, net.IPv4zero, 0)
-			return fmt.Errorf("SOCKS destination not allowed: %v", err)
-		}
-	}
+	tx.Target = dstAddrPort
 
 	// Extract celestial body and apply latency
+	_, parseSpan := startSpan(s.ctx, "host.parse", attr("socks.target", dstAddrPort))
 	bodyName, err := s.getCelestialBodyFromConn(s.conn.RemoteAddr())
 	if err != nil {
-		log.Printf("No valid body found in %v: %v", s.conn.RemoteAddr(), err)
-		// If no body is found, getCelestialBodyFromConn defaults to Mars, so proceed
+		parseSpan.End()
+		s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
+		return err
+	}
+	parseSpan.SetAttr("celestial.body", bodyName)
+	parseSpan.End()
+	tx.Body = bodyName
+	if err := checkProxyable(bodyName); err != nil {
+		tx.Outcome = outcomeDenied
+		s.sendReply(SOCKS5_REP_CONN_NOT_ALLOWED, net.IPv4zero, 0)
+		return fmt.Errorf("SOCKS connection refused: %w", err)
+	}
+
+	// Anti-DDoS: the destination's host and port must be allowlisted.
+	if err := checkDestination(s.security, s.metrics, protoSOCKSTCP, dstAddr, dstPort); err != nil {
+		tx.Outcome = outcomeDenied
+		s.sendReply(SOCKS5_REP_CONN_NOT_ALLOWED, net.IPv4zero, 0)
+		return fmt.Errorf("SOCKS destination not allowed: %v", err)
 	}
 
 	// --- Occlusion Check ---
-	if getCelestialObjects() == nil {
-		log.Printf("Error: celestialObjects not initialized during SOCKS request.")
+	// The objects can't be empty or lack Earth (internal_state.go); if
+	// they are, that is reported rather than worked round.
+	snap, err := takeSnapshot(s.snapshots, s.metrics)
+	if err != nil {
 		s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
-		return fmt.Errorf("internal server error: celestial objects not initialized")
+		return err
 	}
-
-	targetObject, targetFound := findObjectByName(getCelestialObjects(), bodyName)
+	targetObject, targetFound := findObjectByName(snap.Objects, bodyName)
 	if !targetFound {
 		log.Printf("Error: SOCKS: Target celestial body '%s' not found.", bodyName)
 		s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
 		return fmt.Errorf("internal server error: target body '%s' not found", bodyName)
 	}
-	earthObject, earthFound := findObjectByName(getCelestialObjects(), "Earth")
-	if !earthFound {
-		log.Printf("Error: SOCKS: Earth celestial object not found.")
+	earthObject, _ := findObjectByName(snap.Objects, "Earth")
+
+	// Calculate latency based on celestial distance
+	distance, err := getCurrentDistance(bodyName) // Get distance for latency calc
+	if err != nil {
 		s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
-		return fmt.Errorf("internal server error: earth object configuration missing")
+		return err
 	}
-
-	occluded, occluder := IsOccluded(earthObject, targetObject, getCelestialObjects(), time.Now())
-	if occluded {
-		// If occluded is true, occluder is guaranteed to be non-nil by IsOccluded
-		log.Printf("SOCKS connection to %s rejected: occluded by %s", bodyName, occluder.Name)
-		s.sendReply(SOCKS5_REP_HOST_UNREACHABLE, net.IPv4zero, 0) // Host unreachable due to occlusion
+	latency := s.oneWay(bodyName, distance)
+	tx.Latency = latency
+	s.describeLink(bodyName, distance, latency)
+
+	// Occlusion and DSN contact windows share one check.
+	_, linkSpan := startSpan(s.ctx, "link.check", attr("celestial.body", bodyName))
+	outage, down := checkLink(earthObject, targetObject, snap.Objects, linkTime())
+	linkSpan.SetAttr("link.up", !down)
+	linkSpan.End()
+	if down {
+		tx.Outcome = outage.outcome()
+		occlusionPolicy.refuseSOCKS(s, bodyName, outage)
+		log.Printf("SOCKS connection to %s rejected: %s", bodyName, outage)
+		s.sendReply(SOCKS5_REP_HOST_UNREACHABLE, net.IPv4zero, 0) // Host unreachable: no link
 		// Return an error indicating the reason for rejection
-		return fmt.Errorf("SOCKS connection rejected: %s occluded by %s", bodyName, occluder.Name)
+		return fmt.Errorf("SOCKS connection rejected: %s %s", bodyName, outage)
 	}
 	// --- End Occlusion Check ---
 
-	// Calculate latency based on celestial distance
-	distance := getCurrentDistance(bodyName) // Get distance for latency calc
-	var latency time.Duration
-	// Use test latency in test mode
-	if isTestMode.Load() {
-		latency = testModeCalculateLatency(distance)
-	} else {
-		latency = CalculateLatency(distance)
-	}
-
 	// Anti-DDoS: Only allow bodies with significant latency (>1s)
-	// This prevents the proxy from being used for DDoS attacks
-	// Skip this check in test mode
-	if !isTestMode.Load() && latency < 1*time.Second {
-		log.Printf("Rejecting connection with insufficient latency: %s (%.2f ms)",
-			bodyName, latency.Seconds()*1000)
+	// This prevents the proxy from being used for DDoS attacks. A
+	// near-Earth asteroid on a flyby says when it is far enough again.
+	if err := checkLatencyFloor(bodyName, latency, s.security.minLatency); err != nil {
+		tx.Outcome = outcomeDenied
+		log.Printf("Rejecting connection with insufficient latency: %s (%.2f ms): %v",
+			bodyName, latency.Seconds()*1000, err)
 		s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
-		return fmt.Errorf("rejecting request with insufficient latency: %s", bodyName)
+		return fmt.Errorf("rejecting request: %w", err)
 	}
 
-	// Apply space latency for the connection
-	time.Sleep(latency)
+	// With -remote-dns-latency a name costs a round trip to resolve, and
+	// each address it resolves to is checked again (remotedns.go).
+	dialAddrs := []string{dstAddrPort}
+	if s.remoteDNS != nil && net.ParseIP(dstAddr) == nil {
+		_, dnsSpan := startSpan(s.ctx, "dns.resolve", attr("dns.host", dstAddr))
+		dialAddrs, err = s.resolveTarget(bodyName, dstAddr, dstPort, latency)
+		dnsSpan.SetError(err)
+		dnsSpan.End()
+		var denied *DestinationError
+		switch {
+		case errors.As(err, &denied):
+			tx.Outcome = outcomeDenied
+			s.sendReply(SOCKS5_REP_CONN_NOT_ALLOWED, net.IPv4zero, 0)
+			return fmt.Errorf("SOCKS destination not allowed: %v", err)
+		case err != nil:
+			s.sendReply(SOCKS5_REP_HOST_UNREACHABLE, net.IPv4zero, 0)
+			return fmt.Errorf("failed to resolve %s: %v", dstAddr, err)
+		}
+	}
 
-	// Start metrics collection
-	start := time.Now()
-	defer func() {
-		s.metrics.RecordRequest(bodyName, "socks", time.Since(start))
-	}()
+	// A target known to be down fails now rather than after the latency
+	// sleep (preflight.go).
+	if res, ok := s.preflight.Check(s.ctx, dialAddrs[0], ""); !ok {
+		age := s.preflight.Age(res)
+		s.describeUnreachable(age)
+		log.Printf("SOCKS connect to %s via %s refused: pre-flight probe %v ago: %s", dstAddrPort, bodyName, age.Round(time.Millisecond), res.Cause)
+		if res.Cause == "refused" {
+			s.sendReply(SOCKS5_REP_CONN_REFUSED, net.IPv4zero, 0)
+		} else {
+			s.sendReply(SOCKS5_REP_HOST_UNREACHABLE, net.IPv4zero, 0)
+		}
+		return fmt.Errorf("%s: %s unreachable (%s)", preflightUnreachableCode, dstAddrPort, res.Cause)
+	}
+
+	// Apply space latency for the connection
+	_, sleepSpan := startSpan(s.ctx, "latency.sleep", attr("latency.intended_ms", durationMs(latency)))
+	sleepStart := s.now()
+	now := s.sleepLatency(latency)
+	slept := s.since(sleepStart)
+	sleepSpan.End()
+
+	// The sleep can last hours, long enough for the body to slip behind its
+	// parent or out of its DSN pass. Check the link again at the time the
+	// request actually arrives, before dialing and replying.
+	if outage, down := checkLink(earthObject, targetObject, getCelestialObjects(), now); down {
+		tx.Outcome = outage.outcome()
+		occlusionPolicy.refuseSOCKS(s, bodyName, outage)
+		s.metrics.RecordSOCKSLinkLostDuringSetup(bodyName, outage.outcome())
+		log.Printf("SOCKS connection to %s lost during setup: %s", bodyName, outage)
+		s.sendReply(SOCKS5_REP_HOST_UNREACHABLE, net.IPv4zero, 0)
+		return fmt.Errorf("SOCKS connection rejected after latency sleep: %s %s", bodyName, outage)
+	}
 
 	// Connect to destination
 	log.Printf("SOCKS connect to %s from %s via %s (latency: %v)",
@@ -321,7 +455,14 @@ func (s *SOCKSHandler) handleConnect(addrType byte) error {
 	}
 
 	log.Printf("Using connection timeout of %v for %s", connectTimeout, bodyName)
-	target, err := net.DialTimeout("tcp", dstAddrPort, connectTimeout)
+	dialStart := s.now()
+	_, dialSpan := startSpan(s.ctx, "upstream.dial", attr("net.peer", dstAddrPort))
+	target, err := s.dialFirst(dialAddrs, connectTimeout)
+	tx.Upstream = s.since(dialStart)
+	s.preflight.Record(dialAddrs[0], err)
+	s.metrics.RecordSOCKSDial(bodyName, tx.Upstream)
+	dialSpan.SetError(err)
+	dialSpan.End()
 	if err != nil {
 		// Send appropriate error code based on the error
 		switch {
@@ -343,57 +484,122 @@ func (s *SOCKSHandler) handleConnect(addrType byte) error {
 	localAddr := target.LocalAddr().(*net.TCPAddr)
 	s.sendReply(SOCKS5_REP_SUCCESS, localAddr.IP, uint16(localAddr.Port))
 
-	// Relay data in both directions using a delay line per direction.
+	// requests_total counts established tunnels; its duration is the setup
+	// cost (greeting through reply, including simulated latency), while the
+	// relay lifetime goes to socks_session_duration_seconds.
+	setup := s.since(s.started)
+	s.metrics.RecordRequest(bodyName, "socks", setup)
+	s.overhead.record(s.metrics, bodyName, overheadSOCKS, setup-slept)
+	sessionStart := s.now()
+	defer func() {
+		s.metrics.RecordSOCKSSession(bodyName, s.since(sessionStart))
+	}()
+
+	// Relay data in both directions. The old relay slept the full one-way
+	// latency after EACH 32KB read, which coupled latency to throughput: a
+	// Mars link fell to ~45 bytes/s and a TLS handshake took over an hour.
+	// relayWithLatency shifts every byte in time instead (see delay.go).
 	//
-	// The old relay slept the full one-way latency after EACH 32KB read,
-	// which coupled latency to throughput: a Mars link fell to ~45 bytes/s
-	// and a TLS handshake took over an hour. delayCopy instead shifts every
-	// byte in time by `latency` without blocking subsequent reads, so
-	// throughput is preserved. When one direction finishes it closes its
-	// destination, which unblocks the other direction's read and tears the
-	// tunnel down — the same teardown the original loop used.
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	relay := func(dst, src net.Conn, label string) {
-		defer wg.Done()
-		// Each direction gets its own context so returning here unblocks
-		// only this direction's internal reader, not the other side.
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		err := delayCopy(ctx, dst, src, latency, func(n int) {
-			s.metrics.TrackBandwidth(bodyName, int64(n))
-		})
-		if err != nil && !isNetClosingErr(err) {
-			log.Printf("SOCKS relay %s error: %v", label, err)
-		}
-		dst.Close() // unblocks the opposite direction's read on this conn
+	// If the body moves behind an occluder, or its contact window closes,
+	// mid-session, closing the target ends the relay: the client gets what
+	// was in flight, then a FIN rather than a reset (linkloss.go).
+	var lost atomic.Pointer[LinkOutage]
+	done := make(chan struct{})
+	go watchLink(linkRecheckInterval, func() (LinkOutage, bool) {
+		return checkLink(earthObject, targetObject, getCelestialObjects(), linkTime())
+	}, func(outage LinkOutage) {
+		log.Printf("SOCKS connection to %s via %s closed: %s", dstAddrPort, bodyName, outage)
+		lost.Store(&outage)
+		target.Close()
+	}, done)
+	_, copySpan := startSpan(s.ctx, "body.copy")
+	var end relayEnd
+	tx.BytesIn, tx.BytesOut, end = relayWithLatency(s.timing, s.conn, target, bodyName, protoSOCKSTCP, latency, s.metrics, s.sessionLimit, s.tcpWindow)
+	close(done)
+	if s.sessionLimit > 0 && tx.BytesIn+tx.BytesOut > s.sessionLimit {
+		log.Printf("SOCKS connection to %s via %s closed: passed the %d byte session limit", dstAddrPort, bodyName, s.sessionLimit)
+		s.metrics.RecordSizeLimit(bodyName, sizeLimitSOCKSSession)
+	}
+	copySpan.SetAttr("bytes_in", tx.BytesIn)
+	copySpan.SetAttr("bytes_out", tx.BytesOut)
+	copySpan.End()
+	tx.Outcome = end.outcome()
+	if outage := lost.Load(); outage != nil {
+		tx.Outcome = outage.outcome()
 	}
 
-	go relay(target, s.conn, "client->target")
-	go relay(s.conn, target, "target->client")
+	return nil
+}
 
-	// Wait for both goroutines to complete
-	wg.Wait()
+// resolveTarget resolves host as seen from bodyName, spending a round trip
+// of latency on a cache miss, and returns the host:port addresses to dial.
+// Every address must pass ValidateResolvedIP.
+func (s *SOCKSHandler) resolveTarget(bodyName, host string, port uint16, latency time.Duration) ([]string, error) {
+	ips, err := s.remoteDNS.Lookup(s.ctx, bodyName, host, func() { s.sleep(2 * latency) })
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		if err := s.security.ValidateResolvedIP(host, port, ip); err != nil {
+			recordDenial(s.metrics, protoSOCKSTCP, err)
+			log.Printf("SOCKS destination rejected: %v", err)
+			return nil, err
+		}
+		addrs[i] = net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	}
+	return addrs, nil
+}
 
-	return nil
+// dialFirst dials addrs in turn until one connects, all within timeout,
+// and returns the last error if none does.
+func (s *SOCKSHandler) dialFirst(addrs []string, timeout time.Duration) (net.Conn, error) {
+	deadline := s.now().Add(timeout)
+	err := errors.New("no address to dial")
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = net.DialTimeout("tcp", addr, deadline.Sub(s.now())); err == nil {
+			return conn, nil
+		}
+		if s.now().After(deadline) {
+			break
+		}
+	}
+	return nil, err
+}
+
+// sleepLatency waits out the one-way latency and returns the link time at
+// the end of it, for checks that must use the geometry after the sleep.
+func (s *SOCKSHandler) sleepLatency(d time.Duration) time.Time {
+	s.sleep(d)
+	return linkTime()
 }
 
 // handleUDPAssociate handles the SOCKS5 UDP ASSOCIATE command
 func (s *SOCKSHandler) handleUDPAssociate(addrType byte) error {
+	defer activeUDPAssociate.enter()()
 	log.Printf("SOCKS UDP ASSOCIATE request from %s", s.conn.RemoteAddr())
+	start := s.now()
 	var wg sync.WaitGroup
 	done := make(chan struct{}) // Channel to signal UDP relay termination
 
-	// Read and discard the client's requested address and port (they are ignored per RFC)
+	// The client's DST.ADDR/DST.PORT is the address it will send from, or
+	// zeros if it doesn't know (see udp_peers.go).
+	var hint *net.UDPAddr
 	switch addrType {
-	case SOCKS5_ADDR_IPV4:
-		discard := make([]byte, 4+2) // IPv4 (4) + port (2)
-		if _, err := io.ReadFull(s.conn, discard); err != nil {
+	case SOCKS5_ADDR_IPV4, SOCKS5_ADDR_IPV6:
+		size := net.IPv4len
+		if addrType == SOCKS5_ADDR_IPV6 {
+			size = net.IPv6len
+		}
+		buf := make([]byte, size+2) // address + port (2)
+		if _, err := io.ReadFull(s.conn, buf); err != nil {
 			s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
-			return fmt.Errorf("failed to read/discard UDP request address: %v", err)
+			return fmt.Errorf("failed to read UDP request address: %v", err)
 		}
+		hint = &net.UDPAddr{IP: net.IP(buf[:size]), Port: int(binary.BigEndian.Uint16(buf[size:]))}
 	case SOCKS5_ADDR_DOMAIN:
+		// A name isn't a source address; read and discard it.
 		lenBuf := make([]byte, 1)
 		if _, err := io.ReadFull(s.conn, lenBuf); err != nil {
 			s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
@@ -405,19 +611,70 @@ func (s *SOCKSHandler) handleUDPAssociate(addrType byte) error {
 			s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
 			return fmt.Errorf("failed to read/discard UDP domain address: %v", err)
 		}
-	case SOCKS5_ADDR_IPV6:
-		discard := make([]byte, 16+2) // IPv6 (16) + port (2)
-		if _, err := io.ReadFull(s.conn, discard); err != nil {
-			s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
-			return fmt.Errorf("failed to read/discard UDP IPv6 address: %v", err)
-		}
 	default:
 		s.sendReply(SOCKS5_REP_ADDR_NOT_SUPPORTED, net.IPv4zero, 0)
 		return fmt.Errorf("unsupported address type in UDP ASSOCIATE: %d", addrType)
 	}
 
-	// Create UDP socket
-	udpConn, err := net.ListenPacket("udp", ":0") // Listen on any available port
+	// Anti-DDoS: the same minimum-latency floor as CONNECT. A fast UDP relay
+	// is the easiest reflection vector, so refuse before allocating a socket.
+	var distance float64
+	bodyName, err := s.getCelestialBodyFromConn(s.conn.RemoteAddr())
+	if err == nil {
+		_, err = takeSnapshot(s.snapshots, s.metrics)
+	}
+	if err == nil {
+		distance, err = getCurrentDistance(bodyName)
+	}
+	if err != nil {
+		s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
+		s.record(RecentTransaction{
+			Time:     start,
+			ClientIP: clientIP(s.conn.RemoteAddr().String()),
+			Protocol: "socks-udp",
+			Body:     bodyName,
+			Outcome:  outcomeError,
+			Duration: s.since(start),
+		})
+		return fmt.Errorf("UDP ASSOCIATE: %w", err)
+	}
+	if err := checkProxyable(bodyName); err != nil {
+		s.sendReply(SOCKS5_REP_CONN_NOT_ALLOWED, net.IPv4zero, 0)
+		s.record(RecentTransaction{
+			Time:     start,
+			ClientIP: clientIP(s.conn.RemoteAddr().String()),
+			Protocol: "socks-udp",
+			Body:     bodyName,
+			Duration: s.since(start),
+			Outcome:  outcomeDenied,
+		})
+		return fmt.Errorf("UDP ASSOCIATE refused: %w", err)
+	}
+	latency := s.oneWay(bodyName, distance)
+	if err := checkLatencyFloor(bodyName, latency, s.security.minLatency); err != nil {
+		log.Printf("Rejecting UDP ASSOCIATE with insufficient latency: %s (%.2f ms): %v",
+			bodyName, latency.Seconds()*1000, err)
+		s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
+		s.record(RecentTransaction{
+			Time:     start,
+			ClientIP: clientIP(s.conn.RemoteAddr().String()),
+			Protocol: "socks-udp",
+			Body:     bodyName,
+			Latency:  latency,
+			Duration: s.since(start),
+			Outcome:  outcomeDenied,
+		})
+		return fmt.Errorf("rejecting UDP ASSOCIATE: %w", err)
+	}
+
+	// Create the UDP socket on the address the client reached us on, so the
+	// reply names a relay address the client can actually send to (not the
+	// wildcard, and not whatever the SOCKS listener happens to be bound to).
+	bindHost := ""
+	if local, ok := s.conn.LocalAddr().(*net.TCPAddr); ok && !local.IP.IsUnspecified() {
+		bindHost = local.IP.String()
+	}
+	udpConn, err := net.ListenPacket("udp", net.JoinHostPort(bindHost, "0"))
 	if err != nil {
 		s.sendReply(SOCKS5_REP_GENERAL_FAILURE, net.IPv4zero, 0)
 		return fmt.Errorf("failed to create UDP socket: %v", err)
@@ -434,28 +691,10 @@ func (s *SOCKSHandler) handleUDPAssociate(addrType byte) error {
 
 	log.Printf("SOCKS UDP relay listening on %s", udpAddr.String())
 
-	// Send success reply with the UDP socket's IP and port
-	// Use IPv4 address type for simplicity, as requested.
-	// If the bound IP is IPv6, we might need a more robust way to get an IPv4 address
-	// or send an IPv6 reply if the client supports it. For now, assume IPv4.
-	replyIP := udpAddr.IP.To4()
-	if replyIP == nil {
-		// If not an IPv4 address, try to find an IPv4 interface address
-		// This is a simplification; a robust server might need better handling
-		addrs, _ := net.InterfaceAddrs()
-		for _, addr := range addrs {
-			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-				if ipnet.IP.To4() != nil {
-					replyIP = ipnet.IP.To4()
-					log.Printf("Warning: UDP bound to IPv6, replying with local IPv4 %s", replyIP)
-					break
-				}
-			}
-		}
-		if replyIP == nil {
-			log.Printf("Warning: Could not find suitable IPv4 address for UDP reply, using 0.0.0.0")
-			replyIP = net.IPv4zero // Fallback
-		}
+	// Reply with the relay's actual address; IPv6 gets an IPv6 reply.
+	replyIP := udpAddr.IP
+	if replyIP.IsUnspecified() {
+		replyIP = net.IPv4zero // no TCP local address (e.g. a pipe): the client's own host
 	}
 	s.sendReply(SOCKS5_REP_SUCCESS, replyIP, uint16(udpAddr.Port))
 
@@ -466,7 +705,7 @@ func (s *SOCKSHandler) handleUDPAssociate(addrType byte) error {
 
 	// Launch the relay goroutine, passing the done channel
 	wg.Add(1)
-	go s.handleUDPRelay(udpConn, clientTCPAddr, s.security, s.metrics, &wg, done)
+	go s.handleUDPRelay(udpConn, clientTCPAddr, hint, s.security, s.metrics, &wg, done)
 
 	// Keep the TCP connection alive to manage the UDP relay's lifecycle.
 	// Read minimally from the TCP connection; its closure (or error) signals shutdown.
@@ -494,45 +733,121 @@ func (s *SOCKSHandler) handleUDPAssociate(addrType byte) error {
 	wg.Wait()                                                                             // Wait for the relay goroutine to fully exit
 	log.Printf("SOCKS UDP Associate: UDP relay goroutine finished for %s", clientTCPAddr) // DEBUG
 
+	// One ring entry per association (per-packet entries would flood it).
+	s.record(RecentTransaction{
+		Time:     start,
+		ClientIP: clientIP(clientTCPAddr.String()),
+		Protocol: "socks-udp",
+		Body:     bodyName,
+		Duration: s.since(start),
+		Outcome:  outcomeOK,
+	})
+
 	return nil
 }
 
-// handleUDPRelay manages packet forwarding for a UDP association.
+// handleUDPRelay manages packet forwarding for a UDP association. hint is
+// the source address from the UDP ASSOCIATE request, nil for a domain.
 // It terminates when the done channel is closed or the udpConn is closed.
-func (s *SOCKSHandler) handleUDPRelay(udpConn net.PacketConn, clientTCPAddr net.Addr, security *SecurityValidator, metrics *MetricsCollector, wg *sync.WaitGroup, done <-chan struct{}) {
+func (s *SOCKSHandler) handleUDPRelay(udpConn net.PacketConn, clientTCPAddr net.Addr, hint *net.UDPAddr, security *SecurityValidator, metrics Metrics, wg *sync.WaitGroup, done <-chan struct{}) {
 	// NOTE: Do not call udpConn.Close() here. The caller (handleUDPAssociate) is responsible.
 	defer wg.Done() // Signal that this goroutine has finished
+	defer activeUDPRelay.enter()()
 	log.Printf("UDP Relay started for %s, listening on %s", clientTCPAddr, udpConn.LocalAddr())
 
 	// Determine celestial body and latency based on the *initial* TCP connection
 	bodyName, err := s.getCelestialBodyFromConn(clientTCPAddr)
 	if err != nil {
-		log.Printf("UDP Relay: Error getting celestial body for %v: %v. Using default.", clientTCPAddr, err)
-		// getCelestialBodyFromConn defaults to Mars, proceed with that
+		// handleUDPAssociate resolved the body before starting the relay.
+		log.Printf("UDP Relay for %s: %v", clientTCPAddr, err)
+		return
 	}
-	distance := getCurrentDistance(bodyName)
-	var latency time.Duration
-	// Use test latency in test mode
-	if isTestMode.Load() {
-		latency = testModeCalculateLatency(distance)
-	} else {
-		latency = CalculateLatency(distance)
+	distance, err := getCurrentDistance(bodyName)
+	if err != nil {
+		// handleUDPAssociate checked just before; the objects changed since.
+		log.Printf("UDP Relay for %s: %v", clientTCPAddr, err)
+		return
 	}
+	latency := s.oneWay(bodyName, distance)
 	log.Printf("UDP Relay for %s: Using body '%s', latency %v", clientTCPAddr, bodyName, latency)
 
-	// Get Earth object for occlusion check (assuming Earth is the proxy location)
-	earthObject, earthFound := findObjectByName(getCelestialObjects(), "Earth")
-	if !earthFound {
-		log.Printf("Error: UDP Relay: Earth celestial object not found. Occlusion checks disabled.")
-		// Proceed without occlusion checks if Earth object is missing
+	// Earth can't be missing (internal_state.go); if it is, the relay
+	// stops rather than running without occlusion checks.
+	snap, err := takeSnapshot(s.snapshots, metrics)
+	if err != nil {
+		return
 	}
-	targetObject, targetFound := findObjectByName(getCelestialObjects(), bodyName)
+	earthObject, earthFound := findObjectByName(snap.Objects, "Earth")
+	targetObject, targetFound := findObjectByName(snap.Objects, bodyName)
 	if !targetFound {
 		log.Printf("Error: UDP Relay: Target celestial body '%s' not found. Occlusion checks disabled.", bodyName)
 		// Proceed without occlusion checks if target object is missing
 	}
-	// buffer for UDP packets (managed by the readResult struct)
-	var clientUDPAddr net.Addr // Store the client's source UDP address
+	// Which packets are the client's, and which replies it asked for (see
+	// udp_peers.go).
+	client := newUDPClientFilter(hint, clientTCPAddr)
+	replies := newUDPReplyFilter(latency + udpReplyWindow)
+
+	// Per-association packet, byte and destination caps (see udp_limits.go).
+	limiter := newUDPAssocLimiter(s.udpLimits, s.now())
+
+	// Loss, reordering and duplication, independently per direction (see
+	// udp_impair.go). The rates grow near solar conjunction, and a degraded
+	// line of sight loses more packets still.
+	rates := s.udpImpair.ratesFor(bodyName, -1)
+	if earthFound && targetFound {
+		objects, at := getCelestialObjects(), linkTime()
+		if rates.enabled() {
+			rates = s.udpImpair.ratesFor(bodyName, SolarElongation(earthObject, targetObject, objects, at))
+		}
+		if v, _ := IsOccluded(earthObject, targetObject, objects, at); v == VisibilityDegraded {
+			rates = rates.withLoss(s.udpImpair.DegradedLoss)
+		}
+	}
+	// Space weather loses more, and jitters each packet below
+	// (spaceweather.go).
+	weather := bodyWeather(bodyName, getCelestialObjects(), linkTime())
+	rates = rates.withLoss(weather.LossPct)
+	writeUDP := func(pkt []byte, to net.Addr) {
+		if _, err := udpConn.WriteTo(pkt, to); err != nil {
+			log.Printf("UDP Relay: Error writing %d bytes to %s: %v", len(pkt), to, err)
+		}
+	}
+	recordImpairment := func(effect string) { metrics.RecordUDPImpairment(bodyName, effect) }
+	toTarget := newUDPImpairer(rates, latency, writeUDP, recordImpairment)
+	toClient := newUDPImpairer(rates, latency, writeUDP, recordImpairment)
+	defer toTarget.stop()
+	defer toClient.stop()
+
+	// Packets in flight wait in the scheduler, not in this loop, so their
+	// delays overlap and the socket keeps being read. A full queue drops
+	// its oldest packet.
+	sched := relay.NewScheduler(s.clk(), s.udpLimits.MaxQueued, func() {
+		metrics.RecordUDPDrop(bodyName, udpDropQueue)
+	})
+	defer sched.Stop()
+
+	// The simulated link: latency both ways plus any space weather jitter,
+	// occlusion and contact windows on the way out, and bandwidth metrics.
+	pipe := relay.New(
+		relay.WithClock(s.clk()),
+		relay.WithLatencyFunc(func() time.Duration { return latency + weather.jitter() }),
+		relay.WithMetrics(bandwidthSink(metrics, bodyName, protoSOCKSUDP)),
+		relay.WithLinkCheck(func(dir relay.Direction) error {
+			// Only check if we found both Earth and the target body.
+			if dir != relay.ToTarget || !earthFound || !targetFound {
+				return nil
+			}
+			if outage, down := checkLink(earthObject, targetObject, getCelestialObjects(), linkTime()); down {
+				return &linkDownError{body: bodyName, outage: outage}
+			}
+			return nil
+		}),
+	)
+
+	// A dropped packet is silent, but the first of each outage earns the
+	// client a notice (occlusion_policy.go).
+	dropNotices := occlusionPolicy.udpNotifier()
 
 	// Channel to receive results (including data copy) from the reading goroutine
 	type readResult struct {
@@ -547,9 +862,12 @@ func (s *SOCKSHandler) handleUDPRelay(udpConn net.PacketConn, clientTCPAddr net.
 	go func() {
 		// This goroutine will exit when ReadFrom returns an error (e.g., due to udpConn.Close())
 		defer log.Printf("UDP Relay Reader Goroutine: Exiting for %s", clientTCPAddr)
+		// A pooled buffer owned by this goroutine; each packet is copied out
+		// of it, so it is reused for every read (see bufpool.go).
+		buf := udpBufPool.Get()
+		defer udpBufPool.Put(buf)
+		readBuf := *buf
 		for {
-			// Use a separate buffer owned by this goroutine to avoid races with the main loop processing
-			readBuf := make([]byte, 65535)
 			n, remoteAddr, err := udpConn.ReadFrom(readBuf)
 			// Create a copy of the data read to send over the channel
 			// This is crucial because the main loop might still be processing the previous packet
@@ -621,25 +939,9 @@ func (s *SOCKSHandler) handleUDPRelay(udpConn net.PacketConn, clientTCPAddr net.
 				continue
 			}
 
-			// First packet received? Identify the client's UDP source address.
-			// Compare host parts only, as ports might differ.
-			if clientUDPAddr == nil {
-				// Simple IP comparison (might fail for complex cases like NAT)
-				clientTCPHost, _, _ := net.SplitHostPort(clientTCPAddr.String())
-				remoteHost, _, _ := net.SplitHostPort(remoteAddr.String())
-				if clientTCPHost == remoteHost {
-					clientUDPAddr = remoteAddr
-					log.Printf("UDP Relay: Identified client UDP address for %s as %s", clientTCPAddr, clientUDPAddr)
-				} else {
-					// Packet from an unknown source before client sent anything? Drop it.
-					log.Printf("UDP Relay: Dropping packet from unexpected source %s before client %s (%s) sent data.", remoteAddr, clientTCPAddr, clientTCPHost)
-					continue
-				}
-			}
-
 			// Decide if the packet is from the client or an external target
-			// Make sure clientUDPAddr is set before comparing
-			if clientUDPAddr != nil && remoteAddr.String() == clientUDPAddr.String() {
+			if client.matches(remoteAddr) {
+				clientUDPAddr := client.addr()
 				// --- Packet from Client -> Target ---
 				log.Printf("UDP Relay: Processing %d bytes from client %s", n, remoteAddr)
 
@@ -695,12 +997,16 @@ func (s *SOCKSHandler) handleUDPRelay(udpConn net.PacketConn, clientTCPAddr net.
 					dataOffset = 5 + domainLen + 2
 
 					// Process domain (e.g., extract target from .latency.space)
+					var portOverride uint16
 					var err error
-					dstHost, err = s.processDomainName(domain)
+					dstHost, portOverride, err = s.processDomainName(domain)
 					if err != nil {
 						log.Printf("UDP Relay: Failed to process domain name '%s': %v. Dropping packet.", domain, err)
 						continue
 					}
+					if portOverride != 0 {
+						dstPort = portOverride
+					}
 					// Note: processDomainName might have returned the original domain if not special format
 					// We might need to resolve this domain to an IP here if WriteTo needs an IP.
 					// However, net.DialUDP which WriteTo uses often handles resolution. Let's try first.
@@ -726,63 +1032,49 @@ func (s *SOCKSHandler) handleUDPRelay(udpConn net.PacketConn, clientTCPAddr net.
 				dstAddrPort := net.JoinHostPort(dstHost, strconv.Itoa(int(dstPort)))
 
 				// --- Security Checks ---
-				// Check if the destination host is an IP address
-				isLoopback := false
-				if ip := net.ParseIP(dstHost); ip != nil {
-					// Loopback is permitted ONLY in test mode; in production
-					// all IP literals are rejected (see isAllowedDestination).
-					if ip.IsLoopback() && isTestMode.Load() {
-						isLoopback = true
-					} else {
-						log.Printf("UDP Relay: Destination %s is an IP address. Use --socks5-hostname to send domain names to the proxy. Dropping packet.", dstHost)
-						continue
-					}
-				}
-				// Only check allowed hosts for non-loopback addresses
-				if !isLoopback && !security.IsAllowedHost(dstHost) {
-					log.Printf("UDP Relay: Destination host %s not allowed, dropping packet.", dstHost)
-					continue
-				}
-				// Check port validity (using the same SOCKS validator logic)
-				if err := security.ValidateSocksDestination(dstHost, dstPort); err != nil {
-					log.Printf("UDP Relay: Destination port %d not allowed for host %s: %v, dropping packet.", dstPort, dstHost, err)
+				// The same destination policy as CONNECT; checkDestination logs
+				// the refusal.
+				if checkDestination(security, metrics, protoSOCKSUDP, dstHost, dstPort) != nil {
 					continue
 				}
 
-				// --- Occlusion Check ---
-				if earthFound && targetFound { // Only check if we found both Earth and the target body
-					occluded, occluder := IsOccluded(earthObject, targetObject, getCelestialObjects(), time.Now())
-					if occluded {
-						log.Printf("UDP Relay: Path to %s occluded by %s, dropping packet.", bodyName, occluder.Name)
-						continue
-					}
+				if ok, reason := limiter.allow(dstAddrPort, len(payload), s.now()); !ok {
+					log.Printf("UDP Relay: Dropping packet from %s to %s: association %s cap reached", clientUDPAddr, dstAddrPort, reason)
+					metrics.RecordUDPDrop(bodyName, reason)
+					continue
 				}
-				// --- End Occlusion Check ---
-
-				log.Printf("UDP Relay: Relaying %d bytes from client %s to %s (via %s, latency %v)",
-					len(payload), clientUDPAddr, dstAddrPort, bodyName, latency)
-
-				// Apply forward latency
-				time.Sleep(latency)
 
-				// Send payload to destination
 				targetUDPAddr, err := net.ResolveUDPAddr("udp", dstAddrPort)
 				if err != nil {
 					log.Printf("UDP Relay: Failed to resolve destination UDP address %s: %v", dstAddrPort, err)
 					continue
 				}
 
-				_, err = udpConn.WriteTo(payload, targetUDPAddr)
-				if err != nil {
-					log.Printf("UDP Relay: Error writing %d bytes to target %s: %v", len(payload), targetUDPAddr, err)
-					// Don't necessarily continue; could be a temporary error
+				log.Printf("UDP Relay: Relaying %d bytes from client %s to %s (via %s, latency %v)",
+					len(payload), clientUDPAddr, dstAddrPort, bodyName, latency)
+				replies.sent(targetUDPAddr, s.now())
+
+				// Occlusion check, then the payload goes to the destination
+				// after the forward latency.
+				if err := pipe.SendPacket(sched, payload, relay.ToTarget, func() {
+					toTarget.send(payload, targetUDPAddr)
+				}); err != nil {
+					log.Printf("UDP Relay: %v, dropping packet.", err)
+					var linkDown *linkDownError
+					if errors.As(err, &linkDown) {
+						if linkDown.outage.Occluder != "" {
+							metrics.RecordOcclusionRejection(bodyName, protoSOCKSUDP)
+						}
+						if dropNotices.drop(s.now()) {
+							writeUDP(occlusionPolicy.udpDropNoticePacket(targetObject, dstAddrPort, linkDown.outage, linkTime()), clientUDPAddr)
+						}
+					}
+					continue
 				}
-
-				// Record metrics (outgoing bandwidth from client perspective)
-				metrics.TrackBandwidth(bodyName, int64(len(payload)))
+				dropNotices.up()
 
 			} else {
-				// --- Packet from External Target -> Client --- (Stateless approach)
+				// --- Packet from External Target -> Client --- (only targets the client sent to)
 				log.Printf("UDP Relay: Received %d bytes from external source %s (presumed target reply)", n, remoteAddr)
 				targetUDPAddr, ok := remoteAddr.(*net.UDPAddr)
 				if !ok {
@@ -790,10 +1082,16 @@ func (s *SOCKSHandler) handleUDPRelay(udpConn net.PacketConn, clientTCPAddr net.
 					continue
 				}
 
+				clientUDPAddr := client.addr()
 				if clientUDPAddr == nil {
-					log.Printf("UDP Relay: Received packet from target %s before client %s sent data. Dropping.", remoteAddr, clientTCPAddr)
+					log.Printf("UDP Relay: Received packet from %s before client %s sent data. Dropping.", remoteAddr, clientTCPAddr)
 					continue // Don't know where to send it back
 				}
+				if !replies.expected(targetUDPAddr, s.now()) {
+					log.Printf("UDP Relay: Dropping unsolicited packet from %s for client %s", remoteAddr, clientUDPAddr)
+					metrics.RecordUDPDrop(bodyName, udpDropUnsolicited)
+					continue
+				}
 
 				// Construct SOCKS5 UDP Header for the reply
 				var replyHeader []byte
@@ -827,17 +1125,16 @@ func (s *SOCKSHandler) handleUDPRelay(udpConn net.PacketConn, clientTCPAddr net.
 				log.Printf("UDP Relay: Relaying %d bytes from target %s back to client %s (via %s, latency %v)",
 					n, remoteAddr, clientUDPAddr, bodyName, latency)
 
-				// Apply return latency
-				time.Sleep(latency)
-
-				// Send the full SOCKS UDP packet back to the client
-				_, err = udpConn.WriteTo(fullReply, clientUDPAddr)
-				if err != nil {
-					log.Printf("UDP Relay: Error writing %d bytes back to client %s: %v", len(fullReply), clientUDPAddr, err)
+				// The full SOCKS UDP packet goes back to the client after the
+				// return latency. The pipe counts the payload only, as on the
+				// way out, not the SOCKS header.
+				if err := pipe.SendPacket(sched, packetData[:n], relay.ToClient, func() {
+					toClient.send(fullReply, clientUDPAddr)
+					metrics.RecordUDPPacket(bodyName)
+				}); err != nil {
+					log.Printf("UDP Relay: %v, dropping reply.", err)
+					continue
 				}
-
-				// Record metrics (incoming packet to client perspective)
-				metrics.RecordUDPPacket(bodyName, int64(n))
 			}
 		}
 	}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strconv"
 	"strings"
 )
 
@@ -43,6 +44,12 @@ func (s *SOCKSHandler) sendReply(rep byte, ip net.IP, port uint16) {
 	binary.BigEndian.PutUint16(portBytes, port)
 	reply = append(reply, portBytes...)
 
+	// Metrics are recorded before the write so a client that has read the
+	// reply can rely on them being up to date.
+	s.recordReplyMetrics(rep)
+
+	reply = s.appendMetadata(reply)
+
 	// Send reply
 	_, err := s.conn.Write(reply)
 	if err != nil {
@@ -50,14 +57,30 @@ func (s *SOCKSHandler) sendReply(rep byte, ip net.IP, port uint16) {
 	}
 }
 
+// recordReplyMetrics records the handshake duration for the first reply on
+// this connection and counts any failure by its reply code.
+func (s *SOCKSHandler) recordReplyMetrics(rep byte) {
+	if rep != SOCKS5_REP_SUCCESS {
+		s.metrics.RecordSOCKSFailure(rep)
+	}
+	if s.started.IsZero() || s.replied {
+		return
+	}
+	s.replied = true
+	s.metrics.RecordSOCKSHandshake(rep == SOCKS5_REP_SUCCESS, s.since(s.started))
+}
+
 // processDomainName checks if the domain has our latency.space suffix
-// and extracts the actual destination host if needed
-func (s *SOCKSHandler) processDomainName(domain string) (string, error) {
+// and extracts the actual destination host if needed. The last target label
+// may end in -p<port> (example.com-p8443.mars.latency.space) to name the
+// destination port; port is 0 when it doesn't, leaving the request's port.
+// A target that is a single dash-encoded IP label becomes that IP literal.
+func (s *SOCKSHandler) processDomainName(domain string) (host string, port uint16, err error) {
 	// Check if this is our special format
 	if strings.HasSuffix(domain, ".latency.space") {
 		parts := strings.Split(domain, ".")
 		if len(parts) < 3 {
-			return "", fmt.Errorf("invalid latency.space domain format")
+			return "", 0, fmt.Errorf("invalid latency.space domain format")
 		}
 
 		// If format is domain.body.latency.space
@@ -68,55 +91,92 @@ func (s *SOCKSHandler) processDomainName(domain string) (string, error) {
 		// Everything before the celestial body is the target domain
 		targetParts := parts[:bodyIndex]
 		if len(targetParts) == 0 {
-			return "", fmt.Errorf("missing target domain in latency.space format")
+			return "", 0, fmt.Errorf("missing target domain in latency.space format")
+		}
+		last := len(targetParts) - 1
+		if label, p, ok := splitPortSuffix(targetParts[last]); ok {
+			if p == 0 {
+				return "", 0, fmt.Errorf("invalid target port in %s", targetParts[last])
+			}
+			if !s.security.isAllowedPort(strconv.Itoa(int(p))) {
+				return "", 0, fmt.Errorf("destination port %d is not allowed", p)
+			}
+			targetParts[last], port = label, p
 		}
 
 		targetDomain := strings.Join(targetParts, ".")
+		if len(targetParts) == 1 {
+			// 10-0-0-5.mars.latency.space (ip_targets.go)
+			host, ok, err := ipTargetHost(targetParts[0])
+			if err != nil {
+				return "", 0, err
+			}
+			if ok {
+				targetDomain = host
+			}
+		}
 
 		// Get the celestial body name for logging
 		bodyName := parts[bodyIndex]
 		_, found := findObjectByName(getCelestialObjects(), bodyName)
 
 		if !found {
-			return "", fmt.Errorf("unknown celestial body: %s", bodyName)
+			return "", 0, fmt.Errorf("unknown celestial body: %s", bodyName)
 		}
 
 		formattedBodyName := FormatDomainName(bodyName)
 		log.Printf("SOCKS: Extracted target domain %s from %s.latency.space format", targetDomain, formattedBodyName)
-		return targetDomain, nil
+		return targetDomain, port, nil
+	}
+	return domain, 0, nil
+}
+
+// splitPortSuffix splits a "-p<digits>" suffix off label. No top-level
+// domain ends that way, so on a target's last label it can't be mistaken
+// for part of the name. port is 0 if the digits aren't a valid port.
+func splitPortSuffix(label string) (name string, port uint16, ok bool) {
+	i := strings.LastIndex(label, "-p")
+	if i <= 0 || i+2 == len(label) {
+		return label, 0, false
 	}
-	return domain, nil
+	digits := label[i+2:]
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return label, 0, false
+		}
+	}
+	n, err := strconv.ParseUint(digits, 10, 16)
+	if err != nil {
+		return label[:i], 0, true
+	}
+	return label[:i], uint16(n), true
 }
 
-// isAllowedDestination checks if a destination is in the allowed list
-func (s *SOCKSHandler) isAllowedDestination(host string) bool {
-	// Check if this is an IP address
-	if ip := net.ParseIP(host); ip != nil {
-		// Loopback is permitted ONLY in test mode (the test suite dials
-		// 127.0.0.1 echo servers). In production, allowing loopback would let
+// checkDestination applies the destination policy (ValidateDestination) to
+// a SOCKS CONNECT or UDP destination, logging and counting a refusal under
+// protocol.
+func checkDestination(security *SecurityValidator, metrics Metrics, protocol, host string, port uint16) error {
+	err := security.ValidateSocksDestination(host, port)
+	var d *DestinationError
+	if !errors.As(err, &d) {
+		return err
+	}
+	recordDenial(metrics, protocol, err)
+	switch ip := net.ParseIP(host); {
+	case d.Reason == denyIP && ip.IsPrivate():
+		// Private-network addresses are let through only with -allow-private.
+		log.Printf("SOCKS destination rejected: %s is a private address; the proxy needs -allow-private to reach it.", host)
+	case d.Reason == denyIP:
+		// Loopback is permitted ONLY with allowLoopback (the test suite
+		// dials 127.0.0.1 echo servers). In production, allowing loopback would let
 		// an unauthenticated client CONNECT to services on the proxy host,
 		// so all IP literals are rejected — clients must send domain names
 		// (--socks5-hostname) which are then checked against the allowlist.
-		if ip.IsLoopback() && isTestMode.Load() {
-			return true
-		}
 		log.Printf("SOCKS destination rejected: %s is an IP address. Use --socks5-hostname instead of --socks5 to send domain names to the proxy.", host)
-		return false
+	default:
+		log.Printf("SOCKS destination rejected: %v", err)
 	}
-
-	// Just use the hostname directly with the security validator
-	allowed := s.security.IsAllowedHost(host)
-
-	// Log the result for debugging
-	if !allowed {
-		log.Printf("SOCKS destination rejected: %s is not in the allowed list", host)
-	} else {
-		log.Printf("SOCKS destination allowed: %s", host)
-	}
-
-	// Enforce the allowed hosts list to prevent proxy abuse
-	// This is an important security measure
-	return allowed
+	return err
 }
 
 // isNetClosingErr checks if an error indicates a closed network connection.
@@ -143,11 +203,15 @@ func isNetClosingErr(err error) bool {
 	return false
 }
 
+// errHiddenClient is returned for SOCKS connections straight from a trusted
+// proxy: the peer is the balancer, so the client (and its body) is unknown.
+var errHiddenClient = errors.New("connection from a trusted proxy carries no client address; enable -proxy-protocol")
+
 // getCelestialBodyFromConn extracts the celestial body from the connection
 func (s *SOCKSHandler) getCelestialBodyFromConn(addr net.Addr) (string, error) {
 	// If a fixed celestial body is configured, use it
 	if s.fixedCelestialBody != "" {
-		log.Printf("Using fixed celestial body: %s", s.fixedCelestialBody)
+		debugf("SOCKS body %s from the fixed celestial body", s.fixedCelestialBody)
 		return s.fixedCelestialBody, nil
 	}
 
@@ -159,8 +223,14 @@ func (s *SOCKSHandler) getCelestialBodyFromConn(addr net.Addr) (string, error) {
 		host = host[:idx]
 	}
 
+	// Behind a balancer without the PROXY protocol every client would look
+	// the same and silently get the default below.
+	if isTrustedProxy(s.trustedProxies, clientIP(addr.String())) {
+		return "", fmt.Errorf("SOCKS connection from %s: %w", addr, errHiddenClient)
+	}
+
 	// Log the connection host for debugging
-	log.Printf("SOCKS connection from host: %s", host)
+	debugf("SOCKS connection from host: %s", host)
 
 	// Check if this is a celestial body domain
 	if strings.HasSuffix(host, ".latency.space") {
@@ -171,7 +241,7 @@ func (s *SOCKSHandler) getCelestialBodyFromConn(addr net.Addr) (string, error) {
 			bodyName := parts[bodyIndex]
 			celestialBody, found := findObjectByName(getCelestialObjects(), bodyName)
 			if found {
-				log.Printf("Using celestial body from domain: %s", celestialBody.Name)
+				debugf("SOCKS body %s from the connection's domain", celestialBody.Name)
 				return celestialBody.Name, nil
 			}
 		}
@@ -182,13 +252,13 @@ func (s *SOCKSHandler) getCelestialBodyFromConn(addr net.Addr) (string, error) {
 	if len(hostParts) > 0 {
 		body, found := findObjectByName(getCelestialObjects(), hostParts[0])
 		if found {
-			log.Printf("Using celestial body from hostname: %s", body.Name)
+			debugf("SOCKS body %s from the connection's hostname", body.Name)
 			return body.Name, nil
 		}
 	}
 
 	// For clients connecting directly via IP, use Mars with minimal latency for testing
-	log.Printf("No celestial body detected in hostname, using Mars for connection from |%s|", host)
+	debugf("SOCKS body Mars by default: no celestial body in %q", host)
 	body, _ := findObjectByName(getCelestialObjects(), "Mars")
 	return body.Name, nil
 }
@@ -0,0 +1,67 @@
+// socks_metadata.go - server side of the SOCKS5 metadata extension.
+//
+// A client that offers method 0x80 in its greeting gets, after every request
+// reply, a length-prefixed JSON frame describing the link: body, distance,
+// one-way latency, occlusion, remaining connection quota and the bytes the
+// session may carry. The framing and
+// a reference reader live in shared/client; clients that don't offer 0x80
+// see standard SOCKS5.
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/latency-space/shared/client"
+)
+
+// describeLink records the body and its light time for the metadata frame.
+func (s *SOCKSHandler) describeLink(body string, distanceKm float64, latency time.Duration) {
+	if s.meta == nil {
+		return
+	}
+	s.meta.Body = body
+	s.meta.DistanceKm = distanceKm
+	s.meta.OnewayMs = durationMs(latency)
+}
+
+// describeOutage records why the link is down for the metadata frame.
+func (s *SOCKSHandler) describeOutage(outage LinkOutage) {
+	if s.meta == nil {
+		return
+	}
+	s.meta.Occluded = outage.Occluder != ""
+	s.meta.Occluder = outage.Occluder
+	if outage.Occluder != "" {
+		s.meta.ErrorCode = occludedCode
+	}
+}
+
+// describeUnreachable records a pre-flight probe that found the target down.
+func (s *SOCKSHandler) describeUnreachable(age time.Duration) {
+	if s.meta == nil {
+		return
+	}
+	s.meta.ErrorCode = preflightUnreachableCode
+	s.meta.ProbeAgeMs = durationMs(age)
+}
+
+// appendMetadata appends the metadata frame to a reply when the extension
+// was negotiated, so the reply and its frame go out in one write.
+func (s *SOCKSHandler) appendMetadata(reply []byte) []byte {
+	if s.meta == nil {
+		return reply
+	}
+	s.meta.QuotaRemaining = s.limiter.Remaining(clientIP(s.conn.RemoteAddr().String()))
+	s.meta.SessionBytesRemaining = -1
+	if s.sessionLimit > 0 {
+		s.meta.SessionBytesRemaining = s.sessionLimit
+	}
+	framed, err := client.AppendMetadata(reply, s.meta)
+	if err != nil {
+		// Can't happen for this struct; send the bare reply rather than none.
+		log.Printf("SOCKS metadata: %v", err)
+		return reply
+	}
+	return framed
+}
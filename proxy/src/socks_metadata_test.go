@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// startMetadataSOCKS runs a SOCKS listener on loopback that admits through
+// limiter, like serveSOCKSConn, and returns its address. Mars is 5ms away.
+func startMetadataSOCKS(t *testing.T, limiter *RateLimiter) string {
+	t.Helper()
+	return startTestSOCKS(t, &Server{security: newTestSecurity(), metrics: NewRecordingMetrics(), limiter: limiter,
+		fixedCelestialBody: "Mars", timing: fixedLatency(5 * time.Millisecond)})
+}
+
+// startTestSOCKS serves SOCKS connections with s on a loopback port and
+// returns its address.
+func startTestSOCKS(t *testing.T, s *Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveSOCKSConn(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSOCKSMetadataExtension(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+
+	echo := startEchoServer(t)
+	proxy := startMetadataSOCKS(t, NewRateLimiter(60, 5, 3, 0))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	t.Run("metadata client", func(t *testing.T) {
+		conn, meta, err := client.Dial(ctx, proxy, echo.String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		if meta == nil {
+			t.Fatal("extension offered but no metadata received")
+		}
+		wantKm := mustDistance(t, "Mars")
+		if meta.Body != "Mars" || meta.DistanceKm != wantKm || meta.OnewayMs != 5 || meta.Occluded {
+			t.Errorf("metadata = %+v, want Mars at %.0f km, 5 ms, not occluded", meta, wantKm)
+		}
+		// Burst 5 with this connection's token spent, but 3 per IP with one open.
+		if meta.QuotaRemaining != 2 {
+			t.Errorf("quota_remaining = %d, want 2", meta.QuotaRemaining)
+		}
+
+		// The relay starts right after the frame.
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte("ping"))
+		got := make([]byte, 4)
+		if _, err := io.ReadFull(conn, got); err != nil || string(got) != "ping" {
+			t.Fatalf("echo through tunnel: %q, %v", got, err)
+		}
+	})
+
+	t.Run("vanilla client", func(t *testing.T) {
+		conn, err := net.Dial("tcp", proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte{SOCKS5_VERSION, 1, SOCKS5_NO_AUTH})
+		sel := make([]byte, 2)
+		if _, err := io.ReadFull(conn, sel); err != nil || sel[1] != SOCKS5_NO_AUTH {
+			t.Fatalf("greeting: %x, %v", sel, err)
+		}
+		req := []byte{SOCKS5_VERSION, SOCKS5_CMD_CONNECT, 0, SOCKS5_ADDR_IPV4}
+		req = append(req, echo.IP.To4()...)
+		conn.Write(binary.BigEndian.AppendUint16(req, uint16(echo.Port)))
+		reply := make([]byte, 10)
+		if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != SOCKS5_REP_SUCCESS {
+			t.Fatalf("connect: %x, %v", reply, err)
+		}
+		// No frame: the next bytes are the echo of our own data.
+		conn.Write([]byte("pong"))
+		got := make([]byte, 4)
+		if _, err := io.ReadFull(conn, got); err != nil || string(got) != "pong" {
+			t.Fatalf("echo through tunnel: %q, %v (a metadata frame leaked?)", got, err)
+		}
+	})
+
+	t.Run("occluded", func(t *testing.T) {
+		orig := checkLink
+		checkLink = func(earth, target CelestialObject, objects []CelestialObject, at time.Time) (LinkOutage, bool) {
+			return LinkOutage{Occluder: "Sun"}, true
+		}
+		t.Cleanup(func() { checkLink = orig })
+
+		_, meta, err := client.Dial(ctx, proxy, echo.String())
+		var re *client.ReplyError
+		if !errors.As(err, &re) || re.Code != SOCKS5_REP_HOST_UNREACHABLE {
+			t.Fatalf("dial: %v, want a host-unreachable reply", err)
+		}
+		if meta == nil || !meta.Occluded || meta.Body != "Mars" || meta.OnewayMs != 5 {
+			t.Errorf("metadata on refusal = %+v, want Mars, occluded", meta)
+		}
+	})
+}
@@ -1,30 +1,44 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// allowedHost reports whether h's destination policy admits host, port
+// aside.
+func allowedHost(h *SOCKSHandler, host string) bool {
+	return h.security.ValidateSocksDestination(host, 0) == nil
+}
 
 // TestSOCKSRejectsLoopbackInProduction is the regression test for the SSRF
 // finding: an unauthenticated SOCKS client must not be able to CONNECT to a
-// loopback address in production. Loopback stays allowed only in test mode.
+// loopback address in production. Loopback stays allowed only for tests.
 func TestSOCKSRejectsLoopbackInProduction(t *testing.T) {
-	orig := isTestMode.Load()
-	defer func() { isTestMode.Store(orig) }()
-
 	h := &SOCKSHandler{security: NewSecurityValidator()}
-
-	isTestMode.Store(false)
 	for _, addr := range []string{"127.0.0.1", "::1", "127.0.0.53"} {
-		if h.isAllowedDestination(addr) {
+		if allowedHost(h, addr) {
 			t.Errorf("loopback %s must be rejected in production", addr)
 		}
 	}
-	// Non-loopback IP literals are rejected in both modes.
-	if h.isAllowedDestination("169.254.169.254") {
+	// Non-loopback IP literals are always rejected.
+	if allowedHost(h, "169.254.169.254") {
 		t.Error("link-local metadata IP must be rejected")
 	}
 
-	isTestMode.Store(true)
-	if !h.isAllowedDestination("127.0.0.1") {
-		t.Error("loopback should be allowed in test mode (tests use echo servers)")
+	h.security = newTestSecurity()
+	if !allowedHost(h, "127.0.0.1") {
+		t.Error("loopback should be allowed for tests (they use echo servers)")
+	}
+	if allowedHost(h, "169.254.169.254") {
+		t.Error("link-local metadata IP must be rejected for tests too")
 	}
 }
 
@@ -51,3 +65,97 @@ func TestSOCKSPortAllowlist(t *testing.T) {
 		t.Error("non-allowlisted host must be rejected")
 	}
 }
+
+func TestSplitPortSuffix(t *testing.T) {
+	tests := []struct {
+		label string
+		name  string
+		port  uint16
+		ok    bool
+	}{
+		{"com-p8443", "com", 8443, true},
+		{"com", "com", 0, false},
+		{"my-proxy", "my-proxy", 0, false},
+		{"com-p", "com-p", 0, false},
+		{"-p80", "-p80", 0, false},
+		{"com-p99999", "com", 0, true},
+		{"com-p0", "com", 0, true},
+	}
+	for _, tt := range tests {
+		name, port, ok := splitPortSuffix(tt.label)
+		if name != tt.name || port != tt.port || ok != tt.ok {
+			t.Errorf("splitPortSuffix(%q) = %q, %d, %v; want %q, %d, %v",
+				tt.label, name, port, ok, tt.name, tt.port, tt.ok)
+		}
+	}
+}
+
+// TestProcessDomainNamePort checks the -p<port> target suffix is parsed and
+// held to the port allowlist.
+func TestProcessDomainNamePort(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	h := &SOCKSHandler{security: NewSecurityValidator()}
+	h.security.allowedPorts["8443"] = true
+	tests := []struct {
+		domain, host string
+		port         uint16
+		wantErr      bool
+	}{
+		{"example.com", "example.com", 0, false},
+		{"example.com.mars.latency.space", "example.com", 0, false},
+		{"example.com-p8443.mars.latency.space", "example.com", 8443, false},
+		{"my-proxy.example.com-p443.mars.latency.space", "my-proxy.example.com", 443, false},
+		{"example.com-p22.mars.latency.space", "", 0, true},     // not allowlisted
+		{"example.com-p70000.mars.latency.space", "", 0, true},  // out of range
+		{"example.com-p8443.vulcan.latency.space", "", 0, true}, // unknown body
+	}
+	for _, tt := range tests {
+		host, port, err := h.processDomainName(tt.domain)
+		if (err != nil) != tt.wantErr || host != tt.host || port != tt.port {
+			t.Errorf("processDomainName(%q) = %q, %d, %v; want %q, %d, error %v",
+				tt.domain, host, port, err, tt.host, tt.port, tt.wantErr)
+		}
+	}
+}
+
+// TestSOCKSConnectPortSuffix proxies to an echo server on a non-standard
+// port named only by the target label.
+func TestSOCKSConnectPortSuffix(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+
+	echo := startEchoServer(t)
+	security := newTestSecurity()
+	security.allowedHosts["localhost"] = true
+	security.allowedPorts[strconv.Itoa(echo.Port)] = true
+	proxy := startTestSOCKS(t, &Server{security: security, metrics: NewRecordingMetrics(),
+		limiter: NewRateLimiter(60, 5, 3, 0), fixedCelestialBody: "Mars", timing: fixedLatency(5 * time.Millisecond)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// The request's own port (9) is replaced by the label's.
+	target := fmt.Sprintf("localhost-p%d.mars.latency.space:9", echo.Port)
+	conn, _, err := client.Dial(ctx, proxy, target)
+	if err != nil {
+		t.Fatalf("dial %s: %v", target, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo: %q, %v", buf, err)
+	}
+
+	// A port outside the allowlist is refused before any dial.
+	if _, _, err := client.Dial(ctx, proxy, "localhost-p6379.mars.latency.space:9"); err == nil {
+		t.Error("a disallowed -p port should be refused")
+	}
+}
@@ -80,21 +80,19 @@ func setupTestEnvironment() func() {
 // This has been moved to calculations_test.go
 
 // NewTestSOCKSHandler creates a SOCKS connection handler for testing with fixed latency
-func NewTestSOCKSHandler(conn net.Conn, security *SecurityValidator, metrics *MetricsCollector) *SOCKSHandler {
-	return NewSOCKSHandler(conn, security, metrics, "")
+func NewTestSOCKSHandler(conn net.Conn, security *SecurityValidator, metrics Metrics) *SOCKSHandler {
+	h := NewSOCKSHandler(conn, security, metrics, "", nil)
+	h.timing = fixedLatency(testLatency)
+	return h
 }
 
 func TestSocksUDPAssociateAndRelay(t *testing.T) {
 	cleanup := setupTestEnvironment() // Initialize celestial objects
 	defer cleanup()                   // Restore original celestial objects after test
 
-	// Enable test mode for low latency
-	testModeCleanup := setupTestMode()
-	defer testModeCleanup() // Restore normal mode after test
-
 	// 1. Setup Test-Specific Validator and Metrics
-	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector() // Use test metrics to avoid Prometheus registration conflicts
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics() // Use test metrics to avoid Prometheus registration conflicts
 
 	// Allow loopback host for testing SOCKS destination checks
 	security.allowedHosts["127.0.0.1"] = true
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestHeliocentricSpacecraftDistances is the untagged counterpart of
+// TestDistinctSpacecraftDistances, run against the built-in objects: the deep
+// space probes have heliocentric elements in AU and must come out billions of
+// km away and distinct, not collapsed toward the Sun by a km->AU conversion.
+func TestHeliocentricSpacecraftDistances(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, ok := findObjectByName(objects, "Earth")
+	if !ok {
+		t.Fatal("Earth missing")
+	}
+	at := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	const minKm = 5e9 // well inside all three probes' distances
+	seen := map[float64]string{}
+	for _, name := range []string{"Voyager 1", "Voyager 2", "New Horizons"} {
+		obj, ok := findObjectByName(objects, name)
+		if !ok {
+			t.Fatalf("%s missing", name)
+		}
+		if elementsInKm(obj) {
+			t.Errorf("%s: heliocentric elements should be treated as AU", name)
+		}
+		d := CalculateDistance(earth, obj, objects, at)
+		if d < minKm {
+			t.Errorf("%s: %.3g km from Earth, want > %.0g", name, d, minKm)
+		}
+		if other, dup := seen[d]; dup {
+			t.Errorf("%s and %s report the same distance %.0f km", name, other, d)
+		}
+		seen[d] = name
+	}
+}
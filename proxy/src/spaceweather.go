@@ -0,0 +1,533 @@
+// spaceweather.go - seasonal and solar link degradation ("space weather").
+//
+// Real links have bad seasons. Dust lofted in a Martian storm cuts the power
+// reaching the surface and the rates landers can keep up; an active Sun
+// scintillates every deep-space signal; a geomagnetic storm disturbs the
+// ionosphere above the ground stations. -space-weather picks where the
+// conditions come from (a WeatherSource):
+//
+//	off        none (the default)
+//	synthetic  a deterministic model: Martian dust storms by season and a
+//	           solar-cycle noise term, seeded by -sim-seed
+//	PATH|URL   NOAA SWPC planetary K-index JSON, re-read every 15 minutes
+//
+// A body's SpaceWeather adds up to Jitter of extra delay to each UDP relay
+// packet, loses LossPct of them (on top of -udp-loss-pct), and takes
+// BandwidthLossPct off its downlink rate where the proxy paces data (the
+// /api/demo drip). TCP relays keep their order and rate: a real link's
+// retransmissions would turn the loss into delay the light time dwarfs.
+// /api/status-data and the info pages show the conditions and their effect.
+//
+// The synthetic model is pure: the same seed, objects and instant give the
+// same weather, so tests assert values at chosen epochs. Unlike -sim-seed's
+// rolls, 0 is a seed like any other, so the default weather is the same
+// every run.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// Condition kinds, reported in /api/status-data.
+const (
+	weatherDustStorm   = "dust_storm"
+	weatherSolar       = "solar_activity"
+	weatherGeomagnetic = "geomagnetic_storm"
+)
+
+// weatherMinLevel is the least level a condition is reported (and applied) at.
+const weatherMinLevel = 0.05
+
+// weatherEffect is what a condition does to a link at level 1; a lower
+// level scales it down linearly.
+type weatherEffect struct {
+	jitter           time.Duration
+	lossPct          float64
+	bandwidthLossPct float64
+}
+
+var weatherEffects = map[string]weatherEffect{
+	weatherDustStorm:   {200 * time.Millisecond, 20, 60},
+	weatherSolar:       {20 * time.Millisecond, 2, 10},
+	weatherGeomagnetic: {50 * time.Millisecond, 5, 30},
+}
+
+// WeatherCondition is one cause of degradation.
+type WeatherCondition struct {
+	Kind   string  `json:"kind"`             // weatherDustStorm, weatherSolar or weatherGeomagnetic
+	Level  float64 `json:"level"`            // 0-1
+	Detail string  `json:"detail,omitempty"` // e.g. "global, Ls 255°" or "Kp 6.3 (G2)"
+}
+
+// SpaceWeather is a body's link degradation at one instant. The zero value
+// is a clear link.
+type SpaceWeather struct {
+	Conditions       []WeatherCondition
+	Jitter           time.Duration // a packet is delayed by up to this much more
+	LossPct          float64       // extra share of packets lost
+	BandwidthLossPct float64       // share of the downlink rate lost
+}
+
+// clear reports whether nothing degrades the link.
+func (w SpaceWeather) clear() bool { return len(w.Conditions) == 0 }
+
+// with adds a condition of kind at level, combining its effect with the
+// others: jitter adds, and loss and bandwidth loss compound as independent
+// chances.
+func (w SpaceWeather) with(kind string, level float64, detail string) SpaceWeather {
+	level = min(max(level, 0), 1)
+	if level < weatherMinLevel {
+		return w
+	}
+	e := weatherEffects[kind]
+	w.Conditions = append(w.Conditions, WeatherCondition{Kind: kind, Level: level, Detail: detail})
+	w.Jitter += time.Duration(level * float64(e.jitter))
+	w.LossPct = 100 - (100-w.LossPct)*(1-level*e.lossPct/100)
+	w.BandwidthLossPct = 100 - (100-w.BandwidthLossPct)*(1-level*e.bandwidthLossPct/100)
+	return w
+}
+
+// downlink returns a data rate of bps after the bandwidth loss.
+func (w SpaceWeather) downlink(bps float64) float64 {
+	return bps * (1 - w.BandwidthLossPct/100)
+}
+
+// jitter draws one packet's extra delay from the simulation source.
+func (w SpaceWeather) jitter() time.Duration {
+	if w.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(simFloat64() * float64(w.Jitter))
+}
+
+// WeatherSource says what the space weather on a body's link is.
+type WeatherSource interface {
+	// Name identifies the source in /api/status-data.
+	Name() string
+	// Weather returns body's conditions at t; objects place the bodies.
+	Weather(body string, objects []celestial.CelestialObject, t time.Time) SpaceWeather
+}
+
+// weatherPtr holds the active source; nil means -space-weather off.
+var weatherPtr atomic.Pointer[WeatherSource]
+
+func weatherSource() WeatherSource {
+	if p := weatherPtr.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// setWeatherSource replaces the source; nil turns space weather off.
+func setWeatherSource(src WeatherSource) {
+	if src == nil {
+		weatherPtr.Store(nil)
+		return
+	}
+	weatherPtr.Store(&src)
+}
+
+// bodyWeather is body's weather from the active source; clear when off.
+func bodyWeather(body string, objects []celestial.CelestialObject, t time.Time) SpaceWeather {
+	if src := weatherSource(); src != nil {
+		return src.Weather(body, objects, t)
+	}
+	return SpaceWeather{}
+}
+
+// parseWeatherSource reads -space-weather. A NOAA source comes back
+// unloaded; seed seeds the synthetic model.
+func parseWeatherSource(spec string, seed int64) (WeatherSource, error) {
+	switch spec = strings.TrimSpace(spec); strings.ToLower(spec) {
+	case "", "off":
+		return nil, nil
+	case "synthetic":
+		return SyntheticWeather{Seed: seed}, nil
+	}
+	w := &NOAAWeather{Source: spec}
+	if strings.Contains(spec, "://") && !w.isURL() {
+		return nil, fmt.Errorf("%q: want off, synthetic, a file or an http(s) URL", spec)
+	}
+	return w, nil
+}
+
+// weatherExempt reports whether body is never degraded: Earth is the other
+// end of every link, and the Sun isn't a destination.
+func weatherExempt(body string) bool {
+	return body == "Earth" || body == "Sun"
+}
+
+// --- Synthetic model ---
+
+const (
+	// marsLsOffsetDeg is Mars's heliocentric ecliptic longitude at its
+	// northern spring equinox (Ls 0).
+	marsLsOffsetDeg = 85.061
+	// marsYearDays is a Mars year.
+	marsYearDays = 686.9726
+	// The dust storm season, Ls 180-330 (southern spring and summer),
+	// peaks midway.
+	dustSeasonStartLs = 180.0
+	dustSeasonEndLs   = 330.0
+	// globalDustStormOdds is the share of Mars years with a
+	// planet-encircling storm; the rest see regional ones.
+	globalDustStormOdds = 0.25
+	// solarCycleDays is the length of a sunspot cycle.
+	solarCycleDays = 11 * 365.25
+)
+
+// marsYearOne is the start of Mars Year 1 (Clancy et al.), the count the
+// storm years are numbered by.
+var marsYearOne = time.Date(1955, 4, 11, 0, 0, 0, 0, time.UTC)
+
+// solarCycleMinimum is the minimum that began solar cycle 25.
+var solarCycleMinimum = time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)
+
+// SyntheticWeather is the deterministic model. Every Mars year gets a dust
+// storm season of a strength drawn from Seed, a global storm in about one
+// year in four; every day a solar activity level that follows the 11-year
+// cycle with noise drawn from Seed.
+type SyntheticWeather struct {
+	Seed int64
+}
+
+func (SyntheticWeather) Name() string { return "synthetic" }
+
+func (w SyntheticWeather) Weather(body string, objects []celestial.CelestialObject, t time.Time) SpaceWeather {
+	var out SpaceWeather
+	if weatherExempt(body) {
+		return out
+	}
+	if body == "Mars" {
+		if level, detail := w.dustStorm(objects, t); level > 0 {
+			out = out.with(weatherDustStorm, level, detail)
+		}
+	}
+	return out.with(weatherSolar, w.solarActivity(t), "")
+}
+
+// dustStorm returns the storm level on Mars at t, 0 out of season.
+func (w SyntheticWeather) dustStorm(objects []celestial.CelestialObject, t time.Time) (float64, string) {
+	ls, ok := marsSolarLongitude(objects, t)
+	if !ok || ls < dustSeasonStartLs || ls > dustSeasonEndLs {
+		return 0, ""
+	}
+	season := math.Pow(math.Sin(math.Pi*(ls-dustSeasonStartLs)/(dustSeasonEndLs-dustSeasonStartLs)), 2)
+	// The storm year is numbered from t, not Ls, but the season is half a
+	// year from where the two could disagree.
+	u := weatherNoise(w.Seed, weatherDustStorm, marsYear(t))
+	scale, severity := "regional", 0.3+0.4*u/(1-globalDustStormOdds)
+	if u >= 1-globalDustStormOdds {
+		scale, severity = "global", 1
+	}
+	return season * severity, fmt.Sprintf("%s, Ls %.0f°", scale, ls)
+}
+
+// solarActivity returns the solar level at t: the cycle's phase, from 60%
+// to all of it day by day.
+func (w SyntheticWeather) solarActivity(t time.Time) float64 {
+	days := t.Sub(solarCycleMinimum).Hours() / 24
+	phase := math.Sin(math.Pi * days / solarCycleDays)
+	noise := weatherNoise(w.Seed, weatherSolar, int64(math.Floor(days)))
+	return phase * phase * (0.6 + 0.4*noise)
+}
+
+// marsSolarLongitude returns Mars's season angle Ls at t, degrees: 0 at the
+// northern spring equinox, 90 at summer solstice and so on.
+func marsSolarLongitude(objects []celestial.CelestialObject, t time.Time) (float64, bool) {
+	mars, ok := findObjectByName(objects, "Mars")
+	if !ok {
+		return 0, false
+	}
+	p := GetObjectPosition(mars, objects, t)
+	return celestial.NormalizeDegrees(math.Atan2(p.Y, p.X)*180/math.Pi - marsLsOffsetDeg), true
+}
+
+// marsYear numbers the Mars year t falls in.
+func marsYear(t time.Time) int64 {
+	return 1 + int64(math.Floor(t.Sub(marsYearOne).Hours()/24/marsYearDays))
+}
+
+// weatherNoise is a value in [0, 1) fixed by seed, stream and n.
+func weatherNoise(seed int64, stream string, n int64) float64 {
+	x := uint64(seed) ^ uint64(n)*0x9e3779b97f4a7c15
+	for _, c := range []byte(stream) {
+		x = (x ^ uint64(c)) * 0x100000001b3
+	}
+	// splitmix64's finalizer
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+// --- NOAA K-index ---
+
+const (
+	// noaaRefreshInterval is how often a NOAA source is read again.
+	noaaRefreshInterval = 15 * time.Minute
+	// noaaMaxAge is how long a Kp reading stands for; the index is
+	// published every 3 hours.
+	noaaMaxAge = 6 * time.Hour
+	// noaaMaxBytes caps a read of the source.
+	noaaMaxBytes = 8 << 20
+)
+
+// NOAAWeather reads the planetary K-index from NOAA's Space Weather
+// Prediction Center: a file or an http(s) URL of either
+// services.swpc.noaa.gov/json/planetary_k_index_1m.json (objects with
+// time_tag and estimated_kp or kp_index) or
+// services.swpc.noaa.gov/products/noaa-planetary-k-index.json (rows under a
+// header row naming time_tag and Kp). Kp 5 and up (G1-G5 storms) degrades
+// every link at its Earth end.
+type NOAAWeather struct {
+	Source string // path or URL
+
+	mu      sync.RWMutex
+	samples []kpSample // by time
+}
+
+// kpSample is one K-index reading.
+type kpSample struct {
+	at time.Time
+	kp float64
+}
+
+func (*NOAAWeather) Name() string { return "noaa" }
+
+func (w *NOAAWeather) Weather(body string, _ []celestial.CelestialObject, t time.Time) SpaceWeather {
+	var out SpaceWeather
+	if weatherExempt(body) {
+		return out
+	}
+	w.mu.RLock()
+	samples := w.samples
+	w.mu.RUnlock()
+	i := sort.Search(len(samples), func(i int) bool { return samples[i].at.After(t) })
+	if i == 0 || t.Sub(samples[i-1].at) > noaaMaxAge {
+		return out // no reading, or too old to say
+	}
+	kp := samples[i-1].kp
+	g := min(max(int(math.Floor(kp))-4, 1), 5)
+	return out.with(weatherGeomagnetic, (kp-4)/5, fmt.Sprintf("Kp %.1f (G%d)", kp, g))
+}
+
+// isURL reports whether the source is fetched rather than read from disk.
+func (w *NOAAWeather) isURL() bool {
+	return strings.HasPrefix(w.Source, "http://") || strings.HasPrefix(w.Source, "https://")
+}
+
+// load reads the source, replacing the readings; on an error the old ones
+// stay.
+func (w *NOAAWeather) load(ctx context.Context) error {
+	var data []byte
+	var err error
+	if w.isURL() {
+		data, err = fetchNOAA(ctx, w.Source)
+	} else {
+		data, err = os.ReadFile(w.Source)
+	}
+	if err != nil {
+		return err
+	}
+	samples, err := parseKpIndex(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", w.Source, err)
+	}
+	w.mu.Lock()
+	w.samples = samples
+	w.mu.Unlock()
+	return nil
+}
+
+// refresh reloads the source every interval until stop is closed.
+func (w *NOAAWeather) refresh(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := w.load(ctx); err != nil {
+				log.Printf("Space weather: keeping the previous readings: %v", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// fetchNOAA GETs url, at most noaaMaxBytes of it.
+func fetchNOAA(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, noaaMaxBytes))
+}
+
+// noaaTimeLayouts are the time_tag formats SWPC uses, all UTC.
+var noaaTimeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05.000", "2006-01-02 15:04:05", time.RFC3339}
+
+// parseKpIndex reads either NOAA format into readings sorted by time.
+func parseKpIndex(data []byte) ([]kpSample, error) {
+	var samples []kpSample
+	data = bytes.TrimSpace(data)
+	var objects []map[string]interface{}
+	var rows [][]interface{}
+	switch {
+	case json.Unmarshal(data, &objects) == nil:
+		for _, o := range objects {
+			kp, ok := o["estimated_kp"]
+			if !ok {
+				kp = o["kp_index"]
+			}
+			s, err := kpReading(o["time_tag"], kp)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, s)
+		}
+	case json.Unmarshal(data, &rows) == nil:
+		if len(rows) == 0 {
+			break
+		}
+		at, kp := -1, -1
+		for i, name := range rows[0] {
+			switch name {
+			case "time_tag":
+				at = i
+			case "Kp", "kp":
+				kp = i
+			}
+		}
+		if at < 0 || kp < 0 {
+			return nil, errors.New("header row names no time_tag and Kp columns")
+		}
+		for _, row := range rows[1:] {
+			if len(row) <= max(at, kp) {
+				return nil, fmt.Errorf("short row %v", row)
+			}
+			s, err := kpReading(row[at], row[kp])
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, s)
+		}
+	default:
+		return nil, errors.New("not a NOAA K-index JSON array")
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("no K-index readings")
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].at.Before(samples[j].at) })
+	return samples, nil
+}
+
+// kpReading parses a time_tag and a Kp given as a number or a string.
+func kpReading(tag, value interface{}) (kpSample, error) {
+	s, ok := tag.(string)
+	if !ok {
+		return kpSample{}, fmt.Errorf("time_tag %v: want a string", tag)
+	}
+	var at time.Time
+	var err error
+	for _, layout := range noaaTimeLayouts {
+		if at, err = time.ParseInLocation(layout, s, time.UTC); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return kpSample{}, fmt.Errorf("time_tag %q: %v", s, err)
+	}
+	var kp float64
+	switch v := value.(type) {
+	case float64:
+		kp = v
+	case string:
+		if kp, err = strconv.ParseFloat(v, 64); err != nil {
+			return kpSample{}, fmt.Errorf("Kp %q at %s: %v", v, s, err)
+		}
+	default:
+		return kpSample{}, fmt.Errorf("Kp %v at %s: want a number", value, s)
+	}
+	if kp < 0 || kp > 9 {
+		return kpSample{}, fmt.Errorf("Kp %g at %s: want 0-9", kp, s)
+	}
+	return kpSample{at: at.UTC(), kp: kp}, nil
+}
+
+// WeatherReport is a body's space weather in /api/status-data.
+type WeatherReport struct {
+	Source           string             `json:"source"` // "synthetic" or "noaa"
+	Conditions       []WeatherCondition `json:"conditions"`
+	JitterMs         float64            `json:"jitter_ms"`
+	LossPct          float64            `json:"loss_pct"`
+	BandwidthLossPct float64            `json:"bandwidth_reduction_pct"`
+}
+
+// report is w as source reports it, nil when the link is clear.
+func (w SpaceWeather) report(source string) *WeatherReport {
+	if w.clear() {
+		return nil
+	}
+	round := func(v float64) float64 { return math.Round(v*100) / 100 }
+	conditions := make([]WeatherCondition, len(w.Conditions))
+	for i, c := range w.Conditions {
+		c.Level = round(c.Level)
+		conditions[i] = c
+	}
+	return &WeatherReport{
+		Source:           source,
+		Conditions:       conditions,
+		JitterMs:         round(durationMs(w.Jitter)),
+		LossPct:          round(w.LossPct),
+		BandwidthLossPct: round(w.BandwidthLossPct),
+	}
+}
+
+// spaceWeatherRows renders w for the info page in l, with the downlink
+// rate down it leaves.
+func spaceWeatherRows(l Locale, w SpaceWeather, down float64) []impactRow {
+	rows := make([]impactRow, 0, len(w.Conditions)+3)
+	for _, c := range w.Conditions {
+		value := l.Number(c.Level*100, 0) + " %"
+		if c.Detail != "" {
+			value += " (" + c.Detail + ")"
+		}
+		rows = append(rows, impactRow{l.T("weather." + c.Kind), value})
+	}
+	return append(rows,
+		impactRow{l.T("weather.jitter"), l.Duration(w.Jitter)},
+		impactRow{l.T("weather.loss"), l.Number(w.LossPct, 1) + " %"},
+		impactRow{l.T("weather.downlink"), formatBitRate(roundSignificant(w.downlink(down), 3))},
+	)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// withWeather sets the space weather source for one test.
+func withWeather(t *testing.T, src WeatherSource) {
+	t.Helper()
+	orig := weatherSource()
+	setWeatherSource(src)
+	t.Cleanup(func() { setWeatherSource(orig) })
+}
+
+// stormEpoch is deep in Mars Year 29's dust season, which seed 1 makes a
+// global storm, and months after a solar minimum, so the other bodies are
+// clear.
+var stormEpoch = time.Date(2009, 5, 1, 0, 0, 0, 0, time.UTC)
+
+// TestMarsSolarLongitude checks Ls against the northern spring equinoxes
+// that began Mars Years 37 and 38.
+func TestMarsSolarLongitude(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	for _, at := range []time.Time{
+		time.Date(2022, 12, 26, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 11, 12, 0, 0, 0, 0, time.UTC),
+	} {
+		ls, ok := marsSolarLongitude(objects, at)
+		if d := math.Abs(math.Remainder(ls, 360)); !ok || d > 1 {
+			t.Errorf("Ls on %s = %.2f°, want 0", at.Format("2006-01-02"), ls)
+		}
+	}
+}
+
+// TestSyntheticWeather pins the model's values at chosen epochs.
+func TestSyntheticWeather(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	march2026 := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		seed   int64
+		body   string
+		at     time.Time
+		kinds  []string
+		detail string // of the first condition
+		loss   float64
+		jitter time.Duration
+		bwLoss float64
+	}{
+		{1, "Mars", stormEpoch, []string{weatherDustStorm}, "global, Ls 257°", 19.9616, 199616397, 59.8849},
+		{1, "Jupiter", stormEpoch, nil, "", 0, 0, 0},
+		{0, "Mars", march2026, []string{weatherDustStorm, weatherSolar}, "regional, Ls 235°", 7.6934, 77883697, 24.9663},
+		{0, "Jupiter", march2026, []string{weatherSolar}, "", 1.5125, 15124895, 7.5624},
+		{7, "Jupiter", march2026, []string{weatherSolar}, "", 1.6843, 16842685, 8.4213},
+		{0, "Earth", march2026, nil, "", 0, 0, 0},
+	} {
+		w := SyntheticWeather{Seed: tc.seed}.Weather(tc.body, objects, tc.at)
+		var kinds []string
+		for _, c := range w.Conditions {
+			kinds = append(kinds, c.Kind)
+		}
+		if !reflect.DeepEqual(kinds, tc.kinds) || len(kinds) > 0 && w.Conditions[0].Detail != tc.detail {
+			t.Errorf("seed %d %s %s: conditions %+v, want %v (%q)", tc.seed, tc.body, tc.at.Format("2006-01-02"), w.Conditions, tc.kinds, tc.detail)
+		}
+		if math.Abs(w.LossPct-tc.loss) > 1e-4 || w.Jitter != tc.jitter || math.Abs(w.BandwidthLossPct-tc.bwLoss) > 1e-4 {
+			t.Errorf("seed %d %s %s: loss %.4f%%, jitter %d, bandwidth -%.4f%%; want %.4f%%, %d, -%.4f%%",
+				tc.seed, tc.body, tc.at.Format("2006-01-02"), w.LossPct, w.Jitter, w.BandwidthLossPct, tc.loss, tc.jitter, tc.bwLoss)
+		}
+		if again := (SyntheticWeather{Seed: tc.seed}).Weather(tc.body, objects, tc.at); !reflect.DeepEqual(again, w) {
+			t.Errorf("seed %d %s: not pure: %+v then %+v", tc.seed, tc.body, w, again)
+		}
+	}
+}
+
+// TestNOAAWeather checks both SWPC formats, from a file and a URL, and that
+// a reading only stands for noaaMaxAge.
+func TestNOAAWeather(t *testing.T) {
+	oneMinute := `[{"time_tag":"2024-05-10T18:00:00","kp_index":8,"estimated_kp":8.33,"kp":"8M"},
+		{"time_tag":"2024-05-10T12:00:00","kp_index":3,"estimated_kp":3.0,"kp":"3o"}]`
+	product := `[["time_tag","Kp","a_running","station_count"],
+		["2024-05-10 12:00:00.000","3.00","15","8"],["2024-05-10 18:00:00.000","8.33","300","8"]]`
+	path := filepath.Join(t.TempDir(), "kp.json")
+	if err := os.WriteFile(path, []byte(oneMinute), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(product))
+	}))
+	defer srv.Close()
+
+	for _, source := range []string{path, srv.URL} {
+		src, err := parseWeatherSource(source, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		noaa := src.(*NOAAWeather)
+		if err := noaa.load(context.Background()); err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		for _, tc := range []struct {
+			body  string
+			at    time.Time
+			level float64 // 0: clear
+		}{
+			{"Mars", time.Date(2024, 5, 10, 13, 0, 0, 0, time.UTC), 0},     // Kp 3, quiet
+			{"Mars", time.Date(2024, 5, 10, 20, 0, 0, 0, time.UTC), 0.866}, // Kp 8.33, G4
+			{"Voyager 1", time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC), 0.866},
+			{"Earth", time.Date(2024, 5, 10, 20, 0, 0, 0, time.UTC), 0},
+			{"Mars", time.Date(2024, 5, 11, 0, 1, 0, 0, time.UTC), 0},  // the reading is too old
+			{"Mars", time.Date(2024, 5, 10, 11, 0, 0, 0, time.UTC), 0}, // before any reading
+		} {
+			w := noaa.Weather(tc.body, nil, tc.at)
+			switch {
+			case tc.level == 0 && !w.clear():
+				t.Errorf("%s: %s at %s: %+v, want clear", source, tc.body, tc.at.Format("15:04"), w.Conditions)
+			case tc.level > 0 && (len(w.Conditions) != 1 || math.Abs(w.Conditions[0].Level-tc.level) > 1e-3 || w.Conditions[0].Detail != "Kp 8.3 (G4)"):
+				t.Errorf("%s: %s at %s: %+v, want G4 at %v", source, tc.body, tc.at.Format("15:04"), w.Conditions, tc.level)
+			}
+		}
+	}
+
+	for _, bad := range []string{`{}`, `[]`, `[["a","b"],["x","y"]]`, `[{"time_tag":"2024-05-10T18:00:00","kp_index":12}]`, `[{"time_tag":"soon","kp_index":1}]`} {
+		if _, err := parseKpIndex([]byte(bad)); err == nil {
+			t.Errorf("%s parsed", bad)
+		}
+	}
+	if _, err := parseWeatherSource("ftp://example.com/kp.json", 0); err == nil {
+		t.Error("an ftp URL was accepted")
+	}
+	if src, err := parseWeatherSource("off", 0); src != nil || err != nil {
+		t.Errorf("off: %v, %v", src, err)
+	}
+}
+
+// TestSpaceWeatherUDP checks a body in a dust storm loses packets on the
+// UDP relay that a clear body's get through.
+func TestSpaceWeatherUDP(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	withWeather(t, SyntheticWeather{Seed: 1})
+	fakeLinkClock(t, stormEpoch)
+	orig := checkLink
+	checkLink = func(earth, target CelestialObject, objects []CelestialObject, at time.Time) (LinkOutage, bool) {
+		return LinkOutage{}, false
+	}
+	t.Cleanup(func() { checkLink = orig })
+	t.Cleanup(func() { seedSimRand(0) })
+	seedSimRand(3)
+
+	const sent = 100
+	delivered := func(body string) (int, *RecordingMetrics) {
+		security, metrics := newTestSecurity(), NewRecordingMetrics()
+		sink, seen := startUDPSink(t, security)
+		code, relay := udpAssociateWith(t, security, metrics, func(h *SOCKSHandler) { h.fixedCelestialBody = body })
+		if code != SOCKS5_REP_SUCCESS {
+			t.Fatalf("%s: UDP ASSOCIATE failed: 0x%02x", body, code)
+		}
+		client, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		for i := 0; i < sent; i++ {
+			client.WriteTo(buildUDPSocksPacket(sink, []byte{byte(i)}), relay)
+			time.Sleep(time.Millisecond) // under the relay's packet rate cap
+		}
+		// Up to 200 ms of storm jitter on a 1 ms light time.
+		time.Sleep(400 * time.Millisecond)
+		return len(seen()), metrics
+	}
+
+	if got, _ := delivered("Jupiter"); got != sent {
+		t.Errorf("Jupiter, in clear weather: %d of %d delivered", got, sent)
+	}
+	got, metrics := delivered("Mars")
+	if got > sent*9/10 || got < sent/2 {
+		t.Errorf("Mars, in a global dust storm (20%% loss): %d of %d delivered", got, sent)
+	}
+	if dropped := metrics.Count(metricUDPImpaired, "Mars", udpImpairDrop); int(dropped) != sent-got {
+		t.Errorf("%v drops counted, %d packets missing", dropped, sent-got)
+	}
+}
+
+// TestSpaceWeatherReported checks /api/status-data, the info page and the
+// demo drip's pacing show the storm, and nothing shows with the source off.
+func TestSpaceWeatherReported(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	fakeDistanceClock(t, stormEpoch)
+	fakeLinkClock(t, stormEpoch)
+	origTemplate := infoTemplate
+	defer func() { infoTemplate = origTemplate }()
+	infoTemplate = template.Must(template.ParseFiles("templates/info_page.html"))
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), demoLimiter: newDemoLimiter(),
+		timing: fixedLatency(time.Millisecond)}
+
+	status := func() map[string]*WeatherReport {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/status-data", nil))
+		loadOpenAPI(t).checkResponse(t, http.MethodGet, "/api/status-data", rec)
+		_, out := getStatusData(t, s, "")
+		reports := make(map[string]*WeatherReport)
+		for _, entries := range out.Objects {
+			for _, e := range entries {
+				reports[e.Name] = e.SpaceWeather
+			}
+		}
+		return reports
+	}
+	page := func() string {
+		rec := httptest.NewRecorder()
+		s.displayCelestialInfo(rec, httptest.NewRequest(http.MethodGet, "/", nil), currentSnapshot(distanceClock()), "Mars")
+		return rec.Body.String()
+	}
+	dripBps := func() string {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://mars.latency.space/api/demo/drip?bytes=1", nil))
+		return rec.Header().Get("X-Latency-Space-Drip-Bps")
+	}
+
+	clearBps := dripBps()
+	if r := status(); r["Mars"] != nil {
+		t.Errorf("space weather off, but Mars reports %+v", r["Mars"])
+	}
+	if strings.Contains(page(), "Space Weather") {
+		t.Error("space weather off, but the Mars page has a section")
+	}
+
+	withWeather(t, SyntheticWeather{Seed: 1})
+	reports := status()
+	mars := reports["Mars"]
+	if mars == nil || mars.Source != "synthetic" || len(mars.Conditions) != 1 || mars.Conditions[0].Kind != weatherDustStorm ||
+		mars.LossPct != 19.96 || mars.JitterMs != 199.62 || mars.BandwidthLossPct != 59.88 {
+		t.Errorf("Mars reports %+v", mars)
+	}
+	if reports["Jupiter"] != nil {
+		t.Errorf("Jupiter, in clear weather, reports %+v", reports["Jupiter"])
+	}
+	if p := page(); !strings.Contains(p, "<h2>Space Weather</h2>") || !strings.Contains(p, "Dust storm: <strong>100 % (global, Ls 257°)</strong>") {
+		t.Errorf("Mars page has no storm section:\n%s", p)
+	}
+	if got := dripBps(); got == clearBps || got == "" {
+		t.Errorf("drip paced at %s bps in the storm and %s bps without", got, clearBps)
+	}
+}
@@ -0,0 +1,169 @@
+// state.go - a body's orbital state at one instant, for "where is it now".
+//
+//	GET /api/state?body=mars[&t=RFC3339]
+//
+// ?at= is accepted for t. Either wins over a pinned epoch (epoch.go).
+//
+// The elements are the ones the position code itself evaluates (elementsAt),
+// so the position they give is exactly the one reported. They are
+// heliocentric for planets, dwarf planets, asteroids, comets and spacecraft
+// orbiting the Sun, and relative to the parent for moons and spacecraft
+// orbiting a planet. Earth's Moon is placed by the lunar theory, not by
+// elements, so it has none. A comet past e = 1 has a negative semi-major
+// axis and a hyperbolic mean anomaly (comets.go).
+//
+// The velocity is approximate: the heliocentric position is differenced
+// across stateVelocityStep either side of t, not derived from the elements.
+// Over a minute the curvature of even Mercury's orbit is negligible, but a
+// moon's velocity includes its parent's and the lunar theory's wobble.
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// stateVelocityStep is how far either side of t positions are differenced
+// for the velocity.
+const stateVelocityStep = 30 * time.Second
+
+// StateElements are the osculating elements in /api/state, angles in degrees.
+type StateElements struct {
+	SemiMajorAxis float64 `json:"semi_major_axis_au"`
+	Eccentricity  float64 `json:"eccentricity"`
+	Inclination   float64 `json:"inclination_deg"`
+	AscendingNode float64 `json:"ascending_node_deg"`        // Ω
+	ArgPeriapsis  float64 `json:"argument_of_periapsis_deg"` // ω
+	MeanAnomaly   float64 `json:"mean_anomaly_deg"`          // M
+	TrueAnomaly   float64 `json:"true_anomaly_deg"`          // ν
+}
+
+// velocityKmS is a heliocentric ecliptic velocity in km/s.
+type velocityKmS struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// StateResponse is the JSON returned by /api/state.
+type StateResponse struct {
+	Body          string         `json:"body"`
+	Timestamp     time.Time      `json:"timestamp"`
+	Frame         string         `json:"frame"`            // of the elements: "heliocentric" or "parent"
+	Parent        string         `json:"parent,omitempty"` // set when Frame is "parent"
+	Elements      *StateElements `json:"elements,omitempty"`
+	Position      positionAU     `json:"position_au"` // heliocentric ecliptic
+	Velocity      velocityKmS    `json:"velocity_km_s"`
+	Speed         float64        `json:"speed_km_s"`
+	SunDistance   float64        `json:"sun_distance_km"`
+	EarthDistance float64        `json:"earth_distance_km"` // geometric
+	PeriodDays    float64        `json:"period_days,omitempty"`
+	OrbitalPhase  *float64       `json:"orbital_phase,omitempty"` // fraction of the period since periapsis
+}
+
+// handleState serves /api/state.
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	name := strings.TrimSpace(q.Get("body"))
+	if name == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.state_body")
+		return
+	}
+
+	at := simTime(time.Now())
+	ts := q.Get("t")
+	if ts == "" {
+		ts = q.Get("at")
+	}
+	if ts != "" {
+		parsed, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid 't' (want RFC3339): " + err.Error()})
+			return
+		}
+		at = parsed
+	}
+
+	resp, status, err := computeState(name, at)
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// computeState builds the /api/state response for the named body at time
+// at. On failure it returns the HTTP status the caller should use alongside
+// the error.
+func computeState(name string, at time.Time) (*StateResponse, int, error) {
+	objects := getCelestialObjects()
+	obj, ok := findObjectByName(objects, name)
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Errorf("unknown celestial body: %q", name)
+	}
+	if obj.Type == "star" {
+		return nil, http.StatusBadRequest, fmt.Errorf("%s does not orbit anything", obj.Name)
+	}
+
+	pos := GetObjectPosition(obj, objects, at)
+	before := GetObjectPosition(obj, objects, at.Add(-stateVelocityStep))
+	after := GetObjectPosition(obj, objects, at.Add(stateVelocityStep))
+	v := after.Subtract(before).Scale(AU / (2 * stateVelocityStep.Seconds()))
+
+	resp := &StateResponse{
+		Body:        obj.Name,
+		Timestamp:   at.UTC(),
+		Frame:       "heliocentric",
+		Parent:      orbitFrameParent(obj),
+		Position:    positionAU{X: pos.X, Y: pos.Y, Z: pos.Z},
+		Velocity:    velocityKmS{X: v.X, Y: v.Y, Z: v.Z},
+		Speed:       v.Magnitude(),
+		SunDistance: pos.Magnitude() * AU,
+	}
+	if resp.Parent != "" {
+		resp.Frame = "parent"
+	}
+	if earth, ok := findObjectByName(objects, "Earth"); ok {
+		resp.EarthDistance = GetObjectPosition(earth, objects, at).Subtract(pos).Magnitude() * AU
+	}
+
+	if isEarthMoon(obj) {
+		return resp, http.StatusOK, nil
+	}
+	el := elementsAt(obj, centuriesSinceJ2000TDB(at))
+	resp.Elements = stateElements(obj, el)
+	if hasClosedOrbit(obj) {
+		resp.PeriodDays = orbitPeriodDays(obj)
+		phase := el.M / (2 * math.Pi)
+		resp.OrbitalPhase = &phase
+	}
+	return resp, http.StatusOK, nil
+}
+
+// stateElements converts el to degrees, and its semi-major axis to AU where
+// obj's elements are in km.
+func stateElements(obj celestial.CelestialObject, el orbitalElements) *StateElements {
+	a := el.a
+	if elementsInKm(obj) {
+		a /= celestial.AU
+	}
+	var nu float64
+	if el.nearParabolic() || el.e < 1 {
+		x, y := el.perifocal()
+		nu = normalizeRadians(math.Atan2(y, x))
+	}
+	return &StateElements{
+		SemiMajorAxis: a,
+		Eccentricity:  el.e,
+		Inclination:   radToDeg(el.i),
+		AscendingNode: radToDeg(el.node),
+		ArgPeriapsis:  radToDeg(el.w),
+		MeanAnomaly:   radToDeg(el.M),
+		TrueAnomaly:   radToDeg(nu),
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func stateRequest(t *testing.T, query string) (int, *StateResponse) {
+	t.Helper()
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/state?"+query, nil))
+	var out StateResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("response not JSON (%d): %q", rec.Code, rec.Body.String())
+	}
+	return rec.Code, &out
+}
+
+// TestStateEarthSpeed checks the differenced velocity: Earth moves at
+// 29.3-30.3 km/s between aphelion and perihelion, about 1 AU from the Sun.
+func TestStateEarthSpeed(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+
+	for _, at := range []string{"2026-01-03T00:00:00Z", "2026-07-04T00:00:00Z", "2030-10-16T12:00:00Z"} {
+		code, s := stateRequest(t, "body=earth&t="+at)
+		if code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", at, code)
+		}
+		if s.Speed < 29.2 || s.Speed > 30.4 {
+			t.Errorf("%s: Earth moving at %.2f km/s, want about 29.8", at, s.Speed)
+		}
+		if au := s.SunDistance / AU; au < 0.98 || au > 1.02 {
+			t.Errorf("%s: Earth %.3f AU from the Sun", at, au)
+		}
+		if s.EarthDistance != 0 {
+			t.Errorf("%s: Earth %.0f km from itself", at, s.EarthDistance)
+		}
+		if s.Frame != "heliocentric" || s.Elements == nil || s.OrbitalPhase == nil {
+			t.Fatalf("%s: frame %q, elements %v, phase %v", at, s.Frame, s.Elements, s.OrbitalPhase)
+		}
+		// Perihelion is in early January.
+		if at == "2026-01-03T00:00:00Z" && *s.OrbitalPhase > 0.02 && *s.OrbitalPhase < 0.98 {
+			t.Errorf("orbital phase %.3f at perihelion", *s.OrbitalPhase)
+		}
+	}
+}
+
+// TestStateMarsTrueAnomaly checks the reported elements describe the
+// reported position: rotating the position into the orbital plane, its angle
+// from the ascending node must be ω + ν, and its distance a(1-e²)/(1+e cos ν).
+func TestStateMarsTrueAnomaly(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+
+	for _, at := range []string{"2026-10-16T00:00:00Z", "2027-06-01T00:00:00Z", "2028-03-01T00:00:00Z"} {
+		code, s := stateRequest(t, "body=mars&t="+at)
+		if code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", at, code)
+		}
+		el := s.Elements
+		node, inc := el.AscendingNode*math.Pi/180, el.Inclination*math.Pi/180
+		p := s.Position
+		x := p.X*math.Cos(node) + p.Y*math.Sin(node)
+		y := (-p.X*math.Sin(node) + p.Y*math.Cos(node)) * math.Cos(inc)
+		y += p.Z * math.Sin(inc)
+		u := math.Atan2(y, x) * 180 / math.Pi
+		diff := math.Mod(u-(el.ArgPeriapsis+el.TrueAnomaly)+720, 360)
+		if diff > 180 {
+			diff -= 360
+		}
+		if math.Abs(diff) > 1e-6 {
+			t.Errorf("%s: position %.6f deg from the node, elements say ω+ν = %.6f", at, u, el.ArgPeriapsis+el.TrueAnomaly)
+		}
+		nu := el.TrueAnomaly * math.Pi / 180
+		r := el.SemiMajorAxis * (1 - el.Eccentricity*el.Eccentricity) / (1 + el.Eccentricity*math.Cos(nu))
+		if got := s.SunDistance / AU; math.Abs(got-r) > 1e-9 {
+			t.Errorf("%s: %.9f AU from the Sun, elements say %.9f", at, got, r)
+		}
+		if want := el.MeanAnomaly / 360; math.Abs(*s.OrbitalPhase-want) > 1e-12 {
+			t.Errorf("%s: orbital phase %v, mean anomaly says %v", at, *s.OrbitalPhase, want)
+		}
+		if s.Speed < 21 || s.Speed > 27 {
+			t.Errorf("%s: Mars moving at %.2f km/s", at, s.Speed)
+		}
+	}
+}
+
+// TestStateFrames checks moons' elements are parent-relative, Earth's Moon
+// has none, and bad requests are refused.
+func TestStateFrames(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+
+	code, s := stateRequest(t, "body=phobos")
+	if code != http.StatusOK || s.Frame != "parent" || s.Parent != "Mars" || s.Elements == nil {
+		t.Errorf("phobos: %d, frame %q relative to %q, elements %v", code, s.Frame, s.Parent, s.Elements)
+	} else if a := s.Elements.SemiMajorAxis * celestial.AU; a < 9000 || a > 9700 {
+		t.Errorf("phobos semi-major axis %.0f km, want about 9376", a)
+	}
+
+	code, s = stateRequest(t, "body=moon")
+	if code != http.StatusOK || s.Elements != nil || s.OrbitalPhase != nil {
+		t.Errorf("moon: %d, elements %v, phase %v; want position only", code, s.Elements, s.OrbitalPhase)
+	}
+	if s.EarthDistance < 356000 || s.EarthDistance > 407000 {
+		t.Errorf("moon %.0f km from Earth", s.EarthDistance)
+	}
+
+	for query, want := range map[string]int{
+		"":                      http.StatusBadRequest,
+		"body=sun":              http.StatusBadRequest,
+		"body=vulcan":           http.StatusNotFound,
+		"body=mars&t=yesterday": http.StatusBadRequest,
+	} {
+		if code, _ := stateRequest(t, query); code != want {
+			t.Errorf("%q: got %d, want %d", query, code, want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// fakeDistanceClock points distanceClock at a settable time for the test.
+// uptime follows it, as it would without a suspend.
+func fakeDistanceClock(t *testing.T, start time.Time) func(time.Time) {
+	t.Helper()
+	var now atomic.Int64
+	now.Store(start.UnixNano())
+	orig, origUptime := distanceClock, uptime
+	base := uptime()
+	distanceClock = func() time.Time { return time.Unix(0, now.Load()).UTC() }
+	uptime = func() time.Duration { return base + time.Duration(now.Load()-start.UnixNano()) }
+	t.Cleanup(func() {
+		distanceClock, uptime = orig, origUptime
+		invalidateDistanceCache()
+	})
+	return func(t time.Time) { now.Store(t.UnixNano()) }
+}
+
+func getStatusData(t *testing.T, s *Server, query string) (int, ApiResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/status-data"+query, nil))
+	var out ApiResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, out
+}
+
+// TestStatusDataComputedAt pins the cache age: computedAt stays at the
+// cache generation while timestamp moves, until ?refresh=true or the hour
+// is up.
+func TestStatusDataComputedAt(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+
+	t0 := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	setClock := fakeDistanceClock(t, t0)
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(),
+		refreshLimiter: NewRateLimiter(60, 1, 0, 0)}
+
+	check := func(query string, now, wantComputed time.Time) {
+		t.Helper()
+		setClock(now)
+		code, out := getStatusData(t, s, query)
+		if code != http.StatusOK {
+			t.Fatalf("%s at %s: %d", query, now.Format("15:04"), code)
+		}
+		if !out.Timestamp.Equal(now) || !out.ComputedAt.Equal(wantComputed) {
+			t.Errorf("%s at %s: timestamp %s computedAt %s, want %s and %s", query, now.Format("15:04"),
+				out.Timestamp.Format("15:04"), out.ComputedAt.Format("15:04"), now.Format("15:04"), wantComputed.Format("15:04"))
+		}
+	}
+	check("", t0, t0)
+	check("", t0.Add(30*time.Minute), t0)                                     // stale, and says so
+	check("?refresh=true", t0.Add(40*time.Minute), t0.Add(40*time.Minute))    // forced
+	check("", t0.Add(100*time.Minute), t0.Add(100*time.Minute))               // hour expired
+	check("?refresh=false", t0.Add(110*time.Minute), t0.Add(100*time.Minute)) // only "true" forces
+
+	// The burst of one was spent above; the next forced refresh is refused.
+	setClock(t0.Add(111 * time.Minute))
+	if code, _ := getStatusData(t, s, "?refresh=true"); code != http.StatusTooManyRequests {
+		t.Errorf("second forced refresh: %d, want 429", code)
+	}
+}
+
+// TestStatusDataFreshOcclusion tampers with the cached occlusion flags:
+// bodies near the Sun must report a freshly solved value, everything else
+// the cached one.
+func TestStatusDataFreshOcclusion(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	objects := celestial.InitSolarSystemObjects()
+	setCelestialObjects(objects)
+	invalidateDistanceCache()
+	earth, _ := findObjectByName(objects, "Earth")
+
+	// Find a day with a planet within the elongation cutoff.
+	var now time.Time
+	var nearSun string
+	for day := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC); nearSun == "" && day.Year() == 2026; day = day.AddDate(0, 0, 1) {
+		for _, name := range []string{"Mercury", "Venus", "Mars", "Jupiter", "Saturn"} {
+			obj, _ := findObjectByName(objects, name)
+			if SolarElongation(earth, obj, objects, day) < freshOcclusionElongationDeg/2 {
+				now, nearSun = day, name
+				break
+			}
+		}
+	}
+	if nearSun == "" {
+		t.Fatal("no planet came within the elongation cutoff in 2026")
+	}
+	fakeDistanceClock(t, now)
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	getStatusData(t, s, "") // populate the cache
+
+	nearObj := findObject(t, objects, nearSun)
+	actual, _ := IsOccluded(earth, nearObj, objects, now)
+	DistanceCacheMutex.Lock()
+	for i := range distanceEntries {
+		switch distanceEntries[i].Object.Name {
+		case nearSun, "Neptune":
+			e := &distanceEntries[i]
+			if e.Occluded = !e.Occluded; e.Occluded {
+				e.Visibility = VisibilityBlocked
+			} else {
+				e.Visibility = VisibilityClear
+			}
+		}
+	}
+	DistanceCacheMutex.Unlock()
+
+	_, out := getStatusData(t, s, "")
+	seen := 0
+	for _, entries := range out.Objects {
+		for _, e := range entries {
+			switch e.Name {
+			case nearSun:
+				seen++
+				if !e.OcclusionFresh || e.Occluded != actual.Blocked() || e.Visibility != actual.String() {
+					t.Errorf("%s: occluded %v (%s) fresh %v, want the fresh value %s", e.Name, e.Occluded, e.Visibility, e.OcclusionFresh, actual)
+				}
+			case "Neptune":
+				seen++
+				want, _ := IsOccluded(earth, findObject(t, objects, "Neptune"), objects, now)
+				if e.OcclusionFresh || e.Occluded == want.Blocked() {
+					t.Errorf("Neptune: occluded %v fresh %v, want the (tampered) cached value", e.Occluded, e.OcclusionFresh)
+				}
+			}
+		}
+	}
+	if seen != 2 {
+		t.Fatalf("expected %s and Neptune in the response, saw %d of them", nearSun, seen)
+	}
+}
+
+// TestStatusDataCategories is the contract for the grouping keys: the exact
+// set for the shipped objects, /api/bodies agreeing with it, and
+// ?legacyKeys=true carrying the same entries under the old keys.
+func TestStatusDataCategories(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	fakeDistanceClock(t, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) ApiResponse {
+		var out ApiResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%d %s", rec.Code, rec.Body)
+		}
+		return out
+	}
+	keysOf := func(objects map[string][]StatusEntry) []string {
+		var keys []string
+		for key := range objects {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	rec := get("http://latency.space/api/status-data")
+	if rec.Header().Get("Deprecation") != "" {
+		t.Error("Deprecation header without ?legacyKeys")
+	}
+	status := decode(rec)
+	if got, want := strings.Join(status.Categories, ","), "planets,dwarfPlanets,moons,asteroids,comets,spacecraft"; got != want {
+		t.Errorf("categories %s, want %s", got, want)
+	}
+	if got, want := strings.Join(keysOf(status.Objects), ","), "asteroids,comets,dwarfPlanets,moons,planets,spacecraft"; got != want {
+		t.Errorf("object keys %s, want %s", got, want)
+	}
+	category := map[string]string{}
+	for key, entries := range status.Objects {
+		for _, e := range entries {
+			category[e.Name] = key
+		}
+	}
+
+	var bodies BodiesResponse
+	if err := json.Unmarshal(get("http://latency.space/api/bodies").Body.Bytes(), &bodies); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range bodies.Bodies {
+		if b.Name == "Earth" { // the observer, not in the status
+			continue
+		}
+		if b.Category != category[b.Name] {
+			t.Errorf("%s: /api/bodies category %q, listed under %q", b.Name, b.Category, category[b.Name])
+		}
+	}
+
+	rec = get("http://latency.space/api/status-data?legacyKeys=true")
+	if dep := rec.Header().Get("Deprecation"); !strings.HasPrefix(dep, "@") {
+		t.Errorf("legacy keys without a Deprecation header: %q", dep)
+	}
+	legacy := decode(rec)
+	if got, want := strings.Join(legacy.Categories, ","), "planets,dwarf_planets,moons,asteroids,comets,spacecrafts"; got != want {
+		t.Errorf("legacy categories %s, want %s", got, want)
+	}
+	if got, want := strings.Join(keysOf(legacy.Objects), ","), "asteroids,comets,dwarf_planets,moons,planets,spacecrafts"; got != want {
+		t.Errorf("legacy object keys %s, want %s", got, want)
+	}
+	for key, entries := range status.Objects {
+		old := legacyCategoryKey(entries[0].Type)
+		if !reflect.DeepEqual(legacy.Objects[old], entries) {
+			t.Errorf("%s under the legacy key %s differs:\n%+v\n%+v", key, old, legacy.Objects[old], entries)
+		}
+	}
+}
+
+func TestCategoryKey(t *testing.T) {
+	for objType, want := range map[string]string{
+		"planet": "planets", "Planet": "planets", "dwarf_planet": "dwarfPlanets", "DWARF_PLANET": "dwarfPlanets",
+		"moon": "moons", "asteroid": "asteroids", "spacecraft": "spacecraft", "star": "stars",
+		"comet": "comets", "trans_neptunian_object": "transNeptunianObjects",
+	} {
+		if got := categoryKey(objType); got != want {
+			t.Errorf("categoryKey(%q) = %q, want %q", objType, got, want)
+		}
+	}
+}
+
+func findObject(t *testing.T, objects []CelestialObject, name string) CelestialObject {
+	t.Helper()
+	obj, ok := findObjectByName(objects, name)
+	if !ok {
+		t.Fatalf("no %s", name)
+	}
+	return obj
+}
@@ -0,0 +1,268 @@
+// statusstream.go - /api/status-stream, /api/status-data as Server-Sent Events.
+//
+// A client first gets a "snapshot" event, the same JSON as /api/status-data.
+// After that it gets an "update" event (StatusUpdate) only when something
+// changes: the distance cache refreshes, or a body's occlusion or DSN
+// contact state flips. A ": heartbeat" comment every statusHeartbeat keeps
+// proxies from closing an idle stream.
+//
+// One poll loop runs while anyone is subscribed. It builds each state once
+// and fans the encoded events out to every client. Slow clients are dropped
+// rather than buffered without limit. -status-stream-max bounds the number
+// of clients.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStatusStreamClients = 100
+	statusClientBuffer         = 8 // events queued per client before it is dropped
+)
+
+// Intervals of the stream; tests shorten them.
+var (
+	statusStreamPoll = 5 * time.Second
+	statusHeartbeat  = 30 * time.Second
+)
+
+var errStatusStreamFull = errors.New("too many status stream clients")
+
+// StatusUpdate is the data of an "update" event: the bodies whose status
+// changed since the previous event.
+type StatusUpdate struct {
+	Timestamp  time.Time     `json:"timestamp"`
+	ComputedAt time.Time     `json:"computedAt"`
+	Changed    []StatusEntry `json:"changed"`
+}
+
+// statusClient is one subscriber. events is closed when the stream drops it.
+type statusClient struct {
+	events chan []byte
+}
+
+// StatusStream fans status events out to SSE clients.
+type StatusStream struct {
+	mu       sync.Mutex
+	max      int // concurrent clients; 0 = unlimited
+	clients  map[*statusClient]struct{}
+	last     ApiResponse // state of the last event
+	snapshot []byte      // "snapshot" event for last
+	stop     context.CancelFunc
+	done     chan struct{} // closed when the poll loop exits
+}
+
+// NewStatusStream returns a stream allowing max concurrent clients.
+func NewStatusStream(max int) *StatusStream {
+	return &StatusStream{max: max, clients: make(map[*statusClient]struct{})}
+}
+
+// SetMax changes the client limit. Clients over a lowered limit stay
+// connected; new ones are refused until the count drops below it.
+func (st *StatusStream) SetMax(max int) {
+	st.mu.Lock()
+	st.max = max
+	st.mu.Unlock()
+}
+
+// subscribe adds a client and returns it with the snapshot event to send
+// first. The first client starts the poll loop.
+func (st *StatusStream) subscribe() (*statusClient, []byte, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.max > 0 && len(st.clients) >= st.max {
+		return nil, nil, errStatusStreamFull
+	}
+	if st.stop == nil {
+		now := distanceClock()
+		st.setState(buildStatusResponse(currentSnapshot(now), now))
+		ctx, cancel := context.WithCancel(context.Background())
+		st.stop, st.done = cancel, make(chan struct{})
+		go st.poll(ctx, st.done)
+	}
+	c := &statusClient{events: make(chan []byte, statusClientBuffer)}
+	st.clients[c] = struct{}{}
+	return c, st.snapshot, nil
+}
+
+// unsubscribe removes c. The last client out stops the poll loop.
+func (st *StatusStream) unsubscribe(c *statusClient) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.dropLocked(c)
+}
+
+func (st *StatusStream) dropLocked(c *statusClient) {
+	if _, ok := st.clients[c]; !ok {
+		return
+	}
+	delete(st.clients, c)
+	close(c.events)
+	if len(st.clients) == 0 && st.stop != nil {
+		st.stop()
+		st.stop = nil
+	}
+}
+
+// Close drops every client, so their handlers return, and waits for the
+// poll loop to exit.
+func (st *StatusStream) Close() {
+	st.mu.Lock()
+	for c := range st.clients {
+		st.dropLocked(c)
+	}
+	done := st.done
+	st.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// setState records state as the last one sent.
+func (st *StatusStream) setState(state ApiResponse) {
+	st.last = state
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("Status stream: %v", err)
+		return
+	}
+	st.snapshot = sseEvent("snapshot", data)
+}
+
+func (st *StatusStream) poll(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(statusStreamPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			st.tick(ctx)
+		}
+	}
+}
+
+// tick rebuilds the status and sends an update if anything changed.
+func (st *StatusStream) tick(ctx context.Context) {
+	now := distanceClock()
+	state := buildStatusResponse(currentSnapshot(now), now)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if ctx.Err() != nil {
+		return // the last client left while we were building
+	}
+	changed := changedEntries(st.last, state)
+	if len(changed) == 0 && state.ComputedAt.Equal(st.last.ComputedAt) {
+		return
+	}
+	st.setState(state)
+	data, err := json.Marshal(StatusUpdate{Timestamp: state.Timestamp, ComputedAt: state.ComputedAt, Changed: changed})
+	if err != nil {
+		log.Printf("Status stream: %v", err)
+		return
+	}
+	event := sseEvent("update", data)
+	for c := range st.clients {
+		select {
+		case c.events <- event:
+		default:
+			log.Printf("Status stream: dropping a client %d events behind", statusClientBuffer)
+			st.dropLocked(c)
+		}
+	}
+}
+
+// changedEntries returns the entries of next whose distance, visibility,
+// contact or ground station state differs from prev. The station offset
+// moves with Earth's rotation on every poll, so only a change of station
+// counts.
+func changedEntries(prev, next ApiResponse) []StatusEntry {
+	type state struct {
+		distance, geometric, latency float64
+		occluded, noContact          bool
+		visibility, station          string
+		noStation                    bool
+	}
+	key := func(e StatusEntry) state {
+		return state{e.Distance, e.Geometric, e.Latency, e.Occluded, e.NoContact, e.Visibility, e.GroundStation, e.NoStation}
+	}
+	before := make(map[string]state)
+	for _, entries := range prev.Objects {
+		for _, e := range entries {
+			before[e.Name] = key(e)
+		}
+	}
+	changed := []StatusEntry{}
+	for _, entries := range next.Objects {
+		for _, e := range entries {
+			if s, ok := before[e.Name]; !ok || s != key(e) {
+				changed = append(changed, e)
+			}
+		}
+	}
+	return changed
+}
+
+// sseEvent encodes one event. data is single-line JSON.
+func sseEvent(name string, data []byte) []byte {
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", name, data))
+}
+
+// handleStatusStream serves /api/status-stream.
+func (s *Server) handleStatusStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // as /api/status-data
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := s.requestSnapshot(w, r); !ok {
+		return
+	}
+	c, snapshot, err := s.statusStream.subscribe()
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+	defer s.statusStream.unsubscribe(c)
+
+	// The stream outlives the server's request timeout.
+	holdOpen(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // don't let nginx hold events back
+	w.WriteHeader(http.StatusOK)
+	w.Write(snapshot)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(statusHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-c.events:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
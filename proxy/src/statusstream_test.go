@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// sseMessage is one event or comment read from a stream.
+type sseMessage struct {
+	event, data, comment string
+}
+
+// readSSE sends the messages read from body until it ends.
+func readSSE(body *bufio.Reader, out chan<- sseMessage) {
+	defer close(out)
+	var msg sseMessage
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			out <- msg
+			msg = sseMessage{}
+		case strings.HasPrefix(line, ":"):
+			msg.comment = strings.TrimSpace(line[1:])
+		case strings.HasPrefix(line, "event: "):
+			msg.event = line[len("event: "):]
+		case strings.HasPrefix(line, "data: "):
+			msg.data = line[len("data: "):]
+		}
+	}
+}
+
+// subscribeStatus opens /api/status-stream on srv.
+func subscribeStatus(t *testing.T, srv *httptest.Server) (*http.Response, <-chan sseMessage) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/api/status-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	msgs := make(chan sseMessage, 16)
+	if resp.StatusCode == http.StatusOK {
+		go readSSE(bufio.NewReader(resp.Body), msgs)
+	}
+	return resp, msgs
+}
+
+// nextMessage waits for a message matching want.
+func nextMessage(t *testing.T, msgs <-chan sseMessage, want func(sseMessage) bool) sseMessage {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				t.Fatal("stream ended")
+			}
+			if want(m) {
+				return m
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a status stream message")
+		}
+	}
+}
+
+// checkSchema validates JSON data against a component schema of openapi.json.
+func checkSchema(t *testing.T, v *openAPIValidator, name, data string) {
+	t.Helper()
+	schema, err := v.resolve("#/components/schemas/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	for _, e := range v.validate(schema, val, "$") {
+		t.Errorf("%s: %s", name, e)
+	}
+}
+
+func shortenStatusStream(t *testing.T) {
+	origPoll, origHeartbeat := statusStreamPoll, statusHeartbeat
+	statusStreamPoll, statusHeartbeat = 20*time.Millisecond, 100*time.Millisecond
+	t.Cleanup(func() { statusStreamPoll, statusHeartbeat = origPoll, origHeartbeat })
+}
+
+// TestStatusStream subscribes, then moves the clock past the cache's hour:
+// a snapshot arrives first, then an update for the new cache generation,
+// with heartbeats in between.
+func TestStatusStream(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	shortenStatusStream(t)
+
+	t0 := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	setClock := fakeDistanceClock(t, t0)
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), statusStream: NewStatusStream(4)}
+	srv := httptest.NewServer(http.HandlerFunc(s.handleHTTP))
+	defer srv.Close()
+	defer s.statusStream.Close()
+	v := loadOpenAPI(t)
+
+	resp, msgs := subscribeStatus(t, srv)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("CORS header = %q, want the same as /api/status-data", got)
+	}
+
+	first := nextMessage(t, msgs, func(sseMessage) bool { return true })
+	if first.event != "snapshot" {
+		t.Fatalf("first message %+v, want the snapshot", first)
+	}
+	checkSchema(t, v, "StatusResponse", first.data)
+	var snap ApiResponse
+	json.Unmarshal([]byte(first.data), &snap)
+	if !snap.ComputedAt.Equal(t0) || len(snap.Objects["planets"]) == 0 {
+		t.Fatalf("snapshot computed at %v with %d planets", snap.ComputedAt, len(snap.Objects["planets"]))
+	}
+
+	nextMessage(t, msgs, func(m sseMessage) bool { return m.comment == "heartbeat" })
+
+	// Nothing changes within the hour.
+	setClock(t0.Add(30 * time.Minute))
+	time.Sleep(5 * statusStreamPoll)
+	setClock(t0.Add(2 * time.Hour))
+	update := nextMessage(t, msgs, func(m sseMessage) bool { return m.comment == "" })
+	if update.event != "update" {
+		t.Fatalf("got %+v, want an update", update)
+	}
+	checkSchema(t, v, "StatusUpdate", update.data)
+	var u StatusUpdate
+	json.Unmarshal([]byte(update.data), &u)
+	if !u.ComputedAt.Equal(t0.Add(2*time.Hour)) || len(u.Changed) == 0 {
+		t.Errorf("update computed at %v with %d changes, want the new generation", u.ComputedAt, len(u.Changed))
+	}
+}
+
+// TestStatusStreamClients checks the client cap and that leaving clients
+// are removed and stop the poll loop.
+func TestStatusStreamClients(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	shortenStatusStream(t)
+
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), statusStream: NewStatusStream(1)}
+	srv := httptest.NewServer(http.HandlerFunc(s.handleHTTP))
+	defer srv.Close()
+	defer s.statusStream.Close()
+
+	first, msgs := subscribeStatus(t, srv)
+	nextMessage(t, msgs, func(m sseMessage) bool { return m.event == "snapshot" })
+	if second, _ := subscribeStatus(t, srv); second.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("second client: %d, want 503", second.StatusCode)
+	}
+
+	first.Body.Close()
+	st := s.statusStream
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		st.mu.Lock()
+		n, running := len(st.clients), st.stop != nil
+		st.mu.Unlock()
+		if n == 0 && !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients left, poll loop running %v", n, running)
+		}
+	}
+
+	// The freed slot can be taken again.
+	third, msgs := subscribeStatus(t, srv)
+	if third.StatusCode != http.StatusOK {
+		t.Fatalf("third client: %d", third.StatusCode)
+	}
+	nextMessage(t, msgs, func(m sseMessage) bool { return m.event == "snapshot" })
+}
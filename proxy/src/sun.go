@@ -0,0 +1,102 @@
+// sun.go - the Sun's host: an information page and no proxy.
+//
+// Every latency here is measured from Earth, and routing traffic "via the
+// Sun" means nothing physically. sun.latency.space still resolves, but it
+// renders its own page: the Earth-Sun distance, which drifts about 1.7%
+// either side of 1 AU over the year, its light time, the solar facts, and
+// which bodies the Sun is hiding or crowding right now. Every proxy path
+// (SOCKS5 CONNECT and UDP ASSOCIATE, store-and-forward, -tcp-forward)
+// refuses a star with NOT_PROXYABLE.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// notProxyableCode is the structured error code for proxying via a star.
+const notProxyableCode = "NOT_PROXYABLE"
+
+// errNotProxyable is why traffic routed via a star is refused.
+var errNotProxyable = errors.New("nothing is relayed via the Sun: anything routed through it would arrive as plasma. Pick a body that orbits it instead")
+
+// sunTemplate renders sun.latency.space; it is parsed from the same
+// directory as infoTemplate.
+var sunTemplate *template.Template
+
+// checkProxyable returns errNotProxyable, naming the body, when body is a
+// star.
+func checkProxyable(body string) error {
+	if obj, ok := findObjectByName(getCelestialObjects(), body); ok && obj.Type == "star" {
+		return fmt.Errorf("%s: %w", obj.Name, errNotProxyable)
+	}
+	return nil
+}
+
+// SunPageData holds the data rendered by sun_page.html.
+type SunPageData struct {
+	Name              string
+	DistanceMkm       float64  // Earth-Sun distance in millions of km
+	MeanOffset        string   // Signed percentage from 1 AU, e.g. "+1,52"
+	LatencySec        float64  // One-way light time in seconds
+	LatencyFriendly   string   // Human-readable light time
+	RoundTripFriendly string   // Human-readable round trip
+	PinnedEpoch       string   // Set while the simulation epoch is pinned
+	Facts             []string // The Sun's facts, all of them
+	Occluded          []string // Bodies behind the Sun's disc as seen from Earth
+	Degraded          []string // Bodies whose links graze the Sun's limb
+	L                 Locale
+}
+
+// displaySunInfo renders the Sun's page for sun (the star's object) from
+// snap, in the language r asks for.
+func (s *Server) displaySunInfo(w http.ResponseWriter, r *http.Request, snap *SystemSnapshot, sun celestial.CelestialObject) {
+	l := localeFor(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	setContentLanguage(w, l)
+
+	distance, err := snap.Distance(sun.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	latency := s.oneWay(sun.Name, distance)
+	offset := (distance/celestial.AU - 1) * 100
+	data := SunPageData{
+		Name:              sun.Name,
+		DistanceMkm:       float64(int((distance/1e6)*100)) / 100,
+		MeanOffset:        l.Number(offset, 2),
+		LatencySec:        float64(int(latency.Seconds()*100)) / 100,
+		LatencyFriendly:   l.Duration(latency),
+		RoundTripFriendly: l.Duration(2 * latency),
+		Facts:             sun.Facts,
+		L:                 l,
+	}
+	if offset >= 0 {
+		data.MeanOffset = "+" + data.MeanOffset
+	}
+	if epoch, pinned := pinnedEpoch(); pinned {
+		data.PinnedEpoch = epoch.Format(l.T("format.datetime"))
+	}
+	// The distance cache already knows what each link passes closest to.
+	for _, entry := range snap.Entries {
+		if entry.OccludedBy.Name != sun.Name {
+			continue
+		}
+		switch entry.Visibility {
+		case VisibilityBlocked:
+			data.Occluded = append(data.Occluded, entry.Object.Name)
+		case VisibilityDegraded:
+			data.Degraded = append(data.Degraded, entry.Object.Name)
+		}
+	}
+
+	if err := sunTemplate.Execute(w, data); err != nil {
+		log.Printf("Error executing Sun page template: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// withPageTemplates loads the info and Sun page templates for the test.
+func withPageTemplates(t *testing.T) {
+	t.Helper()
+	info, sun := infoTemplate, sunTemplate
+	t.Cleanup(func() { infoTemplate, sunTemplate = info, sun })
+	infoTemplate = template.Must(template.ParseFiles("templates/info_page.html"))
+	sunTemplate = template.Must(template.ParseFiles("templates/sun_page.html"))
+}
+
+// TestSunPage renders sun.latency.space while Jupiter is behind the Sun, a
+// few days before aphelion.
+func TestSunPage(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	withPinnedEpoch(t, time.Date(2025, 6, 24, 12, 0, 0, 0, time.UTC))
+	withPageTemplates(t)
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+
+	get := func(accept string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://sun.latency.space/", nil)
+		req.Header.Set("Accept-Language", accept)
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", accept, rec.Code, rec.Body)
+		}
+		return rec.Body.String()
+	}
+	page := get("en")
+	for _, want := range []string{
+		"<h1>The Sun</h1>",
+		"million km</strong> (&#43;1.6", // near aphelion; html/template escapes the +
+		"Behind the Sun",
+		`<li class="status-occluded">Jupiter</li>`,
+		"Sunlight takes about 8 minutes 20 seconds to reach Earth.",
+		"<code>NOT_PROXYABLE</code>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page missing %q", want)
+		}
+	}
+	if strings.Contains(page, "socks5-hostname") || strings.Contains(page, "Protocol Impact") {
+		t.Error("the Sun's page offers a proxy")
+	}
+	if page := get("de"); !strings.Contains(page, "Hinter der Sonne") || !strings.Contains(page, "(&#43;1,6") {
+		t.Error("German page not localized")
+	}
+}
+
+// TestSunNotProxyable checks SOCKS5, store-and-forward and port forwards
+// all refuse the Sun.
+func TestSunNotProxyable(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+
+	t.Run("socks", func(t *testing.T) {
+		recent := NewRecentLog(10, false)
+		proxy := startTestSOCKS(t, &Server{security: newTestSecurity(), metrics: NewRecordingMetrics(),
+			fixedCelestialBody: "Sun", recent: recent, timing: fixedLatency(time.Millisecond)})
+		echo := startEchoServer(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_, _, err := client.Dial(ctx, proxy, echo.String())
+		var re *client.ReplyError
+		if !errors.As(err, &re) || re.Code != SOCKS5_REP_CONN_NOT_ALLOWED {
+			t.Fatalf("dial: %v, want a not-allowed reply", err)
+		}
+		waitFor(t, func() bool {
+			snap := recent.Snapshot(RecentFilter{})
+			return len(snap) == 1 && snap[0].Body == "Sun" && snap[0].Outcome == outcomeDenied
+		})
+	})
+
+	t.Run("dtn", func(t *testing.T) {
+		s := newDTNTestServer(t)
+		for _, tc := range []struct{ host, body string }{
+			{"sun.latency.space", `{"url":"https://example.com/"}`},
+			{"latency.space", `{"url":"https://example.com/","via":"sun"}`},
+		} {
+			code, out := dtnSend(t, s, tc.host, tc.body)
+			if code != http.StatusBadRequest || out["code"] != notProxyableCode || !strings.Contains(out["error"].(string), "plasma") {
+				t.Errorf("%s %s: %d %v", tc.host, tc.body, code, out)
+			}
+		}
+	})
+
+	t.Run("tcp forward", func(t *testing.T) {
+		f := newTestForwarder(t, "sun", "127.0.0.1:22")
+		if err := f.Listen(); !errors.Is(err, errNotProxyable) {
+			t.Errorf("Listen: %v, want errNotProxyable", err)
+		}
+	})
+}
+
+// TestStatusDataSunSection checks the Sun has its own section in
+// /api/status-data instead of being left out.
+func TestStatusDataSunSection(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, "http://latency.space/api/status-data", nil))
+	var resp ApiResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Sun == nil {
+		t.Fatal("no sun section")
+	}
+	// 1 AU ± 1.7%, so about 8m12s to 8m28s of light time.
+	if au := resp.Sun.Distance / celestial.AU; resp.Sun.Name != "Sun" || au < 0.98 || au > 1.02 {
+		t.Errorf("sun = %+v (%.3f AU)", resp.Sun, au)
+	}
+	if resp.Sun.Latency < 490 || resp.Sun.Latency > 510 {
+		t.Errorf("Sun light time %vs", resp.Sun.Latency)
+	}
+	if _, ok := resp.Objects["stars"]; ok {
+		t.Error("the Sun is also listed among the objects")
+	}
+}
@@ -0,0 +1,230 @@
+// tcpforward.go - static TCP port forwarding for tools that can't speak SOCKS.
+//
+// -tcp-forward mars:2222=github.com:22,europa:5432=db.example.com:5432 opens a
+// dedicated listener per entry (here :2222 and :5432). Every accepted
+// connection is forwarded to the entry's fixed destination as if it had come
+// through the named body: the connection setup waits one one-way light time,
+// each chunk is shifted by the same latency via relayWithLatency, and the link
+// is refused (or torn down) while the body is occluded from Earth. The
+// destination must pass the same allowlists, and the body the same latency
+// floor, as a SOCKS CONNECT.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// tcpForward is one parsed -tcp-forward entry.
+type tcpForward struct {
+	Body   string // celestial body name as given; resolved on Listen
+	Listen string // local listen address, e.g. ":2222"
+	Dest   string // fixed destination host:port
+}
+
+// parseTCPForwards parses a comma-separated list of body:port=host:port entries.
+func parseTCPForwards(spec string) ([]tcpForward, error) {
+	var forwards []tcpForward
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		local, dest, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("tcp-forward %q: expected body:port=host:port", entry)
+		}
+		body, port, ok := strings.Cut(local, ":")
+		if !ok || body == "" {
+			return nil, fmt.Errorf("tcp-forward %q: expected body:port before '='", entry)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("tcp-forward %q: invalid listen port %q", entry, port)
+		}
+		if seen[port] {
+			return nil, fmt.Errorf("tcp-forward %q: listen port %s used twice", entry, port)
+		}
+		seen[port] = true
+		host, destPort, err := net.SplitHostPort(dest)
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("tcp-forward %q: invalid destination %q", entry, dest)
+		}
+		if n, err := strconv.Atoi(destPort); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("tcp-forward %q: invalid destination port %q", entry, destPort)
+		}
+		forwards = append(forwards, tcpForward{Body: body, Listen: ":" + port, Dest: dest})
+	}
+	return forwards, nil
+}
+
+// TCPForwarder serves one -tcp-forward listener. Each forwarder can be
+// stopped on its own; Stop closes the listener and every live connection.
+type TCPForwarder struct {
+	fwd      tcpForward
+	body     string // canonical body name
+	security *SecurityValidator
+	metrics  Metrics
+	limiter  *RateLimiter  // nil = no per-IP limits
+	recent   *RecentLog    // nil = not recorded
+	access   *AccessLog    // nil = not logged
+	recheck  time.Duration // link re-check interval for established sessions
+	window   int           // simulated TCP window (0 = off)
+	timing                 // clock and latency source (clock.go)
+
+	*trackedListener // listener, live connections and Stop
+}
+
+// NewTCPForwarder creates a forwarder for fwd. Call Listen, then Serve.
+func NewTCPForwarder(fwd tcpForward, security *SecurityValidator, metrics Metrics) *TCPForwarder {
+	return &TCPForwarder{
+		fwd:             fwd,
+		security:        security,
+		metrics:         orNop(metrics),
+		trackedListener: newTrackedListener(),
+		recheck:         linkRecheckInterval,
+	}
+}
+
+// Listen validates the body and destination and binds the listener.
+func (f *TCPForwarder) Listen() error {
+	obj, ok := findObjectByName(getCelestialObjects(), f.fwd.Body)
+	if !ok {
+		return fmt.Errorf("tcp-forward %s: unknown celestial body %q", f.fwd.Listen, f.fwd.Body)
+	}
+	f.body = obj.Name
+	if err := checkProxyable(f.body); err != nil {
+		return fmt.Errorf("tcp-forward %s: %w", f.fwd.Listen, err)
+	}
+	if err := f.validateDestination(); err != nil {
+		return fmt.Errorf("tcp-forward %s: %w", f.fwd.Listen, err)
+	}
+	// A body always under the latency floor (Earth) can never be forwarded
+	// to; a near-Earth asteroid on a flyby is refused per connection until
+	// it is far enough again.
+	if distance, err := getCurrentDistance(f.body); err == nil {
+		var tooClose *BodyTooCloseError
+		if err := checkLatencyFloor(f.body, f.oneWay(f.body, distance), f.security.minLatency); err != nil && !errors.As(err, &tooClose) {
+			return fmt.Errorf("tcp-forward %s: %w", f.fwd.Listen, err)
+		}
+	}
+	l, err := net.Listen("tcp", f.fwd.Listen)
+	if err != nil {
+		return fmt.Errorf("tcp-forward %s: %v", f.fwd.Listen, err)
+	}
+	f.listener = l
+	return nil
+}
+
+// validateDestination applies the destination policy (ValidateDestination)
+// to the forwarder's fixed destination, once, before listening.
+func (f *TCPForwarder) validateDestination() error {
+	host, portStr, err := net.SplitHostPort(f.fwd.Dest)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid destination port %q", portStr)
+	}
+	err = f.security.ValidateSocksDestination(host, uint16(port))
+	recordDenial(f.metrics, protoTCPForward, err)
+	return err
+}
+
+// Serve accepts connections until Stop is called.
+func (f *TCPForwarder) Serve() error {
+	log.Printf("TCP forward %s -> %s via %s", f.listener.Addr(), f.fwd.Dest, f.body)
+	return f.serve("TCP forward", f.limiter, f.handle)
+}
+
+// linkDown reports why the link between Earth and the forward's body is down
+// (occlusion or no DSN contact window), if it is.
+func (f *TCPForwarder) linkDown() (LinkOutage, bool) {
+	objects := getCelestialObjects()
+	earth, ok1 := findObjectByName(objects, "Earth")
+	target, ok2 := findObjectByName(objects, f.body)
+	if !ok1 || !ok2 {
+		return LinkOutage{}, false
+	}
+	return checkLink(earth, target, objects, linkTime())
+}
+
+// handle forwards one accepted connection.
+func (f *TCPForwarder) handle(conn net.Conn) {
+	accepted := f.now()
+	tx := RecentTransaction{
+		Time:     accepted,
+		ClientIP: clientIP(conn.RemoteAddr().String()),
+		Protocol: "tcpforward",
+		Body:     f.body,
+		Target:   f.fwd.Dest,
+		Outcome:  outcomeError,
+	}
+	defer func() {
+		tx.Duration = f.since(tx.Time)
+		f.recent.Record(tx)
+		f.access.LogTransaction(tx)
+	}()
+
+	if outage, down := f.linkDown(); down {
+		tx.Outcome = outage.outcome()
+		occlusionPolicy.refuseTCPForward(f.metrics, f.body, outage)
+		log.Printf("TCP forward to %s rejected: %s %s", f.fwd.Dest, f.body, outage)
+		return
+	}
+
+	distance, err := getCurrentDistance(f.body)
+	if err != nil {
+		log.Printf("TCP forward to %s rejected: %v", f.fwd.Dest, err)
+		return
+	}
+	latency := f.oneWay(f.body, distance)
+	tx.Latency = latency
+	// Anti-DDoS, as for SOCKS: no forwarding without the light-time friction.
+	if err := checkLatencyFloor(f.body, latency, f.security.minLatency); err != nil {
+		tx.Outcome = outcomeDenied
+		log.Printf("TCP forward to %s rejected: %v", f.fwd.Dest, err)
+		return
+	}
+	if err := sleepCtx(f.ctx, f.clk(), latency); err != nil {
+		return
+	}
+
+	dialStart := f.now()
+	target, err := net.DialTimeout("tcp", f.fwd.Dest, 30*time.Second)
+	dial := f.since(dialStart)
+	tx.Upstream = dial
+	if err != nil {
+		log.Printf("TCP forward to %s failed: %v", f.fwd.Dest, err)
+		return
+	}
+	defer target.Close()
+	f.metrics.RecordRequest(f.body, "tcpforward", f.since(accepted))
+	f.metrics.RecordLatencySplit(f.body, "tcpforward", latency, dial)
+
+	// End the session if the body moves behind an occluder, or its contact
+	// window closes, mid-session. Closing the target ends the relay; the
+	// client gets what was in flight, then a FIN (linkloss.go).
+	var lost atomic.Pointer[LinkOutage]
+	done := make(chan struct{})
+	defer close(done)
+	go watchLink(f.recheck, f.linkDown, func(outage LinkOutage) {
+		log.Printf("TCP forward to %s closed: %s %s", f.fwd.Dest, f.body, outage)
+		lost.Store(&outage)
+		target.Close()
+	}, done)
+
+	var end relayEnd
+	tx.BytesIn, tx.BytesOut, end = relayWithLatency(f.timing, conn, target, f.body, protoTCPForward, latency, f.metrics, 0, f.window)
+	tx.Outcome = end.outcome()
+	if outage := lost.Load(); outage != nil {
+		tx.Outcome = outage.outcome()
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestParseTCPForwards(t *testing.T) {
+	got, err := parseTCPForwards("mars:2222=github.com:22, europa:5432=db.example.com:5432")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []tcpForward{
+		{Body: "mars", Listen: ":2222", Dest: "github.com:22"},
+		{Body: "europa", Listen: ":5432", Dest: "db.example.com:5432"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d forwards, got %v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("forward %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	if got, err := parseTCPForwards(""); err != nil || len(got) != 0 {
+		t.Errorf("empty spec should parse to nothing, got %v, %v", got, err)
+	}
+
+	for _, bad := range []string{
+		"mars:2222",                           // no destination
+		"2222=github.com:22",                  // no body
+		"mars:abc=github.com:22",              // bad listen port
+		"mars:2222=github.com",                // destination without port
+		"mars:2222=github.com:0",              // bad destination port
+		"mars:22=a.com:22,europa:22=b.com:22", // duplicate listen port
+		"mars:70000=github.com:22",            // listen port out of range
+		"mars:2222=:22",                       // empty destination host
+	} {
+		if _, err := parseTCPForwards(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+// startEchoServer starts a TCP server that echoes everything it reads.
+func startEchoServer(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr)
+}
+
+func newTestForwarder(t *testing.T, body, dest string) *TCPForwarder {
+	t.Helper()
+	sec := newTestSecurity()
+	_, port, _ := net.SplitHostPort(dest)
+	sec.allowedPorts[port] = true
+	f := NewTCPForwarder(tcpForward{Body: body, Listen: "127.0.0.1:0", Dest: dest}, sec, NewRecordingMetrics())
+	f.recent = NewRecentLog(10, false)
+	f.timing = fixedLatency(time.Millisecond)
+	return f
+}
+
+func TestTCPForwardRelaysWithLatency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	echo := startEchoServer(t)
+	f := newTestForwarder(t, "mars", echo.String())
+	f.timing = fixedLatency(latency)
+	if err := f.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go f.Serve()
+	defer f.Stop()
+
+	// Several chunks' worth of random data must come back byte-for-byte.
+	payload := make([]byte, 200*1024)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	// The setup delay starts at accept, which can precede Dial returning.
+	start := time.Now()
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatalf("dial forward: %v", err)
+	}
+	defer conn.Close()
+	go conn.Write(payload)
+	got := make([]byte, len(payload))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read echo: %v", err)
+	}
+	elapsed := time.Since(start)
+	if !bytes.Equal(got, payload) {
+		t.Fatal("echoed data does not match what was sent")
+	}
+	// Connection setup plus one delay in each direction.
+	if elapsed < 3*latency {
+		t.Errorf("round trip took %v, expected at least %v", elapsed, 3*latency)
+	}
+
+	if v := recorded(t, f.metrics).Count(metricRequests, "Mars", "tcpforward"); v != 1 {
+		t.Errorf("expected one tcpforward request for Mars, got %v", v)
+	}
+	if n := len(recorded(t, f.metrics).Observations(metricSimulatedLatency, "Mars", "tcpforward")); n != 1 {
+		t.Errorf("expected the simulated latency to be recorded, got %d", n)
+	}
+}
+
+func TestTCPForwardRejectsDisallowedDestination(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	cases := []struct {
+		name, body, dest, want string
+	}{
+		{"host not allowlisted", "mars", "evil.example.net:22", "not allowed"},
+		{"IP literal", "mars", "10.0.0.1:22", "IP address"},
+		{"unknown body", "vulcan", "github.com:22", "unknown celestial body"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newTestForwarder(t, tc.body, tc.dest)
+			err := f.Listen()
+			if err == nil {
+				f.listener.Close()
+				t.Fatal("expected Listen to fail")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+// TestTCPForwardStopClosesLiveConnections checks that one forwarder can be
+// shut down on its own, taking its established tunnels with it.
+func TestTCPForwardStopClosesLiveConnections(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	echo := startEchoServer(t)
+	stopped := newTestForwarder(t, "mars", echo.String())
+	other := newTestForwarder(t, "mars", echo.String())
+	for _, f := range []*TCPForwarder{stopped, other} {
+		if err := f.Listen(); err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		go f.Serve()
+	}
+	defer other.Stop()
+
+	conn, err := net.Dial("tcp", stopped.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("tunnel not established: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() { stopped.Stop(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop did not return with a live connection open")
+	}
+	if _, err := conn.Read(buf); err == nil {
+		t.Error("expected the live tunnel to be closed by Stop")
+	}
+	if _, err := net.DialTimeout("tcp", stopped.Addr().String(), 200*time.Millisecond); err == nil {
+		t.Error("stopped forwarder should no longer accept connections")
+	}
+
+	// The other forwarder keeps serving.
+	c2, err := net.Dial("tcp", other.Addr().String())
+	if err != nil {
+		t.Fatalf("other forwarder should still accept: %v", err)
+	}
+	defer c2.Close()
+	c2.Write([]byte("pong"))
+	c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(c2, buf); err != nil || string(buf) != "pong" {
+		t.Errorf("other forwarder stopped relaying: %q, %v", buf, err)
+	}
+}
+
+// TestTCPForwardLatencyFloor checks a forward can't relay without the
+// light-time friction: Earth is refused at Listen, and a body under the
+// floor is refused per connection.
+func TestTCPForwardLatencyFloor(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	echo := startEchoServer(t)
+	earth := newTestForwarder(t, "earth", echo.String())
+	earth.security.minLatency = minProxyLatency
+	if err := earth.Listen(); err == nil || !strings.Contains(err.Error(), "insufficient latency") {
+		if err == nil {
+			earth.listener.Close()
+		}
+		t.Errorf("Listen for Earth: %v, want the latency floor", err)
+	}
+
+	f := newTestForwarder(t, "mars", echo.String())
+	if err := f.Listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f.security.minLatency = minProxyLatency // over the test's 1 ms light time
+	go f.Serve()
+	defer f.Stop()
+
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 4)); err != io.EOF {
+		t.Errorf("read under the floor: %v, want the connection closed", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		snap := f.recent.Snapshot(RecentFilter{})
+		if len(snap) == 1 && snap[0].Outcome == outcomeDenied {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected one denied outcome, got %+v", snap)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+	"github.com/latency-space/shared/client"
+)
+
+// testLatency is the one-way latency of tests that don't pick one.
+const testLatency = 3 * time.Millisecond
+
+// fakeClock is a Clock that only moves when Advance is called. Sleep and
+// After wait for the clock to reach their deadline, and AfterFunc runs its
+// function then.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at    time.Time
+	ch    chan time.Time
+	timer *fakeTimer // set for an AfterFunc, in place of ch
+}
+
+// fakeTimer is a pending fakeClock.AfterFunc.
+type fakeTimer struct {
+	c    *fakeClock
+	fn   func()
+	done bool // run or stopped; guarded by c.mu
+}
+
+// Stop cancels the call, reporting false if it has already run.
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	if t.done {
+		return false
+	}
+	t.done = true
+	for i, w := range t.c.waiters {
+		if w.timer == t {
+			t.c.waiters = append(t.c.waiters[:i], t.c.waiters[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func newFakeClock(start time.Time) *fakeClock {
+	return &fakeClock{now: start}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, fn: f}
+	if d <= 0 {
+		t.done = true
+		go f()
+		return t
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), timer: t})
+	return t
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Advance moves the clock on by d and wakes every waiter now due, in
+// deadline order.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+	var pending []fakeWaiter
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		if w.timer != nil {
+			w.timer.done = true
+			go w.timer.fn()
+			continue
+		}
+		w.ch <- w.at
+	}
+	c.waiters = pending
+}
+
+// BlockUntil waits (in real time) until n goroutines are waiting on the
+// clock, so an Advance is sure to reach them.
+func (c *fakeClock) BlockUntil(t *testing.T, n int) {
+	t.Helper()
+	waitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.waiters) >= n
+	})
+}
+
+// testLatencies is a LatencyProvider with a latency per body and a default
+// for the rest. It can be changed while a test runs.
+type testLatencies struct {
+	mu     sync.Mutex
+	fixed  time.Duration
+	bodies map[string]time.Duration
+}
+
+func (l *testLatencies) Latency(body string, _ float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if d, ok := l.bodies[body]; ok {
+		return d
+	}
+	return l.fixed
+}
+
+// set makes body's latency d.
+func (l *testLatencies) set(body string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.bodies == nil {
+		l.bodies = make(map[string]time.Duration)
+	}
+	l.bodies[body] = d
+}
+
+// fixedLatency is timing on the real clock with latency d for every body.
+func fixedLatency(d time.Duration) timing {
+	return timing{latencies: &testLatencies{fixed: d}}
+}
+
+// newTestSecurity is the production validator with the test relaxations:
+// loopback destinations on any port and no latency floor.
+func newTestSecurity() *SecurityValidator {
+	v := NewSecurityValidator()
+	v.allowLoopback = true
+	v.minLatency = 0
+	return v
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newFakeClock(start)
+	late, early := c.After(2*time.Minute), c.After(time.Minute)
+	select {
+	case <-early:
+		t.Fatal("fired before Advance")
+	default:
+	}
+	c.Advance(90 * time.Second)
+	if got := <-early; !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("early fired at %v", got)
+	}
+	select {
+	case <-late:
+		t.Fatal("late fired early")
+	default:
+	}
+	c.Advance(time.Hour)
+	<-late
+	if got := c.Now(); !got.Equal(start.Add(time.Hour + 90*time.Second)) {
+		t.Errorf("now %v", got)
+	}
+	if _, ok := <-c.After(0); !ok {
+		t.Error("After(0) should fire at once")
+	}
+}
+
+func TestTestLatencies(t *testing.T) {
+	l := &testLatencies{fixed: time.Second}
+	l.set("Mars", 12*time.Minute)
+	if l.Latency("Mars", 0) != 12*time.Minute || l.Latency("Moon", 0) != time.Second {
+		t.Error("per-body latency not applied")
+	}
+	var zero timing
+	if zero.oneWay("Moon", 384400) != CalculateLatency(384400) {
+		t.Error("zero timing should be light time")
+	}
+}
+
+// TestSOCKSConnectFakeClock proxies through a twelve-minute Mars link on a
+// fake clock: the setup delay and each direction of the echo are advanced
+// past at once, and the session is recorded with its simulated duration.
+func TestSOCKSConnectFakeClock(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	fakeLinkClock(t, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+
+	const mars = 12 * time.Minute
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	latencies := &testLatencies{fixed: testLatency}
+	latencies.set("Mars", mars)
+	s := &Server{security: newTestSecurity(), metrics: NewRecordingMetrics(), limiter: NewRateLimiter(60, 5, 3, 0),
+		recent: NewRecentLog(4, false), fixedCelestialBody: "Mars", timing: timing{clock: clock, latencies: latencies},
+		overhead: &overheadSampler{pct: 100}}
+	echo := startEchoServer(t)
+	proxy := startTestSOCKS(t, s)
+	began := time.Now()
+
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, _, err := client.Dial(ctx, proxy, echo.String())
+		done <- dialed{conn, err}
+	}()
+
+	// The setup delay: nothing is dialed until the clock passes it.
+	clock.BlockUntil(t, 1)
+	clock.Advance(mars - time.Second)
+	select {
+	case d := <-done:
+		t.Fatalf("connected a second early: %v", d.err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(time.Second)
+	d := <-done
+	if d.err != nil {
+		t.Fatal(d.err)
+	}
+	conn := d.conn
+
+	// One way out, one way back.
+	conn.Write([]byte("ping"))
+	clock.BlockUntil(t, 1)
+	clock.Advance(mars)
+	clock.BlockUntil(t, 1)
+	clock.Advance(mars)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo %q, %v", buf, err)
+	}
+	conn.Close()
+
+	var txs []RecentTransaction
+	waitFor(t, func() bool { txs = s.recent.Snapshot(RecentFilter{}); return len(txs) == 1 })
+	tx := txs[0]
+	if tx.Outcome != outcomeOK || tx.Latency != mars || !tx.Time.Equal(start) || tx.Duration != 3*mars {
+		t.Errorf("recorded %+v, want an ok session of three one-way delays", tx)
+	}
+	// The clock only moved for the sleep, so none of the setup was overhead.
+	m := s.metrics.(*RecordingMetrics)
+	if setup, overhead := m.Observations(metricRequestDuration, "Mars", "socks"), m.Observations(metricOverhead, "Mars", overheadSOCKS); len(setup) != 1 || setup[0] != mars.Seconds() || len(overhead) != 1 || overhead[0] != 0 {
+		t.Errorf("setup %v s, overhead %v s; want %v and 0", setup, overhead, mars.Seconds())
+	}
+	if elapsed := time.Since(began); elapsed > 3*time.Second {
+		t.Errorf("took %v of real time", elapsed)
+	}
+}
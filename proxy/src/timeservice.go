@@ -0,0 +1,258 @@
+// timeservice.go - "spacecraft time": what a body's clock reads if it syncs
+// naively with Earth over the link.
+//
+//	GET /api/time               on a body host, e.g. mars.latency.space
+//	GET /api/time?body=mars     on any host
+//
+// A timestamp from Earth is one light time old when it arrives, so a clock
+// that just adopts it runs that far behind: apparent time = UTC - one-way.
+// A clock that measures the round trip knows Earth's time only to within it,
+// from apparent (all delay on the way back) to apparent + RTT (all on the way
+// out); assuming equal legs, as NTP does, lands on the midpoint, UTC itself.
+//
+// -time-udp :37 also answers the RFC 868 time protocol over UDP, so rdate
+// and friends see the effect. The reply carries the time the request
+// reached the server and is sent one light time later. The datagram may
+// name a body ("mars", default -time-udp-body) and ask for "json" instead
+// of the 4-byte binary form. Replies are capped at timeMaxReply bytes and
+// rate limited per source address, as a UDP service that answers unsolicited
+// datagrams is otherwise an amplifier.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	timeMaxReply   = 64   // bytes, including the JSON form
+	timeMaxPending = 1024 // replies waiting out their light time
+	timeMaxQuery   = 64   // longer datagrams are ignored
+)
+
+// rfc868Epoch is the RFC 868 epoch, 1900-01-01 UTC.
+var rfc868Epoch = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SpacecraftTime is the JSON returned by /api/time.
+type SpacecraftTime struct {
+	Body          string    `json:"body"`
+	EarthUTC      time.Time `json:"earth_utc"`
+	OneWay        float64   `json:"one_way_seconds"`
+	RoundTrip     float64   `json:"round_trip_seconds"`
+	Apparent      time.Time `json:"apparent_onboard_time"`
+	EarliestEarth time.Time `json:"round_trip_earliest"`
+	LatestEarth   time.Time `json:"round_trip_latest"`
+	Explanation   string    `json:"explanation"`
+}
+
+// spacecraftTime computes the /api/time answer for body at now, given its
+// one-way light time.
+func spacecraftTime(body string, now time.Time, oneWay time.Duration) SpacecraftTime {
+	now = now.UTC()
+	apparent := now.Add(-oneWay)
+	return SpacecraftTime{
+		Body:          body,
+		EarthUTC:      now,
+		OneWay:        oneWay.Seconds(),
+		RoundTrip:     (2 * oneWay).Seconds(),
+		Apparent:      apparent,
+		EarliestEarth: apparent,
+		LatestEarth:   apparent.Add(2 * oneWay),
+		Explanation: fmt.Sprintf("A clock on %s set from an Earth timestamp runs %s behind; timing the round trip bounds Earth's time to a %s window.",
+			body, displayDuration(oneWay), displayDuration(2*oneWay)),
+	}
+}
+
+// handleTime serves /api/time.
+func (s *Server) handleTime(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Query().Get("body"))
+	if name == "" {
+		name = s.resolveCelestialHost(getCelestialObjects(), r.Host)
+	}
+	if name == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.time_body")
+		return
+	}
+	obj, ok := findObjectByName(getCelestialObjects(), name)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotFound, "error.unknown_body_named", name)
+		return
+	}
+	distance, err := getCurrentDistance(obj.Name)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	setStationHeader(w, obj.Name)
+	writeJSON(w, http.StatusOK, spacecraftTime(obj.Name, s.now(), s.oneWay(obj.Name, distance)))
+}
+
+// rfc868Time encodes t as RFC 868 seconds since 1900, which wrap in 2036.
+func rfc868Time(t time.Time) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(int64(t.Sub(rfc868Epoch)/time.Second)))
+	return b
+}
+
+// timeJSON is the "json" UDP reply: the stamped time and the light time it
+// was held back by. Short keys keep it under timeMaxReply.
+func timeJSON(stamped time.Time, oneWay time.Duration) []byte {
+	return []byte(fmt.Sprintf(`{"utc":%q,"delay_s":%.3f}`,
+		stamped.UTC().Format("2006-01-02T15:04:05.000Z07:00"), oneWay.Seconds()))
+}
+
+// parseTimeQuery reads a time datagram: an optional body name and an
+// optional "json". An empty datagram is a plain RFC 868 request.
+func parseTimeQuery(payload []byte, defaultBody string) (body string, asJSON bool) {
+	body = defaultBody
+	for _, f := range strings.Fields(string(payload)) {
+		if strings.EqualFold(f, "json") {
+			asJSON = true
+		} else {
+			body = f
+		}
+	}
+	return body, asJSON
+}
+
+// TimeServer answers RFC 868 time requests over UDP after the body's light
+// time.
+type TimeServer struct {
+	addr        string
+	defaultBody string
+	limiter     *RateLimiter // per source address; see newTimeLimiter
+	metrics     Metrics
+	timing      // clock and latency source (clock.go)
+
+	conn    net.PacketConn
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex // orders wg.Add against Stop
+	wg      sync.WaitGroup
+	pending chan struct{} // one slot per reply waiting to be sent
+}
+
+// newTimeLimiter caps the request rate per source address. It is always on:
+// the UDP source address is unauthenticated.
+func newTimeLimiter() *RateLimiter {
+	return NewRateLimiter(
+		envFloat("TIME_UDP_RATE_PER_MIN", 12),
+		envInt("TIME_UDP_BURST", 4),
+		0, 0,
+	)
+}
+
+// NewTimeServer creates a time server for addr answering for defaultBody
+// when a request doesn't name one. Call Listen, then Serve.
+func NewTimeServer(addr, defaultBody string, metrics Metrics) *TimeServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TimeServer{
+		addr:        addr,
+		defaultBody: defaultBody,
+		limiter:     newTimeLimiter(),
+		metrics:     orNop(metrics),
+		ctx:         ctx,
+		cancel:      cancel,
+		pending:     make(chan struct{}, timeMaxPending),
+	}
+}
+
+// Listen binds the UDP socket.
+func (ts *TimeServer) Listen() error {
+	c, err := net.ListenPacket("udp", ts.addr)
+	if err != nil {
+		return fmt.Errorf("time %s: %v", ts.addr, err)
+	}
+	ts.conn = c
+	return nil
+}
+
+// Addr returns the bound address (useful when listening on port 0).
+func (ts *TimeServer) Addr() net.Addr {
+	return ts.conn.LocalAddr()
+}
+
+// Serve answers datagrams until Stop is called.
+func (ts *TimeServer) Serve() error {
+	log.Printf("Time server (RFC 868) listening on %s/udp", ts.conn.LocalAddr())
+	go ts.limiter.StartCleanup(ts.ctx.Done())
+	buf := make([]byte, timeMaxQuery+1)
+	for {
+		n, from, err := ts.conn.ReadFrom(buf)
+		if err != nil {
+			if ts.ctx.Err() != nil || isNetClosingErr(err) {
+				return nil
+			}
+			log.Printf("Time %s: read failed: %v", ts.addr, err)
+			continue
+		}
+		if n > timeMaxQuery {
+			continue
+		}
+		stamped := ts.now()
+		release, err := ts.limiter.Acquire(clientIP(from.String()))
+		if err != nil {
+			continue // no reply: answering refusals would amplify too
+		}
+		release() // only the rate applies; there is no connection to hold
+		name, asJSON := parseTimeQuery(buf[:n], ts.defaultBody)
+		obj, ok := findObjectByName(getCelestialObjects(), name)
+		if !ok {
+			continue
+		}
+		distance, err := getCurrentDistance(obj.Name)
+		if err != nil {
+			continue
+		}
+		var reply []byte
+		oneWay := ts.oneWay(obj.Name, distance)
+		if asJSON {
+			reply = timeJSON(stamped, oneWay)
+		} else {
+			reply = rfc868Time(stamped)
+		}
+		if len(reply) > timeMaxReply {
+			continue
+		}
+
+		select {
+		case ts.pending <- struct{}{}:
+		default:
+			continue // too many replies in flight
+		}
+		ts.mu.Lock()
+		if ts.ctx.Err() != nil {
+			ts.mu.Unlock()
+			return nil
+		}
+		ts.wg.Add(1)
+		ts.mu.Unlock()
+		go func(to net.Addr) {
+			defer ts.wg.Done()
+			defer func() { <-ts.pending }()
+			if err := sleepCtx(ts.ctx, ts.clk(), oneWay-ts.since(stamped)); err != nil {
+				return
+			}
+			ts.conn.WriteTo(reply, to)
+			ts.metrics.RecordRequest(obj.Name, "time", ts.since(stamped))
+		}(from)
+	}
+}
+
+// Stop closes the socket and drops replies still waiting out their delay.
+func (ts *TimeServer) Stop() {
+	ts.mu.Lock()
+	ts.cancel()
+	ts.mu.Unlock()
+	if ts.conn != nil {
+		ts.conn.Close()
+	}
+	ts.wg.Wait()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestSpacecraftTimeArithmetic(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	oneWay := 12*time.Minute + 30*time.Second
+	st := spacecraftTime("Mars", now, oneWay)
+	if !st.Apparent.Add(oneWay).Equal(now) {
+		t.Errorf("apparent %v + %v != now %v", st.Apparent, oneWay, now)
+	}
+	if got := st.LatestEarth.Sub(st.EarliestEarth); got != 2*oneWay {
+		t.Errorf("ambiguity window %v, want the round trip %v", got, 2*oneWay)
+	}
+	// Equal legs put the estimate in the middle of the window: UTC itself.
+	if mid := st.EarliestEarth.Add(st.LatestEarth.Sub(st.EarliestEarth) / 2); !mid.Equal(now) {
+		t.Errorf("window midpoint %v, want %v", mid, now)
+	}
+	if st.OneWay != 750 || st.RoundTrip != 1500 {
+		t.Errorf("one way %v, round trip %v", st.OneWay, st.RoundTrip)
+	}
+}
+
+func TestTimeAPI(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	for _, url := range []string{"http://mars.latency.space/api/time", "http://latency.space/api/time?body=mars"} {
+		rec := get(url)
+		var st SpacecraftTime
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &st) != nil {
+			t.Fatalf("%s: %d %s", url, rec.Code, rec.Body.String())
+		}
+		want := CalculateLatency(mustDistance(t, "Mars"))
+		if st.Body != "Mars" || st.OneWay != want.Seconds() {
+			t.Errorf("%s: body %s one way %vs, want Mars %vs", url, st.Body, st.OneWay, want.Seconds())
+		}
+		if d := st.EarthUTC.Sub(st.Apparent) - want; d < -time.Millisecond || d > time.Millisecond {
+			t.Errorf("%s: apparent + delay is %v off UTC", url, d)
+		}
+		if d := time.Since(st.EarthUTC); d < 0 || d > 5*time.Second {
+			t.Errorf("%s: earth_utc %v is not now", url, st.EarthUTC)
+		}
+	}
+	if rec := get("http://latency.space/api/time"); rec.Code != http.StatusBadRequest {
+		t.Errorf("no body: expected 400, got %d", rec.Code)
+	}
+	if rec := get("http://latency.space/api/time?body=vulcan"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown body: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestRFC868Time(t *testing.T) {
+	// RFC 868's own examples.
+	for _, tc := range []struct {
+		at   time.Time
+		want uint32
+	}{
+		{time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), 2208988800},
+		{time.Date(1976, 1, 1, 0, 0, 0, 0, time.UTC), 2398291200},
+		{time.Date(1983, 5, 1, 0, 0, 0, 0, time.UTC), 2629584000},
+	} {
+		if got := binary.BigEndian.Uint32(rfc868Time(tc.at)); got != tc.want {
+			t.Errorf("%v: %d, want %d", tc.at, got, tc.want)
+		}
+	}
+}
+
+// startTimeServer runs a time server on a loopback port answering for Mars,
+// latency away.
+func startTimeServer(t *testing.T, limiter *RateLimiter, latency time.Duration) (*TimeServer, net.PacketConn) {
+	t.Helper()
+	ts := NewTimeServer("127.0.0.1:0", "mars", NewRecordingMetrics())
+	ts.timing = fixedLatency(latency)
+	if limiter != nil {
+		ts.limiter = limiter
+	}
+	if err := ts.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go ts.Serve()
+	t.Cleanup(ts.Stop)
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return ts, client
+}
+
+// timeQuery sends query and returns the reply, or nil if none comes.
+func timeQuery(t *testing.T, ts *TimeServer, client net.PacketConn, query string) []byte {
+	t.Helper()
+	if _, err := client.WriteTo([]byte(query), ts.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 512)
+	client.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	n, _, err := client.ReadFrom(buf)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+func TestTimeUDP(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	ts, client := startTimeServer(t, NewRateLimiter(6000, 100, 0, 0), latency)
+
+	sent := time.Now()
+	reply := timeQuery(t, ts, client, "")
+	elapsed := time.Since(sent)
+	if len(reply) != 4 {
+		t.Fatalf("RFC 868 reply is %d bytes, want 4", len(reply))
+	}
+	if elapsed < latency {
+		t.Errorf("reply after %v, want at least the one-way %v", elapsed, latency)
+	}
+	stamped := rfc868Epoch.Add(time.Duration(binary.BigEndian.Uint32(reply)) * time.Second)
+	if d := sent.Sub(stamped); d < -time.Second || d > time.Second {
+		t.Errorf("RFC 868 time %v, sent at %v", stamped, sent)
+	}
+
+	reply = timeQuery(t, ts, client, "Mars json")
+	if len(reply) == 0 || len(reply) > timeMaxReply {
+		t.Fatalf("JSON reply %q: want 1-%d bytes", reply, timeMaxReply)
+	}
+	var j struct {
+		UTC   time.Time `json:"utc"`
+		Delay float64   `json:"delay_s"`
+	}
+	if err := json.Unmarshal(reply, &j); err != nil {
+		t.Fatalf("JSON reply %q: %v", reply, err)
+	}
+	if j.Delay != latency.Seconds() || time.Since(j.UTC) < latency || time.Since(j.UTC) > 5*time.Second {
+		t.Errorf("JSON reply %q", reply)
+	}
+
+	// The longest name still fits the cap.
+	if reply := timeQuery(t, ts, client, "json new-horizons"); len(reply) == 0 || len(reply) > timeMaxReply {
+		t.Errorf("New Horizons reply %q", reply)
+	}
+	for _, q := range []string{"vulcan", strings.Repeat("x", timeMaxQuery+1)} {
+		if reply := timeQuery(t, ts, client, q); reply != nil {
+			t.Errorf("%.10q...: expected no reply, got %q", q, reply)
+		}
+	}
+}
+
+func TestTimeUDPRateLimited(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	ts, client := startTimeServer(t, NewRateLimiter(1, 3, 0, 0), time.Millisecond)
+	for i := 0; i < 10; i++ {
+		client.WriteTo(nil, ts.Addr())
+	}
+	replies := countEchoes(client, 300*time.Millisecond)
+	if replies != 3 {
+		t.Errorf("got %d replies to 10 requests, want the burst of 3", replies)
+	}
+}
@@ -56,8 +56,9 @@ func isValidSubdomain(host string) bool {
 }
 
 // setupTLS configures and returns a *tls.Config suitable for the HTTPS server,
-// including ACME autocert support for automatic certificate management.
-func setupTLS() *tls.Config {
+// including ACME autocert support for automatic certificate management. The
+// manager is returned too so the HTTP listener can answer its challenges.
+func setupTLS() (*tls.Config, *autocert.Manager) {
 	// Ensure the certificate cache directory exists.
 	err := os.MkdirAll("certs", 0700)
 	if err != nil {
@@ -135,7 +136,7 @@ func setupTLS() *tls.Config {
 			// tls.TLS_RSA_WITH_AES_256_GCM_SHA384, // Requires Go 1.5+
 			// tls.TLS_RSA_WITH_AES_128_GCM_SHA256, // Requires Go 1.5+
 		},
-	}
+	}, manager
 }
 
 // getDefaultCertificate loads the default certificate or generates a self-signed one if it doesn't exist.
@@ -0,0 +1,193 @@
+// traceroute.go - the simulated path of a proxied request, hop by hop.
+//
+//	GET http://mars.latency.space/api/traceroute?target=example.com
+//
+// The path is drawn as a bent pipe: the client reaches an Earth ground
+// station, the request goes up to the body and back down to Earth, and
+// leaves for the target from there. The terrestrial legs cost nothing in
+// the model; each space leg costs one light time. The proxy applies the
+// same two light times as one each way, so the delay at the target hop is
+// the round trip a client of the proxy sees.
+//
+// With -ground-station on, the Earth hop names the station the body is
+// worked from. Every body is routed directly, so there are no relay hops
+// between Earth and the body. Nothing is sent to the target: the hops are
+// computed from the distance cache. Accept: text/plain gets the listing in
+// traceroute's layout instead of JSON.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TracerouteHop is one hop of a simulated path.
+type TracerouteHop struct {
+	Hop        int     `json:"hop"`
+	Name       string  `json:"name"`
+	LegKm      float64 `json:"leg_distance_km"`          // from the previous hop
+	DelaySec   float64 `json:"cumulative_delay_seconds"` // one-way, from the client
+	Occluded   bool    `json:"occluded"`                 // the leg into this hop is blocked
+	OccludedBy string  `json:"occluded_by,omitempty"`
+}
+
+// TracerouteResponse is the JSON returned by /api/traceroute.
+type TracerouteResponse struct {
+	Body      string          `json:"body"`
+	Target    string          `json:"target"`
+	Timestamp time.Time       `json:"timestamp"`
+	Hops      []TracerouteHop `json:"hops"`
+}
+
+// handleTraceroute serves /api/traceroute.
+func (s *Server) handleTraceroute(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	snap, ok := s.requestSnapshot(w, r)
+	if !ok {
+		return
+	}
+	name := strings.TrimSpace(q.Get("body"))
+	if name == "" {
+		name = s.resolveCelestialHost(snap.Objects, r.Host)
+	}
+	if name == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.traceroute_body")
+		return
+	}
+	obj, ok := snap.Find(name)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotFound, "error.unknown_body_named", name)
+		return
+	}
+	target := tracerouteTarget(q.Get("target"))
+	if target == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.traceroute_target")
+		return
+	}
+	if !s.security.IsAllowedHost(target) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("host %q is not allowed", target)})
+		return
+	}
+	distance, err := snap.Distance(obj.Name)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	setStationHeader(w, obj.Name)
+
+	at := simTime(distanceClock())
+	earth := "Earth"
+	if e, ok := snap.Entry(obj.Name); ok {
+		if fix := stationFor(e, at); fix != nil && fix.Visible {
+			earth = "Earth (" + fix.Station + ")"
+		}
+	}
+	var occluder string
+	if earthObj, ok := snap.Find("Earth"); ok {
+		if v, by := IsOccluded(earthObj, obj, snap.Objects, at); v.Blocked() {
+			occluder = by.Name
+			if occluder == "" {
+				occluder = "unknown"
+			}
+		}
+	}
+	resp := traceroute(obj.Name, target, distance, s.oneWay(obj.Name, distance), earth, occluder)
+	resp.Timestamp = at.UTC()
+
+	if prefersPlainText(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		writeTraceroute(w, resp)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// traceroute lays out the hops from the client to target through body,
+// which is distanceKm and oneWay from earth (the Earth hop's name). A
+// non-empty occluder blocks both space legs.
+func traceroute(body, target string, distanceKm float64, oneWay time.Duration, earth, occluder string) *TracerouteResponse {
+	resp := &TracerouteResponse{Body: body, Target: target}
+	var delay time.Duration
+	add := func(name string, legKm float64, legDelay time.Duration, space bool) {
+		delay += legDelay
+		hop := TracerouteHop{Hop: len(resp.Hops) + 1, Name: name, LegKm: legKm, DelaySec: delay.Seconds()}
+		if space && occluder != "" {
+			hop.Occluded, hop.OccludedBy = true, occluder
+		}
+		resp.Hops = append(resp.Hops, hop)
+	}
+	add("client", 0, 0, false)
+	add(earth, 0, 0, false)
+	add(body, distanceKm, oneWay, true)
+	add("Earth", distanceKm, oneWay, true)
+	add(target, 0, 0, false)
+	return resp
+}
+
+// tracerouteTarget reduces the target parameter, a host or a URL, to its
+// host name; it returns "" when there is none.
+func tracerouteTarget(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if strings.Contains(raw, "://") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return ""
+		}
+		return strings.ToLower(u.Hostname())
+	}
+	if host, _, err := net.SplitHostPort(raw); err == nil {
+		raw = host
+	}
+	if strings.ContainsAny(raw, "/?# ") {
+		return ""
+	}
+	return strings.ToLower(raw)
+}
+
+// prefersPlainText reports whether r asks for text/plain rather than JSON.
+func prefersPlainText(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		switch strings.TrimSpace(mediaType) {
+		case "text/plain":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
+
+// writeTraceroute renders resp the way traceroute prints a path: one line
+// per hop with its cumulative delay in milliseconds and the leg distance
+// for space legs. Hops past an occluded leg can't be reached and show "*".
+func writeTraceroute(w io.Writer, resp *TracerouteResponse) {
+	width := 0
+	for _, h := range resp.Hops {
+		width = max(width, len(h.Name))
+	}
+	fmt.Fprintf(w, "traceroute to %s via %s, %d hops\n", resp.Target, resp.Body, len(resp.Hops))
+	blocked := false
+	for _, h := range resp.Hops {
+		fmt.Fprintf(w, "%2d  %-*s", h.Hop, width, h.Name)
+		blocked = blocked || h.Occluded
+		if blocked {
+			fmt.Fprint(w, "  *")
+		} else {
+			fmt.Fprintf(w, "  %.3f ms", h.DelaySec*1000)
+		}
+		if h.LegKm > 0 {
+			fmt.Fprintf(w, "  %.0f km", h.LegKm)
+		}
+		if h.Occluded {
+			fmt.Fprintf(w, "  (occluded by %s)", h.OccludedBy)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestTracerouteHops(t *testing.T) {
+	resp := traceroute("Mars", "example.com", 2.5e8, 833910*time.Millisecond, "Earth (Goldstone)", "")
+	var names []string
+	for i, h := range resp.Hops {
+		names = append(names, h.Name)
+		if h.Hop != i+1 {
+			t.Errorf("hop %d numbered %d", i+1, h.Hop)
+		}
+	}
+	if got := strings.Join(names, " > "); got != "client > Earth (Goldstone) > Mars > Earth > example.com" {
+		t.Errorf("hops: %s", got)
+	}
+	// Each space leg adds one light time; the terrestrial ones add nothing.
+	for i, want := range []float64{0, 0, 833.91, 1667.82, 1667.82} {
+		if got := resp.Hops[i].DelaySec; got < want-1e-6 || got > want+1e-6 {
+			t.Errorf("hop %d delay %.6f s, want %.2f s", i+1, got, want)
+		}
+	}
+	for i, want := range []float64{0, 0, 2.5e8, 2.5e8, 0} {
+		if resp.Hops[i].LegKm != want || resp.Hops[i].Occluded {
+			t.Errorf("hop %d: %+v", i+1, resp.Hops[i])
+		}
+	}
+
+	blocked := traceroute("Mars", "example.com", 2.5e8, time.Second, "Earth", "Sun")
+	for i, h := range blocked.Hops {
+		space := i == 2 || i == 3
+		if h.Occluded != space || (space && h.OccludedBy != "Sun") {
+			t.Errorf("occluded: hop %d: %+v", i+1, h)
+		}
+	}
+}
+
+func TestWriteTraceroute(t *testing.T) {
+	var buf bytes.Buffer
+	writeTraceroute(&buf, traceroute("Mars", "example.com", 2.5e8, 833910*time.Millisecond, "Earth (Goldstone)", ""))
+	want := `traceroute to example.com via Mars, 5 hops
+ 1  client             0.000 ms
+ 2  Earth (Goldstone)  0.000 ms
+ 3  Mars               833910.000 ms  250000000 km
+ 4  Earth              1667820.000 ms  250000000 km
+ 5  example.com        1667820.000 ms
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	writeTraceroute(&buf, traceroute("Mars", "example.com", 2.5e8, time.Second, "Earth", "Sun"))
+	want = `traceroute to example.com via Mars, 5 hops
+ 1  client       0.000 ms
+ 2  Earth        0.000 ms
+ 3  Mars         *  250000000 km  (occluded by Sun)
+ 4  Earth        *  250000000 km  (occluded by Sun)
+ 5  example.com  *
+`
+	if buf.String() != want {
+		t.Errorf("occluded, got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestTracerouteTarget(t *testing.T) {
+	for raw, want := range map[string]string{
+		"example.com":                   "example.com",
+		" Example.COM ":                 "example.com",
+		"example.com:443":               "example.com",
+		"https://www.example.com/a?b=c": "www.example.com",
+		"example.com/path":              "",
+		"":                              "",
+		"http://[::1]:8080/":            "::1",
+	} {
+		if got := tracerouteTarget(raw); got != want {
+			t.Errorf("tracerouteTarget(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestTracerouteEndpoint(t *testing.T) {
+	withObjects(t, celestial.InitSolarSystemObjects())
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics(), timing: fixedLatency(2 * time.Second)}
+	get := func(url, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("http://mars.latency.space/api/traceroute?target=example.com", "")
+	var out TracerouteResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("%d %s: %v", rec.Code, rec.Body.String(), err)
+	}
+	if out.Body != "Mars" || out.Target != "example.com" || len(out.Hops) != 5 {
+		t.Fatalf("response: %+v", out)
+	}
+	if last := out.Hops[4]; last.Name != "example.com" || last.DelaySec != 4 {
+		t.Errorf("target hop %+v, want two fixed 2s legs", last)
+	}
+	if out.Hops[1].Name != "Earth" {
+		t.Errorf("Earth hop %q with the ground-station model off", out.Hops[1].Name)
+	}
+
+	rec = get("http://mars.latency.space/api/traceroute?target=example.com", "text/plain")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") ||
+		!strings.HasPrefix(rec.Body.String(), "traceroute to example.com via Mars, 5 hops\n") {
+		t.Errorf("text: %s\n%s", ct, rec.Body.String())
+	}
+
+	withGroundStation(t, "auto")
+	rec = get("http://latency.space/api/traceroute?body=mars&target=example.com", "")
+	json.Unmarshal(rec.Body.Bytes(), &out)
+	if station := rec.Header().Get("X-Latency-Space-Ground-Station"); station != "" && station != "none" {
+		if want := "Earth (" + strings.SplitN(station, ";", 2)[0] + ")"; out.Hops[1].Name != want {
+			t.Errorf("Earth hop %q, want %q", out.Hops[1].Name, want)
+		}
+	}
+
+	for url, code := range map[string]int{
+		"http://latency.space/api/traceroute?target=example.com":        http.StatusBadRequest,
+		"http://vulcan.latency.space/api/traceroute?target=example.com": http.StatusBadRequest,
+		"http://latency.space/api/traceroute?body=vulcan&target=x.com":  http.StatusNotFound,
+		"http://mars.latency.space/api/traceroute":                      http.StatusBadRequest,
+		"http://mars.latency.space/api/traceroute?target=evil.invalid":  http.StatusForbidden,
+	} {
+		if rec := get(url, ""); rec.Code != code {
+			t.Errorf("%s: %d, want %d", url, rec.Code, code)
+		}
+	}
+}
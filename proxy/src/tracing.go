@@ -0,0 +1,366 @@
+// tracing.go - per-request timing spans, exported as OpenTelemetry (OTLP).
+//
+// Explaining why a request took 31 minutes instead of the expected 28 needs
+// the time split between simulated latency, the upstream and our own
+// overhead. With -tracing each HTTP request and SOCKS connection gets a root
+// span with a child per phase:
+//
+//	host.parse      which body the host/connection names
+//	link.check      occlusion and DSN contact windows
+//	latency.sleep   the simulated light-time delay (latency.intended_ms)
+//	upstream.dial   TCP (and TLS) connect to the target
+//	upstream.ttfb   request written -> first response byte (HTTP fetches)
+//	body.copy       response body read / SOCKS relay
+//
+// DTN jobs are traced as a dtn.fetch span parented to the request that
+// submitted them.
+//
+// Spans are exported in batches as OTLP/HTTP JSON to the collector named by the
+// standard OTEL_EXPORTER_OTLP_TRACES_ENDPOINT / OTEL_EXPORTER_OTLP_ENDPOINT
+// variables (headers from OTEL_EXPORTER_OTLP_HEADERS, service name from
+// OTEL_SERVICE_NAME). The OpenTelemetry SDK isn't vendored into this build, so
+// the span model and exporter are implemented here over the stdlib; the wire
+// format is the standard one, so any OTLP collector accepts it.
+//
+// With tracing off (the default) there is no tracer: startSpan returns a nil
+// *Span, whose methods are no-ops.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	mrand "math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	traceBatchSize     = 512             // spans per export request
+	traceBatchInterval = 5 * time.Second // max delay before a partial batch is sent
+	traceQueueSize     = 4096            // spans buffered before new ones are dropped
+)
+
+// spanAttr is one span attribute. Values are strings, bools, ints or floats.
+type spanAttr struct {
+	Key   string
+	Value interface{}
+}
+
+func attr(key string, value interface{}) spanAttr { return spanAttr{key, value} }
+
+// spanContext identifies a span and carries the trace's sampling decision.
+type spanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+type spanContextKey struct{}
+
+// Span is a timed operation within a trace. A nil *Span is valid and does
+// nothing.
+type Span struct {
+	tracer *Tracer
+	sc     spanContext
+	parent [8]byte // zero for a root span
+
+	Name   string
+	Start  time.Time
+	Finish time.Time
+	Attrs  []spanAttr
+	Err    string // set by SetError; exported as an error status
+	ended  atomic.Bool
+}
+
+// Tracer samples, collects and exports spans.
+type Tracer struct {
+	ratio    float64
+	exporter spanExporter
+	queue    chan *Span
+	dropped  atomic.Int64
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+// spanExporter ships a batch of finished spans.
+type spanExporter interface {
+	ExportSpans(spans []*Span) error
+}
+
+var activeTracer atomic.Pointer[Tracer]
+
+// setTracer installs t as the process tracer (nil disables tracing).
+func setTracer(t *Tracer) { activeTracer.Store(t) }
+
+// newTracer starts a tracer that samples ratio of new traces (children follow
+// their root) and exports them in batches.
+func newTracer(exp spanExporter, ratio float64) *Tracer {
+	t := &Tracer{
+		ratio:    ratio,
+		exporter: exp,
+		queue:    make(chan *Span, traceQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Shutdown flushes queued spans and stops the exporter loop.
+func (t *Tracer) Shutdown() {
+	if t == nil {
+		return
+	}
+	t.once.Do(func() { close(t.stop) })
+	<-t.done
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	tick := time.NewTicker(traceBatchInterval)
+	defer tick.Stop()
+	batch := make([]*Span, 0, traceBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.ExportSpans(batch); err != nil {
+			log.Printf("Tracing: export of %d spans failed: %v", len(batch), err)
+		}
+		batch = make([]*Span, 0, traceBatchSize)
+	}
+	for {
+		select {
+		case sp := <-t.queue:
+			if batch = append(batch, sp); len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		case <-t.stop:
+			for {
+				select {
+				case sp := <-t.queue:
+					batch = append(batch, sp)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// startSpan starts a span as a child of the span in ctx (or a new trace) and
+// returns a context carrying it. Without a tracer, or in an unsampled trace,
+// it returns a nil span.
+func startSpan(ctx context.Context, name string, attrs ...spanAttr) (context.Context, *Span) {
+	t := activeTracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	parent, hasParent := ctx.Value(spanContextKey{}).(spanContext)
+	if hasParent && !parent.Sampled {
+		return ctx, nil
+	}
+	sc := spanContext{Sampled: true}
+	if hasParent {
+		sc.TraceID = parent.TraceID
+	} else {
+		rand.Read(sc.TraceID[:])
+		if t.ratio < 1 && mrand.Float64() >= t.ratio {
+			// Record the decision so the children are dropped too.
+			sc.Sampled = false
+			return context.WithValue(ctx, spanContextKey{}, sc), nil
+		}
+	}
+	rand.Read(sc.SpanID[:])
+	sp := &Span{tracer: t, sc: sc, Name: name, Start: time.Now(), Attrs: attrs}
+	if hasParent {
+		sp.parent = parent.SpanID
+	}
+	return context.WithValue(ctx, spanContextKey{}, sc), sp
+}
+
+// SetAttr adds or replaces an attribute.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	for i := range s.Attrs {
+		if s.Attrs[i].Key == key {
+			s.Attrs[i].Value = value
+			return
+		}
+	}
+	s.Attrs = append(s.Attrs, spanAttr{key, value})
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Err = err.Error()
+}
+
+// End finishes the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil || !s.ended.CompareAndSwap(false, true) {
+		return
+	}
+	s.Finish = time.Now()
+	select {
+	case s.tracer.queue <- s:
+	default:
+		s.tracer.dropped.Add(1)
+	}
+}
+
+// Context returns ctx carrying s as the parent for new spans.
+func (s *Span) Context(ctx context.Context) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, s.sc)
+}
+
+// backdate moves the span's start, for phases only known once they're over
+// (e.g. the time a DTN job spent in transit).
+func (s *Span) backdate(start time.Time) {
+	if s != nil {
+		s.Start = start
+	}
+}
+
+// otlpExporter posts spans as OTLP/HTTP JSON.
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+}
+
+// newOTLPExporterFromEnv configures the exporter from the standard
+// OTEL_EXPORTER_OTLP_* variables.
+func newOTLPExporterFromEnv() *otlpExporter {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			base = "http://localhost:4318"
+		}
+		endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+	}
+	headerSpec := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS")
+	if headerSpec == "" {
+		headerSpec = os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")
+	}
+	headers := make(map[string]string)
+	for _, kv := range strings.Split(headerSpec, ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "latency-space-proxy"
+	}
+	return &otlpExporter{endpoint: endpoint, headers: headers, service: service,
+		client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (e *otlpExporter) ExportSpans(spans []*Span) error {
+	body, err := json.Marshal(otlpPayload(e.service, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpPayload builds an ExportTraceServiceRequest in OTLP's JSON mapping.
+func otlpPayload(service string, spans []*Span) map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		span := map[string]interface{}{
+			"traceId":           hex.EncodeToString(s.sc.TraceID[:]),
+			"spanId":            hex.EncodeToString(s.sc.SpanID[:]),
+			"name":              s.Name,
+			"kind":              1, // SPAN_KIND_INTERNAL
+			"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.Finish.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.Attrs),
+		}
+		if s.parent != ([8]byte{}) {
+			span["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		if s.Err != "" {
+			span["status"] = map[string]interface{}{"code": 2, "message": s.Err} // STATUS_CODE_ERROR
+		}
+		out = append(out, span)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes([]spanAttr{{"service.name", service}}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "github.com/latency-space/proxy"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attrs []spanAttr) []interface{} {
+	out := make([]interface{}, 0, len(attrs))
+	for _, a := range attrs {
+		var v map[string]interface{}
+		switch x := a.Value.(type) {
+		case string:
+			v = map[string]interface{}{"stringValue": x}
+		case bool:
+			v = map[string]interface{}{"boolValue": x}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(x)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": x}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(x)}
+		}
+		out = append(out, map[string]interface{}{"key": a.Key, "value": v})
+	}
+	return out
+}
+
+// durationMs is a span attribute value for a duration in milliseconds.
+func durationMs(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
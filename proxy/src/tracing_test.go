@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// memoryExporter keeps exported spans for inspection.
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *memoryExporter) ExportSpans(spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// withMemoryTracer installs a tracer exporting to memory for the test. The
+// returned func flushes it and returns what was exported.
+func withMemoryTracer(t *testing.T, ratio float64) func() []*Span {
+	t.Helper()
+	exp := &memoryExporter{}
+	tr := newTracer(exp, ratio)
+	setTracer(tr)
+	t.Cleanup(func() {
+		setTracer(nil)
+		tr.Shutdown()
+	})
+	return func() []*Span {
+		tr.Shutdown()
+		exp.mu.Lock()
+		defer exp.mu.Unlock()
+		return append([]*Span(nil), exp.spans...)
+	}
+}
+
+// spanTree renders spans as "parent>child" edges, sorted, so a test can
+// compare the shape of a trace regardless of export order.
+func spanTree(t *testing.T, spans []*Span) []string {
+	t.Helper()
+	byID := make(map[[8]byte]*Span)
+	for _, s := range spans {
+		byID[s.sc.SpanID] = s
+	}
+	var edges []string
+	for _, s := range spans {
+		if s.sc.TraceID != spans[0].sc.TraceID {
+			t.Errorf("span %s is in a different trace", s.Name)
+		}
+		if s.parent == ([8]byte{}) {
+			edges = append(edges, ">"+s.Name)
+			continue
+		}
+		p, ok := byID[s.parent]
+		if !ok {
+			t.Errorf("span %s has an unexported parent", s.Name)
+			continue
+		}
+		if s.Start.Before(p.Start) && p.Name != "dtn.fetch" {
+			t.Errorf("span %s starts before its parent %s", s.Name, p.Name)
+		}
+		edges = append(edges, p.Name+">"+s.Name)
+	}
+	sort.Strings(edges)
+	return edges
+}
+
+func spanNamed(spans []*Span, name string) *Span {
+	for _, s := range spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func spanAttrValue(s *Span, key string) interface{} {
+	for _, a := range s.Attrs {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// TestTracingHTTPSpanTree sends a DTN request through handleHTTP and checks the exported trace: the request root, its host and link checks,
+// and the job's fetch with the latency and upstream phases beneath it.
+func TestTracingHTTPSpanTree(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	flush := withMemoryTracer(t, 1)
+
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello from space")
+	}))
+	defer dest.Close()
+
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(20 * time.Millisecond)
+	req := httptest.NewRequest(http.MethodPost, "http://mars.latency.space/dtn/send",
+		strings.NewReader(fmt.Sprintf(`{"url":%q}`, dest.URL)))
+	rec := httptest.NewRecorder()
+	s.handleHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("send: %d %s", rec.Code, rec.Body.String())
+	}
+	var job struct{ ID string }
+	json.Unmarshal(rec.Body.Bytes(), &job)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, st := dtnStatus(t, s, job.ID); st["state"] == "delivered" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job never delivered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	spans := flush()
+	got := strings.Join(spanTree(t, spans), " ")
+	want := strings.Join([]string{
+		">http.request",
+		"dtn.fetch>body.copy",
+		"dtn.fetch>latency.sleep",
+		"dtn.fetch>upstream.dial",
+		"dtn.fetch>upstream.ttfb",
+		"http.request>dtn.fetch",
+		"http.request>host.parse",
+		"http.request>link.check",
+	}, " ")
+	if got != want {
+		t.Errorf("span tree:\n got %s\nwant %s", got, want)
+	}
+
+	if sleep := spanNamed(spans, "latency.sleep"); sleep != nil {
+		if ms := spanAttrValue(sleep, "latency.intended_ms"); ms != 20.0 {
+			t.Errorf("latency.intended_ms = %v, want 20", ms)
+		}
+	}
+	if hp := spanNamed(spans, "host.parse"); hp != nil && spanAttrValue(hp, "celestial.body") != "Mars" {
+		t.Errorf("host.parse celestial.body = %v", spanAttrValue(hp, "celestial.body"))
+	}
+	if bc := spanNamed(spans, "body.copy"); bc != nil && spanAttrValue(bc, "bytes") != len("hello from space") {
+		t.Errorf("body.copy bytes = %v", spanAttrValue(bc, "bytes"))
+	}
+	for _, sp := range spans {
+		if sp.Finish.Before(sp.Start) {
+			t.Errorf("span %s finishes before it starts", sp.Name)
+		}
+	}
+}
+
+// TestTracingSOCKSSession relays through a SOCKS CONNECT and checks the
+// per-connection root span and its phases.
+func TestTracingSOCKSSession(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	flush := withMemoryTracer(t, 1)
+
+	echo := startEchoServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		h := NewSOCKSHandler(conn, newTestSecurity(), NewRecordingMetrics(), "Mars", nil)
+		h.timing = fixedLatency(5 * time.Millisecond)
+		h.Handle()
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetDeadline(time.Now().Add(3 * time.Second))
+	client.Write([]byte{SOCKS5_VERSION, 1, SOCKS5_NO_AUTH})
+	if _, err := io.ReadFull(client, make([]byte, 2)); err != nil {
+		t.Fatalf("greeting: %v", err)
+	}
+	dst := echo
+	connect := []byte{SOCKS5_VERSION, 1, 0, 1}
+	connect = append(connect, dst.IP.To4()...)
+	connect = binary.BigEndian.AppendUint16(connect, uint16(dst.Port))
+	client.Write(connect)
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != 0 {
+		t.Fatalf("connect: reply %v, err %v", reply, err)
+	}
+	client.Write([]byte("ping"))
+	if _, err := io.ReadFull(client, make([]byte, 4)); err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+	client.Close()
+	<-handled
+
+	spans := flush()
+	got := strings.Join(spanTree(t, spans), " ")
+	want := ">socks.session socks.session>body.copy socks.session>host.parse socks.session>latency.sleep " +
+		"socks.session>link.check socks.session>upstream.dial"
+	if got != want {
+		t.Errorf("span tree:\n got %s\nwant %s", got, want)
+	}
+	if sleep := spanNamed(spans, "latency.sleep"); sleep != nil {
+		if ms := spanAttrValue(sleep, "latency.intended_ms"); ms != 5.0 {
+			t.Errorf("latency.intended_ms = %v, want 5", ms)
+		}
+	}
+}
+
+// TestTracingDisabledAndSampling checks the no-op paths: no tracer, and a
+// trace dropped by the sampler, whose children must be dropped with it.
+func TestTracingDisabledAndSampling(t *testing.T) {
+	setTracer(nil)
+	ctx, sp := startSpan(context.Background(), "http.request")
+	if sp != nil {
+		t.Fatal("expected a nil span without a tracer")
+	}
+	sp.SetAttr("k", "v")
+	sp.SetError(io.EOF)
+	sp.End()
+	if ctx != context.Background() {
+		t.Error("a disabled tracer should not touch the context")
+	}
+
+	flush := withMemoryTracer(t, 0)
+	ctx, root := startSpan(context.Background(), "http.request")
+	_, child := startSpan(ctx, "host.parse")
+	if root != nil || child != nil {
+		t.Error("ratio 0 should sample nothing")
+	}
+	if spans := flush(); len(spans) != 0 {
+		t.Errorf("exported %d spans, want 0", len(spans))
+	}
+}
+
+// TestOTLPPayload checks the JSON mapping sent to the collector.
+func TestOTLPPayload(t *testing.T) {
+	sp := &Span{Name: "upstream.dial", Start: time.Unix(1, 0), Finish: time.Unix(2, 0),
+		Attrs: []spanAttr{attr("net.peer", "example.com:443"), attr("bytes", int64(7)), attr("link.up", true)}}
+	sp.sc.TraceID[0], sp.sc.SpanID[0], sp.parent[0] = 1, 2, 3
+	sp.SetError(io.EOF)
+
+	raw, err := json.Marshal(otlpPayload("svc", []*Span{sp}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]interface{} `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []map[string]interface{} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil {
+		t.Fatal(err)
+	}
+	got := out.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	for k, want := range map[string]interface{}{
+		"traceId":           "01000000000000000000000000000000",
+		"spanId":            "0200000000000000",
+		"parentSpanId":      "0300000000000000",
+		"startTimeUnixNano": "1000000000",
+		"endTimeUnixNano":   "2000000000",
+	} {
+		if got[k] != want {
+			t.Errorf("%s = %v, want %v", k, got[k], want)
+		}
+	}
+	if status, _ := got["status"].(map[string]interface{}); status["code"] != 2.0 {
+		t.Errorf("status = %v, want error", got["status"])
+	}
+	if !strings.Contains(string(raw), `{"key":"bytes","value":{"intValue":"7"}}`) ||
+		!strings.Contains(string(raw), `{"key":"link.up","value":{"boolValue":true}}`) ||
+		!strings.Contains(string(raw), `{"key":"service.name","value":{"stringValue":"svc"}}`) {
+		t.Errorf("unexpected attributes in %s", raw)
+	}
+}
@@ -0,0 +1,106 @@
+// tracked_listener.go - the listener lifecycle shared by the small TCP
+// services (-tcp-forward, finger, gopher).
+//
+// Each service accepts on its own listener, caps connections per IP with its
+// RateLimiter, and can be stopped on its own: Stop closes the listener and
+// every live connection, then waits for their handlers to return.
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+)
+
+// trackedListener is a listener and the connections it has accepted. A
+// service embeds it, binds listener in its Listen, and serves with serve.
+type trackedListener struct {
+	listener net.Listener
+	ctx      context.Context // cancelled by Stop
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newTrackedListener() *trackedListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &trackedListener{ctx: ctx, cancel: cancel, conns: make(map[net.Conn]struct{})}
+}
+
+// Addr returns the bound listen address (useful when listening on port 0).
+func (t *trackedListener) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// serve accepts connections until Stop is called, handing each to handle on
+// its own goroutine. name starts its log lines; limiter may be nil.
+func (t *trackedListener) serve(name string, limiter *RateLimiter, handle func(net.Conn)) error {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			if t.ctx.Err() != nil || isNetClosingErr(err) {
+				return nil
+			}
+			log.Printf("%s %s: accept failed: %v", name, t.listener.Addr(), err)
+			continue
+		}
+
+		ip := clientIP(conn.RemoteAddr().String())
+		release, err := limiter.Acquire(ip)
+		if err != nil {
+			log.Printf("%s from %s rejected: %v", name, ip, err)
+			conn.Close()
+			continue
+		}
+
+		if !t.track(conn) {
+			release()
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer t.wg.Done()
+			defer release()
+			defer t.untrack(conn)
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+// Stop closes the listener and all live connections, then waits for their
+// handlers to return.
+func (t *trackedListener) Stop() {
+	t.cancel()
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	t.mu.Lock()
+	for c := range t.conns {
+		c.Close()
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+}
+
+// track registers a live connection, or reports false once Stop has begun.
+// Registration and wg.Add happen under mu so Stop cannot miss a connection.
+func (t *trackedListener) track(c net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ctx.Err() != nil {
+		return false
+	}
+	t.conns[c] = struct{}{}
+	t.wg.Add(1)
+	return true
+}
+
+func (t *trackedListener) untrack(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, c)
+}
@@ -0,0 +1,93 @@
+// transfer.go - how long files take to arrive from a body.
+//
+// Light time is only part of the cost of deep-space links: Voyager sends
+// 160 bits a second, so a megabyte takes over fourteen hours to serialize
+// whatever the distance. Each body's DownlinkBps/UplinkBps come from
+// InitSolarSystemObjects (or -objects-file); bodies without them, which is
+// every natural body, get defaultDataRate, a fiber-like link a future colony
+// might have. The info page and /api/bodies list transferSizes at the
+// body's downlink rate.
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultDataRate is the rate in bits per second assumed for a body with
+// no DownlinkBps or UplinkBps of its own.
+const defaultDataRate = 1e9
+
+// transferSize is one file size the transfer table lists.
+type transferSize struct {
+	Key   string // i18n key of the row label
+	Bytes int64
+}
+
+// transferSizes are the rows of the transfer table, smallest first.
+var transferSizes = []transferSize{
+	{"transfer.kb", 1 << 10},
+	{"transfer.mb", 1 << 20},
+	{"transfer.gb", 1 << 30},
+	{"transfer.photo", 25 << 20}, // one camera RAW file
+}
+
+// dataRates returns obj's downlink and uplink rates in bits per second,
+// with defaultDataRate standing in for a missing figure.
+func dataRates(obj CelestialObject) (down, up float64) {
+	down, up = obj.DownlinkBps, obj.UplinkBps
+	if down <= 0 {
+		down = defaultDataRate
+	}
+	if up <= 0 {
+		up = defaultDataRate
+	}
+	return down, up
+}
+
+// TransferTime is how long bytes take to arrive over a link of bps bits
+// per second with one-way latency: the last bit leaves after bytes*8/bps
+// and lands one light time later. A bps of zero or less adds no
+// serialization time.
+func TransferTime(bytes int64, bps float64, latency time.Duration) time.Duration {
+	if bps <= 0 {
+		return latency
+	}
+	return latency + time.Duration(float64(bytes)*8/bps*float64(time.Second))
+}
+
+// TransferSeconds is the transfer table in JSON.
+type TransferSeconds struct {
+	KB       float64 `json:"kb_seconds"`
+	MB       float64 `json:"mb_seconds"`
+	GB       float64 `json:"gb_seconds"`
+	RawPhoto float64 `json:"raw_photo_seconds"`
+}
+
+// transferSeconds fills the JSON table for a downlink of bps at latency.
+func transferSeconds(bps float64, latency time.Duration) TransferSeconds {
+	at := func(i int) float64 { return TransferTime(transferSizes[i].Bytes, bps, latency).Seconds() }
+	return TransferSeconds{KB: at(0), MB: at(1), GB: at(2), RawPhoto: at(3)}
+}
+
+// transferRows renders the transfer table for the info page in l.
+func transferRows(l Locale, bps float64, latency time.Duration) []impactRow {
+	rows := make([]impactRow, 0, len(transferSizes))
+	for _, size := range transferSizes {
+		rows = append(rows, impactRow{l.T(size.Key), l.Duration(TransferTime(size.Bytes, bps, latency))})
+	}
+	return rows
+}
+
+// formatBitRate reads bps with an SI prefix: "160 bps", "28 Mbps".
+func formatBitRate(bps float64) string {
+	for _, unit := range []struct {
+		name string
+		size float64
+	}{{"Gbps", 1e9}, {"Mbps", 1e6}, {"kbps", 1e3}} {
+		if bps >= unit.size {
+			return fmt.Sprintf("%g %s", bps/unit.size, unit.name)
+		}
+	}
+	return fmt.Sprintf("%g bps", bps)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestTransferTimeVoyager checks the arithmetic by hand: at 160 bps a
+// 1 MiB file is 8,388,608 bits, or 52,428.8 s (about 14.5 hours), plus
+// the light time.
+func TestTransferTimeVoyager(t *testing.T) {
+	const lightTime = 23 * time.Hour
+	if got, want := TransferTime(1<<20, 160, 0), 52428800*time.Millisecond; got != want {
+		t.Errorf("1 MiB at 160 bps took %v, want %v", got, want)
+	}
+	if got, want := TransferTime(1<<20, 160, lightTime), lightTime+52428800*time.Millisecond; got != want {
+		t.Errorf("with light time: %v, want %v", got, want)
+	}
+	// 1 KiB: 8192 bits at 160 bps is 51.2 s.
+	if got := TransferTime(1<<10, 160, 0); got != 51200*time.Millisecond {
+		t.Errorf("1 KiB at 160 bps took %v, want 51.2s", got)
+	}
+	if got := TransferTime(1<<30, 0, lightTime); got != lightTime {
+		t.Errorf("no rate should add no serialization time, got %v", got)
+	}
+
+	voyager, ok := findObjectByName(celestial.InitSolarSystemObjects(), "Voyager 1")
+	if !ok {
+		t.Fatal("Voyager 1 missing")
+	}
+	down, up := dataRates(voyager)
+	if down != 160 || up != 16 {
+		t.Errorf("Voyager 1 rates %v/%v, want 160/16", down, up)
+	}
+	table := transferSeconds(down, 0)
+	if table.MB != 52428.8 || table.RawPhoto != 25*52428.8 {
+		t.Errorf("Voyager 1 table %+v", table)
+	}
+	if hours := table.MB / 3600; hours < 14.5 || hours > 14.6 {
+		t.Errorf("1 MiB from Voyager 1 takes %.2f hours, want ~14.5", hours)
+	}
+}
+
+// TestDataRatesDefault checks that bodies without rates of their own get
+// the default link and that a single known rate is kept.
+func TestDataRatesDefault(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	for _, name := range []string{"Mars", "Jupiter", "Moon"} {
+		obj, ok := findObjectByName(objects, name)
+		if !ok {
+			t.Fatalf("%s missing", name)
+		}
+		if down, up := dataRates(obj); down != defaultDataRate || up != defaultDataRate {
+			t.Errorf("%s rates %v/%v, want the default", name, down, up)
+		}
+	}
+	if down, up := dataRates(CelestialObject{DownlinkBps: 500}); down != 500 || up != defaultDataRate {
+		t.Errorf("downlink-only rates %v/%v", down, up)
+	}
+	for _, obj := range objects {
+		if obj.Type == "spacecraft" && (obj.DownlinkBps <= 0 || obj.UplinkBps <= 0) {
+			t.Errorf("%s has no built-in data rates", obj.Name)
+		}
+	}
+}
+
+func TestFormatBitRate(t *testing.T) {
+	for bps, want := range map[float64]string{160: "160 bps", 167000: "167 kbps", 28e6: "28 Mbps", 1e9: "1 Gbps"} {
+		if got := formatBitRate(bps); got != want {
+			t.Errorf("formatBitRate(%v) = %q, want %q", bps, got, want)
+		}
+	}
+}
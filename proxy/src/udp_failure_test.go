@@ -22,13 +22,9 @@ func TestUDPFailureModes(t *testing.T) {
 	cleanup, _ := setupExtendedTestEnv()
 	defer cleanup()
 
-	// Enable test mode for low latency
-	testModeCleanup := setupTestMode()
-	defer testModeCleanup()
-
 	// Setup test-specific validator and metrics
-	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector()
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics()
 
 	// Allow loopback host for testing
 	security.allowedHosts["127.0.0.1"] = true
@@ -101,7 +97,7 @@ func TestUDPFailureModes(t *testing.T) {
 						return
 					}
 
-					handler := NewSOCKSHandler(tcpConn, security, metrics, "")
+					handler := NewTestSOCKSHandler(tcpConn, security, metrics)
 					handler.Handle()
 					close(serverComplete)
 				}()
@@ -292,7 +288,7 @@ func TestUDPFailureModes(t *testing.T) {
 					}
 					defer conn.Close()
 
-					handler := NewSOCKSHandler(conn, security, metrics, "")
+					handler := NewTestSOCKSHandler(conn, security, metrics)
 					handler.Handle()
 				}()
 
@@ -461,7 +457,7 @@ func TestUDPFailureModes(t *testing.T) {
 					}
 					defer conn.Close()
 
-					handler := NewSOCKSHandler(conn, security, metrics, "")
+					handler := NewTestSOCKSHandler(conn, security, metrics)
 					handler.Handle()
 				}()
 
@@ -649,7 +645,7 @@ func TestUDPFailureModes(t *testing.T) {
 					}
 					defer conn.Close()
 
-					handler := NewSOCKSHandler(conn, security, metrics, "")
+					handler := NewTestSOCKSHandler(conn, security, metrics)
 					handler.Handle()
 				}()
 
@@ -825,13 +821,9 @@ func TestUDPPortExhaustion(t *testing.T) {
 	cleanup, _ := setupExtendedTestEnv()
 	defer cleanup()
 
-	// Enable test mode for low latency
-	testModeCleanup := setupTestMode()
-	defer testModeCleanup()
-
 	// Setup security and metrics
-	security := NewSecurityValidator()
-	metrics := NewTestMetricsCollector()
+	security := newTestSecurity()
+	metrics := NewRecordingMetrics()
 
 	// Allow localhost testing
 	security.allowedHosts["127.0.0.1"] = true
@@ -858,7 +850,7 @@ func TestUDPPortExhaustion(t *testing.T) {
 			}
 
 			go func(c net.Conn) {
-				handler := NewSOCKSHandler(c, security, metrics, "")
+				handler := NewTestSOCKSHandler(c, security, metrics)
 				handler.Handle()
 			}(conn)
 		}
@@ -0,0 +1,212 @@
+// udp_impair.go - packet loss, reordering and duplication on the UDP relay.
+//
+// A perfectly reliable relay defeats the point of testing UDP applications
+// against deep space. Each direction of an association gets its own
+// impairer, which per packet (after the latency sleep) may:
+//
+//   - drop it (-udp-loss-pct)
+//   - hold it back until the next packet has gone out, so it arrives one
+//     latency quantum late (-udp-reorder-pct); with no successor within two
+//     quanta it is sent anyway
+//   - send it twice (-udp-dup-pct)
+//
+// Each flag takes a global percentage and optional per-body overrides, e.g.
+// "1,mars=2,voyager-1=10". Within solarImpairmentElongationDeg of the Sun,
+// where solar scintillation degrades real links, the rates grow inversely
+// with the elongation (up to solarImpairmentMaxFactor times), and a link
+// whose line of sight grazes the Sun's limb (VisibilityDegraded) loses a
+// further -udp-degraded-loss-pct of its packets. Rolls come from
+// the shared simulation source (simrand.go), so -sim-seed makes the pattern
+// reproducible. Affected packets are counted in udp_impaired_packets_total.
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+const (
+	// solarImpairmentElongationDeg is where the solar scaling starts.
+	solarImpairmentElongationDeg = 20.0
+	// solarImpairmentMaxFactor caps the scaling right next to the Sun.
+	solarImpairmentMaxFactor = 10.0
+	// defaultDegradedLossPct is the default -udp-degraded-loss-pct.
+	defaultDegradedLossPct = 10.0
+)
+
+// UDP impairment effects, used as the "effect" metric label.
+const (
+	udpImpairDrop      = "dropped"
+	udpImpairReorder   = "reordered"
+	udpImpairDuplicate = "duplicated"
+)
+
+// bodyPercent is a percentage with per-body overrides.
+type bodyPercent struct {
+	Default float64
+	PerBody map[string]float64 // keyed by canonical body name
+}
+
+// forBody returns body's percentage.
+func (p bodyPercent) forBody(body string) float64 {
+	if v, ok := p.PerBody[body]; ok {
+		return v
+	}
+	return p.Default
+}
+
+// parseBodyPercent parses "pct[,body=pct...]"; the bare entry is the default.
+func parseBodyPercent(spec string, objects []celestial.CelestialObject) (bodyPercent, error) {
+	var p bodyPercent
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, hasBody := strings.Cut(entry, "=")
+		if !hasBody {
+			value = name
+		}
+		pct, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || pct < 0 || pct > 100 {
+			return bodyPercent{}, fmt.Errorf("%q: want a percentage from 0 to 100", entry)
+		}
+		if !hasBody {
+			p.Default = pct
+			continue
+		}
+		obj, found := findObjectByName(objects, strings.TrimSpace(name))
+		if !found {
+			return bodyPercent{}, fmt.Errorf("%q: unknown body %q", entry, name)
+		}
+		if p.PerBody == nil {
+			p.PerBody = make(map[string]float64)
+		}
+		p.PerBody[obj.Name] = pct
+	}
+	return p, nil
+}
+
+// UDPImpairment configures the relay's loss, reorder and duplicate rates.
+// The zero value is a perfect link.
+type UDPImpairment struct {
+	Loss, Reorder, Dup bodyPercent
+	DegradedLoss       float64 // extra loss percentage on a degraded link
+}
+
+// udpRates are the effective probabilities (0-1) for one association.
+type udpRates struct {
+	loss, reorder, dup float64
+}
+
+// ratesFor returns body's probabilities at the given solar elongation
+// (degrees); a negative elongation means unknown and applies no scaling.
+func (c UDPImpairment) ratesFor(body string, elongationDeg float64) udpRates {
+	f := solarImpairmentFactor(elongationDeg)
+	return udpRates{
+		loss:    min(c.Loss.forBody(body)*f/100, 1),
+		reorder: min(c.Reorder.forBody(body)*f/100, 1),
+		dup:     min(c.Dup.forBody(body)*f/100, 1),
+	}
+}
+
+// solarImpairmentFactor scales the rates near the Sun: 1 outside
+// solarImpairmentElongationDeg, inversely with the elongation inside it.
+func solarImpairmentFactor(elongationDeg float64) float64 {
+	if elongationDeg < 0 || elongationDeg >= solarImpairmentElongationDeg {
+		return 1
+	}
+	return min(solarImpairmentElongationDeg/max(elongationDeg, 1e-9), solarImpairmentMaxFactor)
+}
+
+// withLoss adds an independent pct percent chance of losing each packet.
+func (r udpRates) withLoss(pct float64) udpRates {
+	r.loss = 1 - (1-r.loss)*(1-min(max(pct, 0), 100)/100)
+	return r
+}
+
+func (r udpRates) enabled() bool { return r.loss > 0 || r.reorder > 0 || r.dup > 0 }
+
+// udpImpairer applies the rates to one direction of an association.
+type udpImpairer struct {
+	rates   udpRates
+	quantum time.Duration // the relay's per-packet latency
+	write   func(pkt []byte, to net.Addr)
+	record  func(effect string)
+
+	mu      sync.Mutex
+	held    *heldPacket
+	heldSeq uint64 // identifies the held packet to its flush timer
+}
+
+type heldPacket struct {
+	pkt   []byte
+	to    net.Addr
+	seq   uint64
+	timer *time.Timer
+}
+
+func newUDPImpairer(rates udpRates, quantum time.Duration, write func([]byte, net.Addr), record func(effect string)) *udpImpairer {
+	return &udpImpairer{rates: rates, quantum: quantum, write: write, record: record}
+}
+
+// send passes pkt, bound for to, through the impairment model.
+func (d *udpImpairer) send(pkt []byte, to net.Addr) {
+	if !d.rates.enabled() {
+		d.write(pkt, to)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if simFloat64() < d.rates.loss {
+		d.record(udpImpairDrop)
+		return
+	}
+	if d.held == nil && simFloat64() < d.rates.reorder {
+		d.record(udpImpairReorder)
+		d.heldSeq++
+		seq := d.heldSeq
+		d.held = &heldPacket{pkt: pkt, to: to, seq: seq}
+		// Successors go out about a quantum apart; allow two before giving up.
+		d.held.timer = time.AfterFunc(2*d.quantum, func() { d.flush(seq) })
+		return
+	}
+	d.write(pkt, to)
+	if simFloat64() < d.rates.dup {
+		d.record(udpImpairDuplicate)
+		d.write(pkt, to)
+	}
+	// The successor has gone out; the held packet follows it.
+	if h := d.held; h != nil {
+		h.timer.Stop()
+		d.held = nil
+		d.write(h.pkt, h.to)
+	}
+}
+
+// flush sends the held packet seq if no successor has released it.
+func (d *udpImpairer) flush(seq uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if h := d.held; h != nil && h.seq == seq {
+		d.held = nil
+		d.write(h.pkt, h.to)
+	}
+}
+
+// stop discards any held packet when the association ends.
+func (d *udpImpairer) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.held != nil {
+		d.held.timer.Stop()
+		d.held = nil
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// impairTrain sends packets 0..n-1 through an impairer and returns the order
+// they were written in. The quantum is long enough that nothing is flushed
+// by its timer.
+func impairTrain(rates udpRates, n int) []int {
+	var got []int
+	d := newUDPImpairer(rates, time.Hour, func(p []byte, _ net.Addr) { got = append(got, int(p[0])) }, func(string) {})
+	for i := 0; i < n; i++ {
+		d.send([]byte{byte(i)}, nil)
+	}
+	d.stop()
+	return got
+}
+
+func TestUDPImpairerPattern(t *testing.T) {
+	t.Cleanup(func() { seedSimRand(0) })
+
+	seedSimRand(42)
+	got := impairTrain(udpRates{loss: 0.1, reorder: 0.1, dup: 0.1}, 30)
+	// 2 and 27 dropped; 0 held behind 1; 9, 12, 15, 20 and 22 duplicated.
+	want := []int{1, 0, 3, 4, 5, 6, 7, 8, 9, 9, 10, 11, 12, 12, 13, 14, 15, 15, 16, 17, 18, 19, 20, 20, 21, 22, 22, 23, 24, 25, 26, 28, 29}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("seed 42 gave\n%v\nwant\n%v", got, want)
+	}
+
+	// Same seed, same pattern.
+	seedSimRand(42)
+	if again := impairTrain(udpRates{loss: 0.1, reorder: 0.1, dup: 0.1}, 30); !reflect.DeepEqual(again, got) {
+		t.Errorf("seed 42 not reproducible:\n%v\n%v", again, got)
+	}
+
+	// A perfect link passes everything through in order, without drawing.
+	if got := impairTrain(udpRates{}, 5); !reflect.DeepEqual(got, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("zero rates gave %v", got)
+	}
+}
+
+// TestUDPImpairerFlush checks a held packet with no successor still goes out.
+func TestUDPImpairerFlush(t *testing.T) {
+	sent := make(chan []byte, 1)
+	d := newUDPImpairer(udpRates{reorder: 1}, 10*time.Millisecond, func(p []byte, _ net.Addr) { sent <- p }, func(string) {})
+	d.send([]byte("late"), nil)
+	select {
+	case p := <-sent:
+		if string(p) != "late" {
+			t.Errorf("flushed %q", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("held packet never flushed")
+	}
+}
+
+func TestParseBodyPercent(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+
+	p, err := parseBodyPercent("1, mars=2.5,voyager-1=10", objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Default != 1 || p.forBody("Mars") != 2.5 || p.forBody("Voyager 1") != 10 || p.forBody("Jupiter") != 1 {
+		t.Errorf("parsed %+v", p)
+	}
+	if p, err := parseBodyPercent("0", objects); err != nil || p.Default != 0 || p.PerBody != nil {
+		t.Errorf("\"0\" parsed as %+v, %v", p, err)
+	}
+	for _, bad := range []string{"-1", "101", "lots", "mars=x", "vulcan=5"} {
+		if _, err := parseBodyPercent(bad, objects); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestUDPImpairmentSolarScaling(t *testing.T) {
+	c := UDPImpairment{Loss: bodyPercent{Default: 2}, Dup: bodyPercent{Default: 50}}
+	for _, tc := range []struct {
+		elongation, loss, dup float64
+	}{
+		{-1, 0.02, 0.5}, // unknown
+		{90, 0.02, 0.5}, // well clear of the Sun
+		{20, 0.02, 0.5}, // at the threshold
+		{10, 0.04, 1},   // twice as bad; dup capped at certainty
+		{0.5, 0.2, 1},   // capped at solarImpairmentMaxFactor
+	} {
+		r := c.ratesFor("Mars", tc.elongation)
+		if math.Abs(r.loss-tc.loss) > 1e-12 || math.Abs(r.dup-tc.dup) > 1e-12 || r.reorder != 0 {
+			t.Errorf("elongation %v: got %+v, want loss %v dup %v", tc.elongation, r, tc.loss, tc.dup)
+		}
+	}
+}
+
+// startUDPSink starts a UDP server that records the first byte of each
+// packet, and allowlists its port.
+func startUDPSink(t *testing.T, security *SecurityValidator) (*net.UDPAddr, func() []int) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	var mu sync.Mutex
+	var seen []int
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n > 0 {
+				mu.Lock()
+				seen = append(seen, int(buf[0]))
+				mu.Unlock()
+			}
+		}
+	}()
+	addr := pc.LocalAddr().(*net.UDPAddr)
+	security.allowedPorts[strconv.Itoa(addr.Port)] = true
+	return addr, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), seen...)
+	}
+}
+
+func TestUDPRelayImpairment(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	fakeLinkClock(t, time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	t.Cleanup(func() { seedSimRand(0) })
+	seedSimRand(7)
+
+	security, metrics := newTestSecurity(), NewRecordingMetrics()
+	sink, seen := startUDPSink(t, security)
+	code, relay := udpAssociateWith(t, security, metrics, func(h *SOCKSHandler) {
+		h.timing = fixedLatency(20 * time.Millisecond)
+		h.udpImpair = UDPImpairment{
+			Loss:    bodyPercent{Default: 10},
+			Reorder: bodyPercent{Default: 10},
+			Dup:     bodyPercent{Default: 10},
+		}
+	})
+	if code != SOCKS5_REP_SUCCESS {
+		t.Fatalf("UDP ASSOCIATE failed: 0x%02x", code)
+	}
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 30; i++ {
+		client.WriteTo(buildUDPSocksPacket(sink, []byte{byte(i)}), relay)
+	}
+	time.Sleep(30*20*time.Millisecond + 300*time.Millisecond)
+	// 15 and 29 dropped; 20 and 22 held behind their successors; 2, 7 and
+	// 26 duplicated.
+	want := []int{0, 1, 2, 2, 3, 4, 5, 6, 7, 7, 8, 9, 10, 11, 12, 13, 14, 16, 17, 18, 19, 21, 20, 23, 22, 24, 25, 26, 26, 27, 28}
+	if got := seen(); !reflect.DeepEqual(got, want) {
+		t.Errorf("seed 7 gave\n%v\nwant\n%v", got, want)
+	}
+	for effect, n := range map[string]float64{udpImpairDrop: 2, udpImpairReorder: 2, udpImpairDuplicate: 3} {
+		if got := metrics.Count(metricUDPImpaired, "Mars", effect); got != n {
+			t.Errorf("%s: counted %v, want %v", effect, got, n)
+		}
+	}
+}
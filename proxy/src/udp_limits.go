@@ -0,0 +1,125 @@
+// udp_limits.go - per-association abuse caps for the SOCKS UDP relay.
+//
+// UDP ASSOCIATE is the easiest reflection vector through the proxy: one
+// control connection can emit an unbounded packet stream to many targets.
+// Each association therefore gets its own packets-per-second and
+// bytes-per-second token buckets plus a cap on distinct destinations (a
+// client spraying new host:port pairs is scanning, not talking to a peer).
+// Packets beyond a cap are dropped and counted in udp_dropped_packets_total;
+// compliant traffic is unaffected.
+//
+// Packets in flight wait in a relay.Scheduler; -udp-max-queued caps how
+// many one association may have waiting, both ways together, and a full
+// queue drops its oldest packet (reason "queue").
+//
+// There is no per-body bandwidth model yet, so the defaults are fixed
+// (200 pps, 1 Mbps, 32 targets, 4096 queued). -udp-max-pps,
+// -udp-max-bytes-per-sec, -udp-max-targets and -udp-max-queued override
+// them; zero disables a cap.
+package main
+
+import (
+	"time"
+)
+
+// minProxyLatency is the anti-DDoS floor: bodies closer than this one-way
+// latency are refused on every proxy path, so the proxy can't be used as a
+// fast relay.
+const minProxyLatency = 1 * time.Second
+
+// UDPLimits caps a single UDP association. Zero or negative disables a cap.
+type UDPLimits struct {
+	PacketsPerSec float64 // client->target packets per second
+	BytesPerSec   float64 // client->target payload bytes per second
+	MaxTargets    int     // distinct destination host:port pairs
+	MaxQueued     int     // packets in flight, both directions
+}
+
+// defaultUDPLimits applies when no flags are given: 1 Mbps, 200 pps, 32
+// targets, 4096 packets in flight.
+var defaultUDPLimits = UDPLimits{PacketsPerSec: 200, BytesPerSec: 125000, MaxTargets: 32, MaxQueued: 4096}
+
+// UDP drop reasons, used as the "reason" metric label.
+const (
+	udpDropPPS     = "pps"
+	udpDropBPS     = "bps"
+	udpDropTargets = "targets"
+	udpDropQueue   = "queue" // oldest packet in flight, dropped for a new one
+)
+
+// tokenBucket is a simple refilling bucket holding up to one second of rate.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: rate, last: now}
+}
+
+// take removes n tokens if available. A nil bucket always admits.
+func (b *tokenBucket) take(n float64, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+		b.last = now
+	}
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// refund gives back n tokens taken for a packet that was then dropped.
+func (b *tokenBucket) refund(n float64) {
+	if b == nil {
+		return
+	}
+	b.tokens = min(b.tokens+n, b.rate)
+}
+
+// udpAssocLimiter enforces UDPLimits for one association. It is used only
+// from the relay's single processing loop, so it needs no locking.
+type udpAssocLimiter struct {
+	limits  UDPLimits
+	packets *tokenBucket
+	bytes   *tokenBucket
+	targets map[string]bool
+}
+
+func newUDPAssocLimiter(limits UDPLimits, now time.Time) *udpAssocLimiter {
+	return &udpAssocLimiter{
+		limits:  limits,
+		packets: newTokenBucket(limits.PacketsPerSec, now),
+		bytes:   newTokenBucket(limits.BytesPerSec, now),
+		targets: make(map[string]bool),
+	}
+}
+
+// allow reports whether a client packet of size bytes to target may be
+// relayed, and if not, why. Dropped packets do not consume packet or byte
+// tokens, or register a new target.
+func (l *udpAssocLimiter) allow(target string, size int, now time.Time) (bool, string) {
+	if !l.targets[target] && l.limits.MaxTargets > 0 && len(l.targets) >= l.limits.MaxTargets {
+		return false, udpDropTargets
+	}
+	if !l.packets.take(1, now) {
+		return false, udpDropPPS
+	}
+	if !l.bytes.take(float64(size), now) {
+		l.packets.refund(1)
+		return false, udpDropBPS
+	}
+	l.targets[target] = true
+	return true, ""
+}
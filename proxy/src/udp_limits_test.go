@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestUDPAssocLimiterCaps(t *testing.T) {
+	now := time.Now()
+
+	t.Run("packets per second", func(t *testing.T) {
+		l := newUDPAssocLimiter(UDPLimits{PacketsPerSec: 10}, now)
+		allowed := 0
+		for i := 0; i < 15; i++ {
+			if ok, reason := l.allow("a:1", 100, now); ok {
+				allowed++
+			} else if reason != udpDropPPS {
+				t.Fatalf("unexpected drop reason %q", reason)
+			}
+		}
+		if allowed != 10 {
+			t.Errorf("expected a burst of 10 packets, got %d", allowed)
+		}
+		if ok, _ := l.allow("a:1", 100, now.Add(time.Second)); !ok {
+			t.Error("bucket should refill after a second")
+		}
+	})
+
+	t.Run("bytes per second", func(t *testing.T) {
+		l := newUDPAssocLimiter(UDPLimits{BytesPerSec: 1000}, now)
+		if ok, _ := l.allow("a:1", 800, now); !ok {
+			t.Fatal("first packet is within the byte budget")
+		}
+		if ok, reason := l.allow("a:1", 300, now); ok || reason != udpDropBPS {
+			t.Errorf("expected a bps drop, got ok=%v reason=%q", ok, reason)
+		}
+		if ok, _ := l.allow("a:1", 200, now); !ok {
+			t.Error("a dropped packet must not consume the byte budget")
+		}
+	})
+
+	t.Run("bytes drops keep packet tokens", func(t *testing.T) {
+		l := newUDPAssocLimiter(UDPLimits{PacketsPerSec: 2, BytesPerSec: 1000}, now)
+		if ok, _ := l.allow("a:1", 800, now); !ok {
+			t.Fatal("first packet is within both budgets")
+		}
+		for i := 0; i < 5; i++ {
+			if ok, reason := l.allow("a:1", 300, now); ok || reason != udpDropBPS {
+				t.Fatalf("expected a bps drop, got ok=%v reason=%q", ok, reason)
+			}
+		}
+		if ok, reason := l.allow("a:1", 200, now); !ok {
+			t.Errorf("a packet within both budgets was dropped for %q", reason)
+		}
+	})
+
+	t.Run("distinct targets", func(t *testing.T) {
+		l := newUDPAssocLimiter(UDPLimits{MaxTargets: 2}, now)
+		for _, target := range []string{"a:1", "b:1", "a:1"} {
+			if ok, _ := l.allow(target, 10, now); !ok {
+				t.Fatalf("%s should be allowed", target)
+			}
+		}
+		if ok, reason := l.allow("c:1", 10, now); ok || reason != udpDropTargets {
+			t.Errorf("third distinct target: expected a targets drop, got ok=%v reason=%q", ok, reason)
+		}
+		if ok, _ := l.allow("b:1", 10, now); !ok {
+			t.Error("known targets keep working after the cap is hit")
+		}
+	})
+}
+
+// udpAssociate runs the SOCKS handshake and UDP ASSOCIATE against a handler
+// for body Mars with the given limits. It returns the reply code and, on
+// success, the relay's UDP address.
+func udpAssociate(t *testing.T, security *SecurityValidator, metrics Metrics, limits UDPLimits) (byte, *net.UDPAddr) {
+	t.Helper()
+	return udpAssociateWith(t, security, metrics, func(h *SOCKSHandler) { h.udpLimits = limits })
+}
+
+// udpAssociateWith is udpAssociate with the handler set up by configure.
+func udpAssociateWith(t *testing.T, security *SecurityValidator, metrics Metrics, configure func(*SOCKSHandler)) (byte, *net.UDPAddr) {
+	t.Helper()
+	return udpAssociateFrom(t, security, metrics, configure, &net.UDPAddr{IP: net.IPv4zero})
+}
+
+// udpAssociateFrom is udpAssociateWith with hint as the request's
+// DST.ADDR/DST.PORT, the address the client says it will send from.
+func udpAssociateFrom(t *testing.T, security *SecurityValidator, metrics Metrics, configure func(*SOCKSHandler), hint *net.UDPAddr) (byte, *net.UDPAddr) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			return
+		}
+		h := NewSOCKSHandler(conn, security, metrics, "Mars", nil)
+		h.timing = fixedLatency(time.Millisecond)
+		configure(h)
+		h.Handle()
+	}()
+
+	ctrl, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctrl.Close()
+		<-done
+	})
+
+	ctrl.Write([]byte{SOCKS5_VERSION, 1, SOCKS5_NO_AUTH})
+	if _, err := io.ReadFull(ctrl, make([]byte, 2)); err != nil {
+		t.Fatalf("read auth choice: %v", err)
+	}
+	req := append([]byte{SOCKS5_VERSION, SOCKS5_CMD_UDP_ASSOCIATE, 0x00, SOCKS5_ADDR_IPV4}, hint.IP.To4()...)
+	ctrl.Write(binary.BigEndian.AppendUint16(req, uint16(hint.Port)))
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(ctrl, reply); err != nil {
+		t.Fatalf("read UDP ASSOCIATE reply: %v", err)
+	}
+	return reply[1], &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(binary.BigEndian.Uint16(reply[8:10]))}
+}
+
+// startUDPEcho starts a UDP echo server and allowlists its port.
+func startUDPEcho(t *testing.T, security *SecurityValidator) *net.UDPAddr {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	addr := pc.LocalAddr().(*net.UDPAddr)
+	security.allowedPorts[strconv.Itoa(addr.Port)] = true
+	return addr
+}
+
+// countEchoes reads relayed replies until the socket has been quiet for idle.
+func countEchoes(client net.PacketConn, idle time.Duration) int {
+	buf := make([]byte, 2048)
+	n := 0
+	for {
+		client.SetReadDeadline(time.Now().Add(idle))
+		if _, _, err := client.ReadFrom(buf); err != nil {
+			return n
+		}
+		n++
+	}
+}
+
+func TestUDPAssociationCaps(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	t.Run("distinct targets", func(t *testing.T) {
+		security, metrics := newTestSecurity(), NewRecordingMetrics()
+		targets := []*net.UDPAddr{startUDPEcho(t, security), startUDPEcho(t, security), startUDPEcho(t, security)}
+		code, relay := udpAssociate(t, security, metrics, UDPLimits{MaxTargets: 2})
+		if code != SOCKS5_REP_SUCCESS {
+			t.Fatalf("UDP ASSOCIATE failed: 0x%02x", code)
+		}
+		client, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		for _, target := range targets {
+			client.WriteTo(buildUDPSocksPacket(target, []byte("hello")), relay)
+		}
+		if got := countEchoes(client, 300*time.Millisecond); got != 2 {
+			t.Errorf("expected replies from the first 2 targets only, got %d", got)
+		}
+		if v := metrics.Count(metricUDPDropped, "Mars", udpDropTargets); v != 1 {
+			t.Errorf("expected 1 targets drop, got %v", v)
+		}
+
+		// Compliant traffic to a known target still flows.
+		client.WriteTo(buildUDPSocksPacket(targets[0], []byte("again")), relay)
+		if got := countEchoes(client, 300*time.Millisecond); got != 1 {
+			t.Errorf("known target should still be relayed, got %d replies", got)
+		}
+	})
+
+	t.Run("packets per second", func(t *testing.T) {
+		security, metrics := newTestSecurity(), NewRecordingMetrics()
+		target := startUDPEcho(t, security)
+		code, relay := udpAssociate(t, security, metrics, UDPLimits{PacketsPerSec: 5})
+		if code != SOCKS5_REP_SUCCESS {
+			t.Fatalf("UDP ASSOCIATE failed: 0x%02x", code)
+		}
+		client, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		for i := 0; i < 20; i++ {
+			client.WriteTo(buildUDPSocksPacket(target, []byte("flood")), relay)
+		}
+		got := countEchoes(client, 300*time.Millisecond)
+		if got < 1 || got > 6 {
+			t.Errorf("expected roughly the 5-packet burst to be relayed, got %d", got)
+		}
+		if v := metrics.Count(metricUDPDropped, "Mars", udpDropPPS); v < 10 {
+			t.Errorf("expected the flood to be dropped with a metric, got %v drops", v)
+		}
+	})
+}
+
+func TestUDPAssociateRejectsLowLatencyBody(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	metrics := NewRecordingMetrics()
+	security := newTestSecurity()
+	security.minLatency = time.Second
+	code, _ := udpAssociate(t, security, metrics, defaultUDPLimits)
+	if code != SOCKS5_REP_GENERAL_FAILURE {
+		t.Fatalf("expected GENERAL_FAILURE below the latency floor, got 0x%02x", code)
+	}
+	if v := metrics.Count(metricSOCKSFailures, "general_failure"); v != 1 {
+		t.Errorf("expected the rejection to be counted, got %v", v)
+	}
+}
+
+// TestUDPRelayBurstOverlaps sends a burst through a 200ms link: each echo
+// comes back one round trip after its own packet went out, not after the
+// whole burst has queued behind one latency after another.
+func TestUDPRelayBurstOverlaps(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	const latency = 200 * time.Millisecond
+	const n = 10
+	security, metrics := newTestSecurity(), NewRecordingMetrics()
+	target := startUDPEcho(t, security)
+	code, relay := udpAssociateWith(t, security, metrics, func(h *SOCKSHandler) {
+		h.timing = fixedLatency(latency)
+		h.udpLimits = defaultUDPLimits
+	})
+	if code != SOCKS5_REP_SUCCESS {
+		t.Fatalf("UDP ASSOCIATE failed: 0x%02x", code)
+	}
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		client.WriteTo(buildUDPSocksPacket(target, []byte{byte(i)}), relay)
+	}
+	buf := make([]byte, 2048)
+	client.SetReadDeadline(start.Add(n * latency))
+	for i := 0; i < n; i++ {
+		if _, _, err := client.ReadFrom(buf); err != nil {
+			t.Fatalf("%d of %d echoes: %v", i, n, err)
+		}
+		if elapsed := time.Since(start); elapsed < 2*latency || elapsed > 3*latency {
+			t.Errorf("echo %d after %v, want about %v", i, elapsed, 2*latency)
+		}
+	}
+	if v := metrics.Count(metricUDPPackets, "Mars"); v != n {
+		t.Errorf("counted %v packets, want %d", v, n)
+	}
+}
+
+// TestUDPRelayQueueCap checks packets beyond -udp-max-queued drop the
+// oldest in flight, with a metric.
+func TestUDPRelayQueueCap(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	security, metrics := newTestSecurity(), NewRecordingMetrics()
+	target := startUDPEcho(t, security)
+	code, relay := udpAssociateWith(t, security, metrics, func(h *SOCKSHandler) {
+		h.timing = fixedLatency(100 * time.Millisecond)
+		h.udpLimits = UDPLimits{MaxQueued: 3}
+	})
+	if code != SOCKS5_REP_SUCCESS {
+		t.Fatalf("UDP ASSOCIATE failed: 0x%02x", code)
+	}
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 5; i++ {
+		client.WriteTo(buildUDPSocksPacket(target, []byte{byte(i)}), relay)
+	}
+	if got := countEchoes(client, 400*time.Millisecond); got != 3 {
+		t.Errorf("got %d echoes, want the 3 the queue holds", got)
+	}
+	if v := metrics.Count(metricUDPDropped, "Mars", udpDropQueue); v != 2 {
+		t.Errorf("expected 2 queue drops, got %v", v)
+	}
+}
@@ -0,0 +1,122 @@
+// udp_peers.go - which packets a UDP association accepts.
+//
+// A UDP relay socket takes packets from anyone who learns its port. The
+// DST.ADDR and DST.PORT of the UDP ASSOCIATE request are the address the
+// client will send from (RFC 1928 section 6), so when the client gives them
+// only packets from that address are taken as the client's. A zero address
+// (0.0.0.0 or ::) stands for the control connection's source IP, and a zero
+// port for whichever port the first packet from that IP comes from - the
+// behaviour before hints were honoured. A domain name can't be a source
+// address and is treated as all zeros. The hint can't point the relay at
+// another host: an IP other than the control connection's is treated as all
+// zeros too, or a client could aim replies at a victim it names.
+//
+// Every other packet is a reply to the client, and is forwarded only from a
+// host:port the client sent to within udpReplyWindow plus the one-way
+// latency. Anything else is dropped and counted as "unsolicited", so the
+// relay can't be used to reflect traffic at the client, and a second
+// process behind the client's NAT can't take the association over.
+package main
+
+import (
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	// udpReplyWindow is how long after the client last sent to a target
+	// (plus the one-way latency) replies from it are forwarded; about a
+	// NAT's UDP mapping timeout.
+	udpReplyWindow = 2 * time.Minute
+	// udpReplyMaxTargets bounds the targets remembered per association
+	// when -udp-max-targets is off.
+	udpReplyMaxTargets = 1024
+
+	udpDropUnsolicited = "unsolicited" // reply from a target the client never sent to
+)
+
+// udpClientFilter recognises the client's packets.
+type udpClientFilter struct {
+	ip   net.IP
+	port int // 0 until the first packet fixes it when there was no port hint
+}
+
+// newUDPClientFilter expects packets from hint, with its zero parts filled
+// from the control connection's remote address. A hint naming another IP is
+// ignored, port and all.
+func newUDPClientFilter(hint *net.UDPAddr, control net.Addr) *udpClientFilter {
+	f := &udpClientFilter{}
+	if host, _, err := net.SplitHostPort(control.String()); err == nil {
+		f.ip = net.ParseIP(host)
+	}
+	if hint == nil {
+		return f
+	}
+	if hint.IP == nil || hint.IP.IsUnspecified() || hint.IP.Equal(f.ip) {
+		f.port = hint.Port
+	}
+	return f
+}
+
+// matches reports whether from is the client, fixing the port on the first
+// match when it wasn't hinted.
+func (f *udpClientFilter) matches(from net.Addr) bool {
+	addr, ok := from.(*net.UDPAddr)
+	if !ok || !addr.IP.Equal(f.ip) {
+		return false
+	}
+	if f.port == 0 {
+		f.port = addr.Port
+	}
+	return addr.Port == f.port
+}
+
+// addr is where replies to the client go, nil until its port is known.
+func (f *udpClientFilter) addr() *net.UDPAddr {
+	if f.port == 0 {
+		return nil
+	}
+	return &net.UDPAddr{IP: f.ip, Port: f.port}
+}
+
+// udpReplyFilter remembers the targets a client sent to. Like
+// udpAssocLimiter it is used only from the relay's processing loop.
+type udpReplyFilter struct {
+	window time.Duration
+	until  map[netip.AddrPort]time.Time
+}
+
+// newUDPReplyFilter accepts replies for window after each send.
+func newUDPReplyFilter(window time.Duration) *udpReplyFilter {
+	return &udpReplyFilter{window: window, until: make(map[netip.AddrPort]time.Time)}
+}
+
+// sent notes a packet from the client to target at now.
+func (f *udpReplyFilter) sent(target *net.UDPAddr, now time.Time) {
+	key := udpPeerKey(target)
+	if _, known := f.until[key]; !known && len(f.until) >= udpReplyMaxTargets {
+		for k, until := range f.until {
+			if now.After(until) {
+				delete(f.until, k)
+			}
+		}
+		if len(f.until) >= udpReplyMaxTargets {
+			return
+		}
+	}
+	f.until[key] = now.Add(f.window)
+}
+
+// expected reports whether a packet from source at now answers something
+// the client sent.
+func (f *udpReplyFilter) expected(source *net.UDPAddr, now time.Time) bool {
+	until, ok := f.until[udpPeerKey(source)]
+	return ok && !now.After(until)
+}
+
+// udpPeerKey compares IPv4 and IPv4-mapped IPv6 addresses as equal.
+func udpPeerKey(a *net.UDPAddr) netip.AddrPort {
+	ap := a.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func listenUDPClient(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+func TestUDPAssociateSourceHint(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	noop := func(*SOCKSHandler) {}
+
+	t.Run("hinted", func(t *testing.T) {
+		security, metrics := newTestSecurity(), NewRecordingMetrics()
+		target := startUDPEcho(t, security)
+		client, other := listenUDPClient(t), listenUDPClient(t)
+		code, relay := udpAssociateFrom(t, security, metrics, noop, client.LocalAddr().(*net.UDPAddr))
+		if code != SOCKS5_REP_SUCCESS {
+			t.Fatalf("UDP ASSOCIATE failed: 0x%02x", code)
+		}
+
+		// Another socket on the client's IP gets there first; it is not
+		// taken for the client.
+		other.WriteTo(buildUDPSocksPacket(target, []byte("first")), relay)
+		if got := countEchoes(other, 300*time.Millisecond); got != 0 {
+			t.Errorf("non-hinted source got %d replies", got)
+		}
+		if v := metrics.Count(metricUDPDropped, "Mars", udpDropUnsolicited); v != 1 {
+			t.Errorf("unsolicited drops = %v, want 1", v)
+		}
+
+		client.WriteTo(buildUDPSocksPacket(target, []byte("hello")), relay)
+		if got := countEchoes(client, 300*time.Millisecond); got != 1 {
+			t.Errorf("hinted client got %d replies, want 1", got)
+		}
+	})
+
+	t.Run("unhinted", func(t *testing.T) {
+		security, metrics := newTestSecurity(), NewRecordingMetrics()
+		target := startUDPEcho(t, security)
+		client := listenUDPClient(t)
+		code, relay := udpAssociateWith(t, security, metrics, noop)
+		if code != SOCKS5_REP_SUCCESS {
+			t.Fatalf("UDP ASSOCIATE failed: 0x%02x", code)
+		}
+		client.WriteTo(buildUDPSocksPacket(target, []byte("hello")), relay)
+		if got := countEchoes(client, 300*time.Millisecond); got != 1 {
+			t.Errorf("client got %d replies, want 1", got)
+		}
+	})
+
+	t.Run("hijack attempt", func(t *testing.T) {
+		security, metrics := newTestSecurity(), NewRecordingMetrics()
+		target := startUDPEcho(t, security)
+		client, intruder := listenUDPClient(t), listenUDPClient(t)
+		code, relay := udpAssociateWith(t, security, metrics, noop)
+		if code != SOCKS5_REP_SUCCESS {
+			t.Fatalf("UDP ASSOCIATE failed: 0x%02x", code)
+		}
+		client.WriteTo(buildUDPSocksPacket(target, []byte("hello")), relay)
+		if got := countEchoes(client, 300*time.Millisecond); got != 1 {
+			t.Fatalf("client got %d replies, want 1", got)
+		}
+
+		// Same IP, different port, after the client is known: neither
+		// relayed to a target nor reflected at the client.
+		intruder.WriteTo(buildUDPSocksPacket(target, []byte("mine now")), relay)
+		intruder.WriteTo([]byte("spoofed reply"), relay)
+		if got := countEchoes(client, 300*time.Millisecond); got != 0 {
+			t.Errorf("client got %d packets from the intruder", got)
+		}
+		if got := countEchoes(intruder, 100*time.Millisecond); got != 0 {
+			t.Errorf("intruder got %d replies", got)
+		}
+		if v := metrics.Count(metricUDPDropped, "Mars", udpDropUnsolicited); v != 2 {
+			t.Errorf("unsolicited drops = %v, want 2", v)
+		}
+
+		// The client's association still works.
+		client.WriteTo(buildUDPSocksPacket(target, []byte("still mine")), relay)
+		if got := countEchoes(client, 300*time.Millisecond); got != 1 {
+			t.Errorf("client got %d replies after the attempt, want 1", got)
+		}
+	})
+}
+
+func TestUDPClientFilter(t *testing.T) {
+	control := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+	from := func(ip string, port int) *net.UDPAddr { return &net.UDPAddr{IP: net.ParseIP(ip), Port: port} }
+
+	// A zero hint latches the first port from the control connection's IP.
+	f := newUDPClientFilter(&net.UDPAddr{IP: net.IPv4zero}, control)
+	if f.addr() != nil || f.matches(from("192.0.2.2", 5000)) {
+		t.Fatal("zero hint: matched another IP or knew a port too early")
+	}
+	if !f.matches(from("192.0.2.1", 5000)) || f.matches(from("192.0.2.1", 5001)) {
+		t.Error("zero hint: didn't latch the first port")
+	}
+
+	// A port alone takes the IP from the control connection.
+	f = newUDPClientFilter(&net.UDPAddr{IP: net.IPv4zero, Port: 6000}, control)
+	if f.matches(from("192.0.2.1", 5000)) || !f.matches(from("192.0.2.1", 6000)) {
+		t.Error("port hint: matched the wrong port")
+	}
+
+	// A full hint is matched exactly, IPv4-mapped or not.
+	f = newUDPClientFilter(from("::ffff:192.0.2.1", 7000), control)
+	if f.matches(from("192.0.2.1", 7001)) || !f.matches(from("192.0.2.1", 7000)) {
+		t.Error("full hint: not matched exactly")
+	}
+
+	// A hint naming another host is ignored: packets must come from the
+	// control connection's IP, on the first port it sends from.
+	f = newUDPClientFilter(from("198.51.100.7", 7000), control)
+	if f.matches(from("198.51.100.7", 7000)) {
+		t.Error("foreign hint: matched the hinted host")
+	}
+	if f.addr() != nil || !f.matches(from("192.0.2.1", 5000)) || f.matches(from("192.0.2.1", 7000)) {
+		t.Error("foreign hint: didn't latch the control IP's first port")
+	}
+	if a := f.addr(); a == nil || !a.IP.Equal(control.IP) || a.Port != 5000 {
+		t.Errorf("foreign hint: replies go to %v, want 192.0.2.1:5000", a)
+	}
+}
+
+func TestUDPReplyFilterExpires(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	target := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 5), Port: 53}
+	f := newUDPReplyFilter(time.Minute)
+	if f.expected(target, now) {
+		t.Fatal("reply expected before anything was sent")
+	}
+	f.sent(target, now)
+	mapped := &net.UDPAddr{IP: net.ParseIP("::ffff:203.0.113.5"), Port: 53}
+	if !f.expected(mapped, now.Add(time.Minute)) {
+		t.Error("reply within the window refused")
+	}
+	if f.expected(&net.UDPAddr{IP: target.IP, Port: 54}, now) {
+		t.Error("reply from another port accepted")
+	}
+	if f.expected(target, now.Add(time.Minute+time.Second)) {
+		t.Error("reply after the window accepted")
+	}
+}
@@ -0,0 +1,160 @@
+// upstream.go - the shared HTTP client used for real upstream fetches.
+//
+// A single overall client timeout conflates two very different failures: a
+// dead upstream (which should fail fast) and a slow-but-working one (which
+// should be allowed to finish). The transport therefore has separate phases:
+//
+//   - connect: TCP dial and TLS handshake, independent of simulated latency
+//     (-upstream-connect-timeout, default 15s)
+//   - headers: time from sending the request to the response headers
+//     (-upstream-header-timeout, default 60s)
+//   - body: a transfer deadline set once the headers arrive, from the
+//     Content-Length (or the size cap when unknown) at a minimum rate
+//
+// The transport is shared, so keep-alive connections (and HTTP/2 where the
+// upstream offers it) are reused across fetches to the same site; the TCP and
+// TLS setup is not part of the simulation model and shouldn't be paid per
+// request. Reuse is counted in proxy_upstream_connections_{created,reused}_total.
+//
+// Failures are classified (refused, timeout, dns, ...) so callers can tell a
+// down target apart from the simulation being slow.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// upstreamErrorCode is the structured error code reported for failed fetches.
+const upstreamErrorCode = "UPSTREAM_ERROR"
+
+const (
+	upstreamBodyBase    = 30 * time.Second // fixed allowance on every body transfer
+	upstreamMinBodyRate = 16 << 10         // bytes/second a transfer must sustain
+	upstreamIdlePerHost = 8                // pooled keep-alive connections per upstream host
+)
+
+// UpstreamTimeouts configures the shared upstream transport.
+type UpstreamTimeouts struct {
+	Connect time.Duration // dial + TLS handshake
+	Header  time.Duration // request sent -> response headers
+}
+
+var defaultUpstreamTimeouts = UpstreamTimeouts{Connect: 15 * time.Second, Header: 60 * time.Second}
+
+// newUpstreamTransport builds the transport shared by all upstream fetches.
+func newUpstreamTransport(t UpstreamTimeouts) *http.Transport {
+	dialer := &net.Dialer{Timeout: t.Connect, KeepAlive: 30 * time.Second}
+	return &http.Transport{
+		Proxy:                 nil, // never chain through an environment proxy
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   t.Connect,
+		ResponseHeaderTimeout: t.Header,
+		ForceAttemptHTTP2:     true, // custom DialContext otherwise disables h2
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   upstreamIdlePerHost,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// bodyTransferDeadline is how long a response body of the given length
+// (-1 if unknown) may take to arrive, given the maximum we will read.
+func bodyTransferDeadline(contentLength, maxBytes int64) time.Duration {
+	size := contentLength
+	if size < 0 || size > maxBytes {
+		size = maxBytes
+	}
+	return upstreamBodyBase + time.Duration(float64(size)/upstreamMinBodyRate*float64(time.Second))
+}
+
+// classifyUpstreamError reduces a fetch error to a short cause for clients:
+// "refused", "timeout", "dns", "tls", "redirect" or "network".
+func classifyUpstreamError(err error) string {
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, context.DeadlineExceeded), isTimeout(err):
+		return "timeout"
+	case errors.As(err, &recordErr), errors.As(err, &certErr):
+		return "tls"
+	case errors.Is(err, errRedirectBlocked):
+		return "redirect"
+	}
+	return "network"
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// errRedirectBlocked marks a redirect refused by the allowlist.
+var errRedirectBlocked = errors.New("redirect to disallowed target")
+
+// Retries. A reset connection or a 502 from the target's own load balancer
+// shouldn't cost a full interplanetary round trip to find out about: a real
+// ground station would retry within the same pass. By the time a fetch runs
+// the request is already "at" the destination, so retries happen there with
+// a short local backoff, without re-applying the simulated latency. Only
+// idempotent methods are retried, and only before anything has been handed
+// back; DTN buffers the whole response, so nothing has reached the client.
+
+const (
+	defaultUpstreamRetries = 2
+	upstreamRetryBase      = 250 * time.Millisecond // first backoff, doubled per retry
+	upstreamRetryMax       = 2 * time.Second
+)
+
+// isIdempotent reports whether method may be retried safely.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// retryableUpstream reports whether a fetch result is worth retrying: a
+// dropped or refused connection, or a gateway error from the target's own
+// infrastructure. Timeouts are not - a retry would wait just as long.
+func retryableUpstream(status int, cause string) bool {
+	switch cause {
+	case "":
+		return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+	case "network", "refused":
+		return true
+	}
+	return false
+}
+
+// bodySkippedHeader marks a response that by HTTP rules has no body (a
+// HEAD, a 304, ...), so none was read or relayed.
+const bodySkippedHeader = "X-Latency-Space-Body-Skipped"
+
+// bodyless reports whether a response to method with status carries no
+// body. Conditional requests are forwarded as they are, so a 304 costs the
+// light time both ways and nothing more. An upstream that sends a body
+// anyway is not read.
+func bodyless(method string, status int) bool {
+	return method == http.MethodHead || (status >= 100 && status < 200) ||
+		status == http.StatusNoContent || status == http.StatusNotModified
+}
+
+// upstreamRetryBackoff is the wait before retry n (1-based): base doubling
+// each time, but never more than a quarter of the one-way light time, so
+// retrying stays cheap next to the trip the request already made.
+func upstreamRetryBackoff(n int, base, oneWay time.Duration) time.Duration {
+	d := base << (n - 1)
+	return min(d, upstreamRetryMax, max(oneWay/4, time.Millisecond))
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// closedPort returns a loopback address nothing is listening on.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestUpstreamRefusedFailsFastWith502(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	s := newDTNTestServer(t)
+	s.timing = fixedLatency(10 * time.Millisecond)
+
+	start := time.Now()
+	code, out := dtnSend(t, s, "mars.latency.space", fmt.Sprintf(`{"url":"http://%s/"}`, closedPort(t)))
+	if code != http.StatusAccepted {
+		t.Fatalf("send: expected 202, got %d (%v)", code, out)
+	}
+	id := out["id"].(string)
+
+	var final map[string]interface{}
+	for time.Now().Before(start.Add(3 * time.Second)) {
+		code, final = dtnStatus(t, s, id)
+		if final["state"] == "failed" || final["state"] == "delivered" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code != http.StatusBadGateway {
+		t.Fatalf("expected 502 for a refused upstream, got %d (%v)", code, final)
+	}
+	if final["code"] != upstreamErrorCode || final["cause"] != "refused" {
+		t.Errorf("expected UPSTREAM_ERROR/refused, got code=%v cause=%v", final["code"], final["cause"])
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("refused upstream took %v to report", elapsed)
+	}
+}
+
+func TestUpstreamHeaderTimeout(t *testing.T) {
+	// Accepts the connection and reads the request, but never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	store := NewDTNStore(t.TempDir()+"/dtn.json", NewSecurityValidator(), nil)
+	store.SetUpstreamTimeouts(UpstreamTimeouts{Connect: time.Second, Header: 200 * time.Millisecond})
+
+	start := time.Now()
+	_, _, _, fetchErr, cause := store.fetch(context.Background(), http.MethodGet, "http://"+l.Addr().String()+"/", nil, "")
+	elapsed := time.Since(start)
+	if fetchErr == "" || cause != "timeout" {
+		t.Fatalf("expected a timeout failure, got err=%q cause=%q", fetchErr, cause)
+	}
+	if elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("expected to give up at the 200ms header deadline, took %v", elapsed)
+	}
+}
+
+// TestUpstreamSlowDripSucceeds checks that a body trickling in for longer
+// than the header timeout is not cut off once headers have arrived.
+func TestUpstreamSlowDripSucceeds(t *testing.T) {
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 8; i++ {
+			fmt.Fprintf(w, "chunk%d;", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer dest.Close()
+
+	store := NewDTNStore(t.TempDir()+"/dtn.json", NewSecurityValidator(), nil)
+	store.SetUpstreamTimeouts(UpstreamTimeouts{Connect: time.Second, Header: 300 * time.Millisecond})
+
+	status, _, body, fetchErr, _ := store.fetch(context.Background(), http.MethodGet, dest.URL, nil, "")
+	if fetchErr != "" || status != http.StatusOK {
+		t.Fatalf("slow body should succeed, got status=%d err=%q", status, fetchErr)
+	}
+	if !strings.HasPrefix(body, "chunk0;") || !strings.HasSuffix(body, "chunk7;") {
+		t.Errorf("body truncated: %q", body)
+	}
+}
+
+func TestBodyTransferDeadline(t *testing.T) {
+	if got := bodyTransferDeadline(0, 1<<20); got != upstreamBodyBase {
+		t.Errorf("empty body: expected %v, got %v", upstreamBodyBase, got)
+	}
+	if got := bodyTransferDeadline(upstreamMinBodyRate*10, 1<<20); got != upstreamBodyBase+10*time.Second {
+		t.Errorf("known length: expected %v, got %v", upstreamBodyBase+10*time.Second, got)
+	}
+	unknown := bodyTransferDeadline(-1, 1<<20)
+	if huge := bodyTransferDeadline(1<<30, 1<<20); huge != unknown {
+		t.Errorf("lengths beyond the cap should be sized by the cap: %v vs %v", huge, unknown)
+	}
+}
+
+func TestClassifyUpstreamError(t *testing.T) {
+	wrap := func(err error) error { return &url.Error{Op: "Get", URL: "http://x/", Err: err} }
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{wrap(&net.DNSError{Err: "no such host", Name: "x", IsNotFound: true}), "dns"},
+		{wrap(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "timeout", IsTimeout: true}}), "dns"},
+		{wrap(fmt.Errorf("%w: host not allowed", errRedirectBlocked)), "redirect"},
+		{wrap(errors.New("connection reset")), "network"},
+	}
+	for _, tc := range cases {
+		if got := classifyUpstreamError(tc.err); got != tc.want {
+			t.Errorf("classifyUpstreamError(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+// TestUpstreamConnectionReuse checks sequential fetches to one TLS upstream
+// share a single connection (one handshake), over HTTP/2, and that shutdown
+// drops the pool.
+func TestUpstreamConnectionReuse(t *testing.T) {
+	var conns, h2 atomic.Int32
+	dest := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 {
+			h2.Add(1)
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	dest.EnableHTTP2 = true
+	dest.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	dest.StartTLS()
+	defer dest.Close()
+
+	metrics := NewRecordingMetrics()
+	store := NewDTNStore(t.TempDir()+"/dtn.json", NewSecurityValidator(), metrics)
+	roots := x509.NewCertPool()
+	roots.AddCert(dest.Certificate())
+	store.client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: roots}
+
+	for i := 0; i < 3; i++ {
+		if status, _, _, fetchErr, _ := store.fetch(context.Background(), http.MethodGet, dest.URL, nil, ""); fetchErr != "" || status != http.StatusOK {
+			t.Fatalf("fetch %d: status=%d err=%q", i, status, fetchErr)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("expected one TLS connection for three requests, got %d", n)
+	}
+	if n := h2.Load(); n != 3 {
+		t.Errorf("expected HTTP/2 to be negotiated, got %d HTTP/2 requests of 3", n)
+	}
+	if c, r := metrics.Count(metricUpstreamConnsCreated), metrics.Count(metricUpstreamConnsReused); c != 1 || r != 2 {
+		t.Errorf("expected 1 created and 2 reused, got %v created and %v reused", c, r)
+	}
+
+	store.CloseIdleConnections()
+	if _, _, _, fetchErr, _ := store.fetch(context.Background(), http.MethodGet, dest.URL, nil, ""); fetchErr != "" {
+		t.Fatalf("fetch after close: %s", fetchErr)
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("expected a fresh connection after CloseIdleConnections, got %d total", n)
+	}
+}
+
+// flakyUpstream fails its first request - by dropping the connection, or with
+// the given status - and answers "ok" after that. It counts requests.
+func flakyUpstream(t *testing.T, failStatus int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			if failStatus == 0 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+				return
+			}
+			w.WriteHeader(failStatus)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(dest.Close)
+	return dest, &hits
+}
+
+// waitDTN polls a job until it is delivered or failed.
+func waitDTN(t *testing.T, s *Server, id string) *httptest.ResponseRecorder {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		s.handleDTN(rec, httptest.NewRequest(http.MethodGet, "http://x/dtn/status/"+id, nil))
+		if strings.Contains(rec.Body.String(), `"delivered"`) || strings.Contains(rec.Body.String(), `"failed"`) {
+			return rec
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s never finished: %s", id, rec.Body.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestUpstreamRetryRecovers checks a GET that fails once is retried at the
+// destination and delivered, with the attempts reported.
+func TestUpstreamRetryRecovers(t *testing.T) {
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+
+	for _, tc := range []struct {
+		name   string
+		status int
+	}{
+		{"connection reset", 0},
+		{"502", http.StatusBadGateway},
+		{"503", http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest, hits := flakyUpstream(t, tc.status)
+			s := newDTNTestServer(t)
+			s.timing = fixedLatency(10 * time.Millisecond)
+			s.dtn.retryBase = time.Millisecond
+
+			start := time.Now()
+			code, out := dtnSend(t, s, "mars.latency.space", fmt.Sprintf(`{"url":%q}`, dest.URL))
+			if code != http.StatusAccepted {
+				t.Fatalf("send: expected 202, got %d (%v)", code, out)
+			}
+			rec := waitDTN(t, s, out["id"].(string))
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"body": "ok"`) {
+				t.Fatalf("expected the retried response, got %d %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("X-Latency-Space-Upstream-Attempts"); got != "2" {
+				t.Errorf("X-Latency-Space-Upstream-Attempts = %q, want 2", got)
+			}
+			if n := hits.Load(); n != 2 {
+				t.Errorf("upstream saw %d requests, want 2", n)
+			}
+			// The retry happens at the destination: one round trip, not two.
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("retried job took %v", elapsed)
+			}
+			if n := recorded(t, s.metrics).Count(metricUpstreamRetries, "Mars"); n != 1 {
+				t.Errorf("retries metric = %v, want 1", n)
+			}
+			if n := recorded(t, s.metrics).Count(metricUpstreamOutcomes, "Mars", "recovered"); n != 1 {
+				t.Errorf("recovered outcome = %v, want 1", n)
+			}
+		})
+	}
+}
+
+// TestUpstreamRetryLimits checks non-idempotent methods are never retried,
+// retries stop at the configured count, and 0 disables them.
+func TestUpstreamRetryLimits(t *testing.T) {
+	var hits atomic.Int32
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer dest.Close()
+
+	for _, tc := range []struct {
+		method  string
+		retries int
+		want    int
+	}{
+		{http.MethodPost, 2, 1},
+		{http.MethodPut, 2, 1},
+		{http.MethodGet, 2, 3},
+		{http.MethodHead, 1, 2},
+		{http.MethodGet, 0, 1},
+	} {
+		metrics := NewRecordingMetrics()
+		store := NewDTNStore(t.TempDir()+"/dtn.json", NewSecurityValidator(), metrics)
+		store.retryBase = time.Millisecond
+		store.SetUpstreamRetries(tc.retries)
+		hits.Store(0)
+
+		status, _, _, _, _, attempts := store.fetchWithRetry(context.Background(), "Mars", time.Second, tc.method, dest.URL, nil, "")
+		if status != http.StatusServiceUnavailable || attempts != tc.want || int(hits.Load()) != tc.want {
+			t.Errorf("%s with %d retries: status %d after %d attempts (%d upstream requests), want %d",
+				tc.method, tc.retries, status, attempts, hits.Load(), tc.want)
+		}
+		if n := metrics.Count(metricUpstreamOutcomes, "Mars", "failed"); n != 1 {
+			t.Errorf("%s: failed outcome = %v, want 1", tc.method, n)
+		}
+	}
+}
+
+func TestUpstreamRetryBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		n      int
+		oneWay time.Duration
+		want   time.Duration
+	}{
+		{1, time.Hour, upstreamRetryBase},
+		{2, time.Hour, 2 * upstreamRetryBase},
+		{5, time.Hour, upstreamRetryMax},
+		{1, 400 * time.Millisecond, 100 * time.Millisecond}, // a quarter of the light time
+		{1, 0, time.Millisecond},
+	} {
+		if got := upstreamRetryBackoff(tc.n, upstreamRetryBase, tc.oneWay); got != tc.want {
+			t.Errorf("backoff(%d, one-way %v) = %v, want %v", tc.n, tc.oneWay, got, tc.want)
+		}
+	}
+	for _, c := range []struct {
+		status int
+		cause  string
+		want   bool
+	}{
+		{http.StatusOK, "", false},
+		{http.StatusNotFound, "", false},
+		{http.StatusInternalServerError, "", false},
+		{http.StatusBadGateway, "", true},
+		{http.StatusGatewayTimeout, "", true},
+		{0, "network", true},
+		{0, "refused", true},
+		{0, "timeout", false},
+		{0, "dns", false},
+		{0, "redirect", false},
+	} {
+		if got := retryableUpstream(c.status, c.cause); got != c.want {
+			t.Errorf("retryableUpstream(%d, %q) = %v, want %v", c.status, c.cause, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// TestVisibilityStates follows Jupiter through its June 2025 conjunction:
+// clear, then degraded near the Sun's limb, then blocked behind the disc.
+func TestVisibilityStates(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	earth := findObject(t, objects, "Earth")
+	for _, tc := range []struct {
+		body     string
+		at       time.Time
+		want     Visibility
+		occluder string
+	}{
+		{"Jupiter", time.Date(2025, 6, 20, 0, 0, 0, 0, time.UTC), VisibilityClear, ""},
+		{"Jupiter", time.Date(2025, 6, 23, 0, 0, 0, 0, time.UTC), VisibilityDegraded, "Sun"},
+		{"Jupiter", time.Date(2025, 6, 24, 12, 0, 0, 0, time.UTC), VisibilityBlocked, "Sun"},
+		// The Moon passes in front of Mars; it has no degraded zone.
+		{"Mars", time.Date(2025, 6, 30, 1, 0, 0, 0, time.UTC), VisibilityBlocked, "Moon"},
+		{"Mars", time.Date(2025, 6, 30, 3, 0, 0, 0, time.UTC), VisibilityClear, ""},
+	} {
+		v, by := IsOccluded(earth, findObject(t, objects, tc.body), objects, tc.at)
+		if v != tc.want || by.Name != tc.occluder {
+			t.Errorf("%s at %s: %s (%q), want %s (%q)", tc.body, tc.at.Format(time.RFC3339), v, by.Name, tc.want, tc.occluder)
+		}
+	}
+}
+
+// TestDegradedLimbRadiiPerType checks the degraded zone follows the
+// configured radii of each occluder type.
+func TestDegradedLimbRadiiPerType(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, jupiter := findObject(t, objects, "Earth"), findObject(t, objects, "Jupiter")
+	orig := degradedLimbRadii
+	t.Cleanup(func() { degradedLimbRadii = orig })
+
+	nearSun := time.Date(2025, 6, 23, 0, 0, 0, 0, time.UTC)
+	degradedLimbRadii = map[string]float64{}
+	if v, _ := IsOccluded(earth, jupiter, objects, nearSun); v != VisibilityClear {
+		t.Errorf("no degraded zone: %s, want clear", v)
+	}
+	degradedLimbRadii = map[string]float64{"star": 20}
+	if v, by := IsOccluded(earth, jupiter, objects, nearSun.Add(-48*time.Hour)); v != VisibilityDegraded || by.Name != "Sun" {
+		t.Errorf("20 solar radii: %s (%q), want degraded by the Sun", v, by.Name)
+	}
+
+	// An hour after the Moon left Mars, a wide lunar zone still covers it.
+	afterMoon := time.Date(2025, 6, 30, 3, 0, 0, 0, time.UTC)
+	degradedLimbRadii = map[string]float64{"moon": 5}
+	if v, by := IsOccluded(earth, findObject(t, objects, "Mars"), objects, afterMoon); v != VisibilityDegraded || by.Name != "Moon" {
+		t.Errorf("5 lunar radii: %s (%q), want degraded by the Moon", v, by.Name)
+	}
+}
+
+func TestParseDegradedLimbRadii(t *testing.T) {
+	radii, err := parseDegradedLimbRadii(" star=4, planet=0.1 ")
+	if err != nil || len(radii) != 2 || radii["star"] != 4 || radii["planet"] != 0.1 {
+		t.Errorf("got %v, %v", radii, err)
+	}
+	if radii, err := parseDegradedLimbRadii(""); err != nil || len(radii) != 0 {
+		t.Errorf("empty: %v, %v", radii, err)
+	}
+	for _, bad := range []string{"star", "star=x", "star=-1"} {
+		if _, err := parseDegradedLimbRadii(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+// TestDegradedLinkLoss checks the extra loss combines with the configured
+// loss as an independent chance.
+func TestDegradedLinkLoss(t *testing.T) {
+	r := udpRates{loss: 0.2, dup: 0.1}.withLoss(10)
+	if math.Abs(r.loss-0.28) > 1e-9 || r.dup != 0.1 {
+		t.Errorf("20%% and 10%% loss: %+v, want 28%% loss", r)
+	}
+	if r := (udpRates{}).withLoss(150); r.loss != 1 {
+		t.Errorf("over 100%%: %+v", r)
+	}
+}
+
+// occlusionYear samples a year from the start of 2026 every five days and
+// four hours, so the times of day drift too.
+func occlusionYear() []time.Time {
+	var dates []time.Time
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for at := start; at.Before(start.AddDate(1, 0, 0)); at = at.Add(5*24*time.Hour + 4*time.Hour) {
+		dates = append(dates, at)
+	}
+	return dates
+}
+
+// TestOccluderPrefilterKeepsOutcomes checks over a year that neither the
+// angular-radius prefilter nor removing the sub-kilometre asteroids (and
+// their moons) changes any line of sight from Earth or Mars.
+func TestOccluderPrefilterKeepsOutcomes(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	tiny := make(map[string]bool)
+	for _, obj := range objects {
+		if obj.Type == "asteroid" && obj.Radius < 1 {
+			tiny[obj.Name] = true
+		}
+	}
+	var withoutTiny []celestial.CelestialObject
+	for _, obj := range objects {
+		if !tiny[obj.Name] && !tiny[obj.ParentName] {
+			withoutTiny = append(withoutTiny, obj)
+		}
+	}
+	if len(withoutTiny) == len(objects) {
+		t.Fatal("the shipped objects have no sub-kilometre asteroids to remove")
+	}
+	orig := minOccluderAngularRadius
+	t.Cleanup(func() { minOccluderAngularRadius = orig })
+
+	type outcome struct {
+		v  Visibility
+		by string
+	}
+	check := func(objs []celestial.CelestialObject, threshold float64, observer, target celestial.CelestialObject, at time.Time) outcome {
+		minOccluderAngularRadius = threshold
+		v, by := IsOccluded(observer, target, objs, at)
+		return outcome{v, by.Name}
+	}
+	changes := 0
+	for _, at := range occlusionYear() {
+		for _, observer := range []string{"Earth", "Mars"} {
+			obs := findObject(t, objects, observer)
+			for _, target := range withoutTiny {
+				if target.Name == observer {
+					continue
+				}
+				all := check(objects, 0, obs, target, at)
+				if got := check(objects, orig, obs, target, at); got != all {
+					t.Errorf("%s from %s on %s: %v with the prefilter, %v without", target.Name, observer, at.Format(time.DateTime), got, all)
+				}
+				if got := check(withoutTiny, 0, obs, target, at); got != all {
+					t.Errorf("%s from %s on %s: %v without the tiny asteroids, %v with", target.Name, observer, at.Format(time.DateTime), got, all)
+				}
+				if all.v != VisibilityClear {
+					changes++
+				}
+			}
+		}
+	}
+	if changes == 0 {
+		t.Error("no line of sight was ever degraded or blocked; the sample proves nothing")
+	}
+}
+
+// TestHeliocentricSeparationBound checks the bound that lets bodies be
+// skipped unsolved never exceeds their true distance over the year.
+func TestHeliocentricSeparationBound(t *testing.T) {
+	objects := celestial.InitSolarSystemObjects()
+	for _, at := range occlusionYear() {
+		for _, observer := range []string{"Earth", "Mars", "Jupiter", "Voyager 1"} {
+			obsPos := GetObjectPosition(findObject(t, objects, observer), objects, at)
+			for _, obj := range objects {
+				if obj.Name == observer {
+					continue
+				}
+				bound := minHeliocentricSeparation(obj, obsPos.Magnitude())
+				if actual := GetObjectPosition(obj, objects, at).Subtract(obsPos).Magnitude(); bound > actual {
+					t.Errorf("%s from %s on %s: bound %.4f AU exceeds the distance %.4f AU", obj.Name, observer, at.Format(time.DateOnly), bound, actual)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkDistanceTableOcclusion times the occlusion checks of a full
+// distance-table refresh: every body seen from Earth at one instant.
+func BenchmarkDistanceTableOcclusion(b *testing.B) {
+	objects := celestial.InitSolarSystemObjects()
+	earth, _ := findObjectByName(objects, "Earth")
+	at := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	orig := minOccluderAngularRadius
+	b.Cleanup(func() { minOccluderAngularRadius = orig })
+
+	for _, bc := range []struct {
+		name      string
+		threshold float64
+		shared    bool
+	}{
+		{"unfiltered", 0, false},
+		{"shared-cache", 0, true},
+		{"shared-cache+prefilter", orig, true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			minOccluderAngularRadius = bc.threshold
+			for i := 0; i < b.N; i++ {
+				var cache *PositionCache
+				if bc.shared {
+					cache = NewPositionCache(objects, at)
+				}
+				for _, obj := range objects {
+					if obj.Name != "Earth" {
+						IsOccludedCached(earth, obj, objects, at, cache)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+// whatif.go - /api/whatif, the scenarios of scenarios.go at a body's
+// current light time and downlink rate.
+//
+//	GET /api/whatif?body=mars&scenario=webpage|ssh|video_call|git_clone[&size=2MB]
+//
+// The body can also come from the host (mars.latency.space/api/whatif).
+// size applies to webpage and git_clone: bytes, or a number with KB, MB or
+// GB (binary multiples, as in the transfer table).
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScenarioBytes bounds size so the serialization time can't overflow.
+const maxScenarioBytes = 1 << 40
+
+// WhatIfStep is a ScenarioStep in JSON.
+type WhatIfStep struct {
+	Step       string  `json:"step"`
+	RoundTrips int     `json:"round_trips"`
+	Seconds    float64 `json:"seconds"`
+}
+
+// WhatIfPlan is a ScenarioPlan in JSON.
+type WhatIfPlan struct {
+	Steps        []WhatIfStep `json:"steps"`
+	RoundTrips   int          `json:"round_trips"`
+	TotalSeconds float64      `json:"total_seconds"`
+}
+
+// WhatIfWebpage is a WebpageScenario in JSON.
+type WhatIfWebpage struct {
+	HTTP1 WhatIfPlan `json:"http1"`
+	HTTP2 WhatIfPlan `json:"http2"`
+	HTTP3 WhatIfPlan `json:"http3"`
+}
+
+// WhatIfSSH is an SSHScenario in JSON.
+type WhatIfSSH struct {
+	Login                WhatIfPlan `json:"login"`
+	FirstPromptSeconds   float64    `json:"first_prompt_seconds"`
+	KeystrokeEchoSeconds float64    `json:"keystroke_echo_seconds"`
+}
+
+// WhatIfVideoCall is a VideoCallScenario in JSON.
+type WhatIfVideoCall struct {
+	Possible                 bool    `json:"possible"`
+	Reason                   string  `json:"reason,omitempty"` // latency or bandwidth
+	MaxOneWaySeconds         float64 `json:"max_one_way_seconds"`
+	MinBandwidthBps          float64 `json:"min_bandwidth_bps"`
+	ConversationalGapSeconds float64 `json:"conversational_gap_seconds"`
+}
+
+// WhatIfResponse is the JSON returned by /api/whatif. Only the requested
+// scenario's field is set.
+type WhatIfResponse struct {
+	Body         string           `json:"body"`
+	Scenario     string           `json:"scenario"`
+	Timestamp    time.Time        `json:"timestamp"`
+	ComputedAt   time.Time        `json:"computed_at"` // the instant the distance describes
+	OneWay       float64          `json:"one_way_seconds"`
+	RoundTrip    float64          `json:"round_trip_seconds"`
+	BandwidthBps float64          `json:"bandwidth_bps"`
+	SizeBytes    int64            `json:"size_bytes,omitempty"`
+	Webpage      *WhatIfWebpage   `json:"webpage,omitempty"`
+	SSH          *WhatIfSSH       `json:"ssh,omitempty"`
+	VideoCall    *WhatIfVideoCall `json:"video_call,omitempty"`
+	GitClone     *WhatIfPlan      `json:"git_clone,omitempty"`
+}
+
+// roundedSeconds rounds d to the millisecond.
+func roundedSeconds(d time.Duration) float64 {
+	return math.Round(d.Seconds()*1000) / 1000
+}
+
+func whatIfPlan(p ScenarioPlan) WhatIfPlan {
+	steps := make([]WhatIfStep, len(p.Steps))
+	for i, s := range p.Steps {
+		steps[i] = WhatIfStep{Step: s.Name, RoundTrips: s.RoundTrips, Seconds: roundedSeconds(s.Duration)}
+	}
+	return WhatIfPlan{Steps: steps, RoundTrips: p.RoundTrips(), TotalSeconds: roundedSeconds(p.Total())}
+}
+
+// parseScenarioSize reads a size such as "2MB" or "1500"; empty is def.
+func parseScenarioSize(s string, def int64) (int64, bool) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return def, true
+	}
+	mult := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), unit.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 || math.IsInf(n, 0) || n*float64(mult) > maxScenarioBytes {
+		return 0, false
+	}
+	return int64(n * float64(mult)), true
+}
+
+// evaluateScenario fills resp for scenario at oneWay and bps. known is
+// false for an unknown scenario and sizeOK for a size that doesn't parse.
+func evaluateScenario(resp *WhatIfResponse, scenario, size string, oneWay time.Duration, bps float64) (sizeOK, known bool) {
+	switch scenario {
+	case scenarioWebpage:
+		n, ok := parseScenarioSize(size, defaultWebpageBytes)
+		if !ok {
+			return false, true
+		}
+		page := WebpageLoad(oneWay, bps, n)
+		resp.SizeBytes = n
+		resp.Webpage = &WhatIfWebpage{HTTP1: whatIfPlan(page.HTTP1), HTTP2: whatIfPlan(page.HTTP2), HTTP3: whatIfPlan(page.HTTP3)}
+	case scenarioSSH:
+		ssh := SSHSession(oneWay)
+		resp.SSH = &WhatIfSSH{
+			Login:                whatIfPlan(ssh.Login),
+			FirstPromptSeconds:   roundedSeconds(ssh.Login.Total()),
+			KeystrokeEchoSeconds: roundedSeconds(ssh.KeystrokeEcho),
+		}
+	case scenarioVideoCall:
+		call := VideoCall(oneWay, bps)
+		resp.VideoCall = &WhatIfVideoCall{
+			Possible:                 call.Possible,
+			Reason:                   call.Reason,
+			MaxOneWaySeconds:         videoCallMaxOneWay.Seconds(),
+			MinBandwidthBps:          videoCallMinBps,
+			ConversationalGapSeconds: roundedSeconds(call.ConversationalGap),
+		}
+	case scenarioGitClone:
+		n, ok := parseScenarioSize(size, defaultGitCloneBytes)
+		if !ok {
+			return false, true
+		}
+		clone := whatIfPlan(GitClone(oneWay, bps, n))
+		resp.SizeBytes = n
+		resp.GitClone = &clone
+	default:
+		return true, false
+	}
+	return true, true
+}
+
+// whatIfLink is one entry of the info page's scenarios section.
+type whatIfLink struct {
+	Label, URL string
+}
+
+// whatIfLinks links every scenario for body, labelled in l.
+func whatIfLinks(l Locale, body string) []whatIfLink {
+	links := make([]whatIfLink, len(scenarioNames))
+	for i, name := range scenarioNames {
+		links[i] = whatIfLink{l.T("scenario." + name), whatIfURL(body, name)}
+	}
+	return links
+}
+
+// whatIfURL is the /api/whatif query for scenario at body, relative to the
+// body's own host.
+func whatIfURL(body, scenario string) string {
+	return "/api/whatif?" + url.Values{"body": {body}, "scenario": {scenario}}.Encode()
+}
+
+// handleWhatIf serves /api/whatif.
+func (s *Server) handleWhatIf(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	snap, ok := s.requestSnapshot(w, r)
+	if !ok {
+		return
+	}
+	name := strings.TrimSpace(q.Get("body"))
+	if name == "" {
+		name = s.resolveCelestialHost(snap.Objects, r.Host)
+	}
+	if name == "" {
+		writeJSONError(w, r, http.StatusBadRequest, "error.whatif_body")
+		return
+	}
+	obj, ok := snap.Find(name)
+	if !ok {
+		writeJSONError(w, r, http.StatusNotFound, "error.unknown_body_named", name)
+		return
+	}
+	distance, err := snap.Distance(obj.Name)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	setStationHeader(w, obj.Name)
+
+	oneWay := s.oneWay(obj.Name, distance)
+	down, _ := dataRates(obj)
+	scenario := strings.ToLower(strings.TrimSpace(q.Get("scenario")))
+	resp := WhatIfResponse{
+		Body:         obj.Name,
+		Scenario:     scenario,
+		Timestamp:    s.now().UTC(),
+		ComputedAt:   snap.ComputedAt.UTC(),
+		OneWay:       roundedSeconds(oneWay),
+		RoundTrip:    roundedSeconds(2 * oneWay),
+		BandwidthBps: down,
+	}
+	sizeOK, known := evaluateScenario(&resp, scenario, q.Get("size"), oneWay, down)
+	if !known {
+		writeJSONError(w, r, http.StatusBadRequest, "error.whatif_scenario", strings.Join(scenarioNames, ", "))
+		return
+	}
+	if !sizeOK {
+		writeJSONError(w, r, http.StatusBadRequest, "error.whatif_size")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
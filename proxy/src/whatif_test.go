@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+func TestParseScenarioSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"", 42, true},
+		{"1500", 1500, true},
+		{"10b", 10, true},
+		{"2MB", 2 << 20, true},
+		{"1.5 kb", 1536, true},
+		{"3GB", 3 << 30, true},
+		{"0", 0, false},
+		{"-1MB", 0, false},
+		{"lots", 0, false},
+		{"2048GB", 0, false},
+	} {
+		got, ok := parseScenarioSize(tc.in, 42)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseScenarioSize(%q) = %d, %v; want %d, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestWhatIfEndpoint(t *testing.T) {
+	original := getCelestialObjects()
+	defer setCelestialObjects(original)
+	setCelestialObjects(celestial.InitSolarSystemObjects())
+	invalidateDistanceCache()
+	s := &Server{security: NewSecurityValidator(), metrics: NewRecordingMetrics()}
+
+	get := func(url string) (int, WhatIfResponse) {
+		rec := httptest.NewRecorder()
+		s.handleHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		var out WhatIfResponse
+		json.Unmarshal(rec.Body.Bytes(), &out)
+		return rec.Code, out
+	}
+
+	code, out := get("http://mars.latency.space/api/whatif?scenario=ssh")
+	if code != http.StatusOK || out.Body != "Mars" || out.SSH == nil {
+		t.Fatalf("ssh on mars: %d %+v", code, out)
+	}
+	if want := 9 * out.RoundTrip; math.Abs(out.SSH.FirstPromptSeconds-want) > 0.01 {
+		t.Errorf("first prompt %v s, want 9 RTT = %v s", out.SSH.FirstPromptSeconds, want)
+	}
+
+	code, out = get("http://latency.space/api/whatif?body=mars&scenario=git_clone&size=10MB")
+	if code != http.StatusOK || out.GitClone == nil || out.SizeBytes != 10<<20 || out.Webpage != nil {
+		t.Fatalf("git_clone: %d %+v", code, out)
+	}
+	transfer := out.GitClone.Steps[len(out.GitClone.Steps)-1]
+	if want := float64(10<<20) * 8 / out.BandwidthBps; math.Abs(transfer.Seconds-want) > 0.001 {
+		t.Errorf("transfer %v s, want %v s at %v bps", transfer.Seconds, want, out.BandwidthBps)
+	}
+
+	if code, out = get("http://latency.space/api/whatif?body=moon&scenario=video_call"); code != http.StatusOK || out.VideoCall == nil || out.VideoCall.Possible {
+		t.Errorf("video call to the Moon: %d %+v, want impossible", code, out.VideoCall)
+	}
+
+	for url, want := range map[string]int{
+		"http://latency.space/api/whatif?scenario=ssh":                         http.StatusBadRequest,
+		"http://latency.space/api/whatif?body=vulcan&scenario=ssh":             http.StatusNotFound,
+		"http://latency.space/api/whatif?body=mars&scenario=teleport":          http.StatusBadRequest,
+		"http://latency.space/api/whatif?body=mars&scenario=webpage&size=huge": http.StatusBadRequest,
+	} {
+		if code, _ := get(url); code != want {
+			t.Errorf("%s: %d, want %d", url, code, want)
+		}
+	}
+}
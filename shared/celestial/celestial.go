@@ -3,8 +3,12 @@
 package celestial
 
 import (
+	"fmt"
+	"log"
 	"math"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // Astronomical constants
@@ -21,17 +25,24 @@ const (
 // CelestialObject defines the structure for storing data about any object in the solar system.
 type CelestialObject struct {
 	Name       string
-	Type       string  // e.g., "planet", "dwarf_planet", "moon", "spacecraft", "asteroid", "star"
-	ParentName string  // Name of parent body (empty for Sun, planet name for moons)
+	Type       string  // e.g., "planet", "dwarf_planet", "moon", "spacecraft", "asteroid", "comet", "star"
+	ParentName string  // Name of parent body (empty for Sun; for moons, the body they orbit)
 	Radius     float64 // Mean radius in kilometers
 
 	// Orbital elements relative to the J2000 epoch.
 	// - Planets/Dwarf Planets/Asteroids: Heliocentric elements (AU, degrees).
 	// - Moons: Parent-centric elements (km, degrees).
 	// - Spacecraft: Mission-specific or fixed elements (AU or km, degrees).
+	//
+	// Every orbit is placed from the ecliptic. A moon's catalogue I and N
+	// are from its parent's equator or, for the outer planets' moons, the
+	// local Laplace plane, and are used as if they were ecliptic: the plane
+	// of the orbit is off by about the parent's tilt (98 degrees for
+	// Uranus), but the moon stays its semi-major axis from the parent, which
+	// is all its light time from Earth depends on.
 	A  float64 // Semi-major axis (AU for heliocentric, km otherwise)
 	E  float64 // Eccentricity
-	I  float64 // Inclination (degrees, relative to ecliptic or parent equator)
+	I  float64 // Inclination (degrees; over 90 is retrograde, with DL still positive)
 	L  float64 // Mean longitude (degrees)
 	LP float64 // Longitude of perihelion (degrees) - used for heliocentric
 	N  float64 // Longitude of ascending node (degrees)
@@ -49,6 +60,13 @@ type CelestialObject struct {
 	DW     float64 // Rate of change for argument of perigee (degrees/century)
 	Period float64 // Orbital period (days) - can be calculated, but useful for reference
 
+	// Cometary elements, given instead of A, L and LP (see
+	// DeriveCometElements). A comet's perihelion is known far better than the
+	// semi-major axis of an orbit that may barely be bound. E, I, N and W (the
+	// argument of perihelion) keep their meanings.
+	Q  float64 // Perihelion distance (AU)
+	TP float64 // Time of perihelion passage (Julian date, TDB)
+
 	// Parameters used in perturbation calculations (simplified VSOP87).
 	B float64 // Coefficient (e.g., related to another body's period)
 	C float64 // Coefficient (e.g., related to eccentricity)
@@ -63,6 +81,17 @@ type CelestialObject struct {
 	LaunchDate        string  // Launch date (YYYY-MM-DD)
 	FrequencyMHz      float64 // Primary downlink frequency in MHz
 	MissionStatus     string  // e.g., "active", "extended", "completed", "failed"
+	TxPowerWatts      float64 // Downlink transmitter RF power in watts (link budget)
+	AntennaGainDbi    float64 // Downlink antenna gain in dBi (link budget)
+
+	// Link data rates in bits per second. Zero means no figure is known and
+	// the proxy assumes a generous default (a future colony's link).
+	DownlinkBps float64 // Body to Earth
+	UplinkBps   float64 // Earth to body
+
+	// Educational text for the body's info page and /api/bodies (facts.go).
+	MOTD  string   // Banner shown at the top of the info page
+	Facts []string // Short facts; the info page shows one at a time
 }
 
 // Vector3 represents a standard 3D vector with X, Y, Z components.
@@ -387,8 +416,7 @@ func InitSolarSystemObjects() []CelestialObject {
 			L:          95.989,
 			LP:         73.597,
 			N:          80.393,
-			DL:         1680.5,   // Approx value for mean motion
-			Period:     1681.0,   // Orbital period (days)
+			Period:     1681.0,   // Orbital period (days); DL is derived from it
 			Mass:       9.393e20, // kg
 		},
 
@@ -654,7 +682,7 @@ func InitSolarSystemObjects() []CelestialObject {
 			N:          177.612,
 			W:          237.234,
 			L:          267.457,
-			DL:         -61.2572637 * 360.0 / 365.25 * DAYS_PER_CENTURY, // Negative for retrograde
+			DL:         61.2572637 * 360.0 / 365.25 * DAYS_PER_CENTURY, // Positive: I over 90 makes it retrograde
 			Period:     5.876854,
 			Mass:       2.14e22, // kg
 		},
@@ -817,7 +845,7 @@ func InitSolarSystemObjects() []CelestialObject {
 			N:          241.6,
 			W:          345.0,
 			L:          110.0,
-			DL:         -0.65419 * 360.0 / 365.25 * DAYS_PER_CENTURY, // Negative for retrograde
+			DL:         0.65419 * 360.0 / 365.25 * DAYS_PER_CENTURY, // Positive: I over 90 makes it retrograde
 			Period:     550.31,
 			Mass:       8.29e18, // kg
 		},
@@ -870,6 +898,22 @@ func InitSolarSystemObjects() []CelestialObject {
 			Mass:       2.08e18, // kg
 		},
 
+		{
+			Name:       "Dimorphos", // Didymos — DART's impact target, a moon of an asteroid
+			Type:       "moon",
+			ParentName: "Didymos",
+			Radius:     0.0755,
+			A:          1.144, // km, after DART shortened the orbit
+			E:          0.0,
+			I:          0.0, // relative to Didymos's equator
+			N:          0.0,
+			W:          0.0,
+			L:          0.0,
+			DL:         360.0 / 0.47383 * DAYS_PER_CENTURY, // 360/Period
+			Period:     0.47383,
+			Mass:       4.3e9, // kg
+		},
+
 		// Spacecraft
 		// Active deep space missions with transmitters
 		{
@@ -882,9 +926,14 @@ func InitSolarSystemObjects() []CelestialObject {
 			I:                 34.9,  // Ecliptic latitude (well above the ecliptic, toward Ophiuchus)
 			DA:                360.0, // AU/century (~3.6 AU/yr) → ~172.6 AU in mid-2026, self-updating
 			LaunchDate:        "1977-09-05",
+			Mass:              815.0, // kg at launch
 			TransmitterActive: true,
 			FrequencyMHz:      8415.0, // X-band downlink frequency
 			MissionStatus:     "active",
+			TxPowerWatts:      23.0,  // X-band TWTA
+			AntennaGainDbi:    48.0,  // 3.7 m high-gain antenna
+			DownlinkBps:       160.0, // X-band via the 70 m DSN dishes
+			UplinkBps:         16.0,  // Command uplink
 		},
 
 		{
@@ -897,9 +946,14 @@ func InitSolarSystemObjects() []CelestialObject {
 			I:                 46.2,  // Degrees
 			DA:                310.0, // AU/century (~3.1 AU/yr) → ~143 AU in mid-2026, self-updating
 			LaunchDate:        "1977-08-20",
+			Mass:              825.5, // kg at launch
 			TransmitterActive: true,
 			FrequencyMHz:      8415.0, // X-band downlink frequency
 			MissionStatus:     "active",
+			TxPowerWatts:      23.0,  // X-band TWTA
+			AntennaGainDbi:    48.0,  // 3.7 m high-gain antenna
+			DownlinkBps:       160.0, // X-band via the 70 m DSN dishes
+			UplinkBps:         16.0,  // Command uplink
 		},
 
 		{
@@ -912,9 +966,14 @@ func InitSolarSystemObjects() []CelestialObject {
 			I:                 2.45,  // Degrees
 			DA:                240.0, // AU/century (~2.4 AU/yr) → ~64.5 AU in mid-2026, self-updating
 			LaunchDate:        "2006-01-19",
+			Mass:              478.0, // kg at launch
 			TransmitterActive: true,
 			FrequencyMHz:      8438.0, // X-band downlink frequency
 			MissionStatus:     "active",
+			TxPowerWatts:      12.0,   // X-band TWTA
+			AntennaGainDbi:    42.0,   // 2.1 m high-gain antenna
+			DownlinkBps:       1000.0, // ~1 kbps from the Kuiper belt
+			UplinkBps:         2000.0,
 		},
 
 		{
@@ -928,9 +987,12 @@ func InitSolarSystemObjects() []CelestialObject {
 			DL:                149420.0, // ~88-day orbit → sweeps 0.046–0.73 AU (orbital phase is approximate)
 			Period:            88.0,     // days
 			LaunchDate:        "2018-08-12",
+			Mass:              685.0, // kg at launch
 			TransmitterActive: true,
 			FrequencyMHz:      8421.0, // X-band downlink frequency
 			MissionStatus:     "active",
+			DownlinkBps:       167000.0, // Typical Ka-band science downlink
+			UplinkBps:         2000.0,
 		},
 
 		{
@@ -942,9 +1004,14 @@ func InitSolarSystemObjects() []CelestialObject {
 			E:                 0.0,
 			I:                 0.1, // Small inclination
 			LaunchDate:        "2021-12-25",
+			Mass:              6161.4, // kg at launch
 			TransmitterActive: true,
 			FrequencyMHz:      25900.0, // Ka-band downlink frequency
 			MissionStatus:     "active",
+			TxPowerWatts:      13.0,       // Ka-band TWTA
+			AntennaGainDbi:    41.0,       // 0.6 m Ka-band antenna
+			DownlinkBps:       28000000.0, // 28 Mbps Ka-band science downlink
+			UplinkBps:         16000.0,    // S-band commands
 		},
 
 		{
@@ -956,9 +1023,12 @@ func InitSolarSystemObjects() []CelestialObject {
 			E:                 0.0,           // On surface
 			I:                 0.0,           // On surface
 			LaunchDate:        "2020-07-30",
+			Mass:              1025.0, // kg (rover)
 			TransmitterActive: true,
 			FrequencyMHz:      8426.0, // X-band downlink frequency
 			MissionStatus:     "active",
+			DownlinkBps:       2000000.0, // Via the Mars relay orbiters; direct-to-Earth is a few kbps
+			UplinkBps:         500.0,     // X-band direct from Earth
 		},
 
 		// ASTEROIDS
@@ -1038,8 +1108,72 @@ func InitSolarSystemObjects() []CelestialObject {
 			Period:     323.6,  // days
 			Mass:       6.1e10, // kg
 		},
+
+		{
+			Name:       "Didymos",
+			Type:       "asteroid",
+			ParentName: "Sun",
+			Radius:     0.3825,
+			A:          1.6427, // AU
+			E:          0.3832,
+			I:          3.4139,
+			L:          93.2, // from perihelion on 2022-10-22
+			LP:         32.58,
+			N:          72.98,
+			DL:         17099.0, // 36000/period(yr)
+			Period:     769.0,   // days
+			Mass:       5.4e11,  // kg
+		},
+
+		// COMETS
+		// Cometary elements (J2000 ecliptic); A, L and LP are derived.
+		{
+			Name:       "Halley",
+			Type:       "comet",
+			ParentName: "Sun",
+			Radius:     5.5,
+			Q:          0.587104, // AU
+			E:          0.967143,
+			I:          162.2627,
+			N:          58.4201,
+			W:          111.3325,
+			TP:         2446470.9589, // 1986-02-09
+			Mass:       2.2e14,       // kg
+		},
+
+		{
+			Name:       "67P",
+			Type:       "comet",
+			ParentName: "Sun",
+			Radius:     1.65,
+			Q:          1.2107, // AU
+			E:          0.6498,
+			I:          3.8717,
+			N:          36.3321,
+			W:          22.1338,
+			TP:         2459520.57, // 2021-11-02
+			Mass:       1.0e13,     // kg
+		},
+
+		{
+			// C/2023 A3, on a barely hyperbolic orbit: it is not coming back.
+			Name:       "Tsuchinshan-ATLAS",
+			Type:       "comet",
+			ParentName: "Sun",
+			Radius:     2.5,      // estimated
+			Q:          0.391438, // AU
+			E:          1.000116,
+			I:          139.1115,
+			N:          21.5597,
+			W:          308.4930,
+			TP:         2460581.2391, // 2024-09-27
+			Mass:       3e13,         // kg, estimated
+		},
 	}
 
+	addEducationalText(objects)
+	DeriveCometElements(objects)
+
 	// Normalize angles
 	for i := range objects {
 		if objects[i].Type != "star" && objects[i].Type != "spacecraft" {
@@ -1047,9 +1181,77 @@ func InitSolarSystemObjects() []CelestialObject {
 		}
 	}
 
+	// Several bodies are defined with a partial element set; fill in their
+	// mean motion so they move.
+	derived := DeriveMissingRates(objects)
+	derivedOnce.Do(func() {
+		for _, d := range derived {
+			log.Printf("Warning: celestial: %s", d)
+		}
+	})
+
 	return objects
 }
 
+// derivedOnce keeps the derived-elements warning to one per process;
+// InitSolarSystemObjects is called far more often than that.
+var derivedOnce sync.Once
+
+// DeriveMissingRates fills in the mean motion (DL) of heliocentric bodies
+// defined without one, from Kepler's third law: the period comes from
+// Period if set, else from the semi-major axis (P = A^1.5 years, A in AU),
+// and DL = 360 degrees per period, per Julian century. Period is populated
+// too when it was derived. It returns one note per body changed, naming the
+// elements derived.
+func DeriveMissingRates(objects []CelestialObject) []string {
+	var notes []string
+	for i := range objects {
+		obj := &objects[i]
+		switch obj.Type {
+		case "planet", "dwarf_planet", "asteroid":
+		default:
+			continue
+		}
+		if obj.DL != 0 {
+			continue
+		}
+		note := "derived DL from Period"
+		if obj.Period <= 0 {
+			if obj.A <= 0 {
+				continue
+			}
+			obj.Period = DAYS_PER_CENTURY / 100 * math.Pow(obj.A, 1.5)
+			note = "derived Period and DL from A"
+		}
+		obj.DL = 360 * DAYS_PER_CENTURY / obj.Period
+		notes = append(notes, fmt.Sprintf("%s: %s (DL %.4f deg/century, Period %.2f days)",
+			obj.Name, note, obj.DL, obj.Period))
+	}
+	return notes
+}
+
+// DeriveCometElements fills in the A, Period, L, LP and DL of comets on
+// elliptical orbits from their cometary elements, so the rest of the code can
+// treat them as it does asteroids: A = Q/(1-E), the period by Kepler's third
+// law as in DeriveMissingRates, and the mean longitude at J2000 from the time
+// since perihelion. A comet with A already set, or on an open orbit (E >= 1),
+// is left alone.
+func DeriveCometElements(objects []CelestialObject) {
+	for i := range objects {
+		obj := &objects[i]
+		if obj.Type != "comet" || obj.A != 0 || obj.Q <= 0 || obj.E < 0 || obj.E >= 1 {
+			continue
+		}
+		obj.A = obj.Q / (1 - obj.E)
+		if obj.Period <= 0 {
+			obj.Period = DAYS_PER_CENTURY / 100 * math.Pow(obj.A, 1.5)
+		}
+		obj.DL = 360 * DAYS_PER_CENTURY / obj.Period
+		obj.LP = NormalizeDegrees(obj.N + obj.W)
+		obj.L = NormalizeDegrees(obj.LP + 360*(J2000_EPOCH-obj.TP)/obj.Period)
+	}
+}
+
 // Helper functions for filtering celestial objects
 func GetPlanets() []CelestialObject {
 	planets := make([]CelestialObject, 0)
@@ -1061,6 +1263,7 @@ func GetPlanets() []CelestialObject {
 	return planets
 }
 
+// GetMoons returns the moons of bodyName, innermost first.
 func GetMoons(bodyName string) []CelestialObject {
 	moons := make([]CelestialObject, 0)
 	for _, obj := range InitSolarSystemObjects() {
@@ -1068,9 +1271,15 @@ func GetMoons(bodyName string) []CelestialObject {
 			moons = append(moons, obj)
 		}
 	}
+	SortMoons(moons)
 	return moons
 }
 
+// SortMoons orders moons of one parent innermost first, by semi-major axis.
+func SortMoons(moons []CelestialObject) {
+	sort.SliceStable(moons, func(i, j int) bool { return moons[i].A < moons[j].A })
+}
+
 func GetSpacecraft() []CelestialObject {
 	spacecraft := make([]CelestialObject, 0)
 	for _, obj := range InitSolarSystemObjects() {
@@ -1100,3 +1309,13 @@ func GetAsteroids() []CelestialObject {
 	}
 	return asteroids
 }
+
+func GetComets() []CelestialObject {
+	comets := make([]CelestialObject, 0)
+	for _, obj := range InitSolarSystemObjects() {
+		if obj.Type == "comet" {
+			comets = append(comets, obj)
+		}
+	}
+	return comets
+}
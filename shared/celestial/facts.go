@@ -0,0 +1,115 @@
+package celestial
+
+// bodyText is the built-in MOTD and facts for one body.
+type bodyText struct {
+	motd  string
+	facts []string
+}
+
+// educationalText is keyed by body name. Facts should stay true for years:
+// prefer geometry and mission history over "currently doing" statements,
+// which belong in an objects-file MOTD instead.
+var educationalText = map[string]bodyText{
+	"Sun": {facts: []string{
+		"Sunlight takes about 8 minutes 20 seconds to reach Earth.",
+		"When a planet passes behind the Sun (solar conjunction), the corona scrambles radio signals and missions pause most commanding for about two weeks.",
+	}},
+	"Mercury": {facts: []string{
+		"Mercury never strays more than about 28 degrees from the Sun in Earth's sky, so its radio links often graze the solar corona.",
+		"MESSENGER orbited Mercury from 2011 to 2015; ESA and JAXA's BepiColombo follows it.",
+	}},
+	"Venus": {facts: []string{
+		"At its closest Venus is about 38 million km away: a round trip of just over four minutes.",
+		"The longest-lived Soviet Venera lander lasted just over two hours on the surface, relaying everything through its carrier spacecraft overhead.",
+	}},
+	"Earth": {facts: []string{
+		"Geostationary satellites sit 35,786 km up; a request and its reply cross that gap four times, adding about half a second.",
+		"Light could circle the Earth about seven and a half times in one second.",
+	}},
+	"Moon": {facts: []string{
+		"The Moon is about 1.3 light-seconds away, which is why Apollo conversations had a two-and-a-half-second pause.",
+		"The round trip to the Moon is longer than TCP's standard one-second initial retransmission timeout, so a new connection's first SYN is resent before its answer can arrive.",
+	}},
+	"Mars": {facts: []string{
+		"Mars is between about 3 and 22 light-minutes from Earth, depending on where both planets are in their orbits.",
+		"Mars rovers can't be driven with a joystick: each day's drive is planned on Earth, uplinked, and carried out autonomously.",
+		"Around solar conjunction, about every 26 months, mission teams stop commanding Mars spacecraft for roughly two weeks.",
+	}},
+	"Phobos": {facts: []string{
+		"Phobos orbits Mars three times a day, rising in the west and setting in the east.",
+	}},
+	"Jupiter": {facts: []string{
+		"Jupiter is between about 33 and 54 light-minutes from Earth.",
+		"Galileo's main antenna failed to unfurl in 1991, so the mission returned its science through a low-gain antenna at no more than about 160 bits per second.",
+	}},
+	"Europa": {facts: []string{
+		"NASA's Europa Clipper is due to reach Jupiter in 2030 and fly past Europa dozens of times.",
+	}},
+	"Saturn": {facts: []string{
+		"Saturn is roughly 1.1 to 1.5 light-hours from Earth.",
+		"Cassini orbited Saturn from 2004 until 2017, when it was deliberately steered into the planet.",
+	}},
+	"Titan": {facts: []string{
+		"The Huygens probe landed on Titan in 2005, relaying its data through Cassini; one of its two radio channels was never switched on at the receiver.",
+	}},
+	"Uranus": {facts: []string{
+		"Uranus is roughly 2.4 to 2.9 light-hours from Earth.",
+		"Voyager 2 is the only spacecraft to have visited Uranus, in January 1986.",
+	}},
+	"Neptune": {facts: []string{
+		"Neptune is about four light-hours from Earth.",
+		"Voyager 2 flew past Neptune in 1989; its pictures took over four hours to reach Earth.",
+	}},
+	"Pluto": {facts: []string{
+		"Pluto is between about 4 and 7 light-hours from Earth.",
+		"New Horizons flew past Pluto in July 2015; downlinking all of the flyby data took over a year.",
+	}},
+	"Voyager 1": {
+		motd: "The most distant human-made object. Every exchange here takes days.",
+		facts: []string{
+			"Voyager 1 crossed into interstellar space in August 2012.",
+			"Voyager 1's radio transmitter puts out about 22 watts, and its signal reaches Earth more than a day after it is sent.",
+			"Only the Deep Space Network's 70-metre dishes can still hear Voyager 1.",
+		},
+	},
+	"Voyager 2": {facts: []string{
+		"Voyager 2 crossed into interstellar space in November 2018.",
+		"Voyager 2 is so far south of the ecliptic that only the DSS-43 antenna in Canberra can send it commands.",
+	}},
+	"New Horizons": {facts: []string{
+		"After Pluto, New Horizons flew past the Kuiper Belt object Arrokoth on 1 January 2019.",
+	}},
+	"Parker Solar Probe": {facts: []string{
+		"Parker Solar Probe's closest passes take it within about 6.1 million km of the Sun's surface.",
+	}},
+	"JWST": {facts: []string{
+		"JWST orbits near the Sun-Earth L2 point, about 1.5 million km away: five light-seconds.",
+	}},
+	"Mars Perseverance": {facts: []string{
+		"Perseverance landed in Jezero Crater in February 2021.",
+		"Most of Perseverance's data reaches Earth through Mars orbiters rather than directly.",
+	}},
+	"Halley": {facts: []string{
+		"Halley's Comet returns about every 76 years: it last passed perihelion in February 1986 and is next due in 2061.",
+		"Halley orbits the Sun backwards, inclined 162 degrees to the ecliptic.",
+		"In 1986 ESA's Giotto flew within about 600 km of Halley's nucleus.",
+	}},
+	"67P": {facts: []string{
+		"ESA's Rosetta orbited 67P/Churyumov-Gerasimenko from 2014 to 2016, and its Philae lander touched down in November 2014.",
+		"Philae bounced twice on landing and came to rest in shadow; its last contact, relayed through Rosetta, was in July 2015.",
+	}},
+	"Tsuchinshan-ATLAS": {facts: []string{
+		"Officially C/2023 A3, it was found in 2023 by China's Purple Mountain Observatory and the ATLAS survey.",
+		"It passed within about 71 million km of Earth in October 2024 on a barely hyperbolic orbit: it is leaving the Solar System.",
+	}},
+}
+
+// addEducationalText fills in the built-in MOTD and facts.
+func addEducationalText(objects []CelestialObject) {
+	for i := range objects {
+		if t, ok := educationalText[objects[i].Name]; ok {
+			objects[i].MOTD = t.motd
+			objects[i].Facts = append([]string(nil), t.facts...)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package celestial
+
+import "strings"
+
+// BodyGroup is a type-level subdomain (planets.latency.space, ...) that lists
+// the bodies of some types rather than naming one body.
+type BodyGroup struct {
+	Name  string   // subdomain label, lowercase
+	Title string   // heading for the index page
+	Types []string // member types; nil means every body except the star
+}
+
+// BodyGroups are the group subdomains, in the order they are listed.
+// Their names are reserved: no body may be called the same.
+var BodyGroups = []BodyGroup{
+	{Name: "all", Title: "All bodies"},
+	{Name: "planets", Title: "Planets and dwarf planets", Types: []string{"planet", "dwarf_planet"}},
+	{Name: "moons", Title: "Moons", Types: []string{"moon"}},
+	{Name: "spacecraft", Title: "Spacecraft", Types: []string{"spacecraft"}},
+	{Name: "asteroids", Title: "Asteroids", Types: []string{"asteroid"}},
+	{Name: "comets", Title: "Comets", Types: []string{"comet"}},
+}
+
+// FindBodyGroup returns the group called name (case-insensitive).
+func FindBodyGroup(name string) (BodyGroup, bool) {
+	for _, g := range BodyGroups {
+		if strings.EqualFold(g.Name, name) {
+			return g, true
+		}
+	}
+	return BodyGroup{}, false
+}
+
+// IsReservedName reports whether name is taken by a group subdomain.
+func IsReservedName(name string) bool {
+	_, ok := FindBodyGroup(name)
+	return ok
+}
+
+// Includes reports whether obj belongs to g.
+func (g BodyGroup) Includes(obj CelestialObject) bool {
+	if g.Types == nil {
+		return obj.Type != "star"
+	}
+	for _, t := range g.Types {
+		if obj.Type == t {
+			return true
+		}
+	}
+	return false
+}
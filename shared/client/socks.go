@@ -0,0 +1,202 @@
+// Package client is a reference client for latency.space's SOCKS5
+// extensions, for test harnesses and tools that want to know what the
+// proxy did to a connection.
+//
+// # Metadata extension
+//
+// SOCKS5 has no way to report the latency applied to a tunnel, so a slow
+// link and a slow target look the same. A client opts in by offering the
+// private authentication method 0x80 ("latency-space metadata"):
+//
+//	greeting   client: VER NMETHODS METHODS, with 0x80 among the methods
+//	selection  server: 0x05 0x80 (there is no sub-negotiation)
+//	request    standard
+//	reply      standard reply, immediately followed by one frame:
+//	           LEN (2 bytes, big endian) | JSON (LEN bytes)
+//	relay      starts after the frame, as usual
+//
+// Every reply to a request carries a frame, failures included, so a client
+// always reads the same shape. Fields not yet known when a request fails
+// (the body, for a malformed request) are zero. The JSON is Metadata.
+//
+// A server that doesn't know the extension never selects 0x80, and a client
+// that doesn't offer it gets standard SOCKS5.
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// MetadataMethod is the SOCKS5 method number that opts into metadata.
+const MetadataMethod = 0x80
+
+const (
+	socksVersion    = 0x05
+	socksNoAuth     = 0x00
+	socksCmdConnect = 0x01
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+)
+
+// Metadata describes the link a request was routed over.
+type Metadata struct {
+	Body       string  `json:"body"`
+	DistanceKm float64 `json:"distance_km"`
+	OnewayMs   float64 `json:"oneway_ms"`
+	Occluded   bool    `json:"occluded"`
+	// Occluder is the body in the line of sight when Occluded.
+	Occluder string `json:"occluder,omitempty"`
+	// QuotaRemaining is how many more connections the client may open right
+	// now before a rate or concurrency limit refuses one; -1 if unlimited.
+	QuotaRemaining int `json:"quota_remaining"`
+	// SessionBytesRemaining is how many bytes, both ways together, the
+	// session may carry before the server closes it; -1 if unlimited.
+	SessionBytesRemaining int64 `json:"session_bytes_remaining"`
+	// ErrorCode explains a refusal the reply code can't, such as
+	// UPSTREAM_UNREACHABLE for a target a pre-flight probe found down.
+	ErrorCode string `json:"error_code,omitempty"`
+	// ProbeAgeMs is how old that probe was.
+	ProbeAgeMs float64 `json:"probe_age_ms,omitempty"`
+}
+
+// AppendMetadata appends m's frame to b, so a server can send a reply and
+// its frame in one write.
+func AppendMetadata(b []byte, m *Metadata) ([]byte, error) {
+	blob, err := json.Marshal(m)
+	if err != nil {
+		return b, err
+	}
+	if len(blob) > 0xFFFF {
+		return b, fmt.Errorf("metadata frame too large: %d bytes", len(blob))
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(blob)))
+	return append(b, blob...), nil
+}
+
+// ReadMetadata reads one frame from r.
+func ReadMetadata(r io.Reader) (*Metadata, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, fmt.Errorf("metadata length: %w", err)
+	}
+	blob := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(r, blob); err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	var m Metadata
+	if err := json.Unmarshal(blob, &m); err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	return &m, nil
+}
+
+// ReplyError is a request the proxy refused. Metadata is set when the
+// extension was negotiated.
+type ReplyError struct {
+	Code     byte
+	Metadata *Metadata
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("SOCKS request failed with reply code %d", e.Code)
+}
+
+// Dial connects to addr ("host:port", sent to the proxy as a hostname)
+// through the SOCKS5 proxy at proxy, offering the metadata extension. The
+// returned Metadata is nil if the proxy didn't select it.
+func Dial(ctx context.Context, proxy, addr string) (net.Conn, *Metadata, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", proxy)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The reply is held back by the body's light time; let ctx bound it.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	meta, err := Handshake(conn, addr)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, meta, err
+	}
+	return conn, meta, nil
+}
+
+// Handshake runs the greeting and a CONNECT to addr over an established
+// connection to the proxy. On a refused request the error is a *ReplyError.
+func Handshake(conn io.ReadWriter, addr string) (*Metadata, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 0xFFFF {
+		return nil, fmt.Errorf("invalid port in %q", addr)
+	}
+	if len(host) > 0xFF {
+		return nil, fmt.Errorf("hostname too long: %q", host)
+	}
+
+	if _, err := conn.Write([]byte{socksVersion, 2, socksNoAuth, MetadataMethod}); err != nil {
+		return nil, err
+	}
+	var sel [2]byte
+	if _, err := io.ReadFull(conn, sel[:]); err != nil {
+		return nil, fmt.Errorf("SOCKS greeting: %w", err)
+	}
+	if sel[0] != socksVersion || (sel[1] != socksNoAuth && sel[1] != MetadataMethod) {
+		return nil, fmt.Errorf("SOCKS greeting: unexpected reply %x", sel)
+	}
+	withMeta := sel[1] == MetadataMethod
+
+	req := []byte{socksVersion, socksCmdConnect, 0, socksAddrDomain, byte(len(host))}
+	req = append(req, host...)
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return nil, fmt.Errorf("SOCKS reply: %w", err)
+	}
+	var bound int
+	switch hdr[3] {
+	case socksAddrIPv4:
+		bound = 4
+	case socksAddrIPv6:
+		bound = 16
+	case socksAddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return nil, fmt.Errorf("SOCKS reply: %w", err)
+		}
+		bound = int(n[0])
+	default:
+		return nil, fmt.Errorf("SOCKS reply: unknown address type %d", hdr[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, bound+2)); err != nil {
+		return nil, fmt.Errorf("SOCKS reply: %w", err)
+	}
+
+	var meta *Metadata
+	if withMeta {
+		if meta, err = ReadMetadata(conn); err != nil {
+			return nil, err
+		}
+	}
+	if hdr[1] != 0 {
+		return meta, &ReplyError{Code: hdr[1], Metadata: meta}
+	}
+	return meta, nil
+}
@@ -22,15 +22,14 @@ export default function LandingPage() {
       setStale(false);
 
       const parsed = [];
-      for (const typeKey in data.objects) {
-        const type = typeKey.replace(/s$/, '');
-        data.objects[typeKey].forEach((entry) => {
+      for (const category of data.categories || Object.keys(data.objects)) {
+        data.objects[category].forEach((entry) => {
           parsed.push({
             name: entry.name,
             distance: entry.distance_km / 1e6, // million km
             latencySeconds: entry.latency_seconds,
             occluded: entry.occluded,
-            type,
+            type: entry.type,
             parentName: entry.parentName || null,
           });
         });
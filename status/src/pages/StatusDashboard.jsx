@@ -33,8 +33,20 @@ const formatLatency = (seconds) => {
   }
 };
 
-// Helper function to capitalize type names
-const capitalize = (s) => s.charAt(0).toUpperCase() + s.slice(1);
+// Section headings for the /api/status-data category keys
+const categoryTitles = {
+  planets: 'Planets',
+  dwarfPlanets: 'Dwarf Planets',
+  moons: 'Moons',
+  asteroids: 'Asteroids',
+  comets: 'Comets',
+  spacecraft: 'Spacecraft',
+  stars: 'Stars',
+};
+
+// Heading for a category key; unknown camelCase keys are split into words
+const categoryTitle = (key) =>
+  categoryTitles[key] || key.replace(/([A-Z])/g, ' $1').replace(/^./, (c) => c.toUpperCase());
 
 export default function StatusDashboard() {
   const [statusData, setStatusData] = useState({ timestamp: null, objects: {} });
@@ -73,20 +85,9 @@ export default function StatusDashboard() {
     return () => clearInterval(interval);
   }, []);
 
-  // Define the desired order of object types
-  const typeOrder = ['planets', 'dwarf_planets', 'moons', 'asteroids', 'spacecraft'];
-
-  // Get sorted object types based on the defined order
-  const sortedObjectTypes = Object.keys(statusData.objects || {})
-    .filter(type => statusData.objects[type]?.length > 0) // Only include types with objects
-    .sort((a, b) => {
-      const indexA = typeOrder.indexOf(a);
-      const indexB = typeOrder.indexOf(b);
-      if (indexA === -1 && indexB === -1) return a.localeCompare(b); // Both not in order, sort alphabetically
-      if (indexA === -1) return 1;  // a not in order, comes after
-      if (indexB === -1) return -1; // b not in order, comes after
-      return indexA - indexB;       // Both in order, sort by index
-    });
+  // The server lists the categories present, in display order
+  const sortedObjectTypes = (statusData.categories || Object.keys(statusData.objects || {}))
+    .filter(type => statusData.objects[type]?.length > 0); // Only include types with objects
 
   return (
     <div className="min-h-screen bg-gradient-to-b from-slate-900 to-slate-800 p-8 text-white">
@@ -104,7 +105,7 @@ export default function StatusDashboard() {
         {!loading && !error && sortedObjectTypes.map(objectType => (
           <div key={objectType} className="mb-10">
             <h2 className="text-3xl font-semibold mb-6 border-b border-gray-600 pb-2">
-              {capitalize(objectType.replace('_', ' '))}
+              {categoryTitle(objectType)}
             </h2>
             <div className="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-3 xl:grid-cols-4 gap-6">
               {(statusData.objects[objectType] || [])
@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"strconv"
@@ -59,6 +60,13 @@ func collectDomains() []string {
 	log.Println("Checking for essential system subdomains...")
 	// Status page subdomain removed - now integrated with main site
 
+	// Group index pages (planets.latency.space, all.latency.space, ...).
+	log.Println("Adding body group subdomains...")
+	for _, group := range celestial.BodyGroups {
+		log.Printf("Adding group: %s → %s.latency.space", group.Title, group.Name)
+		domains = append(domains, group.Name)
+	}
+
 	log.Println("Processing planets and their moons...")
 	for _, planet := range celestial.GetPlanets() {
 		// IMPORTANT: Always enforce lowercase for all domain parts
@@ -67,14 +75,7 @@ func collectDomains() []string {
 		domains = append(domains, planetDomain)
 
 		// Add moon subdomains (e.g., phobos.mars).
-		for _, moon := range celestial.GetMoons(planet.Name) {
-			// Ensure both moon and planet names are lowercase for domain consistency
-			// This format must match how domains are constructed in the proxy code
-			moonName := strings.ToLower(moon.Name)
-			moonDomain := moonName + "." + planetDomain
-			log.Printf("Adding moon: %s → %s.latency.space", moon.Name, moonDomain)
-			domains = append(domains, moonDomain)
-		}
+		domains = append(domains, moonDomains(planet.Name, planetDomain)...)
 	}
 
 	log.Println("Processing spacecraft...")
@@ -92,6 +93,7 @@ func collectDomains() []string {
 		dwarfDomain := strings.ToLower(dwarf.Name)
 		log.Printf("Adding dwarf planet: %s → %s.latency.space", dwarf.Name, dwarfDomain)
 		domains = append(domains, dwarfDomain)
+		domains = append(domains, moonDomains(dwarf.Name, dwarfDomain)...)
 	}
 
 	log.Println("Processing asteroids...")
@@ -99,6 +101,14 @@ func collectDomains() []string {
 		asteroidDomain := strings.ToLower(asteroid.Name)
 		log.Printf("Adding asteroid: %s → %s.latency.space", asteroid.Name, asteroidDomain)
 		domains = append(domains, asteroidDomain)
+		domains = append(domains, moonDomains(asteroid.Name, asteroidDomain)...)
+	}
+
+	log.Println("Processing comets...")
+	for _, comet := range celestial.GetComets() {
+		cometDomain := strings.ToLower(comet.Name)
+		log.Printf("Adding comet: %s → %s.latency.space", comet.Name, cometDomain)
+		domains = append(domains, cometDomain)
 	}
 
 	// Validate all domains are lowercase (critical for SSL and DNS consistency)
@@ -119,6 +129,39 @@ func collectDomains() []string {
 	return domains
 }
 
+// moonDomains returns the subdomains of parent's moons (e.g. phobos.mars),
+// under parentDomain. Moons of planets, dwarf planets (charon.pluto) and
+// asteroids (dimorphos.didymos) all sit under their parent.
+func moonDomains(parent, parentDomain string) []string {
+	var domains []string
+	for _, moon := range celestial.GetMoons(parent) {
+		// Ensure both moon and parent names are lowercase for domain consistency
+		// This format must match how domains are constructed in the proxy code
+		moonName := strings.ToLower(moon.Name)
+		moonDomain := moonName + "." + parentDomain
+		log.Printf("Adding moon: %s → %s.latency.space", moon.Name, moonDomain)
+		domains = append(domains, moonDomain)
+	}
+	return domains
+}
+
+// connectCloudflare initializes the Cloudflare API client and looks up the
+// zone's ID, exiting on failure.
+func connectCloudflare(apiToken, zoneName string) (dnsAPI, string) {
+	api, err := cloudflare.NewWithAPIToken(apiToken)
+	if err != nil {
+		log.Fatalf("Error initializing Cloudflare API: %v", err)
+	}
+
+	// Get the Cloudflare Zone ID for the specified zone name.
+	zoneID, err := api.ZoneIDByName(zoneName)
+	if err != nil {
+		log.Fatalf("Error finding Cloudflare Zone ID for zone '%s': %v", zoneName, err)
+	}
+	log.Printf("Found Zone ID '%s' for zone '%s'", zoneID, zoneName)
+	return api, zoneID
+}
+
 // ExecuteSetupDNS is the main function for DNS setup that can be called from either package
 func ExecuteSetupDNS(objects []celestial.CelestialObject) {
 	// Define command-line flags
@@ -127,6 +170,11 @@ func ExecuteSetupDNS(objects []celestial.CelestialObject) {
 		serverIP = flag.String("ip", "", "Server IP Address to point records to (required)")
 		zoneName = flag.String("zone", "latency.space", "Cloudflare Zone Name")
 		autoSSL  = flag.Bool("ssl", false, "Automatically manage SSL certificates with certbot")
+
+		updateTXT   = flag.Bool("update-txt", false, "Only refresh the status TXT records (no A records, no -ip needed)")
+		dryRun      = flag.Bool("dry-run", false, "Log the DNS records that would be written without writing them")
+		statusURL   = flag.String("status-url", "https://latency.space", "latency.space instance to read body status from")
+		apiInterval = flag.Duration("api-interval", defaultAPIInterval, "Pause between Cloudflare writes")
 	)
 	
 	// Check if flags are already parsed (may happen if called from another package)
@@ -135,9 +183,19 @@ func ExecuteSetupDNS(objects []celestial.CelestialObject) {
 	}
 
 	// Validate required flags
-	if *apiToken == "" || *serverIP == "" {
+	if *apiToken == "" || (*serverIP == "" && !*updateTXT) {
 		log.Fatal("Error: Cloudflare API Token (-token) and Server IP Address (-ip) are required.")
 	}
+	status := &statusClient{base: *statusURL, client: &http.Client{}, pause: distanceLookupInterval}
+
+	if *updateTXT {
+		api, zoneID := connectCloudflare(*apiToken, *zoneName)
+		u := &txtUpdater{api: api, zoneID: zoneID, zoneName: *zoneName, interval: *apiInterval, dryRun: *dryRun}
+		if err := updateStatusTXT(context.Background(), objects, status, u); err != nil {
+			log.Fatalf("Error updating status TXT records: %v", err)
+		}
+		return
+	}
 
 	log.Printf("Initialized %d celestial objects for DNS setup", len(objects))
 
@@ -185,17 +243,7 @@ func ExecuteSetupDNS(objects []celestial.CelestialObject) {
 	// Initialize the Cloudflare API client.
 	log.Println("\nINITIALIZING DNS CONFIGURATION")
 	log.Println("=============================")
-	api, err := cloudflare.NewWithAPIToken(*apiToken)
-	if err != nil {
-		log.Fatalf("Error initializing Cloudflare API: %v", err)
-	}
-
-	// Get the Cloudflare Zone ID for the specified zone name.
-	zoneID, err := api.ZoneIDByName(*zoneName)
-	if err != nil {
-		log.Fatalf("Error finding Cloudflare Zone ID for zone '%s': %v", *zoneName, err)
-	}
-	log.Printf("Found Zone ID '%s' for zone '%s'", zoneID, *zoneName)
+	api, zoneID := connectCloudflare(*apiToken, *zoneName)
 
 	ctx := context.Background()
 	
@@ -243,6 +291,11 @@ func ExecuteSetupDNS(objects []celestial.CelestialObject) {
 			TTL:     1, // Set TTL to 1 (Automatic)
 		}
 
+		if *dryRun {
+			log.Printf(" -> [dry run] would point %s at %s (%d existing record(s))", fullDomainName, *serverIP, len(records))
+			continue
+		}
+
 		if len(records) > 0 {
 			// Update the existing A record(s) - should typically only be one.
 			for _, existing := range records {
@@ -282,6 +335,13 @@ func ExecuteSetupDNS(objects []celestial.CelestialObject) {
 		}
 	}
 
+	// Publish each body's status next to its A record. The A records are
+	// what matter, so a failure here is only logged.
+	u := &txtUpdater{api: api, zoneID: zoneID, zoneName: *zoneName, interval: *apiInterval, dryRun: *dryRun}
+	if err := updateStatusTXT(ctx, objects, status, u); err != nil {
+		log.Printf("Error updating status TXT records: %v", err)
+	}
+
 	log.Println("\nDNS setup completed. Starting SSL certificate management...")
 	log.Println("======================================================")
 	
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestCollectDomainsIncludesGroups(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	domains := make(map[string]bool)
+	for _, d := range collectDomains() {
+		domains[d] = true
+	}
+	for _, want := range []string{"all", "planets", "moons", "spacecraft", "asteroids", "comets", "mars", "phobos.mars", "charon.pluto", "dimorphos.didymos", "voyager-1", "halley", "67p", "tsuchinshan-atlas"} {
+		if !domains[want] {
+			t.Errorf("collectDomains is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// dns_txt.go - TXT records publishing each body's link status.
+//
+// Every body domain gets a TXT record next to its A record, so
+//
+//	dig TXT mars.latency.space
+//
+// answers "can I reach Mars today?" without going through the proxy:
+//
+//	"latency=762s distance=137Gm visibility=clear updated=2025-06-01"
+//
+// A body behind an occluder also says when it clears, to the 10-minute
+// resolution of the proxy's search, or clear=unknown past 30 days:
+//
+//	"latency=2754s distance=826Gm visibility=blocked clear=2025-07-09T06:10Z updated=2025-06-24"
+//
+// Positions are solved by the proxy, not by the shared celestial package,
+// so the values come from a running instance's public API (-status-url):
+// /api/status-data for every body, plus /api/distance for the clear time of
+// each blocked one, as spacecurl does.
+//
+// The zone's TXT records are listed in one call and only records whose
+// content changed are written, one per -api-interval, to stay well inside
+// Cloudflare's API rate limit. -update-txt refreshes just these records (for
+// a cron job through conjunction season) and -dry-run logs what would be
+// written instead of writing it.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/latency-space/shared/celestial"
+)
+
+const (
+	// defaultAPIInterval paces Cloudflare writes; the API allows 1200
+	// requests per five minutes.
+	defaultAPIInterval = 250 * time.Millisecond
+	// distanceLookupInterval paces /api/distance lookups, which are rate
+	// limited to 30 a minute per client.
+	distanceLookupInterval = 2 * time.Second
+	// statusTimeout bounds each request to the status API.
+	statusTimeout = 30 * time.Second
+)
+
+// dnsAPI is the part of the Cloudflare client the tool uses. *cloudflare.API
+// implements it; tests use a fake.
+type dnsAPI interface {
+	ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error)
+	CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error)
+	UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error)
+}
+
+var _ dnsAPI = (*cloudflare.API)(nil)
+
+// bodyStatus is the subset of a /api/status-data entry a TXT record reports.
+type bodyStatus struct {
+	Name        string     `json:"name"`
+	Distance    float64    `json:"distance_km"`
+	Latency     float64    `json:"latency_seconds"`
+	Visibility  string     `json:"visibility"` // clear, degraded or blocked
+	NextVisible *time.Time `json:"-"`          // from /api/distance, for blocked bodies
+}
+
+// txtRecord is one body's TXT record.
+type txtRecord struct {
+	Name    string // relative to the zone, as in collectDomains
+	Content string
+}
+
+// bodyDomain is obj's subdomain, built as collectDomains builds it; ok is
+// false for bodies without one.
+func bodyDomain(obj celestial.CelestialObject) (domain string, ok bool) {
+	name := strings.ToLower(strings.ReplaceAll(obj.Name, " ", "-"))
+	switch obj.Type {
+	case "planet", "dwarf_planet", "asteroid", "comet", "spacecraft":
+		return name, !strings.EqualFold(obj.Name, "Earth")
+	case "moon":
+		for _, parent := range celestial.InitSolarSystemObjects() {
+			switch parent.Type {
+			case "planet", "dwarf_planet", "asteroid":
+				if strings.EqualFold(parent.Name, obj.ParentName) {
+					return name + "." + strings.ToLower(parent.Name), true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// formatTXTDistance writes km in metres with an SI prefix, e.g. 137Gm.
+func formatTXTDistance(km float64) string {
+	m := km * 1e3
+	unit, scale := "Mm", 1e6
+	if m >= 1e12 {
+		unit, scale = "Tm", 1e12
+	} else if m >= 1e9 {
+		unit, scale = "Gm", 1e9
+	}
+	if v := m / scale; v < 10 {
+		return fmt.Sprintf("%.1f%s", v, unit)
+	}
+	return fmt.Sprintf("%.0f%s", m/scale, unit)
+}
+
+// formatTXTLatency writes the one-way light time in seconds, e.g. 762s.
+func formatTXTLatency(seconds float64) string {
+	if seconds < 10 {
+		return fmt.Sprintf("%.1fs", seconds)
+	}
+	return fmt.Sprintf("%.0fs", seconds)
+}
+
+// txtContent is the TXT payload for b as of updated.
+func txtContent(b bodyStatus, updated time.Time) string {
+	fields := []string{
+		"latency=" + formatTXTLatency(b.Latency),
+		"distance=" + formatTXTDistance(b.Distance),
+		"visibility=" + b.Visibility,
+	}
+	if b.Visibility == "blocked" {
+		at := "unknown"
+		if b.NextVisible != nil {
+			at = b.NextVisible.UTC().Format("2006-01-02T15:04Z")
+		}
+		fields = append(fields, "clear="+at)
+	}
+	fields = append(fields, "updated="+updated.UTC().Format("2006-01-02"))
+	return strings.Join(fields, " ")
+}
+
+// buildTXTRecords pairs every body domain in objects with its status. Bodies
+// the status API doesn't list are skipped.
+func buildTXTRecords(objects []celestial.CelestialObject, status map[string]bodyStatus, updated time.Time) []txtRecord {
+	var records []txtRecord
+	for _, obj := range objects {
+		domain, ok := bodyDomain(obj)
+		if !ok {
+			continue
+		}
+		b, ok := status[strings.ToLower(obj.Name)]
+		if !ok {
+			log.Printf("No status for %s; skipping its TXT record", obj.Name)
+			continue
+		}
+		records = append(records, txtRecord{Name: domain, Content: txtContent(b, updated)})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records
+}
+
+// statusClient reads body status from a latency.space instance.
+type statusClient struct {
+	base   string // e.g. https://latency.space
+	client *http.Client
+	pause  time.Duration // between /api/distance lookups
+}
+
+func (c *statusClient) getJSON(ctx context.Context, path string, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(c.base, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Fetch returns the status of every body, keyed by lower-case name, and the
+// instant it describes. Blocked bodies get their clear time; a failed lookup
+// of one is logged and leaves it unknown.
+func (c *statusClient) Fetch(ctx context.Context) (map[string]bodyStatus, time.Time, error) {
+	var data struct {
+		ComputedAt time.Time               `json:"computedAt"`
+		Objects    map[string][]bodyStatus `json:"objects"`
+	}
+	if err := c.getJSON(ctx, "/api/status-data", &data); err != nil {
+		return nil, time.Time{}, fmt.Errorf("status lookup: %w", err)
+	}
+	status := make(map[string]bodyStatus)
+	var blocked []string
+	for _, entries := range data.Objects {
+		for _, b := range entries {
+			status[strings.ToLower(b.Name)] = b
+			if b.Visibility == "blocked" {
+				blocked = append(blocked, b.Name)
+			}
+		}
+	}
+	sort.Strings(blocked)
+
+	for i, name := range blocked {
+		if i > 0 {
+			time.Sleep(c.pause)
+		}
+		var dist struct {
+			NextVisible *time.Time `json:"nextVisible"`
+		}
+		q := url.Values{"from": {"Earth"}, "to": {name}, "t": {data.ComputedAt.UTC().Format(time.RFC3339)}}
+		if err := c.getJSON(ctx, "/api/distance?"+q.Encode(), &dist); err != nil {
+			log.Printf("Clear time for %s: %v", name, err)
+			continue
+		}
+		b := status[strings.ToLower(name)]
+		b.NextVisible = dist.NextVisible
+		status[strings.ToLower(name)] = b
+	}
+	return status, data.ComputedAt, nil
+}
+
+// txtUpdater upserts TXT records in one zone.
+type txtUpdater struct {
+	api      dnsAPI
+	zoneID   string
+	zoneName string
+	interval time.Duration // between writes
+	dryRun   bool          // log the writes instead of making them
+}
+
+// txtChanges counts what Apply did, or would have done.
+type txtChanges struct {
+	Created, Updated, Unchanged, Failed int
+}
+
+// Apply creates the records that don't exist and updates those whose
+// content differs. The zone's TXT records are listed once up front.
+func (u *txtUpdater) Apply(ctx context.Context, records []txtRecord) (txtChanges, error) {
+	var changes txtChanges
+	zone := cloudflare.ZoneIdentifier(u.zoneID)
+	existing, _, err := u.api.ListDNSRecords(ctx, zone, cloudflare.ListDNSRecordsParams{Type: "TXT"})
+	if err != nil {
+		return changes, fmt.Errorf("listing TXT records: %w", err)
+	}
+	byName := make(map[string]cloudflare.DNSRecord, len(existing))
+	for _, r := range existing {
+		// Only records in this format are ours; other TXT records at a
+		// body's name, such as verification tokens, are left alone.
+		if _, seen := byName[r.Name]; !seen && strings.Contains(r.Content, "latency=") {
+			byName[r.Name] = r
+		}
+	}
+
+	writes := 0
+	for _, rec := range records {
+		fullName := rec.Name + "." + u.zoneName
+		old, found := byName[fullName]
+		if found && strings.Trim(old.Content, `"`) == rec.Content {
+			changes.Unchanged++
+			continue
+		}
+		if u.dryRun {
+			if found {
+				log.Printf("[dry run] update TXT %s: %q -> %q", fullName, strings.Trim(old.Content, `"`), rec.Content)
+				changes.Updated++
+			} else {
+				log.Printf("[dry run] create TXT %s: %q", fullName, rec.Content)
+				changes.Created++
+			}
+			continue
+		}
+
+		if writes > 0 {
+			time.Sleep(u.interval)
+		}
+		writes++
+		if found {
+			_, err = u.api.UpdateDNSRecord(ctx, zone, cloudflare.UpdateDNSRecordParams{
+				ID:      old.ID,
+				Type:    "TXT",
+				Name:    rec.Name,
+				Content: rec.Content,
+				TTL:     1,
+			})
+		} else {
+			_, err = u.api.CreateDNSRecord(ctx, zone, cloudflare.CreateDNSRecordParams{
+				Type:    "TXT",
+				Name:    rec.Name,
+				Content: rec.Content,
+				TTL:     1,
+			})
+		}
+		switch {
+		case err != nil:
+			log.Printf(" -> Error writing TXT %s: %v", fullName, err)
+			changes.Failed++
+		case found:
+			log.Printf(" -> Updated TXT %s: %s", fullName, rec.Content)
+			changes.Updated++
+		default:
+			log.Printf(" -> Created TXT %s: %s", fullName, rec.Content)
+			changes.Created++
+		}
+	}
+	return changes, nil
+}
+
+// updateStatusTXT fetches the current status and upserts every body's TXT
+// record.
+func updateStatusTXT(ctx context.Context, objects []celestial.CelestialObject, status *statusClient, u *txtUpdater) error {
+	log.Printf("Fetching body status from %s...", status.base)
+	bodies, computedAt, err := status.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	records := buildTXTRecords(objects, bodies, computedAt)
+	log.Printf("Publishing status TXT records for %d bodies in zone '%s'", len(records), u.zoneName)
+	changes, err := u.Apply(ctx, records)
+	if err != nil {
+		return err
+	}
+	log.Printf("TXT records: %d created, %d updated, %d unchanged, %d failed",
+		changes.Created, changes.Updated, changes.Unchanged, changes.Failed)
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/latency-space/shared/celestial"
+)
+
+// fakeDNS is a dnsAPI holding one zone's records in memory.
+type fakeDNS struct {
+	records []cloudflare.DNSRecord
+	lists   int
+	created []cloudflare.CreateDNSRecordParams
+	updated []cloudflare.UpdateDNSRecordParams
+}
+
+func (f *fakeDNS) ListDNSRecords(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.ListDNSRecordsParams) ([]cloudflare.DNSRecord, *cloudflare.ResultInfo, error) {
+	f.lists++
+	var out []cloudflare.DNSRecord
+	for _, r := range f.records {
+		if (params.Type == "" || r.Type == params.Type) && (params.Name == "" || r.Name == params.Name) {
+			out = append(out, r)
+		}
+	}
+	return out, &cloudflare.ResultInfo{Count: len(out)}, nil
+}
+
+func (f *fakeDNS) CreateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.CreateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	f.created = append(f.created, params)
+	return cloudflare.DNSRecord{Type: params.Type, Name: params.Name, Content: params.Content}, nil
+}
+
+func (f *fakeDNS) UpdateDNSRecord(ctx context.Context, rc *cloudflare.ResourceContainer, params cloudflare.UpdateDNSRecordParams) (cloudflare.DNSRecord, error) {
+	f.updated = append(f.updated, params)
+	return cloudflare.DNSRecord{ID: params.ID, Type: params.Type, Name: params.Name, Content: params.Content}, nil
+}
+
+func TestTXTContent(t *testing.T) {
+	updated := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	clearAt := time.Date(2025, 7, 9, 6, 10, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		status bodyStatus
+		want   string
+	}{
+		{bodyStatus{Name: "Mars", Distance: 137e6, Latency: 762.3, Visibility: "clear"},
+			"latency=762s distance=137Gm visibility=clear updated=2025-06-01"},
+		{bodyStatus{Name: "Moon", Distance: 384400, Latency: 1.28, Visibility: "clear"},
+			"latency=1.3s distance=384Mm visibility=clear updated=2025-06-01"},
+		{bodyStatus{Name: "Voyager 1", Distance: 2.49e10, Latency: 83000, Visibility: "degraded"},
+			"latency=83000s distance=25Tm visibility=degraded updated=2025-06-01"},
+		{bodyStatus{Name: "Jupiter", Distance: 9.1e8, Latency: 3035, Visibility: "blocked", NextVisible: &clearAt},
+			"latency=3035s distance=910Gm visibility=blocked clear=2025-07-09T06:10Z updated=2025-06-01"},
+		{bodyStatus{Name: "Saturn", Distance: 1.5e9, Latency: 5003, Visibility: "blocked"},
+			"latency=5003s distance=1.5Tm visibility=blocked clear=unknown updated=2025-06-01"},
+	} {
+		if got := txtContent(tc.status, updated); got != tc.want {
+			t.Errorf("%s:\n got %q\nwant %q", tc.status.Name, got, tc.want)
+		}
+	}
+}
+
+// TestTXTUpdaterCreatesAndUpdates checks that records are created where
+// missing, updated where stale, left alone where current, and that other
+// TXT records at a body's name are not taken for ours.
+func TestTXTUpdaterCreatesAndUpdates(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	objects := celestial.InitSolarSystemObjects()
+	updated := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	status := map[string]bodyStatus{
+		"mars":   {Name: "Mars", Distance: 137e6, Latency: 762, Visibility: "clear"},
+		"venus":  {Name: "Venus", Distance: 1.2e8, Latency: 400, Visibility: "clear"},
+		"phobos": {Name: "Phobos", Distance: 137e6, Latency: 762, Visibility: "clear"},
+		"earth":  {Name: "Earth", Visibility: "clear"},
+	}
+	records := buildTXTRecords(objects, status, updated)
+	var names []string
+	for _, r := range records {
+		names = append(names, r.Name)
+	}
+	if got := len(records); got != 3 || names[0] != "mars" || names[1] != "phobos.mars" || names[2] != "venus" {
+		t.Fatalf("records for %v, want mars, phobos.mars and venus", names)
+	}
+
+	fake := &fakeDNS{records: []cloudflare.DNSRecord{
+		{ID: "a1", Type: "A", Name: "venus.latency.space", Content: "192.0.2.1"},
+		{ID: "t1", Type: "TXT", Name: "mars.latency.space", Content: `"latency=700s distance=126Gm visibility=clear updated=2025-05-01"`},
+		{ID: "t2", Type: "TXT", Name: "phobos.mars.latency.space", Content: records[1].Content},
+		{ID: "t3", Type: "TXT", Name: "venus.latency.space", Content: "google-site-verification=abc"},
+	}}
+	u := &txtUpdater{api: fake, zoneID: "zone", zoneName: "latency.space"}
+
+	dry := *u
+	dry.dryRun = true
+	changes, err := dry.Apply(context.Background(), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.created)+len(fake.updated) != 0 {
+		t.Fatalf("dry run wrote %d records", len(fake.created)+len(fake.updated))
+	}
+	want := txtChanges{Created: 1, Updated: 1, Unchanged: 1}
+	if changes != want {
+		t.Errorf("dry run changes = %+v, want %+v", changes, want)
+	}
+
+	changes, err = u.Apply(context.Background(), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes != want {
+		t.Errorf("changes = %+v, want %+v", changes, want)
+	}
+	if fake.lists != 2 {
+		t.Errorf("listed the zone %d times over two runs, want once per run", fake.lists)
+	}
+	if len(fake.updated) != 1 || fake.updated[0].ID != "t1" || fake.updated[0].Name != "mars" || fake.updated[0].Content != records[0].Content {
+		t.Errorf("updates = %+v, want mars (t1) to %q", fake.updated, records[0].Content)
+	}
+	if len(fake.created) != 1 || fake.created[0].Type != "TXT" || fake.created[0].Name != "venus" || fake.created[0].Content != records[2].Content {
+		t.Errorf("creates = %+v, want a venus TXT record", fake.created)
+	}
+}
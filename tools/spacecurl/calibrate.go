@@ -0,0 +1,147 @@
+// calibrate.go - -calibrate: how much of the delay isn't simulated.
+//
+// Through the proxy a request takes the simulated light time plus the
+// client's own round trip to latency.space plus the server's handling.
+// -calibrate N pings the body host's /api/ping N times and splits each
+// round trip into the server's share (server_processing_seconds) and the
+// network's (the rest), then sets the median against the simulated round
+// trip.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// pingReply is the subset of /api/ping spacecurl reads.
+type pingReply struct {
+	ClientTimestamp   string  `json:"client_timestamp"`
+	ProcessingSeconds float64 `json:"server_processing_seconds"`
+	Body              string  `json:"body"`
+	OneWaySeconds     float64 `json:"simulated_one_way_seconds"`
+}
+
+// pingSample is one timed ping.
+type pingSample struct {
+	RTT    time.Duration // measured here, request sent to reply read
+	Server time.Duration // reported by the server
+}
+
+// Network is the part of the round trip the server didn't spend.
+func (p pingSample) Network() time.Duration {
+	if n := p.RTT - p.Server; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// calibration is the outcome of a -calibrate run.
+type calibration struct {
+	Body    string
+	Host    string
+	OneWay  time.Duration // the body's simulated one-way light time
+	Samples []pingSample
+}
+
+// ping sends one /api/ping to host and times it.
+func ping(ctx context.Context, client *http.Client, host string) (pingSample, pingReply, error) {
+	stamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+	endpoint := webScheme + "://" + host + "/api/ping?clientTimestamp=" + url.QueryEscape(stamp)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return pingSample{}, pingReply{}, err
+	}
+	sent := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return pingSample{}, pingReply{}, fmt.Errorf("ping: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	rtt := time.Since(sent)
+	if err != nil {
+		return pingSample{}, pingReply{}, fmt.Errorf("ping: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return pingSample{}, pingReply{}, fmt.Errorf("ping: %s", resp.Status)
+	}
+	var reply pingReply
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return pingSample{}, pingReply{}, fmt.Errorf("ping: %w", err)
+	}
+	if reply.ClientTimestamp != stamp {
+		return pingSample{}, pingReply{}, fmt.Errorf("ping: reply is for %q, not %q", reply.ClientTimestamp, stamp)
+	}
+	return pingSample{RTT: rtt, Server: seconds(reply.ProcessingSeconds)}, reply, nil
+}
+
+// calibrate pings obj's host n times.
+func calibrate(ctx context.Context, client *http.Client, obj celestial.CelestialObject, domain string, n int) (*calibration, error) {
+	c := &calibration{Body: obj.Name, Host: bodyHost(obj, domain)}
+	for i := 0; i < n; i++ {
+		sample, reply, err := ping(ctx, client, c.Host)
+		if err != nil {
+			return nil, err
+		}
+		c.OneWay = seconds(reply.OneWaySeconds)
+		c.Samples = append(c.Samples, sample)
+	}
+	return c, nil
+}
+
+// seconds converts a JSON seconds value to a Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// median returns the median of the samples' f.
+func (c *calibration) median(f func(pingSample) time.Duration) time.Duration {
+	ds := make([]time.Duration, len(c.Samples))
+	for i, s := range c.Samples {
+		ds[i] = f(s)
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	if len(ds) == 0 {
+		return 0
+	}
+	if len(ds)%2 == 0 {
+		return (ds[len(ds)/2-1] + ds[len(ds)/2]) / 2
+	}
+	return ds[len(ds)/2]
+}
+
+// OverheadPct is the median round trip as a percentage of the simulated
+// round trip: the error a client of this network adds to every exchange.
+func (c *calibration) OverheadPct() float64 {
+	if c.OneWay <= 0 {
+		return 0
+	}
+	return 100 * float64(c.median(func(s pingSample) time.Duration { return s.RTT })) / float64(2*c.OneWay)
+}
+
+// writeCalibration prints each ping and the breakdown of the medians.
+func writeCalibration(w io.Writer, c *calibration) {
+	row := func(label, value string) { fmt.Fprintf(w, "  %-18s %s\n", label+":", value) }
+
+	for i, s := range c.Samples {
+		fmt.Fprintf(w, "ping %d: %s = server %s + network %s\n", i+1,
+			formatDuration(s.RTT), formatDuration(s.Server), formatDuration(s.Network()))
+	}
+	fmt.Fprintf(w, "--- %s calibration, %d pings to %s\n", c.Body, len(c.Samples), c.Host)
+	row("client RTT", formatDuration(c.median(func(s pingSample) time.Duration { return s.RTT }))+"  (median)")
+	row("server", formatDuration(c.median(func(s pingSample) time.Duration { return s.Server })))
+	row("network", formatDuration(c.median(pingSample.Network)))
+	if c.OneWay > 0 {
+		row("simulated RTT", formatDuration(2*c.OneWay))
+		row("overhead", fmt.Sprintf("%.3g%% of the simulated round trip", c.OverheadPct()))
+	}
+}
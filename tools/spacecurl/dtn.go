@@ -0,0 +1,142 @@
+// dtn.go - requests through a body's store-and-forward (DTN) HTTP API.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// dtnJob is the job document returned by /dtn/send and /dtn/status/{id}.
+type dtnJob struct {
+	ID                   string     `json:"id"`
+	State                string     `json:"state"`
+	Error                string     `json:"error"`
+	Code                 string     `json:"code"`
+	NextContact          *time.Time `json:"nextContact"`
+	OneWayLatencySeconds float64    `json:"oneWayLatencySeconds"`
+	SubmittedAt          time.Time  `json:"submittedAt"`
+	ArrivesAt            time.Time  `json:"arrivesAt"`
+	EstimatedDeliveryAt  time.Time  `json:"estimatedDeliveryAt"`
+	StatusURL            string     `json:"statusUrl"`
+	Response             *struct {
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+		Body    string            `json:"body"`
+	} `json:"response"`
+}
+
+// dtnRequest submits the request to the body's host and polls the job until
+// the response has travelled back. Progress goes to progress.
+func dtnRequest(ctx context.Context, client *http.Client, sendURL, body string, o *options, progress io.Writer) (*result, []byte, error) {
+	started := time.Now()
+	payload, _ := json.Marshal(map[string]interface{}{
+		"url":     o.target,
+		"method":  o.method,
+		"headers": o.headerMap(),
+		"payload": o.data,
+	})
+	job, err := dtnCall(ctx, client, http.MethodPost, sendURL, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	if job.Code == "NO_CONTACT_WINDOW" {
+		return nil, nil, &linkDownError{Body: body, Code: job.Code, NextContact: job.NextContact}
+	}
+	if job.ID == "" {
+		return nil, nil, fmt.Errorf("DTN send: %s", job.Error)
+	}
+
+	statusURL, err := url.Parse(sendURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	statusURL, err = statusURL.Parse(job.StatusURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	oneWay := time.Duration(job.OneWayLatencySeconds * float64(time.Second))
+	fmt.Fprintf(progress, "job %s %s, delivery expected at %s\n", job.ID, job.State,
+		job.EstimatedDeliveryAt.UTC().Format(time.RFC3339))
+
+	// Poll a few times per leg, but not more than twice a second or less
+	// than twice a minute.
+	interval := oneWay / 4
+	if o.poll > 0 {
+		interval = o.poll
+	} else if interval < 500*time.Millisecond {
+		interval = 500 * time.Millisecond
+	} else if interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+	state := job.State
+	for job.Response == nil && job.State != "failed" {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		if job, err = dtnCall(ctx, client, http.MethodGet, statusURL.String(), nil); err != nil {
+			return nil, nil, err
+		}
+		if job.ID == "" {
+			return nil, nil, fmt.Errorf("DTN status: %s", job.Error)
+		}
+		if job.State != state {
+			state = job.State
+			fmt.Fprintf(progress, "job %s %s\n", job.ID, state)
+		}
+	}
+	if job.State == "failed" {
+		return nil, nil, fmt.Errorf("DTN delivery failed: %s", job.Error)
+	}
+
+	r := &result{
+		Body:        body,
+		Via:         "http",
+		Endpoint:    sendURL,
+		StatusCode:  job.Response.Status,
+		Header:      make(http.Header),
+		Bytes:       int64(len(job.Response.Body)),
+		JobID:       job.ID,
+		OneWay:      oneWay,
+		SubmittedAt: job.SubmittedAt,
+		ArrivesAt:   job.ArrivesAt,
+		DeliveryAt:  job.EstimatedDeliveryAt,
+		Total:       time.Since(started),
+	}
+	for k, v := range job.Response.Headers {
+		r.Header.Set(k, v)
+	}
+	return r, []byte(job.Response.Body), nil
+}
+
+// dtnCall performs one DTN API call. Error documents (4xx/5xx with a JSON
+// body) are returned as jobs so the caller can read their code.
+func dtnCall(ctx context.Context, client *http.Client, method, u string, payload []byte) (*dtnJob, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var job dtnJob
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, fmt.Errorf("%s %s: %s (%v)", method, u, resp.Status, err)
+	}
+	if job.Error == "" && resp.StatusCode >= 400 {
+		job.Error = resp.Status
+	}
+	return &job, nil
+}
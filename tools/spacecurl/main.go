@@ -0,0 +1,289 @@
+// spacecurl makes one HTTP request through latency.space and reports where
+// the time went.
+//
+//	spacecurl -body mars -X GET https://example.com/
+//	spacecurl -body voyager-1 -via http https://example.com/
+//	spacecurl -body mars -trace https://example.com/
+//	spacecurl -body moon -calibrate 10
+//
+// A plain curl through the proxy reports one total that mixes both light-time
+// legs with the upstream's own response time. spacecurl first reads the
+// body's link state from /api/status-data, then sends the request and prints
+// the response body on stdout and a timing breakdown on stderr.
+//
+// -via socks (the default) tunnels through the body's dedicated SOCKS5 port;
+// every byte is delayed one-way in each direction, so the RTT measured from
+// request to first byte is two light-times plus the upstream. -via http
+// submits the request to the body's subdomain store-and-forward API
+// (https://<body>.latency.space/dtn/send) and polls until the response has
+// travelled back, which is the only way to reach bodies hours away.
+//
+// -trace first prints the hops the request would take, from the body
+// host's /api/traceroute, on stderr.
+//
+// -calibrate N sends no request: it pings the body host's /api/ping N times
+// and prints how much of each round trip was the server and how much the
+// network, against the simulated round trip (calibrate.go).
+//
+// Exit status: 0 when a response arrived (whatever its HTTP status), 1 on
+// other errors, 2 on bad usage, and 3 when the link is down (the body is
+// occluded or outside its DSN contact window).
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitLinkDown = 3
+)
+
+// webScheme is the scheme for latency.space web requests; tests use "http".
+var webScheme = "https"
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string     { return strings.Join(*h, ", ") }
+func (h *headerFlags) Set(v string) error { *h = append(*h, v); return nil }
+
+// options are the parsed command line.
+type options struct {
+	body      string
+	via       string
+	method    string
+	data      string
+	headers   headerFlags
+	include   bool
+	quiet     bool
+	trace     bool
+	calibrate int
+	domain    string
+	socks     string
+	connectTo string
+	poll      time.Duration
+	timeout   time.Duration
+	target    string
+}
+
+// headerMap returns the -H flags as a map, the shape /dtn/send takes.
+func (o *options) headerMap() map[string]string {
+	m := make(map[string]string)
+	for _, h := range o.headers {
+		if k, v, ok := strings.Cut(h, ":"); ok {
+			m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return m
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run is main without the process: it returns the exit status.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	o := &options{}
+	fs := flag.NewFlagSet("spacecurl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&o.body, "body", "", "Celestial body to route through, by name or slug (e.g. mars, voyager-1)")
+	fs.StringVar(&o.via, "via", "socks", "Path: socks (the body's SOCKS5 port) or http (the body subdomain's store-and-forward API)")
+	fs.StringVar(&o.method, "X", http.MethodGet, "HTTP method")
+	fs.StringVar(&o.data, "d", "", "Request body")
+	fs.Var(&o.headers, "H", "Request header \"Name: value\" (repeatable)")
+	fs.BoolVar(&o.include, "i", false, "Print the response status and headers before the body")
+	fs.BoolVar(&o.quiet, "q", false, "Don't print the link state and timing breakdown")
+	fs.BoolVar(&o.trace, "trace", false, "Print the simulated hops to the target before the request")
+	fs.IntVar(&o.calibrate, "calibrate", 0, "Instead of a request, ping the body host's /api/ping this many times and print the non-simulated part of the round trip")
+	fs.StringVar(&o.domain, "domain", "latency.space", "latency.space deployment to use")
+	fs.StringVar(&o.socks, "socks", "", "SOCKS5 proxy host:port (default: the body's dedicated port on -domain)")
+	fs.StringVar(&o.connectTo, "connect-to", "", "Dial this host:port for requests to -domain and its subdomains (like curl --connect-to)")
+	fs.DurationVar(&o.poll, "poll", 0, "DTN status poll interval (default: a quarter of the one-way latency, 0.5s-30s)")
+	fs.DurationVar(&o.timeout, "timeout", 0, "Give up after this long (default: no limit; Voyager takes days)")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: spacecurl -body <body> [flags] <url>")
+		fmt.Fprintln(stderr, "       spacecurl -body <body> -calibrate <pings>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	wantArgs := 1
+	if o.calibrate > 0 {
+		wantArgs = 0
+	}
+	if o.body == "" || o.calibrate < 0 || fs.NArg() != wantArgs || (o.via != "socks" && o.via != "http") {
+		fs.Usage()
+		return exitUsage
+	}
+	o.target = fs.Arg(0)
+	o.method = strings.ToUpper(o.method)
+
+	obj, ok := findBody(o.body)
+	if !ok {
+		fmt.Fprintf(stderr, "spacecurl: unknown body %q\n", o.body)
+		return exitUsage
+	}
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	if o.calibrate > 0 {
+		c, err := calibrate(ctx, o.webClient(), obj, o.domain, o.calibrate)
+		if err != nil {
+			fmt.Fprintf(stderr, "spacecurl: %v\n", err)
+			return exitError
+		}
+		writeCalibration(stdout, c)
+		return exitOK
+	}
+	var proxy string
+	if o.via == "socks" {
+		var err error
+		if proxy, err = socksAddr(obj, o.domain, o.socks); err != nil {
+			fmt.Fprintf(stderr, "spacecurl: %v\n", err)
+			return exitUsage
+		}
+	}
+	web := o.webClient()
+
+	if o.trace {
+		if err := fetchTrace(ctx, web, obj, o.domain, o.target, stderr); err != nil {
+			fmt.Fprintf(stderr, "spacecurl: %v\n", err)
+		}
+	}
+
+	st, statusErr := fetchStatus(ctx, web, o.domain, obj.Name)
+
+	var r *result
+	var respBody []byte
+	var err error
+	switch o.via {
+	case "socks":
+		r, respBody, err = socksRequest(ctx, proxy, obj.Name, o)
+		if isLinkDown(err) {
+			err = linkDownFromStatus(obj.Name, st)
+		}
+	case "http":
+		progress := stderr
+		if o.quiet {
+			progress = io.Discard
+		}
+		r, respBody, err = dtnRequest(ctx, web, dtnSendURL(obj, o.domain), obj.Name, o, progress)
+	}
+
+	var down *linkDownError
+	switch {
+	case errors.As(err, &down):
+		fmt.Fprintf(stderr, "spacecurl: %v\n", down)
+		return exitLinkDown
+	case err != nil:
+		fmt.Fprintf(stderr, "spacecurl: %v\n", err)
+		return exitError
+	}
+
+	r.Status, r.StatusErr = st, statusErr
+	if o.include {
+		writeHeaders(stdout, r)
+	}
+	stdout.Write(respBody)
+	if !o.quiet {
+		writeReport(stderr, r)
+	}
+	return exitOK
+}
+
+// webClient is the client for latency.space's own web endpoints, honouring
+// -connect-to. Redirects are not followed, so the DTN API answers directly.
+func (o *options) webClient() *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if o.connectTo != "" {
+		var d net.Dialer
+		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, _ := net.SplitHostPort(addr)
+			if host == o.domain || strings.HasSuffix(host, "."+o.domain) {
+				addr = o.connectTo
+			}
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return &http.Client{
+		Transport:     tr,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// socksRequest sends the request through the SOCKS5 proxy on a fresh tunnel
+// and times each phase.
+func socksRequest(ctx context.Context, proxy, body string, o *options) (*result, []byte, error) {
+	r := &result{Body: body, Via: "socks", Endpoint: proxy}
+	var tunnelStart, tlsStart, wrote, firstByte time.Time
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			tunnelStart = time.Now()
+			conn, err := socksDial(ctx, proxy, addr)
+			r.TunnelSetup = time.Since(tunnelStart)
+			return conn, err
+		},
+		DisableKeepAlives: true,
+		// HTTP/1.1 keeps the timing per request simple to read.
+		TLSNextProto: map[string]func(string, *tls.Conn) http.RoundTripper{},
+	}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{
+		Transport:     tr,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	var reqBody io.Reader
+	if o.data != "" {
+		reqBody = strings.NewReader(o.data)
+	}
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { r.TLS = time.Since(tlsStart) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), o.method, o.target, reqBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range o.headerMap() {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.StatusCode = resp.StatusCode
+	r.Header = resp.Header
+	r.Bytes = int64(len(respBody))
+	r.RTT = firstByte.Sub(wrote)
+	r.Total = time.Since(tunnelStart)
+	return r, respBody, nil
+}
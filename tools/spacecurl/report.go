@@ -0,0 +1,162 @@
+// report.go - the timing breakdown printed after a request.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// result is what one spacecurl run observed.
+type result struct {
+	Body     string // celestial body name
+	Via      string // "socks" or "http"
+	Endpoint string // proxy address or DTN URL
+
+	Status    *bodyStatus // pre-flight link state; nil if the lookup failed
+	StatusErr error
+
+	StatusCode int
+	Header     http.Header
+	Bytes      int64
+
+	// SOCKS: measured on the client.
+	TunnelSetup time.Duration // greeting to CONNECT reply
+	TLS         time.Duration // handshake through the tunnel, https only
+	RTT         time.Duration // request written to first response byte
+
+	// HTTP (DTN): reported by the server.
+	JobID       string
+	OneWay      time.Duration
+	SubmittedAt time.Time
+	ArrivesAt   time.Time
+	DeliveryAt  time.Time
+
+	Total time.Duration
+}
+
+// linkDownError is a request refused because the body can't be reached.
+type linkDownError struct {
+	Body        string
+	Code        string // OCCLUDED or NO_CONTACT_WINDOW
+	NextContact *time.Time
+}
+
+func (e *linkDownError) Error() string {
+	switch e.Code {
+	case "OCCLUDED":
+		return fmt.Sprintf("%s is occluded: there is no line of sight from Earth right now. "+
+			"Try another body, or wait until it clears (see https://latency.space/).", e.Body)
+	case "NO_CONTACT_WINDOW":
+		msg := fmt.Sprintf("%s is outside its Deep Space Network contact window: nothing can be uplinked until the next pass", e.Body)
+		if e.NextContact != nil {
+			msg += fmt.Sprintf(", which opens at %s (in %s)", e.NextContact.UTC().Format(time.RFC3339),
+				formatDuration(time.Until(*e.NextContact)))
+		}
+		return msg + "."
+	}
+	return fmt.Sprintf("the proxy reports the link to %s as down, or the destination as unreachable", e.Body)
+}
+
+// linkDownFromStatus classifies a refused connection using the pre-flight
+// status: the SOCKS reply is the same for occlusion and a missed pass.
+func linkDownFromStatus(body string, st *bodyStatus) *linkDownError {
+	e := &linkDownError{Body: body}
+	switch {
+	case st == nil:
+	case st.NoContact:
+		e.Code = "NO_CONTACT_WINDOW"
+		e.NextContact = st.NextPass
+	case st.Occluded:
+		e.Code = "OCCLUDED"
+	}
+	return e
+}
+
+// formatDuration rounds d to a precision that reads well at its scale.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "-" + formatDuration(-d)
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(10 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// writeHeaders prints the response status and headers, as curl -i does.
+func writeHeaders(w io.Writer, r *result) {
+	fmt.Fprintf(w, "HTTP %d %s\n", r.StatusCode, http.StatusText(r.StatusCode))
+	keys := make([]string, 0, len(r.Header))
+	for k := range r.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s: %s\n", k, strings.Join(r.Header[k], ", "))
+	}
+	fmt.Fprintln(w)
+}
+
+// writeReport prints the link state and timing breakdown for r.
+func writeReport(w io.Writer, r *result) {
+	row := func(label, value string) { fmt.Fprintf(w, "  %-18s %s\n", label+":", value) }
+
+	fmt.Fprintf(w, "--- %s via %s (%s)\n", r.Body, r.Via, r.Endpoint)
+	var oneWay time.Duration
+	switch {
+	case r.Status != nil:
+		oneWay = r.Status.OneWay()
+		link := "up"
+		if r.Status.Occluded {
+			link = "occluded"
+		} else if r.Status.NoContact {
+			link = "no contact window"
+		} else if r.Status.Visibility == "degraded" {
+			link = "up, degraded"
+		}
+		if r.Status.OcclusionFresh {
+			link += " (solved now)"
+		} else if !r.Status.ComputedAt.IsZero() {
+			link += " (as of " + r.Status.ComputedAt.UTC().Format("15:04:05Z") + ")"
+		}
+		row("link", link)
+		row("distance", fmt.Sprintf("%.0f km", r.Status.Distance))
+		row("expected one-way", formatDuration(oneWay))
+		row("expected RTT", formatDuration(2*oneWay))
+	case r.StatusErr != nil:
+		row("link", "unknown: "+r.StatusErr.Error())
+	}
+
+	switch r.Via {
+	case "socks":
+		row("tunnel setup", formatDuration(r.TunnelSetup)+"  (one-way + upstream connect)")
+		if r.TLS > 0 {
+			row("TLS handshake", formatDuration(r.TLS))
+		}
+		row("applied RTT", formatDuration(r.RTT)+"  (request sent to first byte)")
+		if r.Status != nil {
+			// Each direction is delayed by one-way; the rest is the upstream.
+			upstream := r.RTT - 2*oneWay
+			if upstream < 0 {
+				upstream = 0
+			}
+			row("upstream time", "~"+formatDuration(upstream))
+		}
+	case "http":
+		row("job", r.JobID)
+		row("applied one-way", formatDuration(r.OneWay))
+		row("submitted", r.SubmittedAt.UTC().Format(time.RFC3339))
+		row("arrived", r.ArrivesAt.UTC().Format(time.RFC3339))
+		row("delivered", r.DeliveryAt.UTC().Format(time.RFC3339))
+	}
+	row("total", formatDuration(r.Total))
+	row("bytes", fmt.Sprint(r.Bytes))
+}
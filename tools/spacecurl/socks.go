@@ -0,0 +1,134 @@
+// socks.go - a minimal SOCKS5 CONNECT client.
+//
+// net/http can dial through socks5:// itself, but it folds the reply code
+// into an error string. spacecurl needs the code to tell a link that is down
+// (host unreachable) from an upstream failure, so it speaks the handshake
+// directly. Hostnames are always sent to the proxy, which rejects literal IPs.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	socksVersion         = 0x05
+	socksNoAuth          = 0x00
+	socksCmdConnect      = 0x01
+	socksAddrDomain      = 0x03
+	socksRepSuccess      = 0x00
+	socksRepNotAllowed   = 0x02
+	socksRepHostUnreach  = 0x04
+	socksRepConnRefused  = 0x05
+	socksRepGeneralError = 0x01
+)
+
+// socksReplyError is a CONNECT refused by the proxy.
+type socksReplyError struct {
+	Code byte
+}
+
+func (e *socksReplyError) Error() string {
+	switch e.Code {
+	case socksRepGeneralError:
+		return "SOCKS proxy: general failure"
+	case socksRepNotAllowed:
+		return "SOCKS proxy: destination not allowed"
+	case socksRepHostUnreach:
+		return "SOCKS proxy: host unreachable"
+	case socksRepConnRefused:
+		return "SOCKS proxy: connection refused by destination"
+	}
+	return fmt.Sprintf("SOCKS proxy: reply code %d", e.Code)
+}
+
+// socksDial connects to addr ("host:port") through the SOCKS5 proxy at proxy.
+func socksDial(ctx context.Context, proxy, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in %q", addr)
+	}
+	if len(host) > 255 {
+		return nil, fmt.Errorf("hostname too long: %q", host)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", proxy)
+	if err != nil {
+		return nil, err
+	}
+	// The CONNECT reply is held back by the body's one-way latency; let the
+	// context, not a fixed deadline, bound the wait.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	fail := func(err error) (net.Conn, error) {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte{socksVersion, 1, socksNoAuth}); err != nil {
+		return fail(err)
+	}
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return fail(fmt.Errorf("SOCKS greeting: %w", err))
+	}
+	if greeting[0] != socksVersion || greeting[1] != socksNoAuth {
+		return fail(fmt.Errorf("SOCKS greeting: unexpected reply %x", greeting))
+	}
+
+	req := []byte{socksVersion, socksCmdConnect, 0, socksAddrDomain, byte(len(host))}
+	req = append(req, host...)
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return fail(err)
+	}
+
+	// VER REP RSV ATYP, then the bound address, which is discarded.
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fail(fmt.Errorf("SOCKS connect: %w", err))
+	}
+	if reply[1] != socksRepSuccess {
+		return fail(&socksReplyError{Code: reply[1]})
+	}
+	var skip int
+	switch reply[3] {
+	case 0x01:
+		skip = 4
+	case 0x04:
+		skip = 16
+	case socksAddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return fail(err)
+		}
+		skip = int(n[0])
+	default:
+		return fail(fmt.Errorf("SOCKS connect: unknown address type %d", reply[3]))
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return fail(err)
+	}
+	return conn, nil
+}
+
+// isLinkDown reports whether err is the proxy refusing because the body is
+// occluded or outside a contact window (both reply host unreachable).
+func isLinkDown(err error) bool {
+	var re *socksReplyError
+	return errors.As(err, &re) && re.Code == socksRepHostUnreach
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyHosts(t *testing.T) {
+	cases := []struct {
+		body, host, send, socks string
+	}{
+		{"mars", "mars.latency.space", "https://mars.latency.space/dtn/send", "latency.space:1080"},
+		{"Voyager 1", "voyager-1.latency.space", "https://voyager-1.latency.space/dtn/send", "latency.space:3080"},
+		{"voyager-1", "voyager-1.latency.space", "https://voyager-1.latency.space/dtn/send", "latency.space:3080"},
+		{"Phobos", "phobos.mars.latency.space", "https://phobos.mars.latency.space/dtn/send", ""},
+		{"europa", "europa.jupiter.latency.space", "https://europa.jupiter.latency.space/dtn/send", "latency.space:2081"},
+	}
+	for _, tc := range cases {
+		obj, ok := findBody(tc.body)
+		if !ok {
+			t.Fatalf("findBody(%q) failed", tc.body)
+		}
+		if got := bodyHost(obj, "latency.space"); got != tc.host {
+			t.Errorf("bodyHost(%s) = %q, want %q", tc.body, got, tc.host)
+		}
+		if got := dtnSendURL(obj, "latency.space"); got != tc.send {
+			t.Errorf("dtnSendURL(%s) = %q, want %q", tc.body, got, tc.send)
+		}
+		got, err := socksAddr(obj, "latency.space", "")
+		if tc.socks == "" {
+			if err == nil {
+				t.Errorf("socksAddr(%s) = %q, want an error (no dedicated port)", tc.body, got)
+			}
+		} else if got != tc.socks {
+			t.Errorf("socksAddr(%s) = %q, %v, want %q", tc.body, got, err, tc.socks)
+		}
+		if got, _ := socksAddr(obj, "latency.space", "localhost:9"); got != "localhost:9" {
+			t.Errorf("socksAddr(%s) ignored the override: %q", tc.body, got)
+		}
+	}
+	if _, ok := findBody("vulcan"); ok {
+		t.Error("findBody accepted an unknown body")
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	computed := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	st := &bodyStatus{Name: "Mars", Distance: 2.5e8, Latency: 833.91, ComputedAt: computed}
+
+	var buf bytes.Buffer
+	writeReport(&buf, &result{
+		Body: "Mars", Via: "socks", Endpoint: "latency.space:1080", Status: st,
+		TunnelSetup: 834*time.Second + 120*time.Millisecond,
+		TLS:         1668*time.Second + 400*time.Millisecond,
+		RTT:         1668*time.Second + 900*time.Millisecond,
+		Total:       4171 * time.Second, Bytes: 1256,
+	})
+	want := `--- Mars via socks (latency.space:1080)
+  link:              up (as of 09:00:00Z)
+  distance:          250000000 km
+  expected one-way:  13m54s
+  expected RTT:      27m48s
+  tunnel setup:      13m54s  (one-way + upstream connect)
+  TLS handshake:     27m48s
+  applied RTT:       27m49s  (request sent to first byte)
+  upstream time:     ~1.08s
+  total:             1h9m31s
+  bytes:             1256
+`
+	if buf.String() != want {
+		t.Errorf("socks report:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	sub := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	writeReport(&buf, &result{
+		Body: "Voyager 1", Via: "http", Endpoint: "https://voyager-1.latency.space/dtn/send",
+		StatusErr: fmt.Errorf("status lookup: 502 Bad Gateway"),
+		JobID:     "abc", OneWay: 23 * time.Hour, SubmittedAt: sub,
+		ArrivesAt: sub.Add(23 * time.Hour), DeliveryAt: sub.Add(46 * time.Hour),
+		Total: 46*time.Hour + 3*time.Second, Bytes: 5,
+	})
+	want = `--- Voyager 1 via http (https://voyager-1.latency.space/dtn/send)
+  link:              unknown: status lookup: 502 Bad Gateway
+  job:               abc
+  applied one-way:   23h0m0s
+  submitted:         2026-10-16T09:00:00Z
+  arrived:           2026-10-17T08:00:00Z
+  delivered:         2026-10-18T07:00:00Z
+  total:             46h0m3s
+  bytes:             5
+`
+	if buf.String() != want {
+		t.Errorf("http report:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+// fakeLatencySpace serves canned /api/status-data and DTN responses.
+func fakeLatencySpace(t *testing.T, occluded, noContact bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/status-data":
+			fmt.Fprintf(w, `{"computedAt":"2026-10-16T09:00:00Z","objects":{"planets":[
+				{"name":"Mars","distance_km":2.5e8,"latency_seconds":0.01,"occluded":%v,"no_contact":%v,
+				 "next_dsn_pass":"2026-10-16T16:00:00Z"}]}}`, occluded, noContact)
+		case r.URL.Path == "/api/traceroute":
+			if r.Host != "mars.latency.space" || r.URL.Query().Get("target") != "example.com" || r.Header.Get("Accept") != "text/plain" {
+				t.Errorf("traceroute request: host %q, query %q, Accept %q", r.Host, r.URL.RawQuery, r.Header.Get("Accept"))
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, "traceroute to example.com via Mars, 5 hops\n")
+		case r.URL.Path == "/api/ping":
+			if r.Host != "mars.latency.space" {
+				t.Errorf("ping to host %q, want the body subdomain", r.Host)
+			}
+			fmt.Fprintf(w, `{"client_timestamp":%q,"server_received":"2026-10-16T09:00:00Z","server_responded":"2026-10-16T09:00:00.0002Z",
+				"server_processing_seconds":0.0002,"body":"Mars","simulated_one_way_seconds":0.01}`, r.URL.Query().Get("clientTimestamp"))
+		case r.URL.Path == "/dtn/send" && noContact:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"error":"Mars: outside DSN contact window","code":"NO_CONTACT_WINDOW","nextContact":"2026-10-16T16:00:00Z"}`)
+		case r.URL.Path == "/dtn/send":
+			if r.Host != "mars.latency.space" {
+				t.Errorf("DTN send to host %q, want the body subdomain", r.Host)
+			}
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprint(w, `{"id":"j1","state":"in_transit","oneWayLatencySeconds":0.01,"submittedAt":"2026-10-16T09:00:00Z",
+				"arrivesAt":"2026-10-16T09:00:00.01Z","estimatedDeliveryAt":"2026-10-16T09:00:00.02Z","statusUrl":"/dtn/status/j1"}`)
+		case r.URL.Path == "/dtn/status/j1":
+			fmt.Fprint(w, `{"id":"j1","state":"delivered","oneWayLatencySeconds":0.01,"submittedAt":"2026-10-16T09:00:00Z",
+				"arrivesAt":"2026-10-16T09:00:00.01Z","estimatedDeliveryAt":"2026-10-16T09:00:00.02Z",
+				"response":{"status":200,"headers":{"Content-Type":"text/plain"},"body":"from mars"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// fakeSOCKS accepts one CONNECT and answers with rep; on success it serves a
+// canned HTTP response through the tunnel.
+func fakeSOCKS(t *testing.T, rep byte) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.ReadFull(conn, make([]byte, 3))
+		conn.Write([]byte{socksVersion, socksNoAuth})
+		hdr := make([]byte, 5)
+		io.ReadFull(conn, hdr)
+		io.ReadFull(conn, make([]byte, int(hdr[4])+2))
+		reply := []byte{socksVersion, rep, 0, 0x01, 0, 0, 0, 0}
+		conn.Write(binary.BigEndian.AppendUint16(reply, 0))
+		if rep != socksRepSuccess {
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Host != "example.com" {
+			t.Errorf("tunnelled request: %v %v", req, err)
+			return
+		}
+		fmt.Fprint(conn, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello")
+	}()
+	return ln.Addr().String()
+}
+
+func runSpacecurl(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	orig := webScheme
+	webScheme = "http"
+	t.Cleanup(func() { webScheme = orig })
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRunSOCKS(t *testing.T) {
+	web := fakeLatencySpace(t, false, false)
+	code, out, errOut := runSpacecurl(t, "-body", "mars", "-connect-to", web.Listener.Addr().String(),
+		"-socks", fakeSOCKS(t, socksRepSuccess), "-i", "http://example.com/")
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, errOut)
+	}
+	if !strings.HasPrefix(out, "HTTP 200 OK\n") || !strings.HasSuffix(out, "\n\nhello") {
+		t.Errorf("stdout = %q", out)
+	}
+	for _, want := range []string{"--- Mars via socks", "expected one-way:  10ms", "applied RTT:", "bytes:             5"} {
+		if !strings.Contains(errOut, want) {
+			t.Errorf("report lacks %q:\n%s", want, errOut)
+		}
+	}
+}
+
+func TestRunTrace(t *testing.T) {
+	web := fakeLatencySpace(t, false, false)
+	code, _, errOut := runSpacecurl(t, "-body", "mars", "-trace", "-q", "-connect-to", web.Listener.Addr().String(),
+		"-socks", fakeSOCKS(t, socksRepSuccess), "http://example.com/")
+	if code != exitOK || errOut != "traceroute to example.com via Mars, 5 hops\n" {
+		t.Errorf("exit %d, stderr %q", code, errOut)
+	}
+}
+
+func TestRunLinkDown(t *testing.T) {
+	t.Run("occluded over socks", func(t *testing.T) {
+		web := fakeLatencySpace(t, true, false)
+		code, out, errOut := runSpacecurl(t, "-body", "mars", "-connect-to", web.Listener.Addr().String(),
+			"-socks", fakeSOCKS(t, socksRepHostUnreach), "http://example.com/")
+		if code != exitLinkDown || out != "" || !strings.Contains(errOut, "Mars is occluded") {
+			t.Errorf("exit %d, stdout %q, stderr %q", code, out, errOut)
+		}
+	})
+	t.Run("no contact window over socks", func(t *testing.T) {
+		web := fakeLatencySpace(t, false, true)
+		code, _, errOut := runSpacecurl(t, "-body", "mars", "-connect-to", web.Listener.Addr().String(),
+			"-socks", fakeSOCKS(t, socksRepHostUnreach), "http://example.com/")
+		if code != exitLinkDown || !strings.Contains(errOut, "opens at 2026-10-16T16:00:00Z") {
+			t.Errorf("exit %d, stderr %q", code, errOut)
+		}
+	})
+	t.Run("no contact window over http", func(t *testing.T) {
+		web := fakeLatencySpace(t, false, true)
+		code, _, errOut := runSpacecurl(t, "-body", "mars", "-via", "http",
+			"-connect-to", web.Listener.Addr().String(), "http://example.com/")
+		if code != exitLinkDown || !strings.Contains(errOut, "outside its Deep Space Network contact window") {
+			t.Errorf("exit %d, stderr %q", code, errOut)
+		}
+	})
+}
+
+func TestRunDTN(t *testing.T) {
+	web := fakeLatencySpace(t, false, false)
+	code, out, errOut := runSpacecurl(t, "-body", "mars", "-via", "http", "-poll", "10ms",
+		"-connect-to", web.Listener.Addr().String(), "http://example.com/")
+	if code != exitOK || out != "from mars" {
+		t.Fatalf("exit %d, stdout %q, stderr %s", code, out, errOut)
+	}
+	for _, want := range []string{"job j1 delivered", "--- Mars via http (http://mars.latency.space/dtn/send)", "applied one-way:   10ms"} {
+		if !strings.Contains(errOut, want) {
+			t.Errorf("stderr lacks %q:\n%s", want, errOut)
+		}
+	}
+}
+
+func TestCalibration(t *testing.T) {
+	c := &calibration{Body: "Moon", Host: "moon.latency.space", OneWay: 1300 * time.Millisecond, Samples: []pingSample{
+		{RTT: 60 * time.Millisecond, Server: 500 * time.Microsecond},
+		{RTT: 52 * time.Millisecond, Server: time.Millisecond},
+		{RTT: 48 * time.Millisecond, Server: 2 * time.Millisecond},
+		{RTT: time.Millisecond, Server: 2 * time.Millisecond}, // clocks disagree
+	}}
+	if n := c.Samples[1].Network(); n != 51*time.Millisecond {
+		t.Errorf("network = %v, want 51ms", n)
+	}
+	if n := c.Samples[3].Network(); n != 0 {
+		t.Errorf("network = %v, want 0 when the server claims more than the round trip", n)
+	}
+	// Median RTT 50ms against a 2.6s simulated round trip.
+	if pct := c.OverheadPct(); math.Abs(pct-100*0.05/2.6) > 1e-9 {
+		t.Errorf("overhead %v%%", pct)
+	}
+
+	var buf bytes.Buffer
+	writeCalibration(&buf, c)
+	want := `ping 1: 60ms = server 500µs + network 60ms
+ping 2: 52ms = server 1ms + network 51ms
+ping 3: 48ms = server 2ms + network 46ms
+ping 4: 1ms = server 2ms + network 0s
+--- Moon calibration, 4 pings to moon.latency.space
+  client RTT:        50ms  (median)
+  server:            2ms
+  network:           49ms
+  simulated RTT:     2.6s
+  overhead:          1.92% of the simulated round trip
+`
+	if buf.String() != want {
+		t.Errorf("calibration report:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestRunCalibrate(t *testing.T) {
+	web := fakeLatencySpace(t, false, false)
+	code, out, errOut := runSpacecurl(t, "-body", "mars", "-calibrate", "3", "-connect-to", web.Listener.Addr().String())
+	if code != exitOK {
+		t.Fatalf("exit %d: %s", code, errOut)
+	}
+	for _, want := range []string{"ping 3: ", "server 200µs", "--- Mars calibration, 3 pings to mars.latency.space", "simulated RTT:     20ms"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("stdout lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"http://example.com/"},
+		{"-body", "vulcan", "http://example.com/"},
+		{"-body", "mars", "-via", "carrier-pigeon", "http://example.com/"},
+		{"-body", "phobos", "http://example.com/"}, // no dedicated SOCKS port
+		{"-body", "mars", "-calibrate", "3", "http://example.com/"},
+		{"-body", "mars", "-calibrate", "-1"},
+	} {
+		if code, _, _ := runSpacecurl(t, args...); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// status.go - the pre-flight link check against /api/status-data.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// bodyStatus is the subset of a /api/status-data entry spacecurl reports.
+type bodyStatus struct {
+	Name           string     `json:"name"`
+	Distance       float64    `json:"distance_km"`
+	Latency        float64    `json:"latency_seconds"`
+	Occluded       bool       `json:"occluded"`
+	Visibility     string     `json:"visibility"` // clear, degraded or blocked
+	OcclusionFresh bool       `json:"occlusion_fresh"`
+	NoContact      bool       `json:"no_contact"`
+	NextPass       *time.Time `json:"next_dsn_pass"`
+	PassEnd        *time.Time `json:"dsn_pass_end"`
+
+	ComputedAt time.Time `json:"-"` // from the response envelope
+}
+
+// OneWay is the expected one-way light time.
+func (b *bodyStatus) OneWay() time.Duration {
+	return time.Duration(b.Latency * float64(time.Second))
+}
+
+// fetchStatus reads the current link state of name from the apex API.
+func fetchStatus(ctx context.Context, client *http.Client, domain, name string) (*bodyStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, webScheme+"://"+domain+"/api/status-data", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("status lookup: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status lookup: %s", resp.Status)
+	}
+	var out struct {
+		ComputedAt time.Time               `json:"computedAt"`
+		Objects    map[string][]bodyStatus `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("status lookup: %w", err)
+	}
+	for _, entries := range out.Objects {
+		for i := range entries {
+			if strings.EqualFold(entries[i].Name, name) {
+				entries[i].ComputedAt = out.ComputedAt
+				return &entries[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("status lookup: %s not in /api/status-data", name)
+}
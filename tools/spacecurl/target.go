@@ -0,0 +1,69 @@
+// target.go - mapping a body name to the latency.space hosts that serve it.
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// socksPorts is the port-per-body table from docker-compose.yml (see
+// SOCKS5_PORT_ASSIGNMENTS.md). Bodies without a deployed SOCKS service need
+// -socks.
+var socksPorts = map[string]int{
+	"Mars":      1080,
+	"Moon":      1081,
+	"Venus":     1082,
+	"Mercury":   1083,
+	"Jupiter":   1084,
+	"Saturn":    1085,
+	"Europa":    2081,
+	"Titan":     2084,
+	"Voyager 1": 3080,
+	"JWST":      3084,
+}
+
+// slug turns a body name into its hostname label ("Voyager 1" -> "voyager-1").
+func slug(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, " ", "-"))
+}
+
+// findBody looks a body up by name or slug, case-insensitively.
+func findBody(name string) (celestial.CelestialObject, bool) {
+	for _, obj := range celestial.InitSolarSystemObjects() {
+		if strings.EqualFold(obj.Name, name) || strings.EqualFold(slug(obj.Name), name) {
+			return obj, true
+		}
+	}
+	return celestial.CelestialObject{}, false
+}
+
+// bodyHost is the subdomain that names obj under domain, in the two shapes
+// the proxy resolves: body.domain, and moon.parent.domain for moons.
+func bodyHost(obj celestial.CelestialObject, domain string) string {
+	if obj.Type == "moon" && obj.ParentName != "" {
+		return slug(obj.Name) + "." + slug(obj.ParentName) + "." + domain
+	}
+	return slug(obj.Name) + "." + domain
+}
+
+// dtnSendURL is the store-and-forward endpoint on the body's own host.
+func dtnSendURL(obj celestial.CelestialObject, domain string) string {
+	return webScheme + "://" + bodyHost(obj, domain) + "/dtn/send"
+}
+
+// socksAddr is the SOCKS5 endpoint for obj: override if set, else the
+// body's dedicated port on the apex domain.
+func socksAddr(obj celestial.CelestialObject, domain, override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+	port, ok := socksPorts[obj.Name]
+	if !ok {
+		return "", fmt.Errorf("%s has no dedicated SOCKS port; pass -socks host:port or use -via dtn", obj.Name)
+	}
+	return net.JoinHostPort(domain, strconv.Itoa(port)), nil
+}
@@ -0,0 +1,37 @@
+// trace.go - the simulated hop listing printed by -trace.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/latency-space/shared/celestial"
+)
+
+// fetchTrace writes the body host's traceroute-style listing of the path to
+// target to w. Nothing is sent to target.
+func fetchTrace(ctx context.Context, client *http.Client, obj celestial.CelestialObject, domain, target string, w io.Writer) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("trace: %w", err)
+	}
+	endpoint := webScheme + "://" + bodyHost(obj, domain) + "/api/traceroute?target=" + url.QueryEscape(u.Hostname())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/plain")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("trace: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("trace: %s", resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}